@@ -0,0 +1,30 @@
+#!/bin/bash
+
+# yamap/install_browser.go の pinnedChromeVersion の chrome-headless-shell を配布元から各プラットフォーム分ダウンロードし、
+# ZIPのSHA-256で pinnedChromeSHA256 を置き換えるスクリプト。pinnedChromeVersion を変えたときに実行する
+
+set -euo pipefail
+
+file="yamap/install_browser.go"
+version=$(sed -n 's/^const pinnedChromeVersion = "\(.*\)"$/\1/p' "$file")
+if [ -z "$version" ]; then
+  echo "$file から pinnedChromeVersion を読み取れません" >&2
+  exit 1
+fi
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+entries=""
+for platform in linux64 mac-arm64 mac-x64 win64 win32; do
+  url="https://storage.googleapis.com/chrome-for-testing-public/${version}/${platform}/chrome-headless-shell-${platform}.zip"
+  echo "ダウンロードしています: $url" >&2
+  curl -fsSL -o "$tmp/$platform.zip" "$url"
+  sum=$(sha256sum "$tmp/$platform.zip" | cut -d' ' -f1)
+  entries="${entries}\t\"${platform}\": \"${sum}\",\n"
+done
+
+# 1行 (空のマップ) でも複数行でも置き換えられるよう、マップの宣言全体を書き換える
+perl -0pi -e "s/var pinnedChromeSHA256 = map\[string\]string\{.*?\n?\}/var pinnedChromeSHA256 = map[string]string{\n${entries}}/s" "$file"
+gofmt -w "$file"
+echo "$file の pinnedChromeSHA256 を chrome-headless-shell ${version} の値に更新しました" >&2
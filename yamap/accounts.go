@@ -0,0 +1,153 @@
+package yamap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// accountConfig は複数のアカウントで実行する場合の、アカウントごとの設定
+type accountConfig struct {
+	// Name はログの接頭辞と履歴ファイルの名前空間に使う識別名
+	Name string `json:"name"`
+	// Env はこのアカウントの実行で上書きする環境変数 (YAMAP_EMAIL, YAMAP_PASSWORD, CREDENTIALS_FILE など)
+	Env map[string]string `json:"env"`
+}
+
+// multiAccountActions は設定ファイルに accounts がある場合に、全アカウント分を実行するアクション
+var multiAccountActions = map[string]bool{"react-timeline": true, "react-activities": true, "collect": true, "react": true, "react-community": true, "react-bookmarks": true, "react-followers": true, "follow-search": true, "follow-commenters": true, "thank-followers": true}
+
+// applyAccount は YAMAP_ACCOUNT で指定されたアカウントの環境変数を設定する。
+// アカウントの設定に HISTORY_FILE がなければ、共通の HISTORY_FILE の名前にアカウント名を加えて履歴を分ける (history.json → history.<名前>.json)。
+// HISTORY_DATABASE_KEY も同様にアカウント名を加える (未設定の場合はアカウント名そのものがキーになる)。
+func applyAccount(name string) error {
+	for _, acc := range config.Accounts {
+		if acc.Name != name {
+			continue
+		}
+		for k, v := range acc.Env {
+			os.Setenv(k, v)
+		}
+		if path := os.Getenv("HISTORY_FILE"); path != "" && acc.Env["HISTORY_FILE"] == "" {
+			ext := filepath.Ext(path)
+			os.Setenv("HISTORY_FILE", strings.TrimSuffix(path, ext)+"."+name+ext)
+		}
+		if key := os.Getenv("HISTORY_DATABASE_KEY"); key != "" && acc.Env["HISTORY_DATABASE_KEY"] == "" {
+			os.Setenv("HISTORY_DATABASE_KEY", key+"."+name)
+		}
+		log.Printf(tr("アカウント %s の設定で実行します。"), name)
+		return nil
+	}
+	return fmt.Errorf(tr("設定ファイルにアカウント '%s' がありません"), name)
+}
+
+// runAccounts は設定ファイルの全アカウントについて、同じ引数でこのプログラムを子プロセスとして実行する。
+// プロセスを分けることで、ブラウザ・プロファイル・待機時間の調整・履歴はアカウントごとに独立する。
+// 同時に実行する数は ACCOUNTS_MAX_PARALLEL (既定値1) までに制限し、子プロセスの終了コードの最大値を返す。
+func runAccounts(accounts []accountConfig) int {
+	maxParallel := 1
+	if v := os.Getenv("ACCOUNTS_MAX_PARALLEL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			exitOnError(fmt.Errorf(tr("ACCOUNTS_MAX_PARALLELの値が不正です: %s"), v))
+		}
+		maxParallel = n
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exitOnError(fmt.Errorf(tr("実行ファイルのパスを取得できません: %w"), err))
+	}
+	log.Printf(tr("%d件のアカウントを最大 %d 件ずつ並行して実行します。"), len(accounts), maxParallel)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		outMu    sync.Mutex
+		exitCode int
+	)
+	sem := make(chan struct{}, maxParallel)
+	for _, acc := range accounts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			out := &prefixWriter{prefix: "[" + acc.Name + "] ", dst: os.Stderr, mu: &outMu}
+			cmd := exec.Command(exe, os.Args[1:]...)
+			// ヘルスチェックとpprofのポートは子プロセス同士で衝突するため、子プロセスでは起動しない
+			cmd.Env = append(os.Environ(), "YAMAP_ACCOUNT="+acc.Name, "HEALTH_ADDR=", "PPROF_ADDR=")
+			cmd.Stdout, cmd.Stderr = out, out
+			var stdout *prefixWriter
+			if outputFormat == "ndjson" {
+				// NDJSONの行は接頭辞を付けずに標準出力へ渡す (アカウントは各行の account で判別できる)
+				stdout = &prefixWriter{dst: os.Stdout, mu: &outMu}
+				cmd.Stdout = stdout
+			}
+			log.Printf(tr("アカウント %s の実行を開始します。"), acc.Name)
+			err := cmd.Run()
+			out.flush()
+			if stdout != nil {
+				stdout.flush()
+			}
+
+			code := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
+			} else if err != nil {
+				log.Printf(tr("アカウント %s の実行を開始できませんでした: %v"), acc.Name, err)
+				code = 1
+			}
+			log.Printf(tr("アカウント %s の実行が終了しました (終了コード %d)。"), acc.Name, code)
+			mu.Lock()
+			exitCode = max(exitCode, code)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return exitCode
+}
+
+// prefixWriter は子プロセスの出力を行単位で接頭辞を付けて dst (標準エラー出力など) に書き出す。
+// 並行して実行する子プロセス同士で行が混ざらないよう、書き出しは共有のロックで直列化する。
+type prefixWriter struct {
+	prefix string
+	dst    io.Writer
+	mu     *sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.buf.Next(i + 1))
+	}
+	return len(p), nil
+}
+
+// flush は改行で終わっていない残りの出力を書き出す
+func (w *prefixWriter) flush() {
+	if w.buf.Len() > 0 {
+		w.writeLine(append(w.buf.Bytes(), '\n'))
+		w.buf.Reset()
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dst.Write(append([]byte(w.prefix), line...))
+}
@@ -0,0 +1,293 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processActivities は活動一覧ページを処理してリアクションを送信する
+func processActivities(ctx context.Context, postCountToProcess int) ([]string, error) {
+	if runCheckpoints.resuming() {
+		status.setPhase("reacting")
+		return reactToActivities(ctx, nil), nil
+	}
+	activities := collectActivities(ctx, postCountToProcess)
+	loggerFromContext(ctx).Printf(tr("%d件の投稿URLを収集しました。リアクション処理を開始します。"), len(activities))
+	status.setPhase("reacting")
+	return reactToActivities(ctx, activities), nil
+}
+
+// collectActivities は活動一覧ページを巡回し、リアクション対象の投稿を収集する
+func collectActivities(ctx context.Context, postCountToProcess int) []ActivityInfo {
+	var activityURLs []ActivityInfo
+	seenURLs := make(map[string]struct{})
+	authors := newAuthorLimiter(ctx)
+	page := 1
+	consecutiveEmptyPages := 0
+
+	drv := driverFromContext(ctx)
+	loggerFromContext(ctx).Println(tr("活動一覧ページから投稿URLを収集します..."))
+	for len(activityURLs) < postCountToProcess {
+		// コンテキストがキャンセルされたかチェック
+		if ctx.Err() != nil {
+			loggerFromContext(ctx).Println(tr("URL収集中にコンテキストがキャンセルされました。"))
+			break
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("最大実行時間に達したため、URLの収集を終了します。"))
+			break
+		}
+
+		pageURL := activitySearchURL(page)
+		loggerFromContext(ctx).Printf(tr("%dページ目に移動します: %s"), page, pageURL)
+
+		var entries []struct {
+			Href     string `json:"href"`
+			UserHref string `json:"user"`
+		}
+		// ページ遷移のコンテキストにタイムアウトを設定
+		pageCtx, pageCancel := context.WithTimeout(ctx, 30*time.Second)
+		defer pageCancel()
+
+		// ページに移動し、フッターが表示されるのを待つ（フッターはどのページにもあるため）
+		err := runActions(pageCtx,
+			drv.Navigate(pageURL),
+			drv.WaitVisible(`footer[data-global-footer="true"]`),
+		)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("%dページ目への移動または待機に失敗しました: %v"), page, err)
+			// タイムアウトなどの場合、次のページの試行は無意味なのでループを抜ける
+			break
+		}
+
+		// ページに活動エントリが存在するかどうかを確認
+		err = runActions(ctx,
+			drv.Evaluate(activityEntriesScript, &entries),
+		)
+
+		// エラーが発生した場合、またはノードが見つからない場合は、ページの終端と見なす
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("%dページ目で活動エントリの取得に失敗しました。おそらく最終ページです: %v"), page, err)
+			break
+		}
+		if len(entries) == 0 {
+			loggerFromContext(ctx).Printf(tr("%dページ目には活動が見つかりませんでした。"), page)
+			consecutiveEmptyPages++
+			if consecutiveEmptyPages >= 3 {
+				loggerFromContext(ctx).Println(tr("3回連続で活動のないページに到達したため、収集を終了します。"))
+				break
+			}
+			page++
+			continue // 次のページへ
+		}
+
+		// 新しいURLが見つかったので、連続空ページカウンターをリセット
+		consecutiveEmptyPages = 0
+
+		initialCount := len(activityURLs)
+		for _, entry := range entries {
+			url := "https://yamap.com" + entry.Href
+			if _, seen := seenURLs[url]; !seen {
+				seenURLs[url] = struct{}{}
+				authorID := userIDFromPath(entry.UserHref)
+				if reason := authors.allow(User{ID: authorID}); reason != "" {
+					loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) の投稿をスキップします (%s): %s"), authorID, reason, url)
+					continue
+				}
+				info := ActivityInfo{URL: url, AuthorID: authorID}
+				if !hooksFromContext(ctx).collected(ctx, info) {
+					continue
+				}
+				activityURLs = append(activityURLs, info)
+				loggerFromContext(ctx).Printf(tr("投稿URLを発見: %s (現在 %d 件)"), url, len(activityURLs))
+				events.publish("collected", url, "", "")
+				status.markStep()
+				if len(activityURLs) >= postCountToProcess {
+					goto collected // 目標件数に達したので収集ループを抜ける
+				}
+			}
+		}
+
+		// このページで新しいURLが一つも見つからなかった場合
+		if len(activityURLs) == initialCount {
+			loggerFromContext(ctx).Println(tr("このページでは新しいURLが見つかりませんでした。重複ページまたは最終ページと判断し、収集を終了します。"))
+			break
+		}
+
+		page++
+		time.Sleep(2 * time.Second) // サーバーへの負荷を考慮した待機
+	}
+
+collected:
+	authors.logSummary(ctx)
+	return activityURLs
+}
+
+// communityID は -community フラグで指定されたコミュニティのID
+var communityID int64
+
+// communityEntriesScript はコミュニティのフィードや山・ランドマークの活動日記の一覧に表示されている投稿のパスと
+// 投稿者のプロフィールへのパスを取得するスクリプト
+const communityEntriesScript = `Array.from(document.querySelectorAll('main a[href^="/activities/"]')).flatMap(a => {
+	const href = (a.getAttribute("href").match(/^\/activities\/\d+/) || [""])[0];
+	if (!href) return [];
+	const entry = a.closest('article, li, [class*="Item"], [class*="Card"]') || a.parentElement;
+	const user = entry && entry.querySelector('a[href^="/users/"]');
+	return [{href: href, user: user ? user.getAttribute("href") : ""}];
+})`
+
+// runCommunityReaction は -community で指定したコミュニティのフィードの最近の投稿にリアクションを送る
+func runCommunityReaction() error {
+	log.Println(tr("--- プログラム開始 (react-community) ---"))
+	startTime := time.Now()
+
+	if communityID <= 0 {
+		return errors.New(tr("react-community では -community にコミュニティのIDを指定してください"))
+	}
+	postCount := 20
+	if v := os.Getenv("COMMUNITY_POST_COUNT_TO_PROCESS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("COMMUNITY_POST_COUNT_TO_PROCESSの値が不正です: %s"), v)
+		}
+		postCount = n
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	var activities []ActivityInfo
+	if !runCheckpoints.resuming() {
+		activities, err = collectCommunity(ctx, communityID, postCount)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("コミュニティのフィードの収集中にエラーが発生しました: %v"), err)
+		}
+		loggerFromContext(ctx).Printf(tr("%d件の投稿を収集しました。リアクション処理を開始します。"), len(activities))
+	}
+	status.setPhase("reacting")
+	reactedURLs := reactToActivities(ctx, activities)
+	if ctx.Err() == nil && status.result().StoppedBy == "" {
+		feedMarkers.commit(ctx)
+	}
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// collectCommunity はコミュニティのフィードをスクロールし、リアクション対象の投稿を収集する。
+// 履歴でリアクション済みの投稿は除き、投稿者ごとの上限は他のアクションと同じく適用する
+func collectCommunity(ctx context.Context, id int64, postCountToProcess int) ([]ActivityInfo, error) {
+	drv := driverFromContext(ctx)
+	url := fmt.Sprintf("https://yamap.com/communities/%d", id)
+	loggerFromContext(ctx).Printf(tr("コミュニティのフィードから投稿URLを収集します: %s"), url)
+	if err := runActions(ctx, drv.Navigate(url), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+		return nil, fmt.Errorf(tr("コミュニティのページの読み込みに失敗: %w"), err)
+	}
+	return collectListedActivities(ctx, postCountToProcess, fmt.Sprintf("community:%d", id))
+}
+
+// collectListedActivities は表示中の活動日記の一覧 (コミュニティのフィード・ブックマークなど) をスクロールし、
+// リアクション対象の投稿を最大 postCountToProcess 件収集する。一覧の読み取りには communityEntriesScript を使う。
+// feed を指定した場合は新しい順の一覧として扱い、前回までに確認した活動日記 (feedMarkers) に達した時点で収集を終える
+func collectListedActivities(ctx context.Context, postCountToProcess int, feed string) ([]ActivityInfo, error) {
+	drv := driverFromContext(ctx)
+	var activities []ActivityInfo
+	seenURLs := make(map[string]struct{})
+	authors := newAuthorLimiter(ctx)
+	var marker, newestID int64
+	if feed != "" {
+		marker = feedMarkers.newest(ctx, feed)
+	}
+	markerReached := false
+	for noNew := 0; len(activities) < postCountToProcess && noNew < 3; {
+		if ctx.Err() != nil {
+			return activities, ctx.Err()
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("最大実行時間に達したため、URLの収集を終了します。"))
+			break
+		}
+		var entries []struct {
+			Href     string `json:"href"`
+			UserHref string `json:"user"`
+		}
+		if err := runActions(ctx, drv.Evaluate(communityEntriesScript, &entries)); err != nil {
+			return activities, err
+		}
+		before := len(activities)
+		for _, entry := range entries {
+			url := "https://yamap.com" + entry.Href
+			if _, seen := seenURLs[url]; seen {
+				continue
+			}
+			seenURLs[url] = struct{}{}
+			if id, err := strconv.ParseInt(strings.TrimPrefix(entry.Href, "/activities/"), 10, 64); err == nil {
+				newestID = max(newestID, id)
+				if marker > 0 && id <= marker {
+					markerReached = true
+				}
+			}
+			if history.hasReacted(url) {
+				loggerFromContext(ctx).Printf(tr("履歴でリアクション済みのためスキップします: %s"), url)
+				continue
+			}
+			authorID := userIDFromPath(entry.UserHref)
+			if reason := authors.allow(User{ID: authorID}); reason != "" {
+				loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) の投稿をスキップします (%s): %s"), authorID, reason, url)
+				continue
+			}
+			info := ActivityInfo{URL: url, AuthorID: authorID}
+			if !hooksFromContext(ctx).collected(ctx, info) {
+				continue
+			}
+			activities = append(activities, info)
+			loggerFromContext(ctx).Printf(tr("投稿URLを発見: %s (現在 %d 件)"), url, len(activities))
+			events.publish("collected", url, "", "")
+			status.markStep()
+			if len(activities) >= postCountToProcess {
+				break
+			}
+		}
+		if len(activities) == before {
+			noNew++
+		} else {
+			noNew = 0
+		}
+		if len(activities) >= postCountToProcess {
+			break
+		}
+		if markerReached {
+			loggerFromContext(ctx).Printf(tr("前回までに確認した投稿 (ID: %d) に達したため、スクロールを終了します。"), marker)
+			break
+		}
+		if err := runActions(ctx, scrollForMore(drv, "("+communityEntriesScript+").length")); err != nil {
+			return activities, err
+		}
+	}
+	authors.logSummary(ctx)
+	if feed != "" && len(activities) < postCountToProcess && !maxRuntimeReached() {
+		feedMarkers.stage(ctx, feed, newestID)
+	}
+	return activities, nil
+}
@@ -0,0 +1,234 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// activityRefPattern は活動日記のIDまたはURLに一致する正規表現
+var activityRefPattern = regexp.MustCompile(`^(?:https://yamap\.com/activities/)?(\d+)/?$`)
+
+// activityIDFromRef は活動日記のIDまたはURLからIDを取り出す。どちらでもない場合は空文字
+func activityIDFromRef(ref string) string {
+	m := activityRefPattern.FindStringSubmatch(strings.TrimSpace(ref))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// activityEditsPath は -edits フラグで指定された、activity-edit の対象と適用するテンプレートを記述したファイルのパス
+var activityEditsPath string
+
+// activityEditsDryRun は -dry-run フラグの値。activity-edit で変更後のタイトルと本文をログに出すだけで保存しない
+var activityEditsDryRun bool
+
+// activityEdits は activity-edit で読み込むファイルの内容。Title・Description は全体のテンプレートで、
+// 活動日記ごとに指定したテンプレートがあればそちらを使う。空のテンプレートの項目は変更しない
+type activityEdits struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description"`
+	Activities  []activityEdit `json:"activities"`
+}
+
+// activityEdit は activity-edit で編集する活動日記1件
+type activityEdit struct {
+	// Activity は活動日記のIDまたはURL
+	Activity    string `json:"activity"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+}
+
+// activityEditData はタイトルと本文のテンプレートで参照できる値。編集ページに入力されている現在の値
+type activityEditData struct {
+	Title       string
+	Description string
+}
+
+// activityEditFieldsScript は活動日記の編集ページのタイトルと本文の現在の値を返すスクリプト。見つからない項目は null
+const activityEditFieldsScript = `(() => {` + planFormHelpers + `
+	const title = __planField(` + "`" + uploadTitleLabel + "`" + `);
+	const description = __planField(` + "`" + uploadDescriptionLabel + "`" + `);
+	return {title: title ? title.value : null, description: description ? description.value : null};
+})()`
+
+// runActivityEdit は -edits のファイルに記述した自分の活動日記のタイトルと本文を、テンプレートから作った値に書き換える。
+// -dry-run の場合は変更前後の値をログに出すだけで保存しない
+func runActivityEdit() error {
+	log.Println(tr("--- プログラム開始 (activity-edit) ---"))
+	startTime := time.Now()
+	data, err := os.ReadFile(activityEditsPath)
+	if err != nil {
+		return fmt.Errorf(tr("%s の読み込みに失敗しました: %w"), activityEditsPath, err)
+	}
+	var edits activityEdits
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&edits); err != nil {
+		return fmt.Errorf(tr("%s の形式が不正です: %w"), activityEditsPath, err)
+	}
+	type plannedEdit struct {
+		id                 string
+		title, description *template.Template
+	}
+	parse := func(name, text, fallback string) (*template.Template, error) {
+		if text == "" {
+			text = fallback
+		}
+		if text == "" {
+			return nil, nil
+		}
+		// contains は同じ文字列を重ねて追記しないよう、現在の値に含まれているかを確かめるために使う
+		return template.New(name).Option("missingkey=error").Funcs(template.FuncMap{"contains": strings.Contains}).Parse(text)
+	}
+	var planned []plannedEdit
+	for i, e := range edits.Activities {
+		id := activityIDFromRef(e.Activity)
+		if id == "" {
+			return fmt.Errorf(tr("activities[%d] の activity には活動日記のIDかURLを指定してください: %s"), i, e.Activity)
+		}
+		p := plannedEdit{id: id}
+		if p.title, err = parse(fmt.Sprintf("activities[%d].title", i), e.Title, edits.Title); err == nil {
+			p.description, err = parse(fmt.Sprintf("activities[%d].description", i), e.Description, edits.Description)
+		}
+		if err != nil {
+			return fmt.Errorf(tr("%s のテンプレートの解析に失敗しました: %w"), activityEditsPath, err)
+		}
+		if p.title == nil && p.description == nil {
+			return fmt.Errorf(tr("activities[%d] に適用するタイトルと本文のテンプレートがありません"), i)
+		}
+		planned = append(planned, p)
+	}
+	if len(planned) == 0 {
+		log.Printf(tr("%s に編集する活動日記がないため、何もせずに終了します。"), activityEditsPath)
+		return nil
+	}
+	if activityEditsDryRun {
+		log.Println(tr("-dry-run のため、変更後の値を表示するだけで保存しません。"))
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	drv := driverFromContext(ctx)
+
+	edited, unchanged, failed := 0, 0, 0
+	for i, p := range planned {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		url := "https://yamap.com/activities/" + p.id
+		loggerFromContext(ctx).Printf(tr("活動日記を編集します (%d/%d): %s"), i+1, len(planned), url)
+		changed, err := editActivity(ctx, drv, p.id, p.title, p.description)
+		switch {
+		case err != nil:
+			failed++
+			status.recordResult(false, err)
+			saveDebugSnapshot(ctx, drv, "activity_edit")
+			loggerFromContext(ctx).Printf(tr("活動日記の編集に失敗しました (%s): %v"), url, err)
+		case !changed:
+			unchanged++
+			loggerFromContext(ctx).Printf(tr("変更がないためスキップします: %s"), url)
+		default:
+			edited++
+			status.recordResult(true, nil)
+		}
+		status.markStep()
+		pace.wait(ctx)
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	if activityEditsDryRun {
+		loggerFromContext(ctx).Printf(tr("--- 変更する活動日記: %d 件、変更なし: %d 件、失敗: %d 件 (-dry-run のため保存していません) ---"), edited, unchanged, failed)
+	} else {
+		loggerFromContext(ctx).Printf(tr("--- 編集した活動日記: %d 件、変更なし: %d 件、失敗: %d 件 ---"), edited, unchanged, failed)
+	}
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	if failed > 0 {
+		return fmt.Errorf(tr("%d 件の活動日記の編集に失敗しました"), failed)
+	}
+	return nil
+}
+
+// editActivity は活動日記の編集ページを開き、現在のタイトルと本文からテンプレートで新しい値を作って保存する。
+// 値が変わらない場合は保存せずに false を返す。-dry-run の場合は変更前後の値をログに出すだけで保存しない
+func editActivity(ctx context.Context, drv pageDriver, id string, titleTmpl, descriptionTmpl *template.Template) (bool, error) {
+	var current struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+	}
+	if err := runActions(ctx,
+		drv.Navigate("https://yamap.com/activities/"+id+"/edit"),
+		drv.WaitVisible(`main form`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(activityEditFieldsScript, &current),
+	); err != nil {
+		return false, err
+	}
+	data := activityEditData{}
+	if current.Title != nil {
+		data.Title = *current.Title
+	}
+	if current.Description != nil {
+		data.Description = *current.Description
+	}
+	var fields [][2]string
+	for _, f := range []struct {
+		label   string
+		tmpl    *template.Template
+		present bool
+		value   string
+	}{
+		{uploadTitleLabel, titleTmpl, current.Title != nil, data.Title},
+		{uploadDescriptionLabel, descriptionTmpl, current.Description != nil, data.Description},
+	} {
+		if f.tmpl == nil {
+			continue
+		}
+		if !f.present {
+			return false, fmt.Errorf(tr("フォームの項目が見つかりません: %s"), f.label)
+		}
+		var buf bytes.Buffer
+		if err := f.tmpl.Execute(&buf, data); err != nil {
+			return false, fmt.Errorf(tr("テンプレートの展開に失敗しました: %w"), err)
+		}
+		value := strings.TrimSpace(buf.String())
+		if value == strings.TrimSpace(f.value) {
+			continue
+		}
+		loggerFromContext(ctx).Printf("%s: %q → %q", tr(f.label), f.value, value)
+		fields = append(fields, [2]string{f.label, value})
+	}
+	if len(fields) == 0 || activityEditsDryRun {
+		return len(fields) > 0, nil
+	}
+	for _, f := range fields {
+		if err := runActions(ctx, drv.Evaluate(planFieldScript(f[0], f[1]), nil)); err != nil {
+			return false, err
+		}
+	}
+	var saved bool
+	if err := runActions(ctx, sleepAction(time.Second), drv.Evaluate(saveActivityScript, &saved)); err != nil {
+		return false, err
+	}
+	if !saved {
+		return false, errors.New(tr("活動日記の保存ボタンが見つかりません"))
+	}
+	if err := runActions(ctx, drv.WaitNavigatedAway("/activities/"+id+"/edit", 60*time.Second), drv.WaitNetworkIdle()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,202 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stopCondition は投稿の合間に評価する、新しい投稿の処理を終える条件。
+// 組み込みの条件と設定ファイルの stop_when を並べ、最初に成り立った条件の名前を実行の記録とレポートに残す
+type stopCondition struct {
+	// name は実行の記録 (stopped_by) に残す条件の名前
+	name string
+	// reached は条件が成り立ったかを返し、成り立った場合は理由をログに出す。
+	// 一時停止や待機が設定されている条件は、ここで待ってから評価する
+	reached func(ctx context.Context) bool
+}
+
+// stopRule は設定ファイルの stop_when の条件1件。"&&" でつないだアラートの条件 (alertRule) がすべて一致した場合に成り立つ
+type stopRule struct {
+	text  string
+	rules []alertRule
+}
+
+// parseStopRule は "failure_rate > 30% && processed >= 10" のような条件を解析する
+func parseStopRule(text string) (stopRule, error) {
+	rule := stopRule{text: text}
+	for _, part := range strings.Split(text, "&&") {
+		r, err := parseAlertRule(strings.TrimSpace(part))
+		if err != nil {
+			return stopRule{}, err
+		}
+		rule.rules = append(rule.rules, r)
+	}
+	return rule, nil
+}
+
+// matches は実行中の結果が条件に一致するかを返す
+func (s stopRule) matches(r runRecord) bool {
+	for _, rule := range s.rules {
+		if !rule.matches(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// reactionStopConditions はリアクション処理の投稿の合間に評価する条件を、評価する順に返す。
+// キルスイッチ・最大実行時間・稼働時間帯・1時間あたりの上限・DOMOの予算の後に、設定ファイルの stop_when を並べる
+func reactionStopConditions() []stopCondition {
+	conditions := []stopCondition{
+		{name: "kill-switch", reached: func(ctx context.Context) bool {
+			if waitForKillSwitch(ctx) != killSwitchStop {
+				return false
+			}
+			loggerFromContext(ctx).Println(tr("キルスイッチにより停止が指示されたため、リアクション処理を終了します。"))
+			return true
+		}},
+		{name: "max-runtime", reached: func(ctx context.Context) bool {
+			if !maxRuntimeReached() {
+				return false
+			}
+			loggerFromContext(ctx).Printf(tr("最大実行時間 (%s) に達したため、新しい投稿の処理を終了します。"), maxRuntime)
+			return true
+		}},
+		{name: "operating-hours", reached: func(ctx context.Context) bool { return !waitForOperatingHours(ctx) }},
+		{name: "hourly-quota", reached: func(ctx context.Context) bool { return !waitForHourlyQuota(ctx) }},
+		{name: "domo-budget", reached: func(ctx context.Context) bool { return !withinDomoBudget(ctx) }},
+	}
+	for _, rule := range config.stopWhen {
+		conditions = append(conditions, stopCondition{name: rule.text, reached: func(ctx context.Context) bool {
+			if !rule.matches(status.result()) {
+				return false
+			}
+			loggerFromContext(ctx).Printf(tr("停止の条件 (%s) が成り立ったため、新しい投稿の処理を終了します。"), rule.text)
+			return true
+		}})
+	}
+	return conditions
+}
+
+// stopConditionReached は条件を順に評価し、成り立った条件があればその名前を実行の記録に残して true を返す
+func stopConditionReached(ctx context.Context, conditions []stopCondition) bool {
+	for _, c := range conditions {
+		if c.reached(ctx) {
+			status.setStoppedBy(c.name)
+			return true
+		}
+	}
+	return false
+}
+
+// alertRule は実行の終了時に評価するアラートの条件。設定ファイルの alerts に "<指標> <比較> <値>" の形式で指定する
+// (例: "succeeded == 0", "failure_rate > 50%", "login > 60s")
+type alertRule struct {
+	text   string
+	metric string
+	op     string
+	value  float64
+}
+
+// alertMetrics はアラートの条件に指定できる指標と、実行の結果から値を取り出す関数。
+// 値がない場合 (投稿を処理していない実行の失敗率、ログインしていない実行のログイン時間) は false を返し、条件を評価しない
+var alertMetrics = map[string]func(r runRecord) (float64, bool){
+	"processed": func(r runRecord) (float64, bool) { return float64(r.Processed), true },
+	"succeeded": func(r runRecord) (float64, bool) { return float64(r.Succeeded), true },
+	"failed":    func(r runRecord) (float64, bool) { return float64(r.Failed), true },
+	"skipped":   func(r runRecord) (float64, bool) { return float64(r.Skipped), true },
+	"failure_rate": func(r runRecord) (float64, bool) {
+		if r.Processed == 0 {
+			return 0, false
+		}
+		return float64(r.Failed) * 100 / float64(r.Processed), true
+	},
+	"login": func(r runRecord) (float64, bool) { return r.LoginSeconds, r.LoginSeconds > 0 },
+	"duration": func(r runRecord) (float64, bool) {
+		return r.FinishedAt.Sub(r.StartedAt).Seconds(), true
+	},
+	"rate_limited": func(r runRecord) (float64, bool) {
+		if r.RateLimited == nil {
+			return 0, true
+		}
+		return float64(r.RateLimited.Detections), true
+	},
+}
+
+// alertMetricNames はエラーメッセージに表示するアラートの指標の一覧
+const alertMetricNames = "processed, succeeded, failed, skipped, failure_rate, login, duration, rate_limited"
+
+// alertOps はアラートの条件に指定できる比較 (2文字のものを先に照合する)
+var alertOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// parseAlertRule はアラートの条件を解析する。値は数値のほか、割合 (50%) と時間 (60s, 2m) で指定でき、
+// 割合はパーセントの数値、時間は秒数として比べる
+func parseAlertRule(text string) (alertRule, error) {
+	fields := strings.Fields(text)
+	if len(fields) != 3 {
+		return alertRule{}, fmt.Errorf(tr("'<指標> <比較> <値>' の形式で指定してください: %s"), text)
+	}
+	rule := alertRule{text: text, metric: fields[0], op: fields[1]}
+	if _, ok := alertMetrics[rule.metric]; !ok {
+		return alertRule{}, fmt.Errorf(tr("指標には %s のいずれかを指定してください: %s"), alertMetricNames, rule.metric)
+	}
+	if !slices.Contains(alertOps, rule.op) {
+		return alertRule{}, fmt.Errorf(tr("比較には %s のいずれかを指定してください: %s"), strings.Join(alertOps, " "), rule.op)
+	}
+	v := fields[2]
+	if d, err := time.ParseDuration(v); err == nil && strings.ContainsAny(v, "hms") {
+		rule.value = d.Seconds()
+		return rule, nil
+	}
+	n, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil {
+		return alertRule{}, fmt.Errorf(tr("値には数値・割合 (50%%)・時間 (60s) のいずれかを指定してください: %s"), v)
+	}
+	rule.value = n
+	return rule, nil
+}
+
+// matches は実行の結果が条件に一致するかを返す
+func (a alertRule) matches(r runRecord) bool {
+	v, ok := alertMetrics[a.metric](r)
+	if !ok {
+		return false
+	}
+	switch a.op {
+	case ">=":
+		return v >= a.value
+	case "<=":
+		return v <= a.value
+	case "==":
+		return v == a.value
+	case "!=":
+		return v != a.value
+	case ">":
+		return v > a.value
+	default:
+		return v < a.value
+	}
+}
+
+// checkAlerts は実行の終了時に設定ファイルの alerts を評価し、一致した条件を ALERT として NOTIFY_WEBHOOK_URL に通知する。
+// サイトの変更などでリアクションが送れなくなっても、エラーにならずに気づきにくい場合に備える
+func checkAlerts(r runRecord) {
+	var matched []string
+	for _, rule := range config.alerts {
+		if rule.matches(r) {
+			matched = append(matched, rule.text)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	message := fmt.Sprintf(tr("%s の実行結果がアラートの条件に一致しました: %s (処理 %d件 / 成功 %d件 / 失敗 %d件 / スキップ %d件)"),
+		r.Action, strings.Join(matched, ", "), r.Processed, r.Succeeded, r.Failed, r.Skipped)
+	log.Printf(tr("警告: %v"), message)
+	notify(context.Background(), "ALERT", message)
+}
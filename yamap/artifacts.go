@@ -0,0 +1,379 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runID は実行ごとの識別子 (開始日時と乱数)。ログの各行・デバッグ情報のファイル名・通知・成果物のアップロード先のキーと、
+// 履歴の実行の記録に含め、どの実行のものかを見分けられるようにする
+var runID = newRunID()
+
+func newRunID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// artifactUploadTimeout は終了時に成果物のアップロードの完了を待つ時間の上限
+const artifactUploadTimeout = time.Minute
+
+// artifacts は ARTIFACT_UPLOAD_URL が設定されている場合の、失敗時のデバッグ情報や実行の記録のアップロード先。未設定の場合は nil
+var artifacts *artifactUploader
+
+// artifactDestination は成果物を保存するストレージ
+type artifactDestination interface {
+	put(ctx context.Context, key, contentType string, data []byte) error
+	String() string
+}
+
+// artifactUploader はコンテナのファイルシステムとともに消えてしまうデバッグ情報などを、実行中にバックグラウンドでストレージに送る。
+// キーは <接頭辞>/[<アカウント名>/]<実行ID>/<ファイル名>
+type artifactUploader struct {
+	dest   artifactDestination
+	prefix string
+	wg     sync.WaitGroup
+}
+
+// newArtifactUploaderFromEnv は ARTIFACT_UPLOAD_URL (s3://バケット/接頭辞, gs://バケット/接頭辞, https://...) からアップロード先を作る。
+// 未設定の場合は nil を返す
+func newArtifactUploaderFromEnv() (*artifactUploader, error) {
+	raw := os.Getenv("ARTIFACT_UPLOAD_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, errors.New(tr("ARTIFACT_UPLOAD_URLの値が不正です (s3://バケット/接頭辞, gs://バケット/接頭辞, https://... のいずれかで指定してください)"))
+	}
+	up := &artifactUploader{prefix: strings.Trim(u.Path, "/")}
+	switch u.Scheme {
+	case "s3":
+		dest, err := newS3Destination(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		up.dest = dest
+	case "gs":
+		key, err := loadServiceAccountKey()
+		if err != nil {
+			return nil, err
+		}
+		up.dest = &gcsDestination{bucket: u.Host, key: key}
+	case "http", "https":
+		up.dest = httpDestination{base: strings.TrimSuffix(raw, "/"), token: os.Getenv("ARTIFACT_UPLOAD_TOKEN")}
+		up.prefix = ""
+	default:
+		return nil, errors.New(tr("ARTIFACT_UPLOAD_URLの値が不正です (s3://バケット/接頭辞, gs://バケット/接頭辞, https://... のいずれかで指定してください)"))
+	}
+	if name := os.Getenv("YAMAP_ACCOUNT"); name != "" {
+		up.prefix = strings.TrimPrefix(up.prefix+"/"+name, "/")
+	}
+	up.prefix = strings.TrimPrefix(up.prefix+"/"+runID, "/")
+	return up, nil
+}
+
+// uploadFile は保存したファイルをバックグラウンドでアップロードする。アップロード先が未設定の場合は何もしない
+func (u *artifactUploader) uploadFile(path string) {
+	if u == nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf(tr("警告: %s をアップロードできません: %v"), path, err)
+		return
+	}
+	u.upload(filepath.Base(path), data)
+}
+
+// upload は data を name としてバックグラウンドでアップロードする。失敗しても実行は続ける
+func (u *artifactUploader) upload(name string, data []byte) {
+	if u == nil {
+		return
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	key := u.prefix + "/" + name
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), artifactUploadTimeout)
+		defer cancel()
+		if err := u.dest.put(ctx, key, contentType, data); err != nil {
+			log.Printf(tr("警告: %s のアップロードに失敗しました: %v"), name, err)
+			return
+		}
+		log.Printf(tr("%s を %s の %s にアップロードしました。"), name, u.dest, key)
+	}()
+}
+
+// wait は終了前に、実行中のアップロードの完了を artifactUploadTimeout まで待つ
+func (u *artifactUploader) wait() {
+	if u == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(artifactUploadTimeout):
+		log.Print(tr("警告: 成果物のアップロードが終わらないまま終了します。"))
+	}
+}
+
+// writeArtifact はデバッグ情報のファイルを書き出し、アップロード先が設定されていればアップロードする。
+// スクリーンショット以外は、フォームに入力したメールアドレスなどを残さないよう秘密の値を伏せる
+func writeArtifact(path string, data []byte) error {
+	if !strings.EqualFold(filepath.Ext(path), ".png") {
+		data = []byte(redaction.redact(string(data)))
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+	artifacts.uploadFile(path)
+	return nil
+}
+
+// sendArtifact はアップロードのリクエストを送り、2xx 以外の応答をエラーにする
+func sendArtifact(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf(tr("ステータス %d: %s"), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// httpDestination は base/<キー> に PUT で送る汎用のアップロード先。token が設定されている場合は Authorization: Bearer で送る
+type httpDestination struct {
+	base  string
+	token string
+}
+
+func (h httpDestination) put(ctx context.Context, key, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.base+"/"+s3EscapePath(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return sendArtifact(req)
+}
+
+func (h httpDestination) String() string {
+	u, _, _ := strings.Cut(h.base, "?")
+	return u
+}
+
+// s3Destination はAmazon S3 (またはS3互換のストレージ) のバケット。認証情報は AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN、リージョンは AWS_REGION (既定値 us-east-1) から読む。ARTIFACT_S3_ENDPOINT を設定した場合は、そのエンドポイントにパス形式で送る
+type s3Destination struct {
+	bucket       string
+	region       string
+	endpoint     string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func newS3Destination(bucket string) (*s3Destination, error) {
+	d := &s3Destination{
+		bucket:       bucket,
+		region:       os.Getenv("AWS_REGION"),
+		endpoint:     strings.TrimSuffix(os.Getenv("ARTIFACT_S3_ENDPOINT"), "/"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if d.region == "" {
+		d.region = "us-east-1"
+	}
+	if d.accessKey == "" || d.secretKey == "" {
+		return nil, errors.New(tr("S3にアップロードするには AWS_ACCESS_KEY_ID と AWS_SECRET_ACCESS_KEY を設定してください"))
+	}
+	return d, nil
+}
+
+func (d *s3Destination) put(ctx context.Context, key, contentType string, data []byte) error {
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", d.bucket, d.region, s3EscapePath(key))
+	if d.endpoint != "" {
+		endpoint = d.endpoint + "/" + d.bucket + "/" + s3EscapePath(key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	sum := sha256.Sum256(data)
+	d.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return sendArtifact(req)
+}
+
+// sign はリクエストにAWS署名バージョン4の Authorization ヘッダーを付ける。署名の対象は Host と設定済みのすべてのヘッダー
+func (d *s3Destination) sign(req *http.Request, payloadHash string, now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if d.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", d.sessionToken)
+	}
+	signAWSV4(req, "s3", d.region, d.accessKey, d.secretKey, payloadHash, now)
+}
+
+// signAWSV4 はAWS署名バージョン4で、リクエストに X-Amz-Date と Authorization ヘッダーを付ける。service は署名のスコープのサービス名 (s3 など)
+func signAWSV4(req *http.Request, service, region, accessKey, secretKey, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	canonicalRequest, signedHeaders := awsCanonicalRequest(req, payloadHash)
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// awsCanonicalRequest は署名するための正規化したリクエストと、署名するヘッダーの名前 (; 区切り) を返す。
+// ヘッダーの値は前後の空白を除いて連続する空白を1つにし、クエリはキーの順に並べてエスケープし直す。
+// パスはS3と同じく、送るパスをそのまま使う (. や .. を解決しない)
+func awsCanonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{req.Method, path, awsCanonicalQuery(req.URL.RawQuery), canonicalHeaders.String(), signedHeaders, payloadHash}, "\n"), signedHeaders
+}
+
+// awsCanonicalQuery はクエリを署名のための形 (キー・値の順に並べ、RFC 3986で予約されていない文字以外をエスケープし、値のないキーは "キー=") にする
+func awsCanonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, _ := neturl.ParseQuery(rawQuery)
+	var pairs []string
+	for k, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func (d *s3Destination) String() string {
+	return "s3://" + d.bucket
+}
+
+// s3EscapePath はキーの / 以外の、RFC 3986で予約されていない文字 (英数字と -_.~) 以外をエスケープしてURLのパスにする。
+// AWS署名の正規化されたURIと一致させるため、neturl.PathEscape より多くの文字をエスケープする
+func s3EscapePath(key string) string {
+	return awsURIEncode(key, false)
+}
+
+// awsURIEncode はRFC 3986で予約されていない文字 (英数字と -_.~) 以外を大文字の16進数でエスケープする。
+// encodeSlash が false の場合は / をそのまま残す
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// googleStorageScope はCloud Storageへの書き込みに必要なOAuthスコープ
+const googleStorageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// gcsDestination はGoogle Cloud Storageのバケット。認証にはスプレッドシートへの書き出しと同じサービスアカウントの鍵を使う
+type gcsDestination struct {
+	bucket string
+	key    serviceAccountKey
+	// endpoint はCloud StorageのAPIの接続先。空の場合は https://storage.googleapis.com
+	endpoint string
+}
+
+func (d *gcsDestination) put(ctx context.Context, key, contentType string, data []byte) error {
+	token, err := googleAccessToken(ctx, d.key, googleStorageScope)
+	if err != nil {
+		return err
+	}
+	base := d.endpoint
+	if base == "" {
+		base = "https://storage.googleapis.com"
+	}
+	endpoint := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+		base, neturl.PathEscape(d.bucket), neturl.QueryEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return sendArtifact(req)
+}
+
+func (d *gcsDestination) String() string {
+	return "gs://" + d.bucket
+}
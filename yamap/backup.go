@@ -0,0 +1,378 @@
+package yamap
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupManifest は backup で保存済みの活動日記の一覧。BACKUP_DIR の manifest.json に保存し、次回以降の実行で保存済みの活動日記を省く
+type backupManifest struct {
+	// Activities は活動日記のIDと、保存した場所 (BACKUP_DIR からの相対パス) と日時
+	Activities map[string]backupItem `json:"activities"`
+}
+
+// backupItem は保存済みの活動日記1件の記録
+type backupItem struct {
+	Path string `json:"path"`
+	// Archive は BACKUP_ARCHIVE=true でまとめた tar.gz の名前。Path はアーカイブ内のパス
+	Archive    string    `json:"archive,omitempty"`
+	BackedUpAt time.Time `json:"backed_up_at"`
+}
+
+// backupActivity は活動日記1件分の activity.json の内容
+type backupActivity struct {
+	URL        string           `json:"url"`
+	Metadata   activityMetadata `json:"metadata"`
+	Comments   []backupComment  `json:"comments"`
+	Photos     []string         `json:"photos"`
+	GPX        string           `json:"gpx,omitempty"`
+	BackedUpAt time.Time        `json:"backed_up_at"`
+}
+
+// backupComment は活動日記のコメント1件
+type backupComment struct {
+	AuthorID   int64  `json:"author_id"`
+	AuthorName string `json:"author_name"`
+	Text       string `json:"text"`
+}
+
+// activityPhotosScript は活動日記詳細ページの写真のURLを重複なく取得するスクリプト。
+// NUXTのデータ内の写真の項目を優先し、見つからない場合は本文の画像を使う
+const activityPhotosScript = `(() => {
+	const nuxt = window.__NUXT__ || {};
+	const candidates = [];
+	if (nuxt.state && nuxt.state.activity) candidates.push(nuxt.state.activity.activity, nuxt.state.activity);
+	for (const d of (nuxt.data || [])) if (d) candidates.push(d.activity, d);
+	const a = candidates.find(c => c && typeof c === "object" && (Array.isArray(c.images) || Array.isArray(c.photos))) || {};
+	const urls = (a.images || a.photos || []).map(p => p && (p.original_url || p.image_url || p.url || p.large_url)).filter(Boolean);
+	if (urls.length === 0) {
+		for (const img of document.querySelectorAll('main img')) {
+			const src = img.currentSrc || img.src;
+			if (/\/(images|photos|activities)\//.test(src) && !/avatar|icon|badge/i.test(src)) urls.push(src);
+		}
+	}
+	return Array.from(new Set(urls));
+})()`
+
+// gpxLinkScript は活動日記詳細ページのGPXファイルのダウンロードのリンクを探すスクリプト。見つからない場合は空文字
+const gpxLinkScript = `(() => {
+	const a = Array.from(document.querySelectorAll('a[href]')).find(a => /gpx/i.test(a.getAttribute("href")) || /GPX/.test(a.textContent || ""));
+	return a ? a.href : "";
+})()`
+
+// fetchInPage は表示中のページからログイン済みのクッキーを付けてURLを取得する。
+// ドライバはPromiseの完了を待てないため、結果をページの変数に置いてから Poll で待つ
+func fetchInPage(ctx context.Context, drv pageDriver, url string) ([]byte, error) {
+	quoted, _ := json.Marshal(url)
+	start := `(() => {
+	window.__yamapAutoDomoFetch = null;
+	fetch(` + string(quoted) + `, {credentials: "include"})
+		.then(r => r.ok ? r.arrayBuffer() : Promise.reject("HTTP " + r.status))
+		.then(buf => {
+			const bytes = new Uint8Array(buf);
+			let s = "";
+			for (let i = 0; i < bytes.length; i += 0x8000) s += String.fromCharCode.apply(null, bytes.subarray(i, i + 0x8000));
+			window.__yamapAutoDomoFetch = {data: btoa(s)};
+		})
+		.catch(e => { window.__yamapAutoDomoFetch = {error: String(e)}; });
+	return true;
+})()`
+	var res struct {
+		Data  string `json:"data"`
+		Error string `json:"error"`
+	}
+	if err := runActions(ctx,
+		drv.Evaluate(start, nil),
+		drv.Poll(`window.__yamapAutoDomoFetch !== null`, time.Minute),
+		drv.Evaluate(`window.__yamapAutoDomoFetch`, &res),
+	); err != nil {
+		return nil, err
+	}
+	if res.Error != "" {
+		return nil, errors.New(res.Error)
+	}
+	return base64.StdEncoding.DecodeString(res.Data)
+}
+
+// downloadPhoto は写真をダウンロードする。写真は公開の配信元にあるため、ブラウザを介さずに取得する
+func downloadPhoto(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(tr("ステータス %d"), resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// collectMyActivityPaths は自分のプロフィールページをスクロールし、すべての活動日記のパスを新しい順に返す
+func collectMyActivityPaths(ctx context.Context, userID int64) ([]string, error) {
+	drv := driverFromContext(ctx)
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", userID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+	); err != nil {
+		return nil, err
+	}
+	var paths []string
+	seen := make(map[string]struct{})
+	for noNew := 0; noNew < 3; {
+		var found []string
+		if err := runActions(ctx, drv.Evaluate(myActivityLinksScript, &found)); err != nil {
+			return nil, err
+		}
+		before := len(paths)
+		for _, p := range found {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) == before {
+			noNew++
+		} else {
+			noNew = 0
+			status.markStep()
+		}
+		if err := runActions(ctx, scrollForMore(drv, "("+myActivityLinksScript+").length")); err != nil {
+			return nil, err
+		}
+	}
+	return paths, nil
+}
+
+// runBackup は自分のすべての活動日記の情報・本文・コメント・写真・GPXファイルを BACKUP_DIR (既定値 backup) の
+// 実行日時のディレクトリに保存する。manifest.json に記録した保存済みの活動日記は省き、BACKUP_ARCHIVE=true の場合は
+// 保存したディレクトリを tar.gz にまとめる
+func runBackup() error {
+	log.Println(tr("--- プログラム開始 (backup) ---"))
+	startTime := time.Now()
+	root := os.Getenv("BACKUP_DIR")
+	if root == "" {
+		root = "backup"
+	}
+	manifestPath := filepath.Join(root, "manifest.json")
+	manifest := backupManifest{Activities: make(map[string]backupItem)}
+	if data, err := os.ReadFile(manifestPath); err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf(tr("%s の形式が不正です: %w"), manifestPath, err)
+		}
+		if manifest.Activities == nil {
+			manifest.Activities = make(map[string]backupItem)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	runDirName := startTime.Format("2006-01-02_150405")
+	runDir := filepath.Join(root, runDirName)
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、自分の活動日記を確認できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	paths, err := collectMyActivityPaths(ctx, sess.UserID)
+	if err != nil {
+		return fmt.Errorf(tr("自分の活動日記の一覧の取得に失敗: %w"), err)
+	}
+	var pending []string
+	for _, p := range paths {
+		if _, ok := manifest.Activities[strings.TrimPrefix(p, "/activities/")]; !ok {
+			pending = append(pending, p)
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の活動日記のうち、%d件が未保存です。"), len(paths), len(pending))
+
+	status.setPhase("reacting")
+	saved := 0
+	for i, p := range pending {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("残りの活動日記は次回の実行で保存します。"))
+			break
+		}
+		id := strings.TrimPrefix(p, "/activities/")
+		url := "https://yamap.com" + p
+		loggerFromContext(ctx).Printf(tr("活動日記を保存します (%d/%d): %s"), i+1, len(pending), url)
+		rel := filepath.Join(runDirName, "activities", id)
+		err := backupActivityTo(ctx, drv, url, filepath.Join(root, rel))
+		status.recordResult(err == nil, err)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("活動日記の保存に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		manifest.Activities[id] = backupItem{Path: filepath.ToSlash(rel), BackedUpAt: time.Now()}
+		// 途中で中断しても保存済みの活動日記を次回に省けるよう、1件ごとに記録する
+		data, _ := json.MarshalIndent(manifest, "", "  ")
+		if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+			return fmt.Errorf(tr("%s の保存に失敗しました: %w"), manifestPath, err)
+		}
+		saved++
+		pace.wait(ctx)
+	}
+
+	if saved > 0 && os.Getenv("BACKUP_ARCHIVE") == "true" {
+		archive := runDir + ".tar.gz"
+		if err := writeTarGz(archive, runDir); err != nil {
+			return fmt.Errorf(tr("バックアップのアーカイブの作成に失敗しました: %w"), err)
+		}
+		if err := os.RemoveAll(runDir); err != nil {
+			return err
+		}
+		for id, item := range manifest.Activities {
+			if strings.HasPrefix(item.Path, runDirName+"/") {
+				item.Archive = runDirName + ".tar.gz"
+				manifest.Activities[id] = item
+			}
+		}
+		data, _ := json.MarshalIndent(manifest, "", "  ")
+		if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+			return fmt.Errorf(tr("%s の保存に失敗しました: %w"), manifestPath, err)
+		}
+		loggerFromContext(ctx).Printf(tr("バックアップを %s にまとめました。"), archive)
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- %d件の活動日記を %s に保存しました ---"), saved, root)
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// backupActivityTo は活動日記1件の情報・コメント・写真・GPXファイルを dir に保存する。
+// 写真やGPXファイルを取得できなかった場合はログに出力し、取得できたものだけを保存する
+func backupActivityTo(ctx context.Context, drv pageDriver, url, dir string) error {
+	var comments []struct {
+		Href string `json:"href"`
+		Name string `json:"name"`
+		Text string `json:"text"`
+	}
+	var photos []string
+	var gpxURL string
+	if err := runActions(ctx,
+		drv.Navigate(url),
+		drv.WaitVisible(layout.footer),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(commentItemsScript, &comments),
+		drv.Evaluate(activityPhotosScript, &photos),
+		drv.Evaluate(gpxLinkScript, &gpxURL),
+	); err != nil {
+		return err
+	}
+	meta, err := fetchActivityMetadata(ctx, drv)
+	if err != nil {
+		return fmt.Errorf(tr("活動の情報の取得に失敗: %w"), err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "photos"), 0o755); err != nil {
+		return err
+	}
+	record := backupActivity{URL: url, Metadata: meta, Comments: []backupComment{}, Photos: []string{}, BackedUpAt: time.Now()}
+	for _, c := range comments {
+		record.Comments = append(record.Comments, backupComment{AuthorID: userIDFromPath(c.Href), AuthorName: c.Name, Text: c.Text})
+	}
+	for i, photoURL := range photos {
+		data, err := downloadPhoto(ctx, photoURL)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("写真のダウンロードに失敗しました (%s): %v"), photoURL, err)
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(strings.SplitN(photoURL, "?", 2)[0]))
+		if ext == "" || len(ext) > 5 {
+			ext = ".jpg"
+		}
+		name := fmt.Sprintf("photos/%03d%s", i+1, ext)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			return err
+		}
+		record.Photos = append(record.Photos, name)
+		status.markStep()
+	}
+	if gpxURL != "" {
+		if data, err := fetchInPage(ctx, drv, gpxURL); err != nil {
+			loggerFromContext(ctx).Printf(tr("GPXファイルのダウンロードに失敗しました (%s): %v"), gpxURL, err)
+		} else if err := os.WriteFile(filepath.Join(dir, "track.gpx"), data, 0o644); err != nil {
+			return err
+		} else {
+			record.GPX = "track.gpx"
+		}
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "activity.json"), data, 0o644)
+}
+
+// writeTarGz は dir 以下のファイルを、dir の名前を先頭に付けたパスで tar.gz にまとめる
+func writeTarGz(path, dir string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	base := filepath.Dir(dir)
+	err = filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
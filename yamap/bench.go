@@ -0,0 +1,145 @@
+package yamap
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// benchSteps は bench で計測する段階 (結果の表示順)
+var benchSteps = []string{"navigate", "parse", "scroll", "react"}
+
+// runBench は navigate (タイムラインを開いてNUXTデータを待つ)、parse (NUXTデータの解析)、scroll (続きの読み込み)、
+// react (未リアクションの投稿へのリアクション) を BENCH_CYCLES 回 (既定値 5) 繰り返し、段階ごとの所要時間の分布を出力する。
+// BENCH_REACT=true でなければドライランとし、react では投稿ページで絵文字ピッカーを開くところまでを計測する
+func runBench() error {
+	log.Println(tr("--- プログラム開始 (bench) ---"))
+	startTime := time.Now()
+	cycles := 5
+	if v := os.Getenv("BENCH_CYCLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("BENCH_CYCLESの値が不正です: %s"), v)
+		}
+		cycles = n
+	}
+	dryRun := os.Getenv("BENCH_REACT") != "true"
+	if dryRun {
+		log.Println(tr("ドライランのため、リアクションは送らずに絵文字ピッカーを開くまでを計測します。"))
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	drv := driverFromContext(ctx)
+
+	samples := make(map[string][]time.Duration)
+	measure := func(step string, f func() error) error {
+		start := time.Now()
+		if err := f(); err != nil {
+			loggerFromContext(ctx).Printf(tr("%s の計測に失敗しました: %v"), step, err)
+			return err
+		}
+		samples[step] = append(samples[step], time.Since(start))
+		return nil
+	}
+	tried := make(map[string]bool)
+	for i := 0; i < cycles; i++ {
+		if maxRuntimeReached() || ctx.Err() != nil {
+			break
+		}
+		loggerFromContext(ctx).Printf(tr("--- 計測 %d/%d ---"), i+1, cycles)
+		if err := measure("navigate", func() error {
+			return runActions(ctx,
+				drv.Navigate("https://yamap.com/timeline"),
+				drv.WaitVisible(layout.feed),
+				drv.Poll(`window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds`, 20*time.Second),
+			)
+		}); err != nil {
+			continue
+		}
+		var feedItems []FeedItem
+		if err := measure("parse", func() (err error) {
+			feedItems, err = parseNuxtData(ctx)
+			return err
+		}); err != nil {
+			continue
+		}
+		measure("scroll", func() error {
+			return runActions(ctx, scrollForMore(drv, feedCountScript))
+		})
+
+		var target ActivityInfo
+		for _, item := range feedItems {
+			if item.Activity == nil || item.Activity.ID == 0 || item.isPromoted() {
+				continue
+			}
+			url := fmt.Sprintf("https://yamap.com/activities/%d", item.Activity.ID)
+			reacted := tried[url]
+			for _, reaction := range item.Activity.EmojiReactions {
+				reacted = reacted || reaction.ViewerHasReacted
+			}
+			if !reacted {
+				target = ActivityInfo{URL: url, Title: item.Activity.Title}
+				if item.Activity.User != nil {
+					target.AuthorID, target.AuthorName = item.Activity.User.ID, item.Activity.User.Name
+				}
+				break
+			}
+		}
+		if target.URL == "" {
+			loggerFromContext(ctx).Println(tr("リアクションを計測する未リアクションの投稿が見つかりませんでした。"))
+			continue
+		}
+		tried[target.URL] = true
+		measure("react", func() error {
+			if dryRun {
+				return runActions(ctx,
+					drv.Navigate(target.URL),
+					drv.WaitVisible(layout.footer),
+					drv.ScrollIntoView(postPageOf(target.URL).toolbar),
+					drv.WaitVisible(postPageOf(target.URL).addButton),
+					drv.Click(postPageOf(target.URL).addButton),
+					drv.WaitVisible(`.emojiPickerBody`),
+				)
+			}
+			liked, sent, err := sendReaction(ctx, target.URL, "")
+			status.recordResult(liked, err)
+			if liked {
+				recordReaction(ctx, target, sent)
+			}
+			return err
+		})
+		status.markStep()
+	}
+
+	loggerFromContext(ctx).Println(tr("--- 段階ごとの所要時間 ---"))
+	for _, step := range benchSteps {
+		d := samples[step]
+		if len(d) == 0 {
+			loggerFromContext(ctx).Printf(tr("%-8s 計測なし"), step)
+			continue
+		}
+		slices.Sort(d)
+		loggerFromContext(ctx).Printf("%-8s n=%d  p50=%s  p90=%s  p99=%s  max=%s", step, len(d),
+			percentile(d, 50), percentile(d, 90), percentile(d, 99), d[len(d)-1].Round(time.Millisecond))
+	}
+	loggerFromContext(ctx).Println("---------------------------------")
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// percentile は昇順に並べた所要時間から、最近傍順位法で p パーセンタイルの値を返す
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i-1, 0)].Round(time.Millisecond)
+}
@@ -0,0 +1,269 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// bookmarkButtonSelector は活動日記詳細ページのブックマークのボタン
+const bookmarkButtonSelector = `button[aria-label*="ブックマーク"], button[aria-label*="Bookmark"], button[aria-label*="クリップ"], [class*="Bookmark"] button, button[class*="Bookmark"]`
+
+// bookmarkStateScript はブックマークのボタンの状態を返すスクリプト。
+// ボタンが見つからない場合は "missing"、ブックマークされている場合は "on"、されていない場合は "off" を返す
+const bookmarkStateScript = `(() => {
+	const button = document.querySelector('` + bookmarkButtonSelector + `');
+	if (!button) return "missing";
+	const pressed = button.getAttribute("aria-pressed");
+	if (pressed !== null) return pressed === "true" ? "on" : "off";
+	return /is-?active|bookmarked|selected/i.test(button.className) ? "on" : "off";
+})()`
+
+// bookmarkEntriesScript はブックマークの一覧に表示されている活動日記のパスと、ブックマークした日時を表示順に取得するスクリプト。
+// 日時はNUXTのデータの bookmarked_at (なければ created_at) を使い、データにない場合は一覧の項目の time 要素の日時を使う
+const bookmarkEntriesScript = `(() => {
+	const dates = {};
+	const seen = new Set();
+	const walk = (v, depth) => {
+		if (!v || typeof v !== "object" || depth > 8 || seen.has(v)) return;
+		seen.add(v);
+		if (v.activity && v.activity.id && (v.bookmarked_at || v.created_at)) dates[v.activity.id] = v.bookmarked_at || v.created_at;
+		for (const x of Object.values(v)) walk(x, depth + 1);
+	};
+	walk(window.__NUXT__, 0);
+	const hrefs = [];
+	const result = [];
+	for (const a of document.querySelectorAll('main a[href^="/activities/"]')) {
+		const href = (a.getAttribute("href").match(/^\/activities\/\d+/) || [""])[0];
+		if (!href || hrefs.includes(href)) continue;
+		hrefs.push(href);
+		const entry = a.closest('article, li, [class*="Item"], [class*="Card"]') || a.parentElement;
+		const time = entry && entry.querySelector("time[datetime]");
+		result.push({href: href, bookmarked_at: dates[href.split("/")[2]] || (time ? time.getAttribute("datetime") : "")});
+	}
+	return result;
+})()`
+
+// bookmarksURL は BOOKMARKS_URL で指定されたブックマークの一覧のURLを返す
+func bookmarksURL() string {
+	if v := os.Getenv("BOOKMARKS_URL"); v != "" {
+		return v
+	}
+	return "https://yamap.com/bookmarks"
+}
+
+// runBookmarksClean はブックマークの一覧をスクロールして、BOOKMARKS_CLEAN_OLDER_THAN_DAYS 日より前にブックマークした活動日記と、
+// 新しい順に BOOKMARKS_CLEAN_KEEP 件を超えた活動日記のブックマークを外す。1回の実行で外すのは BOOKMARKS_CLEAN_MAX 件 (既定値 50) まで
+func runBookmarksClean() error {
+	log.Println(tr("--- プログラム開始 (bookmarks-clean) ---"))
+	startTime := time.Now()
+
+	olderThan := 0
+	if v := os.Getenv("BOOKMARKS_CLEAN_OLDER_THAN_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("BOOKMARKS_CLEAN_OLDER_THAN_DAYSの値が不正です: %s"), v)
+		}
+		olderThan = n
+	}
+	keep := -1
+	if v := os.Getenv("BOOKMARKS_CLEAN_KEEP"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf(tr("BOOKMARKS_CLEAN_KEEPの値が不正です: %s"), v)
+		}
+		keep = n
+	}
+	if olderThan == 0 && keep < 0 {
+		return errors.New(tr("bookmarks-clean では BOOKMARKS_CLEAN_OLDER_THAN_DAYS か BOOKMARKS_CLEAN_KEEP を指定してください"))
+	}
+	maxRemovals := 50
+	if v := os.Getenv("BOOKMARKS_CLEAN_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("BOOKMARKS_CLEAN_MAXの値が不正です: %s"), v)
+		}
+		maxRemovals = n
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	drv := driverFromContext(ctx)
+	url := bookmarksURL()
+	loggerFromContext(ctx).Printf(tr("ブックマークの一覧を読み込みます: %s"), url)
+	if err := runActions(ctx, drv.Navigate(url), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+		return fmt.Errorf(tr("ブックマークの一覧の読み込みに失敗: %w"), err)
+	}
+	var entries []struct {
+		Href         string        `json:"href"`
+		BookmarkedAt feedTimestamp `json:"bookmarked_at"`
+	}
+	for loaded, noNew := 0, 0; ; {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("最大実行時間に達したため、URLの収集を終了します。"))
+			break
+		}
+		if err := runActions(ctx, drv.Evaluate(bookmarkEntriesScript, &entries)); err != nil {
+			return fmt.Errorf(tr("ブックマークの一覧の読み込みに失敗: %w"), err)
+		}
+		if len(entries) == loaded {
+			noNew++
+		} else {
+			noNew = 0
+			loaded = len(entries)
+			status.markStep()
+		}
+		if noNew >= 3 {
+			break
+		}
+		if err := runActions(ctx, scrollForMore(drv, "("+bookmarkEntriesScript+").length")); err != nil {
+			return err
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("%d件のブックマークを読み込みました。"), len(entries))
+
+	cutoff := time.Now().AddDate(0, 0, -olderThan)
+	var targets []string
+	for i, e := range entries {
+		bookmarkedAt := e.BookmarkedAt.time()
+		beyondKeep := keep >= 0 && i >= keep
+		tooOld := olderThan > 0 && !bookmarkedAt.IsZero() && bookmarkedAt.Before(cutoff)
+		if beyondKeep || tooOld {
+			targets = append(targets, "https://yamap.com"+e.Href)
+		}
+	}
+	if len(targets) > maxRemovals {
+		loggerFromContext(ctx).Printf(tr("上限 (%d件) を超えた分は次回以降に処理します。"), maxRemovals)
+		targets = targets[:maxRemovals]
+	}
+	loggerFromContext(ctx).Printf(tr("%d件のブックマークを外します。"), len(targets))
+
+	status.setPhase("reacting")
+	removed := removeBookmarks(ctx, targets)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- %d件の投稿のブックマークを外しました ---"), removed)
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// runBookmarksReaction は自分のブックマークの一覧の活動日記のうち、まだリアクションしていないものにリアクションを送る。
+// REACT_BOOKMARKS_REMOVE=true の場合は、リアクションした活動日記のブックマークを外す
+func runBookmarksReaction() error {
+	log.Println(tr("--- プログラム開始 (react-bookmarks) ---"))
+	startTime := time.Now()
+
+	postCount := 20
+	if v := os.Getenv("BOOKMARKS_POST_COUNT_TO_PROCESS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("BOOKMARKS_POST_COUNT_TO_PROCESSの値が不正です: %s"), v)
+		}
+		postCount = n
+	}
+	remove := os.Getenv("REACT_BOOKMARKS_REMOVE") == "true"
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	var activities []ActivityInfo
+	if !runCheckpoints.resuming() {
+		drv := driverFromContext(ctx)
+		url := bookmarksURL()
+		loggerFromContext(ctx).Printf(tr("ブックマークの一覧から投稿URLを収集します: %s"), url)
+		if err := runActions(ctx, drv.Navigate(url), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+			return fmt.Errorf(tr("ブックマークの一覧の読み込みに失敗: %w"), err)
+		}
+		activities, err = collectListedActivities(ctx, postCount, "")
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("ブックマークの一覧の収集中にエラーが発生しました: %v"), err)
+		}
+		loggerFromContext(ctx).Printf(tr("%d件の投稿を収集しました。リアクション処理を開始します。"), len(activities))
+	}
+	status.setPhase("reacting")
+	reactedURLs := reactToActivities(ctx, activities)
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+	if remove && len(reactedURLs) > 0 {
+		removed := removeBookmarks(ctx, reactedURLs)
+		loggerFromContext(ctx).Printf(tr("%d件の投稿のブックマークを外しました。"), removed)
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// removeBookmarks は投稿のページを順に開いてブックマークを外し、外した件数を返す
+func removeBookmarks(ctx context.Context, urls []string) int {
+	removed := 0
+	for _, url := range urls {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		if removeBookmark(ctx, url) {
+			removed++
+		}
+		pace.wait(ctx)
+	}
+	return removed
+}
+
+// removeBookmark は投稿のページを開いてブックマークのボタンを押し、ボタンがブックマークされていない状態に変わったことを確かめる。
+// 外したことを確かめられた場合に true を返す。ブックマークされていない投稿のボタンは押さない
+func removeBookmark(ctx context.Context, url string) bool {
+	drv := driverFromContext(ctx)
+	var state string
+	if err := runActions(ctx,
+		drv.Navigate(url),
+		drv.WaitVisible(layout.footer),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(bookmarkStateScript, &state),
+	); err != nil {
+		loggerFromContext(ctx).Printf(tr("ブックマークを外せませんでした (%s): %v"), url, err)
+		return false
+	}
+	switch state {
+	case "off":
+		loggerFromContext(ctx).Printf(tr("ブックマークされていないため、そのままにします: %s"), url)
+		return false
+	case "missing":
+		loggerFromContext(ctx).Printf(tr("ブックマークのボタンが見つからないため、外せませんでした: %s"), url)
+		return false
+	}
+	if err := runActions(ctx,
+		drv.Click(bookmarkButtonSelector),
+		drv.Poll(bookmarkStateScript+` === "off"`, 5*time.Second),
+	); err != nil {
+		loggerFromContext(ctx).Printf(tr("ブックマークを外したことを確認できませんでした (%s): %v"), url, err)
+		return false
+	}
+	loggerFromContext(ctx).Printf(tr("ブックマークを外しました: %s"), url)
+	return true
+}
@@ -0,0 +1,124 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// noLogin は -no-login で指定された、ログインせずに公開ページだけを読むか
+var noLogin bool
+
+// launchBrowser はブラウザを起動し、アクション全体のタイムアウトを設定したコンテキストを返す。返される関数でブラウザを終了する
+func launchBrowser() (context.Context, func(), error) {
+	allocatorCtx, cancelAllocator := context.WithTimeout(context.Background(), runTimeout()+5*time.Minute)
+	browserCtx, cancelBrowser, err := startBrowser(allocatorCtx)
+	if err != nil {
+		cancelAllocator()
+		return nil, nil, fmt.Errorf(tr("ブラウザの起動に失敗しました: %w"), err)
+	}
+	ctx, cancel := context.WithTimeout(browserCtx, runTimeout())
+	status.setCancel(cancel)
+	closeBrowser := func() {
+		cancel()
+		cancelBrowser()
+		cancelAllocator()
+	}
+	loggerFromContext(ctx).Println(tr("ブラウザの初期化完了。"))
+	status.setBrowser(ctx)
+	return ctx, closeBrowser, nil
+}
+
+// openPublicBrowser は公開ページだけを読むアクション (publicActions) のブラウザを開く。
+// -no-login の場合はログインせず、空のセッション情報を紐づける。そうでなければ openLoggedInBrowser と同じくログインする
+func openPublicBrowser() (context.Context, func(), error) {
+	if !noLogin {
+		return openLoggedInBrowser(false)
+	}
+	ctx, closeBrowser, err := launchBrowser()
+	if err != nil {
+		return nil, nil, err
+	}
+	loggerFromContext(ctx).Println(tr("-no-login のため、ログインせずに公開ページを読みます。"))
+	return withSession(ctx, &session{}), closeBrowser, nil
+}
+
+// openLoggedInBrowser はブラウザを起動してログインし、セッション情報を紐づけたコンテキストを返す。
+// 返される関数でブラウザを終了する。起動やログインに失敗した場合はブラウザを終了してからエラーを返す。
+func openLoggedInBrowser(navigateToTimeline bool) (context.Context, func(), error) {
+	ctx, closeBrowser, err := launchBrowser()
+	if err != nil {
+		return nil, nil, err
+	}
+	status.setPhase("logging-in")
+
+	email := os.Getenv("YAMAP_EMAIL")
+	password, err := resolvePassword(email)
+	if err != nil {
+		closeBrowser()
+		return nil, nil, fmt.Errorf(tr("パスワードの取得に失敗しました: %w"), err)
+	}
+	if email == "" || password == "" {
+		closeBrowser()
+		return nil, nil, errors.New(tr("環境変数 YAMAP_EMAIL, YAMAP_PASSWORD を設定してください"))
+	}
+
+	loggerFromContext(ctx).Println(tr("ログイン処理を開始します..."))
+	loginStartTime := time.Now()
+	if err := login(ctx, email, password, navigateToTimeline); err != nil {
+		closeBrowser()
+		return nil, nil, loginError(err)
+	}
+	loggerFromContext(ctx).Printf(tr("ログイン成功。処理時間: %s"), time.Since(loginStartTime))
+	return withSession(ctx, discoverSession(ctx)), closeBrowser, nil
+}
+
+// tabMemoryCheckInterval は作業用のタブのメモリ使用量を確認する間隔
+const tabMemoryCheckInterval = time.Minute
+
+// lastTabMemoryCheck は最後にタブのメモリ使用量を確認した日時
+var lastTabMemoryCheck time.Time
+
+// tabMemoryLimit は TAB_MEMORY_LIMIT_MB (既定値512) からタブを作り直すメモリ使用量の閾値 (バイト) を返す。0 の場合は監視しない
+func tabMemoryLimit(ctx context.Context) int64 {
+	limitMB := int64(512)
+	if v := os.Getenv("TAB_MEMORY_LIMIT_MB"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			loggerFromContext(ctx).Printf(tr("警告: TAB_MEMORY_LIMIT_MBの値が不正です。既定値 %d を使用します"), limitMB)
+		} else {
+			limitMB = n
+		}
+	}
+	return limitMB * 1024 * 1024
+}
+
+// recycleTabIfNeeded は投稿の合間に呼び出され、tabMemoryCheckInterval ごとに作業用のタブのメモリ使用量を確認する。
+// 閾値を超えていれば、長時間の実行でブラウザのメモリが増え続けないようタブを閉じて作り直す。ログイン状態はクッキーとして引き継がれる。
+func recycleTabIfNeeded(ctx context.Context) {
+	limit := tabMemoryLimit(ctx)
+	if limit == 0 || time.Since(lastTabMemoryCheck) < tabMemoryCheckInterval {
+		return
+	}
+	lastTabMemoryCheck = time.Now()
+	drv := driverFromContext(ctx)
+	var used int64
+	if err := runActions(ctx, drv.MemoryUsage(&used)); err != nil {
+		if !errors.Is(err, errors.ErrUnsupported) {
+			loggerFromContext(ctx).Printf(tr("タブのメモリ使用量の取得に失敗しました: %v"), err)
+		}
+		return
+	}
+	if used < limit {
+		return
+	}
+	loggerFromContext(ctx).Printf(tr("タブのメモリ使用量 (%dMB) が上限 (%dMB) を超えたため、タブを作り直します。"), used/1024/1024, limit/1024/1024)
+	if err := runActions(ctx, drv.RecycleTab()); err != nil {
+		loggerFromContext(ctx).Printf(tr("タブの作り直しに失敗しました: %v"), err)
+		return
+	}
+	status.markStep()
+}
@@ -0,0 +1,270 @@
+package yamap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// chartPath は report-chart で書き出すグラフのパス。拡張子が .png の場合はPNG、それ以外はSVG
+var chartPath string
+
+// chartWeeks は report-chart でグラフにする期間 (週数)
+var chartWeeks int
+
+// chartDay はグラフの1日分のリアクション数
+type chartDay struct {
+	Date      time.Time
+	Reactions int
+}
+
+// chartWeek はグラフの1週間分の返報の推定。
+// Authors はその週に初めてリアクションした投稿者の数、Reciprocated はそのうち後から自分をフォローした投稿者の数
+type chartWeek struct {
+	Start        time.Time
+	Authors      int
+	Reciprocated int
+}
+
+// rate は返報率 (0〜1) を返す。投稿者がいない週は -1
+func (w chartWeek) rate() float64 {
+	if w.Authors == 0 {
+		return -1
+	}
+	return float64(w.Reciprocated) / float64(w.Authors)
+}
+
+// trend は now を含む直近 weeks 週間の、日ごとのリアクション数と週ごとの返報の推定を集計する。
+// 返報は history の A/B 比較と同じく、投稿者への最初のリアクションより後に thank-followers がフォロワーとして記録したかで判定する
+func (h *historyStore) trend(weeks int, now time.Time) ([]chartDay, []chartWeek) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	y, m, d := now.Local().Date()
+	start := time.Date(y, m, d-weeks*7+1, 0, 0, 0, 0, time.Local)
+	days := make([]chartDay, weeks*7)
+	for i := range days {
+		days[i].Date = start.AddDate(0, 0, i)
+	}
+	weekly := make([]chartWeek, weeks)
+	for i := range weekly {
+		weekly[i].Start = start.AddDate(0, 0, i*7)
+	}
+	// dayIndex は日時が集計の期間の何日目かを返す。期間外の場合は -1
+	dayIndex := func(t time.Time) int {
+		t = t.Local()
+		if t.Before(start) {
+			return -1
+		}
+		i := int(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local).Sub(start).Hours()+12) / 24
+		if i >= len(days) {
+			return -1
+		}
+		return i
+	}
+
+	firstReacted := make(map[int64]time.Time)
+	for _, e := range h.Entries {
+		if i := dayIndex(e.ReactedAt); i >= 0 {
+			days[i].Reactions++
+		}
+		if first, ok := firstReacted[e.AuthorID]; e.AuthorID != 0 && (!ok || e.ReactedAt.Before(first)) {
+			firstReacted[e.AuthorID] = e.ReactedAt
+		}
+	}
+	for author, first := range firstReacted {
+		i := dayIndex(first)
+		if i < 0 {
+			continue
+		}
+		w := &weekly[i/7]
+		w.Authors++
+		if followedAt, ok := h.ThankedFollowers[author]; ok && followedAt.After(first) {
+			w.Reciprocated++
+		}
+	}
+	return days, weekly
+}
+
+// グラフの大きさ (SVG・PNG共通、ピクセル)
+const (
+	chartWidth       = 800
+	chartPanelHeight = 220
+	chartMarginLeft  = 50
+	chartMarginRight = 20
+	chartMarginTop   = 40
+	chartPanelGap    = 70
+	chartHeight      = chartMarginTop + chartPanelHeight + chartPanelGap + chartPanelHeight + 40
+)
+
+// chartLayout はグラフの各要素の座標を計算する
+type chartLayout struct {
+	days      []chartDay
+	weeks     []chartWeek
+	maxPerDay int
+}
+
+func newChartLayout(days []chartDay, weeks []chartWeek) chartLayout {
+	l := chartLayout{days: days, weeks: weeks, maxPerDay: 1}
+	for _, d := range days {
+		l.maxPerDay = max(l.maxPerDay, d.Reactions)
+	}
+	return l
+}
+
+// plotWidth はグラフの描画領域の幅
+func (l chartLayout) plotWidth() float64 {
+	return chartWidth - chartMarginLeft - chartMarginRight
+}
+
+// bar は i 日目の棒の左上の座標と幅・高さを返す
+func (l chartLayout) bar(i int) (x, y, w, h float64) {
+	slot := l.plotWidth() / float64(len(l.days))
+	h = float64(l.days[i].Reactions) / float64(l.maxPerDay) * chartPanelHeight
+	return chartMarginLeft + float64(i)*slot + slot*0.15, chartMarginTop + chartPanelHeight - h, slot * 0.7, h
+}
+
+// ratePanelTop は返報率のグラフの上端
+func (l chartLayout) ratePanelTop() float64 {
+	return chartMarginTop + chartPanelHeight + chartPanelGap
+}
+
+// point は i 週目の返報率の点の座標を返す。投稿者がいない週は ok が false
+func (l chartLayout) point(i int) (x, y float64, ok bool) {
+	rate := l.weeks[i].rate()
+	if rate < 0 {
+		return 0, 0, false
+	}
+	slot := l.plotWidth() / float64(len(l.weeks))
+	return chartMarginLeft + (float64(i)+0.5)*slot, l.ratePanelTop() + (1-rate)*chartPanelHeight, true
+}
+
+// renderChartSVG は日ごとのリアクション数 (棒グラフ) と週ごとの返報率 (折れ線グラフ) をSVGで描画する
+func renderChartSVG(days []chartDay, weeks []chartWeek) []byte {
+	l := newChartLayout(days, weeks)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`+"\n", chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="#fff"/>`+"\n")
+
+	fmt.Fprintf(&b, `<text x="%d" y="%d" font-size="14" font-weight="bold">1日あたりのリアクション数 (%s 〜 %s)</text>`+"\n",
+		chartMarginLeft, chartMarginTop-16, days[0].Date.Format("2006-01-02"), days[len(days)-1].Date.Format("2006-01-02"))
+	for _, v := range []int{0, l.maxPerDay / 2, l.maxPerDay} {
+		y := chartMarginTop + chartPanelHeight - float64(v)/float64(l.maxPerDay)*chartPanelHeight
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/><text x="%d" y="%.1f" text-anchor="end">%d</text>`+"\n",
+			chartMarginLeft, y, chartWidth-chartMarginRight, y, chartMarginLeft-6, y+4, v)
+	}
+	for i, d := range days {
+		x, y, w, h := l.bar(i)
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#2e8b57"><title>%s: %d件</title></rect>`+"\n",
+			x, y, w, h, d.Date.Format("2006-01-02"), d.Reactions)
+		if i%7 == 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%d">%s</text>`+"\n", x, chartMarginTop+chartPanelHeight+14, d.Date.Format("01/02"))
+		}
+	}
+
+	top := l.ratePanelTop()
+	fmt.Fprintf(&b, `<text x="%d" y="%.1f" font-size="14" font-weight="bold">週ごとの返報率の推定 (その週に初めてリアクションした投稿者のうち、フォローが返ってきた割合)</text>`+"\n", chartMarginLeft, top-16)
+	for _, pct := range []int{0, 50, 100} {
+		y := top + (1-float64(pct)/100)*chartPanelHeight
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/><text x="%d" y="%.1f" text-anchor="end">%d%%</text>`+"\n",
+			chartMarginLeft, y, chartWidth-chartMarginRight, y, chartMarginLeft-6, y+4, pct)
+	}
+	var path []string
+	for i, w := range weeks {
+		x := chartMarginLeft + (float64(i)+0.5)*l.plotWidth()/float64(len(weeks))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", x, top+chartPanelHeight+14, w.Start.Format("01/02"))
+		x, y, ok := l.point(i)
+		if !ok {
+			continue
+		}
+		path = append(path, fmt.Sprintf("%.1f,%.1f", x, y))
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="4" fill="#1e6fd9"><title>%s〜: %d/%d人</title></circle><text x="%.1f" y="%.1f" text-anchor="middle">%d/%d</text>`+"\n",
+			x, y, w.Start.Format("2006-01-02"), w.Reciprocated, w.Authors, x, y-8, w.Reciprocated, w.Authors)
+	}
+	if len(path) > 1 {
+		fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="#1e6fd9" stroke-width="2"/>`+"\n", strings.Join(path, " "))
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// renderChartPNG は renderChartSVG と同じグラフをPNGで描画する。文字を描画するフォントがないため、目盛りや日付のラベルは含まない
+func renderChartPNG(days []chartDay, weeks []chartWeek) ([]byte, error) {
+	l := newChartLayout(days, weeks)
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	fill := func(x, y, w, h float64, c color.Color) {
+		r := image.Rect(int(x), int(y), int(x+w+0.5), int(y+h+0.5))
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	grid := color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	for _, top := range []float64{chartMarginTop, l.ratePanelTop()} {
+		for _, f := range []float64{0, 0.5, 1} {
+			fill(chartMarginLeft, top+f*chartPanelHeight, l.plotWidth(), 1, grid)
+		}
+	}
+	for i := range days {
+		x, y, w, h := l.bar(i)
+		fill(x, y, w, h, color.RGBA{0x2e, 0x8b, 0x57, 0xff})
+	}
+	blue := color.RGBA{0x1e, 0x6f, 0xd9, 0xff}
+	prevX, prevY, havePrev := 0.0, 0.0, false
+	for i := range weeks {
+		x, y, ok := l.point(i)
+		if !ok {
+			continue
+		}
+		if havePrev {
+			// 前の点から直線を引く
+			steps := int(max(math.Abs(x-prevX), math.Abs(y-prevY)))
+			for s := 0; s <= steps; s++ {
+				t := float64(s) / float64(max(steps, 1))
+				fill(prevX+(x-prevX)*t-1, prevY+(y-prevY)*t-1, 2, 2, blue)
+			}
+		}
+		fill(x-4, y-4, 8, 8, blue)
+		prevX, prevY, havePrev = x, y, true
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// runReportChart は直近 -weeks 週間のリアクション数と返報率の推定をグラフにして -chart に書き出す
+func runReportChart() error {
+	if history == nil {
+		return errors.New(tr("グラフにする履歴として HISTORY_FILE を設定してください"))
+	}
+	if chartWeeks <= 0 {
+		return fmt.Errorf(tr("-weeks には1以上の週数を指定してください: %d"), chartWeeks)
+	}
+	days, weeks := history.trend(chartWeeks, time.Now())
+	var data []byte
+	if strings.EqualFold(filepath.Ext(chartPath), ".png") {
+		var err error
+		if data, err = renderChartPNG(days, weeks); err != nil {
+			return err
+		}
+	} else {
+		data = renderChartSVG(days, weeks)
+	}
+	if err := writeArtifact(chartPath, data); err != nil {
+		return err
+	}
+	total := 0
+	for _, d := range days {
+		total += d.Reactions
+	}
+	log.Printf(tr("直近%d週間 (リアクション %d件) のグラフを %s に保存しました。"), chartWeeks, total, chartPath)
+	return nil
+}
@@ -0,0 +1,202 @@
+package yamap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// selectorCheck は check-selectors で確かめるセレクタ1件。expr はページ上で評価して真になれば一致とみなすJavaScriptの式
+type selectorCheck struct {
+	name string
+	// selector は表に表示するセレクタ (NUXTのデータなど、JavaScriptで確認するものはそのパス)
+	selector string
+	expr     string
+}
+
+// cssSelectorCheck は selector に一致する要素があるかを確かめる selectorCheck を返す
+func cssSelectorCheck(name, selector string) selectorCheck {
+	encoded, _ := json.Marshal(selector)
+	return selectorCheck{name: name, selector: selector, expr: fmt.Sprintf(`!!document.querySelector(%s)`, encoded)}
+}
+
+// selectorCheckPage は check-selectors で開くページと、そのページで確かめるセレクタ
+type selectorCheckPage struct {
+	name   string
+	checks []selectorCheck
+}
+
+// selectorCheckTimeout は check-selectors でセレクタ1件が一致するまで待つ時間
+const selectorCheckTimeout = 10 * time.Second
+
+// selectorCheckPages は check-selectors で確かめるページとセレクタの一覧 (docs/specifications.md の「CSS/JSセレクタ一覧」に対応)
+func selectorCheckPages() []selectorCheckPage {
+	return []selectorCheckPage{
+		{"timeline", []selectorCheck{
+			cssSelectorCheck(tr("フィード"), layout.feed),
+			{tr("フィードデータ"), "window.__NUXT__.state.timeline.feeds", `Array.isArray(window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds)`},
+			cssSelectorCheck(tr("プロフィールリンク"), `header a[href^="/users/"]`),
+		}},
+		{"search", []selectorCheck{
+			cssSelectorCheck(tr("活動エントリ"), `[data-testid="activity-entry"] a[href^="/activities/"]`),
+		}},
+		{"activity", []selectorCheck{
+			cssSelectorCheck(tr("フッター"), layout.footer),
+			cssSelectorCheck(tr("リアクションのツールバー"), layout.activity.toolbar),
+			cssSelectorCheck(tr("リアクションボタン"), emojiAddButtonSelector),
+		}},
+		{"emoji-picker", []selectorCheck{
+			cssSelectorCheck(tr("絵文字ピッカー"), `.emojiPickerBody`),
+			cssSelectorCheck(tr("絵文字ボタン"), `.emojiPickerBody button, .emojiPickerBody .emojiButton, .emojiPickerBody .emoji-picker-button`),
+		}},
+	}
+}
+
+// selectorCheckResult は check-selectors の1件の結果
+type selectorCheckResult struct {
+	page  string
+	check selectorCheck
+	ok    bool
+}
+
+// runCheckSelectors はログインしてタイムライン・活動日記の検索・活動日記1件を開き、使用しているセレクタがまだ一致するかを確かめて表で出力する。
+// サイトの更新の直後などに、リアクションを送らずに短時間で動作を確かめるためのアクション。一致しないセレクタがあればエラーを返す
+func runCheckSelectors() error {
+	log.Println(tr("--- プログラム開始 (check-selectors) ---"))
+	startTime := time.Now()
+
+	ctx, closeBrowser, err := openLoggedInBrowser(true)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+	drv := driverFromContext(ctx)
+
+	var results []selectorCheckResult
+	check := func(page selectorCheckPage) {
+		failed := false
+		for _, c := range page.checks {
+			ok := runActions(ctx, drv.Poll(c.expr, selectorCheckTimeout)) == nil
+			results = append(results, selectorCheckResult{page: page.name, check: c, ok: ok})
+			failed = failed || !ok
+		}
+		if failed {
+			saveDebugSnapshot(ctx, drv, "check_selectors_"+page.name)
+		}
+		status.markStep()
+	}
+	// unchecked はページを開けなかった場合に、そのページのセレクタをすべて一致しなかったものとして記録する
+	unchecked := func(page selectorCheckPage, err error) {
+		loggerFromContext(ctx).Printf(tr("警告: %s を開けませんでした: %v"), page.name, err)
+		for _, c := range page.checks {
+			results = append(results, selectorCheckResult{page: page.name, check: c})
+		}
+	}
+	open := func(page selectorCheckPage, actions ...browserAction) bool {
+		if err := runActions(ctx, actions...); err != nil {
+			unchecked(page, err)
+			return false
+		}
+		return true
+	}
+
+	pages := selectorCheckPages()
+	// ログイン後はタイムラインを開いている
+	check(pages[0])
+
+	var entries []struct {
+		Href string `json:"href"`
+	}
+	if open(pages[1], drv.Navigate(activitySearchURL(1)), drv.WaitNetworkIdle()) {
+		check(pages[1])
+		if err := runActions(ctx, drv.Evaluate(activityEntriesScript, &entries)); err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: 活動日記の一覧を取得できませんでした: %v"), err)
+		}
+	}
+
+	activityURL := os.Getenv("CHECK_SELECTORS_ACTIVITY_URL")
+	if activityURL == "" && len(entries) > 0 {
+		activityURL = "https://yamap.com" + entries[0].Href
+	}
+	if activityURL == "" {
+		err := errors.New(tr("確認する活動日記が見つかりません"))
+		unchecked(pages[2], err)
+		unchecked(pages[3], err)
+	} else if open(pages[2], drv.Navigate(activityURL), drv.WaitNetworkIdle()) {
+		loggerFromContext(ctx).Printf(tr("活動日記 %s で確認します。"), activityURL)
+		check(pages[2])
+		// 絵文字ピッカーを開くだけで、絵文字は選ばないためリアクションは送られない
+		if open(pages[3], drv.ScrollIntoView(layout.activity.toolbar), drv.Click(layout.activity.addButton)) {
+			check(pages[3])
+		}
+	}
+
+	failed := 0
+	loggerFromContext(ctx).Println(tr("--- セレクタの確認結果 ---"))
+	for _, r := range results {
+		mark := "OK  "
+		if !r.ok {
+			mark = "NG  "
+			failed++
+		}
+		loggerFromContext(ctx).Printf("%s%-14s %-24s %s", mark, r.page, r.check.name, r.check.selector)
+	}
+	loggerFromContext(ctx).Println("---------------------------------")
+	status.setPhase("done")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	if failed > 0 {
+		msg := fmt.Sprintf(tr("%d/%d 件のセレクタが一致しませんでした。サイトの構造が変わった可能性があります。"), failed, len(results))
+		notify(ctx, "ALERT", msg)
+		return errors.New(msg)
+	}
+	loggerFromContext(ctx).Printf(tr("%d 件のセレクタがすべて一致しました。"), len(results))
+	return nil
+}
+
+// runCheckSchema はログインしてタイムラインのフィード (window.__NUXT__.state.timeline.feeds) を取得し、
+// FeedItem の形と照らし合わせる。足りないフィールドや型の違いがあれば、取得したデータを保存して ALERT の通知を送り、エラーを返す
+func runCheckSchema() error {
+	log.Println(tr("--- プログラム開始 (check-schema) ---"))
+	startTime := time.Now()
+
+	ctx, closeBrowser, err := openLoggedInBrowser(true)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+	drv := driverFromContext(ctx)
+
+	var payload json.RawMessage
+	if err := runActions(ctx,
+		drv.Poll(`window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds`, 20*time.Second),
+		drv.Evaluate(`window.__NUXT__.state.timeline.feeds`, &payload),
+	); err != nil {
+		saveDebugSnapshot(ctx, drv, "check_schema")
+		notify(ctx, "ALERT", tr("タイムラインのフィードのデータ (window.__NUXT__.state.timeline.feeds) が見つかりません。"))
+		return fmt.Errorf(tr("タイムラインのフィードを取得できませんでした: %w"), err)
+	}
+	status.markStep()
+	report, err := checkFeedSchema(payload)
+	if err != nil {
+		return err
+	}
+	logFeedSchemaReport(ctx, report)
+	status.setPhase("done")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	if !report.ok() {
+		path := debugPath(ctx, "nuxt_schema_timeline.json")
+		writeArtifact(path, payload)
+		msg := fmt.Sprintf(tr("タイムラインのフィードのデータの形が想定と異なります。YAMAPのデータが変わった可能性があります (%s)"), path)
+		notify(ctx, "ALERT", msg)
+		return errors.New(msg)
+	}
+	loggerFromContext(ctx).Println(tr("フィードのデータの形は想定どおりです。"))
+	return nil
+}
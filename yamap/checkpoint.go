@@ -0,0 +1,387 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// timelineCheckpointMaxAge は中断した収集の状態を再開に使う期限。古いタイムラインの位置は当てにならないため破棄する
+const timelineCheckpointMaxAge = time.Hour
+
+// timelineCheckpoint はタイムラインの収集の途中経過。COLLECTION_STATE_FILE に保存し、
+// ウォッチドッグによる再起動やクラッシュの後に、確認済みの投稿を読み直さずに中断した位置から収集を再開する
+type timelineCheckpoint struct {
+	path      string
+	SavedAt   time.Time      `json:"saved_at"`
+	ScrollY   int64          `json:"scroll_y"`
+	SeenIDs   []int64        `json:"seen_ids"`
+	Collected []ActivityInfo `json:"collected"`
+}
+
+// loadTimelineCheckpoint は COLLECTION_STATE_FILE から収集の途中経過を読み込む。
+// 未設定・ファイルがない・期限切れの場合は空の状態を返す (path が空の場合は保存もしない)
+func loadTimelineCheckpoint(ctx context.Context) *timelineCheckpoint {
+	c := &timelineCheckpoint{path: os.Getenv("COLLECTION_STATE_FILE")}
+	if c.path == "" {
+		return c
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			loggerFromContext(ctx).Printf(tr("収集の途中経過の読み込みに失敗しました: %v"), err)
+		}
+		return c
+	}
+	var saved timelineCheckpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		loggerFromContext(ctx).Printf(tr("収集の途中経過を解析できないため破棄します: %v"), err)
+		return c
+	}
+	if time.Since(saved.SavedAt) > timelineCheckpointMaxAge {
+		loggerFromContext(ctx).Printf(tr("収集の途中経過が %s より古いため破棄します。"), timelineCheckpointMaxAge)
+		return c
+	}
+	saved.path = c.path
+	return &saved
+}
+
+// save は収集の途中経過を書き出す。一時ファイルに書き込んでから置き換えるため、書き込み中に中断されても壊れない
+func (c *timelineCheckpoint) save(ctx context.Context) {
+	if c.path == "" {
+		return
+	}
+	c.SavedAt = time.Now()
+	data, err := json.Marshal(c)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("収集の途中経過の保存に失敗しました: %v"), err)
+		return
+	}
+	if err := writeFileAtomic(c.path, data, 0o600); err != nil {
+		loggerFromContext(ctx).Printf(tr("収集の途中経過の保存に失敗しました: %v"), err)
+	}
+}
+
+// clear は収集が完了した後に途中経過を削除する
+func (c *timelineCheckpoint) clear(ctx context.Context) {
+	if c.path == "" {
+		return
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		loggerFromContext(ctx).Printf(tr("収集の途中経過の削除に失敗しました: %v"), err)
+	}
+}
+
+// runCheckpointMaxAge は実行の途中経過を再開に使う期限。古い処理待ちの投稿は既に他の方法で処理されている可能性が高いため破棄する
+const runCheckpointMaxAge = 6 * time.Hour
+
+// runCheckpoints は RUN_CHECKPOINT が設定されている場合の、リアクション処理の途中経過の保存先。未設定の場合は nil
+var runCheckpoints *runCheckpointer
+
+// runCheckpoint はリアクション処理の途中経過。Podの退去などで実行が中断された場合に、次の実行で収集とログインを省いて続きから処理する
+type runCheckpoint struct {
+	SavedAt time.Time `json:"saved_at"`
+	Action  string    `json:"action"`
+	// Done は処理済みの投稿のURL、Queue は処理待ちの投稿 (並び順・A/Bテストの割り当てを含む)
+	Done  []string       `json:"done"`
+	Queue []ActivityInfo `json:"queue"`
+	// Reacted はリアクションに成功した投稿のURL。Succeeded, Failed, Skipped は処理結果の件数
+	Reacted   []string `json:"reacted"`
+	Succeeded int      `json:"succeeded"`
+	Failed    int      `json:"failed"`
+	Skipped   int      `json:"skipped"`
+	// Cookies はyamap.comのクッキー (Chromeのみ)。再開時にログインを省くために使う
+	Cookies []*network.Cookie `json:"cookies,omitempty"`
+}
+
+// checkpointStore は途中経過の保存先。マウントしたボリューム上のファイルか、HTTPのPUT・GET・DELETEを受け付けるオブジェクトストレージのURL
+type checkpointStore interface {
+	// load は保存されている途中経過を返す。保存されていない場合は nil
+	load() ([]byte, error)
+	save(data []byte) error
+	remove() error
+	String() string
+}
+
+// runCheckpointer は途中経過を RUN_CHECKPOINT_INTERVAL ごと (既定値 0 で投稿1件ごと) に保存する
+type runCheckpointer struct {
+	store     checkpointStore
+	interval  time.Duration
+	lastSaved time.Time
+	// resumed は前回の実行から引き継いだ途中経過。引き継ぐものがない場合や、既にリアクション処理で使った場合は nil
+	resumed *runCheckpoint
+}
+
+// newRunCheckpointerFromEnv は RUN_CHECKPOINT, RUN_CHECKPOINT_INTERVAL から途中経過の保存先を作り、同じアクションの途中経過があれば読み込む。
+// RUN_CHECKPOINT が未設定の場合は nil を返す。複数アカウントで実行する場合は、ファイル名にアカウント名を加えて分ける
+func newRunCheckpointerFromEnv(action string) (*runCheckpointer, error) {
+	target := os.Getenv("RUN_CHECKPOINT")
+	if target == "" {
+		return nil, nil
+	}
+	c := &runCheckpointer{}
+	if v := os.Getenv("RUN_CHECKPOINT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf(tr("RUN_CHECKPOINT_INTERVALの値が不正です: %s"), v)
+		}
+		c.interval = d
+	}
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		u, err := neturl.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf(tr("RUN_CHECKPOINTの値が不正です: %w"), err)
+		}
+		u.Path = accountFilePath(u.Path)
+		c.store = httpCheckpoint{url: u.String(), token: os.Getenv("RUN_CHECKPOINT_TOKEN")}
+	} else {
+		c.store = fileCheckpoint{path: accountFilePath(target)}
+	}
+
+	data, err := c.store.load()
+	if err != nil {
+		log.Printf(tr("実行の途中経過の読み込みに失敗しました: %v"), err)
+		return c, nil
+	}
+	if data == nil {
+		return c, nil
+	}
+	var saved runCheckpoint
+	switch {
+	case json.Unmarshal(data, &saved) != nil:
+		log.Print(tr("実行の途中経過を解析できないため破棄します。"))
+	case saved.Action != action:
+		log.Printf(tr("実行の途中経過は別のアクション (%s) のものため破棄します。"), saved.Action)
+	case time.Since(saved.SavedAt) > runCheckpointMaxAge:
+		log.Printf(tr("実行の途中経過が %s より古いため破棄します。"), runCheckpointMaxAge)
+	case len(saved.Queue) == 0:
+	default:
+		log.Printf(tr("%s に保存された実行の途中経過から再開します (処理済み %d 件、残り %d 件)。"),
+			saved.SavedAt.Local().Format("2006-01-02 15:04:05"), len(saved.Done), len(saved.Queue))
+		c.resumed = &saved
+		return c, nil
+	}
+	c.clear(context.Background())
+	return c, nil
+}
+
+// resuming は前回の実行の処理待ちの投稿を引き継ぐかを返す。引き継ぐ場合、投稿の収集は省ける
+func (c *runCheckpointer) resuming() bool {
+	return c != nil && c.resumed != nil
+}
+
+// resume は引き継ぐ途中経過を返し、以降は引き継がないようにする。引き継ぐものがない場合は nil
+func (c *runCheckpointer) resume() *runCheckpoint {
+	if c == nil {
+		return nil
+	}
+	cp := c.resumed
+	c.resumed = nil
+	return cp
+}
+
+// record は投稿1件の処理結果を途中経過に反映し、処理待ちの投稿を remaining にする
+func (cp *runCheckpoint) record(url string, liked bool, err error, remaining []ActivityInfo) {
+	cp.Done = append(cp.Done, url)
+	cp.Queue = remaining
+	var skipErr *skipError
+	switch {
+	case errors.As(err, &skipErr):
+		cp.Skipped++
+	case liked:
+		cp.Succeeded++
+	default:
+		cp.Failed++
+	}
+}
+
+// save は途中経過を保存する。force が false の場合は、前回の保存から RUN_CHECKPOINT_INTERVAL が経過していなければ保存しない。
+// 保存に失敗してもリアクション処理は続ける
+func (c *runCheckpointer) save(ctx context.Context, cp runCheckpoint, force bool) {
+	if c == nil || (!force && time.Since(c.lastSaved) < c.interval) {
+		return
+	}
+	cp.SavedAt = time.Now()
+	cp.Cookies = browserCookies(ctx)
+	data, err := json.Marshal(cp)
+	if err == nil {
+		err = c.store.save(data)
+	}
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 実行の途中経過の保存に失敗しました (%s): %v"), c.store, err)
+		return
+	}
+	c.lastSaved = cp.SavedAt
+}
+
+// clear はリアクション処理を終えた後に途中経過を削除する
+func (c *runCheckpointer) clear(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	if err := c.store.remove(); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 実行の途中経過の削除に失敗しました (%s): %v"), c.store, err)
+	}
+}
+
+// restoreCookies は引き継いだ途中経過のクッキーをブラウザに設定し、設定したかを返す
+func (c *runCheckpointer) restoreCookies(ctx context.Context) bool {
+	if c == nil || c.resumed == nil || len(c.resumed.Cookies) == 0 || !usingChrome() {
+		return false
+	}
+	params := make([]*network.CookieParam, 0, len(c.resumed.Cookies))
+	for _, ck := range c.resumed.Cookies {
+		p := &network.CookieParam{Name: ck.Name, Value: ck.Value, Domain: ck.Domain, Path: ck.Path,
+			Secure: ck.Secure, HTTPOnly: ck.HTTPOnly, SameSite: ck.SameSite}
+		if !ck.Session {
+			expires := cdp.TimeSinceEpoch(time.Unix(int64(ck.Expires), 0))
+			p.Expires = &expires
+		}
+		params = append(params, p)
+	}
+	if err := chromedp.Run(ctx, network.SetCookies(params)); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 途中経過のクッキーを設定できません: %v"), err)
+		return false
+	}
+	return true
+}
+
+// browserCookies はyamap.comのクッキーを返す。Firefoxの場合や取得に失敗した場合は nil
+func browserCookies(ctx context.Context) []*network.Cookie {
+	if !usingChrome() {
+		return nil
+	}
+	var cookies []*network.Cookie
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		cookies, err = network.GetCookies().WithURLs([]string{"https://yamap.com/"}).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil
+	}
+	return cookies
+}
+
+// fileCheckpoint はマウントしたボリューム上のファイルに保存する途中経過。クッキーを含むため所有者だけが読めるようにする
+type fileCheckpoint struct {
+	path string
+}
+
+func (f fileCheckpoint) load() ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f fileCheckpoint) save(data []byte) error {
+	return writeFileAtomic(f.path, data, 0o600)
+}
+
+func (f fileCheckpoint) remove() error {
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f fileCheckpoint) String() string {
+	return f.path
+}
+
+// httpCheckpoint はオブジェクトストレージなど、HTTPのPUT・GET・DELETEでオブジェクトを読み書きできるURLに保存する途中経過。
+// token が設定されている場合は Authorization: Bearer で送る
+type httpCheckpoint struct {
+	url   string
+	token string
+}
+
+func (h httpCheckpoint) do(method string, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if method != http.MethodPut && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf(tr("ステータス %d: %s"), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (h httpCheckpoint) load() ([]byte, error) {
+	data, err := h.do(http.MethodGet, nil)
+	if len(data) == 0 {
+		return nil, err
+	}
+	return data, err
+}
+
+func (h httpCheckpoint) save(data []byte) error {
+	_, err := h.do(http.MethodPut, data)
+	return err
+}
+
+func (h httpCheckpoint) remove() error {
+	_, err := h.do(http.MethodDelete, nil)
+	return err
+}
+
+// String はURLをクエリ (署名付きURLの署名など) を除いて返す
+func (h httpCheckpoint) String() string {
+	u, _, _ := strings.Cut(h.url, "?")
+	return u
+}
+
+// restoreScrollPosition はタイムラインを遅延読み込みさせながら、ページの高さが target に達するまでスクロールする
+func restoreScrollPosition(ctx context.Context, drv pageDriver, target int64) {
+	if target <= 0 {
+		return
+	}
+	loggerFromContext(ctx).Printf(tr("中断した位置 (%dpx) までスクロールします..."), target)
+	var lastHeight int64
+	for i := 0; i < 30 && ctx.Err() == nil; i++ {
+		var height int64
+		if err := runActions(ctx,
+			scrollForMore(drv, feedCountScript),
+			drv.Evaluate(`document.body.scrollHeight`, &height),
+		); err != nil {
+			loggerFromContext(ctx).Printf(tr("中断した位置までのスクロールに失敗しました: %v"), err)
+			return
+		}
+		if height >= target || height == lastHeight {
+			return
+		}
+		lastHeight = height
+	}
+}
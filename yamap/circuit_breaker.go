@@ -0,0 +1,137 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// circuitBreakerCauses は連続した失敗で実行を中止したときに推定する原因の分類と、その説明
+var circuitBreakerCauses = map[string]string{
+	"logged-out": "ログイン状態が切れている",
+	"rate-limit": "アクセス過多による制限",
+	"selector":   "ページの構造の変更 (リアクションのボタンが見つからない)",
+	"network":    "ネットワークまたはYAMAPの障害",
+	"browser":    "ブラウザの異常",
+	"unknown":    "不明",
+}
+
+// circuitBreaker は連続したリアクションの失敗を数え、threshold 件続いたら原因を推定して実行を中止する。
+// 原因がサイト側やログイン状態にある場合、続けても失敗を重ねるだけのため
+type circuitBreaker struct {
+	threshold int
+	failures  []circuitFailure
+}
+
+// circuitFailure は連続した失敗の1件分
+type circuitFailure struct {
+	url string
+	err error
+}
+
+// newCircuitBreakerFromEnv は CIRCUIT_BREAKER_THRESHOLD (既定値 8) から circuitBreaker を作成する。0 の場合は中止しない
+func newCircuitBreakerFromEnv(ctx context.Context) *circuitBreaker {
+	b := &circuitBreaker{threshold: 8}
+	if v := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			loggerFromContext(ctx).Printf(tr("警告: CIRCUIT_BREAKER_THRESHOLDの値が不正です。既定値 %d を使用します"), b.threshold)
+		} else {
+			b.threshold = n
+		}
+	}
+	return b
+}
+
+// record は投稿1件の結果を記録し、失敗が threshold 件続いた場合に true を返す。
+// スキップは投稿側の理由のため、成功と同じく連続した失敗を打ち切る。中断による失敗は数えない
+func (b *circuitBreaker) record(ctx context.Context, url string, err error) bool {
+	var skipErr *skipError
+	if err == nil || errors.As(err, &skipErr) {
+		b.failures = nil
+		return false
+	}
+	if ctx.Err() != nil || b.threshold == 0 {
+		return false
+	}
+	b.failures = append(b.failures, circuitFailure{url: url, err: err})
+	return len(b.failures) >= b.threshold
+}
+
+// probableCause は連続した失敗のエラーと表示中のページから、失敗の原因の分類を推定する
+func (b *circuitBreaker) probableCause(ctx context.Context, drv pageDriver) string {
+	for _, f := range b.failures {
+		if errors.Is(f.err, errRateLimited) {
+			return "rate-limit"
+		}
+		if errors.Is(f.err, errRendererCrashed) {
+			return "browser"
+		}
+	}
+	var page struct {
+		Path   string `json:"path"`
+		Online bool   `json:"online"`
+		Error  bool   `json:"error"`
+		Footer bool   `json:"footer"`
+	}
+	probeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := runActions(probeCtx, drv.Evaluate(`({
+		path: location.pathname,
+		online: navigator.onLine,
+		error: location.protocol === "chrome-error:" || location.href === "about:neterror",
+		footer: document.querySelector(`+jsString(layout.footer)+`) !== null,
+	})`, &page)); err != nil {
+		return "browser"
+	}
+	networkErrors := 0
+	for _, f := range b.failures {
+		if strings.Contains(f.err.Error(), "net::ERR_") || strings.Contains(f.err.Error(), "NS_ERROR_") {
+			networkErrors++
+		}
+	}
+	switch {
+	case strings.HasPrefix(page.Path, "/login"):
+		return "logged-out"
+	case !page.Online || page.Error || networkErrors > len(b.failures)/2:
+		return "network"
+	case page.Footer:
+		// 投稿ページは表示できているのにリアクションできない場合は、ボタンなどのセレクタが合っていない可能性が高い
+		return "selector"
+	}
+	return "unknown"
+}
+
+// trip は連続した失敗による中止の診断情報 (失敗の一覧・推定した原因・直近のログと、表示中のページのスクリーンショット・HTML) を
+// circuit_breaker_<日時> として保存し、通知を送って実行全体を中止する
+func (b *circuitBreaker) trip(ctx context.Context) {
+	drv := driverFromContext(ctx)
+	cause := b.probableCause(ctx, drv)
+	label := tr(circuitBreakerCauses[cause])
+	loggerFromContext(ctx).Printf(tr("リアクションが %d 件続けて失敗したため、実行を中止します。推定される原因: %s (%s)"), len(b.failures), label, cause)
+
+	name := "circuit_breaker_" + time.Now().Format("20060102_150405")
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "time: %s\naction: %s\ncause: %s (%s)\nthreshold: %d\n\n--- failures ---\n", time.Now().Format(time.RFC3339), status.report().Action, cause, label, b.threshold)
+	for _, f := range b.failures {
+		fmt.Fprintf(&sb, "%s: %v\n", f.url, f.err)
+	}
+	sb.WriteString("\n--- recent logs ---\n")
+	for _, line := range recentLogs.snapshot() {
+		sb.WriteString(line + "\n")
+	}
+	path := debugPath(ctx, name+".txt")
+	if err := writeArtifact(path, []byte(sb.String())); err != nil {
+		loggerFromContext(ctx).Printf(tr("診断情報の保存に失敗しました: %v"), err)
+	} else {
+		loggerFromContext(ctx).Printf(tr("診断情報を %s に保存しました。"), path)
+	}
+	saveDebugSnapshot(ctx, drv, name)
+
+	notify(ctx, "ALERT", fmt.Sprintf(tr("リアクションが %d 件続けて失敗したため、%s の実行を中止しました。推定される原因: %s"), len(b.failures), status.report().Action, label))
+	status.abort(fmt.Errorf("%w (%s)", errCircuitBreakerOpen, label))
+}
@@ -0,0 +1,639 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// Main はコマンドラインの引数と環境変数に従ってアクションを実行し、プロセスを終了する。
+// 他のプログラムから機能を使う場合は Main ではなく NewClient を使う
+func Main() {
+	log.SetOutput(logOutput(console))
+	// 同時に動く実行や過去の実行のログを見分けられるよう、日時の後に実行IDを付ける
+	log.SetPrefix(runID + " ")
+	log.SetFlags(log.Flags() | log.Lmsgprefix)
+	defer recoverCrash()
+
+	// コマンドライン引数の解析
+	action := flag.String("action", "", "実行するアクション (例: react-timeline)")
+	flag.StringVar(&browserKind, "browser", "chrome", "使用するブラウザ (chrome, firefox, replay)。replay はブラウザを起動せず、SESSION_REPLAY_FILE に記録した操作を再現する")
+	flag.DurationVar(&spreadWindow, "spread", 0, "リアクションなどを続けて送らず、指定した時間 (例: 2h) の中のランダムな時刻に分散させる")
+	flag.DurationVar(&maxRuntime, "max-runtime", 0, "最大実行時間 (例: 30m)。経過後は新しい投稿の処理を始めず、処理中の投稿を終えてから結果を出力して終了する")
+	flag.BoolVar(&includeMoments, "moments", false, "react-timeline・plan・collect でタイムラインのモーメントもリアクションの対象にする")
+	flag.DurationVar(&maxAge, "max-age", 0, "react-timeline・plan・collect でタイムラインから収集する投稿の古さの上限 (例: 24h)。古い投稿は対象から除き、読み込んだ投稿がすべて古くなったらスクロールを終える")
+	flag.BoolVar(&noLogin, "no-login", false, "ログインせずに公開ページだけを読む (conditions, watch のみ)。資格情報は不要")
+	flag.BoolVar(&passwordFromStdin, "password-stdin", false, "YAMAP_PASSWORD の代わりに標準入力の1行目からパスワードを読み込む")
+	flag.StringVar(&planPath, "plan", "plan.json", "plan で書き出し、apply で読み込むプランファイルのパス")
+	flag.StringVar(&saveFeedPath, "save-feed", "", "react-timeline で読み込んだフィードを保存するファイルのパス (.atom はAtom、.rss はRSS、それ以外はJSON。export-feed では出力先、既定値 feed.json)")
+	flag.StringVar(&historySince, "since", "", "history・unreact で対象にする期間の開始 (例: 2026-10-01, 7d, 48h)")
+	flag.StringVar(&historyUntil, "until", "", "history・unreact で対象にする期間の終了 (例: 2026-10-02, 1d)。日付の場合はその日の終わりまでを含む")
+	flag.StringVar(&historyAuthor, "author", "", "history・unreact で対象にする投稿者のIDまたは名前 (名前は部分一致)")
+	flag.StringVar(&historyAction, "history-action", "", "history・unreact で対象にするリアクションを送ったアクション (例: react-timeline)")
+	flag.Int64Var(&communityID, "community", 0, "react-community でリアクションするコミュニティのID")
+	flag.StringVar(&unreactURLsPath, "urls", "", "unreact でリアクションを取り消す投稿URLを1行に1件記載したファイルのパス")
+	verbose := flag.Bool("v", false, "ブラウザの操作を1件ずつ、かかった時間とともにログに出す")
+	debugVerbose := flag.Bool("vv", false, "-v に加え、Chromeとの間のCDPのメッセージをそのままログに出す")
+	quiet := flag.Bool("quiet", false, "実行中のログを出さず、終了時に実行の結果の要約とエラーだけを表示する")
+	colorMode := flag.String("color", "auto", "ログの色分け (auto, always, never)。auto では端末に出力し、NO_COLOR が未設定の場合に色を付ける")
+	tui := flag.Bool("tui", false, "ログの代わりに処理状況をまとめて表示するダッシュボードを端末に表示する")
+	flag.StringVar(&configPath, "config", "", "設定ファイル (JSON) のパス。絵文字の選択ルールなど、環境変数で表しにくい設定を記述する")
+	flag.StringVar(&logLang, "lang", "ja", "ログと結果の表示に使う言語 (ja, en)")
+	flag.StringVar(&profileDir, "profile-dir", "", "実行をまたいで使い続けるブラウザのプロファイル (クッキー・キャッシュ・localStorage) のディレクトリ")
+	mobile := flag.Bool("mobile", false, "スマートフォンの端末をエミュレートし、軽量なスマートフォン版のページで操作する (Firefoxでは使用不可)")
+	replayDir := flag.String("replay", "", "yamap.com の代わりに、このディレクトリに保存したページ (フィクスチャ) をローカルのサーバーから返してオフラインで実行する (Chromeのみ)")
+	saveFixturesDir := flag.String("save-fixtures", "", "読み込んだ yamap.com のページを -replay で使うフィクスチャとしてこのディレクトリに保存する (Chromeのみ)")
+	harPath := flag.String("har", "", "実行中の通信を記録するHARファイルのパス (Chromeのみ)")
+	recordDir := flag.String("record", "", "ブラウザの画面を録画したフレームを保存するディレクトリ (Chromeのみ)")
+	flag.StringVar(&cpuProfilePath, "cpuprofile", "", "CPUプロファイルを書き出すファイルのパス")
+	flag.StringVar(&memProfilePath, "memprofile", "", "終了時にヒーププロファイルを書き出すファイルのパス")
+	flag.StringVar(&debugDir, "debug-dir", "", "デバッグ情報を実行ごとのサブディレクトリに分けて保存するディレクトリ (DEBUG_DIR より優先)")
+	flag.DurationVar(&debugMaxAge, "debug-max-age", 7*24*time.Hour, "-debug-dir の実行ごとのサブディレクトリを残す期間 (0 で無期限)")
+	flag.Int64Var(&debugMaxSizeMB, "debug-max-size", 500, "-debug-dir 全体の上限のサイズ (MB)。超えた場合は古い実行のサブディレクトリから削除する (0 で無制限)")
+	flag.StringVar(&cookiesPath, "cookies", "", "auth-import-cookies で取り込み、auth-export-cookies で書き出すクッキーのファイル (JSON形式、拡張子 .txt はNetscape形式) のパス")
+	flag.StringVar(&reportPath, "report", "", "実行の結果・リアクションした投稿・スキップの理由・失敗時のスクリーンショットをまとめたレポートのパス (.md はMarkdown、.html はHTML)")
+	flag.StringVar(&chartPath, "chart", "reaction-chart.svg", "report-chart で書き出すグラフのパス (.svg はSVG、.png はPNG)")
+	flag.IntVar(&chartWeeks, "weeks", 8, "report-chart でグラフにする直近の週数")
+	flag.StringVar(&gpxPath, "gpx", "", "activity-upload で活動日記の下書きにするGPXファイルのパス")
+	flag.StringVar(&uploadTitle, "title", "", "activity-upload で付ける活動日記のタイトル (既定値はGPXファイルのトラックの名前)")
+	flag.StringVar(&activityEditsPath, "edits", "activity-edits.json", "activity-edit で編集する活動日記と、タイトル・本文のテンプレートを記述したファイル (JSON) のパス")
+	flag.BoolVar(&activityEditsDryRun, "dry-run", false, "activity-edit で変更後のタイトル・本文をログに出すだけで保存しない")
+	flag.StringVar(&planTemplatePath, "template", "plan-template.yaml", "plan-create で登山計画のフォームに入力する内容を記述したテンプレート (YAML) のパス")
+	account := flag.String("account", "", "設定ファイルの accounts のうち、このアカウントだけで実行する (YAMAP_ACCOUNT と同じ)")
+	flag.StringVar(&outputFormat, "output", "text", "進捗の出力形式 (text, ndjson)。ndjson では標準出力にイベントを1行ずつJSONで出力する")
+	flag.Parse()
+	status.setAction(*action)
+	if *account != "" {
+		os.Setenv("YAMAP_ACCOUNT", *account)
+	}
+	if logLang != "ja" && logLang != "en" {
+		log.Fatalf("-lang には ja または en を指定してください: %s", logLang)
+	}
+	if enabled, err := consoleColorEnabled(*colorMode); err != nil {
+		log.Fatal(err)
+	} else {
+		console.color = enabled
+	}
+	switch {
+	case *quiet && (*verbose || *debugVerbose):
+		log.Fatal(tr("-quiet と -v・-vv は同時に使えません。"))
+	case *quiet && *tui:
+		log.Fatal(tr("-quiet と -tui は同時に使えません。"))
+	case *quiet:
+		logVerbosity = verbosityQuiet
+	case *debugVerbose:
+		logVerbosity = verbosityDebug
+		if !usingChrome() {
+			log.Print(tr("警告: CDPのメッセージはChromeでのみ出力できます。-vv は -v と同じになります。"))
+		}
+	case *verbose:
+		logVerbosity = verbosityVerbose
+	}
+	if *mobile {
+		if browserKind == "firefox" {
+			log.Fatal(tr("-mobile はFirefoxでは使用できません。"))
+		}
+		layout = mobileLayout
+	}
+	switch outputFormat {
+	case "text":
+	case "ndjson":
+		if *tui {
+			log.Fatal(tr("-output ndjson と -tui は同時に使えません。"))
+		}
+		events.ndjson = os.Stdout
+	default:
+		log.Fatalf(tr("-output には text または ndjson を指定してください: %s"), outputFormat)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println(tr("警告: .envファイルが見つからないか、読み込みに失敗しました。"))
+	}
+	// 設定を読み込んだ後は RESULT の行を必ず出すよう、log.Fatal ではなく exitOnError で終了処理をしてから終了する
+	if configPath != "" && *action != "config-validate" {
+		if err := loadConfig(configPath); err != nil {
+			exitOnError(fmt.Errorf(tr("設定ファイルの読み込みに失敗しました: %w"), err))
+		}
+	}
+	if !runRecordExcludedActions[*action] && *action != "" {
+		if open, next := withinOperatingHours(context.Background(), time.Now()); !open {
+			log.Printf(tr("稼働時間 (OPERATING_HOURS=%s) の外のため実行しません。次に稼働できるのは %s からです。"), os.Getenv("OPERATING_HOURS"), next.Format("2006-01-02 15:04 MST"))
+			printResultLine(status.result(), 0)
+			return
+		}
+	}
+	if name := os.Getenv("YAMAP_ACCOUNT"); name != "" {
+		if err := applyAccount(name); err != nil {
+			exitOnError(err)
+		}
+	} else if len(config.Accounts) > 0 && multiAccountActions[*action] {
+		if *tui || passwordFromStdin {
+			exitOnError(errors.New(tr("複数のアカウントで実行する場合は -tui と -password-stdin を使えません。")))
+		}
+		os.Exit(runAccounts(config.Accounts))
+	}
+
+	if noLogin && !publicActions[*action] {
+		exitOnError(errors.New(tr("-no-login は公開ページだけを読むアクション (conditions, watch) でのみ使えます。")))
+	}
+	if !credentialsFreeActions[*action] && !noLogin {
+		if err := loadCredentialsFile(); err != nil {
+			exitOnError(fmt.Errorf(tr("資格情報ファイルの読み込みに失敗しました: %w"), err))
+		}
+	}
+	// アカウントと資格情報ファイルを反映した後の値を、ログやデバッグ情報から伏せる
+	redaction.addFromEnv()
+
+	pace = newPacerFromEnv(context.Background())
+	limit, err := newRateLimiterFromEnv()
+	if err != nil {
+		exitOnError(err)
+	}
+	requestLimit = limit
+	backend, err := historyBackendFromEnv()
+	if err != nil {
+		exitOnError(err)
+	}
+	if backend != nil {
+		if v := os.Getenv("HISTORY_LOCK_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				exitOnError(fmt.Errorf(tr("HISTORY_LOCK_TIMEOUTの値が不正です: %s"), v))
+			}
+			historyLockTimeout = d
+		}
+		// 履歴を書き換えるアクションは、重なった実行が互いの記録を上書きしないよう実行の間ロックを持つ。
+		// 読み込みだけのアクションは一時ファイルからの置き換えで完全な内容を読めるため、ロックしない。
+		// roundLockedActions は処理の区切りごとに withHistoryLock でロックする
+		if !runRecordExcludedActions[*action] && !roundLockedActions[*action] {
+			lock, err := backend.lock(*action, historyLockTimeout)
+			if err != nil {
+				log.Printf(tr("リアクション履歴のロックを取得できません: %v"), err)
+				code := 1
+				if errors.Is(err, errHistoryLocked) {
+					code = exitCodeHistoryLocked
+				}
+				printResultLine(status.result(), code)
+				os.Exit(code)
+			}
+			heldHistoryLock = lock
+		}
+		h, err := loadHistory(backend)
+		if err != nil {
+			exitOnError(fmt.Errorf(tr("リアクション履歴の読み込みに失敗しました: %w"), err))
+		}
+		history = h
+	}
+	pruneDebugDir()
+	if dedupe, err = newRedisDedupeFromEnv(); err != nil {
+		exitOnError(err)
+	}
+	if artifacts, err = newArtifactUploaderFromEnv(); err != nil {
+		exitOnError(err)
+	}
+	if tracer, err = newSpanExporterFromEnv(); err != nil {
+		exitOnError(err)
+	}
+	if !runRecordExcludedActions[*action] {
+		if runCheckpoints, err = newRunCheckpointerFromEnv(*action); err != nil {
+			exitOnError(err)
+		}
+	}
+	if addr := os.Getenv("HEALTH_ADDR"); addr != "" {
+		startHealthServer(addr)
+	}
+	if addr := os.Getenv("PPROF_ADDR"); addr != "" {
+		startPprofServer(addr)
+	}
+	if err := startProfiling(); err != nil {
+		exitOnError(err)
+	}
+	if *replayDir != "" || *saveFixturesDir != "" {
+		if !usingChrome() {
+			exitOnError(errors.New(tr("-replay と -save-fixtures はChromeでのみ使えます。")))
+		}
+		if *replayDir != "" && *saveFixturesDir != "" {
+			exitOnError(errors.New(tr("-replay と -save-fixtures は同時に使えません。")))
+		}
+		var err error
+		if *replayDir != "" {
+			if fixtureReplay, err = newFixtureServer(*replayDir); err != nil {
+				exitOnError(err)
+			}
+			log.Printf(tr("-replay: yamap.com の代わりに %s のフィクスチャを返し、それ以外の通信は行いません。"), *replayDir)
+		} else if fixtureSaver, err = newFixtureRecorder(*saveFixturesDir); err != nil {
+			exitOnError(err)
+		}
+	}
+	if *harPath != "" {
+		if !usingChrome() {
+			log.Print(tr("警告: -har はChromeでのみ使えます。通信は記録しません。"))
+		} else {
+			harLog = newHARRecorder(*harPath)
+		}
+	}
+	if *recordDir != "" {
+		if !usingChrome() {
+			log.Print(tr("警告: -record はChromeでのみ使えます。画面は録画しません。"))
+		} else {
+			rec, err := newScreenRecorder(*recordDir)
+			if err != nil {
+				exitOnError(err)
+			}
+			screenRec = rec
+		}
+	}
+
+	// 実行中のエラーは、ブラウザを終了する defer を済ませるため runAction から戻してから扱う
+	var runErr error
+	run := func() { runErr = runAction(*action) }
+	if tracer != nil {
+		rootSpan = newTraceSpan("run "+*action, nil)
+		rootSpan.setAttr("run.id", runID)
+		rootSpan.setAttr("run.action", *action)
+	}
+	quietLogs()
+	if *tui {
+		runWithDashboard(run)
+	} else {
+		run()
+	}
+	rootSpan.finish(runErr)
+	if runErr == nil && !runRecordExcludedActions[*action] {
+		var err error
+		if roundLockedActions[*action] && history != nil {
+			// withHistoryLock は履歴を読み込み直すため、読み込み後の history に記録する
+			err = withHistoryLock(*action, func() error { return history.recordRun(status.result()) })
+		} else {
+			err = history.recordRun(status.result())
+		}
+		if err != nil {
+			log.Printf(tr("警告: 実行結果の履歴への保存に失敗しました: %v"), err)
+		}
+		if err := exportReactionsToSheet(status.runReactions()); err != nil {
+			log.Printf(tr("警告: Googleスプレッドシートへの書き出しに失敗しました: %v"), err)
+		}
+		if artifacts != nil {
+			report, _ := json.MarshalIndent(map[string]interface{}{"run": status.result(), "reactions": status.runReactions()}, "", "  ")
+			artifacts.upload("run-report.json", report)
+		}
+	}
+	if runErr == nil {
+		runErr = abortError()
+	}
+	if reportPath != "" && !runRecordExcludedActions[*action] {
+		if err := writeRunReport(reportPath, status.result(), status.runReactions(), status.postOutcomes(), runErr); err != nil {
+			log.Printf(tr("警告: 実行のレポートの保存に失敗しました: %v"), err)
+		} else {
+			log.Printf(tr("実行のレポートを %s に保存しました。"), reportPath)
+		}
+	}
+	if !runRecordExcludedActions[*action] {
+		checkAlerts(status.result())
+		logRunSummary(status.result())
+	}
+	logWarningSummary()
+	exitOnError(runErr)
+	beforeExit()
+	printResultLine(status.result(), 0)
+}
+
+// runAction は -action で指定されたアクションを実行する
+func runAction(action string) error {
+	switch action {
+	case "react-timeline":
+		log.Println(tr("アクション: react-timeline を実行します。"))
+		return runTimelineReaction()
+	case "react-activities":
+		log.Println(tr("アクション: react-activities を実行します。"))
+		return runActivitiesReaction()
+	case "plan":
+		log.Println(tr("アクション: plan を実行します。"))
+		return runPlan()
+	case "apply":
+		log.Println(tr("アクション: apply を実行します。"))
+		return runApply()
+	case "collect":
+		log.Println(tr("アクション: collect を実行します。"))
+		return runCollect()
+	case "react":
+		log.Println(tr("アクション: react を実行します。"))
+		return runReact()
+	case "export-feed":
+		log.Println(tr("アクション: export-feed を実行します。"))
+		return runExportFeed()
+	case "bench":
+		log.Println(tr("アクション: bench を実行します。"))
+		return runBench()
+	case "domo-stats":
+		log.Println(tr("アクション: domo-stats を実行します。"))
+		return runDomoStats()
+	case "notifications-export":
+		log.Println(tr("アクション: notifications-export を実行します。"))
+		return runNotificationsExport()
+	case "snapshot":
+		log.Println(tr("アクション: snapshot を実行します。"))
+		return runSnapshot()
+	case "crosspost":
+		log.Println(tr("アクション: crosspost を実行します。"))
+		return runCrosspost()
+	case "plans-export":
+		log.Println(tr("アクション: plans-export を実行します。"))
+		return runPlansExport()
+	case "plan-create":
+		log.Println(tr("アクション: plan-create を実行します。"))
+		return runPlanCreate()
+	case "watch":
+		log.Println(tr("アクション: watch を実行します。"))
+		return runWatch()
+	case "conditions":
+		log.Println(tr("アクション: conditions を実行します。"))
+		return runConditions()
+	case "sync-strava":
+		log.Println(tr("アクション: sync-strava を実行します。"))
+		return runSyncStrava()
+	case "backup":
+		log.Println(tr("アクション: backup を実行します。"))
+		return runBackup()
+	case "diff-followers":
+		log.Println(tr("アクション: diff-followers を実行します。"))
+		return runDiffFollowers()
+	case "react-followers":
+		log.Println(tr("アクション: react-followers を実行します。"))
+		return runFollowersReaction()
+	case "react-community":
+		log.Println(tr("アクション: react-community を実行します。"))
+		return runCommunityReaction()
+	case "react-bookmarks":
+		log.Println(tr("アクション: react-bookmarks を実行します。"))
+		return runBookmarksReaction()
+	case "bookmarks-clean":
+		log.Println(tr("アクション: bookmarks-clean を実行します。"))
+		return runBookmarksClean()
+	case "follow-search":
+		log.Println(tr("アクション: follow-search を実行します。"))
+		return runFollowSearch()
+	case "follow-commenters":
+		log.Println(tr("アクション: follow-commenters を実行します。"))
+		return runFollowCommenters()
+	case "scan-comments":
+		log.Println(tr("アクション: scan-comments を実行します。"))
+		return runScanComments()
+	case "unreact":
+		log.Println(tr("アクション: unreact を実行します。"))
+		return runUnreact()
+	case "thank-followers":
+		log.Println(tr("アクション: thank-followers を実行します。"))
+		return runThankFollowers()
+	case "activity-upload":
+		log.Println(tr("アクション: activity-upload を実行します。"))
+		return runActivityUpload()
+	case "activity-photos":
+		log.Println(tr("アクション: activity-photos を実行します。"))
+		return runActivityPhotos()
+	case "activity-edit":
+		log.Println(tr("アクション: activity-edit を実行します。"))
+		return runActivityEdit()
+	case "profile-update":
+		log.Println(tr("アクション: profile-update を実行します。"))
+		return runProfileUpdate()
+	case "search-export":
+		log.Println(tr("アクション: search-export を実行します。"))
+		return runSearchExport()
+	case "engagers-export":
+		log.Println(tr("アクション: engagers-export を実行します。"))
+		return runEngagersExport()
+	case "selftest":
+		log.Println(tr("アクション: selftest を実行します。"))
+		return runSelfTest()
+	case "check-selectors":
+		log.Println(tr("アクション: check-selectors を実行します。"))
+		return runCheckSelectors()
+	case "check-schema":
+		log.Println(tr("アクション: check-schema を実行します。"))
+		return runCheckSchema()
+	case "version":
+		return runVersion()
+	case "update":
+		log.Println(tr("アクション: update を実行します。"))
+		return runUpdate()
+	case "install-browser":
+		log.Println(tr("アクション: install-browser を実行します。"))
+		return runInstallBrowser()
+	case "config-validate":
+		return runConfigValidate()
+	case "completion":
+		return runCompletion(flag.Arg(0))
+	case "doctor":
+		log.Println(tr("アクション: doctor を実行します。"))
+		return runDoctor()
+	case "dashboard":
+		log.Println(tr("アクション: dashboard を実行します。"))
+		return runWebDashboard()
+	case "history":
+		if err := runHistoryQuery(); err != nil {
+			return fmt.Errorf(tr("履歴の集計に失敗しました: %w"), err)
+		}
+	case "report-chart":
+		if err := runReportChart(); err != nil {
+			return fmt.Errorf(tr("グラフの作成に失敗しました: %w"), err)
+		}
+	case "auth-set":
+		log.Println(tr("アクション: auth-set を実行します。"))
+		if err := runAuthSet(); err != nil {
+			return fmt.Errorf(tr("資格情報ファイルの作成に失敗しました: %w"), err)
+		}
+	case "auth-import-cookies":
+		log.Println(tr("アクション: auth-import-cookies を実行します。"))
+		if err := runImportCookies(); err != nil {
+			return fmt.Errorf(tr("クッキーの取り込みに失敗しました: %w"), err)
+		}
+	case "auth-export-cookies":
+		log.Println(tr("アクション: auth-export-cookies を実行します。"))
+		if err := runExportCookies(); err != nil {
+			return fmt.Errorf(tr("クッキーの書き出しに失敗しました: %w"), err)
+		}
+	case "":
+		log.Println(tr("利用可能なアクション: ") + availableActions)
+		return errors.New(tr("-actionフラグが指定されていません。実行するアクションを指定してください"))
+	default:
+		log.Println(tr("利用可能なアクション: ") + availableActions)
+		return fmt.Errorf(tr("不明なアクション '%s' が指定されました"), action)
+	}
+	return nil
+}
+
+// publicActions はログインせずに公開ページだけを読んで実行できるアクション (-no-login)
+var publicActions = map[string]bool{"conditions": true, "watch": true}
+
+// credentialsFreeActions は資格情報ファイルを読み込まずに実行するアクション (資格情報を作るもの、使わないもの)
+var credentialsFreeActions = map[string]bool{"auth-set": true, "auth-import-cookies": true, "config-validate": true, "completion": true}
+
+// runRecordExcludedActions は終了時に実行の記録を履歴に残さないアクション (履歴の参照・資格情報の設定・動作確認のみを行うもの)
+var runRecordExcludedActions = map[string]bool{"dashboard": true, "history": true, "report-chart": true, "auth-set": true,
+	"auth-import-cookies": true, "auth-export-cookies": true, "selftest": true, "check-selectors": true, "check-schema": true, "doctor": true, "version": true, "update": true, "install-browser": true, "config-validate": true, "completion": true}
+
+// availableActions は -action に指定できるアクションの一覧 (エラーメッセージ用)
+const availableActions = "react-timeline, react-activities, react-community, react-bookmarks, react-followers, watch, conditions, plan, apply, collect, react, unreact, follow-search, follow-commenters, scan-comments, thank-followers, export-feed, domo-stats, search-export, engagers-export, bookmarks-clean, profile-update, notifications-export, snapshot, diff-followers, backup, crosspost, sync-strava, plans-export, plan-create, activity-upload, activity-photos, activity-edit, bench, selftest, check-selectors, check-schema, doctor, version, update, install-browser, config-validate, completion, dashboard, history, report-chart, auth-set, auth-import-cookies, auth-export-cookies"
+
+// completionFileFlags はシェルの補完でファイル名を補うフラグ
+var completionFileFlags = map[string]bool{"report": true, "chart": true, "template": true, "config": true, "plan": true, "save-feed": true, "urls": true, "har": true, "cpuprofile": true, "replay": true, "save-fixtures": true, "memprofile": true, "cookies": true, "gpx": true, "edits": true}
+
+// completionDirFlags はシェルの補完でディレクトリ名を補うフラグ
+var completionDirFlags = map[string]bool{"profile-dir": true, "record": true, "debug-dir": true}
+
+// completionChoices はシェルの補完で決まった値の中から補うフラグと、その値
+func completionChoices() map[string]string {
+	return map[string]string{
+		"action":  strings.ReplaceAll(availableActions, ",", ""),
+		"browser": "chrome firefox replay",
+		"lang":    "ja en",
+		"output":  "text ndjson",
+	}
+}
+
+// completionFlag はシェルの補完スクリプトに含めるフラグ
+type completionFlag struct {
+	name  string
+	usage string
+	bool  bool
+}
+
+// completionFlags は定義されたフラグを名前の順に返す
+func completionFlags() []completionFlag {
+	var flags []completionFlag
+	flag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, bool: ok && b.IsBoolFlag()})
+	})
+	return flags
+}
+
+// runCompletion は引数 (bash, zsh, fish) のシェルの補完スクリプトを標準出力に書き出す。
+// 引数が accounts の場合は、補完スクリプトから呼び出されて -config の設定ファイルのアカウント名を1行ずつ書き出す
+func runCompletion(shell string) error {
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion())
+	case "zsh":
+		fmt.Print(zshCompletion())
+	case "fish":
+		fmt.Print(fishCompletion())
+	case "accounts":
+		for _, acc := range config.Accounts {
+			fmt.Println(acc.Name)
+		}
+	default:
+		return fmt.Errorf(tr("completion には bash, zsh, fish のいずれかを指定してください: %s"), shell)
+	}
+	return nil
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	var names, fileFlags, dirFlags []string
+	for _, f := range completionFlags() {
+		names = append(names, "-"+f.name)
+		if completionFileFlags[f.name] {
+			fileFlags = append(fileFlags, "-"+f.name)
+		}
+		if completionDirFlags[f.name] {
+			dirFlags = append(dirFlags, "-"+f.name)
+		}
+	}
+	b.WriteString("# yamap-auto-domo のbashの補完 (source <(yamap-auto-domo -action completion bash))\n")
+	b.WriteString("_yamap_auto_domo() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\" cfg i\n")
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        [[ \"${COMP_WORDS[i]}\" == -config ]] && cfg=\"${COMP_WORDS[i+1]}\"\n")
+	b.WriteString("    done\n")
+	b.WriteString("    case \"$prev\" in\n")
+	choices := completionChoices()
+	for _, name := range []string{"action", "browser", "lang", "output"} {
+		fmt.Fprintf(&b, "    -%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n", name, choices[name])
+	}
+	b.WriteString("    -account) COMPREPLY=($(compgen -W \"$(\"${COMP_WORDS[0]}\" -action completion -config \"$cfg\" accounts 2>/dev/null)\" -- \"$cur\")); return ;;\n")
+	fmt.Fprintf(&b, "    %s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n", strings.Join(fileFlags, "|"))
+	fmt.Fprintf(&b, "    %s) COMPREPLY=($(compgen -d -- \"$cur\")); return ;;\n", strings.Join(dirFlags, "|"))
+	b.WriteString("    esac\n")
+	fmt.Fprintf(&b, "    [[ \"$cur\" == -* ]] && COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString("}\n")
+	b.WriteString("complete -F _yamap_auto_domo yamap-auto-domo\n")
+	return b.String()
+}
+
+func zshCompletion() string {
+	var b strings.Builder
+	b.WriteString("#compdef yamap-auto-domo\n")
+	b.WriteString("# yamap-auto-domo のzshの補完 (fpath のディレクトリに _yamap-auto-domo として保存する)\n\n")
+	b.WriteString("_yamap_auto_domo_accounts() {\n")
+	b.WriteString("    local i=${words[(I)-config]} cfg\n")
+	b.WriteString("    (( i )) && cfg=${words[i+1]}\n")
+	b.WriteString("    local -a accounts\n")
+	b.WriteString("    accounts=(${(f)\"$(${words[1]} -action completion -config \"$cfg\" accounts 2>/dev/null)\"})\n")
+	b.WriteString("    compadd -a accounts\n")
+	b.WriteString("}\n\n")
+	b.WriteString("_arguments \\\n")
+	escape := strings.NewReplacer("[", `\[`, "]", `\]`, "'", `'\''`)
+	choices := completionChoices()
+	for _, f := range completionFlags() {
+		spec := fmt.Sprintf("-%s[%s]", f.name, escape.Replace(f.usage))
+		switch {
+		case f.bool:
+		case f.name == "account":
+			spec += ":account:_yamap_auto_domo_accounts"
+		case choices[f.name] != "":
+			spec += fmt.Sprintf(":%s:(%s)", f.name, choices[f.name])
+		case completionFileFlags[f.name]:
+			spec += ":file:_files"
+		case completionDirFlags[f.name]:
+			spec += ":directory:_files -/"
+		default:
+			spec += ":" + f.name + ": "
+		}
+		fmt.Fprintf(&b, "    '%s' \\\n", spec)
+	}
+	b.WriteString("    && return 0\n")
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	b.WriteString("# yamap-auto-domo のfishの補完 (~/.config/fish/completions/yamap-auto-domo.fish に保存する)\n")
+	b.WriteString("function __yamap_auto_domo_accounts\n")
+	b.WriteString("    set -l tokens (commandline -opc)\n")
+	b.WriteString("    set -l cfg\n")
+	b.WriteString("    set -l idx (contains -i -- -config $tokens)\n")
+	b.WriteString("    if test -n \"$idx\"\n")
+	b.WriteString("        set cfg $tokens[(math $idx + 1)]\n")
+	b.WriteString("    end\n")
+	b.WriteString("    $tokens[1] -action completion -config \"$cfg\" accounts 2>/dev/null\n")
+	b.WriteString("end\n")
+	choices := completionChoices()
+	for _, f := range completionFlags() {
+		line := "complete -c yamap-auto-domo -o " + f.name
+		switch {
+		case f.bool:
+		case f.name == "account":
+			line += ` -x -a "(__yamap_auto_domo_accounts)"`
+		case choices[f.name] != "":
+			line += fmt.Sprintf(" -x -a %q", choices[f.name])
+		case completionFileFlags[f.name]:
+			line += " -r -F"
+		case completionDirFlags[f.name]:
+			line += ` -x -a "(__fish_complete_directories)"`
+		default:
+			line += " -x"
+		}
+		fmt.Fprintf(&b, "%s -d '%s'\n", line, strings.ReplaceAll(f.usage, "'", `\'`))
+	}
+	return b.String()
+}
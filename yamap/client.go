@@ -0,0 +1,276 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Client は他のGoのプログラムからYAMAPへのログイン・タイムラインの収集・リアクションを行うためのクライアント。
+// CLIと同じ処理を使うため、ペースの調整や履歴による重複の防止もCLIと同じように働く。
+// 設定ファイルや環境変数で指定する設定 (絵文字の選択ルール・PACING_* など) は、CLIと同じく環境変数から読み込む。
+//
+// ブラウザや履歴はパッケージの中で共有するため、同時に使える Client は1つだけで、メソッドを並行して呼び出すこともできない
+type Client struct {
+	email     string
+	password  string
+	headless  bool
+	rateLimit float64
+	rateBurst int
+	store     HistoryStore
+	logger    Logger
+	hooks     *Hooks
+
+	lock         historyLocker
+	browserCtx   context.Context
+	closeBrowser context.CancelFunc
+}
+
+// Option は NewClient に渡す Client の設定
+type Option func(*Client)
+
+// WithCredentials はログインに使うメールアドレスとパスワードを指定する
+func WithCredentials(email, password string) Option {
+	return func(c *Client) {
+		c.email = email
+		c.password = password
+	}
+}
+
+// WithHeadless はブラウザを画面を表示せずに起動するかを指定する。既定値は true
+func WithHeadless(headless bool) Option {
+	return func(c *Client) {
+		c.headless = headless
+	}
+}
+
+// WithRateLimit はYAMAPへのリクエストを1分あたり perMinute 件、連続して burst 件までに制限する
+// (REQUEST_RATE_LIMIT・REQUEST_RATE_BURST と同じ)。既定値は制限なし
+func WithRateLimit(perMinute float64, burst int) Option {
+	return func(c *Client) {
+		c.rateLimit = perMinute
+		c.rateBurst = burst
+	}
+}
+
+// WithHistoryStore はリアクションの履歴の保存先を指定する。履歴があればリアクション済みの投稿を再び送らず、
+// 1時間あたりの上限などもCLIと同じように数える。既定値は履歴なし
+func WithHistoryStore(store HistoryStore) Option {
+	return func(c *Client) {
+		c.store = store
+	}
+}
+
+// WithLogger はログの出力先を指定する。*log.Logger などを渡せる。既定値は標準の log パッケージ
+func WithLogger(l Logger) Option {
+	return func(c *Client) {
+		c.logger = l
+	}
+}
+
+// WithHooks は処理の各段階 (収集・リアクション・スクロール・失敗) で呼び出す関数を指定する。
+// OnCollected が false を返した投稿は CollectTimeline の結果に含めない
+func WithHooks(h *Hooks) Option {
+	return func(c *Client) {
+		c.hooks = h
+	}
+}
+
+// HistoryStore はリアクションの履歴 (JSON) の保存先。FileHistoryStore・PostgresHistoryStore のほか、
+// 独自の保存先を実装して渡せる。独自の保存先ではプロセス間のロックを行わない
+type HistoryStore interface {
+	// Load は保存されている履歴を返す。まだ保存されていない場合は nil を返す
+	Load() ([]byte, error)
+	// Save は履歴を保存する
+	Save(data []byte) error
+}
+
+// FileHistoryStore はJSONファイルに保存する履歴 (HISTORY_FILE と同じ) を返す
+func FileHistoryStore(path string) HistoryStore {
+	return backendStore{fileHistory{path: path}}
+}
+
+// PostgresHistoryStore はPostgreSQLに保存する履歴 (HISTORY_DATABASE_URL・HISTORY_DATABASE_KEY と同じ) を返す
+func PostgresHistoryStore(dsn, key string) (HistoryStore, error) {
+	p, err := newPostgresHistory(dsn, key)
+	if err != nil {
+		return nil, err
+	}
+	return backendStore{p}, nil
+}
+
+// backendStore は組み込みの保存先を HistoryStore として公開する
+type backendStore struct {
+	backend historyBackend
+}
+
+func (s backendStore) Load() ([]byte, error)  { return s.backend.load() }
+func (s backendStore) Save(data []byte) error { return s.backend.save(data) }
+
+// customHistory は利用者が実装した HistoryStore を historyBackend として使う
+type customHistory struct {
+	store HistoryStore
+}
+
+func (h customHistory) load() ([]byte, error)  { return h.store.Load() }
+func (h customHistory) save(data []byte) error { return h.store.Save(data) }
+func (h customHistory) lock(string, time.Duration) (historyLocker, error) {
+	return noHistoryLock{}, nil
+}
+func (h customHistory) String() string { return fmt.Sprintf("%T", h.store) }
+
+// noHistoryLock はロックを行わない保存先のロック
+type noHistoryLock struct{}
+
+func (noHistoryLock) release() {}
+
+// ErrNotLoggedIn は Login の前にブラウザを使うメソッドを呼び出したことを表す
+var ErrNotLoggedIn error = messageError("ログインしていません。先に Login を呼び出してください")
+
+// errClientInUse は別の Client が使用中であることを表す
+var errClientInUse error = messageError("別の Client が使用中です。先に Close を呼び出してください")
+
+var (
+	activeClientMu sync.Mutex
+	activeClient   *Client
+)
+
+// NewClient は opts の設定で Client を作成する。履歴の保存先を指定した場合は、ここで履歴を読み込み、
+// Close まで書き換えのロックを持つ
+func NewClient(opts ...Option) (*Client, error) {
+	c := &Client{headless: true, rateBurst: 5}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.rateLimit < 0 || (c.rateLimit > 0 && c.rateBurst < 1) {
+		return nil, fmt.Errorf(tr("WithRateLimit の値が不正です: %v, %d"), c.rateLimit, c.rateBurst)
+	}
+
+	activeClientMu.Lock()
+	defer activeClientMu.Unlock()
+	if activeClient != nil {
+		return nil, errClientInUse
+	}
+
+	var h *historyStore
+	if c.store != nil {
+		var backend historyBackend = customHistory{c.store}
+		if s, ok := c.store.(backendStore); ok {
+			backend = s.backend
+		}
+		lock, err := backend.lock("client", historyLockTimeout)
+		if err != nil {
+			return nil, fmt.Errorf(tr("リアクション履歴のロックを取得できません: %v"), err)
+		}
+		if h, err = loadHistory(backend); err != nil {
+			lock.release()
+			return nil, fmt.Errorf(tr("リアクション履歴の読み込みに失敗しました: %w"), err)
+		}
+		c.lock = lock
+	}
+
+	headless = c.headless
+	pace = newPacerFromEnv(c.baseContext())
+	requestLimit = nil
+	if c.rateLimit > 0 {
+		requestLimit = &rateLimiter{rate: c.rateLimit / 60, burst: float64(c.rateBurst), tokens: float64(c.rateBurst), last: time.Now()}
+	}
+	history = h
+	activeClient = c
+	return c, nil
+}
+
+// Login はブラウザを起動してログインする。ブラウザは Close まで開いたままにし、以降のメソッドで使う。
+// ctx はログインの操作だけに使い、ブラウザの寿命には影響しない
+func (c *Client) Login(ctx context.Context) error {
+	if c.email == "" || c.password == "" {
+		return errors.New(tr("WithCredentials でメールアドレスとパスワードを指定してください"))
+	}
+	if c.browserCtx == nil {
+		browserCtx, closeBrowser, err := startBrowser(c.baseContext())
+		if err != nil {
+			return fmt.Errorf(tr("ブラウザの起動に失敗しました: %w"), err)
+		}
+		c.browserCtx, c.closeBrowser = browserCtx, closeBrowser
+	}
+	runCtx, done := c.bind(ctx)
+	defer done()
+	if err := login(runCtx, c.email, c.password, true); err != nil {
+		return loginError(err)
+	}
+	c.browserCtx = withSession(c.browserCtx, discoverSession(runCtx))
+	return nil
+}
+
+// CollectTimeline はタイムラインを開き、リアクションしていない投稿を最大 n 件収集する。
+// react-timeline の収集と同じく、-max-age などの設定に当たる条件や履歴でリアクション済みの投稿は除く
+func (c *Client) CollectTimeline(ctx context.Context, n int) ([]ActivityInfo, error) {
+	if c.browserCtx == nil {
+		return nil, ErrNotLoggedIn
+	}
+	runCtx, done := c.bind(ctx)
+	defer done()
+	drv := driverFromContext(runCtx)
+	if err := runActions(runCtx, drv.Navigate("https://yamap.com/timeline"), drv.WaitVisible(layout.feed)); err != nil {
+		return nil, fmt.Errorf(tr("タイムラインを開けませんでした: %w"), err)
+	}
+	return collectTimeline(runCtx, n)
+}
+
+// React は activities に順番にリアクションを送り、リアクションした投稿のURLを返す。
+// 投稿ごとの失敗やスキップは戻り値のエラーにせず、ログに出して次の投稿へ進む。ctx が終わった場合は、
+// それまでにリアクションした投稿のURLと ctx のエラーを返す
+func (c *Client) React(ctx context.Context, activities []ActivityInfo) ([]string, error) {
+	if c.browserCtx == nil {
+		return nil, ErrNotLoggedIn
+	}
+	runCtx, done := c.bind(ctx)
+	defer done()
+	reacted := reactToActivities(runCtx, activities)
+	return reacted, ctx.Err()
+}
+
+// Close はブラウザを終了し、履歴のロックを解放する。Close の後は別の Client を作成できる
+func (c *Client) Close() error {
+	activeClientMu.Lock()
+	defer activeClientMu.Unlock()
+	if c.closeBrowser != nil {
+		c.closeBrowser()
+		c.browserCtx, c.closeBrowser = nil, nil
+	}
+	if c.lock != nil {
+		c.lock.release()
+		c.lock = nil
+	}
+	if activeClient == c {
+		activeClient = nil
+		history = nil
+	}
+	return nil
+}
+
+// baseContext は WithLogger で指定した出力先と WithHooks で指定したフックを紐づけたコンテキストを返す。
+// ブラウザのコンテキストはこれから作るため、各メソッドのログやフックも同じものが使われる
+func (c *Client) baseContext() context.Context {
+	ctx := context.Background()
+	if c.logger != nil {
+		ctx = withLogger(ctx, c.logger)
+	}
+	if c.hooks != nil {
+		ctx = withHooks(ctx, c.hooks)
+	}
+	return ctx
+}
+
+// bind はブラウザのコンテキストから、呼び出し元の ctx が終わると中断されるコンテキストを作る。
+// ブラウザのコンテキストを直接キャンセルするとブラウザが終了するため、その子で中断する
+func (c *Client) bind(ctx context.Context) (context.Context, func()) {
+	runCtx, cancel := context.WithCancel(c.browserCtx)
+	stop := context.AfterFunc(ctx, cancel)
+	return runCtx, func() {
+		stop()
+		cancel()
+	}
+}
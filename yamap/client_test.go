@@ -0,0 +1,149 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryHistory はテスト用の、メモリに保存する HistoryStore
+type memoryHistory struct {
+	data []byte
+}
+
+func (m *memoryHistory) Load() ([]byte, error)  { return m.data, nil }
+func (m *memoryHistory) Save(data []byte) error { m.data = data; return nil }
+
+// TestClientReact は Client を記録したセッション (testdata/session_react.jsonl) で動かし、
+// React の結果が WithHistoryStore の保存先に、ログが WithLogger の出力先に書かれること、
+// Client が同時に1つしか使えないことを確かめる
+func TestClientReact(t *testing.T) {
+	origPace, origLimit, origHistory, origKind, origCheck := pace, requestLimit, history, browserKind, lastTabMemoryCheck
+	t.Cleanup(func() {
+		pace, requestLimit, history, browserKind, lastTabMemoryCheck = origPace, origLimit, origHistory, origKind, origCheck
+		headless = true
+	})
+	t.Setenv("SESSION_REPLAY_FILE", "testdata/session_react.jsonl")
+
+	// WithLogger の出力先にだけ書かれ、標準の log パッケージには書かれないことも確かめる
+	var captured, global bytes.Buffer
+	origOutput := log.Writer()
+	log.SetOutput(&global)
+	t.Cleanup(func() { log.SetOutput(origOutput) })
+
+	store := &memoryHistory{}
+	c, err := NewClient(WithHistoryStore(store), WithHeadless(false), WithRateLimit(600, 10), WithLogger(log.New(&captured, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if headless || requestLimit == nil || requestLimit.burst != 10 {
+		t.Errorf("options not applied: headless=%v, requestLimit=%+v", headless, requestLimit)
+	}
+	if _, err := NewClient(); !errors.Is(err, errClientInUse) {
+		t.Errorf("second NewClient error = %v, want errClientInUse", err)
+	}
+	if _, err := c.React(context.Background(), nil); !errors.Is(err, ErrNotLoggedIn) {
+		t.Errorf("React before Login error = %v, want ErrNotLoggedIn", err)
+	}
+
+	// ログインの操作は記録にないため、ブラウザの起動だけを Login と同じように行う
+	browserKind = "replay"
+	c.browserCtx, c.closeBrowser, err = startBrowser(c.baseContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pace, lastTabMemoryCheck = &pacer{}, time.Time{}
+	got, err := c.React(context.Background(), []ActivityInfo{
+		{URL: "https://yamap.com/activities/1"},
+		{URL: "https://yamap.com/activities/2"},
+		{URL: "https://yamap.com/activities/3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://yamap.com/activities/1"}; !slices.Equal(got, want) {
+		t.Errorf("reacted = %q, want %q", got, want)
+	}
+	if !strings.Contains(string(store.data), "https://yamap.com/activities/1") {
+		t.Errorf("history store = %s, want the reacted activity", store.data)
+	}
+
+	if want := fmt.Sprintf(tr("--- 投稿 %d/%d を処理中 ---"), 1, 3); !strings.Contains(captured.String(), want) {
+		t.Errorf("WithLogger output does not contain %q:\n%s", want, captured.String())
+	}
+	if global.Len() > 0 {
+		t.Errorf("standard logger got %q; want nothing", global.String())
+	}
+
+	c.Close()
+	next, err := NewClient()
+	if err != nil {
+		t.Fatalf("NewClient after Close: %v", err)
+	}
+	next.Close()
+}
+
+func TestClientLoginRequiresCredentials(t *testing.T) {
+	origPace, origLimit := pace, requestLimit
+	t.Cleanup(func() { pace, requestLimit = origPace, origLimit })
+	c, err := NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Login(context.Background()); err == nil {
+		t.Error("Login without credentials succeeded")
+	}
+	if _, err := NewClient(WithRateLimit(-1, 5)); err == nil {
+		t.Error("NewClient accepted a negative rate limit")
+	}
+}
+
+// TestClientHooksVetoCollected は WithHooks で指定した OnCollected が false を返した投稿が、
+// CollectTimeline の結果から除かれることを記録したセッション (testdata/session_timeline.jsonl) で確かめる。
+// 記録ではタイムラインに未リアクションの活動日記が2件 (ID 1, 2) ある
+func TestClientHooksVetoCollected(t *testing.T) {
+	origPace, origLimit, origKind, origCheck := pace, requestLimit, browserKind, lastTabMemoryCheck
+	t.Cleanup(func() { pace, requestLimit, browserKind, lastTabMemoryCheck = origPace, origLimit, origKind, origCheck })
+	t.Setenv("SESSION_REPLAY_FILE", "testdata/session_timeline.jsonl")
+
+	var vetoed []string
+	c, err := NewClient(WithLogger(log.New(io.Discard, "", 0)), WithHooks(&Hooks{
+		OnCollected: func(ctx context.Context, activity ActivityInfo) bool {
+			if activity.URL == "https://yamap.com/activities/1" {
+				vetoed = append(vetoed, activity.URL)
+				return false
+			}
+			return true
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	browserKind = "replay"
+	c.browserCtx, c.closeBrowser, err = startBrowser(c.baseContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pace, lastTabMemoryCheck = &pacer{}, time.Time{}
+	got, err := c.CollectTimeline(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].URL != "https://yamap.com/activities/2" {
+		t.Errorf("collected = %+v, want only https://yamap.com/activities/2", got)
+	}
+	if want := []string{"https://yamap.com/activities/1"}; !slices.Equal(vetoed, want) {
+		t.Errorf("OnCollected vetoed %q, want %q", vetoed, want)
+	}
+}
@@ -0,0 +1,120 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// parseCommentTemplates は設定ファイルのコメントテンプレートを解析する
+func parseCommentTemplates(key string, texts []string) ([]*template.Template, error) {
+	var templates []*template.Template
+	for i, text := range texts {
+		tmpl, err := template.New(fmt.Sprintf("%s[%d]", key, i)).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf(tr("コメントテンプレートの解析に失敗しました: %w"), err)
+		}
+		templates = append(templates, tmpl)
+	}
+	return templates, nil
+}
+
+// commentData はコメントテンプレートで参照できる値
+type commentData struct {
+	Title string
+	// MountainName は活動で登った最初の山の名前。山が登録されていない場合は空
+	MountainName  string
+	MountainNames []string
+	// Distance は活動距離 (km)
+	Distance float64
+	// Elevation は累積標高 (上り, m)
+	Elevation float64
+	Author    string
+	// URL は活動日記のURL。crosspost で投稿する文章でのみ設定される
+	URL string
+}
+
+// renderComment はコメントテンプレートの中からランダムに1つ選び、活動の情報を埋め込んだコメントを返す
+func renderComment(templates []*template.Template, meta activityMetadata) (string, error) {
+	if len(templates) == 0 {
+		return "", nil
+	}
+	return executeTemplate(templates, newCommentData(meta))
+}
+
+// newCommentData は活動の情報からテンプレートで参照できる値を作る
+func newCommentData(meta activityMetadata) commentData {
+	data := commentData{
+		Title:         meta.Title,
+		MountainNames: meta.MountainNames,
+		Distance:      meta.Distance / 1000,
+		Elevation:     meta.CumulativeUp,
+		Author:        meta.Author,
+	}
+	if len(meta.MountainNames) > 0 {
+		data.MountainName = meta.MountainNames[0]
+	}
+	return data
+}
+
+// executeTemplate はテンプレートの中からランダムに1つ選び、値を埋め込んだ文章を返す
+func executeTemplate(templates []*template.Template, data commentData) (string, error) {
+	tmpl := templates[mathrand.IntN(len(templates))]
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// commentInputSelector は活動日記詳細ページのコメント入力欄のセレクタ
+const commentInputSelector = `textarea[placeholder*="コメント"], textarea[placeholder*="comment" i]`
+
+// submitCommentScript はコメント入力欄を含むフォームの送信ボタンをクリックするスクリプト
+const submitCommentScript = `(() => {
+	const input = document.querySelector(` + "`" + commentInputSelector + "`" + `);
+	const form = input && input.closest("form");
+	const button = form && form.querySelector('button[type="submit"], button:not([type])');
+	if (!button || button.disabled) return false;
+	button.click();
+	return true;
+})()`
+
+// postComment は表示中の活動日記にテンプレートから作成したコメントを送る。テンプレートが未設定の場合は何もしない
+func postComment(ctx context.Context, drv pageDriver, templates []*template.Template) error {
+	if len(templates) == 0 {
+		return nil
+	}
+	meta, err := fetchActivityMetadata(ctx, drv)
+	if err != nil {
+		return fmt.Errorf(tr("活動の情報の取得に失敗: %w"), err)
+	}
+	text, err := renderComment(templates, meta)
+	if err != nil {
+		return fmt.Errorf(tr("コメントの作成に失敗: %w"), err)
+	}
+	if text == "" {
+		return nil
+	}
+	loggerFromContext(ctx).Printf(tr("コメントを送信します: %s"), text)
+	var submitted bool
+	if err := runActions(ctx,
+		drv.ScrollIntoView(commentInputSelector),
+		drv.Click(commentInputSelector),
+		drv.SendKeys(commentInputSelector, text),
+		sleepAction(time.Second),
+		drv.Evaluate(submitCommentScript, &submitted),
+		sleepAction(2*time.Second),
+	); err != nil {
+		return err
+	}
+	if !submitted {
+		return errors.New(tr("コメントの送信ボタンが見つかりません"))
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// spamCommentConfig は scan-comments で不審なコメントを判定する設定
+type spamCommentConfig struct {
+	// Patterns は不審とみなすコメントの本文の正規表現。空の場合は defaultSpamPatterns を使う
+	Patterns []string `json:"patterns"`
+	// AllowURLs が true の場合、yamap.com 以外へのURLを含むだけでは不審とみなさない
+	AllowURLs bool `json:"allow_urls"`
+
+	patterns []*regexp.Regexp
+}
+
+// defaultSpamPatterns は spam_comments.patterns が未設定の場合に使う、よくある勧誘のコメントの表現
+var defaultSpamPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)line\s*(id|@|＠)`),
+	regexp.MustCompile(`(?i)(dm|ＤＭ)\s*(ください|下さい|して|待って)`),
+	regexp.MustCompile(`副業|在宅ワーク|不労所得|簡単に稼|高収入`),
+	regexp.MustCompile(`(?i)(crypto|bitcoin|仮想通貨|投資).*(儲|稼|利益)`),
+	regexp.MustCompile(`プロフ(ィール)?(見て|を見て|のリンク)`),
+}
+
+// spamURLPattern はコメントに含まれるURLを探す正規表現
+var spamURLPattern = regexp.MustCompile(`(?i)(https?://|www\.)[^\s]+|[a-z0-9-]+\.(com|net|org|jp|xyz|top|info|me|link|io|ly|gl|co|cc|to|site|shop|biz|club|online)\b(/[^\s]*)?`)
+
+// suspiciousReasons はコメントの本文が不審とみなされる理由を返す。不審でない場合は nil
+func (c spamCommentConfig) suspiciousReasons(text string) []string {
+	var reasons []string
+	if !c.AllowURLs {
+		for _, u := range spamURLPattern.FindAllString(text, -1) {
+			if !strings.Contains(strings.ToLower(u), "yamap.com") {
+				reasons = append(reasons, tr("外部のURL: ")+u)
+				break
+			}
+		}
+	}
+	patterns := c.patterns
+	if len(c.Patterns) == 0 {
+		patterns = defaultSpamPatterns
+	}
+	for _, re := range patterns {
+		if m := re.FindString(text); m != "" {
+			reasons = append(reasons, fmt.Sprintf(tr("パターン %s に一致: %s"), re, m))
+		}
+	}
+	return reasons
+}
+
+// scannedComment は scan-comments で見つけた不審なコメント
+type scannedComment struct {
+	ActivityURL string   `json:"activity_url"`
+	AuthorID    int64    `json:"author_id"`
+	AuthorName  string   `json:"author_name"`
+	Text        string   `json:"text"`
+	Reasons     []string `json:"reasons"`
+	// Handled は SPAM_COMMENTS_ACTION で行った操作 (reported, deleted)。操作しなかった・できなかった場合は空
+	Handled string `json:"handled,omitempty"`
+
+	index int
+}
+
+// commentItemsScript は活動日記のコメント欄のコメントを、投稿者のプロフィールへのパス・名前・本文として取得するスクリプト。
+// コメントの要素には後から通報・削除のメニューを探せるよう番号を付ける
+const commentItemsScript = `(() => {
+	const items = [];
+	const seen = new Set();
+	for (const a of document.querySelectorAll('[class*="Comment"] a[href^="/users/"]')) {
+		const item = a.closest('li, article, [class*="CommentItem"], [class*="Comment__Item"], [class*="CommentList__Item"]');
+		if (!item || seen.has(item)) continue;
+		seen.add(item);
+		item.setAttribute("data-yamap-auto-domo-comment", String(items.length));
+		const name = (a.textContent || "").trim();
+		const body = item.querySelector('[class*="Body"], [class*="Text"], p');
+		let text = ((body || item).innerText || "").trim();
+		if (!body && name && text.startsWith(name)) text = text.slice(name.length).trim();
+		items.push({href: a.getAttribute("href"), name: name, text: text});
+	}
+	return items;
+})()`
+
+// commentMenuScript は番号を付けたコメントのメニューのボタン (「…」など) をクリックするスクリプト。
+// 通報・削除のボタンがコメントに直接表示されている場合はクリックしない。コメントが見つからない場合は false を返す
+const commentMenuScript = `((index, labels) => {
+	const item = document.querySelector('[data-yamap-auto-domo-comment="' + index + '"]');
+	if (!item) return false;
+	const text = el => (el.getAttribute("aria-label") || "") + (el.textContent || "");
+	if (Array.from(item.querySelectorAll("button")).some(b => labels.some(l => text(b).includes(l)))) return true;
+	const menu = Array.from(item.querySelectorAll("button")).find(b => /メニュー|その他|more|menu|…|⋯/i.test(text(b)));
+	if (menu) menu.click();
+	return true;
+})`
+
+// commentMenuItemScript はコメントの中か開いたメニューから、ラベルを含む項目をクリックするスクリプト。見つからない場合は false を返す
+const commentMenuItemScript = `((index, labels) => {
+	const item = document.querySelector('[data-yamap-auto-domo-comment="' + index + '"]');
+	const text = el => (el.getAttribute("aria-label") || "") + (el.textContent || "");
+	const candidates = [
+		...(item ? item.querySelectorAll("button") : []),
+		...document.querySelectorAll('[role="menu"] button, [role="menuitem"], [class*="Menu"] button, [class*="Dropdown"] button, [class*="Popover"] button'),
+	];
+	const target = candidates.find(el => labels.some(l => text(el).trim().startsWith(l) || (el.getAttribute("aria-label") || "").includes(l)));
+	if (!target) return false;
+	target.click();
+	return true;
+})`
+
+// commentConfirmScript は確認のダイアログが表示された場合に、ラベルを含むボタンか「OK」「はい」をクリックするスクリプト
+const commentConfirmScript = `((labels) => {
+	const button = Array.from(document.querySelectorAll('[role="dialog"] button, [role="alertdialog"] button'))
+		.find(b => labels.some(l => (b.textContent || "").includes(l)) || /^(OK|はい)$/.test((b.textContent || "").trim()));
+	if (button) button.click();
+	return !!button;
+})`
+
+// handleComment は番号を付けたコメントを画面のメニューから通報・削除する。メニューに項目が見つからない場合は false を返す
+func handleComment(ctx context.Context, drv pageDriver, index int, labels []string) (bool, error) {
+	args, _ := json.Marshal(labels)
+	call := func(script string) string { return fmt.Sprintf("(%s)(%d, %s)", script, index, args) }
+	var found, clicked bool
+	if err := runActions(ctx, drv.Evaluate(call(commentMenuScript), &found)); err != nil || !found {
+		return false, err
+	}
+	if err := runActions(ctx,
+		sleepAction(800*time.Millisecond),
+		drv.Evaluate(call(commentMenuItemScript), &clicked),
+	); err != nil || !clicked {
+		return false, err
+	}
+	return true, runActions(ctx,
+		sleepAction(800*time.Millisecond),
+		drv.Evaluate(fmt.Sprintf("(%s)(%s)", commentConfirmScript, args), nil),
+		sleepAction(time.Second),
+	)
+}
+
+// spamCommentActions は SPAM_COMMENTS_ACTION に指定できる操作と、メニューで探す項目のラベル
+var spamCommentActions = map[string][]string{
+	"report": {"通報", "報告", "Report"},
+	"delete": {"削除", "Delete"},
+}
+
+// spamCommentHandled は操作したコメントに記録する値
+var spamCommentHandled = map[string]string{"report": "reported", "delete": "deleted"}
+
+// runScanComments は自分の最近の活動日記 SPAM_COMMENTS_ACTIVITIES 件 (既定値 5) のコメントを設定ファイルの spam_comments で判定し、
+// 不審なコメントを SPAM_COMMENTS_FILE (既定値 spam-comments.json) に書き出す。
+// SPAM_COMMENTS_ACTION が report か delete の場合は、画面のメニューから通報・削除できるコメントに操作する
+func runScanComments() error {
+	log.Println(tr("--- プログラム開始 (scan-comments) ---"))
+	startTime := time.Now()
+	activityCount := 5
+	if v := os.Getenv("SPAM_COMMENTS_ACTIVITIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("SPAM_COMMENTS_ACTIVITIESの値が不正です: %s"), v)
+		}
+		activityCount = n
+	}
+	path := os.Getenv("SPAM_COMMENTS_FILE")
+	if path == "" {
+		path = "spam-comments.json"
+	}
+	handle := os.Getenv("SPAM_COMMENTS_ACTION")
+	labels, ok := spamCommentActions[handle]
+	if handle != "" && !ok {
+		return fmt.Errorf(tr("SPAM_COMMENTS_ACTIONには report または delete を指定してください: %s"), handle)
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、自分の活動日記を確認できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	var paths []string
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", sess.UserID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(myActivityLinksScript, &paths),
+	); err != nil {
+		return fmt.Errorf(tr("自分の活動日記の一覧の取得に失敗: %w"), err)
+	}
+	if len(paths) > activityCount {
+		paths = paths[:activityCount]
+	}
+
+	suspicious := []scannedComment{}
+	scanned := 0
+	for _, p := range paths {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		url := "https://yamap.com" + p
+		loggerFromContext(ctx).Printf(tr("コメント欄を確認します: %s"), url)
+		var items []struct {
+			Href string `json:"href"`
+			Name string `json:"name"`
+			Text string `json:"text"`
+		}
+		if err := runActions(ctx,
+			drv.Navigate(url),
+			drv.WaitVisible(layout.footer),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(commentItemsScript, &items),
+		); err != nil {
+			loggerFromContext(ctx).Printf(tr("コメント欄の取得に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		status.markStep()
+		for i, item := range items {
+			authorID := userIDFromPath(item.Href)
+			if authorID == sess.UserID {
+				continue
+			}
+			scanned++
+			reasons := config.SpamComments.suspiciousReasons(item.Text)
+			if len(reasons) == 0 {
+				continue
+			}
+			c := scannedComment{ActivityURL: url, AuthorID: authorID, AuthorName: item.Name, Text: item.Text, Reasons: reasons, index: i}
+			loggerFromContext(ctx).Printf(tr("不審なコメント: %s (%s): %s"), c.AuthorName, strings.Join(reasons, ", "), url)
+			if handle != "" {
+				if done, err := handleComment(ctx, drv, c.index, labels); err != nil {
+					loggerFromContext(ctx).Printf(tr("コメントの操作 (%s) に失敗しました (%s): %v"), handle, url, err)
+				} else if !done {
+					loggerFromContext(ctx).Printf(tr("画面のメニューにコメントの操作 (%s) が見つかりません (%s)"), handle, url)
+				} else {
+					c.Handled = spamCommentHandled[handle]
+				}
+			}
+			suspicious = append(suspicious, c)
+		}
+		pace.wait(ctx)
+	}
+
+	data, err := json.MarshalIndent(suspicious, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf(tr("不審なコメントの書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d件のコメントのうち %d件を不審なコメントとして %s に書き出しました。"), scanned, len(suspicious), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
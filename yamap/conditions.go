@@ -0,0 +1,205 @@
+package yamap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mountainConditions は conditions で山のページから取得した天気予報と最近の山の状況
+type mountainConditions struct {
+	ID        int64             `json:"id"`
+	URL       string            `json:"url"`
+	Name      string            `json:"name"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Weather   []mountainWeather `json:"weather"`
+	Reports   []conditionReport `json:"reports"`
+}
+
+// mountainWeather は1日分の天気予報。気温は取得できない場合は空
+type mountainWeather struct {
+	Date    string `json:"date"`
+	Summary string `json:"summary"`
+	High    string `json:"high"`
+	Low     string `json:"low"`
+}
+
+// conditionReport は登山者が投稿した山の状況 (登山道・積雪など) の報告
+type conditionReport struct {
+	Date   string `json:"date"`
+	Text   string `json:"text"`
+	Author string `json:"author"`
+	URL    string `json:"url"`
+}
+
+// mountainConditionsScript は山のページの NUXT データから天気予報と山の状況の報告を取り出すスクリプト。
+// ストアの構成はページの実装により異なるため、山らしいオブジェクトを候補の中から探し、見つからない項目は
+// 見出しに「天気」「状況」を含む区画の表示から補う
+const mountainConditionsScript = `(() => {
+	const nuxt = window.__NUXT__ || {};
+	const candidates = [];
+	if (nuxt.state && nuxt.state.mountain) candidates.push(nuxt.state.mountain.mountain, nuxt.state.mountain);
+	for (const d of (nuxt.data || [])) if (d) candidates.push(d.mountain, d);
+	const m = candidates.find(c => c && typeof c === "object" && "name" in c && ("altitude" in c || "weathers" in c || "forecasts" in c)) || {};
+	const text = el => el ? el.textContent.replace(/\s+/g, " ").trim() : "";
+	const str = v => v === undefined || v === null ? "" : String(v);
+	const section = word => Array.from(document.querySelectorAll("main section, main [class*='Section']"))
+		.find(s => text(s.querySelector("h2, h3")).includes(word));
+	let weather = (m.weathers || m.forecasts || m.weather_forecasts || []).map(w => ({
+		date: str(w.date || w.target_date),
+		summary: str(w.telop || w.weather || w.summary),
+		high: str(w.max_temperature ?? w.high),
+		low: str(w.min_temperature ?? w.low),
+	}));
+	if (weather.length === 0) {
+		const s = section("天気");
+		if (s) weather = Array.from(s.querySelectorAll("li, tr")).map(el => ({date: "", summary: text(el), high: "", low: ""})).filter(w => w.summary);
+	}
+	const activityURL = href => {
+		const m = (href || "").match(/^\/activities\/\d+/);
+		return m ? "https://yamap.com" + m[0] : "";
+	};
+	let reports = (m.condition_reports || m.road_conditions || m.conditions || []).map(r => ({
+		date: str(r.created_at || r.date),
+		text: str(r.body || r.text || r.description).trim(),
+		author: str(r.user && r.user.name),
+		url: r.activity_id ? "https://yamap.com/activities/" + r.activity_id : "",
+	}));
+	if (reports.length === 0) {
+		const s = section("状況");
+		if (s) reports = Array.from(s.querySelectorAll("li, article")).map(el => {
+			const a = el.querySelector('a[href^="/activities/"]');
+			const t = el.querySelector("time[datetime]");
+			const u = el.querySelector('a[href^="/users/"]');
+			return {date: t ? t.getAttribute("datetime") : "", text: text(el), author: text(u), url: a ? activityURL(a.getAttribute("href")) : ""};
+		}).filter(r => r.text);
+	}
+	return {name: str(m.name) || text(document.querySelector("h1")), weather: weather, reports: reports};
+})()`
+
+// conditionsMountains は conditions で確認する山のID。CONDITIONS_MOUNTAINS (カンマ区切り) が未設定の場合は設定ファイルの watch.mountains
+func conditionsMountains() ([]int64, error) {
+	v := os.Getenv("CONDITIONS_MOUNTAINS")
+	if v == "" {
+		return config.Watch.Mountains, nil
+	}
+	var ids []int64
+	for _, s := range strings.Split(v, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf(tr("CONDITIONS_MOUNTAINSの値が不正です: %s"), v)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// runConditions は山のページを開いて天気予報と最近の山の状況を取得し、CONDITIONS_FILE (既定値 conditions.json) に書き出す。
+// CONDITIONS_NOTIFY=true の場合は山ごとのまとめを NOTIFY_WEBHOOK_URL にも送る
+func runConditions() error {
+	log.Println(tr("--- プログラム開始 (conditions) ---"))
+	startTime := time.Now()
+	ids, err := conditionsMountains()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return errors.New(tr("conditions では CONDITIONS_MOUNTAINS か設定ファイルの watch.mountains に山のIDを指定してください"))
+	}
+	reportCount := 5
+	if v := os.Getenv("CONDITIONS_REPORTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf(tr("CONDITIONS_REPORTSの値が不正です: %s"), v)
+		}
+		reportCount = n
+	}
+	path := os.Getenv("CONDITIONS_FILE")
+	if path == "" {
+		path = "conditions.json"
+	}
+	notifyConditions := os.Getenv("CONDITIONS_NOTIFY") == "true"
+
+	ctx, closeBrowser, err := openPublicBrowser()
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	results := make([]mountainConditions, 0, len(ids))
+	for _, id := range ids {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		c := mountainConditions{ID: id, URL: fmt.Sprintf("https://yamap.com/mountains/%d", id), CheckedAt: time.Now()}
+		err := runActions(ctx,
+			drv.Navigate(c.URL),
+			drv.WaitVisible(`main`),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(mountainConditionsScript, &c),
+		)
+		status.recordResult(err == nil, err)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("山の状況の取得に失敗しました (%s): %v"), c.URL, err)
+			continue
+		}
+		if len(c.Reports) > reportCount {
+			c.Reports = c.Reports[:reportCount]
+		}
+		summary := c.summary()
+		loggerFromContext(ctx).Println(summary)
+		if notifyConditions {
+			notify(ctx, "INFO", summary)
+		}
+		results = append(results, c)
+		status.markStep()
+		pace.wait(ctx)
+	}
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf(tr("山の状況の書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の山の状況を %s に書き出しました。"), len(results), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// summary は直近の天気予報と最新の山の状況の報告を1行にまとめる
+func (c mountainConditions) summary() string {
+	parts := []string{c.Name}
+	if len(c.Weather) > 0 {
+		w := c.Weather[0]
+		s := strings.TrimSpace(w.Date + " " + w.Summary)
+		if w.High != "" || w.Low != "" {
+			s += fmt.Sprintf(" %s/%s℃", w.High, w.Low)
+		}
+		parts = append(parts, s)
+	} else {
+		parts = append(parts, tr("天気予報なし"))
+	}
+	if len(c.Reports) > 0 {
+		r := c.Reports[0]
+		text := []rune(r.Text)
+		if len(text) > 80 {
+			text = append(text[:80], '…')
+		}
+		parts = append(parts, fmt.Sprintf(tr("最新の状況 (%s): %s"), r.Date, string(text)))
+	} else {
+		parts = append(parts, tr("状況の報告なし"))
+	}
+	return strings.Join(parts, " / ") + " " + c.URL
+}
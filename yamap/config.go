@@ -0,0 +1,463 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// appConfig は -config で指定する設定ファイルの内容
+type appConfig struct {
+	// EmojiRules は投稿の内容に応じて送る絵文字を選ぶルール。上から順に評価し、最初に一致したものを使う
+	EmojiRules []emojiRule `json:"emoji_rules"`
+	// DefaultEmoji はどのルールにも一致しない場合の絵文字。空の場合はピッカーの最初の絵文字
+	DefaultEmoji string `json:"default_emoji"`
+	// CommentTemplates はリアクション後に送るコメントのテンプレート (text/template)。
+	// 複数指定するとランダムに1つ選ぶ。空の場合はコメントを送らない
+	CommentTemplates []string `json:"comment_templates"`
+	// ThankYouTemplates は thank-followers で新しいフォロワーの投稿に送るお礼コメントのテンプレート
+	ThankYouTemplates []string `json:"thank_you_templates"`
+	// Accounts は複数のアカウントで実行する場合のアカウントの一覧。空の場合は環境変数のアカウントのみで実行する
+	Accounts []accountConfig `json:"accounts"`
+	// ExcludeAuthors はリアクションしない投稿者 (公式アカウントなど) のルール
+	ExcludeAuthors authorExclusion `json:"exclude_authors"`
+	// FollowCommentersAllow が空でない場合、follow-commenters はこのIDのユーザーだけをフォローする
+	FollowCommentersAllow []int64 `json:"follow_commenters_allow"`
+	// FollowCommentersDeny は follow-commenters でフォローしないユーザーのID
+	FollowCommentersDeny []int64 `json:"follow_commenters_deny"`
+	// QueueOrder は収集した投稿を処理する順の戦略 (prioritizers の名前)。環境変数 QUEUE_ORDER が優先される
+	QueueOrder string `json:"queue_order"`
+	// ABTest は2つの戦略の結果を比べる場合の戦略の組。収集した投稿を交互に割り当てる
+	ABTest []abVariant `json:"ab_test"`
+	// Retry はログイン・投稿ページへの移動・リアクションの段階ごとの再試行の設定
+	Retry retryConfig `json:"retry"`
+	// Alerts は実行の終了時に評価し、一致した場合に通知するアラートの条件
+	Alerts []string `json:"alerts"`
+	// StopWhen はリアクション処理の投稿の合間に評価し、いずれかが成り立ったら新しい投稿の処理を終える条件
+	StopWhen []string `json:"stop_when"`
+	// CrosspostTemplates は crosspost でSNSに投稿する活動のまとめのテンプレート
+	CrosspostTemplates []string `json:"crosspost_templates"`
+	// SpamComments は scan-comments で不審なコメントを判定する設定
+	SpamComments spamCommentConfig `json:"spam_comments"`
+	// Watch は watch で新しい活動日記を確認する山・ランドマークと条件
+	Watch watchConfig `json:"watch"`
+	// ActivitySearch は react-activities・follow-search などで使う活動日記の検索の条件
+	ActivitySearch activitySearch `json:"activity_search"`
+	// FeedEnd はタイムラインのスクロールで終端に達したとみなす条件
+	FeedEnd feedEndConfig `json:"feed_end"`
+	// BlockRequests はブラウザが送信する前に遮断するリクエスト
+	BlockRequests blockRequestsConfig `json:"block_requests"`
+	// Profile は profile-update で設定する自分のプロフィールの値
+	Profile profileConfig `json:"profile"`
+	// ActivityUploadDescription は activity-upload で活動日記の本文にするテンプレート (text/template)
+	ActivityUploadDescription string `json:"activity_upload_description"`
+	// PhotoCaptionTemplate は activity-photos で追加する写真の説明にするテンプレート (text/template)
+	PhotoCaptionTemplate string `json:"photo_caption_template"`
+
+	commentTemplates   []*template.Template
+	thankYouTemplates  []*template.Template
+	crosspostTemplates []*template.Template
+	alerts             []alertRule
+	stopWhen           []stopRule
+
+	activityUploadDescription *template.Template
+	photoCaptionTemplate      *template.Template
+}
+
+// abVariant は A/B 比較で投稿に割り当てる戦略
+type abVariant struct {
+	// Name は履歴に記録する戦略の名前
+	Name string `json:"name"`
+	// Emoji は送る絵文字。空の場合は通常どおり絵文字のルールで選ぶ
+	Emoji string `json:"emoji"`
+	// PaceFactor は投稿後の待機時間に掛ける倍率。0 の場合は 1 (通常の待機時間)
+	PaceFactor float64 `json:"pace_factor"`
+}
+
+// retryConfig は段階ごとの再試行の設定
+type retryConfig struct {
+	Login      retryPolicy `json:"login"`
+	Navigation retryPolicy `json:"navigation"`
+	Reaction   retryPolicy `json:"reaction"`
+}
+
+// retryPolicy は1つの段階の再試行の方法。未指定 (ゼロ値) の項目は段階ごとの既定値を使う
+type retryPolicy struct {
+	// Attempts は最初の1回を含む試行回数
+	Attempts int `json:"attempts"`
+	// Timeout は1回の試行にかける時間の上限 (例: "30s")
+	Timeout string `json:"timeout"`
+	// OnRetry は再試行の前にページを読み込み直す方法。reload はリロードしてその段階の待機からやり直し、
+	// navigate はURLを開き直してその段階の最初からやり直す
+	OnRetry string `json:"on_retry"`
+
+	timeout time.Duration
+}
+
+const (
+	retryReload   = "reload"
+	retryNavigate = "navigate"
+)
+
+// 段階ごとの再試行の既定値。ログインと投稿ページへの移動は再試行せず、リアクションはリロードして3回まで試行する
+var (
+	defaultLoginRetry      = retryPolicy{Attempts: 1, OnRetry: retryNavigate, timeout: 60 * time.Second}
+	defaultNavigationRetry = retryPolicy{Attempts: 1, OnRetry: retryNavigate}
+	defaultReactionRetry   = retryPolicy{Attempts: 3, OnRetry: retryReload, timeout: defaultAttemptTimeout}
+)
+
+// parse は設定ファイルの値を検証し、timeout を解析する。key はエラーメッセージに使う設定のキー
+func (p *retryPolicy) parse(key string) error {
+	if p.Attempts < 0 {
+		return fmt.Errorf(tr("%s.attempts には1以上の値を指定してください: %d"), key, p.Attempts)
+	}
+	if p.Timeout != "" {
+		d, err := time.ParseDuration(p.Timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf(tr("%s.timeout の値が不正です: %s"), key, p.Timeout)
+		}
+		p.timeout = d
+	}
+	if p.OnRetry != "" && p.OnRetry != retryReload && p.OnRetry != retryNavigate {
+		return fmt.Errorf(tr("%s.on_retry には reload か navigate を指定してください: %s"), key, p.OnRetry)
+	}
+	return nil
+}
+
+// or は未指定の項目を def の値で補った設定を返す
+func (p retryPolicy) or(def retryPolicy) retryPolicy {
+	if p.Attempts == 0 {
+		p.Attempts = def.Attempts
+	}
+	if p.timeout == 0 {
+		p.timeout = def.timeout
+	}
+	if p.OnRetry == "" {
+		p.OnRetry = def.OnRetry
+	}
+	return p
+}
+
+// withTimeout は1回の試行のコンテキストを返す。timeout が0の場合は ctx の期限だけに従う
+func (p retryPolicy) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.timeout)
+}
+
+// authorExclusion はリアクションの対象から除く投稿者のルール。いずれかに一致する投稿者を除く
+type authorExclusion struct {
+	// Official はYAMAPの公式アカウント・ブランドのアカウント (is_official) を除く
+	Official bool `json:"official"`
+	// Ambassadors はアンバサダー (is_ambassador) を除く
+	Ambassadors bool `json:"ambassadors"`
+	// IDs は除くユーザーのID
+	IDs []int64 `json:"ids"`
+	// NamePatterns は除くユーザーの名前の正規表現
+	NamePatterns []string `json:"name_patterns"`
+
+	namePatterns []*regexp.Regexp
+}
+
+// match は投稿者がルールに一致する場合にスキップの理由を返す
+func (e authorExclusion) match(u User) string {
+	switch {
+	case e.Official && u.IsOfficial:
+		return tr("公式アカウント")
+	case e.Ambassadors && u.IsAmbassador:
+		return tr("アンバサダー")
+	case slices.Contains(e.IDs, u.ID):
+		return tr("除外するユーザーID")
+	}
+	for _, re := range e.namePatterns {
+		if u.Name != "" && re.MatchString(u.Name) {
+			return tr("除外する名前のパターン ") + re.String()
+		}
+	}
+	return ""
+}
+
+// config は読み込まれた設定。設定ファイルを指定しない場合はゼロ値
+var config appConfig
+
+// configPath は -config フラグで指定された設定ファイルのパス
+var configPath string
+
+// loadConfig は設定ファイルを読み込み config に設定する。未知のキーは誤記とみなしてエラーにする
+func loadConfig(path string) error {
+	cfg, problems, err := parseConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return problems[0].Err
+	}
+	config = cfg
+	return nil
+}
+
+// configProblem は設定ファイルの検証で見つかった問題。Key は問題のある項目のパス (例: emoji_rules[0].emoji)
+type configProblem struct {
+	Key string
+	Err error
+}
+
+// parseConfigFile は設定ファイルを読み込んで検証し、見つかった問題をすべて返す。
+// ファイルを読めない場合やJSONとして解析できない場合は検証を続けられないため、エラーを返す
+func parseConfigFile(path string) (appConfig, []configProblem, error) {
+	var cfg appConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, nil, err
+	}
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return cfg, nil, fmt.Errorf(tr("%s の形式が不正です: %w"), path, err)
+	}
+	var problems []configProblem
+	for _, key := range unknownConfigKeys(raw, reflect.TypeOf(cfg), "") {
+		problems = append(problems, configProblem{key, fmt.Errorf(tr("%s は不明なキーです"), key)})
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, nil, fmt.Errorf(tr("%s の形式が不正です: %w"), path, err)
+	}
+	return cfg, append(problems, cfg.validate()...), nil
+}
+
+// unknownConfigKeys は設定ファイルのJSONの値 v のうち、型 t に対応するフィールドがないキーのパスを返す。
+// encoding/json と同じく、キーの大文字・小文字は区別しない
+func unknownConfigKeys(v interface{}, t reflect.Type, path string) []string {
+	var keys []string
+	switch t.Kind() {
+	case reflect.Pointer:
+		return unknownConfigKeys(v, t.Elem(), path)
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if f.IsExported() && name != "" && name != "-" {
+				fields[strings.ToLower(name)] = f.Type
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := name
+			if path != "" {
+				key = path + "." + name
+			}
+			ft, ok := fields[strings.ToLower(name)]
+			if !ok {
+				keys = append(keys, key)
+				continue
+			}
+			keys = append(keys, unknownConfigKeys(obj[name], ft, key)...)
+		}
+	case reflect.Slice:
+		arr, _ := v.([]interface{})
+		for i, e := range arr {
+			keys = append(keys, unknownConfigKeys(e, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Map:
+		obj, _ := v.(map[string]interface{})
+		for name, e := range obj {
+			keys = append(keys, unknownConfigKeys(e, t.Elem(), path+"."+name)...)
+		}
+	}
+	return keys
+}
+
+// validate は設定の値を検証し、正規表現とテンプレートを解析して設定する。見つかった問題をすべて返す
+func (c *appConfig) validate() []configProblem {
+	var problems []configProblem
+	add := func(key string, err error) {
+		problems = append(problems, configProblem{key, err})
+	}
+	catchAll := -1
+	for i, rule := range c.EmojiRules {
+		key := fmt.Sprintf("emoji_rules[%d]", i)
+		if rule.Emoji == "" {
+			add(key+".emoji", fmt.Errorf(tr("emoji_rules[%d] に emoji が指定されていません"), i))
+		}
+		if rule.MinDistanceKm < 0 || rule.MinElevationM < 0 {
+			add(key, fmt.Errorf(tr("emoji_rules[%d] の min_distance_km・min_elevation_m に負の値が指定されています"), i))
+		}
+		if catchAll >= 0 {
+			add(key, fmt.Errorf(tr("emoji_rules[%d] は条件のない emoji_rules[%d] より後にあるため使われません"), i, catchAll))
+		} else if len(rule.Keywords) == 0 && rule.MinDistanceKm <= 0 && rule.MinElevationM <= 0 {
+			catchAll = i
+		}
+	}
+	seenAccounts := make(map[string]struct{})
+	for i, acc := range c.Accounts {
+		key := fmt.Sprintf("accounts[%d].name", i)
+		if acc.Name == "" || strings.ContainsAny(acc.Name, `/\. `) {
+			add(key, fmt.Errorf(tr("accounts[%d] の name が空か、使えない文字 (/ \\ . 空白) を含んでいます"), i))
+		} else if _, ok := seenAccounts[acc.Name]; ok {
+			add(key, fmt.Errorf(tr("accounts[%d] の name '%s' が重複しています"), i, acc.Name))
+		}
+		seenAccounts[acc.Name] = struct{}{}
+	}
+	if n := len(c.ABTest); n != 0 && n != 2 {
+		add("ab_test", fmt.Errorf(tr("ab_test には戦略を2つ指定してください (%d個指定されています)"), n))
+	}
+	for i, v := range c.ABTest {
+		if v.Name == "" || v.PaceFactor < 0 {
+			add(fmt.Sprintf("ab_test[%d]", i), fmt.Errorf(tr("ab_test[%d] の name が空か、pace_factor が負の値です"), i))
+		}
+	}
+	if len(c.ABTest) == 2 && c.ABTest[0].Name == c.ABTest[1].Name {
+		add("ab_test[1].name", fmt.Errorf(tr("ab_test の2つの戦略の name '%s' が重複しています"), c.ABTest[0].Name))
+	}
+	if _, ok := prioritizers[c.QueueOrder]; c.QueueOrder != "" && !ok {
+		add("queue_order", fmt.Errorf(tr("queue_order には %s のいずれかを指定してください: %s"), prioritizerNames, c.QueueOrder))
+	}
+	for _, step := range []struct {
+		key    string
+		policy *retryPolicy
+	}{{"retry.login", &c.Retry.Login}, {"retry.navigation", &c.Retry.Navigation}, {"retry.reaction", &c.Retry.Reaction}} {
+		if err := step.policy.parse(step.key); err != nil {
+			add(step.key, err)
+		}
+	}
+	for i, pattern := range c.ExcludeAuthors.NamePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			add(fmt.Sprintf("exclude_authors.name_patterns[%d]", i), fmt.Errorf(tr("exclude_authors.name_patterns[%d] の正規表現が不正です: %w"), i, err))
+			continue
+		}
+		c.ExcludeAuthors.namePatterns = append(c.ExcludeAuthors.namePatterns, re)
+	}
+	for _, list := range []struct {
+		key string
+		ids []int64
+	}{{"exclude_authors.ids", c.ExcludeAuthors.IDs}, {"follow_commenters_allow", c.FollowCommentersAllow}, {"follow_commenters_deny", c.FollowCommentersDeny}} {
+		for i, id := range list.ids {
+			if id <= 0 {
+				add(fmt.Sprintf("%s[%d]", list.key, i), fmt.Errorf(tr("%s[%d] のユーザーIDが不正です: %d"), list.key, i, id))
+			}
+		}
+	}
+	for i, id := range c.FollowCommentersDeny {
+		if slices.Contains(c.FollowCommentersAllow, id) {
+			add(fmt.Sprintf("follow_commenters_deny[%d]", i), fmt.Errorf(tr("ユーザーID %d が follow_commenters_allow と follow_commenters_deny の両方に指定されています"), id))
+		}
+	}
+	for i, pattern := range c.SpamComments.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			add(fmt.Sprintf("spam_comments.patterns[%d]", i), fmt.Errorf(tr("spam_comments.patterns[%d] の正規表現が不正です: %w"), i, err))
+			continue
+		}
+		c.SpamComments.patterns = append(c.SpamComments.patterns, re)
+	}
+	c.ActivitySearch.validate(add)
+	c.FeedEnd.validate(add)
+	c.BlockRequests.validate(add)
+	for _, list := range []struct {
+		key string
+		ids []int64
+	}{{"watch.mountains", c.Watch.Mountains}, {"watch.landmarks", c.Watch.Landmarks}} {
+		for i, id := range list.ids {
+			if id <= 0 {
+				add(fmt.Sprintf("%s[%d]", list.key, i), fmt.Errorf(tr("%s[%d] のIDが不正です: %d"), list.key, i, id))
+			}
+		}
+	}
+	if c.Watch.Interval != "" {
+		d, err := time.ParseDuration(c.Watch.Interval)
+		if err != nil || d < time.Minute {
+			add("watch.interval", fmt.Errorf(tr("watch.interval には1分以上の時間 (例: 30m) を指定してください: %s"), c.Watch.Interval))
+		}
+		c.Watch.interval = d
+	}
+	if c.Watch.MinDistanceKm < 0 || c.Watch.MinElevationM < 0 {
+		add("watch", errors.New(tr("watch の min_distance_km・min_elevation_m に負の値が指定されています")))
+	}
+	for i, text := range c.Alerts {
+		rule, err := parseAlertRule(text)
+		if err != nil {
+			add(fmt.Sprintf("alerts[%d]", i), fmt.Errorf(tr("alerts[%d] が不正です: %w"), i, err))
+			continue
+		}
+		c.alerts = append(c.alerts, rule)
+	}
+	for i, text := range c.StopWhen {
+		rule, err := parseStopRule(text)
+		if err != nil {
+			add(fmt.Sprintf("stop_when[%d]", i), fmt.Errorf(tr("stop_when[%d] が不正です: %w"), i, err))
+			continue
+		}
+		c.stopWhen = append(c.stopWhen, rule)
+	}
+	var err error
+	if c.commentTemplates, err = parseCommentTemplates("comment_templates", c.CommentTemplates); err != nil {
+		add("comment_templates", err)
+	}
+	if c.thankYouTemplates, err = parseCommentTemplates("thank_you_templates", c.ThankYouTemplates); err != nil {
+		add("thank_you_templates", err)
+	}
+	if c.crosspostTemplates, err = parseCommentTemplates("crosspost_templates", c.CrosspostTemplates); err != nil {
+		add("crosspost_templates", err)
+	}
+	if c.Profile.Bio != "" {
+		if c.Profile.bio, err = template.New("profile.bio").Option("missingkey=error").Parse(c.Profile.Bio); err != nil {
+			add("profile.bio", fmt.Errorf(tr("profile.bio のテンプレートの解析に失敗しました: %w"), err))
+		}
+	}
+	if c.ActivityUploadDescription != "" {
+		if c.activityUploadDescription, err = template.New("activity_upload_description").Option("missingkey=error").Parse(c.ActivityUploadDescription); err != nil {
+			add("activity_upload_description", fmt.Errorf(tr("activity_upload_description のテンプレートの解析に失敗しました: %w"), err))
+		}
+	}
+	if c.PhotoCaptionTemplate != "" {
+		if c.photoCaptionTemplate, err = template.New("photo_caption_template").Option("missingkey=error").Parse(c.PhotoCaptionTemplate); err != nil {
+			add("photo_caption_template", fmt.Errorf(tr("photo_caption_template のテンプレートの解析に失敗しました: %w"), err))
+		}
+	}
+	return problems
+}
+
+// runConfigValidate はブラウザを起動せずに -config の設定ファイルを検証し、見つかった問題をすべて標準出力に表示する。
+// -output ndjson の場合は問題を1行ずつ {"key": ..., "message": ...} のJSONで出力する。問題があればエラーを返す
+func runConfigValidate() error {
+	if configPath == "" {
+		return errors.New(tr("-config で検証する設定ファイルを指定してください"))
+	}
+	_, problems, err := parseConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		if outputFormat == "ndjson" {
+			line, _ := json.Marshal(map[string]string{"key": p.Key, "message": p.Err.Error()})
+			fmt.Println(string(line))
+		} else {
+			fmt.Printf("%s: %v\n", p.Key, p.Err)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf(tr("%s に %d 件の問題があります"), configPath, len(problems))
+	}
+	log.Printf(tr("%s に問題は見つかりませんでした。"), configPath)
+	return nil
+}
@@ -0,0 +1,162 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// Logger はログの出力先。*log.Logger と同じメソッドを持つ。埋め込み (WithLogger) やテストで出力を差し替えられるよう、
+// コンテキストを受け取る処理は log パッケージを直接使わず loggerFromContext(ctx) に出力する
+type Logger interface {
+	Print(v ...any)
+	Printf(format string, v ...any)
+	Println(v ...any)
+}
+
+type loggerContextKey struct{}
+
+// withLogger はログの出力先を紐づけたコンテキストを返す
+func withLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, l)
+}
+
+// loggerFromContext はコンテキストに紐づくログの出力先を返す。紐づいていない場合 (ブラウザの起動に失敗して ctx が nil の場合を含む) は
+// 標準の log パッケージの出力先 (秘密の値を伏せて標準エラー出力とダッシュボードに書く) を返す
+func loggerFromContext(ctx context.Context) Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(loggerContextKey{}).(Logger); ok {
+			return l
+		}
+	}
+	return log.Default()
+}
+
+// Hooks は処理の各段階で呼び出す関数。Client の WithHooks で指定すると、アクションのコードを変えずに
+// 個々の段階を観測したり (独自の指標への送信など)、取りやめたり (収集した投稿の独自の絞り込みなど) できる。
+// 設定していない関数は呼ばない
+type Hooks struct {
+	// OnCollected は投稿をリアクションの対象に加える前に呼ぶ。false を返すと対象に加えない
+	OnCollected func(ctx context.Context, activity ActivityInfo) bool
+	// OnReaction は投稿1件のリアクションを終えたあとに呼ぶ。sent は送信した絵文字 (送信しなかった場合は空)、err は失敗またはスキップの理由
+	OnReaction func(ctx context.Context, activity ActivityInfo, sent string, err error)
+	// OnError は処理の段階 (reaction, comment, scroll) が失敗したときに呼ぶ。スキップは含まない
+	OnError func(ctx context.Context, step string, err error)
+	// OnScroll は続きを読み込むためのスクロールを終えたあとに、読み込まれている件数を渡して呼ぶ。
+	// エラーを返すとスクロールの失敗として扱い、収集を終える
+	OnScroll func(ctx context.Context, loaded int) error
+}
+
+type hooksContextKey struct{}
+
+// withHooks は処理の各段階で呼び出す関数を紐づけたコンテキストを返す
+func withHooks(ctx context.Context, h *Hooks) context.Context {
+	return context.WithValue(ctx, hooksContextKey{}, h)
+}
+
+// hooksFromContext はコンテキストに紐づく Hooks を返す。紐づいていない場合は何も呼ばない空の Hooks を返す
+func hooksFromContext(ctx context.Context) *Hooks {
+	if ctx != nil {
+		if h, ok := ctx.Value(hooksContextKey{}).(*Hooks); ok && h != nil {
+			return h
+		}
+	}
+	return &Hooks{}
+}
+
+// collected は OnCollected を呼び、投稿を対象に加えてよいかを返す
+func (h *Hooks) collected(ctx context.Context, activity ActivityInfo) bool {
+	if h.OnCollected == nil || h.OnCollected(ctx, activity) {
+		return true
+	}
+	loggerFromContext(ctx).Printf(tr("フックにより投稿を対象から除きます: %s"), activity.URL)
+	return false
+}
+
+// reacted は OnReaction を呼ぶ
+func (h *Hooks) reacted(ctx context.Context, activity ActivityInfo, sent string, err error) {
+	if h.OnReaction != nil {
+		h.OnReaction(ctx, activity, sent, err)
+	}
+}
+
+// failed は OnError を呼ぶ
+func (h *Hooks) failed(ctx context.Context, step string, err error) {
+	if h.OnError != nil {
+		h.OnError(ctx, step, err)
+	}
+}
+
+// scrolled は OnScroll を呼ぶ
+func (h *Hooks) scrolled(ctx context.Context, loaded int) error {
+	if h.OnScroll == nil {
+		return nil
+	}
+	if err := h.OnScroll(ctx, loaded); err != nil {
+		return fmt.Errorf(tr("フックによりスクロールを終了します: %w"), err)
+	}
+	return nil
+}
+
+// session はログイン後に判明したアカウントの情報を保持し、コンテキストを通じて全てのアクションから参照できるようにする
+type session struct {
+	// UserID はログイン中のユーザーのID。取得できなかった場合は0
+	UserID int64
+	// UserName はログイン中のユーザーの表示名。取得できなかった場合は空
+	UserName string
+}
+
+type sessionContextKey struct{}
+
+// withSession はセッション情報を紐づけたコンテキストを返す
+func withSession(ctx context.Context, sess *session) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, sess)
+}
+
+// sessionFromContext はコンテキストに紐づくセッション情報を返す。ログイン前は空のセッションを返す
+func sessionFromContext(ctx context.Context) *session {
+	if sess, ok := ctx.Value(sessionContextKey{}).(*session); ok {
+		return sess
+	}
+	return &session{}
+}
+
+// sessionDiscoveryScript はログイン中のユーザーIDと名前を取得するスクリプト。
+// NUXTのストアにあるログインユーザーの情報を優先し、見つからない場合はヘッダーのプロフィールリンクから取得する。
+const sessionDiscoveryScript = `(() => {
+	const state = (window.__NUXT__ && window.__NUXT__.state) || {};
+	const candidates = [
+		state.auth && state.auth.user,
+		state.auth && state.auth.me,
+		state.user && state.user.me,
+		state.me,
+		state.viewer,
+	];
+	for (const user of candidates) {
+		if (user && user.id) {
+			return {id: Number(user.id), name: user.name || user.nickname || ""};
+		}
+	}
+	const link = document.querySelector('header a[href^="/users/"], nav a[href^="/users/"]');
+	if (link) {
+		const m = link.getAttribute("href").match(/^\/users\/(\d+)/);
+		if (m) {
+			return {id: Number(m[1]), name: (link.getAttribute("aria-label") || link.textContent || "").trim()};
+		}
+	}
+	return null;
+})()`
+
+// discoverSession はログイン直後のページから自分のユーザーIDを取得する。取得できなくても処理は継続する
+func discoverSession(ctx context.Context) *session {
+	var res *struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := runActions(ctx, driverFromContext(ctx).Evaluate(sessionDiscoveryScript, &res)); err != nil || res == nil || res.ID == 0 {
+		loggerFromContext(ctx).Printf(tr("警告: 自分のユーザーIDを取得できませんでした: %v"), err)
+		return &session{}
+	}
+	loggerFromContext(ctx).Printf(tr("ログイン中のユーザー: %s (ID: %d)"), res.Name, res.ID)
+	return &session{UserID: res.ID, UserName: res.Name}
+}
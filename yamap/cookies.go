@@ -0,0 +1,327 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// cookiesPath は -cookies フラグで指定された、auth-import-cookies で読み込み auth-export-cookies で書き出すクッキーのファイルのパス
+var cookiesPath string
+
+// defaultSessionCookiesFile は auth-import-cookies で取り込んだクッキーを保存するファイルの既定のパス
+const defaultSessionCookiesFile = "session-cookies.json"
+
+// sessionCookiesFilePath は取り込んだクッキーを保存するファイルのパス (SESSION_COOKIES_FILE、既定値 session-cookies.json)
+func sessionCookiesFilePath() string {
+	if path := os.Getenv("SESSION_COOKIES_FILE"); path != "" {
+		return path
+	}
+	return defaultSessionCookiesFile
+}
+
+// browserCookie はブラウザ拡張 (Cookie-Editor, EditThisCookie など) が読み書きするJSON形式のクッキー。
+// 読み込みでは Puppeteer・Playwright の形式 (expires, 先頭が大文字の sameSite) も受け付ける
+type browserCookie struct {
+	Domain         string  `json:"domain"`
+	ExpirationDate float64 `json:"expirationDate,omitempty"`
+	Expires        float64 `json:"expires,omitempty"`
+	HostOnly       bool    `json:"hostOnly"`
+	HTTPOnly       bool    `json:"httpOnly"`
+	Name           string  `json:"name"`
+	Path           string  `json:"path"`
+	SameSite       string  `json:"sameSite,omitempty"`
+	Secure         bool    `json:"secure"`
+	Session        bool    `json:"session"`
+	Value          string  `json:"value"`
+}
+
+// expiry はクッキーの有効期限を返す。ブラウザを閉じるまでのセッションクッキーの場合はゼロ値
+func (c browserCookie) expiry() time.Time {
+	sec := c.ExpirationDate
+	if sec == 0 {
+		sec = c.Expires
+	}
+	if c.Session || sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), 0)
+}
+
+// hostOnly はドメイン属性のない (下位のドメインに送られない) クッキーかを返す。
+// hostOnly を持たない形式では、ドメインが "." で始まらないものをドメイン属性のないクッキーとみなす
+func (c browserCookie) hostOnly() bool {
+	return c.HostOnly || !strings.HasPrefix(c.Domain, ".")
+}
+
+// sameSite はJSONの sameSite をCDPの値に変換する
+func (c browserCookie) sameSite() network.CookieSameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return network.CookieSameSiteStrict
+	case "lax":
+		return network.CookieSameSiteLax
+	case "none", "no_restriction":
+		return network.CookieSameSiteNone
+	}
+	return ""
+}
+
+// param はクッキーをブラウザに設定するCDPのパラメーターに変換する。
+// ドメイン属性のないクッキーはドメインを指定すると下位のドメインにも送られてしまうため、URLで指定する
+func (c browserCookie) param() *network.CookieParam {
+	p := &network.CookieParam{Name: c.Name, Value: c.Value, Path: c.Path,
+		Secure: c.Secure, HTTPOnly: c.HTTPOnly, SameSite: c.sameSite()}
+	if c.hostOnly() {
+		p.URL = "https://" + c.Domain + c.Path
+	} else {
+		p.Domain = c.Domain
+	}
+	if exp := c.expiry(); !exp.IsZero() {
+		expires := cdp.TimeSinceEpoch(exp)
+		p.Expires = &expires
+	}
+	return p
+}
+
+// browserCookieFrom はCDPで取得したクッキーをJSON形式のクッキーに変換する
+func browserCookieFrom(ck *network.Cookie) browserCookie {
+	c := browserCookie{Domain: ck.Domain, HostOnly: !strings.HasPrefix(ck.Domain, "."), HTTPOnly: ck.HTTPOnly,
+		Name: ck.Name, Path: ck.Path, Secure: ck.Secure, Session: ck.Session, Value: ck.Value, SameSite: "unspecified"}
+	if !ck.Session {
+		c.ExpirationDate = ck.Expires
+	}
+	switch ck.SameSite {
+	case network.CookieSameSiteStrict:
+		c.SameSite = "strict"
+	case network.CookieSameSiteLax:
+		c.SameSite = "lax"
+	case network.CookieSameSiteNone:
+		c.SameSite = "no_restriction"
+	}
+	return c
+}
+
+// isYamapCookie はクッキーがyamap.comとそのサブドメインに送られるものかを返す
+func isYamapCookie(c browserCookie) bool {
+	domain := strings.TrimPrefix(c.Domain, ".")
+	return domain == "yamap.com" || strings.HasSuffix(domain, ".yamap.com")
+}
+
+// parseCookies はJSON形式 (クッキーの配列、または cookies を持つ Playwright の storageState) と
+// Netscape形式 (cookies.txt) のクッキーを読み込む。形式は内容から判別する
+func parseCookies(data []byte) ([]browserCookie, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New(tr("クッキーのファイルが空です"))
+	}
+	switch trimmed[0] {
+	case '[':
+		var cookies []browserCookie
+		if err := json.Unmarshal(trimmed, &cookies); err != nil {
+			return nil, fmt.Errorf(tr("JSON形式のクッキーを読み込めません: %w"), err)
+		}
+		return cookies, nil
+	case '{':
+		var state struct {
+			Cookies []browserCookie `json:"cookies"`
+		}
+		if err := json.Unmarshal(trimmed, &state); err != nil {
+			return nil, fmt.Errorf(tr("JSON形式のクッキーを読み込めません: %w"), err)
+		}
+		return state.Cookies, nil
+	}
+	return parseNetscapeCookies(trimmed)
+}
+
+// parseNetscapeCookies はcurlやブラウザ拡張が書き出すNetscape形式のクッキーを読み込む。
+// 各行はタブ区切りでドメイン・サブドメインに送るか・パス・Secure・有効期限・名前・値を表す
+func parseNetscapeCookies(data []byte) ([]browserCookie, error) {
+	var cookies []browserCookie
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf(tr("Netscape形式のクッキーの %d 行目を読み込めません"), i+1)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf(tr("Netscape形式のクッキーの %d 行目の有効期限が不正です: %s"), i+1, fields[4])
+		}
+		cookies = append(cookies, browserCookie{
+			Domain:         fields[0],
+			HostOnly:       !strings.EqualFold(fields[1], "TRUE"),
+			Path:           fields[2],
+			Secure:         strings.EqualFold(fields[3], "TRUE"),
+			ExpirationDate: float64(expires),
+			Session:        expires == 0,
+			Name:           fields[5],
+			Value:          fields[6],
+			HTTPOnly:       httpOnly,
+		})
+	}
+	return cookies, nil
+}
+
+// formatNetscapeCookies はクッキーをNetscape形式で書き出す
+func formatNetscapeCookies(cookies []browserCookie) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Netscape HTTP Cookie File\n")
+	upper := func(b bool) string {
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+	for _, c := range cookies {
+		domain := c.Domain
+		if c.HTTPOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if exp := c.expiry(); !exp.IsZero() {
+			expires = exp.Unix()
+		}
+		fmt.Fprintf(&buf, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, upper(!c.hostOnly()), c.Path, upper(c.Secure), expires, c.Name, c.Value)
+	}
+	return buf.Bytes()
+}
+
+// writeCookiesFile はクッキーを拡張子が .txt の場合はNetscape形式、それ以外はJSON形式で書き出す。
+// セッションを引き継げる値のため、所有者だけが読めるようにする
+func writeCookiesFile(path string, cookies []browserCookie) error {
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".txt") {
+		data = formatNetscapeCookies(cookies)
+	} else {
+		var err error
+		if data, err = json.MarshalIndent(cookies, "", "  "); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf(tr("%s への書き込みに失敗: %w"), path, err)
+	}
+	return nil
+}
+
+// runImportCookies は普段のブラウザから書き出したクッキー (-cookies) のうちyamap.comのものを取り込み、
+// 次回以降のログインで使うように SESSION_COOKIES_FILE に保存する。有効期限が過ぎたクッキーは取り込まない
+func runImportCookies() error {
+	if cookiesPath == "" {
+		return errors.New(tr("-cookies で取り込むクッキーのファイルを指定してください"))
+	}
+	data, err := os.ReadFile(cookiesPath)
+	if err != nil {
+		return err
+	}
+	cookies, err := parseCookies(data)
+	if err != nil {
+		return err
+	}
+	var imported []browserCookie
+	expired := 0
+	for _, c := range cookies {
+		if !isYamapCookie(c) {
+			continue
+		}
+		if exp := c.expiry(); !exp.IsZero() && exp.Before(time.Now()) {
+			expired++
+			continue
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		redaction.add(c.Value)
+		imported = append(imported, c)
+	}
+	if len(imported) == 0 {
+		return fmt.Errorf(tr("%s に有効なyamap.comのクッキーがありません"), cookiesPath)
+	}
+	if expired > 0 {
+		log.Printf(tr("警告: 有効期限が過ぎたクッキー %d 件は取り込みませんでした。"), expired)
+	}
+	path := sessionCookiesFilePath()
+	if err := writeCookiesFile(path, imported); err != nil {
+		return err
+	}
+	log.Printf(tr("yamap.comのクッキー %d 件を %s に取り込みました。次回のログインからこのセッションを使います。"), len(imported), path)
+	return nil
+}
+
+// runExportCookies はログインしたブラウザのyamap.comのクッキーを -cookies (既定値 cookies.json) に書き出す (Chromeのみ)
+func runExportCookies() error {
+	if !usingChrome() {
+		return errors.New(tr("auth-export-cookies はChromeでのみ使えます"))
+	}
+	path := cookiesPath
+	if path == "" {
+		path = "cookies.json"
+	}
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	var cookies []browserCookie
+	for _, ck := range browserCookies(ctx) {
+		cookies = append(cookies, browserCookieFrom(ck))
+	}
+	if len(cookies) == 0 {
+		return errors.New(tr("ブラウザからyamap.comのクッキーを取得できませんでした"))
+	}
+	if err := writeCookiesFile(path, cookies); err != nil {
+		return err
+	}
+	loggerFromContext(ctx).Printf(tr("yamap.comのクッキー %d 件を %s に書き出しました。"), len(cookies), path)
+	return nil
+}
+
+// restoreSessionCookies は auth-import-cookies で取り込んだクッキーがあればブラウザに設定し、設定したかを返す
+func restoreSessionCookies(ctx context.Context) bool {
+	if !usingChrome() {
+		return false
+	}
+	path := sessionCookiesFilePath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			loggerFromContext(ctx).Printf(tr("警告: 取り込んだクッキーを読み込めません: %v"), err)
+		}
+		return false
+	}
+	cookies, err := parseCookies(data)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 取り込んだクッキーを読み込めません: %v"), err)
+		return false
+	}
+	params := make([]*network.CookieParam, 0, len(cookies))
+	for _, c := range cookies {
+		redaction.add(c.Value)
+		params = append(params, c.param())
+	}
+	if err := chromedp.Run(ctx, network.SetCookies(params)); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 取り込んだクッキーを設定できません: %v"), err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,238 @@
+package yamap
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// credentials は資格情報ファイルに暗号化して保存する内容
+type credentials struct {
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	TOTPSecret string `json:"totp_secret,omitempty"`
+}
+
+// credentialsFile は資格情報ファイルの形式。パスフレーズからPBKDF2-SHA256で鍵を導出し、AES-256-GCMで暗号化する
+type credentialsFile struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// credentialsKDFIterations はPBKDF2の反復回数
+const credentialsKDFIterations = 600000
+
+// credentialsFilePath は資格情報ファイルのパスを返す。CREDENTIALS_FILE が未設定の場合は credentials.enc
+func credentialsFilePath() string {
+	if path := os.Getenv("CREDENTIALS_FILE"); path != "" {
+		return path
+	}
+	return "credentials.enc"
+}
+
+// readCredentialsPassphrase は資格情報ファイルのパスフレーズを取得する。
+// CREDENTIALS_KEY_FILE が設定されていればその内容を、CREDENTIALS_PASSPHRASE が設定されていればその値を使い、
+// どちらもなければ標準入力から読み込む。
+func readCredentialsPassphrase() (string, error) {
+	if keyFile := os.Getenv("CREDENTIALS_KEY_FILE"); keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", fmt.Errorf(tr("鍵ファイルの読み込みに失敗: %w"), err)
+		}
+		return strings.TrimRight(string(key), "\r\n"), nil
+	}
+	if passphrase := os.Getenv("CREDENTIALS_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	return promptLine(tr("資格情報ファイルのパスフレーズ: "))
+}
+
+// stdinReader は標準入力からの対話入力に使う共有のリーダー
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// promptLine は標準エラー出力にプロンプトを表示し、標準入力から1行読み込む
+func promptLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf(tr("標準入力の読み込みに失敗: %w"), err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// deriveCredentialsKey はパスフレーズとソルトからAES-256の鍵を導出する
+func deriveCredentialsKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+}
+
+// runAuthSet はメールアドレス・パスワード・TOTPシークレットを暗号化して資格情報ファイルに保存する。
+// 環境変数に値があればそれを使い、なければ標準入力から尋ねる。
+func runAuthSet() error {
+	var creds credentials
+	fields := []struct {
+		env      string
+		prompt   string
+		dst      *string
+		optional bool
+	}{
+		{"YAMAP_EMAIL", "メールアドレス: ", &creds.Email, false},
+		{"YAMAP_PASSWORD", "パスワード: ", &creds.Password, false},
+		{"YAMAP_TOTP_SECRET", "TOTPシークレット (不要なら空のまま Enter): ", &creds.TOTPSecret, true},
+	}
+	for _, f := range fields {
+		if v := os.Getenv(f.env); v != "" {
+			*f.dst = v
+			continue
+		}
+		v, err := promptLine(tr(f.prompt))
+		if err != nil {
+			return err
+		}
+		if v == "" && !f.optional {
+			return fmt.Errorf(tr("%s が入力されませんでした"), f.env)
+		}
+		*f.dst = v
+	}
+
+	passphrase, err := readCredentialsPassphrase()
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return errors.New(tr("パスフレーズが空です"))
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := deriveCredentialsKey(passphrase, salt, credentialsKDFIterations)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	file := credentialsFile{
+		Version:    1,
+		KDF:        "pbkdf2-sha256",
+		Iterations: credentialsKDFIterations,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(gcm.Seal(nil, nonce, plaintext, nil)),
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := credentialsFilePath()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf(tr("%s への書き込みに失敗: %w"), path, err)
+	}
+	log.Printf(tr("資格情報を暗号化して %s に保存しました。"), path)
+	return nil
+}
+
+// loadCredentialsFile は CREDENTIALS_FILE が設定されている場合に資格情報ファイルを復号し、
+// 未設定の YAMAP_EMAIL, YAMAP_PASSWORD, YAMAP_TOTP_SECRET を環境変数として設定する。
+func loadCredentialsFile() error {
+	path := os.Getenv("CREDENTIALS_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file credentialsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf(tr("資格情報ファイルの形式が不正です: %w"), err)
+	}
+	if file.Version != 1 || file.KDF != "pbkdf2-sha256" {
+		return fmt.Errorf(tr("未対応の資格情報ファイルです (version=%d, kdf=%s)"), file.Version, file.KDF)
+	}
+	if file.Iterations <= 0 {
+		return fmt.Errorf(tr("資格情報ファイルの反復回数が不正です: %d"), file.Iterations)
+	}
+	salt, err := base64.StdEncoding.DecodeString(file.Salt)
+	if err != nil {
+		return fmt.Errorf(tr("ソルトの読み込みに失敗: %w"), err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(file.Nonce)
+	if err != nil {
+		return fmt.Errorf(tr("ノンスの読み込みに失敗: %w"), err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(file.Ciphertext)
+	if err != nil {
+		return fmt.Errorf(tr("暗号文の読み込みに失敗: %w"), err)
+	}
+
+	passphrase, err := readCredentialsPassphrase()
+	if err != nil {
+		return err
+	}
+	key, err := deriveCredentialsKey(passphrase, salt, file.Iterations)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	// 長さの異なるノンスを Open に渡すとパニックになるため、先に確かめる
+	if len(nonce) != gcm.NonceSize() {
+		return fmt.Errorf(tr("ノンスの長さが不正です (%d バイト)"), len(nonce))
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return errors.New(tr("復号に失敗しました。パスフレーズが正しいか確認してください"))
+	}
+	var creds credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return fmt.Errorf(tr("復号した資格情報の形式が不正です: %w"), err)
+	}
+
+	for env, value := range map[string]string{
+		"YAMAP_EMAIL":       creds.Email,
+		"YAMAP_PASSWORD":    creds.Password,
+		"YAMAP_TOTP_SECRET": creds.TOTPSecret,
+	} {
+		if os.Getenv(env) == "" && value != "" {
+			os.Setenv(env, value)
+		}
+	}
+	log.Printf(tr("資格情報ファイル %s を読み込みました。"), path)
+	return nil
+}
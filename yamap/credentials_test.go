@@ -0,0 +1,126 @@
+package yamap
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// saveTestCredentials は runAuthSet で資格情報ファイルを作成し、そのパスを返す。
+// 保存に使った環境変数は空に戻し、loadCredentialsFile が設定した値だけが残るようにする
+func saveTestCredentials(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.enc")
+	t.Setenv("CREDENTIALS_FILE", path)
+	t.Setenv("CREDENTIALS_KEY_FILE", "")
+	t.Setenv("CREDENTIALS_PASSPHRASE", "correct horse battery staple")
+	t.Setenv("YAMAP_EMAIL", "hiker@example.com")
+	t.Setenv("YAMAP_PASSWORD", "p@ss")
+	t.Setenv("YAMAP_TOTP_SECRET", "JBSWY3DPEHPK3PXP")
+	if err := runAuthSet(); err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"YAMAP_EMAIL", "YAMAP_PASSWORD", "YAMAP_TOTP_SECRET"} {
+		os.Setenv(env, "")
+	}
+	return path
+}
+
+// rewriteCredentialsFile は資格情報ファイルの内容を edit で書き換える
+func rewriteCredentialsFile(t *testing.T, path string, edit func(f *credentialsFile)) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file credentialsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	edit(&file)
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCredentialsFileRoundTrip(t *testing.T) {
+	path := saveTestCredentials(t)
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("credentials file: %v, %v; want mode 0600", info, err)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "hiker@example.com") {
+		t.Error("credentials file contains the plaintext email")
+	}
+	if err := loadCredentialsFile(); err != nil {
+		t.Fatal(err)
+	}
+	for env, want := range map[string]string{
+		"YAMAP_EMAIL":       "hiker@example.com",
+		"YAMAP_PASSWORD":    "p@ss",
+		"YAMAP_TOTP_SECRET": "JBSWY3DPEHPK3PXP",
+	} {
+		if got := os.Getenv(env); got != want {
+			t.Errorf("%s = %q, want %q", env, got, want)
+		}
+	}
+}
+
+func TestCredentialsFileWrongPassphrase(t *testing.T) {
+	saveTestCredentials(t)
+	t.Setenv("CREDENTIALS_PASSPHRASE", "wrong passphrase")
+	err := loadCredentialsFile()
+	if err == nil || !strings.Contains(err.Error(), tr("復号に失敗しました。パスフレーズが正しいか確認してください")) {
+		t.Errorf("loadCredentialsFile error = %v, want a decryption failure", err)
+	}
+	if os.Getenv("YAMAP_EMAIL") != "" {
+		t.Error("YAMAP_EMAIL was set despite the wrong passphrase")
+	}
+}
+
+func TestCredentialsFileCorrupted(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(f *credentialsFile)
+	}{
+		{"tampered ciphertext", func(f *credentialsFile) {
+			raw, _ := base64.StdEncoding.DecodeString(f.Ciphertext)
+			raw[0] ^= 0xff
+			f.Ciphertext = base64.StdEncoding.EncodeToString(raw)
+		}},
+		{"truncated ciphertext", func(f *credentialsFile) { f.Ciphertext = f.Ciphertext[:8] }},
+		{"short nonce", func(f *credentialsFile) { f.Nonce = base64.StdEncoding.EncodeToString([]byte("short")) }},
+		{"empty nonce", func(f *credentialsFile) { f.Nonce = "" }},
+		{"zero iterations", func(f *credentialsFile) { f.Iterations = 0 }},
+		{"negative iterations", func(f *credentialsFile) { f.Iterations = -1 }},
+		{"invalid base64", func(f *credentialsFile) { f.Salt = "!!" }},
+		{"unsupported kdf", func(f *credentialsFile) { f.KDF = "scrypt" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := saveTestCredentials(t)
+			rewriteCredentialsFile(t, path, tt.edit)
+			if err := loadCredentialsFile(); err == nil {
+				t.Error("loadCredentialsFile succeeded on a corrupted file")
+			}
+			if os.Getenv("YAMAP_EMAIL") != "" {
+				t.Error("YAMAP_EMAIL was set from a corrupted file")
+			}
+		})
+	}
+
+	t.Run("not json", func(t *testing.T) {
+		path := saveTestCredentials(t)
+		if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadCredentialsFile(); err == nil {
+			t.Error("loadCredentialsFile succeeded on a broken JSON file")
+		}
+	})
+}
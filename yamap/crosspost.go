@@ -0,0 +1,284 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultCrosspostTemplate は設定ファイルの crosspost_templates が未設定の場合に使う、活動のまとめのテンプレート
+const defaultCrosspostTemplate = `{{.Title}}{{if .MountainName}} ({{.MountainName}}){{end}} {{printf "%.1f" .Distance}}km / 累積標高 {{printf "%.0f" .Elevation}}m {{.URL}}`
+
+// crosspostTarget は活動のまとめを投稿する先のSNS
+type crosspostTarget interface {
+	// Name はログに表示する投稿先の名前
+	Name() string
+	// Post は文章を投稿する
+	Post(ctx context.Context, text string) error
+}
+
+// crosspostTargets は環境変数が設定されている投稿先を返す
+func crosspostTargets() []crosspostTarget {
+	var targets []crosspostTarget
+	if base, token := os.Getenv("MASTODON_URL"), os.Getenv("MASTODON_TOKEN"); base != "" && token != "" {
+		visibility := os.Getenv("MASTODON_VISIBILITY")
+		if visibility == "" {
+			visibility = "public"
+		}
+		targets = append(targets, mastodonTarget{baseURL: strings.TrimRight(base, "/"), token: token, visibility: visibility})
+	}
+	x := xTarget{
+		consumerKey:    os.Getenv("X_API_KEY"),
+		consumerSecret: os.Getenv("X_API_SECRET"),
+		token:          os.Getenv("X_ACCESS_TOKEN"),
+		tokenSecret:    os.Getenv("X_ACCESS_SECRET"),
+	}
+	if x.consumerKey != "" && x.consumerSecret != "" && x.token != "" && x.tokenSecret != "" {
+		targets = append(targets, x)
+	}
+	return targets
+}
+
+// mastodonTarget はMastodonのインスタンスのAPI (POST /api/v1/statuses) に投稿する
+type mastodonTarget struct {
+	baseURL    string
+	token      string
+	visibility string
+}
+
+func (m mastodonTarget) Name() string { return "Mastodon" }
+
+func (m mastodonTarget) Post(ctx context.Context, text string) error {
+	form := neturl.Values{"status": {text}, "visibility": {m.visibility}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.baseURL+"/api/v1/statuses", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// 通信の失敗で再実行した場合に同じ活動を二重に投稿しないよう、文章から冪等キーを作る
+	req.Header.Set("Idempotency-Key", fmt.Sprintf("%x", sha256.Sum256([]byte(text))))
+	return sendCrosspost(req)
+}
+
+// xTarget はXのAPI (POST /2/tweets) にOAuth 1.0aのユーザーの認証で投稿する
+type xTarget struct {
+	consumerKey, consumerSecret string
+	token, tokenSecret          string
+}
+
+func (x xTarget) Name() string { return "X" }
+
+func (x xTarget) Post(ctx context.Context, text string) error {
+	const endpoint = "https://api.twitter.com/2/tweets"
+	body, _ := json.Marshal(map[string]string{"text": text})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", x.authorization(http.MethodPost, endpoint, nil, time.Now(), rand.Text()))
+	req.Header.Set("Content-Type", "application/json")
+	return sendCrosspost(req)
+}
+
+// authorization はOAuth 1.0a (HMAC-SHA1) の Authorization ヘッダーを作る。署名にはURLのクエリと form (application/x-www-form-urlencoded の本文) の
+// パラメーターを含める。JSONの本文は署名に含めないため、JSONを送る場合は form に nil を渡す
+func (x xTarget) authorization(method, endpoint string, form neturl.Values, now time.Time, nonce string) string {
+	escape := func(s string) string { return strings.ReplaceAll(neturl.QueryEscape(s), "+", "%20") }
+	oauth := map[string]string{
+		"oauth_consumer_key":     x.consumerKey,
+		"oauth_nonce":            nonce,
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(now.Unix(), 10),
+		"oauth_token":            x.token,
+		"oauth_version":          "1.0",
+	}
+	baseURL := endpoint
+	var pairs []string
+	if u, err := neturl.Parse(endpoint); err == nil {
+		baseURL = strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + u.EscapedPath()
+		for k, vs := range u.Query() {
+			for _, v := range vs {
+				pairs = append(pairs, escape(k)+"="+escape(v))
+			}
+		}
+	}
+	for k, vs := range form {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k)+"="+escape(v))
+		}
+	}
+	for k, v := range oauth {
+		pairs = append(pairs, escape(k)+"="+escape(v))
+	}
+	sort.Strings(pairs)
+	base := method + "&" + escape(baseURL) + "&" + escape(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(escape(x.consumerSecret)+"&"+escape(x.tokenSecret)))
+	mac.Write([]byte(base))
+	oauth["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	keys := make([]string, 0, len(oauth))
+	for k := range oauth {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var header []string
+	for _, k := range keys {
+		header = append(header, fmt.Sprintf(`%s="%s"`, escape(k), escape(oauth[k])))
+	}
+	return "OAuth " + strings.Join(header, ", ")
+}
+
+// sendCrosspost は投稿のリクエストを送り、2xx 以外の応答を本文の先頭とともにエラーにする
+func sendCrosspost(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return fmt.Errorf(tr("ステータス %d: %s"), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// runCrosspost は自分の最近の活動日記 CROSSPOST_ACTIVITIES 件 (既定値 5) のうち、まだ紹介していないものの
+// まとめを設定ファイルの crosspost_templates から作成し、MastodonとXに投稿する。
+// 紹介済みの活動日記は履歴に記録し、同じ活動日記を二度投稿しない
+func runCrosspost() error {
+	log.Println(tr("--- プログラム開始 (crosspost) ---"))
+	startTime := time.Now()
+	if history == nil {
+		return errors.New(tr("crosspost では紹介済みの活動日記を記録するために HISTORY_FILE を設定してください"))
+	}
+	activityCount := 5
+	if v := os.Getenv("CROSSPOST_ACTIVITIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("CROSSPOST_ACTIVITIESの値が不正です: %s"), v)
+		}
+		activityCount = n
+	}
+	targets := crosspostTargets()
+	if len(targets) == 0 {
+		return errors.New(tr("投稿先として MASTODON_URL・MASTODON_TOKEN か、X_API_KEY・X_API_SECRET・X_ACCESS_TOKEN・X_ACCESS_SECRET を設定してください"))
+	}
+	templates := config.crosspostTemplates
+	if len(templates) == 0 {
+		templates = []*template.Template{template.Must(template.New("crosspost").Parse(defaultCrosspostTemplate))}
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、自分の活動日記を確認できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	var paths []string
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", sess.UserID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(myActivityLinksScript, &paths),
+	); err != nil {
+		return fmt.Errorf(tr("自分の活動日記の一覧の取得に失敗: %w"), err)
+	}
+	if len(paths) > activityCount {
+		paths = paths[:activityCount]
+	}
+	var ids []int64
+	for _, p := range paths {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(p, "/activities/"), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	if !history.hasAnnouncedAny() {
+		// 初回は過去の活動日記をまとめて投稿しないよう、現在の活動日記を紹介済みとして記録するだけにする
+		if err := history.markAnnounced(ids, time.Now()); err != nil {
+			return fmt.Errorf(tr("紹介済みの活動日記の保存に失敗しました: %w"), err)
+		}
+		loggerFromContext(ctx).Println(tr("初回の実行のため、現在の活動日記を紹介済みとして記録しました。次回以降の実行で新しい活動日記を投稿します。"))
+		status.setPhase("done")
+		sdNotify("STOPPING=1")
+		return nil
+	}
+
+	status.setPhase("reacting")
+	posted := 0
+	// 古い活動日記から順に投稿する
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		if history.isAnnounced(id) {
+			continue
+		}
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		url := fmt.Sprintf("https://yamap.com/activities/%d", id)
+		if err := runActions(ctx, drv.Navigate(url), drv.WaitVisible(layout.footer), drv.WaitNetworkIdle()); err != nil {
+			loggerFromContext(ctx).Printf(tr("活動日記の読み込みに失敗しました (%s): %v"), url, err)
+			status.recordResult(false, err)
+			continue
+		}
+		meta, err := fetchActivityMetadata(ctx, drv)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("活動の情報の取得に失敗しました (%s): %v"), url, err)
+			status.recordResult(false, err)
+			continue
+		}
+		data := newCommentData(meta)
+		data.URL = url
+		text, err := executeTemplate(templates, data)
+		if err != nil {
+			return fmt.Errorf(tr("投稿する文章の作成に失敗しました: %w"), err)
+		}
+		ok := false
+		for _, t := range targets {
+			if err := t.Post(ctx, text); err != nil {
+				loggerFromContext(ctx).Printf(tr("%s への投稿に失敗しました (%s): %v"), t.Name(), url, err)
+				continue
+			}
+			loggerFromContext(ctx).Printf(tr("%s に投稿しました: %s"), t.Name(), text)
+			ok = true
+		}
+		status.recordResult(ok, nil)
+		// 一部の投稿先に投稿できた場合も、次回に他の投稿先へ重複して投稿しないよう紹介済みにする
+		if ok {
+			posted++
+			if err := history.markAnnounced([]int64{id}, time.Now()); err != nil {
+				return fmt.Errorf(tr("紹介済みの活動日記の保存に失敗しました: %w"), err)
+			}
+		}
+		status.markStep()
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- %d件の活動日記を紹介しました ---"), posted)
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
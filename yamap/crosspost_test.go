@@ -0,0 +1,49 @@
+package yamap
+
+import (
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestXAuthorizationDocsExample はXの開発者向けドキュメント「Creating a signature」の例
+// (POST statuses/update.json に include_entities と status を送るリクエスト) と同じ署名になることを確かめる
+func TestXAuthorizationDocsExample(t *testing.T) {
+	x := xTarget{
+		consumerKey:    "xvz1evFS4wEEPTGEFPHBog",
+		consumerSecret: "kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw",
+		token:          "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		tokenSecret:    "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE",
+	}
+	form := neturl.Values{"status": {"Hello Ladies + Gentlemen, a signed OAuth request!"}}
+	got := x.authorization(http.MethodPost, "https://api.twitter.com/1.1/statuses/update.json?include_entities=true", form,
+		time.Unix(1318622958, 0), "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg")
+	want := `OAuth oauth_consumer_key="xvz1evFS4wEEPTGEFPHBog", oauth_nonce="kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg", ` +
+		`oauth_signature="hCtSmYh%2BiHYCEqBWrE7C7hYmtUk%3D", oauth_signature_method="HMAC-SHA1", oauth_timestamp="1318622958", ` +
+		`oauth_token="370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb", oauth_version="1.0"`
+	if got != want {
+		t.Errorf("authorization =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestXAuthorizationJSONBody はJSONの本文を送る投稿 (POST /2/tweets) では、クエリも本文も署名に含めず、
+// 本文の内容によって署名が変わらないことを確かめる
+func TestXAuthorizationJSONBody(t *testing.T) {
+	x := xTarget{consumerKey: "ck", consumerSecret: "cs", token: "t", tokenSecret: "ts"}
+	now := time.Unix(1700000000, 0)
+	a := x.authorization(http.MethodPost, "https://api.twitter.com/2/tweets", nil, now, "nonce")
+	b := x.authorization(http.MethodPost, "https://API.twitter.com/2/tweets", nil, now, "nonce")
+	if a != b {
+		t.Errorf("host case changed the signature:\n%s\n%s", a, b)
+	}
+	if c := x.authorization(http.MethodPost, "https://api.twitter.com/2/tweets", nil, now, "other"); c == a {
+		t.Error("a different nonce produced the same signature")
+	}
+	for _, p := range []string{"oauth_consumer_key=", "oauth_nonce=", "oauth_signature=", "oauth_signature_method=", "oauth_timestamp=", "oauth_token=", "oauth_version="} {
+		if !strings.Contains(a, p) {
+			t.Errorf("authorization lacks %s: %s", p, a)
+		}
+	}
+}
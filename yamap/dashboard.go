@@ -0,0 +1,165 @@
+package yamap
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// dashboardLogLines はダッシュボードに表示する直近のログの行数
+const dashboardLogLines = 10
+
+// dashboardModel は -tui で表示するダッシュボードの状態 (bubbletea のモデル)
+type dashboardModel struct {
+	logs   []string
+	done   bool
+	width  int
+	height int
+}
+
+type dashboardTickMsg time.Time
+type dashboardLogMsg string
+type dashboardDoneMsg struct{}
+
+func dashboardTick() tea.Cmd {
+	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg { return dashboardTickMsg(t) })
+}
+
+func (m dashboardModel) Init() tea.Cmd {
+	return dashboardTick()
+}
+
+func (m dashboardModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			// 処理中のブラウザ操作を止めて終了する。結果の出力は各アクションに任せる
+			status.mu.Lock()
+			cancel := status.cancel
+			status.mu.Unlock()
+			if cancel != nil {
+				cancel()
+			}
+			if msg.String() == "ctrl+c" || cancel == nil {
+				return m, tea.Quit
+			}
+		}
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case dashboardLogMsg:
+		m.logs = append(m.logs, string(msg))
+		if len(m.logs) > dashboardLogLines {
+			m.logs = m.logs[len(m.logs)-dashboardLogLines:]
+		}
+	case dashboardDoneMsg:
+		m.done = true
+		return m, tea.Quit
+	case dashboardTickMsg:
+		return m, dashboardTick()
+	}
+	return m, nil
+}
+
+func (m dashboardModel) View() string {
+	r := status.report()
+	var b strings.Builder
+	fmt.Fprintf(&b, tr("yamap-auto-domo  アクション: %s  フェーズ: %s  経過: %s"), r.Action, r.Phase, r.Uptime)
+	if maxRuntime > 0 {
+		remaining := max(maxRuntime-time.Since(status.startedAt), 0)
+		fmt.Fprintf(&b, tr("  残り時間: %s"), remaining.Round(time.Second))
+	}
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, tr("処理済み %d/%d  成功 %d  失敗 %d  スキップ %d\n"), r.Processed, r.Queued, r.Succeeded, r.Failed, r.Skipped)
+	if r.CurrentURL != "" {
+		fmt.Fprintf(&b, tr("処理中: %s\n"), r.CurrentURL)
+	}
+
+	b.WriteString(tr("\n--- 待機中の投稿 ---\n"))
+	pending := status.pendingQueue()
+	if len(pending) > 0 {
+		pending = pending[1:] // 先頭は処理中の投稿
+	}
+	for i, url := range pending {
+		if i == 5 {
+			fmt.Fprintf(&b, tr("... ほか %d 件\n"), len(pending)-i)
+			break
+		}
+		b.WriteString(url + "\n")
+	}
+
+	b.WriteString(tr("\n--- 最近のログ ---\n"))
+	for _, line := range m.logs {
+		if m.width > 0 {
+			line = ansi.Truncate(line, m.width, "")
+		}
+		b.WriteString(line + "\n")
+	}
+	if !m.done {
+		b.WriteString(tr("\nq: 停止して終了  ctrl+c: 強制終了\n"))
+	}
+	return b.String()
+}
+
+// dashboardLogWriter はログをダッシュボードに送りつつ、終了後に出力できるよう全て保持する
+type dashboardLogWriter struct {
+	mu      sync.Mutex
+	program *tea.Program
+	buf     bytes.Buffer
+	all     bytes.Buffer
+}
+
+func (w *dashboardLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.all.Write(p)
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// 改行のない残りは次の書き込みまで保持する
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		if text := strings.TrimRight(line, "\n"); strings.TrimSpace(text) != "" {
+			w.program.Send(dashboardLogMsg(text))
+		}
+	}
+	return len(p), nil
+}
+
+// runWithDashboard はアクションをバックグラウンドで実行し、その間ダッシュボードを表示する。
+// 終了後は実行中のログをまとめて標準エラー出力に書き出す。
+func runWithDashboard(run func()) {
+	program := tea.NewProgram(dashboardModel{}, tea.WithAltScreen())
+	writer := &dashboardLogWriter{program: program}
+	log.SetOutput(logOutput(writer))
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				// 端末を元の画面に戻してからクラッシュレポートを残す
+				program.Kill()
+				handleCrash(r, debug.Stack())
+			}
+		}()
+		run()
+		program.Send(dashboardDoneMsg{})
+	}()
+	_, err := program.Run()
+	log.SetOutput(logOutput(console))
+	writer.mu.Lock()
+	os.Stderr.Write(writer.all.Bytes())
+	writer.mu.Unlock()
+	if err != nil {
+		log.Printf(tr("ダッシュボードの表示に失敗しました: %v"), err)
+	}
+}
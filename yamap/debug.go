@@ -0,0 +1,35 @@
+package yamap
+
+import (
+	"context"
+	"time"
+)
+
+// saveDebugSnapshot は表示中のページのスクリーンショットとHTMLを <name>_screenshot.png と <name>.html に保存する
+func saveDebugSnapshot(ctx context.Context, drv pageDriver, name string) {
+	var buf []byte
+	var htmlContent string
+	// 呼び出し元のコンテキストがキャンセル済みでも取得できるよう、独立したタイムアウトを設定する
+	snapCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	// スクリーンショットとHTMLを取得
+	if err := runActions(snapCtx,
+		drv.Screenshot(&buf),
+		drv.OuterHTML(&htmlContent),
+	); err != nil {
+		loggerFromContext(ctx).Printf(tr("デバッグ情報（スクリーンショット/HTML）の取得に失敗: %v"), err)
+		return
+	}
+	screenshotPath := debugPath(ctx, name+"_screenshot.png")
+	if err := writeArtifact(screenshotPath, buf); err != nil {
+		loggerFromContext(ctx).Printf(tr("スクリーンショットの保存に失敗: %v"), err)
+	} else {
+		loggerFromContext(ctx).Printf(tr("スクリーンショットを %s に保存しました。"), screenshotPath)
+	}
+	htmlPath := debugPath(ctx, name+".html")
+	if err := writeArtifact(htmlPath, []byte(htmlContent)); err != nil {
+		loggerFromContext(ctx).Printf(tr("HTMLの保存に失敗: %v"), err)
+	} else {
+		loggerFromContext(ctx).Printf(tr("HTMLを %s に保存しました。"), htmlPath)
+	}
+}
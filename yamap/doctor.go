@@ -0,0 +1,245 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// doctorMinFreeBytes は doctor で書き込み先のディスクに必要とする空き容量
+const doctorMinFreeBytes = 500 << 20
+
+// doctorCheck は doctor の確認項目の結果。問題がある場合は fix に対処方法を記載する
+type doctorCheck struct {
+	name   string
+	ok     bool
+	detail string
+	fix    string
+}
+
+// runDoctor は実行に必要な環境 (資格情報と件数の環境変数、書き込み先のディレクトリとディスクの空き容量、
+// yamap.comへの接続、ブラウザの起動) を確認し、問題があれば対処方法とともに表示する。
+// 問題が1件でもあればエラーを返し、定期実行の前の確認に使えるようにする
+func runDoctor() error {
+	log.Println(tr("--- プログラム開始 (doctor) ---"))
+	var checks []doctorCheck
+	checks = append(checks, doctorCredentials())
+	for _, name := range []string{"TIMELINE_POST_COUNT_TO_PROCESS", "ACTIVITIES_POST_COUNT_TO_PROCESS"} {
+		checks = append(checks, doctorPostCount(name))
+	}
+	checks = append(checks, doctorDirectories()...)
+	checks = append(checks, doctorNetwork(), doctorBrowser())
+
+	failed := 0
+	for _, c := range checks {
+		if c.ok {
+			log.Printf("OK  %-24s %s", c.name, c.detail)
+			continue
+		}
+		failed++
+		log.Printf("NG  %-24s %s", c.name, c.detail)
+		log.Printf(tr("    対処: %s"), c.fix)
+	}
+	log.Println("---------------------------------")
+	if failed > 0 {
+		return fmt.Errorf(tr("%d 件の問題が見つかりました"), failed)
+	}
+	log.Println(tr("問題は見つかりませんでした。"))
+	return nil
+}
+
+// doctorCredentials はログインに使うメールアドレスとパスワードを取得できるかを確認する
+func doctorCredentials() doctorCheck {
+	c := doctorCheck{name: tr("資格情報"), fix: tr("YAMAP_EMAIL と、YAMAP_PASSWORD・YAMAP_PASSWORD_KEYRING・CREDENTIALS_FILE のいずれかを設定してください")}
+	email := os.Getenv("YAMAP_EMAIL")
+	if email == "" {
+		c.detail = tr("YAMAP_EMAIL が設定されていません")
+		return c
+	}
+	if passwordFromStdin {
+		c.ok, c.detail = true, tr("パスワードは実行時に標準入力から読み込みます")
+		return c
+	}
+	password, err := resolvePassword(email)
+	switch {
+	case err != nil:
+		c.detail = fmt.Sprintf(tr("パスワードの取得に失敗しました: %v"), err)
+	case password == "":
+		c.detail = tr("パスワードが設定されていません")
+	default:
+		c.ok, c.detail = true, email
+	}
+	return c
+}
+
+// doctorPostCount は処理する投稿の件数の環境変数を確認する。未設定はそのアクションを使わない場合があるため問題としない
+func doctorPostCount(name string) doctorCheck {
+	c := doctorCheck{name: name, fix: fmt.Sprintf(tr("%s には1以上の整数を指定してください"), name)}
+	v := os.Getenv(name)
+	if v == "" {
+		c.ok, c.detail = true, tr("未設定 (使うアクションでは必須です)")
+		return c
+	}
+	if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+		c.detail = fmt.Sprintf(tr("値が不正です: %s"), v)
+		return c
+	}
+	c.ok, c.detail = true, v
+	return c
+}
+
+// doctorDirectories は状態・デバッグ情報の書き込み先のディレクトリに書き込めるか、空き容量が足りるかを確認する
+func doctorDirectories() []doctorCheck {
+	debug := os.Getenv("DEBUG_DIR")
+	if debugDir != "" {
+		debug = debugDir
+	}
+	dirs := []struct{ name, dir string }{{"DEBUG_DIR", debug}}
+	for _, name := range []string{"HISTORY_FILE", "COLLECTION_STATE_FILE", "SESSION_COOKIES_FILE"} {
+		if path := os.Getenv(name); path != "" {
+			dirs = append(dirs, struct{ name, dir string }{name, filepath.Dir(path)})
+		}
+	}
+	for _, name := range []string{"AUDIT_SCREENSHOT_DIR", "NUXT_ARCHIVE_DIR"} {
+		if dir := os.Getenv(name); dir != "" {
+			dirs = append(dirs, struct{ name, dir string }{name, dir})
+		}
+	}
+	if profileDir != "" {
+		dirs = append(dirs, struct{ name, dir string }{"-profile-dir", profileDir})
+	}
+
+	var checks []doctorCheck
+	diskChecked := make(map[string]bool)
+	for _, d := range dirs {
+		dir := d.dir
+		if dir == "" {
+			dir = "."
+		}
+		existing, err := writableDir(dir)
+		if err != nil {
+			checks = append(checks, doctorCheck{name: d.name, detail: fmt.Sprintf("%s: %v", dir, err),
+				fix: fmt.Sprintf(tr("%s を書き込めるディレクトリに変更するか、実行するユーザーに書き込みの権限を与えてください"), d.name)})
+			continue
+		}
+		checks = append(checks, doctorCheck{name: d.name, ok: true, detail: dir})
+		if diskChecked[existing] {
+			continue
+		}
+		diskChecked[existing] = true
+		free, err := freeDiskBytes(existing)
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+		case err != nil:
+			log.Printf(tr("警告: %s の空き容量を確認できません: %v"), existing, err)
+		case free < doctorMinFreeBytes:
+			checks = append(checks, doctorCheck{name: tr("空き容量"), detail: fmt.Sprintf("%s: %dMB", existing, free>>20),
+				fix: fmt.Sprintf(tr("%s のディスクに %dMB 以上の空きを作ってください (古いデバッグ情報は -debug-max-age・-debug-max-size で削除できます)"), existing, doctorMinFreeBytes>>20)})
+		default:
+			checks = append(checks, doctorCheck{name: tr("空き容量"), ok: true, detail: fmt.Sprintf("%s: %dMB", existing, free>>20)})
+		}
+	}
+	return checks
+}
+
+// writableDir は dir (まだない場合は作成される親のディレクトリ) に書き込めるかを一時ファイルで確かめ、確かめたディレクトリを返す
+func writableDir(dir string) (string, error) {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return "", errors.New(tr("ディレクトリではありません"))
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", err
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".doctor-*")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return dir, nil
+}
+
+// freeDiskBytes は dir のあるディスクの空き容量を df で取得する。Windows では errors.ErrUnsupported を返す
+func freeDiskBytes(dir string) (int64, error) {
+	if runtime.GOOS == "windows" {
+		return 0, errors.ErrUnsupported
+	}
+	out, err := exec.Command("df", "-Pk", dir).Output()
+	if err != nil {
+		return 0, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) < 4 {
+		return 0, fmt.Errorf(tr("df の出力を解析できません: %s"), out)
+	}
+	kb, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return kb << 10, nil
+}
+
+// doctorNetwork はyamap.comに接続できるかを確認する
+func doctorNetwork() doctorCheck {
+	c := doctorCheck{name: "yamap.com", fix: tr("ネットワークの接続、DNS、プロキシ (HTTPS_PROXY) の設定を確認してください")}
+	client := &http.Client{Timeout: 15 * time.Second}
+	start := time.Now()
+	resp, err := client.Get("https://yamap.com/")
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		c.detail = fmt.Sprintf(tr("ステータス %d"), resp.StatusCode)
+		c.fix = tr("YAMAPが障害やメンテナンス中の可能性があります。時間をおいて確認してください")
+		return c
+	}
+	c.ok, c.detail = true, fmt.Sprintf(tr("ステータス %d (%s)"), resp.StatusCode, time.Since(start).Round(time.Millisecond))
+	return c
+}
+
+// doctorBrowser は -browser のブラウザを現在の起動設定で起動できるかを確認する
+func doctorBrowser() doctorCheck {
+	c := doctorCheck{name: tr("ブラウザ") + " (" + browserKind + ")",
+		fix: tr("Google Chrome または Chromium をインストールして PATH の通った場所から起動できるようにするか、-action install-browser でダウンロードしてください。コンテナではフォントなどの依存パッケージも必要です")}
+	if browserKind == "firefox" {
+		c.fix = tr("Firefox をインストールするか、FIREFOX_PATH に実行ファイルのパスを指定してください")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	browserCtx, cancelBrowser, err := startBrowser(ctx)
+	if err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	defer cancelBrowser()
+	var ua string
+	if err := runActions(browserCtx, driverFromContext(browserCtx).Evaluate(`navigator.userAgent`, &ua)); err != nil {
+		c.detail = err.Error()
+		return c
+	}
+	c.ok, c.detail = true, ua
+	return c
+}
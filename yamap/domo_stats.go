@@ -0,0 +1,214 @@
+package yamap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// domoStats は domo-stats で書き出す、ある時点のDOMOの残高と最近の投稿が受け取ったリアクションの集計
+type domoStats struct {
+	CollectedAt time.Time `json:"collected_at"`
+	UserID      int64     `json:"user_id"`
+	// Balance はDOMOの残高。ページから読み取れなかった場合は nil
+	Balance    *int64              `json:"balance"`
+	Activities []domoActivityStats `json:"activities"`
+}
+
+// domoActivityStats は投稿1件が受け取ったDOMO・リアクションの数
+type domoActivityStats struct {
+	URL      string `json:"url"`
+	Title    string `json:"title"`
+	PostedAt string `json:"posted_at,omitempty"`
+	// Domo は投稿が受け取ったDOMOの数。ページから読み取れなかった場合は nil
+	Domo *int64 `json:"domo"`
+	// Reactions は絵文字リアクションの総数、ReactionKinds はその種類数
+	Reactions     int64 `json:"reactions"`
+	ReactionKinds int   `json:"reaction_kinds"`
+}
+
+// domoBalanceScript は表示中のページからDOMOの残高を探すスクリプト。
+// 専用の表示の構成はページの実装により異なるため、NUXTのデータ内のDOMOらしい数値の項目と、"1,234 DOMO" のような表示の順に探す
+const domoBalanceScript = `(() => {
+	const seen = new Set();
+	const find = (v, depth) => {
+		if (!v || typeof v !== "object" || depth > 6 || seen.has(v)) return null;
+		seen.add(v);
+		for (const [k, x] of Object.entries(v)) {
+			if (/^(domo_?balance|domo_?point|domo_?count|domos?)$/i.test(k) && typeof x === "number") return x;
+		}
+		for (const x of Object.values(v)) {
+			const found = find(x, depth + 1);
+			if (found !== null) return found;
+		}
+		return null;
+	};
+	const fromNuxt = find(window.__NUXT__, 0);
+	if (fromNuxt !== null) return fromNuxt;
+	const m = (document.body.innerText || "").match(/([\d,]+)\s*DOMO/i);
+	return m ? Number(m[1].replace(/,/g, "")) : -1;
+})()`
+
+// domoActivityScript は活動日記詳細ページから受け取ったDOMO・リアクションの数を取り出すスクリプト
+const domoActivityScript = `(() => {
+	const nuxt = window.__NUXT__ || {};
+	const candidates = [];
+	if (nuxt.state && nuxt.state.activity) candidates.push(nuxt.state.activity.activity, nuxt.state.activity);
+	for (const d of (nuxt.data || [])) if (d) candidates.push(d.activity, d);
+	const a = candidates.find(c => c && typeof c === "object" && ("emoji_reactions" in c || "title" in c)) || {};
+	const reactions = a.emoji_reactions || [];
+	const domo = [a.domo_count, a.domos_count, a.domo].find(v => typeof v === "number");
+	const heading = document.querySelector("h1");
+	const posted = a.created_at || a.published_at || "";
+	return {
+		title: a.title || (heading ? heading.textContent.trim() : document.title),
+		posted_at: typeof posted === "number" ? new Date(posted * 1000).toISOString() : String(posted),
+		domo: domo === undefined ? null : domo,
+		reactions: reactions.reduce((sum, r) => sum + (Number(r.count) || 1), 0),
+		reaction_kinds: reactions.length,
+	};
+})()`
+
+// runDomoStats は自分のDOMOの残高と、最近の投稿 (DOMO_STATS_COUNT 件、既定値 10) が受け取ったDOMO・リアクションの数を集計し、
+// DOMO_STATS_FILE (既定値 domo-stats.json) に書き出す。拡張子が .csv の場合は実行ごとに行を追記し、推移を追えるようにする
+func runDomoStats() error {
+	log.Println(tr("--- プログラム開始 (domo-stats) ---"))
+	startTime := time.Now()
+	count := 10
+	if v := os.Getenv("DOMO_STATS_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("DOMO_STATS_COUNTの値が不正です: %s"), v)
+		}
+		count = n
+	}
+	path := os.Getenv("DOMO_STATS_FILE")
+	if path == "" {
+		path = "domo-stats.json"
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、DOMOを集計できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+	stats := domoStats{CollectedAt: time.Now(), UserID: sess.UserID}
+
+	profileURL := fmt.Sprintf("https://yamap.com/users/%d", sess.UserID)
+	var hrefs []string
+	if err := runActions(ctx,
+		drv.Navigate(profileURL),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(`Array.from(new Set(Array.from(document.querySelectorAll('main a[href^="/activities/"]')).map(a => a.getAttribute("href").split("?")[0])))`, &hrefs),
+	); err != nil {
+		return fmt.Errorf(tr("プロフィールページの読み込みに失敗しました: %w"), err)
+	}
+	balanceURL := os.Getenv("DOMO_BALANCE_URL")
+	if balanceURL != "" {
+		if err := runActions(ctx, drv.Navigate(balanceURL), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+			loggerFromContext(ctx).Printf(tr("DOMOの残高のページの読み込みに失敗しました: %v"), err)
+		}
+	}
+	var balance int64
+	if err := runActions(ctx, drv.Evaluate(domoBalanceScript, &balance)); err != nil || balance < 0 {
+		loggerFromContext(ctx).Println(tr("警告: DOMOの残高をページから読み取れませんでした。"))
+	} else {
+		stats.Balance = &balance
+		loggerFromContext(ctx).Printf(tr("DOMOの残高: %d"), balance)
+	}
+
+	if len(hrefs) > count {
+		hrefs = hrefs[:count]
+	}
+	for i, href := range hrefs {
+		if maxRuntimeReached() || ctx.Err() != nil {
+			break
+		}
+		url := "https://yamap.com" + href
+		loggerFromContext(ctx).Printf(tr("投稿の集計中 (%d/%d): %s"), i+1, len(hrefs), url)
+		a := domoActivityStats{URL: url}
+		if err := runActions(ctx,
+			drv.Navigate(url),
+			drv.WaitVisible(layout.footer),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(domoActivityScript, &a),
+		); err != nil {
+			loggerFromContext(ctx).Printf(tr("投稿の集計に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		a.URL = url
+		stats.Activities = append(stats.Activities, a)
+		status.markStep()
+		pace.wait(ctx)
+	}
+
+	var total int64
+	for _, a := range stats.Activities {
+		total += a.Reactions
+	}
+	loggerFromContext(ctx).Printf(tr("最近の投稿 %d 件で %d 件のリアクションを受け取りました。"), len(stats.Activities), total)
+	if err := stats.write(path); err != nil {
+		return fmt.Errorf(tr("DOMOの集計の書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("DOMOの集計を %s に書き出しました。"), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// write は集計をファイルに書き出す。拡張子が .csv の場合は投稿ごとの行を追記し (新しいファイルには見出しの行を付ける)、
+// それ以外はJSONで上書きする
+func (s domoStats) write(path string) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	if errors.Is(statErr, os.ErrNotExist) {
+		w.Write([]string{"collected_at", "balance", "url", "title", "posted_at", "domo", "reactions", "reaction_kinds"})
+	}
+	optional := func(v *int64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatInt(*v, 10)
+	}
+	collectedAt := s.CollectedAt.Format(time.RFC3339)
+	if len(s.Activities) == 0 {
+		w.Write([]string{collectedAt, optional(s.Balance), "", "", "", "", "", ""})
+	}
+	for _, a := range s.Activities {
+		w.Write([]string{collectedAt, optional(s.Balance), a.URL, a.Title, a.PostedAt, optional(a.Domo),
+			strconv.FormatInt(a.Reactions, 10), strconv.Itoa(a.ReactionKinds)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
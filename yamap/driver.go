@@ -0,0 +1,280 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// browserAction はブラウザに対する一つの操作を表す。chromedp.Action と同様に runActions で順番に実行する
+type browserAction func(ctx context.Context) error
+
+// runActions は操作を順番に実行し、最初に発生したエラーを返す
+func runActions(ctx context.Context, actions ...browserAction) error {
+	for _, action := range actions {
+		if err := action(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// networkQuietPeriod は通信が途絶えてから読み込みが落ち着いたとみなすまでの時間
+const networkQuietPeriod = 500 * time.Millisecond
+
+// networkIdleTimeout は WaitNetworkIdle で待つ最大の時間
+const networkIdleTimeout = 15 * time.Second
+
+// networkIdlePollInterval は WaitNetworkIdle で通信の状態を確認する間隔
+const networkIdlePollInterval = 100 * time.Millisecond
+
+// waitResourcesIdle はネットワークのイベントを受け取れない場合の WaitNetworkIdle の実装。
+// Resource Timing APIで読み込み済みのリソース数を数え、document.readyState が complete かつ件数が
+// networkQuietPeriod の間増えなくなるまで待つ
+func waitResourcesIdle(drv pageDriver) browserAction {
+	return func(ctx context.Context) error {
+		deadline := time.Now().Add(networkIdleTimeout)
+		last, stableSince := -1, time.Now()
+		for time.Now().Before(deadline) {
+			var count int
+			if err := drv.Evaluate(`document.readyState === "complete" ? performance.getEntriesByType("resource").length : -1`, &count)(ctx); err != nil {
+				return err
+			}
+			if count < 0 || count != last {
+				last, stableSince = count, time.Now()
+			} else if time.Since(stableSince) >= networkQuietPeriod {
+				return nil
+			}
+			if err := sleepAction(networkIdlePollInterval)(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// pathOutside は url のパスが path で始まらないかを返す
+func pathOutside(url, path string) bool {
+	u, err := neturl.Parse(url)
+	return err == nil && u.Path != "" && !strings.HasPrefix(u.Path, path)
+}
+
+// navigationTimeoutError は WaitNavigatedAway の期限までにページが移動しなかったことを表す
+type navigationTimeoutError struct {
+	path    string
+	timeout time.Duration
+	url     string
+}
+
+func (e *navigationTimeoutError) Error() string {
+	return fmt.Sprintf(tr("%s 以内に %s から移動しませんでした (現在のURL: %s)"), e.timeout, e.path, e.url)
+}
+
+// waitNavigatedAwayByPolling はナビゲーションのイベントを受け取れないドライバ向けに、
+// location.pathname を networkIdlePollInterval ごとに確認して WaitNavigatedAway を実現する
+func waitNavigatedAwayByPolling(drv pageDriver, path string, timeout time.Duration) browserAction {
+	return func(ctx context.Context) error {
+		deadline := time.Now().Add(timeout)
+		for {
+			var url string
+			if err := drv.Evaluate(`location.href`, &url)(ctx); err != nil {
+				return err
+			}
+			if pathOutside(url, path) {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return &navigationTimeoutError{path: path, timeout: timeout, url: url}
+			}
+			if err := sleepAction(networkIdlePollInterval)(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sleepAction は指定時間待機する操作を返す。コンテキストがキャンセルされた場合は即座に戻る
+func sleepAction(d time.Duration) browserAction {
+	return func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+			return nil
+		}
+	}
+}
+
+// pageDriver はログイン・収集・リアクションに必要なブラウザ操作を抽象化する。
+// セレクタはすべてCSSセレクタとして扱う。
+// Chrome (chromedp) と Firefox (WebDriver BiDi) の実装があり、-browser フラグで選択する。
+type pageDriver interface {
+	Navigate(url string) browserAction
+	Reload() browserAction
+	WaitVisible(sel string) browserAction
+	Click(sel string) browserAction
+	SendKeys(sel, text string) browserAction
+	ScrollIntoView(sel string) browserAction
+	// Evaluate はJavaScriptの式を評価し、結果をJSONとして res にデコードする。res が nil の場合は結果を捨てる
+	Evaluate(expr string, res interface{}) browserAction
+	// Poll は式が真になるまで待機する
+	Poll(expr string, timeout time.Duration) browserAction
+	Screenshot(buf *[]byte) browserAction
+	// ViewportScreenshot はページ全体ではなく、現在の表示領域だけのスクリーンショットを撮る
+	ViewportScreenshot(buf *[]byte) browserAction
+	OuterHTML(html *string) browserAction
+	// WaitNetworkIdle はページの読み込みが完了し、通信が networkQuietPeriod の間途絶えるまで待つ。
+	// networkIdleTimeout を過ぎても静かにならない場合 (定期的な通信があるページなど) はそのまま戻る
+	WaitNetworkIdle() browserAction
+	// PressKey はページにキー入力 ("End"・"PageDown") を送る
+	PressKey(key string) browserAction
+	// WaitNavigatedAway はページのURLのパスが path で始まらなくなるまで (ログイン後のリダイレクトなど) 待つ。
+	// timeout を過ぎた場合は現在のURLを含むエラーを返す
+	WaitNavigatedAway(path string, timeout time.Duration) browserAction
+	// MemoryUsage は作業用のタブのメモリ使用量 (バイト) を取得する。取得できないブラウザでは errors.ErrUnsupported を返す
+	MemoryUsage(bytes *int64) browserAction
+	// RecycleTab は作業用のタブを閉じて新しいタブに置き換える。クッキーなどのセッションは引き継がれる
+	RecycleTab() browserAction
+	// Process はブラウザのプロセスを返す。起動前の場合は nil
+	Process() *os.Process
+}
+
+// browserKind は -browser フラグで指定された使用ブラウザ
+var browserKind = "chrome"
+
+// headless はブラウザを画面を表示せずに起動するか。CLIでは常に true で、NewClient の WithHeadless で変えられる
+var headless = true
+
+// usingChrome はChromeを使っているか (CDPを直接使う機能を使えるか) を返す
+func usingChrome() bool {
+	return browserKind == "chrome" || browserKind == ""
+}
+
+type driverContextKey struct{}
+
+// driverFromContext はコンテキストに紐づくブラウザドライバを返す。未設定の場合はchromedpのドライバを返す
+func driverFromContext(ctx context.Context) pageDriver {
+	if drv, ok := ctx.Value(driverContextKey{}).(pageDriver); ok {
+		return drv
+	}
+	return chromeDriver{}
+}
+
+// startBrowser は browserKind に応じたブラウザを起動し、ドライバを紐づけたコンテキストを返す。
+// 返されるキャンセル関数を呼び出すとブラウザは終了する。
+func startBrowser(parent context.Context) (context.Context, context.CancelFunc, error) {
+	l := loggerFromContext(parent)
+	switch browserKind {
+	case "chrome", "":
+		l.Println(tr("標準のchromedpを使用してヘッドレスブラウザを初期化しています..."))
+		allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
+			chromedp.Headless,
+			chromedp.NoSandbox,
+			chromedp.DisableGPU,
+		)
+		if !headless {
+			allocOpts = append(allocOpts, chromedp.Flag("headless", false))
+		}
+		if locale := os.Getenv("UI_LOCALE"); locale != "" {
+			l.Printf(tr("ブラウザのロケールを %s に固定します。"), locale)
+			allocOpts = append(allocOpts,
+				chromedp.Flag("lang", locale),
+				chromedp.Flag("accept-lang", locale),
+			)
+		}
+		allocOpts = append(allocOpts, chromeResourceFlags(parent)...)
+		if path, err := chromeExecPath(parent); err != nil {
+			return nil, nil, err
+		} else if path != "" {
+			allocOpts = append(allocOpts, chromedp.ExecPath(path))
+		}
+		if b := config.BlockRequests; b.active() {
+			l.Printf(tr("リクエストを遮断します (ドメイン %d件・URLのパターン %d件・リソースの種類 %d件)。"), len(b.domains), len(b.urls), len(b.types))
+		}
+		if dir, err := browserProfileDir(); err != nil {
+			return nil, nil, err
+		} else if dir != "" {
+			l.Printf(tr("ブラウザのプロファイル %s を使用します。"), dir)
+			allocOpts = append(allocOpts, chromedp.UserDataDir(dir))
+		}
+		allocCtx, cancelAlloc := chromedp.NewExecAllocator(parent, allocOpts...)
+		ctxOpts := []chromedp.ContextOption{chromedp.WithLogf(l.Printf)}
+		if logVerbosity >= verbosityDebug {
+			ctxOpts = append(ctxOpts, chromedp.WithDebugf(l.Printf))
+		}
+		ctx, cancelCtx := chromedp.NewContext(allocCtx, ctxOpts...)
+		cancel := func() {
+			cancelCtx()
+			cancelAlloc()
+		}
+		// ブラウザを先に起動しておき、起動失敗をここで検出する
+		if err := chromedp.Run(ctx); err != nil {
+			cancel()
+			return nil, nil, fmt.Errorf(tr("Chromeの起動に失敗: %w"), err)
+		}
+		if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			_, product, _, _, _, err := browser.GetVersion().Do(ctx)
+			status.setBrowserVersion(product)
+			return err
+		})); err != nil {
+			l.Printf(tr("警告: Chromeのバージョンを取得できません: %v"), err)
+		}
+		tab, err := newChromeTab(ctx)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		drv, closeRecord, err := withSessionRecorder(parent, chromeDriver{browser: chromedp.FromContext(ctx).Browser, tab: tab})
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		return context.WithValue(ctx, driverContextKey{}, withTracing(withPageGuards(withStepLogging(drv)))), func() {
+			cancel()
+			closeRecord()
+		}, nil
+	case "firefox":
+		l.Println(tr("WebDriver BiDiを使用してヘッドレスFirefoxを初期化しています..."))
+		if config.BlockRequests.active() {
+			l.Print(tr("警告: block_requests はChromeでのみ使えます。Firefoxではリクエストを遮断しません。"))
+		}
+		ff, err := startFirefox(parent)
+		if err != nil {
+			return nil, nil, err
+		}
+		drv, closeRecord, err := withSessionRecorder(parent, ff)
+		if err != nil {
+			ff.close()
+			return nil, nil, err
+		}
+		ctx, cancelCtx := context.WithCancel(parent)
+		cancel := func() {
+			cancelCtx()
+			ff.close()
+			closeRecord()
+		}
+		return context.WithValue(ctx, driverContextKey{}, withTracing(withPageGuards(withStepLogging(drv)))), cancel, nil
+	case "replay":
+		drv, err := loadReplayDriver(parent)
+		if err != nil {
+			return nil, nil, err
+		}
+		ctx, cancel := context.WithCancel(parent)
+		return context.WithValue(ctx, driverContextKey{}, withTracing(withPageGuards(withStepLogging(drv)))), cancel, nil
+	default:
+		return nil, nil, fmt.Errorf(tr("不明なブラウザ '%s' が指定されました (chrome, firefox, replay)"), browserKind)
+	}
+}
+
+// jsString はGoの文字列をJavaScriptの文字列リテラルに変換する
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
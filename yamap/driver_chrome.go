@@ -0,0 +1,455 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/inspector"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/performance"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// profileDir は -profile-dir フラグで指定された、実行をまたいで使い続けるブラウザのプロファイルのディレクトリ。
+// 空の場合は実行ごとに一時的なプロファイルを使う
+var profileDir string
+
+// browserProfileDir は使用するプロファイルのディレクトリを作成して返す。-profile-dir が未指定の場合は空文字を返す。
+// ブラウザは同じプロファイルを同時に開けず、アカウントごとにクッキーも分ける必要があるため、
+// 複数アカウントの子プロセスではアカウント名のサブディレクトリを使う
+func browserProfileDir() (string, error) {
+	if profileDir == "" {
+		return "", nil
+	}
+	dir, err := filepath.Abs(profileDir)
+	if err != nil {
+		return "", err
+	}
+	if name := os.Getenv("YAMAP_ACCOUNT"); name != "" {
+		dir = filepath.Join(dir, name)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf(tr("プロファイルのディレクトリを作成できません: %w"), err)
+	}
+	return dir, nil
+}
+
+// chromeResourceFlags はChromeのリソース使用量を抑える起動オプションを返す。
+// 拡張機能やバックグラウンド通信の無効化は chromedp.DefaultExecAllocatorOptions に含まれているため、
+// ここでは CHROME_MAX_OLD_SPACE_MB によるJavaScriptヒープの上限と CHROME_EXTRA_FLAGS による追加のフラグを扱う
+func chromeResourceFlags(ctx context.Context) []chromedp.ExecAllocatorOption {
+	var opts []chromedp.ExecAllocatorOption
+	if v := os.Getenv("CHROME_MAX_OLD_SPACE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n <= 0 {
+			loggerFromContext(ctx).Print(tr("警告: CHROME_MAX_OLD_SPACE_MBの値が不正です。JavaScriptヒープの上限は設定しません"))
+		} else {
+			loggerFromContext(ctx).Printf(tr("JavaScriptヒープの上限を %dMB に設定します。"), n)
+			opts = append(opts, chromedp.Flag("js-flags", fmt.Sprintf("--max-old-space-size=%d", n)))
+		}
+	}
+	// CHROME_EXTRA_FLAGS は空白区切りの "--name" または "--name=value" の並び (例: "--renderer-process-limit=2 --disable-software-rasterizer")
+	for _, f := range strings.Fields(os.Getenv("CHROME_EXTRA_FLAGS")) {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(f, "-"), "=")
+		if name == "" {
+			continue
+		}
+		if hasValue {
+			opts = append(opts, chromedp.Flag(name, value))
+		} else {
+			opts = append(opts, chromedp.Flag(name, true))
+		}
+	}
+	return opts
+}
+
+// chromeDriver はchromedpによる pageDriver の実装
+type chromeDriver struct {
+	browser *chromedp.Browser
+	// tab は操作を行う作業用のタブ。nil の場合は呼び出し元のコンテキストのタブで操作する
+	tab *chromeTab
+}
+
+// chromeTab はメモリ使用量を抑えるために作り直せる作業用のタブを保持する。
+// ブラウザを所有する最初のタブ (root) は閉じるとブラウザが終了するため about:blank のまま残し、その子として作業用のタブを作る。
+type chromeTab struct {
+	mu     sync.Mutex
+	root   context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	// crashed は作業用のタブのレンダラーがクラッシュしたときに閉じられる
+	crashed chan struct{}
+	// network は作業用のタブで通信中のリクエスト
+	network *inflightRequests
+	// frames は作業用のタブのメインフレームのURL
+	frames *frameTracker
+}
+
+// inflightRequests はCDPのNetworkドメインのイベントから通信中のリクエストを数える
+type inflightRequests struct {
+	mu sync.Mutex
+	// pending はリクエストIDごとの送信時刻
+	pending      map[network.RequestID]time.Time
+	lastActivity time.Time
+}
+
+// inflightStaleAfter を超えて応答のないリクエスト (ロングポーリングなど) は通信中として数えない
+const inflightStaleAfter = 10 * time.Second
+
+func newInflightRequests() *inflightRequests {
+	return &inflightRequests{pending: make(map[network.RequestID]time.Time), lastActivity: time.Now()}
+}
+
+// handle はNetworkドメインのイベントを受け取り、通信中のリクエストを更新する
+func (r *inflightRequests) handle(ev interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		r.pending[ev.RequestID] = time.Now()
+	case *network.EventLoadingFinished:
+		delete(r.pending, ev.RequestID)
+	case *network.EventLoadingFailed:
+		delete(r.pending, ev.RequestID)
+	default:
+		return
+	}
+	r.lastActivity = time.Now()
+}
+
+// idle は通信中のリクエストがなく、最後の通信から quiet 以上経過しているかを返す
+func (r *inflightRequests) idle(quiet time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, sent := range r.pending {
+		if time.Since(sent) < inflightStaleAfter {
+			return false
+		}
+	}
+	return time.Since(r.lastActivity) >= quiet
+}
+
+// frameTracker はPageドメインのイベントからメインフレームのURLを追跡し、URLが変わるたびに待機中の呼び出し元を起こす
+type frameTracker struct {
+	mu        sync.Mutex
+	mainFrame cdp.FrameID
+	url       string
+	// changed はURLが変わったときに閉じられ、新しいチャネルに置き換えられる
+	changed chan struct{}
+}
+
+func newFrameTracker() *frameTracker {
+	return &frameTracker{changed: make(chan struct{})}
+}
+
+// handle はPageドメインのイベントを受け取り、メインフレームのURLを更新する。
+// SPAの画面遷移 (history.pushState) は navigatedWithinDocument として届く
+func (f *frameTracker) handle(ev interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch ev := ev.(type) {
+	case *page.EventFrameNavigated:
+		if ev.Frame.ParentID != "" {
+			return
+		}
+		f.mainFrame, f.url = ev.Frame.ID, ev.Frame.URL
+	case *page.EventNavigatedWithinDocument:
+		if ev.FrameID != f.mainFrame {
+			return
+		}
+		f.url = ev.URL
+	default:
+		return
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// current は現在のURLと、次にURLが変わったときに閉じられるチャネルを返す
+func (f *frameTracker) current() (string, <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.url, f.changed
+}
+
+// errRendererCrashed はメモリ不足などでタブのレンダラープロセスがクラッシュしたことを表す。
+// 次の操作の前にタブを作り直すため、実行全体は中断せずその投稿だけを失敗として扱う
+var errRendererCrashed error = messageError("タブのレンダラーがクラッシュしました")
+
+// newChromeTab は root と同じブラウザに作業用のタブを開く
+func newChromeTab(root context.Context) (*chromeTab, error) {
+	t := &chromeTab{root: root}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// open は新しいタブを開いて作業用のタブにする。同じブラウザのタブ同士でクッキーは共有される
+func (t *chromeTab) open() error {
+	ctx, cancel := chromedp.NewContext(t.root)
+	if err := chromedp.Run(ctx); err != nil {
+		cancel()
+		return fmt.Errorf(tr("タブの作成に失敗: %w"), err)
+	}
+	crashed := make(chan struct{})
+	var once sync.Once
+	requests := newInflightRequests()
+	frames := newFrameTracker()
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		if _, ok := ev.(*inspector.EventTargetCrashed); ok {
+			once.Do(func() { close(crashed) })
+			return
+		}
+		requests.handle(ev)
+		frames.handle(ev)
+		harLog.handle(ctx, ev)
+		fixtureSaver.handle(ctx, ev)
+		screenRec.handle(ctx, ev)
+		handlePausedRequest(ctx, ev)
+	})
+	screenRec.start(ctx)
+	interceptRequests(ctx)
+	if layout == mobileLayout {
+		if err := chromedp.Run(ctx, chromedp.Emulate(mobileDevice)); err != nil {
+			cancel()
+			return fmt.Errorf(tr("スマートフォンの端末のエミュレートに失敗: %w"), err)
+		}
+	}
+	t.mu.Lock()
+	old := t.cancel
+	t.ctx, t.cancel, t.crashed, t.network, t.frames = ctx, cancel, crashed, requests, frames
+	t.mu.Unlock()
+	if old != nil {
+		// chromedpのコンテキストをキャンセルすると対応するタブが閉じられる
+		old()
+	}
+	return nil
+}
+
+func (t *chromeTab) current() (context.Context, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ctx, t.crashed
+}
+
+// ensureAlive はレンダラーがクラッシュしたタブを新しいタブに置き換える
+func (t *chromeTab) ensureAlive(ctx context.Context) error {
+	_, crashed := t.current()
+	select {
+	case <-crashed:
+		loggerFromContext(ctx).Println(tr("クラッシュしたタブを閉じて新しいタブを開き直します。"))
+		return t.open()
+	default:
+		return nil
+	}
+}
+
+// run は作業用のタブでchromedpの操作を実行する。呼び出し元のコンテキストのキャンセルと期限はそのまま適用する
+func (d chromeDriver) run(ctx context.Context, actions ...chromedp.Action) error {
+	if d.tab == nil {
+		return chromedp.Run(ctx, actions...)
+	}
+	if err := d.tab.ensureAlive(ctx); err != nil {
+		return err
+	}
+	tabCtx, crashed := d.tab.current()
+	runCtx, cancel := context.WithCancel(tabCtx)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancelDeadline context.CancelFunc
+		runCtx, cancelDeadline = context.WithDeadline(runCtx, deadline)
+		defer cancelDeadline()
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	// クラッシュしたタブへの操作は応答が返らず期限まで待たされるため、クラッシュを検知した時点で打ち切る
+	go func() {
+		select {
+		case <-crashed:
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+	if err := chromedp.Run(runCtx, actions...); err != nil {
+		select {
+		case <-crashed:
+			loggerFromContext(ctx).Printf(tr("警告: %v"), errRendererCrashed)
+			return fmt.Errorf("%w: %v", errRendererCrashed, err)
+		default:
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+func (d chromeDriver) Navigate(url string) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.Navigate(url)) }
+}
+
+func (d chromeDriver) Reload() browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.Reload()) }
+}
+
+func (d chromeDriver) WaitVisible(sel string) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.WaitVisible(sel, chromedp.ByQuery)) }
+}
+
+func (d chromeDriver) Click(sel string) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.Click(sel, chromedp.ByQuery)) }
+}
+
+func (d chromeDriver) SendKeys(sel, text string) browserAction {
+	return func(ctx context.Context) error {
+		return d.run(ctx, chromedp.SendKeys(sel, text, chromedp.ByQuery))
+	}
+}
+
+func (d chromeDriver) ScrollIntoView(sel string) browserAction {
+	return func(ctx context.Context) error {
+		return d.run(ctx, chromedp.ScrollIntoView(sel, chromedp.ByQuery))
+	}
+}
+
+func (d chromeDriver) Evaluate(expr string, res interface{}) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.Evaluate(expr, res)) }
+}
+
+func (d chromeDriver) Poll(expr string, timeout time.Duration) browserAction {
+	return func(ctx context.Context) error {
+		return d.run(ctx, chromedp.Poll(expr, nil, chromedp.WithPollingTimeout(timeout)))
+	}
+}
+
+func (d chromeDriver) Screenshot(buf *[]byte) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.FullScreenshot(buf, 90)) }
+}
+
+func (d chromeDriver) ViewportScreenshot(buf *[]byte) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.CaptureScreenshot(buf)) }
+}
+
+func (d chromeDriver) OuterHTML(html *string) browserAction {
+	return func(ctx context.Context) error { return d.run(ctx, chromedp.OuterHTML("html", html)) }
+}
+
+// WaitNetworkIdle は作業用のタブのNetworkドメインのイベントから通信中のリクエストを数え、
+// document.readyState が complete かつ通信が途絶えるまで待つ。作業用のタブがない場合はリソース数で判定する
+// WaitNavigatedAway はCDPの Page.frameNavigated / Page.navigatedWithinDocument イベントでURLの変化を待つ
+func (d chromeDriver) WaitNavigatedAway(path string, timeout time.Duration) browserAction {
+	return func(ctx context.Context) error {
+		if d.tab == nil {
+			return waitNavigatedAwayByPolling(d, path, timeout)(ctx)
+		}
+		d.tab.mu.Lock()
+		frames := d.tab.frames
+		d.tab.mu.Unlock()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		for {
+			url, changed := frames.current()
+			if pathOutside(url, path) {
+				return nil
+			}
+			select {
+			case <-changed:
+			case <-timer.C:
+				return &navigationTimeoutError{path: path, timeout: timeout, url: url}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+func (d chromeDriver) WaitNetworkIdle() browserAction {
+	return func(ctx context.Context) error {
+		if d.tab == nil {
+			return waitResourcesIdle(d)(ctx)
+		}
+		d.tab.mu.Lock()
+		requests := d.tab.network
+		d.tab.mu.Unlock()
+		deadline := time.Now().Add(networkIdleTimeout)
+		for time.Now().Before(deadline) {
+			if requests.idle(networkQuietPeriod) {
+				var complete bool
+				if err := d.run(ctx, chromedp.Evaluate(`document.readyState === "complete"`, &complete)); err != nil {
+					return err
+				}
+				if complete {
+					return nil
+				}
+			}
+			if err := sleepAction(networkIdlePollInterval)(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// chromeKeys は PressKey で送れるキーとchromedpのキーコードの対応
+var chromeKeys = map[string]string{"End": kb.End, "PageDown": kb.PageDown}
+
+// PressKey はCDPの Input.dispatchKeyEvent でキーの押下と解放を送る
+func (d chromeDriver) PressKey(key string) browserAction {
+	return func(ctx context.Context) error {
+		code, ok := chromeKeys[key]
+		if !ok {
+			return fmt.Errorf(tr("未対応のキー %q が指定されました"), key)
+		}
+		return d.run(ctx, chromedp.KeyEvent(code))
+	}
+}
+
+// MemoryUsage はCDPの Performance.getMetrics から作業用のタブのJavaScriptヒープの確保量 (JSHeapTotalSize) を取得する
+func (d chromeDriver) MemoryUsage(bytes *int64) browserAction {
+	return func(ctx context.Context) error {
+		return d.run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+			if err := performance.Enable().Do(ctx); err != nil {
+				return err
+			}
+			metrics, err := performance.GetMetrics().Do(ctx)
+			if err != nil {
+				return err
+			}
+			for _, m := range metrics {
+				if m.Name == "JSHeapTotalSize" {
+					*bytes = int64(m.Value)
+					return nil
+				}
+			}
+			return errors.New(tr("JSHeapTotalSize が取得できませんでした"))
+		}))
+	}
+}
+
+// RecycleTab は作業用のタブを閉じて新しいタブに置き換える
+func (d chromeDriver) RecycleTab() browserAction {
+	return func(ctx context.Context) error {
+		if d.tab == nil {
+			return errors.ErrUnsupported
+		}
+		return d.tab.open()
+	}
+}
+
+func (d chromeDriver) Process() *os.Process {
+	if d.browser == nil {
+		return nil
+	}
+	return d.browser.Process()
+}
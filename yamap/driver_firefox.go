@@ -0,0 +1,507 @@
+package yamap
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// firefoxDriver はWebDriver BiDiでFirefoxを操作する pageDriver の実装。
+// 要素の操作はページ内のJavaScriptで行う。
+type firefoxDriver struct {
+	cmd *exec.Cmd
+	// tempProfile は終了時に削除する一時プロファイルのディレクトリ。-profile-dir を使う場合は空
+	tempProfile string
+	client      *bidiClient
+	context     string
+}
+
+// firefoxPollInterval は要素の表示待機や式のポーリングを行う間隔
+const firefoxPollInterval = 100 * time.Millisecond
+
+// startFirefox は一時プロファイル (-profile-dir を指定した場合はそのディレクトリ) でヘッドレスFirefoxを起動し、WebDriver BiDiのセッションを開始する。
+// 実行ファイルは環境変数 FIREFOX_PATH で指定でき、未設定の場合は PATH 上の firefox を使用する。
+func startFirefox(ctx context.Context) (*firefoxDriver, error) {
+	bin := os.Getenv("FIREFOX_PATH")
+	if bin == "" {
+		bin = "firefox"
+	}
+	dir, err := browserProfileDir()
+	if err != nil {
+		return nil, err
+	}
+	// -profile-dir を指定した場合は終了時にプロファイルを削除しない
+	tempProfile := ""
+	if dir == "" {
+		dir, err = os.MkdirTemp("", "yamap-firefox-profile-")
+		if err != nil {
+			return nil, fmt.Errorf(tr("Firefoxのプロファイル作成に失敗: %w"), err)
+		}
+		tempProfile = dir
+	} else {
+		loggerFromContext(ctx).Printf(tr("ブラウザのプロファイル %s を使用します。"), dir)
+	}
+
+	if locale := os.Getenv("UI_LOCALE"); locale != "" {
+		loggerFromContext(ctx).Printf(tr("ブラウザのロケールを %s に固定します。"), locale)
+		prefs := fmt.Sprintf("user_pref(\"intl.accept_languages\", %s);\nuser_pref(\"intl.locale.requested\", %s);\n", jsString(locale), jsString(locale))
+		if err := os.WriteFile(filepath.Join(dir, "user.js"), []byte(prefs), 0644); err != nil {
+			os.RemoveAll(tempProfile)
+			return nil, fmt.Errorf(tr("Firefoxのロケール設定に失敗: %w"), err)
+		}
+	}
+
+	args := []string{"--no-remote", "--profile", dir, "--remote-debugging-port", "0", "about:blank"}
+	if headless {
+		args = append([]string{"--headless"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, bin, args...)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(tempProfile)
+		return nil, fmt.Errorf(tr("Firefoxの出力取得に失敗: %w"), err)
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(tempProfile)
+		return nil, fmt.Errorf(tr("Firefoxの起動に失敗: %w"), err)
+	}
+	drv := &firefoxDriver{cmd: cmd, tempProfile: tempProfile}
+
+	// 起動ログに出力される "WebDriver BiDi listening on ws://..." からエンドポイントを取得する
+	endpoint := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if idx := strings.Index(line, "WebDriver BiDi listening on "); idx >= 0 {
+				select {
+				case endpoint <- strings.TrimSpace(line[idx+len("WebDriver BiDi listening on "):]):
+				default:
+				}
+			}
+		}
+	}()
+
+	var wsURL string
+	select {
+	case wsURL = <-endpoint:
+	case <-time.After(30 * time.Second):
+		drv.close()
+		return nil, errors.New(tr("FirefoxのWebDriver BiDiエンドポイントが30秒以内に見つかりませんでした"))
+	case <-ctx.Done():
+		drv.close()
+		return nil, ctx.Err()
+	}
+
+	client, err := dialBiDi(ctx, wsURL+"/session")
+	if err != nil {
+		drv.close()
+		return nil, err
+	}
+	drv.client = client
+
+	var session struct {
+		Capabilities struct {
+			BrowserName    string `json:"browserName"`
+			BrowserVersion string `json:"browserVersion"`
+		} `json:"capabilities"`
+	}
+	if err := client.call(ctx, "session.new", map[string]interface{}{"capabilities": map[string]interface{}{}}, &session); err != nil {
+		drv.close()
+		return nil, fmt.Errorf(tr("BiDiセッションの開始に失敗: %w"), err)
+	}
+	status.setBrowserVersion(session.Capabilities.BrowserName + "/" + session.Capabilities.BrowserVersion)
+	var tree struct {
+		Contexts []struct {
+			Context string `json:"context"`
+		} `json:"contexts"`
+	}
+	if err := client.call(ctx, "browsingContext.getTree", map[string]interface{}{}, &tree); err != nil || len(tree.Contexts) == 0 {
+		drv.close()
+		return nil, fmt.Errorf(tr("Firefoxのタブ取得に失敗: %v"), err)
+	}
+	drv.context = tree.Contexts[0].Context
+	return drv, nil
+}
+
+// close はBiDiセッションを終了し、Firefoxのプロセスと一時プロファイルを片付ける
+func (d *firefoxDriver) close() {
+	if d.client != nil {
+		endCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		d.client.call(endCtx, "session.end", map[string]interface{}{}, nil)
+		cancel()
+		d.client.close()
+	}
+	if d.cmd != nil && d.cmd.Process != nil {
+		d.cmd.Process.Kill()
+		d.cmd.Wait()
+	}
+	if d.tempProfile != "" {
+		os.RemoveAll(d.tempProfile)
+	}
+}
+
+func (d *firefoxDriver) Navigate(url string) browserAction {
+	return func(ctx context.Context) error {
+		if err := requestLimit.wait(ctx); err != nil {
+			return err
+		}
+		return d.client.call(ctx, "browsingContext.navigate", map[string]interface{}{
+			"context": d.context,
+			"url":     url,
+			"wait":    "complete",
+		}, nil)
+	}
+}
+
+func (d *firefoxDriver) Reload() browserAction {
+	return func(ctx context.Context) error {
+		if err := requestLimit.wait(ctx); err != nil {
+			return err
+		}
+		return d.client.call(ctx, "browsingContext.reload", map[string]interface{}{
+			"context": d.context,
+			"wait":    "complete",
+		}, nil)
+	}
+}
+
+func (d *firefoxDriver) WaitVisible(sel string) browserAction {
+	return d.poll(fmt.Sprintf(`(() => {
+		const el = document.querySelector(%s);
+		if (!el) return false;
+		const rect = el.getBoundingClientRect();
+		const style = window.getComputedStyle(el);
+		return rect.width > 0 && rect.height > 0 && style.visibility !== "hidden" && style.display !== "none";
+	})()`, jsString(sel)), 0)
+}
+
+func (d *firefoxDriver) Click(sel string) browserAction {
+	return func(ctx context.Context) error {
+		if err := d.WaitVisible(sel)(ctx); err != nil {
+			return err
+		}
+		return d.Evaluate(fmt.Sprintf(`(() => {
+			const el = document.querySelector(%s);
+			el.scrollIntoView({block: "center"});
+			el.click();
+		})()`, jsString(sel)), nil)(ctx)
+	}
+}
+
+func (d *firefoxDriver) SendKeys(sel, text string) browserAction {
+	return func(ctx context.Context) error {
+		if err := d.WaitVisible(sel)(ctx); err != nil {
+			return err
+		}
+		// フレームワークが値の変更を検知できるよう、ネイティブのsetterで値を設定してからinputイベントを発火する
+		return d.Evaluate(fmt.Sprintf(`(() => {
+			const el = document.querySelector(%s);
+			el.focus();
+			const setter = Object.getOwnPropertyDescriptor(Object.getPrototypeOf(el), "value").set;
+			setter.call(el, el.value + %s);
+			el.dispatchEvent(new Event("input", {bubbles: true}));
+			el.dispatchEvent(new Event("change", {bubbles: true}));
+		})()`, jsString(sel), jsString(text)), nil)(ctx)
+	}
+}
+
+func (d *firefoxDriver) ScrollIntoView(sel string) browserAction {
+	return func(ctx context.Context) error {
+		if err := d.poll(fmt.Sprintf(`document.querySelector(%s) !== null`, jsString(sel)), 0)(ctx); err != nil {
+			return err
+		}
+		return d.Evaluate(fmt.Sprintf(`document.querySelector(%s).scrollIntoView({block: "center"})`, jsString(sel)), nil)(ctx)
+	}
+}
+
+func (d *firefoxDriver) Evaluate(expr string, res interface{}) browserAction {
+	return func(ctx context.Context) error {
+		// 結果をJSON文字列として受け取り、chromedpと同じくJSONとしてデコードする
+		wrapped := fmt.Sprintf(`(() => { const v = eval(%s); return v === undefined ? null : JSON.stringify(v); })()`, jsString(expr))
+		var result struct {
+			Type   string `json:"type"`
+			Result struct {
+				Type  string          `json:"type"`
+				Value json.RawMessage `json:"value"`
+			} `json:"result"`
+			ExceptionDetails *struct {
+				Text string `json:"text"`
+			} `json:"exceptionDetails"`
+		}
+		if err := d.client.call(ctx, "script.evaluate", map[string]interface{}{
+			"expression":      wrapped,
+			"target":          map[string]interface{}{"context": d.context},
+			"awaitPromise":    false,
+			"resultOwnership": "none",
+		}, &result); err != nil {
+			return err
+		}
+		if result.Type == "exception" {
+			text := ""
+			if result.ExceptionDetails != nil {
+				text = result.ExceptionDetails.Text
+			}
+			return fmt.Errorf(tr("JavaScriptの評価中に例外が発生: %s"), text)
+		}
+		if res == nil || result.Result.Type != "string" {
+			return nil
+		}
+		var encoded string
+		if err := json.Unmarshal(result.Result.Value, &encoded); err != nil {
+			return fmt.Errorf(tr("評価結果の読み込みに失敗: %w"), err)
+		}
+		if raw, ok := res.(*json.RawMessage); ok {
+			*raw = json.RawMessage(encoded)
+			return nil
+		}
+		return json.Unmarshal([]byte(encoded), res)
+	}
+}
+
+func (d *firefoxDriver) Poll(expr string, timeout time.Duration) browserAction {
+	return d.poll(expr, timeout)
+}
+
+// poll は式が真になるまで firefoxPollInterval ごとに評価する。timeout が0の場合はコンテキストの期限まで待つ
+func (d *firefoxDriver) poll(expr string, timeout time.Duration) browserAction {
+	return func(ctx context.Context) error {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		for {
+			var ok bool
+			if err := d.Evaluate(fmt.Sprintf(`!!(%s)`, expr), &ok)(ctx); err == nil && ok {
+				return nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(firefoxPollInterval):
+			}
+		}
+	}
+}
+
+func (d *firefoxDriver) Screenshot(buf *[]byte) browserAction {
+	return d.captureScreenshot("document", buf)
+}
+
+func (d *firefoxDriver) ViewportScreenshot(buf *[]byte) browserAction {
+	return d.captureScreenshot("viewport", buf)
+}
+
+// captureScreenshot は origin ("document" はページ全体、"viewport" は表示領域) のスクリーンショットを撮る
+func (d *firefoxDriver) captureScreenshot(origin string, buf *[]byte) browserAction {
+	return func(ctx context.Context) error {
+		var result struct {
+			Data string `json:"data"`
+		}
+		if err := d.client.call(ctx, "browsingContext.captureScreenshot", map[string]interface{}{
+			"context": d.context,
+			"origin":  origin,
+		}, &result); err != nil {
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(result.Data)
+		if err != nil {
+			return fmt.Errorf(tr("スクリーンショットのデコードに失敗: %w"), err)
+		}
+		*buf = data
+		return nil
+	}
+}
+
+func (d *firefoxDriver) OuterHTML(html *string) browserAction {
+	return d.Evaluate(`document.documentElement.outerHTML`, html)
+}
+
+// WaitNetworkIdle はBiDiのネットワークのイベントを購読していないため、リソース数で通信が落ち着いたかを判定する
+func (d *firefoxDriver) WaitNetworkIdle() browserAction {
+	return waitResourcesIdle(d)
+}
+
+func (d *firefoxDriver) WaitNavigatedAway(path string, timeout time.Duration) browserAction {
+	return waitNavigatedAwayByPolling(d, path, timeout)
+}
+
+// webDriverKeys は PressKey で送れるキーとWebDriverのキーコードの対応
+var webDriverKeys = map[string]string{"End": "\uE010", "PageDown": "\uE00F"}
+
+// PressKey はWebDriver BiDiの input.performActions でキーの押下と解放を送る
+func (d *firefoxDriver) PressKey(key string) browserAction {
+	return func(ctx context.Context) error {
+		code, ok := webDriverKeys[key]
+		if !ok {
+			return fmt.Errorf(tr("未対応のキー %q が指定されました"), key)
+		}
+		return d.client.call(ctx, "input.performActions", map[string]interface{}{
+			"context": d.context,
+			"actions": []map[string]interface{}{{
+				"type": "key",
+				"id":   "keyboard",
+				"actions": []map[string]interface{}{
+					{"type": "keyDown", "value": code},
+					{"type": "keyUp", "value": code},
+				},
+			}},
+		}, nil)
+	}
+}
+
+// MemoryUsage はWebDriver BiDiではタブのメモリ使用量を取得できないため、常に errors.ErrUnsupported を返す
+func (d *firefoxDriver) MemoryUsage(bytes *int64) browserAction {
+	return func(ctx context.Context) error { return errors.ErrUnsupported }
+}
+
+// RecycleTab は新しいタブを開いて操作の対象を切り替え、それまでのタブを閉じる
+func (d *firefoxDriver) RecycleTab() browserAction {
+	return func(ctx context.Context) error {
+		var created struct {
+			Context string `json:"context"`
+		}
+		if err := d.client.call(ctx, "browsingContext.create", map[string]interface{}{"type": "tab"}, &created); err != nil {
+			return fmt.Errorf(tr("タブの作成に失敗: %w"), err)
+		}
+		old := d.context
+		d.context = created.Context
+		return d.client.call(ctx, "browsingContext.close", map[string]interface{}{"context": old}, nil)
+	}
+}
+
+func (d *firefoxDriver) Process() *os.Process {
+	if d.cmd == nil {
+		return nil
+	}
+	return d.cmd.Process
+}
+
+// bidiClient はWebDriver BiDiのWebSocket接続を管理し、コマンドの送信と応答の対応付けを行う
+type bidiClient struct {
+	conn    net.Conn
+	reader  io.Reader
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan bidiMessage
+	err     error
+}
+
+// bidiMessage はBiDiのコマンド応答。イベントは id を持たないため読み捨てる
+type bidiMessage struct {
+	ID      int64           `json:"id"`
+	Type    string          `json:"type"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+	Message string          `json:"message"`
+}
+
+func dialBiDi(ctx context.Context, url string) (*bidiClient, error) {
+	conn, br, _, err := ws.Dial(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf(tr("WebDriver BiDiへの接続に失敗 (%s): %w"), url, err)
+	}
+	c := &bidiClient{conn: conn, reader: conn, pending: make(map[int64]chan bidiMessage)}
+	if br != nil {
+		c.reader = io.MultiReader(br, conn)
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *bidiClient) readLoop() {
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{c.reader, c.conn}
+	for {
+		data, err := wsutil.ReadServerText(rw)
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			for id, ch := range c.pending {
+				close(ch)
+				delete(c.pending, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+		var msg bidiMessage
+		if err := json.Unmarshal(data, &msg); err != nil || msg.ID == 0 {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[msg.ID]
+		delete(c.pending, msg.ID)
+		c.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+// call はコマンドを送信して応答を待ち、結果を result にデコードする
+func (c *bidiClient) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return fmt.Errorf(tr("WebDriver BiDiの接続が切断されています: %w"), err)
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan bidiMessage, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	payload, err := json.Marshal(map[string]interface{}{"id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	err = wsutil.WriteClientText(c.conn, payload)
+	c.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf(tr("%s の送信に失敗: %w"), method, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	case msg, ok := <-ch:
+		if !ok {
+			return fmt.Errorf(tr("%s の応答待ちの間に接続が切断されました"), method)
+		}
+		if msg.Type == "error" {
+			return fmt.Errorf(tr("%s が失敗しました: %s: %s"), method, msg.Error, msg.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	}
+}
+
+func (c *bidiClient) close() {
+	c.conn.Close()
+}
@@ -0,0 +1,107 @@
+package yamap
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// loggingDriver はブラウザの操作を1件ずつ、かかった時間と結果とともにログに出す pageDriver のラッパー (-v)。
+// どの操作で止まったか・失敗したかを、デバッグ情報を見ずに追えるようにする
+type loggingDriver struct {
+	pageDriver
+}
+
+// withStepLogging は -v 以上の場合に、drv を操作をログに出すドライバでラップする
+func withStepLogging(drv pageDriver) pageDriver {
+	if logVerbosity < verbosityVerbose {
+		return drv
+	}
+	return loggingDriver{pageDriver: drv}
+}
+
+// stepLogArgLimit はログに出す操作の対象 (スクリプトなど) の最大の文字数
+const stepLogArgLimit = 120
+
+// step は action を実行し、操作の名前・対象・かかった時間・エラーをログに出す
+func (d loggingDriver) step(method, arg string, action browserAction) browserAction {
+	arg = strings.Join(strings.Fields(arg), " ")
+	if r := []rune(arg); len(r) > stepLogArgLimit {
+		arg = string(r[:stepLogArgLimit]) + "…"
+	}
+	return func(ctx context.Context) error {
+		start := time.Now()
+		err := action(ctx)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("[操作] %s %s (%s): 失敗: %v"), method, arg, time.Since(start).Round(time.Millisecond), err)
+		} else {
+			loggerFromContext(ctx).Printf(tr("[操作] %s %s (%s)"), method, arg, time.Since(start).Round(time.Millisecond))
+		}
+		return err
+	}
+}
+
+func (d loggingDriver) Navigate(url string) browserAction {
+	return d.step("Navigate", url, d.pageDriver.Navigate(url))
+}
+
+func (d loggingDriver) Reload() browserAction {
+	return d.step("Reload", "", d.pageDriver.Reload())
+}
+
+func (d loggingDriver) WaitVisible(sel string) browserAction {
+	return d.step("WaitVisible", sel, d.pageDriver.WaitVisible(sel))
+}
+
+func (d loggingDriver) Click(sel string) browserAction {
+	return d.step("Click", sel, d.pageDriver.Click(sel))
+}
+
+// SendKeys は入力内容にパスワードを含むため、対象のセレクタだけをログに出す
+func (d loggingDriver) SendKeys(sel, text string) browserAction {
+	return d.step("SendKeys", sel, d.pageDriver.SendKeys(sel, text))
+}
+
+func (d loggingDriver) ScrollIntoView(sel string) browserAction {
+	return d.step("ScrollIntoView", sel, d.pageDriver.ScrollIntoView(sel))
+}
+
+func (d loggingDriver) Evaluate(expr string, res interface{}) browserAction {
+	return d.step("Evaluate", expr, d.pageDriver.Evaluate(expr, res))
+}
+
+func (d loggingDriver) Poll(expr string, timeout time.Duration) browserAction {
+	return d.step("Poll", expr, d.pageDriver.Poll(expr, timeout))
+}
+
+func (d loggingDriver) Screenshot(buf *[]byte) browserAction {
+	return d.step("Screenshot", "", d.pageDriver.Screenshot(buf))
+}
+
+func (d loggingDriver) ViewportScreenshot(buf *[]byte) browserAction {
+	return d.step("ViewportScreenshot", "", d.pageDriver.ViewportScreenshot(buf))
+}
+
+func (d loggingDriver) OuterHTML(html *string) browserAction {
+	return d.step("OuterHTML", "", d.pageDriver.OuterHTML(html))
+}
+
+func (d loggingDriver) WaitNetworkIdle() browserAction {
+	return d.step("WaitNetworkIdle", "", d.pageDriver.WaitNetworkIdle())
+}
+
+func (d loggingDriver) PressKey(key string) browserAction {
+	return d.step("PressKey", key, d.pageDriver.PressKey(key))
+}
+
+func (d loggingDriver) WaitNavigatedAway(path string, timeout time.Duration) browserAction {
+	return d.step("WaitNavigatedAway", path, d.pageDriver.WaitNavigatedAway(path, timeout))
+}
+
+func (d loggingDriver) MemoryUsage(bytes *int64) browserAction {
+	return d.step("MemoryUsage", "", d.pageDriver.MemoryUsage(bytes))
+}
+
+func (d loggingDriver) RecycleTab() browserAction {
+	return d.step("RecycleTab", "", d.pageDriver.RecycleTab())
+}
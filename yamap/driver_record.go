@@ -0,0 +1,170 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// sessionExchange は記録したドライバの操作1件。SESSION_RECORD_FILE に1行ずつJSONで書き、-browser replay で読み戻す
+type sessionExchange struct {
+	// Method は pageDriver のメソッド名
+	Method string `json:"method"`
+	// Args は操作の対象 (URL・セレクタ・式など)。SendKeys の入力内容はパスワードを含むため記録しない
+	Args []string `json:"args,omitempty"`
+	// Result は結果 (Evaluate の値・HTML・スクリーンショットなど) をJSONにしたもの
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error は失敗した場合のエラーの文言、Sentinel はそのうち呼び出し元が errors.Is で判定するエラー (replaySentinels のキー)
+	Error    string `json:"error,omitempty"`
+	Sentinel string `json:"sentinel,omitempty"`
+}
+
+// replaySentinels は読み戻したエラーでも errors.Is で判定できるよう、記録するエラーの種類
+var replaySentinels = map[string]error{
+	"renderer-crashed":  errRendererCrashed,
+	"deadline-exceeded": context.DeadlineExceeded,
+	"canceled":          context.Canceled,
+	"unsupported":       errors.ErrUnsupported,
+}
+
+// replayedError は記録から読み戻したエラー
+type replayedError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *replayedError) Error() string { return e.msg }
+func (e *replayedError) Unwrap() error { return e.sentinel }
+
+// recordingDriver はドライバの操作と結果を SESSION_RECORD_FILE に記録する pageDriver のラッパー。
+// 実際のブラウザでの実行を記録しておき、-browser replay でブラウザなしに同じ操作の流れを再現できるようにする
+type recordingDriver struct {
+	pageDriver
+	mu  *sync.Mutex
+	enc *json.Encoder
+}
+
+// withSessionRecorder は SESSION_RECORD_FILE が指定されていれば、drv を操作を記録するドライバでラップする。
+// 記録にはページの内容が含まれるため、ファイルは所有者のみ読み書きできる権限で作成する。
+// 返す関数はブラウザの終了時に呼び出し、記録をディスクに書き出してファイルを閉じる (記録しない場合は何もしない)
+func withSessionRecorder(ctx context.Context, drv pageDriver) (pageDriver, func(), error) {
+	path := os.Getenv("SESSION_RECORD_FILE")
+	if path == "" {
+		return drv, func() {}, nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf(tr("ドライバの操作の記録先を作成できません: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("ドライバの操作を %s に記録します。"), path)
+	rec := recordingDriver{pageDriver: drv, mu: &sync.Mutex{}, enc: json.NewEncoder(f)}
+	closeRecord := func() {
+		// 終了の直前まで実行中の操作が記録を書いている場合があるため、書き終えるのを待ってから閉じる
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		if err := f.Sync(); err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: ドライバの操作の記録を書き出せません: %v"), err)
+		}
+		f.Close()
+	}
+	return rec, closeRecord, nil
+}
+
+// record は action を実行し、その結果を記録する。result は実行後に結果として記録する値 (nil の場合は記録しない)
+func (d recordingDriver) record(method string, args []string, action browserAction, result func() any) browserAction {
+	return func(ctx context.Context) error {
+		err := action(ctx)
+		ex := sessionExchange{Method: method, Args: args}
+		if err != nil {
+			ex.Error = err.Error()
+			for key, sentinel := range replaySentinels {
+				if errors.Is(err, sentinel) {
+					ex.Sentinel = key
+					break
+				}
+			}
+		} else if result != nil {
+			if data, merr := json.Marshal(result()); merr == nil {
+				ex.Result = data
+			}
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if werr := d.enc.Encode(ex); werr != nil {
+			loggerFromContext(ctx).Printf(tr("警告: ドライバの操作を記録できません: %v"), werr)
+		}
+		return err
+	}
+}
+
+func (d recordingDriver) Navigate(url string) browserAction {
+	return d.record("Navigate", []string{url}, d.pageDriver.Navigate(url), nil)
+}
+
+func (d recordingDriver) Reload() browserAction {
+	return d.record("Reload", nil, d.pageDriver.Reload(), nil)
+}
+
+func (d recordingDriver) WaitVisible(sel string) browserAction {
+	return d.record("WaitVisible", []string{sel}, d.pageDriver.WaitVisible(sel), nil)
+}
+
+func (d recordingDriver) Click(sel string) browserAction {
+	return d.record("Click", []string{sel}, d.pageDriver.Click(sel), nil)
+}
+
+func (d recordingDriver) SendKeys(sel, text string) browserAction {
+	return d.record("SendKeys", []string{sel}, d.pageDriver.SendKeys(sel, text), nil)
+}
+
+func (d recordingDriver) ScrollIntoView(sel string) browserAction {
+	return d.record("ScrollIntoView", []string{sel}, d.pageDriver.ScrollIntoView(sel), nil)
+}
+
+func (d recordingDriver) Evaluate(expr string, res interface{}) browserAction {
+	var result func() any
+	if res != nil {
+		result = func() any { return res }
+	}
+	return d.record("Evaluate", []string{expr}, d.pageDriver.Evaluate(expr, res), result)
+}
+
+func (d recordingDriver) Poll(expr string, timeout time.Duration) browserAction {
+	return d.record("Poll", []string{expr}, d.pageDriver.Poll(expr, timeout), nil)
+}
+
+func (d recordingDriver) Screenshot(buf *[]byte) browserAction {
+	return d.record("Screenshot", nil, d.pageDriver.Screenshot(buf), func() any { return *buf })
+}
+
+func (d recordingDriver) ViewportScreenshot(buf *[]byte) browserAction {
+	return d.record("ViewportScreenshot", nil, d.pageDriver.ViewportScreenshot(buf), func() any { return *buf })
+}
+
+func (d recordingDriver) OuterHTML(html *string) browserAction {
+	return d.record("OuterHTML", nil, d.pageDriver.OuterHTML(html), func() any { return *html })
+}
+
+func (d recordingDriver) WaitNetworkIdle() browserAction {
+	return d.record("WaitNetworkIdle", nil, d.pageDriver.WaitNetworkIdle(), nil)
+}
+
+func (d recordingDriver) PressKey(key string) browserAction {
+	return d.record("PressKey", []string{key}, d.pageDriver.PressKey(key), nil)
+}
+
+func (d recordingDriver) WaitNavigatedAway(path string, timeout time.Duration) browserAction {
+	return d.record("WaitNavigatedAway", []string{path}, d.pageDriver.WaitNavigatedAway(path, timeout), nil)
+}
+
+func (d recordingDriver) MemoryUsage(bytes *int64) browserAction {
+	return d.record("MemoryUsage", nil, d.pageDriver.MemoryUsage(bytes), func() any { return *bytes })
+}
+
+func (d recordingDriver) RecycleTab() browserAction {
+	return d.record("RecycleTab", nil, d.pageDriver.RecycleTab(), nil)
+}
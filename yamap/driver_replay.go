@@ -0,0 +1,135 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// replayDriver は SESSION_RECORD_FILE に記録した操作を順に読み戻す pageDriver の実装 (-browser replay)。
+// ブラウザもネットワークも使わずに、ログイン・収集・リアクションの処理を記録した時と同じ応答で再現する。
+// 記録と異なる操作が要求された場合は、どこで食い違ったかを含むエラーを返す
+type replayDriver struct {
+	mu        *sync.Mutex
+	exchanges []sessionExchange
+	next      *int
+}
+
+// loadReplayDriver は SESSION_REPLAY_FILE から記録した操作を読み込む
+func loadReplayDriver(ctx context.Context) (replayDriver, error) {
+	path := os.Getenv("SESSION_REPLAY_FILE")
+	if path == "" {
+		return replayDriver{}, errors.New(tr("-browser replay には SESSION_REPLAY_FILE に記録したファイルを指定してください"))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return replayDriver{}, fmt.Errorf(tr("記録したドライバの操作を読み込めません: %w"), err)
+	}
+	var exchanges []sessionExchange
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var ex sessionExchange
+		if err := dec.Decode(&ex); err == io.EOF {
+			break
+		} else if err != nil {
+			return replayDriver{}, fmt.Errorf(tr("記録したドライバの操作を読み込めません (%d 件目): %w"), len(exchanges)+1, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	loggerFromContext(ctx).Printf(tr("%s に記録した %d 件の操作を再現します。"), path, len(exchanges))
+	return replayDriver{mu: &sync.Mutex{}, exchanges: exchanges, next: new(int)}, nil
+}
+
+// replay は次に記録された操作が method と args に一致することを確かめ、記録した結果を result に書く
+func (d replayDriver) replay(method string, args []string, result any) browserAction {
+	return func(ctx context.Context) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if *d.next >= len(d.exchanges) {
+			return fmt.Errorf(tr("記録した操作を使い切りました (%s %q)"), method, args)
+		}
+		ex := d.exchanges[*d.next]
+		if ex.Method != method || !slices.Equal(ex.Args, args) {
+			return fmt.Errorf(tr("記録と異なる操作です (%d 件目: 記録 %s %q、実行 %s %q)"), *d.next+1, ex.Method, ex.Args, method, args)
+		}
+		*d.next++
+		if ex.Error != "" {
+			return &replayedError{msg: ex.Error, sentinel: replaySentinels[ex.Sentinel]}
+		}
+		if result != nil && len(ex.Result) > 0 {
+			return json.Unmarshal(ex.Result, result)
+		}
+		return nil
+	}
+}
+
+func (d replayDriver) Navigate(url string) browserAction {
+	return d.replay("Navigate", []string{url}, nil)
+}
+
+func (d replayDriver) Reload() browserAction { return d.replay("Reload", nil, nil) }
+
+func (d replayDriver) WaitVisible(sel string) browserAction {
+	return d.replay("WaitVisible", []string{sel}, nil)
+}
+
+func (d replayDriver) Click(sel string) browserAction {
+	return d.replay("Click", []string{sel}, nil)
+}
+
+func (d replayDriver) SendKeys(sel, text string) browserAction {
+	return d.replay("SendKeys", []string{sel}, nil)
+}
+
+func (d replayDriver) ScrollIntoView(sel string) browserAction {
+	return d.replay("ScrollIntoView", []string{sel}, nil)
+}
+
+func (d replayDriver) Evaluate(expr string, res interface{}) browserAction {
+	return d.replay("Evaluate", []string{expr}, res)
+}
+
+func (d replayDriver) Poll(expr string, timeout time.Duration) browserAction {
+	return d.replay("Poll", []string{expr}, nil)
+}
+
+func (d replayDriver) Screenshot(buf *[]byte) browserAction {
+	return d.replay("Screenshot", nil, buf)
+}
+
+func (d replayDriver) ViewportScreenshot(buf *[]byte) browserAction {
+	return d.replay("ViewportScreenshot", nil, buf)
+}
+
+func (d replayDriver) OuterHTML(html *string) browserAction {
+	return d.replay("OuterHTML", nil, html)
+}
+
+func (d replayDriver) WaitNetworkIdle() browserAction { return d.replay("WaitNetworkIdle", nil, nil) }
+
+func (d replayDriver) PressKey(key string) browserAction {
+	return d.replay("PressKey", []string{key}, nil)
+}
+
+func (d replayDriver) WaitNavigatedAway(path string, timeout time.Duration) browserAction {
+	return d.replay("WaitNavigatedAway", []string{path}, nil)
+}
+
+func (d replayDriver) MemoryUsage(bytes *int64) browserAction {
+	return d.replay("MemoryUsage", nil, bytes)
+}
+
+func (d replayDriver) RecycleTab() browserAction { return d.replay("RecycleTab", nil, nil) }
+
+// Process はブラウザを起動しないため常に nil
+func (d replayDriver) Process() *os.Process { return nil }
@@ -0,0 +1,36 @@
+package yamap
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestReplayReactToActivities は記録したセッション (testdata/session_react.jsonl) を -browser replay と同じ
+// replayDriver で読み戻し、reactToActivities の操作の流れと結果が記録した時と変わらないことを確かめる。
+// 記録では1件目の投稿にリアクションを送り、2件目は削除済みのページ、3件目は投稿ページでリアクション済みとしてスキップしている。
+// 投稿ページの操作を変えた場合は、SESSION_RECORD_FILE で記録し直してこのファイルを置き換える
+func TestReplayReactToActivities(t *testing.T) {
+	t.Setenv("SESSION_REPLAY_FILE", "testdata/session_react.jsonl")
+	drv, err := loadReplayDriver(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	origPace, origCheck := pace, lastTabMemoryCheck
+	pace, lastTabMemoryCheck = &pacer{}, time.Time{}
+	t.Cleanup(func() { pace, lastTabMemoryCheck = origPace, origCheck })
+
+	ctx := context.WithValue(context.Background(), driverContextKey{}, pageDriver(drv))
+	got := reactToActivities(ctx, []ActivityInfo{
+		{URL: "https://yamap.com/activities/1"},
+		{URL: "https://yamap.com/activities/2"},
+		{URL: "https://yamap.com/activities/3"},
+	})
+	if want := []string{"https://yamap.com/activities/1"}; !slices.Equal(got, want) {
+		t.Errorf("reacted = %q, want %q", got, want)
+	}
+	if *drv.next != len(drv.exchanges) {
+		t.Errorf("replayed %d of %d recorded operations", *drv.next, len(drv.exchanges))
+	}
+}
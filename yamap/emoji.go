@@ -0,0 +1,198 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// emojiRule は絵文字を選ぶ条件。指定された条件をすべて満たす場合に一致する
+type emojiRule struct {
+	// Emoji はピッカー内の絵文字ボタンのラベル (例: "clap") または絵文字そのもの
+	Emoji string `json:"emoji"`
+	// Keywords はタイトルか本文にいずれかが含まれていれば一致する
+	Keywords []string `json:"keywords,omitempty"`
+	// MinDistanceKm は活動距離 (km) の下限
+	MinDistanceKm float64 `json:"min_distance_km,omitempty"`
+	// MinElevationM は累積標高 (上り, m) の下限
+	MinElevationM float64 `json:"min_elevation_m,omitempty"`
+}
+
+// matches は活動の内容がルールの条件を満たすかを返す
+func (r emojiRule) matches(meta activityMetadata) bool {
+	if r.MinDistanceKm > 0 && meta.Distance/1000 < r.MinDistanceKm {
+		return false
+	}
+	if r.MinElevationM > 0 && meta.CumulativeUp < r.MinElevationM {
+		return false
+	}
+	if len(r.Keywords) > 0 {
+		text := strings.ToLower(meta.Title + "\n" + meta.Description)
+		for _, kw := range r.Keywords {
+			if strings.Contains(text, strings.ToLower(kw)) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// activityMetadata は活動日記詳細ページから取得する活動の情報
+type activityMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Distance は活動距離 (m)
+	Distance float64 `json:"distance"`
+	// CumulativeUp は累積標高 (上り, m)
+	CumulativeUp  float64  `json:"cumulative_up"`
+	MountainNames []string `json:"mountains"`
+	Author        string   `json:"author"`
+	AuthorID      int64    `json:"author_id"`
+	// PostedAt は投稿日時 (RFC 3339)。取得できない場合は空
+	PostedAt string `json:"posted_at"`
+}
+
+// activityMetadataScript は活動日記詳細ページの NUXT データから活動の情報を取り出すスクリプト。
+// ストアの構成はページの実装により異なるため、活動らしいオブジェクトを候補の中から探す。
+const activityMetadataScript = `(() => {
+	const nuxt = window.__NUXT__ || {};
+	const candidates = [];
+	if (nuxt.state && nuxt.state.activity) candidates.push(nuxt.state.activity.activity, nuxt.state.activity);
+	for (const d of (nuxt.data || [])) if (d) candidates.push(d.activity, d);
+	const a = candidates.find(c => c && typeof c === "object" && ("distance" in c || "cumulative_up" in c || "title" in c)) || {};
+	const mountains = (a.mountains || (a.map ? [a.map] : [])).map(m => m && m.name).filter(Boolean);
+	const heading = document.querySelector("h1");
+	const posted = a.created_at || a.published_at || (document.querySelector("time[datetime]") || {getAttribute: () => ""}).getAttribute("datetime");
+	return {
+		title: a.title || (heading ? heading.textContent.trim() : document.title),
+		description: a.description || a.body || "",
+		distance: Number(a.distance) || 0,
+		cumulative_up: Number(a.cumulative_up) || 0,
+		mountains: mountains,
+		author: (a.user && a.user.name) || "",
+		author_id: (a.user && Number(a.user.id)) || 0,
+		posted_at: typeof posted === "number" ? new Date(posted * 1000).toISOString() : String(posted || ""),
+	};
+})()`
+
+// postedAt は投稿日時を返す。取得できなかった場合や形式が不正な場合はゼロ値
+func (m activityMetadata) postedAt() time.Time {
+	return feedTimestamp(m.PostedAt).time()
+}
+
+// fetchActivityMetadata は表示中の活動日記詳細ページから活動の情報を取得する
+func fetchActivityMetadata(ctx context.Context, drv pageDriver) (activityMetadata, error) {
+	var meta activityMetadata
+	err := runActions(ctx, drv.Evaluate(activityMetadataScript, &meta))
+	return meta, err
+}
+
+// chooseEmoji は設定のルールに従って送る絵文字を選ぶ。ルールも既定の絵文字もない場合は空文字 (ピッカーの最初の絵文字)
+func chooseEmoji(ctx context.Context, drv pageDriver) string {
+	if len(config.EmojiRules) == 0 {
+		return config.DefaultEmoji
+	}
+	meta, err := fetchActivityMetadata(ctx, drv)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("活動の情報の取得に失敗したため、既定の絵文字を使用します: %v"), err)
+		return config.DefaultEmoji
+	}
+	return emojiForMetadata(ctx, meta)
+}
+
+// emojiForMetadata は活動の情報に最初に一致したルールの絵文字を返す。一致しない場合は既定の絵文字
+func emojiForMetadata(ctx context.Context, meta activityMetadata) string {
+	for _, rule := range config.EmojiRules {
+		if rule.matches(meta) {
+			loggerFromContext(ctx).Printf(tr("絵文字ルールに一致しました (%.1fkm, 累積標高%.0fm): %s"), meta.Distance/1000, meta.CumulativeUp, rule.Emoji)
+			return rule.Emoji
+		}
+	}
+	return config.DefaultEmoji
+}
+
+// clickEmojiScript は絵文字ピッカー内で、ラベル・代替テキスト・表示文字のいずれかが指定の絵文字と一致するボタンをクリックするスクリプト。
+// 一致するボタンがあれば true を返す。":clap:" のようにコロンで囲んだ指定も受け付ける。
+func clickEmojiScript(emoji string) string {
+	return `(() => {
+	const want = ` + jsString(strings.Trim(emoji, ":")) + `.toLowerCase();
+	const keys = ` + emojiKeysScript + `;
+	const button = Array.from(document.querySelectorAll(".emojiPickerBody button, .emojiPickerBody .emojiButton, .emojiPickerBody .emoji-picker-button"))
+		.find(b => keys(b).includes(want));
+	if (!button) return false;
+	button.click();
+	return true;
+})()`
+}
+
+// emojiKeysScript は絵文字のボタンから名前の候補 (ラベル・画像の alt・表示文字など) を小文字で取り出す関数式
+const emojiKeysScript = `b => [b.getAttribute("aria-label"), b.getAttribute("title"), b.getAttribute("data-emoji"), b.getAttribute("data-name"),
+		...Array.from(b.querySelectorAll("img[alt]")).map(i => i.getAttribute("alt")), b.textContent]
+		.filter(Boolean).map(k => k.trim().replace(/^:|:$/g, "").toLowerCase())`
+
+// ownReactionFilter はツールバーのボタンのうち、自分が送ったリアクション (aria-pressed または選択状態のクラス) かを判定する関数式
+const ownReactionFilter = `b => b.getAttribute("aria-pressed") === "true" || /active|reacted|selected/i.test(b.className)`
+
+// removeReactionScript はツールバーに並ぶリアクションのうち、自分が送ったもの (aria-pressed または選択状態のクラス) をクリックして取り消す。
+// emoji が指定されていればその絵文字を優先する。自分のリアクションが見つからない場合は false を返す
+func removeReactionScript(page postPage, emoji string) string {
+	return `(() => {
+	const want = ` + jsString(strings.Trim(emoji, ":")) + `.toLowerCase();
+	const keys = ` + emojiKeysScript + `;
+	const add = ` + jsString(emojiAddButtonSelector) + `;
+	const mine = Array.from(document.querySelectorAll(` + jsString(page.toolbar+" button") + `))
+		.filter(b => !b.matches(add))
+		.filter(` + ownReactionFilter + `);
+	const button = (want && mine.find(b => keys(b).includes(want))) || mine[0];
+	if (!button) return false;
+	button.click();
+	return true;
+})()`
+}
+
+// removeReaction は投稿ページを開き、自分が送った絵文字リアクションを取り消す
+func removeReaction(parentCtx context.Context, url, emoji string) error {
+	postTimeout, _ := reactionTimeouts()
+	ctx, cancel := context.WithTimeout(parentCtx, postTimeout)
+	defer cancel()
+
+	drv := driverFromContext(parentCtx)
+	page := postPageOf(url)
+	loggerFromContext(ctx).Printf(tr("投稿ページに移動してリアクションを取り消します: %s"), url)
+	status.setCurrentURL(url)
+	if err := openPost(ctx, drv, url); err != nil {
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			return err
+		}
+		return fmt.Errorf(tr("投稿ページの基本読み込みに失敗: %w"), err)
+	}
+	var unavailable string
+	if err := runActions(ctx,
+		drv.Poll(`(`+page.availability+`) !== null`, 10*time.Second),
+		drv.Evaluate(page.availability, &unavailable),
+	); err == nil && unavailable != "" {
+		return &skipError{reason: tr(unavailable)}
+	}
+
+	var removed bool
+	if err := runActions(ctx,
+		drv.ScrollIntoView(page.toolbar),
+		drv.WaitVisible(page.addButton),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(removeReactionScript(page, emoji), &removed),
+	); err != nil {
+		return fmt.Errorf(tr("リアクションの取り消しに失敗: %w"), err)
+	}
+	if !removed {
+		return &skipError{reason: tr("自分のリアクションが見つかりません")}
+	}
+	if err := runActions(ctx, drv.WaitNetworkIdle()); err != nil {
+		return err
+	}
+	status.markStep()
+	return nil
+}
@@ -0,0 +1,242 @@
+package yamap
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// engagers は engagers-export で書き出す、自分の最近の投稿にリアクション・DOMOを送ったユーザーの集計
+type engagers struct {
+	CollectedAt time.Time `json:"collected_at"`
+	UserID      int64     `json:"user_id"`
+	// Activities は集計した投稿のURL
+	Activities []string  `json:"activities"`
+	Users      []engager `json:"users"`
+}
+
+// engager はユーザー1人が自分の投稿に送った反応の数
+type engager struct {
+	UserID int64  `json:"user_id"`
+	Name   string `json:"name"`
+	// Reactions は送られたリアクション・DOMOの総数、Activities はそのうち反応があった投稿の件数
+	Reactions  int `json:"reactions"`
+	Activities int `json:"activities"`
+}
+
+// engagerEntry は投稿1件のリアクション・DOMOの一覧に載っているユーザー1件
+type engagerEntry struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+// engagersNuxtScript は活動日記詳細ページのNUXTのデータから、リアクション・DOMOを送ったユーザーを取り出すスクリプト。
+// 絵文字ごとの users と、domo_users のような項目を探し、同じユーザーが複数の絵文字を送った場合はその数だけ返す。見つからない場合は null を返す
+const engagersNuxtScript = `(() => {
+	const seen = new Set();
+	const found = [];
+	const pushUsers = users => {
+		for (const u of users) {
+			const user = u && (u.user || u);
+			if (user && user.id) found.push({ href: "/users/" + user.id, name: user.name || "" });
+		}
+	};
+	const walk = (v, depth) => {
+		if (!v || typeof v !== "object" || depth > 8 || seen.has(v)) return;
+		seen.add(v);
+		for (const [k, x] of Object.entries(v)) {
+			if (k === "emoji_reactions" && Array.isArray(x)) {
+				for (const r of x) if (r && Array.isArray(r.users)) pushUsers(r.users);
+			} else if (/^domo_?users$|^domos$/i.test(k) && Array.isArray(x)) {
+				pushUsers(x);
+			} else {
+				walk(x, depth + 1);
+			}
+		}
+	};
+	walk(window.__NUXT__, 0);
+	return found.length > 0 ? found : null;
+})()`
+
+// engagersOpenScript はリアクション・DOMOの数の表示を押して、送ったユーザーの一覧を開くスクリプト。押せる要素がなければ false を返す
+const engagersOpenScript = `(() => {
+	const el = document.querySelector('[class*="ReactionUsers"], [class*="DomoUsers"], a[href$="/reactions"], a[href$="/domos"], [class*="EmojiReaction"] button');
+	if (!el) return false;
+	el.click();
+	return true;
+})()`
+
+// engagersDialogScript は開いた一覧のダイアログに表示されているユーザーを取得するスクリプト
+const engagersDialogScript = `Array.from(document.querySelectorAll('[role="dialog"] a[href^="/users/"]'))
+	.map(a => ({ href: a.getAttribute("href"), name: (a.textContent || "").trim() }))`
+
+// runEngagersExport は自分の最近の投稿 (ENGAGERS_ACTIVITIES 件、既定値 10) にリアクション・DOMOを送ったユーザーを集計し、
+// 反応の多い順に ENGAGERS_FILE (既定値 engagers.json) に書き出す。拡張子が .csv の場合はCSVで上書きする
+func runEngagersExport() error {
+	log.Println(tr("--- プログラム開始 (engagers-export) ---"))
+	startTime := time.Now()
+	count := 10
+	if v := os.Getenv("ENGAGERS_ACTIVITIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("ENGAGERS_ACTIVITIESの値が不正です: %s"), v)
+		}
+		count = n
+	}
+	path := os.Getenv("ENGAGERS_FILE")
+	if path == "" {
+		path = "engagers.json"
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、自分の活動日記を確認できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+	var paths []string
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", sess.UserID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(myActivityLinksScript, &paths),
+	); err != nil {
+		return fmt.Errorf(tr("自分の活動日記の一覧の取得に失敗: %w"), err)
+	}
+	if len(paths) > count {
+		paths = paths[:count]
+	}
+
+	result := engagers{CollectedAt: time.Now(), UserID: sess.UserID}
+	byID := make(map[int64]*engager)
+	for i, p := range paths {
+		if maxRuntimeReached() || ctx.Err() != nil {
+			break
+		}
+		url := "https://yamap.com" + p
+		loggerFromContext(ctx).Printf(tr("リアクションしたユーザーを確認します (%d/%d): %s"), i+1, len(paths), url)
+		entries, err := collectEngagers(ctx, url)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("リアクションしたユーザーの取得に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		result.Activities = append(result.Activities, url)
+		counted := make(map[int64]bool)
+		for _, e := range entries {
+			id := userIDFromPath(e.Href)
+			if id == 0 || id == sess.UserID {
+				continue
+			}
+			u, ok := byID[id]
+			if !ok {
+				u = &engager{UserID: id}
+				byID[id] = u
+			}
+			if u.Name == "" {
+				u.Name = e.Name
+			}
+			u.Reactions++
+			if !counted[id] {
+				counted[id] = true
+				u.Activities++
+			}
+		}
+		status.markStep()
+		pace.wait(ctx)
+	}
+
+	for _, u := range byID {
+		result.Users = append(result.Users, *u)
+	}
+	sort.Slice(result.Users, func(i, j int) bool {
+		a, b := result.Users[i], result.Users[j]
+		if a.Reactions != b.Reactions {
+			return a.Reactions > b.Reactions
+		}
+		if a.Activities != b.Activities {
+			return a.Activities > b.Activities
+		}
+		return a.UserID < b.UserID
+	})
+	loggerFromContext(ctx).Printf(tr("最近の投稿 %d 件に %d 人がリアクションしました。"), len(result.Activities), len(result.Users))
+	if err := result.write(path); err != nil {
+		return fmt.Errorf(tr("リアクションしたユーザーの書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("リアクションしたユーザーを %s に書き出しました。"), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// collectEngagers は投稿のページを開き、リアクション・DOMOを送ったユーザーの一覧を返す。
+// NUXTのデータから読み取れない場合は、数の表示を押して開いた一覧のダイアログから読み取る
+func collectEngagers(ctx context.Context, url string) ([]engagerEntry, error) {
+	drv := driverFromContext(ctx)
+	var entries []engagerEntry
+	if err := runActions(ctx,
+		drv.Navigate(url),
+		drv.WaitVisible(layout.footer),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(engagersNuxtScript, &entries),
+	); err != nil {
+		return nil, err
+	}
+	if entries != nil {
+		return entries, nil
+	}
+	var opened bool
+	if err := runActions(ctx, drv.Evaluate(engagersOpenScript, &opened)); err != nil || !opened {
+		// リアクションが1件もない投稿には一覧を開く要素がない
+		return nil, err
+	}
+	if err := runActions(ctx,
+		drv.Poll(`document.querySelector('[role="dialog"] a[href^="/users/"]') !== null`, 5*time.Second),
+		drv.Evaluate(engagersDialogScript, &entries),
+	); err != nil {
+		return nil, fmt.Errorf(tr("リアクションしたユーザーの一覧を開けませんでした: %w"), err)
+	}
+	return entries, nil
+}
+
+// write は集計をファイルに書き出す。拡張子が .csv の場合はユーザーごとの行をCSVで、それ以外はJSONで上書きする
+func (e engagers) write(path string) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"collected_at", "user_id", "name", "reactions", "activities"})
+	collectedAt := e.CollectedAt.Format(time.RFC3339)
+	for _, u := range e.Users {
+		w.Write([]string{collectedAt, strconv.FormatInt(u.UserID, 10), u.Name, strconv.Itoa(u.Reactions), strconv.Itoa(u.Activities)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,151 @@
+package yamap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// runEvent は /events で配信する実行の進捗イベント
+type runEvent struct {
+	// Type は login (ログイン成功), collected (収集), navigating (投稿ページへの移動), reacted (成功), skipped (スキップ),
+	// error (失敗), done (実行の終了) のいずれか
+	Type    string `json:"type"`
+	Action  string `json:"action"`
+	Account string `json:"account,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Emoji   string `json:"emoji,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Result は done のイベントにのみ含まれる実行の結果
+	Result *runRecord `json:"result,omitempty"`
+	RunID  string     `json:"run_id"`
+	At     string     `json:"at"`
+}
+
+// eventBroker は進捗イベントを /events の購読者に配る
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan runEvent]struct{}
+	// ndjson は -output ndjson の出力先。設定されていればイベントを1行ずつJSONで書き出す
+	ndjson io.Writer
+	// done は実行の終了を一度だけ配信するために使う
+	done sync.Once
+}
+
+// ndjsonEventTypes は -output ndjson で出力するイベントと、その出力時の名前
+var ndjsonEventTypes = map[string]string{
+	"login":     "login_ok",
+	"collected": "url_collected",
+	"reacted":   "reaction_sent",
+	"error":     "reaction_failed",
+	"skipped":   "reaction_skipped",
+	"done":      "run_done",
+}
+
+// outputFormat は -output フラグの値 (text, ndjson)
+var outputFormat string
+
+// events はプロセス全体で共有する進捗イベントの配信先
+var events = &eventBroker{subscribers: make(map[chan runEvent]struct{})}
+
+// eventBufferSize は購読者ごとに保持するイベントの数。読み出しが追いつかない購読者の分は捨てる
+const eventBufferSize = 64
+
+// publish はイベントを全ての購読者に送る。処理を止めないよう、バッファが一杯の購読者には送らない
+func (b *eventBroker) publish(typ, url, emoji, message string) {
+	b.send(runEvent{Type: typ, URL: url, Emoji: emoji, Message: message})
+}
+
+// publishDone は実行の終了を結果とともに配信する。中止した場合は理由を message に含める。
+// 終了の経路が複数あっても配信するのは最初の1回だけ
+func (b *eventBroker) publishDone() {
+	b.done.Do(func() {
+		r := status.result()
+		b.send(runEvent{Type: "done", Message: r.Aborted, Result: &r})
+	})
+}
+
+func (b *eventBroker) send(ev runEvent) {
+	ev.Action = status.report().Action
+	ev.Account = os.Getenv("YAMAP_ACCOUNT")
+	ev.RunID = runID
+	ev.At = time.Now().Format(time.RFC3339)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if name, ok := ndjsonEventTypes[ev.Type]; ok && b.ndjson != nil {
+		line := ev
+		line.Type = name
+		data, _ := json.Marshal(line)
+		b.ndjson.Write(append(data, '\n'))
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe はイベントを受け取るチャネルと、購読をやめる関数を返す
+func (b *eventBroker) subscribe() (<-chan runEvent, func()) {
+	ch := make(chan runEvent, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// publishResult は投稿1件の処理結果をイベントとして配信する
+func (b *eventBroker) publishResult(url, sent string, err error) {
+	var skipErr *skipError
+	switch {
+	case errors.As(err, &skipErr):
+		b.publish("skipped", url, "", skipErr.reason)
+	case err != nil:
+		b.publish("error", url, "", err.Error())
+	default:
+		b.publish("reacted", url, sent, "")
+	}
+}
+
+// serveEvents は進捗イベントを Server-Sent Events として配信する。接続が切れるまで返らない
+func serveEvents(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, tr("ストリーミングに対応していません"), http.StatusInternalServerError)
+		return
+	}
+	ch, unsubscribe := events.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// プロキシに無通信の接続を切られないよう、定期的にコメント行を送る
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case ev := <-ch:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,310 @@
+package yamap
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// saveFeedPath は -save-feed フラグで指定されたフィードの保存先
+var saveFeedPath string
+
+// savedFeed は読み込んだフィードを記録する。保存しない場合は nil
+var savedFeed *feedRecorder
+
+// feedExportItem はフィードを保存する際の1件分。分析やテストデータとして扱いやすいよう、必要な項目を平坦にしている
+type feedExportItem struct {
+	ID            int64  `json:"id"`
+	FeedableType  string `json:"feedable_type"`
+	URL           string `json:"url,omitempty"`
+	AuthorID      int64  `json:"author_id,omitempty"`
+	AuthorName    string `json:"author_name,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Text          string `json:"text,omitempty"`
+	Reacted       bool   `json:"reacted"`
+	ReactionCount int    `json:"reaction_count"`
+	StartAt       string `json:"start_at,omitempty"`
+	CreatedAt     string `json:"created_at,omitempty"`
+}
+
+// feedRecorder は読み込んだフィードを重複なく記録する
+type feedRecorder struct {
+	seen  map[int64]struct{}
+	items []feedExportItem
+}
+
+func newFeedRecorder() *feedRecorder {
+	return &feedRecorder{seen: make(map[int64]struct{})}
+}
+
+// add はフィードを記録し、新しく記録した件数を返す。記録しない場合 (nil) は何もしない
+func (r *feedRecorder) add(items []FeedItem) int {
+	if r == nil {
+		return 0
+	}
+	added := 0
+	for _, item := range items {
+		if _, ok := r.seen[item.ID]; ok {
+			continue
+		}
+		r.seen[item.ID] = struct{}{}
+		added++
+		exported := feedExportItem{ID: item.ID, FeedableType: item.FeedableType, CreatedAt: string(item.CreatedAt)}
+		if a := item.Activity; a != nil {
+			exported.URL = fmt.Sprintf("https://yamap.com/activities/%d", a.ID)
+			exported.Title = a.Title
+			exported.StartAt = string(a.StartAt)
+			exported.ReactionCount = len(a.EmojiReactions)
+			if a.CreatedAt != "" {
+				exported.CreatedAt = string(a.CreatedAt)
+			}
+			if a.User != nil {
+				exported.AuthorID = a.User.ID
+				exported.AuthorName = a.User.Name
+			}
+			for _, reaction := range a.EmojiReactions {
+				if reaction.ViewerHasReacted {
+					exported.Reacted = true
+					break
+				}
+			}
+		}
+		if item.Journal != nil {
+			exported.Text = item.Journal.Text
+		}
+		r.items = append(r.items, exported)
+	}
+	return added
+}
+
+// write は記録したフィードをファイルに書き出す。拡張子が .atom の場合はAtom、.rss の場合はRSS 2.0、それ以外はJSON
+func (r *feedRecorder) write(path string) error {
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".atom":
+		data, err = r.atom(time.Now())
+	case ".rss":
+		data, err = r.rss(time.Now())
+	default:
+		data, err = json.MarshalIndent(r.items, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// feedTitle はAtom・RSSのフィードの名前
+const feedTitle = "YAMAP タイムライン"
+
+// entryTitle は記録したフィード1件の、フィードリーダーに表示するタイトル
+func (item feedExportItem) entryTitle() string {
+	title := item.Title
+	if title == "" {
+		title = item.FeedableType
+	}
+	if item.AuthorName != "" {
+		title = item.AuthorName + ": " + title
+	}
+	return title
+}
+
+// updated はフィード1件の投稿日時を返す。読み取れない場合は now
+func (item feedExportItem) updated(now time.Time) time.Time {
+	if t := feedTimestamp(item.CreatedAt).time(); !t.IsZero() {
+		return t
+	}
+	return now
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string    `xml:"id"`
+	Title   string    `xml:"title"`
+	Link    *atomLink `xml:"link,omitempty"`
+	Updated string    `xml:"updated"`
+	Author  struct {
+		Name string `xml:"name"`
+		URI  string `xml:"uri,omitempty"`
+	} `xml:"author"`
+	Summary string `xml:"summary,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Link    atomLink    `xml:"link"`
+	Updated string      `xml:"updated"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atom は記録したフィードをAtomに変換する
+func (r *feedRecorder) atom(now time.Time) ([]byte, error) {
+	feed := atomFeed{ID: "https://yamap.com/timeline", Title: feedTitle, Link: atomLink{Href: "https://yamap.com/timeline"}, Updated: now.Format(time.RFC3339)}
+	for _, item := range r.items {
+		e := atomEntry{
+			ID:      fmt.Sprintf("tag:yamap.com,2013:feed/%d", item.ID),
+			Title:   item.entryTitle(),
+			Updated: item.updated(now).Format(time.RFC3339),
+			Summary: item.Text,
+		}
+		if item.URL != "" {
+			e.Link = &atomLink{Href: item.URL, Rel: "alternate"}
+		}
+		e.Author.Name = item.AuthorName
+		if e.Author.Name == "" {
+			e.Author.Name = "YAMAP"
+		}
+		if item.AuthorID != 0 {
+			e.Author.URI = fmt.Sprintf("https://yamap.com/users/%d", item.AuthorID)
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Author      string  `xml:"dc:creator,omitempty"`
+	Description string  `xml:"description,omitempty"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	DC      string   `xml:"xmlns:dc,attr"`
+	Channel struct {
+		Title         string    `xml:"title"`
+		Link          string    `xml:"link"`
+		Description   string    `xml:"description"`
+		LastBuildDate string    `xml:"lastBuildDate"`
+		Items         []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+// rss は記録したフィードをRSS 2.0に変換する。投稿者の名前はメールアドレスを求める author ではなく dc:creator に入れる
+func (r *feedRecorder) rss(now time.Time) ([]byte, error) {
+	feed := rssFeed{Version: "2.0", DC: "http://purl.org/dc/elements/1.1/"}
+	feed.Channel.Title = feedTitle
+	feed.Channel.Link = "https://yamap.com/timeline"
+	feed.Channel.Description = feedTitle
+	feed.Channel.LastBuildDate = now.Format(time.RFC1123Z)
+	for _, item := range r.items {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       item.entryTitle(),
+			Link:        item.URL,
+			GUID:        rssGUID{Value: fmt.Sprintf("tag:yamap.com,2013:feed/%d", item.ID)},
+			PubDate:     item.updated(now).Format(time.RFC1123Z),
+			Author:      item.AuthorName,
+			Description: item.Text,
+		})
+	}
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// runExportFeed はタイムラインのフィードをリアクションせずにファイルへ書き出す
+func runExportFeed() error {
+	log.Println(tr("--- プログラム開始 (export-feed) ---"))
+	startTime := time.Now()
+	if saveFeedPath == "" {
+		saveFeedPath = "feed.json"
+	}
+	count := 50
+	if v := os.Getenv("EXPORT_FEED_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("EXPORT_FEED_COUNTの値が不正です: %s"), v)
+		}
+		count = n
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(true)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+
+	recorder := newFeedRecorder()
+	drv := driverFromContext(ctx)
+	feedEnd := newFeedEndDetector()
+	for len(recorder.items) < count {
+		if maxRuntimeReached() || ctx.Err() != nil {
+			break
+		}
+		if err := runActions(ctx,
+			drv.WaitVisible(layout.feed),
+			drv.Poll(`window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds`, 20*time.Second),
+		); err != nil {
+			loggerFromContext(ctx).Printf(tr("タイムラインデータの準備待機中にエラーが発生しました: %v"), err)
+			break
+		}
+		feedItems, err := parseNuxtData(ctx)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("NUXTデータのパースに失敗: %v"), err)
+			break
+		}
+		added := recorder.add(feedItems)
+		if added > 0 {
+			status.markStep()
+		}
+		loggerFromContext(ctx).Printf(tr("フィードを %d 件読み込みました。"), len(recorder.items))
+		if len(recorder.items) >= count {
+			break
+		}
+		reason, err := feedEnd.observe(ctx, drv, added > 0)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("ページの高さの取得に失敗: %v"), err)
+			break
+		}
+		if reason != "" {
+			loggerFromContext(ctx).Printf(tr("%s。タイムラインの終端と判断します。"), reason)
+			break
+		}
+		if err := runActions(ctx, scrollForMore(drv, feedCountScript)); err != nil {
+			loggerFromContext(ctx).Printf(tr("ページスクロールに失敗: %v"), err)
+			break
+		}
+	}
+	if len(recorder.items) > count {
+		recorder.items = recorder.items[:count]
+	}
+
+	if err := recorder.write(saveFeedPath); err != nil {
+		return fmt.Errorf(tr("フィードの書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("フィード %d 件を %s に書き出しました。"), len(recorder.items), saveFeedPath)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
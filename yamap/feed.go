@@ -0,0 +1,451 @@
+package yamap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ActivityInfo holds the essential details for processing a post.
+type ActivityInfo struct {
+	URL     string
+	Reacted bool
+	// AuthorID is the ID of the user who posted the activity, or 0 if unknown.
+	AuthorID   int64
+	AuthorName string
+	Title      string
+	// PostedAt is when the activity was posted, or the zero time if unknown.
+	PostedAt time.Time
+	// ReactionCount is the number of emoji reaction kinds the activity has received.
+	// It is only meaningful when ReactionCountKnown is set (the timeline feed provides it).
+	ReactionCount      int
+	ReactionCountKnown bool
+	// Variant is the name of the A/B comparison strategy assigned to the activity, if any.
+	Variant string
+	// Emoji is the emoji to send. If empty, it is chosen by the emoji rules when reacting.
+	Emoji string
+}
+
+// User represents the author of an activity.
+type User struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// IsOfficial and IsAmbassador mark YAMAP official/brand accounts and ambassadors.
+	IsOfficial   bool `json:"is_official"`
+	IsAmbassador bool `json:"is_ambassador"`
+}
+
+// Activity represents the activity data within a feed item.
+type Activity struct {
+	ID             int64         `json:"id"`
+	Title          string        `json:"title"`
+	User           *User         `json:"user"`
+	StartAt        feedTimestamp `json:"start_at"`
+	CreatedAt      feedTimestamp `json:"created_at"`
+	EmojiReactions []struct {
+		ViewerHasReacted bool `json:"viewer_has_reacted"`
+	} `json:"emoji_reactions"`
+}
+
+// feedTimestamp accepts both string and Unix-second timestamps from the NUXT payload
+// and keeps them as RFC 3339 strings, so an unexpected format never breaks feed parsing.
+type feedTimestamp string
+
+func (t *feedTimestamp) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*t = feedTimestamp(str)
+		return nil
+	}
+	var sec float64
+	if err := json.Unmarshal(data, &sec); err == nil {
+		*t = feedTimestamp(time.Unix(int64(sec), 0).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// time parses the timestamp, returning the zero time if it is empty or not in RFC 3339.
+func (t feedTimestamp) time() time.Time {
+	parsed, err := time.Parse(time.RFC3339, string(t))
+	if err != nil {
+		return time.Time{}
+	}
+	return parsed
+}
+
+// Moment represents a moment (a short post lighter than an activity) within a feed item.
+type Moment struct {
+	ID             int64         `json:"id"`
+	Text           string        `json:"text"`
+	User           *User         `json:"user"`
+	CreatedAt      feedTimestamp `json:"created_at"`
+	EmojiReactions []struct {
+		ViewerHasReacted bool `json:"viewer_has_reacted"`
+	} `json:"emoji_reactions"`
+}
+
+// Journal represents a journal entry within a feed item.
+// It's kept minimal as we only need it for parsing.
+type Journal struct {
+	ID   int64  `json:"id"`
+	Text string `json:"text"`
+}
+
+// FeedItem represents a single item in the timeline feed.
+// It includes fields for both activities and journals to ensure proper JSON parsing.
+type FeedItem struct {
+	ID           int64         `json:"id"`
+	FeedableType string        `json:"feedable_type"`
+	CreatedAt    feedTimestamp `json:"created_at"`
+	Activity     *Activity     `json:"activity"`
+	Journal      *Journal      `json:"journal"`
+	Moment       *Moment       `json:"moment"`
+	// IsSponsored, IsPromoted are set on sponsored or promoted items mixed into the timeline.
+	IsSponsored bool `json:"is_sponsored"`
+	IsPromoted  bool `json:"is_promoted"`
+}
+
+// promotedFeedableTypes are substrings of feedable_type used by promoted or official campaign items.
+var promotedFeedableTypes = []string{"advertisement", "sponsor", "promot", "campaign", "official"}
+
+// isPromoted reports whether the item is a sponsored, promoted, or official campaign item
+// that a bot should not react to.
+func (f FeedItem) isPromoted() bool {
+	if f.IsSponsored || f.IsPromoted {
+		return true
+	}
+	t := strings.ToLower(f.FeedableType)
+	for _, p := range promotedFeedableTypes {
+		if strings.Contains(t, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseNuxtData extracts and parses the timeline feed data from the page's javascript context.
+func parseNuxtData(ctx context.Context) ([]FeedItem, error) {
+	var res json.RawMessage
+	// タイムラインのフィードは window.__NUXT__.state.timeline.feeds に格納されている
+	// オブジェクトを直接返し、chromedpにJSONへのシリアライズを任せる
+	script := `
+		(function() {
+			if (window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds) {
+				return window.__NUXT__.state.timeline.feeds;
+			}
+			return null;
+		})();
+	`
+	err := runActions(ctx,
+		driverFromContext(ctx).Evaluate(script, &res),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate javascript to get feed items: %w", err)
+	}
+
+	if len(res) == 0 || string(res) == "null" {
+		return []FeedItem{}, nil
+	}
+	archiveNuxtPayload(ctx, "timeline_feeds", res)
+	checkFeedSchemaOnce(ctx, res)
+
+	var items []FeedItem
+	if err := json.Unmarshal(res, &items); err != nil {
+		path := debugPath(ctx, "failed_unmarshal_feeds.json")
+		writeArtifact(path, res)
+		return nil, fmt.Errorf("failed to unmarshal feed items from javascript object: %w. JSON saved to %s", err, path)
+	}
+
+	return items, nil
+}
+
+// archiveNuxtPayload は NUXT_ARCHIVE_DIR が設定されている場合に、取得したペイロードを日時付きのgzipファイルとして保存する。
+// スキーマの変化が断続的にしか起きない場合の調査用で、保存に失敗しても処理は継続する。
+func archiveNuxtPayload(ctx context.Context, name string, payload []byte) {
+	dir := os.Getenv("NUXT_ARCHIVE_DIR")
+	if dir == "" {
+		return
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: NUXTデータの保存先を作成できません: %v"), err)
+		return
+	}
+	path := filepath.Join(dir, time.Now().UTC().Format("20060102T150405.000Z")+"_"+name+".json.gz")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(payload)
+	if err := zw.Close(); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: NUXTデータの圧縮に失敗しました: %v"), err)
+		return
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: NUXTデータの保存に失敗しました: %v"), err)
+		return
+	}
+	pruneNuxtArchive(dir)
+}
+
+// optionalFeedFields はタイムラインのフィードに含まれないことがあるフィールド (FeedItem からのJSONのパス)。
+// これ以外のフィールドが1件のフィードにも含まれない場合は、YAMAPのデータの形が変わったとみなす
+var optionalFeedFields = map[string]bool{
+	"activity":                    true,
+	"journal":                     true,
+	"is_sponsored":                true,
+	"is_promoted":                 true,
+	"activity.start_at":           true,
+	"activity.user.is_official":   true,
+	"activity.user.is_ambassador": true,
+	"journal.text":                true,
+	"moment":                      true,
+	"moment.text":                 true,
+	"moment.user":                 true,
+	"moment.created_at":           true,
+	"moment.user.is_official":     true,
+	"moment.user.is_ambassador":   true,
+}
+
+// feedSchemaReport はタイムラインのフィードを FeedItem の形と照らし合わせた結果
+type feedSchemaReport struct {
+	// Items はフィードの件数
+	Items int `json:"items"`
+	// Missing は FeedItem にあるのに、1件のフィードにも含まれなかったフィード (optionalFeedFields を除く)
+	Missing []string `json:"missing,omitempty"`
+	// Mismatched は型が FeedItem と異なるフィールドと、その内容
+	Mismatched []string `json:"mismatched,omitempty"`
+	// Unknown はフィードに含まれるが FeedItem にないフィールド。使っていないだけのため、失敗とはみなさない
+	Unknown []string `json:"unknown,omitempty"`
+}
+
+// ok はデータの形が FeedItem の想定どおりかを返す
+func (r feedSchemaReport) ok() bool {
+	return r.Items > 0 && len(r.Missing) == 0 && len(r.Mismatched) == 0
+}
+
+// feedSchemaWalker はフィードのJSONをたどり、FeedItem の構造体のフィールドと突き合わせる
+type feedSchemaWalker struct {
+	// objects はパスごとにたどったオブジェクトの数、fields はそのオブジェクトが持つべきフィールドのパス
+	objects map[string]int
+	fields  map[string]string
+	seen    map[string]bool
+	unknown map[string]bool
+	// mismatched はパスごとの型の食い違い
+	mismatched map[string]string
+}
+
+// checkFeedSchema は window.__NUXT__.state.timeline.feeds のJSONを FeedItem の形と照らし合わせる
+func checkFeedSchema(payload []byte) (feedSchemaReport, error) {
+	var items []any
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return feedSchemaReport{}, fmt.Errorf(tr("フィードが配列ではありません: %w"), err)
+	}
+	w := &feedSchemaWalker{objects: make(map[string]int), fields: make(map[string]string), seen: make(map[string]bool),
+		unknown: make(map[string]bool), mismatched: make(map[string]string)}
+	for _, item := range items {
+		w.walk("", reflect.TypeOf(FeedItem{}), item)
+	}
+	report := feedSchemaReport{Items: len(items)}
+	for path, parent := range w.fields {
+		if w.objects[parent] > 0 && !w.seen[path] && !optionalFeedFields[path] {
+			report.Missing = append(report.Missing, path)
+		}
+	}
+	for path := range w.unknown {
+		report.Unknown = append(report.Unknown, path)
+	}
+	for path, detail := range w.mismatched {
+		report.Mismatched = append(report.Mismatched, path+": "+detail)
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Unknown)
+	sort.Strings(report.Mismatched)
+	return report, nil
+}
+
+// feedTimestampType は文字列とUnixの秒数のどちらも受け付ける feedTimestamp の型
+var feedTimestampType = reflect.TypeOf(feedTimestamp(""))
+
+// joinFeedPath は親のパスにフィールド名を加える
+func joinFeedPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// walk は値 v を型 t と照らし合わせる。null はポインタでなくても json.Unmarshal がゼロ値のまま残すため、食い違いとはみなさない
+func (w *feedSchemaWalker) walk(path string, t reflect.Type, v any) {
+	if v == nil {
+		return
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	mismatch := func(want string) {
+		w.mismatched[path] = fmt.Sprintf(tr("%s を想定していますが %s です"), want, jsonKind(v))
+	}
+	if t == feedTimestampType {
+		switch v.(type) {
+		case string, float64:
+		default:
+			mismatch("string/number")
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			mismatch("object")
+			return
+		}
+		w.objects[path]++
+		known := make(map[string]bool)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			known[name] = true
+			fieldPath := joinFeedPath(path, name)
+			w.fields[fieldPath] = path
+			if val, ok := obj[name]; ok {
+				w.seen[fieldPath] = true
+				w.walk(fieldPath, f.Type, val)
+			}
+		}
+		for key := range obj {
+			if !known[key] {
+				w.unknown[joinFeedPath(path, key)] = true
+			}
+		}
+	case reflect.Slice:
+		arr, ok := v.([]any)
+		if !ok {
+			mismatch("array")
+			return
+		}
+		for _, e := range arr {
+			w.walk(path+"[]", t.Elem(), e)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			mismatch("string")
+		}
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		if _, ok := v.(float64); !ok {
+			mismatch("number")
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			mismatch("boolean")
+		}
+	}
+}
+
+// jsonKind はデコードしたJSONの値の種類を返す
+func jsonKind(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+// logFeedSchemaReport はデータの形の確認結果をログに出力する
+func logFeedSchemaReport(ctx context.Context, r feedSchemaReport) {
+	l := loggerFromContext(ctx)
+	l.Printf(tr("フィード %d 件の形を FeedItem と照らし合わせました。"), r.Items)
+	if r.Items == 0 {
+		l.Println(tr("NG  フィードが0件です"))
+	}
+	for _, path := range r.Missing {
+		l.Printf(tr("NG  フィールドがありません: %s"), path)
+	}
+	for _, m := range r.Mismatched {
+		l.Printf(tr("NG  型が異なります: %s"), m)
+	}
+	if len(r.Unknown) > 0 {
+		l.Printf(tr("FeedItem にないフィールド (%d 件、使っていないため失敗とはしません): %s"), len(r.Unknown), strings.Join(r.Unknown, ", "))
+	}
+}
+
+// nuxtSchemaChecked は NUXT_SCHEMA_CHECK による確認を実行ごとに1回にするためのもの
+var nuxtSchemaChecked sync.Once
+
+// checkFeedSchemaOnce は NUXT_SCHEMA_CHECK が設定されていれば、実行の最初に読み込んだフィードのデータの形を確かめる。
+// 食い違いがあれば収集を止めずに警告し、ALERT の通知を送る。収集した件数が0件になる前に変化に気付けるようにする
+func checkFeedSchemaOnce(ctx context.Context, payload []byte) {
+	if os.Getenv("NUXT_SCHEMA_CHECK") != "true" {
+		return
+	}
+	nuxtSchemaChecked.Do(func() {
+		report, err := checkFeedSchema(payload)
+		if err == nil && report.ok() {
+			return
+		}
+		path := debugPath(ctx, "nuxt_schema_timeline.json")
+		writeArtifact(path, payload)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: フィードのデータの形を確認できません: %v"), err)
+		} else {
+			logFeedSchemaReport(ctx, report)
+		}
+		notify(ctx, "ALERT", fmt.Sprintf(tr("タイムラインのフィードのデータの形が想定と異なります。YAMAPのデータが変わった可能性があります (%s)"), path))
+	})
+}
+
+// pruneNuxtArchive は NUXT_ARCHIVE_MAX_AGE (既定値 168h) より古いファイルを削除し、
+// 残りが NUXT_ARCHIVE_MAX_FILES (既定値 200) を超える場合は古いものから削除する
+func pruneNuxtArchive(dir string) {
+	maxAge := 7 * 24 * time.Hour
+	if v := os.Getenv("NUXT_ARCHIVE_MAX_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			maxAge = d
+		}
+	}
+	maxFiles := 200
+	if v := os.Getenv("NUXT_ARCHIVE_MAX_FILES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			maxFiles = n
+		}
+	}
+
+	// ファイル名は日時で始まるため、名前順が保存順になる
+	files, err := filepath.Glob(filepath.Join(dir, "*.json.gz"))
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-maxAge)
+	var kept []string
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && maxAge > 0 && info.ModTime().Before(cutoff) {
+			os.Remove(f)
+			continue
+		}
+		kept = append(kept, f)
+	}
+	if maxFiles > 0 && len(kept) > maxFiles {
+		for _, f := range kept[:len(kept)-maxFiles] {
+			os.Remove(f)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activityEntriesScript は活動一覧ページの各エントリから投稿のパスと投稿者のプロフィールへのパスを取得するスクリプト
+const activityEntriesScript = `Array.from(document.querySelectorAll('[data-testid="activity-entry"]')).flatMap(entry => {
+	const activity = entry.querySelector('a[href^="/activities/"]');
+	if (!activity) return [];
+	const user = entry.querySelector('a[href^="/users/"]');
+	return [{href: activity.getAttribute("href"), user: user ? user.getAttribute("href") : ""}];
+})`
+
+// userIDFromPath は "/users/123" 形式のパスからユーザーIDを取り出す。取り出せない場合は0
+func userIDFromPath(path string) int64 {
+	rest, ok := strings.CutPrefix(path, "/users/")
+	if !ok {
+		return 0
+	}
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		rest = rest[:i]
+	}
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// authorLimiter は1回の実行で同じ投稿者にリアクションする件数と、履歴に基づく同じ投稿者へのリアクション間隔を制限する。
+// 収集は新しい順に行われるため、上限に達するまでに採用されるのは各投稿者の最新の投稿になる。
+type authorLimiter struct {
+	max      int
+	cooldown time.Duration
+	counts   map[int64]int
+	skipped  int
+	cooling  int
+	excluded int
+}
+
+// newAuthorLimiter は MAX_REACTIONS_PER_AUTHOR (既定値1、0で無制限) と
+// AUTHOR_COOLDOWN_DAYS (既定値0、無効) から authorLimiter を作成する
+func newAuthorLimiter(ctx context.Context) *authorLimiter {
+	l := &authorLimiter{max: 1, counts: make(map[int64]int)}
+	if v := os.Getenv("MAX_REACTIONS_PER_AUTHOR"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			loggerFromContext(ctx).Printf(tr("警告: MAX_REACTIONS_PER_AUTHORの値が不正です。既定値 %d を使用します"), l.max)
+		} else {
+			l.max = n
+		}
+	}
+	if v := os.Getenv("AUTHOR_COOLDOWN_DAYS"); v != "" {
+		days, err := strconv.ParseFloat(v, 64)
+		if err != nil || days < 0 {
+			loggerFromContext(ctx).Print(tr("警告: AUTHOR_COOLDOWN_DAYSの値が不正です。クールダウンは無効になります"))
+		} else if history == nil {
+			loggerFromContext(ctx).Print(tr("警告: AUTHOR_COOLDOWN_DAYSを使うにはHISTORY_FILEの設定が必要です。クールダウンは無効になります"))
+		} else {
+			l.cooldown = time.Duration(days * float64(24*time.Hour))
+		}
+	}
+	return l
+}
+
+// allow は投稿者の投稿を処理対象に加えてよいかを判定し、加える場合は件数を数える。
+// 加えない場合はその理由を返す。投稿者が不明 (0) の場合は常に許可する。
+// 名前や公式アカウントなどの情報はタイムラインでのみ取得できるため、他のページではIDのルールだけが適用される。
+func (l *authorLimiter) allow(author User) string {
+	authorID := author.ID
+	if authorID == 0 {
+		return ""
+	}
+	if reason := config.ExcludeAuthors.match(author); reason != "" {
+		l.excluded++
+		return reason
+	}
+	if l.cooldown > 0 {
+		if last, ok := history.lastReactionTo(authorID); ok && time.Since(last) < l.cooldown {
+			l.cooling++
+			return fmt.Sprintf(tr("前回のリアクションから%sが経過していません (前回: %s)"), formatDays(l.cooldown), last.Local().Format("2006-01-02 15:04"))
+		}
+	}
+	if l.max > 0 && l.counts[authorID] >= l.max {
+		l.skipped++
+		return fmt.Sprintf(tr("1回の実行での上限 %d 件に達しています"), l.max)
+	}
+	l.counts[authorID]++
+	return ""
+}
+
+// formatDays は日単位の期間を表示用の文字列にする
+func formatDays(d time.Duration) string {
+	return strconv.FormatFloat(d.Hours()/24, 'f', -1, 64) + tr("日")
+}
+
+// logSummary は投稿者ごとの上限によりスキップした件数を出力する
+func (l *authorLimiter) logSummary(ctx context.Context) {
+	if l.excluded > 0 {
+		loggerFromContext(ctx).Printf(tr("設定ファイルの exclude_authors により %d 件の投稿をスキップしました。"), l.excluded)
+	}
+	if l.skipped > 0 {
+		loggerFromContext(ctx).Printf(tr("同じユーザーへのリアクション上限 (%d件/回) により %d 件の投稿をスキップしました。"), l.max, l.skipped)
+	}
+	if l.cooling > 0 {
+		loggerFromContext(ctx).Printf(tr("同じユーザーへのリアクション間隔 (%s) により %d 件の投稿をスキップしました。"), formatDays(l.cooldown), l.cooling)
+	}
+}
@@ -0,0 +1,179 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// fixtureReplay は -replay で yamap.com の代わりにページを返すローカルのサーバー、
+// fixtureSaver は -save-fixtures でページを保存する先。どちらも未指定の場合は nil
+var (
+	fixtureReplay *fixtureServer
+	fixtureSaver  *fixtureRecorder
+)
+
+// fixturePath はyamap.comとそのサブドメインのURLに対応するフィクスチャのファイルのパスを返す。
+// パスに拡張子がなければ .html を付け、クエリがある場合は "@<クエリ>" を加える (例: /search/activities?page=2 → search/activities@page=2.html)。
+// yamap.com 以外のサブドメインはホスト名のディレクトリに分ける。対象外のURLの場合は空文字を返す
+func fixturePath(dir, rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil || !hostWithin(u.Hostname(), "yamap.com") {
+		return ""
+	}
+	name := strings.TrimPrefix(path.Clean("/"+u.Path), "/")
+	if name == "" {
+		name = "index"
+	}
+	if path.Ext(name) == "" {
+		if u.RawQuery != "" {
+			// ファイル名に使えない文字を避けるため、クエリはキーの順に並べ替えてからエスケープする
+			name += "@" + strings.NewReplacer("/", "%2F", "\\", "%5C", ":", "%3A", "*", "%2A", "?", "%3F", "\"", "%22", "<", "%3C", ">", "%3E", "|", "%7C").Replace(u.Query().Encode())
+		}
+		name += ".html"
+	}
+	if host := strings.ToLower(u.Hostname()); host != "yamap.com" {
+		name = host + "/" + name
+	}
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// fixtureServer は -replay のディレクトリのフィクスチャを返すローカルのHTTPサーバー。
+// タブのyamap.comへのリクエストは Fetch ドメインで http://127.0.0.1:<ポート>/<元のURL> に差し替え、それ以外のリクエストは失敗させる
+type fixtureServer struct {
+	dir     string
+	base    string
+	mu      sync.Mutex
+	missing map[string]bool
+}
+
+// newFixtureServer はローカルのポートで fixtureServer を起動する
+func newFixtureServer(dir string) (*fixtureServer, error) {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf(tr("-replay のディレクトリ %s がありません"), dir)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf(tr("フィクスチャのサーバーを起動できません: %w"), err)
+	}
+	s := &fixtureServer{dir: dir, base: "http://" + ln.Addr().String() + "/", missing: make(map[string]bool)}
+	go http.Serve(ln, s)
+	return s, nil
+}
+
+// localURL は元のURLを、fixtureServer が返すURLに変換する
+func (s *fixtureServer) localURL(rawURL string) string {
+	return s.base + neturl.PathEscape(rawURL)
+}
+
+// ServeHTTP はパスに埋め込んだ元のURLに対応するフィクスチャを返す。クエリ付きのフィクスチャがなければクエリのないものを返す
+func (s *fixtureServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	original, err := neturl.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	candidates := []string{fixturePath(s.dir, original)}
+	if u, err := neturl.Parse(original); err == nil && u.RawQuery != "" {
+		u.RawQuery = ""
+		candidates = append(candidates, fixturePath(s.dir, u.String()))
+	}
+	for _, p := range candidates {
+		// http.ServeFile はリクエストのパスでリダイレクトすることがあるため、ファイルを開いて ServeContent で返す
+		f, err := os.Open(p)
+		if p == "" || err != nil {
+			continue
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil && !info.IsDir() {
+			http.ServeContent(w, r, filepath.Base(p), info.ModTime(), f)
+			return
+		}
+	}
+	s.mu.Lock()
+	first := !s.missing[candidates[0]]
+	s.missing[candidates[0]] = true
+	s.mu.Unlock()
+	if first {
+		log.Printf(tr("フィクスチャがないため404を返します: %s (%s)"), original, candidates[0])
+	}
+	http.NotFound(w, r)
+}
+
+// fixtureRecorder は -save-fixtures で、タブが読み込んだyamap.comのページ (サーバーが返したHTMLとNUXTのデータ) をフィクスチャとして保存する
+type fixtureRecorder struct {
+	dir string
+	mu  sync.Mutex
+	// pending はリクエストIDごとの、読み込みの完了を待っているページのURL
+	pending map[network.RequestID]string
+}
+
+func newFixtureRecorder(dir string) (*fixtureRecorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf(tr("フィクスチャの保存先を作成できません: %w"), err)
+	}
+	return &fixtureRecorder{dir: dir, pending: make(map[network.RequestID]string)}, nil
+}
+
+// handle はNetworkドメインのイベントを受け取り、正常に読み込まれたyamap.comのページの本文を保存する
+func (r *fixtureRecorder) handle(ctx context.Context, ev interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventResponseReceived:
+		if ev.Type == network.ResourceTypeDocument && ev.Response.Status == http.StatusOK && fixturePath(r.dir, ev.Response.URL) != "" {
+			r.pending[ev.RequestID] = ev.Response.URL
+		}
+	case *network.EventLoadingFinished:
+		url, ok := r.pending[ev.RequestID]
+		if !ok {
+			return
+		}
+		delete(r.pending, ev.RequestID)
+		// イベントのリスナーの中ではCDPのコマンドの応答を待てないため、別のゴルーチンで取得する
+		go r.save(ctx, ev.RequestID, url)
+	case *network.EventLoadingFailed:
+		delete(r.pending, ev.RequestID)
+	}
+}
+
+// save はページの本文を取得し、秘密の値を伏せてフィクスチャのファイルに書き出す
+func (r *fixtureRecorder) save(ctx context.Context, id network.RequestID, url string) {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Target == nil {
+		return
+	}
+	bodyCtx, cancel := context.WithTimeout(cdp.WithExecutor(ctx, c.Target), 10*time.Second)
+	defer cancel()
+	body, err := network.GetResponseBody(id).Do(bodyCtx)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: フィクスチャの本文を取得できません (%s): %v"), url, err)
+		return
+	}
+	p := fixturePath(r.dir, url)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: フィクスチャを保存できません (%s): %v"), p, err)
+		return
+	}
+	if err := writeFileAtomic(p, []byte(redaction.redact(string(body))), 0o644); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: フィクスチャを保存できません (%s): %v"), p, err)
+		return
+	}
+	loggerFromContext(ctx).Printf(tr("フィクスチャを保存しました: %s"), p)
+}
@@ -0,0 +1,314 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// runFollowSearch は react-activities と同じ活動日記の検索結果から投稿者を集め、リアクションの代わりにフォローする
+func runFollowSearch() error {
+	log.Println(tr("--- プログラム開始 (follow-search) ---"))
+	startTime := time.Now()
+
+	maxFollows := 10
+	if v := os.Getenv("FOLLOW_SEARCH_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("FOLLOW_SEARCH_MAXの値が不正です: %s"), v)
+		}
+		maxFollows = n
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	authors := collectSearchAuthors(ctx, maxFollows)
+	loggerFromContext(ctx).Printf(tr("%d人の投稿者を収集しました。フォローを開始します。"), len(authors))
+	status.setPhase("reacting")
+	followed := followUsers(ctx, authors)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- %d人をフォローしました ---"), followed)
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// collectSearchAuthors は活動日記の検索結果を巡回し、まだフォローしていない投稿者のIDを最大 maxAuthors 人まで集める。
+// 自分自身と、履歴でフォロー済みのユーザーは除く
+func collectSearchAuthors(ctx context.Context, maxAuthors int) []int64 {
+	drv := driverFromContext(ctx)
+	self := sessionFromContext(ctx).UserID
+	seen := map[int64]struct{}{0: {}, self: {}}
+	var ids []int64
+	for page := 1; len(ids) < maxAuthors; page++ {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		pageURL := activitySearchURL(page)
+		loggerFromContext(ctx).Printf(tr("%dページ目に移動します: %s"), page, pageURL)
+		var entries []struct {
+			Href     string `json:"href"`
+			UserHref string `json:"user"`
+		}
+		if err := runActions(ctx,
+			drv.Navigate(pageURL),
+			drv.WaitVisible(`footer[data-global-footer="true"]`),
+			drv.Evaluate(activityEntriesScript, &entries),
+		); err != nil {
+			loggerFromContext(ctx).Printf(tr("%dページ目の読み込みに失敗しました: %v"), page, err)
+			break
+		}
+		before := len(ids)
+		for _, entry := range entries {
+			id := userIDFromPath(entry.UserHref)
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			if history.hasFollowed(id) {
+				continue
+			}
+			ids = append(ids, id)
+			status.markStep()
+			if len(ids) >= maxAuthors {
+				break
+			}
+		}
+		if len(ids) == before {
+			loggerFromContext(ctx).Println(tr("このページでは新しい投稿者が見つかりませんでした。収集を終了します。"))
+			break
+		}
+		time.Sleep(2 * time.Second) // サーバーへの負荷を考慮した待機
+	}
+	return ids
+}
+
+// followButtonScript はプロフィールページのフォローボタンを押す。押した場合は "followed"、
+// 既にフォロー中の場合は "following"、ボタンが見つからない場合は空文字を返す
+var followButtonScript = func() string {
+	following, _ := json.Marshal(uiLabels["following"])
+	follow, _ := json.Marshal(uiLabels["follow"])
+	return fmt.Sprintf(`(() => {
+	const buttons = Array.from(document.querySelectorAll("main button"));
+	const text = b => (b.getAttribute("aria-label") || b.textContent || "").trim();
+	if (buttons.some(b => %s.includes(text(b)))) return "following";
+	const follow = buttons.find(b => %s.includes(text(b)));
+	if (!follow) return "";
+	follow.click();
+	return "followed";
+})()`, following, follow)
+}()
+
+// followUser はユーザーのプロフィールページを開いてフォローする。既にフォロー中の場合は skipError を返す
+func followUser(parentCtx context.Context, userID int64) error {
+	ctx, cancel := context.WithTimeout(parentCtx, 60*time.Second)
+	defer cancel()
+	drv := driverFromContext(parentCtx)
+	url := fmt.Sprintf("https://yamap.com/users/%d", userID)
+	loggerFromContext(ctx).Printf(tr("プロフィールページに移動してフォローします: %s"), url)
+	status.setCurrentURL(url)
+	var result string
+	if err := runActions(ctx,
+		drv.Navigate(url),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(followButtonScript, &result),
+	); err != nil {
+		return fmt.Errorf(tr("フォローボタンの操作に失敗: %w"), err)
+	}
+	switch result {
+	case "following":
+		return &skipError{reason: tr("フォロー済み")}
+	case "":
+		return errors.New(tr("フォローボタンが見つかりません"))
+	}
+	if err := runActions(ctx, drv.WaitNetworkIdle()); err != nil {
+		return err
+	}
+	status.markStep()
+	return nil
+}
+
+// followUsers はユーザーを順にフォローし、フォローした人数を返す。投稿の間隔やキルスイッチはリアクションと同じく適用する
+func followUsers(ctx context.Context, userIDs []int64) int {
+	queue := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		queue[i] = fmt.Sprintf("https://yamap.com/users/%d", id)
+	}
+	status.setQueue(queue)
+	pace.spreadOver(ctx, len(queue))
+	followed := 0
+	for i, id := range userIDs {
+		if waitForKillSwitch(ctx) == killSwitchStop {
+			loggerFromContext(ctx).Println(tr("キルスイッチにより停止が指示されたため、フォローを終了します。"))
+			break
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Printf(tr("最大実行時間 (%s) に達したため、新しいユーザーの処理を終了します。"), maxRuntime)
+			break
+		}
+		if !waitForOperatingHours(ctx) {
+			break
+		}
+		recycleTabIfNeeded(ctx)
+		loggerFromContext(ctx).Printf(tr("--- ユーザー %d/%d を処理中 ---"), i+1, len(userIDs))
+		err := followUser(ctx, id)
+		status.recordResult(err == nil, err)
+		events.publishResult(queue[i], "", err)
+		recordPostOutcome(ctx, queue[i], err)
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) をスキップしました: %s"), id, skipErr.reason)
+			if err := history.markFollowed(id, time.Now()); err != nil {
+				loggerFromContext(ctx).Printf(tr("警告: フォローの履歴の保存に失敗しました: %v"), err)
+			}
+		} else if err != nil {
+			loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) のフォローに失敗しました: %v"), id, err)
+		} else {
+			followed++
+			loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) をフォローしました。(現在 %d/%d 人)"), id, followed, len(userIDs))
+			if err := history.markFollowed(id, time.Now()); err != nil {
+				loggerFromContext(ctx).Printf(tr("警告: フォローの履歴の保存に失敗しました: %v"), err)
+			}
+		}
+		if ctx.Err() != nil {
+			loggerFromContext(ctx).Println(tr("メインコンテキストがキャンセルされたため、フォローを中断します。"))
+			break
+		}
+		pace.wait(ctx)
+	}
+	return followed
+}
+
+// myActivityLinksScript はプロフィールページに表示されている活動日記のパスを重複なく取得するスクリプト
+const myActivityLinksScript = `Array.from(new Set(Array.from(document.querySelectorAll('main a[href^="/activities/"]'))
+	.map(a => (a.getAttribute("href").match(/^\/activities\/\d+/) || [""])[0]).filter(Boolean)))`
+
+// commenterLinksScript は活動日記のコメント欄に表示されているユーザーのプロフィールへのパスを取得するスクリプト
+const commenterLinksScript = `Array.from(document.querySelectorAll('[class*="Comment"] a[href^="/users/"]')).map(a => a.getAttribute("href"))`
+
+// runFollowCommenters は自分の最近の活動日記にコメントしたユーザーのうち、まだフォローしていないユーザーをフォローする
+func runFollowCommenters() error {
+	log.Println(tr("--- プログラム開始 (follow-commenters) ---"))
+	startTime := time.Now()
+
+	maxFollows := 10
+	if v := os.Getenv("FOLLOW_COMMENTERS_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("FOLLOW_COMMENTERS_MAXの値が不正です: %s"), v)
+		}
+		maxFollows = n
+	}
+	activityCount := 5
+	if v := os.Getenv("FOLLOW_COMMENTERS_ACTIVITIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("FOLLOW_COMMENTERS_ACTIVITIESの値が不正です: %s"), v)
+		}
+		activityCount = n
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、自分の活動日記を確認できません"))
+	}
+	status.setPhase("collecting")
+	status.markStep()
+
+	commenters, err := collectCommenters(ctx, sess.UserID, activityCount, maxFollows)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("コメントしたユーザーの収集中にエラーが発生しました: %v"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d人のユーザーを収集しました。フォローを開始します。"), len(commenters))
+	status.setPhase("reacting")
+	followed := followUsers(ctx, commenters)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- %d人をフォローしました ---"), followed)
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// collectCommenters は自分の最近の活動日記 activityCount 件のコメント欄から、フォローの対象のユーザーを最大 maxUsers 人まで集める。
+// 自分自身、履歴でフォロー済みのユーザー、設定ファイルの follow_commenters_deny のユーザーを除き、
+// follow_commenters_allow が指定されている場合はそのユーザーだけに絞る
+func collectCommenters(ctx context.Context, userID int64, activityCount, maxUsers int) ([]int64, error) {
+	drv := driverFromContext(ctx)
+	var paths []string
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", userID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(myActivityLinksScript, &paths),
+	); err != nil {
+		return nil, fmt.Errorf(tr("自分の活動日記の一覧の取得に失敗: %w"), err)
+	}
+	if len(paths) > activityCount {
+		paths = paths[:activityCount]
+	}
+
+	allow := make(map[int64]struct{})
+	for _, id := range config.FollowCommentersAllow {
+		allow[id] = struct{}{}
+	}
+	seen := map[int64]struct{}{0: {}, userID: {}}
+	for _, id := range config.FollowCommentersDeny {
+		seen[id] = struct{}{}
+	}
+	var ids []int64
+	for _, path := range paths {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		url := "https://yamap.com" + path
+		loggerFromContext(ctx).Printf(tr("コメント欄を確認します: %s"), url)
+		var hrefs []string
+		if err := runActions(ctx,
+			drv.Navigate(url),
+			drv.WaitVisible(layout.footer),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(commenterLinksScript, &hrefs),
+		); err != nil {
+			loggerFromContext(ctx).Printf(tr("コメント欄の取得に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		status.markStep()
+		for _, href := range hrefs {
+			id := userIDFromPath(href)
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			if _, ok := allow[id]; len(allow) > 0 && !ok {
+				continue
+			}
+			if history.hasFollowed(id) {
+				continue
+			}
+			ids = append(ids, id)
+			if len(ids) >= maxUsers {
+				return ids, nil
+			}
+		}
+	}
+	return ids, nil
+}
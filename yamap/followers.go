@@ -0,0 +1,221 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// followerLinksScript はフォロワー一覧に表示されているユーザーのプロフィールへのパスを取得するスクリプト
+const followerLinksScript = `Array.from(document.querySelectorAll('main a[href^="/users/"]')).map(a => a.getAttribute("href"))`
+
+// collectFollowers は自分のフォロワー一覧ページをスクロールし、表示されたフォロワーのIDを新しい順に返す
+func collectFollowers(ctx context.Context, userID int64) ([]int64, error) {
+	drv := driverFromContext(ctx)
+	url := fmt.Sprintf("https://yamap.com/users/%d?tab=followers", userID)
+	if err := runActions(ctx, drv.Navigate(url), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	seen := map[int64]struct{}{userID: {}}
+	for noNew := 0; noNew < 3; {
+		var hrefs []string
+		if err := runActions(ctx, drv.Evaluate(followerLinksScript, &hrefs)); err != nil {
+			return nil, err
+		}
+		before := len(ids)
+		for _, href := range hrefs {
+			id := userIDFromPath(href)
+			if _, ok := seen[id]; ok || id == 0 {
+				continue
+			}
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+		if len(ids) == before {
+			noNew++
+		} else {
+			noNew = 0
+			status.markStep()
+		}
+		if err := runActions(ctx, scrollForMore(drv, "("+followerLinksScript+").length")); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// latestActivityURL はユーザーのプロフィールページから最新の活動日記のURLを返す。投稿がない場合は空文字
+func latestActivityURL(ctx context.Context, userID int64) (string, error) {
+	drv := driverFromContext(ctx)
+	var href string
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", userID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(`(document.querySelector('main a[href^="/activities/"]') || {getAttribute: () => ""}).getAttribute("href")`, &href),
+	); err != nil {
+		return "", err
+	}
+	if href == "" {
+		return "", nil
+	}
+	return "https://yamap.com" + href, nil
+}
+
+// thankFollower はフォロワーの投稿にリアクションとお礼コメントを送る
+func thankFollower(ctx context.Context, url string, react bool) error {
+	if react {
+		liked, sent, err := sendReaction(ctx, url, "")
+		postReactionEvent(ctx, ActivityInfo{URL: url}, sent, err)
+		events.publishResult(url, sent, err)
+		recordPostOutcome(ctx, url, err)
+		// すでにリアクションしている投稿でも、お礼のコメントは送る
+		if err != nil && !isAlreadyReacted(err) {
+			return err
+		}
+		if liked {
+			recordReaction(ctx, ActivityInfo{URL: url}, sent)
+		}
+	} else if err := runActions(ctx, driverFromContext(ctx).Navigate(url), driverFromContext(ctx).WaitVisible(layout.footer)); err != nil {
+		return err
+	}
+	return postComment(ctx, driverFromContext(ctx), config.thankYouTemplates)
+}
+
+// runFollowersReaction は自分のフォロワーの最新の活動日記にリアクションを送る。
+// 前回のリアクションから REACT_FOLLOWERS_COOLDOWN_DAYS が経過していないフォロワーと、履歴または投稿ページでリアクション済みの投稿は除く
+func runFollowersReaction() error {
+	log.Println(tr("--- プログラム開始 (react-followers) ---"))
+	startTime := time.Now()
+
+	maxFollowers := 20
+	if v := os.Getenv("REACT_FOLLOWERS_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("REACT_FOLLOWERS_MAXの値が不正です: %s"), v)
+		}
+		maxFollowers = n
+	}
+	cooldown := 7 * 24 * time.Hour
+	if v := os.Getenv("REACT_FOLLOWERS_COOLDOWN_DAYS"); v != "" {
+		days, err := strconv.ParseFloat(v, 64)
+		if err != nil || days < 0 {
+			return fmt.Errorf(tr("REACT_FOLLOWERS_COOLDOWN_DAYSの値が不正です: %s"), v)
+		}
+		cooldown = time.Duration(days * float64(24*time.Hour))
+	}
+	if cooldown > 0 && history == nil {
+		log.Print(tr("警告: HISTORY_FILEが設定されていないため、フォロワーごとのリアクション間隔は確認しません"))
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	var activities []ActivityInfo
+	if !runCheckpoints.resuming() {
+		sess := sessionFromContext(ctx)
+		if sess.UserID == 0 {
+			return errors.New(tr("自分のユーザーIDを取得できなかったため、フォロワー一覧を確認できません"))
+		}
+		activities, err = collectFollowerActivities(ctx, sess.UserID, maxFollowers, cooldown)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("フォロワーの投稿の収集中にエラーが発生しました: %v"), err)
+		}
+		loggerFromContext(ctx).Printf(tr("%d件の投稿を収集しました。リアクション処理を開始します。"), len(activities))
+	}
+	status.setPhase("reacting")
+	reactedURLs := reactToActivities(ctx, activities)
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// collectFollowerActivities はフォロワー一覧の新しい順にプロフィールページを開き、最新の活動日記を最大 maxFollowers 件集める。
+// 投稿のないフォロワー、exclude_authors に該当するフォロワー、cooldown の間にリアクションしたフォロワー、
+// 履歴または投稿ページでリアクション済みの投稿は除く
+func collectFollowerActivities(ctx context.Context, userID int64, maxFollowers int, cooldown time.Duration) ([]ActivityInfo, error) {
+	followers, err := collectFollowers(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf(tr("フォロワー一覧の取得に失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d人のフォロワーを確認しました。"), len(followers))
+
+	var activities []ActivityInfo
+	cooling := 0
+	for _, id := range followers {
+		if len(activities) >= maxFollowers {
+			break
+		}
+		if ctx.Err() != nil {
+			return activities, ctx.Err()
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("最大実行時間に達したため、URLの収集を終了します。"))
+			break
+		}
+		if reason := config.ExcludeAuthors.match(User{ID: id}); reason != "" {
+			loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) の投稿をスキップします (%s)"), id, reason)
+			continue
+		}
+		if last, ok := history.lastReactionTo(id); ok && cooldown > 0 && time.Since(last) < cooldown {
+			cooling++
+			continue
+		}
+		url, err := latestActivityURL(ctx, id)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("フォロワー (ID: %d) の最新の投稿の取得に失敗しました: %v"), id, err)
+			continue
+		}
+		status.markStep()
+		if url == "" {
+			continue
+		}
+		if history.hasReacted(url) {
+			loggerFromContext(ctx).Printf(tr("履歴でリアクション済みのためスキップします: %s"), url)
+			continue
+		}
+		// 履歴がない場合も、リアクション済みの投稿で上限の人数を使い切って毎回同じフォロワーで止まらないよう、
+		// 集める時点で投稿ページを開いて確かめる
+		if err := openPost(ctx, driverFromContext(ctx), url); err != nil {
+			loggerFromContext(ctx).Printf(tr("フォロワー (ID: %d) の最新の投稿を開けませんでした: %v"), id, err)
+			continue
+		}
+		if viewerHasReacted(ctx, driverFromContext(ctx), postPageOf(url)) {
+			loggerFromContext(ctx).Printf(tr("投稿ページでリアクション済みのためスキップします: %s"), url)
+			continue
+		}
+		info := ActivityInfo{URL: url, AuthorID: id}
+		if !hooksFromContext(ctx).collected(ctx, info) {
+			continue
+		}
+		activities = append(activities, info)
+		loggerFromContext(ctx).Printf(tr("投稿URLを発見: %s (現在 %d 件)"), url, len(activities))
+		events.publish("collected", url, "", "")
+		pace.wait(ctx)
+	}
+	if cooling > 0 {
+		loggerFromContext(ctx).Printf(tr("フォロワーごとのリアクション間隔 (%s) により %d 人のフォロワーをスキップしました。"), formatDays(cooldown), cooling)
+	}
+	return activities, nil
+}
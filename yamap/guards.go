@@ -0,0 +1,231 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultModalDismissSelectors はクッキー同意バナーやキャンペーンのポップアップを閉じるボタンの既定のセレクタ。
+// 誤って本文中のボタンを押さないよう、ダイアログ内の閉じるボタンに限定している。
+var defaultModalDismissSelectors = []string{
+	`[role="dialog"] ` + strings.ReplaceAll(labelSelector("button", "aria-label", "close"), ", ", `, [role="dialog"] `),
+	`#onetrust-accept-btn-handler`,
+	`.cookie-consent button`,
+}
+
+// modalDismissSelectors は閉じるボタンのセレクタ一覧を返す。
+// 環境変数 MODAL_DISMISS_SELECTORS が設定されていればセミコロン区切りで読み込み、空文字の場合は無効化する。
+func modalDismissSelectors() []string {
+	v, ok := os.LookupEnv("MODAL_DISMISS_SELECTORS")
+	if !ok {
+		return defaultModalDismissSelectors
+	}
+	var sels []string
+	for _, sel := range strings.Split(v, ";") {
+		if sel = strings.TrimSpace(sel); sel != "" {
+			sels = append(sels, sel)
+		}
+	}
+	return sels
+}
+
+// guardedDriver はページ遷移の後にサイトの状態確認とモーダルのクローズを、クリックの前にモーダルのクローズを挟む pageDriver のラッパー
+type guardedDriver struct {
+	pageDriver
+	dismissScript string
+}
+
+// withPageGuards は drv をページの状態確認とモーダルの自動クローズ付きのドライバでラップする
+func withPageGuards(drv pageDriver) pageDriver {
+	g := guardedDriver{pageDriver: drv}
+	sels := modalDismissSelectors()
+	if len(sels) == 0 {
+		return g
+	}
+	encoded, _ := json.Marshal(sels)
+	// 絵文字ピッカーもダイアログとして表示されるため、ピッカーが開いている間は何も閉じない
+	g.dismissScript = fmt.Sprintf(`(() => {
+		if (document.querySelector(".emojiPickerBody")) return 0;
+		let closed = 0;
+		for (const sel of %s) {
+			let els;
+			try { els = document.querySelectorAll(sel); } catch (e) { continue; }
+			for (const el of els) {
+				const rect = el.getBoundingClientRect();
+				if (rect.width > 0 && rect.height > 0) { el.click(); closed++; }
+			}
+		}
+		return closed;
+	})()`, encoded)
+	return g
+}
+
+// dismissModals は表示中のモーダルを閉じる。失敗しても本来の操作を妨げないようエラーは無視する
+func (d guardedDriver) dismissModals() browserAction {
+	return func(ctx context.Context) error {
+		if d.dismissScript == "" {
+			return nil
+		}
+		var closed int
+		if err := d.pageDriver.Evaluate(d.dismissScript, &closed)(ctx); err == nil && closed > 0 {
+			loggerFromContext(ctx).Printf(tr("表示されていたモーダルを %d 件閉じました。"), closed)
+		}
+		return nil
+	}
+}
+
+// checkPage は表示中のページがメンテナンス画面やアカウント制限の警告でないかを確認する。
+// 該当した場合は以降の操作を止めるため実行全体を中止し、エラーを返す。
+func (d guardedDriver) checkPage() browserAction {
+	return func(ctx context.Context) error {
+		var state string
+		if err := d.pageDriver.Evaluate(pageStateScript, &state)(ctx); err != nil || state == "" {
+			return nil
+		}
+		var pageURL string
+		d.pageDriver.Evaluate(`window.location.href`, &pageURL)(ctx)
+		action := status.report().Action
+
+		switch state {
+		case "maintenance":
+			loggerFromContext(ctx).Printf(tr("YAMAPのメンテナンス画面を検出しました (%s)。処理を中止します。"), pageURL)
+			notify(ctx, "WARN", fmt.Sprintf(tr("YAMAPがメンテナンス中のため、%s の実行を中止しました。"), action))
+			status.abort(errSiteMaintenance)
+			return errSiteMaintenance
+		case "rate-limited":
+			return coolDownAfterRateLimit(ctx, pageURL)
+		case "restricted":
+			loggerFromContext(ctx).Printf(tr("アカウントの警告・利用制限の表示を検出しました (%s)。直ちに全ての操作を停止します。"), pageURL)
+			// 中止するとコンテキストがキャンセルされるため、先に証拠を保存する
+			saveDebugSnapshot(ctx, d.pageDriver, "account_restricted")
+			notify(ctx, "ALERT", fmt.Sprintf(tr("アカウントの警告・利用制限を検出したため、%s の実行を中止しました (%s)。スケジュール実行を停止してください。"), action, pageURL))
+			status.abort(errAccountRestricted)
+			return errAccountRestricted
+		}
+		return nil
+	}
+}
+
+func (d guardedDriver) Navigate(url string) browserAction {
+	return func(ctx context.Context) error {
+		return runActions(ctx, d.pageDriver.Navigate(url), d.checkPage(), d.dismissModals())
+	}
+}
+
+func (d guardedDriver) Reload() browserAction {
+	return func(ctx context.Context) error {
+		return runActions(ctx, d.pageDriver.Reload(), d.checkPage(), d.dismissModals())
+	}
+}
+
+func (d guardedDriver) Click(sel string) browserAction {
+	return func(ctx context.Context) error {
+		// 警告はリアクション送信などの操作の直後に表示されることもあるため、クリック後にも確認する
+		return runActions(ctx, d.dismissModals(), d.pageDriver.Click(sel), d.checkPage())
+	}
+}
+
+// defaultRateLimitCooldown はアクセス過多の表示を検出したときに一時停止する時間の既定値
+const defaultRateLimitCooldown = 15 * time.Minute
+
+// coolDownAfterRateLimit はアクセス過多の表示を検出したときに、RATE_LIMIT_COOLDOWN (既定値15分) の間、実行全体を一時停止する。
+// 試行ごとのタイムアウトで待機が打ち切られないよう、実行全体のコンテキストで待つ。
+// 表示していたページの操作は失敗として扱うため、待機の後に errRateLimited を返す
+func coolDownAfterRateLimit(ctx context.Context, pageURL string) error {
+	cooldown := defaultRateLimitCooldown
+	if v := os.Getenv("RATE_LIMIT_COOLDOWN"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cooldown = d
+		} else {
+			loggerFromContext(ctx).Printf(tr("警告: RATE_LIMIT_COOLDOWNの値が不正です。既定値 %s を使用します: %s"), defaultRateLimitCooldown, v)
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("YAMAPのアクセス過多の表示を検出しました (%s)。%s の間、実行全体を一時停止します。"), pageURL, cooldown)
+	notify(ctx, "WARN", fmt.Sprintf(tr("YAMAPのアクセス過多の表示を検出したため、%s の実行を %s の間一時停止します。"), status.report().Action, cooldown))
+
+	status.mu.Lock()
+	runCtx := status.browserCtx
+	status.mu.Unlock()
+	if runCtx == nil {
+		runCtx = ctx
+	}
+	start := time.Now()
+	timer := time.NewTimer(cooldown)
+	defer timer.Stop()
+	// 一時停止中もヘルスチェックが停滞とみなさないよう、1分ごとに前進を記録する
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-runCtx.Done():
+			break wait
+		case <-ticker.C:
+			status.markStep()
+		case <-timer.C:
+			break wait
+		}
+	}
+	status.recordRateLimit(pageURL, time.Since(start))
+	if err := runCtx.Err(); err != nil {
+		return err
+	}
+	loggerFromContext(ctx).Println(tr("一時停止を終了し、処理を再開します。"))
+	return errRateLimited
+}
+
+// maintenancePhrases はメンテナンス画面に表示される文言
+var maintenancePhrases = []string{"メンテナンス中", "メンテナンスを実施", "under maintenance", "scheduled maintenance"}
+
+// rateLimitPhrases はアクセスが多すぎる場合のトーストやエラーの表示に含まれる文言
+var rateLimitPhrases = []string{"アクセスが集中", "リクエストが多すぎ", "リクエスト数が上限", "操作が多すぎ", "too many requests", "rate limit", "slow down"}
+
+// restrictionPhrases はアカウントへの警告や利用制限の通知に表示される文言
+var restrictionPhrases = []string{"不審なアクティビティ", "不審な操作", "アカウントが制限", "利用を制限", "利用制限", "一時的に制限", "unusual activity", "account has been restricted", "account is restricted", "temporarily restricted"}
+
+// pageStateScript はページがメンテナンス画面なら "maintenance"、アカウントへの警告・制限の表示があれば "restricted"、
+// アクセス過多の表示があれば "rate-limited"、いずれでもなければ空文字を返すスクリプト。
+// 投稿本文の文言に反応しないよう、ページ全体ではなくタイトル・見出し・アラートやダイアログのみを対象とする。
+var pageStateScript = func() string {
+	maintenance, _ := json.Marshal(maintenancePhrases)
+	restriction, _ := json.Marshal(restrictionPhrases)
+	rateLimit, _ := json.Marshal(rateLimitPhrases)
+	return fmt.Sprintf(`(() => {
+		const collect = (sel) => Array.from(document.querySelectorAll(sel)).map(e => (e.textContent || "").toLowerCase());
+		const includesAny = (texts, phrases) => phrases.some(p => texts.some(t => t.includes(p.toLowerCase())));
+		const headings = [(document.title || "").toLowerCase(), ...collect("h1, h2")];
+		if (includesAny(headings, %s)) return "maintenance";
+		const notices = collect('[role="alert"], [role="alertdialog"], [role="dialog"], [class*="Toast"], [class*="toast"], [class*="Banner"], [class*="banner"]');
+		if (includesAny(headings.concat(notices), %s)) return "restricted";
+		if (includesAny(headings.concat(notices), %s)) return "rate-limited";
+		return "";
+	})()`, maintenance, restriction, rateLimit)
+}()
+
+// errSiteMaintenance はYAMAPがメンテナンス中であることを表す
+var errSiteMaintenance error = messageError("YAMAPがメンテナンス中です")
+
+// errRateLimited はアクセス過多の表示により操作が完了しなかったことを表す
+var errRateLimited error = messageError("YAMAPのアクセス過多の表示により操作を中断しました")
+
+// errAccountRestricted はアカウントへの警告や利用制限が表示されたことを表す
+var errAccountRestricted error = messageError("アカウントへの警告・利用制限が表示されています")
+
+// errCircuitBreakerOpen はリアクションの失敗が続いたため実行を中止したことを表す
+var errCircuitBreakerOpen error = messageError("リアクションの失敗が続いています")
+
+// 実行を中止した理由ごとの終了コード。スケジューラーから理由を判別できるようにする
+const (
+	exitCodeMaintenance       = 10
+	exitCodeAccountRestricted = 11
+	exitCodePanic             = 12
+	exitCodeBadCredentials    = 13
+	exitCodeLoginChallenge    = 14
+	exitCodeLoginNetwork      = 15
+	exitCodeHistoryLocked     = 16
+	exitCodeCircuitBreaker    = 17
+)
@@ -0,0 +1,274 @@
+package yamap
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// harLog は -har で指定されたHARファイルへの記録。-har が未指定の場合は nil
+var harLog *harRecorder
+
+// harBodyLimit はHARに本文を含めるレスポンスの最大サイズ
+const harBodyLimit = 1 << 20
+
+// harRedactedHeaders は認証情報を含むため、HARに値を残さないヘッダー (小文字)
+var harRedactedHeaders = map[string]bool{"cookie": true, "set-cookie": true, "authorization": true}
+
+// harRecorder はCDPのNetworkドメインのイベントから、通信の記録をHAR 1.2形式で蓄積する。
+// ログインのフォームの送信内容を残さないよう、リクエストの本文は記録しない
+type harRecorder struct {
+	mu      sync.Mutex
+	path    string
+	entries []*harEntry
+	// pending はリクエストIDごとの記録中のエントリ
+	pending map[network.RequestID]*harEntry
+	saved   bool
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	ResourceType    string      `json:"_resourceType,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+	// sentAt, receivedAt はCDPのタイムスタンプ (所要時間の計算用)
+	sentAt, receivedAt time.Time
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	Cookies     []harNameValue `json:"cookies"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int64          `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []harNameValue `json:"headers"`
+	Cookies     []harNameValue `json:"cookies"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func newHARRecorder(path string) *harRecorder {
+	return &harRecorder{path: path, pending: make(map[network.RequestID]*harEntry)}
+}
+
+// harHeaders はCDPのヘッダーをHARの形式に変換する。認証情報を含むヘッダーは値を伏せる
+func harHeaders(headers network.Headers) []harNameValue {
+	list := []harNameValue{}
+	for name, value := range headers {
+		v := fmt.Sprint(value)
+		if harRedactedHeaders[strings.ToLower(name)] {
+			v = "(redacted)"
+		}
+		list = append(list, harNameValue{Name: name, Value: v})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// harMillis は2つの時刻の差をHARのミリ秒に変換する
+func harMillis(from, to time.Time) float64 {
+	return float64(to.Sub(from).Microseconds()) / 1000
+}
+
+// setResponse はレスポンスの情報をエントリに記録する
+func (e *harEntry) setResponse(r *network.Response, at time.Time) {
+	e.Request.HTTPVersion = r.Protocol
+	e.Response.Status = r.Status
+	e.Response.StatusText = r.StatusText
+	e.Response.HTTPVersion = r.Protocol
+	e.Response.Headers = harHeaders(r.Headers)
+	e.Response.Content.MimeType = r.MimeType
+	for name, value := range r.Headers {
+		if strings.EqualFold(name, "Location") {
+			e.Response.RedirectURL = fmt.Sprint(value)
+		}
+	}
+	e.receivedAt = at
+	e.Timings.Wait = harMillis(e.sentAt, at)
+}
+
+// finish はエントリの所要時間を確定させる
+func (e *harEntry) finish(at time.Time) {
+	if e.receivedAt.IsZero() {
+		e.receivedAt = at
+	}
+	e.Timings.Receive = harMillis(e.receivedAt, at)
+	e.Time = harMillis(e.sentAt, at)
+}
+
+// handle はNetworkドメインのイベントを受け取り、エントリを更新する。ctx はイベントを受け取ったタブのコンテキストで、
+// XHR・fetchのレスポンスの本文を取得するために使う
+func (r *harRecorder) handle(ctx context.Context, ev interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		at := ev.Timestamp.Time()
+		if prev, ok := r.pending[ev.RequestID]; ok && ev.RedirectResponse != nil {
+			// リダイレクトは同じリクエストIDで続くため、リダイレクトの応答で前のエントリを閉じる
+			prev.setResponse(ev.RedirectResponse, at)
+			prev.finish(at)
+		}
+		e := &harEntry{
+			StartedDateTime: ev.WallTime.Time().Format(time.RFC3339Nano),
+			ResourceType:    string(ev.Type),
+			sentAt:          at,
+			Request: harRequest{
+				Method:      ev.Request.Method,
+				URL:         ev.Request.URL,
+				HTTPVersion: "HTTP/1.1",
+				Headers:     harHeaders(ev.Request.Headers),
+				QueryString: []harNameValue{},
+				Cookies:     []harNameValue{},
+				HeadersSize: -1,
+			},
+			Response: harResponse{Headers: []harNameValue{}, Cookies: []harNameValue{}, HeadersSize: -1, BodySize: -1},
+		}
+		if ev.Request.HasPostData {
+			e.Request.BodySize = -1
+		}
+		if u, err := neturl.Parse(ev.Request.URL); err == nil {
+			for name, values := range u.Query() {
+				for _, v := range values {
+					e.Request.QueryString = append(e.Request.QueryString, harNameValue{Name: name, Value: v})
+				}
+			}
+		}
+		r.pending[ev.RequestID] = e
+		r.entries = append(r.entries, e)
+	case *network.EventResponseReceived:
+		if e, ok := r.pending[ev.RequestID]; ok {
+			e.setResponse(ev.Response, ev.Timestamp.Time())
+		}
+	case *network.EventLoadingFinished:
+		e, ok := r.pending[ev.RequestID]
+		if !ok {
+			return
+		}
+		delete(r.pending, ev.RequestID)
+		e.finish(ev.Timestamp.Time())
+		e.Response.BodySize = int64(ev.EncodedDataLength)
+		e.Response.Content.Size = int64(ev.EncodedDataLength)
+		apiCall := e.ResourceType == string(network.ResourceTypeXHR) || e.ResourceType == string(network.ResourceTypeFetch)
+		if apiCall && ev.EncodedDataLength <= harBodyLimit {
+			// イベントのリスナーの中ではCDPのコマンドの応答を待てないため、別のゴルーチンで取得する
+			go r.fetchBody(ctx, ev.RequestID, e)
+		}
+	case *network.EventLoadingFailed:
+		e, ok := r.pending[ev.RequestID]
+		if !ok {
+			return
+		}
+		delete(r.pending, ev.RequestID)
+		e.finish(ev.Timestamp.Time())
+		e.Error = ev.ErrorText
+		if ev.BlockedReason != "" {
+			e.Error += " (" + string(ev.BlockedReason) + ")"
+		}
+	}
+}
+
+// fetchBody はAPIのレスポンスの本文を取得してエントリに加える。テキストでない本文はBase64で記録する
+func (r *harRecorder) fetchBody(ctx context.Context, id network.RequestID, e *harEntry) {
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Target == nil {
+		return
+	}
+	bodyCtx, cancel := context.WithTimeout(cdp.WithExecutor(ctx, c.Target), 10*time.Second)
+	defer cancel()
+	body, err := network.GetResponseBody(id).Do(bodyCtx)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if utf8.Valid(body) {
+		e.Response.Content.Text = string(body)
+	} else {
+		e.Response.Content.Text = base64.StdEncoding.EncodeToString(body)
+		e.Response.Content.Encoding = "base64"
+	}
+}
+
+// save は記録した通信をHARファイルに書き出す。複数回呼ばれても書き出すのは最初の1回だけ
+func (r *harRecorder) save() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.saved {
+		return
+	}
+	r.saved = true
+	doc := map[string]interface{}{
+		"log": map[string]interface{}{
+			"version": "1.2",
+			"creator": map[string]string{"name": "yamap-auto-domo", "version": "1.0"},
+			"pages":   []interface{}{},
+			"entries": r.entries,
+		},
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		log.Printf(tr("HARの作成に失敗しました: %v"), err)
+		return
+	}
+	// URLのクエリやAPIのレスポンスに含まれるメールアドレスなどを伏せる
+	data = []byte(redaction.redact(string(data)))
+	path := accountFilePath(r.path)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		log.Printf(tr("HARの保存に失敗しました: %v"), err)
+		return
+	}
+	log.Printf(tr("%d件の通信を記録したHARを %s に保存しました。"), len(r.entries), path)
+}
@@ -0,0 +1,678 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// historyEntry はリアクション履歴の1件分
+type historyEntry struct {
+	URL        string `json:"url"`
+	AuthorID   int64  `json:"author_id,omitempty"`
+	AuthorName string `json:"author_name,omitempty"`
+	Title      string `json:"title,omitempty"`
+	// Action はリアクションを送ったアクション (react-timeline など)
+	Action string `json:"action,omitempty"`
+	// PostedAt は投稿日時。取得できなかった場合は記録しない
+	PostedAt time.Time `json:"posted_at,omitzero"`
+	// Emoji は送った絵文字のラベル。ピッカーから取得できなかった場合は空
+	Emoji     string    `json:"emoji,omitempty"`
+	ReactedAt time.Time `json:"reacted_at"`
+	// Variant は A/B 比較でこの投稿に割り当てた戦略の名前
+	Variant string `json:"variant,omitempty"`
+	// Domo はこのリアクションで贈ったDOMOの量 (DOMO_PER_REACTION)
+	Domo int `json:"domo,omitempty"`
+	// Screenshot は AUDIT_SCREENSHOT_DIR に保存したリアクション直後のスクリーンショットのパス
+	Screenshot string `json:"screenshot,omitempty"`
+}
+
+// runRecord は1回の実行の結果。ダッシュボードで過去の実行や失敗率を表示するために履歴に保存する
+type runRecord struct {
+	// Build は実行したバイナリのビルド情報と起動したブラウザのバージョン
+	Build *buildInfo `json:"build,omitempty"`
+	// RunID は成果物のアップロード先のキーに含まれる実行ID
+	RunID      string    `json:"run_id,omitempty"`
+	Action     string    `json:"action"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Processed  int       `json:"processed"`
+	Succeeded  int       `json:"succeeded"`
+	Failed     int       `json:"failed"`
+	Skipped    int       `json:"skipped"`
+	// Aborted はメンテナンスなどにより実行を中止した場合の理由
+	Aborted string `json:"aborted,omitempty"`
+	// StoppedBy は新しい投稿の処理を終えた条件 (kill-switch, max-runtime, stop_when の条件など)
+	StoppedBy string `json:"stopped_by,omitempty"`
+	// Feed はタイムラインの収集で読み込んだフィードの内訳
+	Feed *feedStats `json:"feed,omitempty"`
+	// RateLimited はYAMAPのアクセス過多の表示を検出して一時停止した記録。検出しなかった場合は nil
+	RateLimited *rateLimitStats `json:"rate_limited,omitempty"`
+	// LoginSeconds はログインにかかった秒数。ログインしていない場合は 0
+	LoginSeconds float64 `json:"login_seconds,omitempty"`
+}
+
+// rateLimitStats はアクセス過多の表示を検出して一時停止した回数と時間
+type rateLimitStats struct {
+	Detections int `json:"detections"`
+	// Paused は一時停止した時間の合計 (例: 30m0s)
+	Paused string `json:"paused"`
+	// URLs は検出したときに表示していたページ
+	URLs   []string  `json:"urls,omitempty"`
+	LastAt time.Time `json:"last_at"`
+
+	paused time.Duration
+}
+
+// historyStore は実行をまたいで保持するリアクション履歴。HISTORY_FILE のJSONファイルか、HISTORY_DATABASE_URL のPostgreSQLに保存される
+type historyStore struct {
+	mu      sync.Mutex
+	backend historyBackend
+	Entries []historyEntry `json:"entries"`
+	// Followers は thank-followers が前回までに確認したフォロワーのID。nil の場合はまだ一度も確認していない
+	Followers []int64 `json:"followers,omitempty"`
+	// ThankedFollowers はお礼を送ったフォロワーのIDと日時
+	ThankedFollowers map[int64]time.Time `json:"thanked_followers,omitempty"`
+	// Runs は過去の実行の結果
+	Runs []runRecord `json:"runs,omitempty"`
+	// Followed はフォローしたユーザー (または既にフォロー中だったユーザー) のIDと日時
+	Followed map[int64]time.Time `json:"followed,omitempty"`
+	// Snapshots は snapshot で記録したフォロワー数・フォロー数・DOMOの残高 (古い順)
+	Snapshots []accountSnapshot `json:"snapshots,omitempty"`
+	// Announced は crosspost でSNSに紹介した (または初回の実行で紹介済みとした) 自分の活動日記のIDと日時
+	Announced map[int64]time.Time `json:"announced,omitempty"`
+	// StravaSynced は sync-strava でStravaにアップロードした自分の活動日記のIDと、StravaのアクティビティのID。
+	// 初回の実行で同期済みとした活動日記は 0
+	StravaSynced map[int64]int64 `json:"strava_synced,omitempty"`
+	// WatchSources は watch で活動日記の一覧を初めて確認した山・ランドマーク (例: mountains/123) と日時
+	WatchSources map[string]time.Time `json:"watch_sources,omitempty"`
+	// WatchSeen は watch で確認済みとした活動日記のIDと日時
+	WatchSeen map[int64]time.Time `json:"watch_seen,omitempty"`
+	// RetryQueue はリアクションに失敗し、次回以降の実行で先に再試行する投稿
+	RetryQueue []retryEntry `json:"retry_queue,omitempty"`
+}
+
+// history は HISTORY_FILE か HISTORY_DATABASE_URL が設定されている場合に読み込まれるリアクション履歴。未設定の場合は nil
+var history *historyStore
+
+// loadHistory はリアクション履歴を読み込む。まだ保存されていない場合は空の履歴を返す
+func loadHistory(backend historyBackend) (*historyStore, error) {
+	h := &historyStore{backend: backend}
+	data, err := backend.load()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return h, nil
+	}
+	if err := json.Unmarshal(data, h); err != nil {
+		return nil, fmt.Errorf(tr("履歴ファイルの形式が不正です: %w"), err)
+	}
+	return h, nil
+}
+
+// recordReaction はリアクションの成功を今回の実行の結果と履歴に記録する。
+// 収集の方法によっては投稿者やタイトルがわからないため、不足している情報は表示中の投稿ページから補う
+func recordReaction(ctx context.Context, activity ActivityInfo, emoji string) {
+	if activity.AuthorID == 0 || activity.AuthorName == "" || activity.Title == "" || activity.PostedAt.IsZero() {
+		completeActivityInfo(ctx, &activity)
+	}
+	e := historyEntry{
+		URL:        activity.URL,
+		AuthorID:   activity.AuthorID,
+		AuthorName: activity.AuthorName,
+		Title:      activity.Title,
+		PostedAt:   activity.PostedAt,
+		Action:     status.report().Action,
+		Emoji:      emoji,
+		ReactedAt:  time.Now(),
+		Domo:       loadDomoBudget(ctx).perReaction,
+		Variant:    activity.Variant,
+	}
+	e.Screenshot = saveAuditScreenshot(ctx, e)
+	status.addReaction(e)
+	if err := history.record(e); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: リアクション履歴の保存に失敗しました: %v"), err)
+	}
+}
+
+// completeActivityInfo は表示中の投稿ページから、activity に不足している投稿者・タイトル・投稿日時を補う。
+// 取得に失敗しても記録は続けられるため、エラーは無視する
+func completeActivityInfo(ctx context.Context, activity *ActivityInfo) {
+	metaCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	meta, err := fetchActivityMetadata(metaCtx, driverFromContext(ctx))
+	if err != nil {
+		return
+	}
+	if activity.AuthorID == 0 {
+		activity.AuthorID = meta.AuthorID
+	}
+	if activity.AuthorName == "" {
+		activity.AuthorName = meta.Author
+	}
+	if activity.Title == "" {
+		activity.Title = meta.Title
+	}
+	if activity.PostedAt.IsZero() {
+		activity.PostedAt = meta.postedAt()
+	}
+}
+
+// saveAuditScreenshot は AUDIT_SCREENSHOT_DIR が設定されている場合に、リアクションした投稿の表示領域のスクリーンショットを保存し、
+// そのパスを返す。後から何にリアクションしたかを確認するためのもので、失敗してもリアクションの記録は続ける
+func saveAuditScreenshot(ctx context.Context, e historyEntry) string {
+	dir := os.Getenv("AUDIT_SCREENSHOT_DIR")
+	if dir == "" {
+		return ""
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: AUDIT_SCREENSHOT_DIR を作成できません: %v"), err)
+		return ""
+	}
+	var buf []byte
+	shotCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := runActions(shotCtx, driverFromContext(ctx).ViewportScreenshot(&buf)); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 監査用のスクリーンショットの取得に失敗しました (%s): %v"), e.URL, err)
+		return ""
+	}
+	path := filepath.Join(dir, e.ReactedAt.Format("20060102-150405")+"_"+filepath.Base(strings.TrimSuffix(e.URL, "/"))+".png")
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 監査用のスクリーンショットの保存に失敗しました: %v"), err)
+		return ""
+	}
+	return path
+}
+
+// record はリアクションを履歴に追加してファイルに保存する。履歴が無効な場合は何もしない
+func (h *historyStore) record(e historyEntry) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Entries = append(h.Entries, e)
+	return h.save()
+}
+
+// hasReacted は投稿にリアクションした記録が履歴にあるかを返す。履歴が無効な場合は false
+func (h *historyStore) hasReacted(url string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range h.Entries {
+		if e.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// hasFollowed はユーザーをフォローした記録が履歴にあるかを返す。履歴が無効な場合は false
+func (h *historyStore) hasFollowed(id int64) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.Followed[id]
+	return ok
+}
+
+// markFollowed はユーザーをフォローしたことを記録してファイルに保存する。履歴が無効な場合は何もしない
+func (h *historyStore) markFollowed(id int64, at time.Time) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Followed == nil {
+		h.Followed = make(map[int64]time.Time)
+	}
+	h.Followed[id] = at
+	return h.save()
+}
+
+// forget はリアクションを取り消した投稿を履歴から削除してファイルに保存する。以降の実行では再びリアクションの対象になる
+func (h *historyStore) forget(url string) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	kept := h.Entries[:0]
+	for _, e := range h.Entries {
+		if e.URL != url {
+			kept = append(kept, e)
+		}
+	}
+	h.Entries = kept
+	return h.save()
+}
+
+// matching は絞り込み条件に一致するリアクションを、投稿ごとに最新の1件にまとめて古い順に返す
+func (h *historyStore) matching(f historyFilter) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	index := make(map[string]int)
+	var entries []historyEntry
+	for _, e := range h.Entries {
+		if !f.matches(e) {
+			continue
+		}
+		if i, ok := index[e.URL]; ok {
+			entries[i] = e
+			continue
+		}
+		index[e.URL] = len(entries)
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// recordRun は実行の結果を履歴に追加してファイルに保存する。履歴が無効な場合は何もしない
+func (h *historyStore) recordRun(run runRecord) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Runs = append(h.Runs, run)
+	return h.save()
+}
+
+// save は履歴を保存先に書き出す
+func (h *historyStore) save() error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return h.backend.save(data)
+}
+
+// historyLockInfo はロックファイルに書き込む、ロックを持つプロセスの情報
+type historyLockInfo struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Action    string    `json:"action"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// historyLock は HISTORY_FILE を書き換えるプロセスを1つに限るためのロック。<HISTORY_FILE>.lock を排他的に作成して取得する。
+// data はロックのファイルに書いた内容で、解放するときに自分のロックかを確かめるのに使う
+type historyLock struct {
+	path string
+	data []byte
+}
+
+// ownHistoryLocks はこのプロセスが取得中のロックのファイルのパス。ロックを持つPIDが自分と同じ場合に、
+// 自分が取得中のロックか、同じPIDだった以前のプロセス (コンテナの再起動など) が残したロックかを見分けるのに使う
+var ownHistoryLocks sync.Map
+
+// heldHistoryLock は取得中の履歴のロック。取得していない場合は nil
+var heldHistoryLock historyLocker
+
+// historyLockTimeout は HISTORY_LOCK_TIMEOUT で指定された、履歴のロックの解放を待つ時間の上限
+var historyLockTimeout time.Duration
+
+// roundLockedActions は実行の間ずっとではなく、処理の区切りごとに withHistoryLock で履歴をロックするアクション。
+// 停止の指示まで動き続けるため、実行の間ロックを持つと同じ履歴を使う他のアクションがすべて終了コード 16 で終わってしまう
+var roundLockedActions = map[string]bool{"watch": true}
+
+// withHistoryLock は履歴のロックを取得し、ロックを持たない間に別のプロセスが保存した内容を読み込み直してから fn を実行する。
+// fn が終わるとロックを解放する。別のプロセスが使用中の場合は historyLockTimeout まで待ち、errHistoryLocked を含むエラーを返す
+func withHistoryLock(action string, fn func() error) error {
+	backend := history.backend
+	lock, err := backend.lock(action, historyLockTimeout)
+	if err != nil {
+		return err
+	}
+	heldHistoryLock = lock
+	defer func() {
+		heldHistoryLock = nil
+		lock.release()
+	}()
+	h, err := loadHistory(backend)
+	if err != nil {
+		return fmt.Errorf(tr("リアクション履歴の読み込みに失敗しました: %w"), err)
+	}
+	history = h
+	return fn()
+}
+
+// errHistoryLocked は履歴ファイルを別のプロセスが使用中であることを表す
+var errHistoryLocked error = messageError("履歴ファイルを別のプロセスが使用中です")
+
+// lockHistory は履歴ファイルのロックを取得する。別のプロセスが使用中の場合は timeout まで待ち、取得できなければ
+// ロックを持つプロセスの情報を含むエラーを返す。ロックを持つプロセスが同じホストで既に終了している場合は、残ったロックを取り除いて取得する
+func lockHistory(historyPath, action string, timeout time.Duration) (*historyLock, error) {
+	host, _ := os.Hostname()
+	data, _ := json.Marshal(historyLockInfo{PID: os.Getpid(), Host: host, Action: action, StartedAt: time.Now()})
+	l := &historyLock{path: historyPath + ".lock", data: data}
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = f.Write(data)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return nil, err
+			}
+			ownHistoryLocks.Store(l.path, true)
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		var holder historyLockInfo
+		raw, err := os.ReadFile(l.path)
+		if err == nil && json.Unmarshal(raw, &holder) == nil && holder.stale(l.path, host) {
+			if takeOverStaleLock(l.path, raw) {
+				log.Printf(tr("終了したプロセス (PID %d) の履歴のロックが残っていたため取り除きます。"), holder.PID)
+			}
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, holder.lockedError(l.path)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// stale はロックを持つプロセスが同じホストで既に終了しているかを返す。PIDが自分と同じでも、このプロセスが取得したロックでなければ、
+// 同じPIDを使っていた以前のプロセスが残したロックとみなす
+func (i historyLockInfo) stale(path, host string) bool {
+	if i.Host != host || i.PID <= 0 {
+		return false
+	}
+	if i.PID == os.Getpid() {
+		_, own := ownHistoryLocks.Load(path)
+		return !own
+	}
+	return !processAlive(i.PID)
+}
+
+// takeOverStaleLock は内容が stale のロックのファイルを取り除き、取り除いたかを返す。
+// 複数のプロセスが同時に同じロックを残骸と判断しても、一方が取り除いて取得し直したロックをもう一方が消さないよう、
+// いったん自分だけの名前に移してから内容を確かめ、stale と異なる場合は元の名前に戻す
+func takeOverStaleLock(path string, stale []byte) bool {
+	moved := fmt.Sprintf("%s.%d.%d.stale", path, os.Getpid(), time.Now().UnixNano())
+	if err := os.Rename(path, moved); err != nil {
+		// 別のプロセスが先に取り除いた
+		return false
+	}
+	if raw, err := os.ReadFile(moved); err == nil && bytes.Equal(raw, stale) {
+		os.Remove(moved)
+		return true
+	}
+	// 移したのは別のプロセスが取得し直したロックのため戻す。Link は戻すまでの間に作られたロックを上書きしない
+	if err := os.Link(moved, path); err == nil || errors.Is(err, os.ErrExist) {
+		os.Remove(moved)
+	} else {
+		os.Rename(moved, path)
+	}
+	return false
+}
+
+// lockedError はロックを持つプロセスの情報を含む errHistoryLocked を返す。情報を読み取れなかった場合は、確認する場所を示す
+func (i historyLockInfo) lockedError(where string) error {
+	if i.PID == 0 {
+		return fmt.Errorf(tr("%w (%s を確認してください)"), errHistoryLocked, where)
+	}
+	return fmt.Errorf(tr("%w (PID %d, ホスト %s, アクション %s, 開始 %s)。同時に実行しないようスケジュールを確認してください"),
+		errHistoryLocked, i.PID, i.Host, i.Action, i.StartedAt.Local().Format("2006-01-02 15:04:05"))
+}
+
+// release はロックを解放する。取得していない場合は何もしない。
+// ロックのファイルが別のプロセスのものに置き換わっている場合は取り除かない
+func (l *historyLock) release() {
+	if l == nil {
+		return
+	}
+	ownHistoryLocks.Delete(l.path)
+	if raw, err := os.ReadFile(l.path); err == nil && bytes.Equal(raw, l.data) {
+		os.Remove(l.path)
+	}
+}
+
+// writeFileAtomic は同じディレクトリの一時ファイルに書き込んでディスクに同期してから置き換える。
+// 書き込み中に中断されても、元のファイルか新しいファイルのどちらかが完全な状態で残る
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// knownFollowers は前回までに確認したフォロワーの集合を返す。一度も確認していない場合は nil
+func (h *historyStore) knownFollowers() map[int64]struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Followers == nil {
+		return nil
+	}
+	known := make(map[int64]struct{}, len(h.Followers))
+	for _, id := range h.Followers {
+		known[id] = struct{}{}
+	}
+	return known
+}
+
+// addFollowers は確認済みのフォロワーを追加して保存する
+func (h *historyStore) addFollowers(ids []int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Followers == nil {
+		h.Followers = []int64{}
+	}
+	h.Followers = append(h.Followers, ids...)
+	return h.save()
+}
+
+// addSnapshot はフォロワー数などのスナップショットを追加して保存する
+func (h *historyStore) addSnapshot(s accountSnapshot) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.Snapshots = append(h.Snapshots, s)
+	return h.save()
+}
+
+// latestSnapshot は最後に記録したスナップショットを返す
+func (h *historyStore) latestSnapshot() (accountSnapshot, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.Snapshots) == 0 {
+		return accountSnapshot{}, false
+	}
+	return h.Snapshots[len(h.Snapshots)-1], true
+}
+
+// latestFollowerList は diff-followers で最後に記録したフォロワー一覧と、その日時を返す
+func (h *historyStore) latestFollowerList() ([]int64, time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := len(h.Snapshots) - 1; i >= 0; i-- {
+		if s := h.Snapshots[i]; s.FollowerIDs != nil {
+			return s.FollowerIDs, s.At, true
+		}
+	}
+	return nil, time.Time{}, false
+}
+
+// hasAnnouncedAny は crosspost を一度でも実行したか (紹介済みの活動日記の記録があるか) を返す
+func (h *historyStore) hasAnnouncedAny() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Announced != nil
+}
+
+// isAnnounced は活動日記を紹介済みかを返す
+func (h *historyStore) isAnnounced(id int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.Announced[id]
+	return ok
+}
+
+// markAnnounced は活動日記を紹介済みとして記録して保存する
+func (h *historyStore) markAnnounced(ids []int64, at time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.Announced == nil {
+		h.Announced = make(map[int64]time.Time)
+	}
+	for _, id := range ids {
+		h.Announced[id] = at
+	}
+	return h.save()
+}
+
+// watchSeenRetention は watch で確認済みとした活動日記を履歴に残す期間。一覧の先頭から外れた古い活動日記の記録は不要になる
+const watchSeenRetention = 90 * 24 * time.Hour
+
+// isWatchSource は watch でその山・ランドマークの一覧を確認したことがあるかを返す
+func (h *historyStore) isWatchSource(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.WatchSources[key]
+	return ok
+}
+
+// isWatchSeen は watch で活動日記を確認済みかを返す
+func (h *historyStore) isWatchSeen(id int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.WatchSeen[id]
+	return ok
+}
+
+// markWatchSeen は山・ランドマーク source の一覧を確認済みとし、活動日記を確認済みとして記録して保存する。
+// watchSeenRetention より前に確認した活動日記の記録は削除する
+func (h *historyStore) markWatchSeen(source string, ids []int64, at time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.WatchSources == nil {
+		h.WatchSources = make(map[string]time.Time)
+	}
+	if _, ok := h.WatchSources[source]; !ok {
+		h.WatchSources[source] = at
+	}
+	if h.WatchSeen == nil {
+		h.WatchSeen = make(map[int64]time.Time)
+	}
+	for id, seen := range h.WatchSeen {
+		if at.Sub(seen) > watchSeenRetention {
+			delete(h.WatchSeen, id)
+		}
+	}
+	for _, id := range ids {
+		h.WatchSeen[id] = at
+	}
+	return h.save()
+}
+
+// hasStravaSyncedAny は sync-strava を一度でも実行したかを返す
+func (h *historyStore) hasStravaSyncedAny() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.StravaSynced != nil
+}
+
+// isStravaSynced は活動日記をStravaに同期済みかを返す
+func (h *historyStore) isStravaSynced(id int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.StravaSynced[id]
+	return ok
+}
+
+// markStravaSynced は活動日記をStravaのアクティビティ stravaID に同期済みとして記録して保存する
+func (h *historyStore) markStravaSynced(ids []int64, stravaID int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.StravaSynced == nil {
+		h.StravaSynced = make(map[int64]int64)
+	}
+	for _, id := range ids {
+		h.StravaSynced[id] = stravaID
+	}
+	return h.save()
+}
+
+// markThanked はフォロワーにお礼を送ったことを記録して保存する
+func (h *historyStore) markThanked(id int64, at time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ThankedFollowers == nil {
+		h.ThankedFollowers = make(map[int64]time.Time)
+	}
+	h.ThankedFollowers[id] = at
+	return h.save()
+}
+
+// lastReactionTo は指定した投稿者に最後にリアクションした日時を返す
+func (h *historyStore) lastReactionTo(authorID int64) (time.Time, bool) {
+	if h == nil {
+		return time.Time{}, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var last time.Time
+	for _, e := range h.Entries {
+		if e.AuthorID == authorID && e.ReactedAt.After(last) {
+			last = e.ReactedAt
+		}
+	}
+	return last, !last.IsZero()
+}
+
+// reactionsSince は履歴のうち since 以降のリアクションの件数を返す
+func (h *historyStore) reactionsSince(since time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, e := range h.Entries {
+		if !e.ReactedAt.Before(since) {
+			n++
+		}
+	}
+	return n
+}
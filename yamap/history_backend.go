@@ -0,0 +1,69 @@
+package yamap
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// historyBackend は履歴の保存先。HISTORY_FILE のJSONファイルか、HISTORY_DATABASE_URL のPostgreSQL
+type historyBackend interface {
+	// load は保存されている履歴のJSONを返す。まだ保存されていない場合は nil
+	load() ([]byte, error)
+	// save は履歴のJSONを保存する
+	save(data []byte) error
+	// lock は履歴を書き換えるプロセスを1つに限るためのロックを取得する。別のプロセスが使用中の場合は timeout まで待つ
+	lock(action string, timeout time.Duration) (historyLocker, error)
+	// String はログやダッシュボードに表示する保存先の名前
+	String() string
+}
+
+// historyLocker は取得した履歴のロック
+type historyLocker interface {
+	release()
+}
+
+// historyBackendFromEnv は環境変数から履歴の保存先を選ぶ。HISTORY_DATABASE_URL は HISTORY_FILE より優先され、
+// どちらも未設定の場合は nil を返す
+func historyBackendFromEnv() (historyBackend, error) {
+	if dsn := os.Getenv("HISTORY_DATABASE_URL"); dsn != "" {
+		key := os.Getenv("HISTORY_DATABASE_KEY")
+		if key == "" {
+			key = os.Getenv("YAMAP_ACCOUNT")
+		}
+		if key == "" {
+			key = "default"
+		}
+		return newPostgresHistory(dsn, key)
+	}
+	if path := os.Getenv("HISTORY_FILE"); path != "" {
+		return fileHistory{path: path}, nil
+	}
+	return nil, nil
+}
+
+// fileHistory はJSONファイルに保存する履歴
+type fileHistory struct {
+	path string
+}
+
+func (f fileHistory) load() ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// save は一時ファイルに書き出してから置き換えることで、書き込み途中での破損を防ぐ
+func (f fileHistory) save(data []byte) error {
+	return writeFileAtomic(f.path, data, 0o600)
+}
+
+func (f fileHistory) lock(action string, timeout time.Duration) (historyLocker, error) {
+	return lockHistory(f.path, action, timeout)
+}
+
+func (f fileHistory) String() string {
+	return f.path
+}
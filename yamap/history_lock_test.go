@@ -0,0 +1,159 @@
+package yamap
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// exitedPID は終了したプロセスのPIDを返す
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// writeLockInfo は履歴のロックのファイルを info の内容で作成し、書いた内容を返す
+func writeLockInfo(t *testing.T, path string, info historyLockInfo) []byte {
+	t.Helper()
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readLockInfo(t *testing.T, path string) historyLockInfo {
+	t.Helper()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info historyLockInfo
+	if err := json.Unmarshal(raw, &info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestProcessAlive(t *testing.T) {
+	if !processAlive(os.Getpid()) {
+		t.Error("processAlive(self) = false, want true")
+	}
+	if pid := exitedPID(t); processAlive(pid) {
+		t.Errorf("processAlive(%d) of an exited process = true, want false", pid)
+	}
+	if processAlive(0) || processAlive(-1) {
+		t.Error("processAlive of a non-positive PID = true, want false")
+	}
+}
+
+// TestLockHistoryStaleHolder は同じホストで終了したプロセスや、同じPIDだった以前のプロセスが残したロックを取り除いて取得し、
+// 生存しているプロセス・別のホストのプロセス・このプロセスが取得中のロックは取り除かないことを確かめる
+func TestLockHistoryStaleHolder(t *testing.T) {
+	host, _ := os.Hostname()
+	tests := []struct {
+		name   string
+		holder historyLockInfo
+		stale  bool
+	}{
+		{"exited process", historyLockInfo{PID: exitedPID(t), Host: host, Action: "react-timeline"}, true},
+		{"same PID from a previous process", historyLockInfo{PID: os.Getpid(), Host: host, Action: "react-timeline"}, true},
+		{"live process", historyLockInfo{PID: os.Getppid(), Host: host, Action: "react-timeline"}, false},
+		{"other host", historyLockInfo{PID: exitedPID(t), Host: host + ".other", Action: "react-timeline"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "history.json")
+			writeLockInfo(t, path+".lock", tt.holder)
+			l, err := lockHistory(path, "watch", 0)
+			if !tt.stale {
+				if !errors.Is(err, errHistoryLocked) {
+					t.Fatalf("lockHistory error = %v, want errHistoryLocked", err)
+				}
+				if got := readLockInfo(t, path+".lock"); got.PID != tt.holder.PID {
+					t.Errorf("lock holder = %d, want %d left in place", got.PID, tt.holder.PID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lockHistory: %v", err)
+			}
+			defer l.release()
+			if got := readLockInfo(t, path+".lock"); got.PID != os.Getpid() || got.Action != "watch" {
+				t.Errorf("lock holder = %+v, want this process running watch", got)
+			}
+		})
+	}
+}
+
+// TestLockHistoryHeldInProcess はこのプロセスが取得中のロックを、PIDが同じでも残骸とみなさないことを確かめる
+func TestLockHistoryHeldInProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	l, err := lockHistory(path, "client", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockHistory(path, "watch", 0); !errors.Is(err, errHistoryLocked) {
+		t.Errorf("second lockHistory error = %v, want errHistoryLocked", err)
+	}
+	l.release()
+	if _, err := os.Stat(path + ".lock"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock file after release: %v, want removed", err)
+	}
+	l, err = lockHistory(path, "watch", 0)
+	if err != nil {
+		t.Fatalf("lockHistory after release: %v", err)
+	}
+	l.release()
+}
+
+// TestTakeOverStaleLockRace は2つのプロセスが同じ残骸を同時に見つけた場合を再現し、
+// 先に取り除いたプロセスが取得し直したロックを、遅れたプロセスが取り除かないことを確かめる
+func TestTakeOverStaleLockRace(t *testing.T) {
+	host, _ := os.Hostname()
+	path := filepath.Join(t.TempDir(), "history.json")
+	stale := writeLockInfo(t, path+".lock", historyLockInfo{PID: exitedPID(t), Host: host, Action: "react-timeline", StartedAt: time.Now()})
+
+	// 先に見つけたプロセスが残骸を取り除いてロックを取得する
+	if !takeOverStaleLock(path+".lock", stale) {
+		t.Fatal("first takeover failed")
+	}
+	winner := writeLockInfo(t, path+".lock", historyLockInfo{PID: os.Getppid(), Host: host, Action: "react-moments", StartedAt: time.Now()})
+
+	// 同じ残骸を見つけていた遅れたプロセスは、取得し直されたロックを取り除かない
+	if takeOverStaleLock(path+".lock", stale) {
+		t.Error("second takeover removed the lock re-acquired by the first process")
+	}
+	if got, err := os.ReadFile(path + ".lock"); err != nil || string(got) != string(winner) {
+		t.Errorf("lock file = %s (%v), want the winner's lock %s", got, err, winner)
+	}
+	if matches, _ := filepath.Glob(path + ".lock.*"); len(matches) > 0 {
+		t.Errorf("leftover files: %q", matches)
+	}
+}
+
+// TestHistoryLockReleaseKeepsOthers は解放するときに、別のプロセスのものに置き換わったロックを取り除かないことを確かめる
+func TestHistoryLockReleaseKeepsOthers(t *testing.T) {
+	host, _ := os.Hostname()
+	path := filepath.Join(t.TempDir(), "history.json")
+	l, err := lockHistory(path, "watch", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := writeLockInfo(t, path+".lock", historyLockInfo{PID: os.Getppid(), Host: host, Action: "react-timeline"})
+	l.release()
+	if got, err := os.ReadFile(path + ".lock"); err != nil || string(got) != string(other) {
+		t.Errorf("lock file = %s (%v), want the other process's lock kept", got, err)
+	}
+}
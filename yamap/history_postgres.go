@@ -0,0 +1,166 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// postgresHistoryTable は履歴を保存するテーブル。key ごとに履歴のJSONを1行で持ち、locked_by はロックを持つプロセスの情報
+const postgresHistoryTable = `CREATE TABLE IF NOT EXISTS yamap_history (
+	key text PRIMARY KEY,
+	data jsonb,
+	locked_by jsonb,
+	updated_at timestamptz NOT NULL DEFAULT now()
+)`
+
+// postgresTimeout は接続と1回のクエリにかける時間の上限
+const postgresTimeout = 30 * time.Second
+
+// postgresHistory は複数のマシンで履歴 (リアクション・実行の結果、それに基づく上限の判定) を共有するための、PostgreSQLに保存する履歴。
+// 履歴は HISTORY_DATABASE_KEY (既定値はアカウント名、なければ default) ごとに分かれる。
+// 接続・認証 (SCRAM-SHA-256・MD5など)・TLS は pgx に任せる
+type postgresHistory struct {
+	mu     sync.Mutex
+	dsn    *neturl.URL
+	config *pgx.ConnConfig
+	key    string
+	conn   *pgx.Conn
+}
+
+func newPostgresHistory(dsn, key string) (*postgresHistory, error) {
+	u, err := neturl.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return nil, errors.New(tr("HISTORY_DATABASE_URLの値が不正です (postgres://ユーザー:パスワード@ホスト:5432/データベース の形式で指定してください)"))
+	}
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, errors.New(tr("HISTORY_DATABASE_URLの値が不正です (postgres://ユーザー:パスワード@ホスト:5432/データベース の形式で指定してください)"))
+	}
+	return &postgresHistory{dsn: u, config: config, key: key}, nil
+}
+
+// connect は新しい接続を開き、履歴のテーブルがなければ作成する
+func (p *postgresHistory) connect(ctx context.Context) (*pgx.Conn, error) {
+	c, err := pgx.ConnectConfig(ctx, p.config)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.Exec(ctx, postgresHistoryTable); err != nil {
+		c.Close(context.Background())
+		return nil, err
+	}
+	return c, nil
+}
+
+// withConn は読み書き用の接続で fn を実行する。接続が切れていれば繋ぎ直し、通信のエラーの後は次の呼び出しで繋ぎ直す
+func (p *postgresHistory) withConn(fn func(ctx context.Context, c *pgx.Conn) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	if p.conn == nil {
+		c, err := p.connect(ctx)
+		if err != nil {
+			return err
+		}
+		p.conn = c
+	}
+	err := fn(ctx, p.conn)
+	// サーバーが返したエラー (SQLの誤りなど) と行がない場合は、接続をそのまま使い続けられる
+	var pgErr *pgconn.PgError
+	if err != nil && !errors.As(err, &pgErr) && !errors.Is(err, pgx.ErrNoRows) {
+		p.conn.Close(context.Background())
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *postgresHistory) load() ([]byte, error) {
+	var data string
+	err := p.withConn(func(ctx context.Context, c *pgx.Conn) error {
+		return c.QueryRow(ctx, `SELECT data::text FROM yamap_history WHERE key = $1 AND data IS NOT NULL`, p.key).Scan(&data)
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (p *postgresHistory) save(data []byte) error {
+	return p.withConn(func(ctx context.Context, c *pgx.Conn) error {
+		_, err := c.Exec(ctx, `INSERT INTO yamap_history (key, data, updated_at) VALUES ($1, $2::jsonb, now())
+			ON CONFLICT (key) DO UPDATE SET data = EXCLUDED.data, updated_at = now()`, p.key, string(data))
+		return err
+	})
+}
+
+// lock はアドバイザリロックで履歴を占有する。ロックはセッションに結びつくため、専用の接続を実行の間開いたままにする。
+// プロセスが強制終了しても接続が切れた時点でサーバーが解放するため、ファイルのように残ったロックを取り除く必要はない
+func (p *postgresHistory) lock(action string, timeout time.Duration) (historyLocker, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+postgresTimeout)
+	defer cancel()
+	c, err := p.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	info, _ := json.Marshal(historyLockInfo{PID: os.Getpid(), Host: host, Action: action, StartedAt: time.Now()})
+	lockKey := "yamap_history:" + p.key
+	deadline := time.Now().Add(timeout)
+	for {
+		var locked bool
+		if err := c.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtext($1))`, lockKey).Scan(&locked); err != nil {
+			c.Close(context.Background())
+			return nil, err
+		}
+		if locked {
+			if _, err := c.Exec(ctx, `INSERT INTO yamap_history (key, locked_by) VALUES ($1, $2::jsonb)
+				ON CONFLICT (key) DO UPDATE SET locked_by = EXCLUDED.locked_by`, p.key, string(info)); err != nil {
+				c.Close(context.Background())
+				return nil, err
+			}
+			return &postgresHistoryLock{conn: c, key: p.key}, nil
+		}
+		if time.Now().After(deadline) {
+			var holder historyLockInfo
+			var lockedBy string
+			if err := c.QueryRow(ctx, `SELECT coalesce(locked_by::text, '') FROM yamap_history WHERE key = $1`, p.key).Scan(&lockedBy); err == nil {
+				json.Unmarshal([]byte(lockedBy), &holder)
+			}
+			c.Close(context.Background())
+			return nil, holder.lockedError(p.String())
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// String は接続先をパスワードを伏せて返す
+func (p *postgresHistory) String() string {
+	return fmt.Sprintf("%s (key=%s)", p.dsn.Redacted(), p.key)
+}
+
+// postgresHistoryLock は postgresHistory.lock で取得したロック
+type postgresHistoryLock struct {
+	conn *pgx.Conn
+	key  string
+}
+
+// release はロックを持つプロセスの情報を消し、接続を閉じてロックを解放する
+func (l *postgresHistoryLock) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresTimeout)
+	defer cancel()
+	l.conn.Exec(ctx, `UPDATE yamap_history SET locked_by = NULL WHERE key = $1`, l.key)
+	l.conn.Close(ctx)
+}
@@ -0,0 +1,287 @@
+package yamap
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historySince, historyUntil, historyAuthor, historyAction は history・unreact の絞り込み条件 (-since, -until, -author, -history-action)
+var historySince, historyUntil, historyAuthor, historyAction string
+
+// historyFilter は history・unreact で対象にするリアクションの絞り込み条件。ゼロ値の項目は絞り込まない
+type historyFilter struct {
+	since time.Time
+	// until はこの日時より前のリアクションに絞り込む
+	until time.Time
+	// author は投稿者のID、または名前の一部
+	author string
+	action string
+}
+
+// parseSince は -since の値を日時に変換する。日付 (2006-01-02)、日数 (7d)、time.Duration 形式 (48h) を受け付ける
+func parseSince(v string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		if n, err := strconv.ParseFloat(days, 64); err == nil && n >= 0 {
+			return now.Add(-time.Duration(n * float64(24*time.Hour))), nil
+		}
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf(tr("-since の値が不正です: %s (例: 2026-10-01, 7d, 48h)"), v)
+}
+
+// parseUntil は -until の値を日時に変換する。日付の場合はその日の終わり (翌日の0時) を返し、それ以外は parseSince と同じ形式を受け付ける
+func parseUntil(v string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t.AddDate(0, 0, 1), nil
+	}
+	t, err := parseSince(v, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(tr("-until の値が不正です: %s (例: 2026-10-02, 1d, 12h)"), v)
+	}
+	return t, nil
+}
+
+// historyFilterFromFlags は -since, -until, -author, -history-action から絞り込み条件を作る
+func historyFilterFromFlags() (historyFilter, error) {
+	filter := historyFilter{author: historyAuthor, action: historyAction}
+	now := time.Now()
+	if historySince != "" {
+		since, err := parseSince(historySince, now)
+		if err != nil {
+			return filter, err
+		}
+		filter.since = since
+	}
+	if historyUntil != "" {
+		until, err := parseUntil(historyUntil, now)
+		if err != nil {
+			return filter, err
+		}
+		filter.until = until
+	}
+	return filter, nil
+}
+
+// isZero は絞り込み条件が1つも指定されていないかを返す
+func (f historyFilter) isZero() bool {
+	return f.since.IsZero() && f.until.IsZero() && f.author == "" && f.action == ""
+}
+
+// matches はリアクション1件が絞り込み条件に一致するかを返す
+func (f historyFilter) matches(e historyEntry) bool {
+	if !f.since.IsZero() && e.ReactedAt.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && !e.ReactedAt.Before(f.until) {
+		return false
+	}
+	if f.action != "" && e.Action != f.action {
+		return false
+	}
+	if f.author != "" {
+		if id, err := strconv.ParseInt(f.author, 10, 64); err == nil {
+			return e.AuthorID == id
+		}
+		return strings.Contains(strings.ToLower(e.AuthorName), strings.ToLower(f.author))
+	}
+	return true
+}
+
+// historyCount は集計の1行分 (日付や投稿URLごとの件数)
+type historyCount struct {
+	Key   string
+	Count int
+}
+
+// historyStats は history で出力する集計結果
+type historyStats struct {
+	Reactions     int
+	UniqueAuthors int
+	// Runs は期間とアクションの条件に一致する実行の回数
+	Runs int
+	// BusiestDays はリアクションの多い日 (多い順)
+	BusiestDays []historyCount
+	// Duplicates は2回以上リアクションした投稿。重複の防止が働いていないことを示す
+	Duplicates []historyCount
+	// Variants は A/B 比較の戦略ごとの集計 (戦略の名前の順)
+	Variants []variantStats
+	// Snapshots は期間内に snapshot で記録したスナップショットの最初と最後。1件もない場合は nil
+	Snapshots []accountSnapshot
+}
+
+// variantStats は A/B 比較の戦略1つ分の集計
+type variantStats struct {
+	Name      string
+	Reactions int
+	Authors   int
+	// Reciprocated は最初のリアクションの後に自分をフォローした投稿者の数。
+	// thank-followers が新しいフォロワーとして記録した日時で判定する
+	Reciprocated int
+}
+
+// historyBusiestDays は history で表示するリアクションの多い日の件数
+const historyBusiestDays = 5
+
+// stats は絞り込み条件に一致するリアクションを集計する
+func (h *historyStore) stats(f historyFilter) historyStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var st historyStats
+	authors := make(map[int64]struct{})
+	perDay := make(map[string]int)
+	perURL := make(map[string]int)
+	variants := make(map[string]*variantStats)
+	// firstReacted は戦略ごとの、投稿者に最初にリアクションした日時
+	firstReacted := make(map[string]map[int64]time.Time)
+	for _, e := range h.Entries {
+		if !f.matches(e) {
+			continue
+		}
+		if e.Variant != "" {
+			v, ok := variants[e.Variant]
+			if !ok {
+				v = &variantStats{Name: e.Variant}
+				variants[e.Variant] = v
+				firstReacted[e.Variant] = make(map[int64]time.Time)
+			}
+			v.Reactions++
+			if first, ok := firstReacted[e.Variant][e.AuthorID]; e.AuthorID != 0 && (!ok || e.ReactedAt.Before(first)) {
+				firstReacted[e.Variant][e.AuthorID] = e.ReactedAt
+			}
+		}
+		st.Reactions++
+		if e.AuthorID != 0 {
+			authors[e.AuthorID] = struct{}{}
+		}
+		perDay[e.ReactedAt.Local().Format("2006-01-02")]++
+		perURL[e.URL]++
+	}
+	st.UniqueAuthors = len(authors)
+	var snapshots []accountSnapshot
+	for _, s := range h.Snapshots {
+		if (f.since.IsZero() || !s.At.Before(f.since)) && (f.until.IsZero() || s.At.Before(f.until)) {
+			snapshots = append(snapshots, s)
+		}
+	}
+	if len(snapshots) > 0 {
+		st.Snapshots = []accountSnapshot{snapshots[0], snapshots[len(snapshots)-1]}
+	}
+	for _, r := range h.Runs {
+		if (f.since.IsZero() || !r.StartedAt.Before(f.since)) && (f.until.IsZero() || r.StartedAt.Before(f.until)) && (f.action == "" || r.Action == f.action) {
+			st.Runs++
+		}
+	}
+
+	st.BusiestDays = sortedCounts(perDay, 1)
+	if len(st.BusiestDays) > historyBusiestDays {
+		st.BusiestDays = st.BusiestDays[:historyBusiestDays]
+	}
+	st.Duplicates = sortedCounts(perURL, 2)
+	for name, v := range variants {
+		v.Authors = len(firstReacted[name])
+		for author, first := range firstReacted[name] {
+			if followedAt, ok := h.ThankedFollowers[author]; ok && followedAt.After(first) {
+				v.Reciprocated++
+			}
+		}
+		st.Variants = append(st.Variants, *v)
+	}
+	sort.Slice(st.Variants, func(i, j int) bool { return st.Variants[i].Name < st.Variants[j].Name })
+	return st
+}
+
+// sortedCounts は件数が minCount 以上の項目を件数の多い順 (同数の場合はキーの順) に並べて返す
+func sortedCounts(counts map[string]int, minCount int) []historyCount {
+	var rows []historyCount
+	for key, n := range counts {
+		if n >= minCount {
+			rows = append(rows, historyCount{Key: key, Count: n})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].Key < rows[j].Key
+	})
+	return rows
+}
+
+// runHistoryQuery は -since, -author, -history-action で絞り込んだリアクション履歴の集計を標準出力に表示する
+func runHistoryQuery() error {
+	if history == nil {
+		return errors.New(tr("集計する履歴として HISTORY_FILE を設定してください"))
+	}
+	filter, err := historyFilterFromFlags()
+	if err != nil {
+		return err
+	}
+	st := history.stats(filter)
+
+	var conds []string
+	if !filter.since.IsZero() {
+		conds = append(conds, fmt.Sprintf(tr("期間: %s 以降"), filter.since.Local().Format("2006-01-02 15:04")))
+	}
+	if !filter.until.IsZero() {
+		conds = append(conds, fmt.Sprintf(tr("期間: %s より前"), filter.until.Local().Format("2006-01-02 15:04")))
+	}
+	if filter.author != "" {
+		conds = append(conds, tr("投稿者: ")+filter.author)
+	}
+	if filter.action != "" {
+		conds = append(conds, tr("アクション: ")+filter.action)
+	}
+	if len(conds) == 0 {
+		conds = append(conds, tr("なし (全期間)"))
+	}
+	fmt.Printf(tr("絞り込み条件: %s\n\n"), strings.Join(conds, ", "))
+	fmt.Printf(tr("リアクション数: %d\n"), st.Reactions)
+	fmt.Printf(tr("投稿者数: %d\n"), st.UniqueAuthors)
+	if filter.author == "" {
+		fmt.Printf(tr("実行回数: %d\n"), st.Runs)
+	}
+
+	if len(st.Snapshots) > 0 && filter.author == "" {
+		first, last := st.Snapshots[0], st.Snapshots[1]
+		fmt.Printf(tr("\n--- フォロワー数などの推移 (%s 〜 %s) ---\n"), first.At.Local().Format("2006-01-02 15:04"), last.At.Local().Format("2006-01-02 15:04"))
+		fmt.Printf(tr("フォロワー: %s\n"), snapshotValue(last.Followers, first.Followers, true))
+		fmt.Printf(tr("フォロー: %s\n"), snapshotValue(last.Following, first.Following, true))
+		fmt.Printf(tr("DOMOの残高: %s\n"), snapshotValue(last.DomoBalance, first.DomoBalance, true))
+	}
+
+	fmt.Println(tr("\n--- リアクションの多い日 ---"))
+	for _, day := range st.BusiestDays {
+		fmt.Printf(tr("%s  %d件\n"), day.Key, day.Count)
+	}
+
+	if len(st.Variants) > 0 {
+		fmt.Println(tr("\n--- A/B比較の戦略ごとの結果 ---"))
+		for _, v := range st.Variants {
+			rate := "-"
+			if v.Authors > 0 {
+				rate = fmt.Sprintf("%.1f%%", float64(v.Reciprocated)*100/float64(v.Authors))
+			}
+			fmt.Printf(tr("%s: リアクション %d件 / 投稿者 %d人 / フォローが返ってきた投稿者 %d人 (返報率 %s)\n"), v.Name, v.Reactions, v.Authors, v.Reciprocated, rate)
+		}
+	}
+
+	fmt.Println(tr("\n--- 2回以上リアクションした投稿 ---"))
+	if len(st.Duplicates) == 0 {
+		fmt.Println(tr("なし"))
+		return nil
+	}
+	for _, dup := range st.Duplicates {
+		fmt.Printf(tr("%s  %d回\n"), dup.Key, dup.Count)
+	}
+	fmt.Printf(tr("\n警告: %d件の投稿に重複してリアクションしています。リアクション済みの判定に問題がある可能性があります。\n"), len(st.Duplicates))
+	return nil
+}
@@ -0,0 +1,269 @@
+package yamap
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// pinnedChromeVersion は install-browser でダウンロードする Chrome for Testing の chrome-headless-shell のバージョン。
+// chromedp のバージョンを上げて新しいCDPのコマンドを使う場合は、あわせて更新する
+const pinnedChromeVersion = "131.0.6778.204"
+
+// pinnedChromeSHA256 は pinnedChromeVersion の chrome-headless-shell のZIPのSHA-256 (プラットフォームごと)。
+// ダウンロードしたZIPは必ずこの値か BROWSER_DOWNLOAD_SHA256 と照らし合わせ、一致しなければ展開しない。
+// 値は update_chrome_sha256.sh で配布元のZIPから計算して書き込む。pinnedChromeVersion を変えたときも実行し直す。
+// 値のないプラットフォームでは、BROWSER_DOWNLOAD_SHA256 を設定しない限りダウンロードせずにエラーを返す
+var pinnedChromeSHA256 = map[string]string{}
+
+// chromeForTestingPlatforms は chrome-headless-shell が配布されているOS・アーキテクチャと、Chrome for Testing でのプラットフォーム名。
+// Linux の arm64 向けのビルドは配布されていない
+var chromeForTestingPlatforms = map[string]string{
+	"linux/amd64":   "linux64",
+	"darwin/arm64":  "mac-arm64",
+	"darwin/amd64":  "mac-x64",
+	"windows/amd64": "win64",
+	"windows/386":   "win32",
+}
+
+// chromeForTestingPlatform は実行中のOS・アーキテクチャの Chrome for Testing でのプラットフォーム名。配布されていない場合は空文字を返す
+func chromeForTestingPlatform() string {
+	return chromeForTestingPlatforms[runtime.GOOS+"/"+runtime.GOARCH]
+}
+
+// installedBrowserDir は install-browser でダウンロードしたブラウザを置くディレクトリ。
+// BROWSER_CACHE_DIR が未設定の場合は、ユーザーのキャッシュディレクトリの下の yamap-auto-domo/browsers を使う
+func installedBrowserDir() (string, error) {
+	base := os.Getenv("BROWSER_CACHE_DIR")
+	if base == "" {
+		cache, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf(tr("ブラウザのキャッシュディレクトリを決められません。BROWSER_CACHE_DIR を設定してください: %w"), err)
+		}
+		base = filepath.Join(cache, "yamap-auto-domo", "browsers")
+	}
+	return filepath.Join(base, "chrome-headless-shell-"+pinnedChromeVersion), nil
+}
+
+// installedBrowserPath は install-browser でダウンロードしたブラウザの実行ファイルのパス
+func installedBrowserPath() (string, error) {
+	dir, err := installedBrowserDir()
+	if err != nil {
+		return "", err
+	}
+	name := "chrome-headless-shell"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, "chrome-headless-shell-"+chromeForTestingPlatform(), name), nil
+}
+
+// systemChromeFound はchromedpが起動に使うChrome・Chromiumが見つかるかを返す (chromedp の findExecPath と同じ場所を探す)
+func systemChromeFound() bool {
+	var locations []string
+	switch runtime.GOOS {
+	case "darwin":
+		locations = []string{"/Applications/Chromium.app/Contents/MacOS/Chromium", "/Applications/Google Chrome.app/Contents/MacOS/Google Chrome"}
+	case "windows":
+		locations = []string{"chrome", "chrome.exe",
+			`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+			`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+			filepath.Join(os.Getenv("USERPROFILE"), `AppData\Local\Google\Chrome\Application\chrome.exe`),
+			filepath.Join(os.Getenv("USERPROFILE"), `AppData\Local\Chromium\Application\chrome.exe`)}
+	default:
+		locations = []string{"headless_shell", "headless-shell", "chromium", "chromium-browser", "google-chrome", "google-chrome-stable",
+			"google-chrome-beta", "google-chrome-unstable", "/usr/bin/google-chrome", "/usr/local/bin/chrome", "/snap/bin/chromium", "chrome"}
+	}
+	for _, path := range locations {
+		if _, err := exec.LookPath(path); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// chromeExecPath はChromeの起動に使う実行ファイルのパスを返す。PATH などにChromeがある場合は空文字 (chromedp の既定) を返す。
+// 見つからない場合は install-browser でダウンロードしたブラウザを使い、まだなければ BROWSER_AUTO_INSTALL=true のときだけダウンロードする
+func chromeExecPath(ctx context.Context) (string, error) {
+	if systemChromeFound() {
+		return "", nil
+	}
+	path, err := installedBrowserPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err == nil {
+		loggerFromContext(ctx).Printf(tr("ダウンロード済みのブラウザ %s を使用します。"), path)
+		return path, nil
+	}
+	if os.Getenv("BROWSER_AUTO_INSTALL") != "true" {
+		return "", errors.New(tr("Chrome・Chromiumが見つかりません。-action install-browser でダウンロードするか、BROWSER_AUTO_INSTALL=true を設定してください"))
+	}
+	loggerFromContext(ctx).Println(tr("Chrome・Chromiumが見つからないため、ブラウザをダウンロードします (BROWSER_AUTO_INSTALL)。"))
+	return installBrowser(ctx)
+}
+
+// runInstallBrowser は固定したバージョンの chrome-headless-shell をキャッシュディレクトリにダウンロードする。
+// ダウンロード済みの場合は何もしない
+func runInstallBrowser() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	path, err := installedBrowserPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		loggerFromContext(ctx).Printf(tr("ブラウザ (chrome-headless-shell %s) はダウンロード済みです: %s"), pinnedChromeVersion, path)
+		return nil
+	}
+	_, err = installBrowser(ctx)
+	return err
+}
+
+// installBrowser は chrome-headless-shell のZIPをダウンロードして installedBrowserDir に展開し、実行ファイルのパスを返す。
+// BROWSER_DOWNLOAD_URL でミラーのURLに置き換えられる。ZIPのSHA-256は常に検証し、期待値は BROWSER_DOWNLOAD_SHA256、
+// 未設定の場合は pinnedChromeSHA256 を使う。期待値がない場合はダウンロードしない
+func installBrowser(ctx context.Context) (string, error) {
+	platform := chromeForTestingPlatform()
+	if platform == "" {
+		return "", fmt.Errorf(tr("%s/%s 向けの chrome-headless-shell は配布されていません。Chromium をパッケージマネージャーでインストールしてください"), runtime.GOOS, runtime.GOARCH)
+	}
+	want := strings.ToLower(os.Getenv("BROWSER_DOWNLOAD_SHA256"))
+	if want == "" {
+		want = pinnedChromeSHA256[platform]
+	}
+	if want == "" {
+		return "", fmt.Errorf(tr("chrome-headless-shell %s (%s) のチェックサムが登録されていないため、検証できないブラウザはダウンロードしません。配布元のZIPのSHA-256を BROWSER_DOWNLOAD_SHA256 に設定してください"), pinnedChromeVersion, platform)
+	}
+	url := os.Getenv("BROWSER_DOWNLOAD_URL")
+	if url == "" {
+		url = fmt.Sprintf("https://storage.googleapis.com/chrome-for-testing-public/%s/%s/chrome-headless-shell-%s.zip", pinnedChromeVersion, platform, platform)
+	}
+	dir, err := installedBrowserDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return "", err
+	}
+	loggerFromContext(ctx).Printf(tr("chrome-headless-shell %s をダウンロードしています: %s"), pinnedChromeVersion, url)
+	archive, err := os.CreateTemp(filepath.Dir(dir), ".chrome-headless-shell-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archive.Name())
+	sum, err := downloadTo(ctx, url, archive)
+	archive.Close()
+	if err != nil {
+		return "", fmt.Errorf(tr("ブラウザのダウンロードに失敗しました: %w"), err)
+	}
+	if sum != want {
+		return "", fmt.Errorf(tr("ダウンロードしたブラウザのチェックサムが一致しません (期待値 %s, 実際 %s)"), want, sum)
+	}
+	// 展開の途中で中断しても不完全なディレクトリを使わないよう、一時ディレクトリに展開してから名前を変える
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".chrome-headless-shell-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractZip(archive.Name(), tmp); err != nil {
+		return "", fmt.Errorf(tr("ブラウザの展開に失敗しました: %w"), err)
+	}
+	os.RemoveAll(dir)
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	path, err := installedBrowserPath()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf(tr("展開したファイルに実行ファイルがありません: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("ブラウザを %s にインストールしました。"), path)
+	return path, nil
+}
+
+// downloadTo は url の内容を w に書き出し、SHA-256を16進数で返す。大きなファイルでもメモリに読み込まずに書き出す
+func downloadTo(ctx context.Context, url string, w io.Writer) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "yamap-auto-domo")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf(tr("%s の取得に失敗しました: ステータス %d"), url, resp.StatusCode)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractZip はZIPファイルを dir に展開する。実行ファイルの権限はZIPに記録されたものを使う
+func extractZip(path, dir string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		target := filepath.Join(dir, f.Name)
+		// ZIPのエントリ名に .. を含めて展開先の外に書き込むことを防ぐ
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf(tr("ZIPに不正なパスが含まれています: %s"), f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractZipFile はZIPのエントリ1件を target に書き出す
+func extractZipFile(f *zip.File, target string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	mode := f.Mode().Perm()
+	if mode == 0 {
+		mode = 0o644
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,116 @@
+package yamap
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestPinnedChromeSHA256Coverage は chrome-headless-shell が配布されているすべてのプラットフォームに pinnedChromeSHA256 の値があり、
+// update_chrome_sha256.sh が同じプラットフォームを書き込むことを確かめる。
+// 値がまだ1つも書き込まれていない場合は、CHROME_SHA256_REQUIRED=true (リリースのワークフローで設定する) のときだけ失敗とする
+func TestPinnedChromeSHA256Coverage(t *testing.T) {
+	platforms := slices.Sorted(maps.Values(chromeForTestingPlatforms))
+
+	script, err := os.ReadFile("../update_chrome_sha256.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`(?m)^for platform in (.+); do$`).FindSubmatch(script)
+	if m == nil {
+		t.Fatal("update_chrome_sha256.sh has no platform loop")
+	}
+	if got := slices.Sorted(slices.Values(strings.Fields(string(m[1])))); !slices.Equal(got, platforms) {
+		t.Errorf("update_chrome_sha256.sh platforms = %q, want %q", got, platforms)
+	}
+
+	if len(pinnedChromeSHA256) == 0 && os.Getenv("CHROME_SHA256_REQUIRED") != "true" {
+		t.Skip("pinnedChromeSHA256 is empty; run ./update_chrome_sha256.sh")
+	}
+	sha256Pattern := regexp.MustCompile(`^[0-9a-f]{64}$`)
+	for _, platform := range platforms {
+		if sum := pinnedChromeSHA256[platform]; !sha256Pattern.MatchString(sum) {
+			t.Errorf("pinnedChromeSHA256[%q] = %q, want a lowercase hex SHA-256", platform, sum)
+		}
+	}
+	for platform := range pinnedChromeSHA256 {
+		if !slices.Contains(platforms, platform) {
+			t.Errorf("pinnedChromeSHA256 has %q, which is not a Chrome for Testing platform", platform)
+		}
+	}
+}
+
+// TestInstallBrowser はダウンロードしたZIPのSHA-256が期待値と一致する場合だけ展開し、一致しない場合や期待値がない場合は
+// 何も展開しないことを確かめる
+func TestInstallBrowser(t *testing.T) {
+	platform := chromeForTestingPlatform()
+	if platform == "" {
+		t.Skip("chrome-headless-shell is not distributed for this platform")
+	}
+	name := "chrome-headless-shell"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	header := &zip.FileHeader{Name: "chrome-headless-shell-" + platform + "/" + name, Method: zip.Deflate}
+	header.SetMode(0o755)
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "#!/bin/sh\n")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(archive.Bytes())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive.Bytes())
+	}))
+	defer srv.Close()
+	cache := t.TempDir()
+	t.Setenv("BROWSER_CACHE_DIR", cache)
+	t.Setenv("BROWSER_DOWNLOAD_URL", srv.URL+"/chrome-headless-shell.zip")
+	ctx := withLogger(context.Background(), log.New(io.Discard, "", 0))
+
+	origPinned := pinnedChromeSHA256
+	t.Cleanup(func() { pinnedChromeSHA256 = origPinned })
+	pinnedChromeSHA256 = map[string]string{}
+	for _, want := range []string{"", strings.Repeat("0", 64)} {
+		t.Setenv("BROWSER_DOWNLOAD_SHA256", want)
+		if _, err := installBrowser(ctx); err == nil {
+			t.Errorf("installBrowser with expected SHA-256 %q succeeded", want)
+		}
+		if entries, _ := os.ReadDir(cache); len(entries) > 0 {
+			t.Fatalf("cache after a rejected download = %v, want empty", entries)
+		}
+	}
+
+	// BROWSER_DOWNLOAD_SHA256 が未設定の場合は pinnedChromeSHA256 の値で検証する
+	t.Setenv("BROWSER_DOWNLOAD_SHA256", "")
+	pinnedChromeSHA256 = map[string]string{platform: hex.EncodeToString(sum[:])}
+	path, err := installBrowser(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := installedBrowserPath(); path != want {
+		t.Errorf("path = %q, want %q", path, want)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("installed file = %q, %v", data, err)
+	}
+}
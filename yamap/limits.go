@@ -0,0 +1,390 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hourlyQuota は HOURLY_REACTION_QUOTA から1時間 (毎時0分区切り) あたりのリアクションの上限を返す。0 の場合は無制限
+func hourlyQuota(ctx context.Context) int {
+	v := os.Getenv("HOURLY_REACTION_QUOTA")
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		loggerFromContext(ctx).Print(tr("警告: HOURLY_REACTION_QUOTAの値が不正です。上限は設けません"))
+		return 0
+	}
+	return n
+}
+
+// reactionsThisHour は現在の1時間の区切り (毎時0分から) に送ったリアクションの件数を返す。
+// 履歴が有効な場合は実行をまたいで数え、無効な場合は今回の実行の分だけを数える
+func reactionsThisHour(now time.Time) int {
+	bucket := now.Truncate(time.Hour)
+	if history != nil {
+		return history.reactionsSince(bucket)
+	}
+	n := 0
+	for _, e := range status.runReactions() {
+		if !e.ReactedAt.Before(bucket) {
+			n++
+		}
+	}
+	return n
+}
+
+// waitForHourlyQuota は投稿の処理前に呼び出され、1時間あたりの上限に達していれば、
+// HOURLY_QUOTA_WAIT=true の場合は次の1時間の区切りまで待機し、それ以外は false を返して処理を終えさせる
+func waitForHourlyQuota(ctx context.Context) bool {
+	quota := hourlyQuota(ctx)
+	if quota == 0 {
+		return true
+	}
+	for {
+		now := time.Now()
+		if reactionsThisHour(now) < quota {
+			return true
+		}
+		next := now.Truncate(time.Hour).Add(time.Hour)
+		if os.Getenv("HOURLY_QUOTA_WAIT") != "true" {
+			loggerFromContext(ctx).Printf(tr("1時間あたりのリアクションの上限 (%d件) に達したため、新しい投稿の処理を終了します。"), quota)
+			return false
+		}
+		loggerFromContext(ctx).Printf(tr("1時間あたりのリアクションの上限 (%d件) に達したため、%s まで待機します。"), quota, next.Local().Format("15:04"))
+		// 待機中もsystemdのウォッチドッグへ生存を通知する
+		for time.Now().Before(next) {
+			sdWatchdog()
+			if err := sleepAction(min(killSwitchPollInterval, time.Until(next)))(ctx); err != nil {
+				return false
+			}
+		}
+	}
+}
+
+// domoBudget はリアクションで贈るDOMOの日ごと・週ごとの上限。0 の項目は無制限
+type domoBudget struct {
+	daily, weekly int
+	// perReaction はリアクション1件で贈るDOMOの量
+	perReaction int
+}
+
+// loadDomoBudget は DOMO_DAILY_BUDGET, DOMO_WEEKLY_BUDGET, DOMO_PER_REACTION からDOMOの予算を読み込む
+func loadDomoBudget(ctx context.Context) domoBudget {
+	b := domoBudget{perReaction: 1}
+	for _, item := range []struct {
+		name string
+		dst  *int
+	}{{"DOMO_DAILY_BUDGET", &b.daily}, {"DOMO_WEEKLY_BUDGET", &b.weekly}, {"DOMO_PER_REACTION", &b.perReaction}} {
+		v := os.Getenv(item.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || (n == 0 && item.dst == &b.perReaction) {
+			loggerFromContext(ctx).Printf(tr("警告: %sの値が不正です。無視します: %s"), item.name, v)
+			continue
+		}
+		*item.dst = n
+	}
+	return b
+}
+
+// domoPeriodStarts は now を含む日と週 (月曜始まり) の始まりを、稼働時間と同じタイムゾーン (OPERATING_TZ) で返す
+func domoPeriodStarts(ctx context.Context, now time.Time) (day, week time.Time) {
+	local := now.In(operatingLocation(ctx))
+	day = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	week = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	return day, week
+}
+
+// domoGiven は since 以降に贈ったDOMOの量を返す。履歴が有効な場合は実行をまたいで数え、無効な場合は今回の実行の分だけを数える。
+// DOMOの量を記録する前の履歴は、リアクション1件あたり perReaction として数える
+func domoGiven(since time.Time, perReaction int) int {
+	entries := status.runReactions()
+	if history != nil {
+		history.mu.Lock()
+		entries = append([]historyEntry(nil), history.Entries...)
+		history.mu.Unlock()
+	}
+	total := 0
+	for _, e := range entries {
+		if e.ReactedAt.Before(since) {
+			continue
+		}
+		if e.Domo > 0 {
+			total += e.Domo
+		} else {
+			total += perReaction
+		}
+	}
+	return total
+}
+
+// withinDomoBudget は投稿の処理前に呼び出され、次のリアクションで日ごと・週ごとのDOMOの予算を超える場合は false を返して処理を終えさせる。
+// 予算は期間の区切りまで回復しないため、1時間あたりの上限と異なり待機はしない
+func withinDomoBudget(ctx context.Context) bool {
+	b := loadDomoBudget(ctx)
+	if b.daily == 0 && b.weekly == 0 {
+		return true
+	}
+	day, week := domoPeriodStarts(ctx, time.Now())
+	for _, limit := range []struct {
+		label  string
+		budget int
+		since  time.Time
+	}{{"1日", b.daily, day}, {"1週間", b.weekly, week}} {
+		if limit.budget == 0 {
+			continue
+		}
+		if given := domoGiven(limit.since, b.perReaction); given+b.perReaction > limit.budget {
+			loggerFromContext(ctx).Printf(tr("%sあたりのDOMOの予算 (%d) に達したため、新しい投稿の処理を終了します (贈ったDOMO: %d)。"), tr(limit.label), limit.budget, given)
+			return false
+		}
+	}
+	return true
+}
+
+// operatingWindow は1日のうち稼働してよい時間帯。end が start より前の場合は日をまたぐ (例: 22:00-06:00)。
+// start と end が同じ場合は、その時刻から翌日の同じ時刻までの24時間とする (例: 00:00-00:00 は終日)
+type operatingWindow struct {
+	start, end time.Duration
+}
+
+// parseOperatingHours は "07:00-22:00" 形式の時間帯をカンマ区切りで読み込む
+func parseOperatingHours(v string) ([]operatingWindow, error) {
+	var windows []operatingWindow
+	for _, part := range strings.Split(v, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf(tr("時間帯 %q は 07:00-22:00 の形式で指定してください"), part)
+		}
+		var w operatingWindow
+		for i, hm := range []string{from, to} {
+			t, err := time.Parse("15:04", strings.TrimSpace(hm))
+			if err != nil {
+				return nil, fmt.Errorf(tr("時刻 %q が不正です"), hm)
+			}
+			d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+			if i == 0 {
+				w.start = d
+			} else {
+				w.end = d
+			}
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// contains は0時からの経過時間 d が時間帯に含まれるかを返す
+func (w operatingWindow) contains(d time.Duration) bool {
+	if w.start == w.end {
+		return true
+	}
+	if w.start < w.end {
+		return d >= w.start && d < w.end
+	}
+	return d >= w.start || d < w.end
+}
+
+// operatingLocation は OPERATING_TZ (既定値 Asia/Tokyo) のタイムゾーンを返す。
+// タイムゾーンのデータベースがない環境でも既定の日本時間は使えるようにする
+func operatingLocation(ctx context.Context) *time.Location {
+	name := os.Getenv("OPERATING_TZ")
+	if name == "" {
+		name = "Asia/Tokyo"
+	}
+	loc, err := time.LoadLocation(name)
+	if err == nil {
+		return loc
+	}
+	if name == "Asia/Tokyo" {
+		return time.FixedZone("JST", 9*60*60)
+	}
+	loggerFromContext(ctx).Printf(tr("警告: OPERATING_TZの値が不正です。ローカルのタイムゾーンを使用します: %v"), err)
+	return time.Local
+}
+
+// withinOperatingHours は now が OPERATING_HOURS の時間帯に含まれるかを返す。含まれない場合は次に稼働できる時刻も返す。
+// 未設定または不正な場合は常に稼働できるものとする
+func withinOperatingHours(ctx context.Context, now time.Time) (bool, time.Time) {
+	v := os.Getenv("OPERATING_HOURS")
+	if v == "" {
+		return true, now
+	}
+	windows, err := parseOperatingHours(v)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: OPERATING_HOURSの値が不正です。時間帯の制限は設けません: %v"), err)
+		return true, now
+	}
+	local := now.In(operatingLocation(ctx))
+	// 分単位で最大1日先まで調べ、最初に時間帯に入る時刻を探す
+	for m := 0; m <= 24*60; m++ {
+		t := local.Add(time.Duration(m) * time.Minute)
+		if m > 0 {
+			t = t.Truncate(time.Minute)
+		}
+		sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+		for _, w := range windows {
+			if w.contains(sinceMidnight) {
+				return m == 0, t
+			}
+		}
+	}
+	return false, local
+}
+
+// waitForOperatingHours は投稿の処理前に呼び出され、稼働時間の外であれば、
+// OPERATING_HOURS_WAIT=true の場合は次に稼働できる時刻まで待機し、それ以外は false を返して処理を終えさせる
+func waitForOperatingHours(ctx context.Context) bool {
+	open, next := withinOperatingHours(ctx, time.Now())
+	if open {
+		return true
+	}
+	if os.Getenv("OPERATING_HOURS_WAIT") != "true" {
+		loggerFromContext(ctx).Printf(tr("稼働時間 (OPERATING_HOURS=%s) の外になったため、新しい投稿の処理を終了します。"), os.Getenv("OPERATING_HOURS"))
+		return false
+	}
+	loggerFromContext(ctx).Printf(tr("稼働時間の外になったため、%s まで待機します。"), next.Format("2006-01-02 15:04 MST"))
+	for time.Now().Before(next) {
+		sdWatchdog()
+		if err := sleepAction(min(killSwitchPollInterval, time.Until(next)))(ctx); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// maxRuntime は -max-runtime フラグで指定された最大実行時間。0 の場合は無制限
+var maxRuntime time.Duration
+
+// maxAge は -max-age で指定された、タイムラインから収集する投稿の古さの上限。0 の場合は制限しない
+var maxAge time.Duration
+
+// includeMoments は -moments で指定された、タイムラインのモーメントもリアクションの対象にするか
+var includeMoments bool
+
+// defaultRunTimeout はアクション全体のコンテキストのタイムアウト
+const defaultRunTimeout = 55 * time.Minute
+
+// runTimeout はアクション全体のコンテキストのタイムアウトを返す。
+// -max-runtime が指定されている場合は、処理中の投稿を終えて結果を出力する余裕を持たせて延長する。
+func runTimeout() time.Duration {
+	// watch は -max-runtime がなければ停止の指示まで確認を続けるため、期限を設けない
+	if maxRuntime == 0 && status.report().Action == "watch" {
+		return 100 * 365 * 24 * time.Hour
+	}
+	if maxRuntime > 0 && maxRuntime+5*time.Minute > defaultRunTimeout {
+		return maxRuntime + 5*time.Minute
+	}
+	// -spread の場合は、収集とログインの時間を見込んで分散させる時間幅より長くする
+	if maxRuntime == 0 && spreadWindow+30*time.Minute > defaultRunTimeout {
+		return spreadWindow + 30*time.Minute
+	}
+	return defaultRunTimeout
+}
+
+// maxRuntimeReached は -max-runtime で指定された時間がプログラム開始から経過したかを返す
+func maxRuntimeReached() bool {
+	return maxRuntime > 0 && time.Since(status.startedAt) >= maxRuntime
+}
+
+// killSwitchState はキルスイッチから読み取った実行制御の状態を表す
+type killSwitchState int
+
+const (
+	killSwitchRun killSwitchState = iota
+	killSwitchPause
+	killSwitchStop
+)
+
+// killSwitchPollInterval は一時停止中にキルスイッチを再確認する間隔
+const killSwitchPollInterval = 30 * time.Second
+
+// readKillSwitch は環境変数 KILL_SWITCH で指定されたファイルパスまたはURLを確認し、実行制御の状態を返す。
+// 内容が "stop" なら停止、"pause" なら一時停止とみなす。ファイルが存在するが空の場合も一時停止とする。
+// 未設定、ファイルが存在しない、または取得に失敗した場合は処理を継続する。
+func readKillSwitch(ctx context.Context) killSwitchState {
+	target := os.Getenv("KILL_SWITCH")
+	if target == "" {
+		return killSwitchRun
+	}
+
+	var content string
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, target, nil)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("キルスイッチURLのリクエスト作成に失敗しました: %v"), err)
+			return killSwitchRun
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("キルスイッチURLの取得に失敗しました。処理を継続します: %v"), err)
+			return killSwitchRun
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return killSwitchRun
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("キルスイッチURLの読み込みに失敗しました。処理を継続します: %v"), err)
+			return killSwitchRun
+		}
+		content = string(body)
+	} else {
+		body, err := os.ReadFile(target)
+		if err != nil {
+			return killSwitchRun
+		}
+		content = string(body)
+		if strings.TrimSpace(content) == "" {
+			return killSwitchPause
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(content)) {
+	case "stop":
+		return killSwitchStop
+	case "pause":
+		return killSwitchPause
+	default:
+		return killSwitchRun
+	}
+}
+
+// waitForKillSwitch は投稿の処理前に呼び出され、一時停止が指示されている間は待機する。
+// 停止が指示された場合、またはコンテキストがキャンセルされた場合は killSwitchStop を返す。
+func waitForKillSwitch(ctx context.Context) killSwitchState {
+	paused := false
+	for {
+		// 投稿の合間 (一時停止中を含む) にsystemdのウォッチドッグへ生存を通知する
+		sdWatchdog()
+		state := readKillSwitch(ctx)
+		if state != killSwitchPause {
+			if paused && state == killSwitchRun {
+				loggerFromContext(ctx).Println(tr("キルスイッチの一時停止が解除されました。処理を再開します。"))
+			}
+			return state
+		}
+		if !paused {
+			loggerFromContext(ctx).Printf(tr("キルスイッチにより一時停止します。%s ごとに再確認します..."), killSwitchPollInterval)
+			paused = true
+		}
+		select {
+		case <-ctx.Done():
+			return killSwitchStop
+		case <-time.After(killSwitchPollInterval):
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package yamap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOperatingWindowContains(t *testing.T) {
+	tests := []struct {
+		hours string
+		at    time.Duration
+		want  bool
+	}{
+		{"07:00-22:00", 7 * time.Hour, true},
+		{"07:00-22:00", 22 * time.Hour, false},
+		{"22:00-02:00", 23 * time.Hour, true},
+		{"22:00-02:00", 3 * time.Hour, false},
+		{"00:00-00:00", 12 * time.Hour, true},
+		{"07:00-07:00", 6*time.Hour + 59*time.Minute, true},
+	}
+	for _, tt := range tests {
+		windows, err := parseOperatingHours(tt.hours)
+		if err != nil {
+			t.Fatalf("parseOperatingHours(%q): %v", tt.hours, err)
+		}
+		if got := windows[0].contains(tt.at); got != tt.want {
+			t.Errorf("%s contains %v = %v, want %v", tt.hours, tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,556 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"maps"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crashLogLines はクラッシュレポートに含める直近のログの行数
+const crashLogLines = 200
+
+// logRing は直近のログを行単位で保持する。標準エラー出力と並べて log の出力先にする
+type logRing struct {
+	mu    sync.Mutex
+	lines []string
+	buf   bytes.Buffer
+}
+
+// redactedText は秘密の値を置き換える文字列
+const redactedText = "[REDACTED]"
+
+// secretEnvNames はログやデバッグ情報から伏せる値を持つ環境変数
+var secretEnvNames = []string{"YAMAP_EMAIL", "YAMAP_PASSWORD", "YAMAP_TOTP_SECRET", "PGPASSWORD", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+	"ARTIFACT_UPLOAD_TOKEN", "RUN_CHECKPOINT_TOKEN", "NOTIFY_WEBHOOK_URL", "REACTION_WEBHOOK_URL", "MASTODON_TOKEN", "X_API_SECRET", "X_ACCESS_TOKEN", "X_ACCESS_SECRET",
+	"STRAVA_CLIENT_SECRET", "STRAVA_REFRESH_TOKEN"}
+
+// secretURLEnvNames はURLに含まれるパスワードだけを伏せる環境変数
+var secretURLEnvNames = []string{"HISTORY_DATABASE_URL", "REDIS_URL"}
+
+// redaction はログ・HTMLなどのデバッグ情報・HARから伏せる秘密の値
+var redaction = &redactor{}
+
+// redactor は登録された秘密の値を、そのままの形とURL・HTML・JSONでエスケープした形のどちらも [REDACTED] に置き換える
+type redactor struct {
+	mu       sync.RWMutex
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+// add は秘密の値を登録する。空の値は無視する
+func (r *redactor) add(secret string) {
+	if strings.TrimSpace(secret) == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.secrets == nil {
+		r.secrets = make(map[string]bool)
+	}
+	encoded, _ := json.Marshal(secret)
+	for _, v := range []string{secret, neturl.QueryEscape(secret), neturl.PathEscape(secret), htmltemplate.HTMLEscapeString(secret), string(encoded[1 : len(encoded)-1])} {
+		r.secrets[v] = true
+	}
+	variants := make([]string, 0, len(r.secrets))
+	for v := range r.secrets {
+		variants = append(variants, v)
+	}
+	// 同じ位置で複数の値に一致する場合に長い方を優先するため、長い順に並べる
+	sort.Slice(variants, func(i, j int) bool { return len(variants[i]) > len(variants[j]) })
+	pairs := make([]string, 0, len(variants)*2)
+	for _, v := range variants {
+		pairs = append(pairs, v, redactedText)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// addFromEnv は secretEnvNames・secretURLEnvNames の値と、REDACT_SECRETS にカンマ区切りで指定された値を登録する
+func (r *redactor) addFromEnv() {
+	for _, name := range secretEnvNames {
+		r.add(os.Getenv(name))
+	}
+	for _, name := range secretURLEnvNames {
+		if u, err := neturl.Parse(os.Getenv(name)); err == nil {
+			if password, ok := u.User.Password(); ok {
+				r.add(password)
+			}
+		}
+	}
+	for _, v := range strings.Split(os.Getenv("REDACT_SECRETS"), ",") {
+		r.add(strings.TrimSpace(v))
+	}
+}
+
+// redact は s に含まれる秘密の値を伏せる
+func (r *redactor) redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.replacer == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// redactingWriter はログの出力先をラップし、書き込む前に秘密の値を伏せる
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, redaction.redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// consoleLevel はコンソールに出す行の種類。色分けと終了時の警告のまとめに使う
+type consoleLevel int
+
+const (
+	levelInfo consoleLevel = iota
+	levelSuccess
+	levelWarning
+	levelError
+)
+
+// 行の種類を判定する文言。-lang en でも判定できるよう、日本語と英語の両方を並べる
+var (
+	// warningPrefixes は警告の行の先頭の文言
+	warningPrefixes = []string{"警告:", "Warning:"}
+	// errorPrefixes はエラーの行の先頭の文言、errorPhrases はエラーの行に含まれる文言
+	errorPrefixes = []string{"エラー:", "Error:", "パニックが発生しました", "Panic:"}
+	errorPhrases  = []string{"エラーが発生しました", "An error occurred"}
+	// successPhrases は成功の行に含まれる文言
+	successPhrases = []string{"ログイン成功。", "Login succeeded.", "全ての処理が正常に完了しました", "All processing completed successfully",
+		"リアクションの送信に成功しました", "Reaction sent:"}
+)
+
+// logMessage はログの1行から日時と実行IDを除いた本文を返す
+func logMessage(line string) string {
+	if i := strings.Index(line, runID+" "); i >= 0 {
+		return line[i+len(runID)+1:]
+	}
+	return line
+}
+
+// levelOf はログの本文から行の種類を判定する。終了時の警告のまとめの行 ("- 警告: ...") も警告として扱う
+func levelOf(message string) consoleLevel {
+	text := strings.TrimLeft(message, " -")
+	hasPrefix := func(prefixes []string) bool {
+		return slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(text, p) })
+	}
+	contains := func(phrases []string) bool {
+		return slices.ContainsFunc(phrases, func(p string) bool { return strings.Contains(text, p) })
+	}
+	switch {
+	case hasPrefix(warningPrefixes):
+		return levelWarning
+	case hasPrefix(errorPrefixes) || contains(errorPhrases):
+		return levelError
+	case contains(successPhrases):
+		return levelSuccess
+	}
+	return levelInfo
+}
+
+// consoleColors は行の種類ごとのANSIエスケープシーケンス
+var consoleColors = map[consoleLevel]string{levelSuccess: "\x1b[32m", levelWarning: "\x1b[33m", levelError: "\x1b[31m"}
+
+// consoleWriter は標準エラー出力に、行の種類に応じて色を付けて書き出す。color が false の場合はそのまま書き出す
+type consoleWriter struct {
+	w     io.Writer
+	color bool
+}
+
+func (c *consoleWriter) Write(p []byte) (int, error) {
+	if !c.color {
+		return c.w.Write(p)
+	}
+	var b strings.Builder
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		text := strings.TrimSuffix(line, "\n")
+		if code, ok := consoleColors[levelOf(logMessage(text))]; ok && text != "" {
+			b.WriteString(code + text + "\x1b[0m" + line[len(text):])
+		} else {
+			b.WriteString(line)
+		}
+	}
+	if _, err := io.WriteString(c.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// console は色分けする標準エラー出力。-color の値に応じて main で color を設定する
+var console = &consoleWriter{w: os.Stderr}
+
+// consoleColorEnabled は -color の値 (auto, always, never) から色を付けるかを返す。
+// auto の場合は、標準エラー出力が端末で、NO_COLOR が未設定で、TERM が dumb でないときに色を付ける
+func consoleColorEnabled(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		info, err := os.Stderr.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf(tr("-color には auto, always, never のいずれかを指定してください: %s"), mode)
+}
+
+// logOutput は log の出力先を返す。dst (標準エラー出力・ダッシュボードなど) に加え、
+// クラッシュレポートのための直近のログと、終了時にまとめる警告にも書き出す
+func logOutput(dst io.Writer) io.Writer {
+	return redactingWriter{io.MultiWriter(dst, recentLogs, runWarnings)}
+}
+
+// maxWarningSummary は終了時の警告のまとめに表示する警告の種類の上限
+const maxWarningSummary = 20
+
+// warningLog は実行中に出力した警告を、同じ文言ごとに回数を数えて出力した順に保持する
+type warningLog struct {
+	mu     sync.Mutex
+	order  []string
+	counts map[string]int
+}
+
+// runWarnings は実行中の警告。終了時に logWarningSummary でまとめて表示する
+var runWarnings = &warningLog{counts: map[string]int{}}
+
+// Write はログのうち警告の行を記録する。log は1回の出力を1回の Write で書き込む
+func (l *warningLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		message := logMessage(line)
+		if !slices.ContainsFunc(warningPrefixes, func(prefix string) bool { return strings.HasPrefix(message, prefix) }) {
+			continue
+		}
+		if l.counts[message] == 0 {
+			l.order = append(l.order, message)
+		}
+		l.counts[message]++
+	}
+	return len(p), nil
+}
+
+// logWarningSummary は実行中に出力した警告を、重複を除いてまとめて表示する。-quiet でも表示する。
+// まとめの行は警告として記録し直さないよう、runWarnings を含まない出力先に書く
+func logWarningSummary() {
+	runWarnings.mu.Lock()
+	order := append([]string(nil), runWarnings.order...)
+	counts := maps.Clone(runWarnings.counts)
+	runWarnings.mu.Unlock()
+	if len(order) == 0 {
+		return
+	}
+	out := log.New(redactingWriter{io.MultiWriter(console, recentLogs)}, log.Prefix(), log.Flags())
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
+	out.Printf(tr("--- 実行中の警告 (%d種類・%d件) ---"), len(order), total)
+	for i, message := range order {
+		if i == maxWarningSummary {
+			out.Printf(tr("ほか %d種類の警告はログを確認してください。"), len(order)-maxWarningSummary)
+			break
+		}
+		if n := counts[message]; n > 1 {
+			out.Printf(tr("- %s (%d回)"), message, n)
+		} else {
+			out.Printf("- %s", message)
+		}
+	}
+}
+
+// logVerbosity はログの詳細さ。-quiet で verbosityQuiet、-v で verbosityVerbose、-vv で verbosityDebug になる
+var logVerbosity = verbosityNormal
+
+const (
+	// verbosityQuiet は実行の結果の要約とエラーだけを表示する
+	verbosityQuiet = -1
+	// verbosityNormal は投稿ごとの進捗を表示する (既定)
+	verbosityNormal = 0
+	// verbosityVerbose はブラウザの操作を1件ずつ表示する
+	verbosityVerbose = 1
+	// verbosityDebug はブラウザの操作に加え、Chromeとの間のCDPのメッセージをそのまま表示する
+	verbosityDebug = 2
+)
+
+// quietLogs は -quiet で実行中のログの出力先。端末には出さず、クラッシュレポートのためにだけ保持する
+func quietLogs() {
+	if logVerbosity == verbosityQuiet {
+		log.SetOutput(redactingWriter{io.MultiWriter(recentLogs, runWarnings)})
+	}
+}
+
+// essentialLog は -quiet でも表示する行 (実行の結果の要約・終了の原因) の出力先を返す
+func essentialLog() Logger {
+	if logVerbosity == verbosityQuiet {
+		return log.New(redactingWriter{io.MultiWriter(console, recentLogs)}, log.Prefix(), log.Flags())
+	}
+	return log.Default()
+}
+
+// logRunSummary は実行の結果の要約を1行で表示する
+func logRunSummary(r runRecord) {
+	essentialLog().Printf(tr("%s の実行結果: 処理 %d件 / 成功 %d件 / 失敗 %d件 / スキップ %d件 (所要時間 %s)"),
+		r.Action, r.Processed, r.Succeeded, r.Failed, r.Skipped, r.FinishedAt.Sub(r.StartedAt).Round(time.Second))
+}
+
+// printResultLine は終了時に、実行の結果を1行の key=value 形式で標準エラー出力に出す。
+// 定期実行のラッパーやCIが結果を grep で取り出せるよう、ログの詳細さ・言語に関係なく常に同じ形式で出す
+func printResultLine(r runRecord, code int) {
+	action := r.Action
+	if action == "" {
+		action = "-"
+	}
+	fmt.Fprintf(os.Stderr, "RESULT action=%s reacted=%d skipped=%d failed=%d duration=%s exit=%d run_id=%s\n",
+		action, r.Succeeded, r.Skipped, r.Failed, r.FinishedAt.Sub(r.StartedAt).Round(time.Second), code, runID)
+}
+
+// recentLogs はクラッシュレポートのために保持する直近のログ
+var recentLogs = &logRing{}
+
+func (r *logRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf.Write(p)
+	for {
+		line, err := r.buf.ReadString('\n')
+		if err != nil {
+			// 改行のない残りは次の書き込みまで保持する
+			r.buf.Reset()
+			r.buf.WriteString(line)
+			break
+		}
+		r.lines = append(r.lines, strings.TrimRight(line, "\n"))
+		if len(r.lines) > crashLogLines {
+			r.lines = r.lines[len(r.lines)-crashLogLines:]
+		}
+	}
+	return len(p), nil
+}
+
+func (r *logRing) snapshot() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+// debugDir, debugMaxAge, debugMaxSizeMB は -debug-dir, -debug-max-age, -debug-max-size フラグの値
+var (
+	debugDir       string
+	debugMaxAge    time.Duration
+	debugMaxSizeMB int64
+)
+
+// debugRunDir は -debug-dir の中の今回の実行のサブディレクトリ (<実行ID>[_<アカウント名>])。実行IDは開始日時で始まるため、名前順が実行順になる
+func debugRunDir() string {
+	name := runID
+	if account := os.Getenv("YAMAP_ACCOUNT"); account != "" {
+		name += "_" + account
+	}
+	return filepath.Join(debugDir, name)
+}
+
+// debugPath はデバッグ用のファイルを置くパスを返す。-debug-dir が指定されていればその中の実行ごとのサブディレクトリに、
+// なければ DEBUG_DIR (未設定の場合はカレントディレクトリ) に、ファイル名の先頭に実行IDを付けて置く
+func debugPath(ctx context.Context, name string) string {
+	dir := os.Getenv("DEBUG_DIR")
+	if debugDir != "" {
+		dir = debugRunDir()
+	} else {
+		name = runID + "_" + name
+	}
+	if dir == "" {
+		return name
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: デバッグ情報の保存先 %s を作成できません: %v"), dir, err)
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// pruneDebugDir は -debug-dir の古い実行のサブディレクトリを削除する。-debug-max-age より古いものを削除し、
+// 残りの合計が -debug-max-size を超えていれば古いものから削除する。今回の実行のサブディレクトリは削除しない
+func pruneDebugDir() {
+	if debugDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(debugDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf(tr("警告: デバッグ情報の保存先を確認できません: %v"), err)
+		}
+		return
+	}
+	current := filepath.Base(debugRunDir())
+	type runDir struct {
+		path string
+		size int64
+	}
+	var runs []runDir
+	var total int64
+	cutoff := time.Now().Add(-debugMaxAge)
+	removed := 0
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == current {
+			continue
+		}
+		path := filepath.Join(debugDir, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if debugMaxAge > 0 && info.ModTime().Before(cutoff) {
+			if os.RemoveAll(path) == nil {
+				removed++
+			}
+			continue
+		}
+		var size int64
+		filepath.WalkDir(path, func(_ string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if fi, err := d.Info(); err == nil {
+					size += fi.Size()
+				}
+			}
+			return nil
+		})
+		runs = append(runs, runDir{path: path, size: size})
+		total += size
+	}
+	// os.ReadDir は名前順で返すため、runs は古い順に並んでいる
+	limit := debugMaxSizeMB * 1024 * 1024
+	for _, r := range runs {
+		if limit <= 0 || total <= limit {
+			break
+		}
+		if os.RemoveAll(r.path) == nil {
+			total -= r.size
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf(tr("%s の古いデバッグ情報を %d 件の実行分削除しました。"), debugDir, removed)
+	}
+}
+
+// recoverCrash は defer で呼び出し、パニックが起きた場合にクラッシュレポートを残して終了する
+func recoverCrash() {
+	if r := recover(); r != nil {
+		handleCrash(r, debug.Stack())
+	}
+}
+
+// handleCrash はパニックの内容・スタックトレース・実行中のアクションとURL・直近のログを crash_<日時>.txt に書き出し、
+// ブラウザが生きていればスクリーンショットとHTMLも保存してから exitCodePanic で終了する
+func handleCrash(r any, stack []byte) {
+	name := "crash_" + time.Now().Format("20060102_150405")
+	report := status.report()
+	var b strings.Builder
+	build := currentBuildInfo()
+	build.Browser = status.browserVersion()
+	b.WriteString(build.text() + "\n")
+	fmt.Fprintf(&b, "panic: %v\n\n", r)
+	fmt.Fprintf(&b, "run_id: %s\ntime: %s\naction: %s\nphase: %s\ncurrent_url: %s\nuptime: %s\n", runID, time.Now().Format(time.RFC3339), report.Action, report.Phase, report.CurrentURL, report.Uptime)
+	fmt.Fprintf(&b, "processed: %d/%d (succeeded %d, failed %d, skipped %d)\n\n", report.Processed, report.Queued, report.Succeeded, report.Failed, report.Skipped)
+	b.WriteString("--- stack ---\n")
+	b.Write(stack)
+	b.WriteString("\n--- recent logs ---\n")
+	for _, line := range recentLogs.snapshot() {
+		b.WriteString(line + "\n")
+	}
+
+	log.SetOutput(redactingWriter{console})
+	log.Printf(tr("パニックが発生しました: %v"), r)
+	os.Stderr.Write(stack)
+	path := debugPath(context.Background(), name+".txt")
+	if err := writeArtifact(path, []byte(b.String())); err != nil {
+		log.Printf(tr("クラッシュレポートの保存に失敗しました: %v"), err)
+	} else {
+		log.Printf(tr("クラッシュレポートを %s に保存しました。"), path)
+	}
+	if status.browserAlive() {
+		status.mu.Lock()
+		ctx := status.browserCtx
+		status.mu.Unlock()
+		saveDebugSnapshot(ctx, driverFromContext(ctx), name)
+	}
+	events.publishDone()
+	beforeExit()
+	printResultLine(status.result(), exitCodePanic)
+	os.Exit(exitCodePanic)
+}
+
+// exitError は終了コードを指定してプロセスを終了させるエラー
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// exitCodeOf は err で終了するときの終了コードを返す
+func exitCodeOf(err error) int {
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return 1
+}
+
+// exitOnError は err があれば記録し、終了処理をしてから err に応じた終了コードでプロセスを終了する。
+// 実行の途中では呼び出さず、エラーを main まで返して defer によるブラウザの終了を済ませてから呼び出す
+func exitOnError(err error) {
+	if err == nil {
+		return
+	}
+	code := exitCodeOf(err)
+	essentialLog().Printf(tr("エラー: %v (終了コード %d)"), err, code)
+	events.publishDone()
+	beforeExit()
+	printResultLine(status.result(), code)
+	os.Exit(code)
+}
+
+// abortError は実行が中止されていれば、理由に応じた終了コードを持つエラーを返す
+func abortError() error {
+	err := status.abortError()
+	if err == nil {
+		return nil
+	}
+	code := 1
+	switch {
+	case errors.Is(err, errSiteMaintenance):
+		code = exitCodeMaintenance
+	case errors.Is(err, errAccountRestricted):
+		code = exitCodeAccountRestricted
+	case errors.Is(err, errCircuitBreakerOpen):
+		code = exitCodeCircuitBreaker
+	}
+	return &exitError{code: code, err: fmt.Errorf(tr("実行を中止しました: %w"), err)}
+}
@@ -0,0 +1,439 @@
+package yamap
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+// restoredSession は -profile-dir のプロファイルに残ったクッキーでログイン済みかを確かめる。
+// ログイン済みの場合、ログインページを開くと別のページへ移動させられる
+func restoredSession(ctx context.Context, drv pageDriver) bool {
+	var loggedIn bool
+	err := runActions(ctx,
+		drv.Navigate("https://yamap.com/login"),
+		drv.Poll(`!location.pathname.startsWith("/login") || document.querySelector('input[name="email"]') !== null`, 20*time.Second),
+		drv.Evaluate(`!location.pathname.startsWith("/login")`, &loggedIn),
+	)
+	return err == nil && loggedIn
+}
+
+// loginRedirectTimeout はログインボタンを押してからログインページ以外へ移動するまでの待機時間の上限
+const loginRedirectTimeout = 30 * time.Second
+
+// loginCheckInterval はログインページからの移動を待つ間に、失敗の表示を確認する間隔
+const loginCheckInterval = 500 * time.Millisecond
+
+// ログインに失敗した理由。終了コードでスケジューラーから判別できるようにする
+var (
+	errBadCredentials error = messageError("メールアドレスまたはパスワードが正しくありません")
+	errLoginChallenge error = messageError("CAPTCHAなどの追加の確認を求められました")
+	errLoginNetwork   error = messageError("ネットワークエラーによりログインできませんでした")
+)
+
+// loginFailurePhrases はログインページに表示されるエラー (トースト・入力欄の検証メッセージ) の文言を、失敗の種類ごとにまとめたもの
+var loginFailurePhrases = []struct {
+	Kind    string   `json:"kind"`
+	Phrases []string `json:"phrases"`
+}{
+	{"credentials", []string{"メールアドレスまたはパスワードが", "パスワードが正しくありません", "パスワードが間違って", "ログインに失敗しました", "incorrect email or password", "invalid email or password", "incorrect password"}},
+	{"network", []string{"通信エラー", "ネットワークエラー", "通信に失敗", "network error", "failed to fetch"}},
+}
+
+// loginFailureScript はログインページに失敗の表示があれば {kind, text} を、なければ null を返すスクリプト。
+// CAPTCHA (reCAPTCHA・hCaptcha・Cloudflare Turnstile) の枠が表示されている場合は challenge とする
+var loginFailureScript = func() string {
+	encoded, _ := json.Marshal(loginFailurePhrases)
+	return fmt.Sprintf(`(() => {
+		const captcha = document.querySelector('iframe[src*="recaptcha"], iframe[src*="hcaptcha"], iframe[src*="challenges.cloudflare.com"], [class*="captcha" i]');
+		if (captcha && captcha.getBoundingClientRect().height > 0) return {kind: "challenge", text: captcha.getAttribute("src") || captcha.className};
+		const messages = Array.from(document.querySelectorAll('[role="alert"], [class*="toast" i], [class*="error" i], [class*="invalid" i]'))
+			.map(e => (e.textContent || "").trim()).filter(t => t !== "");
+		for (const entry of %s) {
+			for (const text of messages) {
+				if (entry.phrases.some(p => text.toLowerCase().includes(p.toLowerCase()))) return {kind: entry.kind, text: text.slice(0, 200)};
+			}
+		}
+		return null;
+	})()`, encoded)
+}()
+
+// waitLoginRedirect はログインページからの移動を loginRedirectTimeout まで待つ。
+// 待機中は loginCheckInterval ごとにページの表示を確認し、認証情報の誤り・CAPTCHA・通信エラーが表示されていればすぐに
+// errBadCredentials, errLoginChallenge, errLoginNetwork を含むエラーを返す
+func waitLoginRedirect(drv pageDriver) browserAction {
+	return func(ctx context.Context) error {
+		deadline := time.Now().Add(loginRedirectTimeout)
+		for {
+			err := drv.WaitNavigatedAway("/login", min(loginCheckInterval, time.Until(deadline)))(ctx)
+			var timeout *navigationTimeoutError
+			if !errors.As(err, &timeout) {
+				return err
+			}
+			var failure *struct {
+				Kind string `json:"kind"`
+				Text string `json:"text"`
+			}
+			if err := drv.Evaluate(loginFailureScript, &failure)(ctx); err == nil && failure != nil {
+				switch failure.Kind {
+				case "credentials":
+					return fmt.Errorf("%w (%s)", errBadCredentials, failure.Text)
+				case "challenge":
+					return fmt.Errorf("%w (%s)", errLoginChallenge, failure.Text)
+				case "network":
+					return fmt.Errorf("%w (%s)", errLoginNetwork, failure.Text)
+				}
+			}
+			if !time.Now().Before(deadline) {
+				timeout.timeout = loginRedirectTimeout
+				return timeout
+			}
+		}
+	}
+}
+
+// isNetworkError はページの読み込みがネットワークの障害で失敗したか (Chromeの net::ERR_*、Firefoxの NS_ERROR_*) を返す
+func isNetworkError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "net::ERR_") || strings.Contains(msg, "NS_ERROR_")
+}
+
+// loginError はログインの失敗を、失敗の理由に応じた終了コードを持つエラーにする。
+// ログイン中に実行が中止されていた場合は中止の理由を優先する
+func loginError(err error) error {
+	if aborted := abortError(); aborted != nil {
+		return aborted
+	}
+	code := 1
+	switch {
+	case errors.Is(err, errBadCredentials):
+		code = exitCodeBadCredentials
+	case errors.Is(err, errLoginChallenge):
+		code = exitCodeLoginChallenge
+	case errors.Is(err, errLoginNetwork):
+		code = exitCodeLoginNetwork
+	case isNetworkError(err):
+		code = exitCodeLoginNetwork
+	}
+	return &exitError{code: code, err: fmt.Errorf(tr("ログインに失敗しました: %w"), err)}
+}
+
+func login(ctx context.Context, email, password string, navigateToTimeline bool) (err error) {
+	startedAt := time.Now()
+	ctx, span := startSpan(ctx, "login")
+	defer func() { span.finish(err) }()
+	drv := driverFromContext(ctx)
+	if fixtureReplay != nil {
+		// フィクスチャはログイン済みのセッションで保存したページのため、ログインの操作は行わない
+		loggerFromContext(ctx).Println(tr("-replay のため、ログインの操作を省略します。"))
+		return nil
+	}
+	method := os.Getenv("YAMAP_LOGIN_METHOD")
+	if profileDir != "" && restoredSession(ctx, drv) {
+		loggerFromContext(ctx).Println(tr("保存されたプロファイルのセッションでログイン済みのため、ログインフォームの入力を省略します。"))
+		method = "session"
+	} else if runCheckpoints.restoreCookies(ctx) && restoredSession(ctx, drv) {
+		loggerFromContext(ctx).Println(tr("実行の途中経過のクッキーでログイン済みのため、ログインフォームの入力を省略します。"))
+		method = "session"
+	} else if restoreSessionCookies(ctx) {
+		if restoredSession(ctx, drv) {
+			loggerFromContext(ctx).Println(tr("取り込んだクッキーでログイン済みのため、ログインフォームの入力を省略します。"))
+			method = "session"
+		} else {
+			loggerFromContext(ctx).Println(tr("警告: 取り込んだクッキーのセッションが切れているため、ログインフォームからログインします。"))
+		}
+	}
+	if !slices.Contains([]string{"session", "google", "apple", "", "password"}, method) {
+		return fmt.Errorf(tr("不明なログイン方式 '%s' が指定されました (password, google, apple)"), method)
+	}
+	span.setAttr("login.method", method)
+
+	// 認証情報の誤りやCAPTCHAなど、繰り返しても結果が変わらない失敗は再試行しない
+	policy := config.Retry.Login.or(defaultLoginRetry)
+	for attempt := 1; ; attempt++ {
+		span.setAttr("login.attempts", attempt)
+		err := loginAttempt(ctx, drv, method, email, password, navigateToTimeline, policy, attempt)
+		if err == nil {
+			break
+		}
+		if attempt >= policy.Attempts || ctx.Err() != nil || errors.Is(err, errBadCredentials) || errors.Is(err, errLoginChallenge) {
+			return err
+		}
+		loggerFromContext(ctx).Printf(tr("ログインに失敗したため再試行します (%d/%d回目): %v"), attempt+1, policy.Attempts, err)
+	}
+
+	loggerFromContext(ctx).Println(tr("ログイン成功を確認しました。"))
+	status.setLoginDuration(time.Since(startedAt))
+	events.publish("login", "", "", "")
+	return nil
+}
+
+// loginAttempt はログインを1回試行する。再試行で policy.OnRetry が reload の場合は、フォームを入力し直さずに
+// ページをリロードしてログイン後の表示の確認からやり直す
+func loginAttempt(ctx context.Context, drv pageDriver, method, email, password string, navigateToTimeline bool, policy retryPolicy, attempt int) error {
+	var actions []browserAction
+	switch {
+	case attempt > 1 && policy.OnRetry == retryReload:
+		actions = append(actions, drv.Reload())
+	case method == "session":
+	case method == "google" || method == "apple":
+		if err := loginWithSSO(ctx, method, email, password); err != nil {
+			return err
+		}
+	case method == "" || method == "password":
+		loggerFromContext(ctx).Println(tr("ログインページに移動し、フォームを入力します..."))
+		if err := runActions(ctx,
+			drv.Navigate("https://yamap.com/login"),
+			drv.WaitVisible(`input[name="email"]`),
+			drv.SendKeys(`input[name="email"]`, email),
+			drv.SendKeys(`input[name="password"]`, password),
+		); err != nil {
+			if isNetworkError(err) {
+				err = fmt.Errorf("%w: %w", errLoginNetwork, err)
+			}
+			return fmt.Errorf(tr("フォーム入力に失敗: %w"), err)
+		}
+
+		loggerFromContext(ctx).Println(tr("ログインボタンをクリックします..."))
+		actions = append(actions,
+			drv.Evaluate(`document.querySelector('button[type="submit"]').click()`, nil),
+			// ログインに成功するとログインページから移動するため、そのナビゲーションを待ってから通信が落ち着くまで待機。
+			// 失敗の表示が出た場合はタイムアウトを待たずに理由を判別して戻る
+			waitLoginRedirect(drv),
+			drv.WaitNetworkIdle(),
+		)
+	}
+
+	loginCtx, loginCancel := policy.withTimeout(ctx)
+	defer loginCancel()
+
+	if navigateToTimeline {
+		loggerFromContext(ctx).Println(tr("明示的にタイムラインへ移動します..."))
+		actions = append(actions,
+			drv.Navigate("https://yamap.com/timeline"),
+			drv.WaitVisible(layout.feed),
+		)
+	} else {
+		loggerFromContext(ctx).Println(tr("ログイン成功を確認するため、マイページリンクの表示を待ちます..."))
+		// ログイン後の汎用的な待機条件として、フッターが表示されるのを待つ
+		actions = append(actions,
+			drv.WaitVisible(`footer[data-global-footer="true"]`),
+		)
+	}
+
+	if err := runActions(loginCtx, actions...); err != nil {
+		loggerFromContext(ctx).Println(tr("ログイン後のページ遷移または要素の表示確認に失敗しました。デバッグ情報を保存します..."))
+		saveDebugSnapshot(ctx, drv, "login_failure")
+		return fmt.Errorf(tr("ログイン後の処理に失敗: %w"), err)
+	}
+	return nil
+}
+
+// passwordFromStdin は -password-stdin フラグの値
+var passwordFromStdin bool
+
+// keyringServiceName はOSのキーリングにパスワードを保存する際のサービス名の既定値
+const keyringServiceName = "yamap-auto-domo"
+
+// resolvePassword はログインに使うパスワードを取得し、ログなどから伏せる値として登録する。
+func resolvePassword(email string) (string, error) {
+	password, err := lookupPassword(email)
+	redaction.add(password)
+	return password, err
+}
+
+// lookupPassword はパスワードを取得する。
+// -password-stdin が指定されていれば標準入力から、YAMAP_PASSWORD が設定されていればその値を使い、
+// どちらもなく YAMAP_PASSWORD_KEYRING が設定されている場合はOSのキーリングから email をアカウント名として取得する。
+func lookupPassword(email string) (string, error) {
+	if passwordFromStdin {
+		log.Println(tr("標準入力からパスワードを読み込みます..."))
+		return promptLine("")
+	}
+	if password := os.Getenv("YAMAP_PASSWORD"); password != "" {
+		return password, nil
+	}
+	if service := os.Getenv("YAMAP_PASSWORD_KEYRING"); service != "" {
+		if service == "1" || service == "true" {
+			service = keyringServiceName
+		}
+		log.Printf(tr("OSのキーリング (サービス名: %s) からパスワードを取得します..."), service)
+		return readKeyring(service, email)
+	}
+	return "", nil
+}
+
+// readKeyring はOS標準の資格情報ストアからパスワードを読み出す。
+// Linux は Secret Service (secret-tool)、macOS はキーチェーン (security)、
+// Windows は %APPDATA%\yamap-auto-domo\<サービス名>.dpapi に保存したDPAPI暗号化済みのパスワードを使用する。
+func readKeyring(service, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "windows":
+		path := filepath.Join(os.Getenv("APPDATA"), keyringServiceName, service+".dpapi")
+		script := fmt.Sprintf(`Add-Type -AssemblyName System.Security; `+
+			`$b = [Convert]::FromBase64String((Get-Content -Raw %s).Trim()); `+
+			`[Text.Encoding]::UTF8.GetString([Security.Cryptography.ProtectedData]::Unprotect($b, $null, 'CurrentUser'))`,
+			"'"+strings.ReplaceAll(path, "'", "''")+"'")
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		return "", fmt.Errorf(tr("このOS (%s) のキーリングには対応していません"), runtime.GOOS)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf(tr("キーリングからの取得に失敗 (%s): %w"), cmd.Path, err)
+	}
+	password := strings.TrimRight(string(out), "\r\n")
+	if password == "" {
+		return "", fmt.Errorf(tr("キーリングにパスワードが登録されていません (サービス: %s, アカウント: %s)"), service, account)
+	}
+	return password, nil
+}
+
+// ssoProvider はSSOプロバイダのログインフォームの構成を表す
+type ssoProvider struct {
+	// buttonText はYAMAPのログインページにあるプロバイダボタンの表示文言
+	buttonText string
+	// steps はプロバイダのフォームを入力する順序。入力欄ごとに、入力後に押すボタンを指定する
+	steps []ssoStep
+	// totp はワンタイムパスワードの入力欄。YAMAP_TOTP_SECRET が設定されていて、この欄が表示された場合に入力する
+	totp *ssoStep
+}
+
+type ssoStep struct {
+	input  string
+	submit string
+	// secret が true の場合はパスワード、false の場合はメールアドレスを入力する
+	secret bool
+}
+
+var ssoProviders = map[string]ssoProvider{
+	"google": {
+		buttonText: "Google",
+		steps: []ssoStep{
+			{input: `input[type="email"]`, submit: `#identifierNext button, #identifierNext`},
+			{input: `input[type="password"][name="Passwd"], input[type="password"]`, submit: `#passwordNext button, #passwordNext`, secret: true},
+		},
+		totp: &ssoStep{input: `input[name="totpPin"]`, submit: `#totpNext button, #totpNext`},
+	},
+	"apple": {
+		buttonText: "Apple",
+		steps: []ssoStep{
+			{input: `#account_name_text_field`, submit: `#sign-in`},
+			{input: `#password_text_field`, submit: `#sign-in`, secret: true},
+		},
+	},
+}
+
+// loginWithSSO はGoogle/AppleのSSOでログインする。
+// YAMAPのログインページでプロバイダのボタンを押し、プロバイダのフォームに email/password を入力して
+// YAMAPへリダイレクトされるまで待機する。ポップアップで開かれる場合も同じタブで遷移させる。
+func loginWithSSO(ctx context.Context, method, email, password string) error {
+	provider := ssoProviders[method]
+	drv := driverFromContext(ctx)
+
+	loggerFromContext(ctx).Printf(tr("ログインページに移動し、%sでログインします..."), provider.buttonText)
+	ssoCtx, cancel := context.WithTimeout(ctx, 120*time.Second)
+	defer cancel()
+
+	// window.open を上書きし、ポップアップではなく同じタブでプロバイダのページへ遷移させる
+	clickProvider := fmt.Sprintf(`(() => {
+		window.open = (url) => { if (url) { window.location.href = url; } return null; };
+		const text = %s;
+		const el = Array.from(document.querySelectorAll("a, button")).find(e => e.textContent.includes(text));
+		if (!el) return false;
+		el.click();
+		return true;
+	})()`, jsString(provider.buttonText))
+
+	var clicked bool
+	if err := runActions(ssoCtx,
+		drv.Navigate("https://yamap.com/login"),
+		drv.WaitVisible(`input[name="email"]`),
+		drv.Evaluate(clickProvider, &clicked),
+	); err != nil {
+		return fmt.Errorf(tr("%sログインボタンのクリックに失敗: %w"), provider.buttonText, err)
+	}
+	if !clicked {
+		return fmt.Errorf(tr("ログインページに%sログインボタンが見つかりませんでした"), provider.buttonText)
+	}
+
+	for _, step := range provider.steps {
+		value := email
+		if step.secret {
+			value = password
+		}
+		if err := runActions(ssoCtx,
+			drv.WaitVisible(step.input),
+			drv.SendKeys(step.input, value),
+			drv.Click(step.submit),
+		); err != nil {
+			return fmt.Errorf(tr("%sのログインフォーム入力に失敗 (%s): %w"), provider.buttonText, step.input, err)
+		}
+	}
+
+	if secret := os.Getenv("YAMAP_TOTP_SECRET"); secret != "" && provider.totp != nil {
+		// ワンタイムパスワードの入力欄が表示されるか、YAMAPへ戻るまで待つ
+		var needTOTP bool
+		waitTOTP := fmt.Sprintf(`document.querySelector(%s) !== null || window.location.hostname === "yamap.com"`, jsString(provider.totp.input))
+		if err := runActions(ssoCtx,
+			drv.Poll(waitTOTP, 20*time.Second),
+			drv.Evaluate(fmt.Sprintf(`document.querySelector(%s) !== null`, jsString(provider.totp.input)), &needTOTP),
+		); err == nil && needTOTP {
+			code, err := totpCode(secret, time.Now())
+			if err != nil {
+				return fmt.Errorf(tr("ワンタイムパスワードの生成に失敗: %w"), err)
+			}
+			loggerFromContext(ctx).Println(tr("ワンタイムパスワードを入力します..."))
+			if err := runActions(ssoCtx,
+				drv.WaitVisible(provider.totp.input),
+				drv.SendKeys(provider.totp.input, code),
+				drv.Click(provider.totp.submit),
+			); err != nil {
+				return fmt.Errorf(tr("ワンタイムパスワードの入力に失敗: %w"), err)
+			}
+		}
+	}
+
+	loggerFromContext(ctx).Println(tr("YAMAPへのリダイレクトを待機します... (ワンタイムパスワード以外の2段階認証が有効な場合はここで失敗します)"))
+	if err := runActions(ssoCtx,
+		drv.Poll(`window.location.hostname === "yamap.com" && document.readyState === "complete"`, 90*time.Second),
+	); err != nil {
+		return fmt.Errorf(tr("%sログイン後にYAMAPへ戻りませんでした: %w"), provider.buttonText, err)
+	}
+	return nil
+}
+
+// totpCode はBase32でエンコードされたシークレットからRFC 6238のワンタイムパスワード (30秒, 6桁, SHA-1) を生成する
+func totpCode(secret string, now time.Time) (string, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return "", fmt.Errorf(tr("TOTPシークレットの形式が不正です: %w"), err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
@@ -0,0 +1,64 @@
+package yamap
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret はRFC 6238 付録B (SHA-1) のシークレット "12345678901234567890" をBase32でエンコードしたもの
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestTOTPCodeRFC6238 はRFC 6238 付録BのSHA-1のテストベクトルと同じコードになることを確かめる。
+// 付録Bは8桁のため、6桁のコードはその下6桁になる
+func TestTOTPCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string // 付録Bの8桁の値の下6桁
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+// TestTOTPCodeRFC4226 はRFC 4226 付録DのHOTPの値 (カウンター0から9) と、30秒ごとのコードが一致することを確かめる
+func TestTOTPCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, w := range want {
+		// 30秒の区切りの中ならどの時刻でも同じコードになる
+		for _, offset := range []int64{0, 29} {
+			got, err := totpCode(rfc6238Secret, time.Unix(int64(counter)*30+offset, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != w {
+				t.Errorf("counter %d (+%ds) = %s, want %s", counter, offset, got, w)
+			}
+		}
+	}
+}
+
+func TestTOTPCodeSecretFormat(t *testing.T) {
+	now := time.Unix(59, 0)
+	// 認証アプリの表示のように小文字・空白区切り・パディング付きで入力しても同じシークレットとして扱う
+	for _, secret := range []string{"gezd gnbv gy3t qojq gezd gnbv gy3t qojq", rfc6238Secret + "===="} {
+		if got, err := totpCode(secret, now); err != nil || got != "287082" {
+			t.Errorf("totpCode(%q) = %s, %v; want 287082", secret, got, err)
+		}
+	}
+	if _, err := totpCode("not base32!", now); err == nil {
+		t.Error("totpCode accepted an invalid secret")
+	}
+}
@@ -0,0 +1,221 @@
+package yamap
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notificationTypes は notifications-export で通知を分類する種類。いずれにも当てはまらない通知は other とする
+const notificationTypes = "reaction, comment, follow, domo, other"
+
+// notificationExport は notifications-export で書き出す通知の一覧
+type notificationExport struct {
+	CollectedAt   time.Time            `json:"collected_at"`
+	Notifications []notificationRecord `json:"notifications"`
+}
+
+// notificationRecord は通知1件分。UserID は通知の相手、ActivityURL は通知の対象の活動日記 (ない場合は空)
+type notificationRecord struct {
+	ID          string `json:"id,omitempty"`
+	Type        string `json:"type"`
+	UserID      int64  `json:"user_id,omitempty"`
+	UserName    string `json:"user_name,omitempty"`
+	ActivityURL string `json:"activity_url,omitempty"`
+	Text        string `json:"text"`
+	// NotifiedAt は通知の日時。ページに日時の属性がない場合は「3時間前」などの表示のまま
+	NotifiedAt string `json:"notified_at,omitempty"`
+}
+
+// notificationEntriesScript は通知一覧に表示されている通知を取り出すスクリプト。
+// 他の通知を含まない、ユーザーへのリンクを持つ要素を通知1件とみなす
+const notificationEntriesScript = `(() => {
+	const candidates = Array.from(document.querySelectorAll('main li, main article, main [class*="NotificationItem"]'))
+		.filter(el => el.querySelector('a[href^="/users/"]'));
+	return candidates.filter(el => !candidates.some(c => c !== el && el.contains(c))).map(el => {
+		const user = el.querySelector('a[href^="/users/"]');
+		const activity = el.querySelector('a[href^="/activities/"]');
+		const time = el.querySelector("time");
+		const avatar = user.querySelector("img");
+		return {
+			id: el.dataset.id || el.dataset.notificationId || el.id || "",
+			kind: el.dataset.type || el.dataset.notificationType || String(el.className || ""),
+			text: (el.innerText || "").replace(/\s+/g, " ").trim(),
+			user: user.getAttribute("href"),
+			user_name: (user.textContent || "").trim() || (avatar ? avatar.getAttribute("alt") || "" : ""),
+			activity: activity ? (activity.getAttribute("href").match(/^\/activities\/\d+/) || [""])[0] : "",
+			time: time ? time.getAttribute("datetime") || time.textContent.trim() : "",
+		};
+	});
+})()`
+
+// notificationType は通知の要素の属性・クラス名と本文から通知の種類を判定する
+func notificationType(kind, text string) string {
+	s := strings.ToLower(kind + " " + text)
+	switch {
+	case strings.Contains(s, "domo"):
+		return "domo"
+	case strings.Contains(s, "comment") || strings.Contains(s, "コメント"):
+		return "comment"
+	case strings.Contains(s, "follow") || strings.Contains(s, "フォロー"):
+		return "follow"
+	case strings.Contains(s, "reaction") || strings.Contains(s, "emoji") || strings.Contains(s, "リアクション"):
+		return "reaction"
+	}
+	return "other"
+}
+
+// runNotificationsExport は自分の通知一覧をスクロールして NOTIFICATIONS_MAX 件 (既定値 200) までの通知を集め、
+// 種類・相手・対象の活動日記に整理して NOTIFICATIONS_FILE (既定値 notifications.json) に書き出す。拡張子が .csv の場合はCSVで書き出す
+func runNotificationsExport() error {
+	log.Println(tr("--- プログラム開始 (notifications-export) ---"))
+	startTime := time.Now()
+	maxNotifications := 200
+	if v := os.Getenv("NOTIFICATIONS_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("NOTIFICATIONS_MAXの値が不正です: %s"), v)
+		}
+		maxNotifications = n
+	}
+	path := os.Getenv("NOTIFICATIONS_FILE")
+	if path == "" {
+		path = "notifications.json"
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	export := notificationExport{CollectedAt: time.Now()}
+	export.Notifications, err = collectNotifications(ctx, maxNotifications)
+	if err != nil {
+		if len(export.Notifications) == 0 {
+			return fmt.Errorf(tr("通知一覧の取得に失敗しました: %w"), err)
+		}
+		loggerFromContext(ctx).Printf(tr("通知一覧の収集中にエラーが発生しました。取得できた分を書き出します: %v"), err)
+	}
+	counts := make(map[string]int)
+	for _, n := range export.Notifications {
+		counts[n.Type]++
+	}
+	var parts []string
+	for _, t := range strings.Split(notificationTypes, ", ") {
+		if counts[t] > 0 {
+			parts = append(parts, fmt.Sprintf("%s: %d", t, counts[t]))
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の通知を取得しました (%s)。"), len(export.Notifications), strings.Join(parts, ", "))
+	if err := export.write(path); err != nil {
+		return fmt.Errorf(tr("通知の書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("通知を %s に書き出しました。"), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// collectNotifications は通知一覧ページをスクロールし、表示された通知を新しい順に最大 maxNotifications 件返す
+func collectNotifications(ctx context.Context, maxNotifications int) ([]notificationRecord, error) {
+	drv := driverFromContext(ctx)
+	if err := runActions(ctx, drv.Navigate("https://yamap.com/notifications"), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+		return nil, err
+	}
+
+	var records []notificationRecord
+	seen := make(map[string]struct{})
+	for noNew := 0; len(records) < maxNotifications && noNew < 3; {
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("最大実行時間に達したため、通知の収集を終了します。"))
+			break
+		}
+		var entries []struct {
+			ID       string `json:"id"`
+			Kind     string `json:"kind"`
+			Text     string `json:"text"`
+			User     string `json:"user"`
+			UserName string `json:"user_name"`
+			Activity string `json:"activity"`
+			Time     string `json:"time"`
+		}
+		if err := runActions(ctx, drv.Evaluate(notificationEntriesScript, &entries)); err != nil {
+			return records, err
+		}
+		before := len(records)
+		for _, e := range entries {
+			r := notificationRecord{ID: e.ID, Type: notificationType(e.Kind, e.Text), UserID: userIDFromPath(e.User), UserName: e.UserName, Text: e.Text, NotifiedAt: e.Time}
+			if e.Activity != "" {
+				r.ActivityURL = "https://yamap.com" + e.Activity
+			}
+			key := r.ID
+			if key == "" {
+				key = strings.Join([]string{r.Type, strconv.FormatInt(r.UserID, 10), r.ActivityURL, r.Text}, "\x00")
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			records = append(records, r)
+			if len(records) >= maxNotifications {
+				break
+			}
+		}
+		if len(records) == before {
+			noNew++
+		} else {
+			noNew = 0
+			status.markStep()
+		}
+		if len(records) >= maxNotifications {
+			break
+		}
+		if err := runActions(ctx, scrollForMore(drv, "("+notificationEntriesScript+").length")); err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+// write は通知の一覧をファイルに書き出す。拡張子が .csv の場合は通知ごとの行を見出しの行付きで、それ以外はJSONで上書きする
+func (e notificationExport) write(path string) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"collected_at", "id", "type", "user_id", "user_name", "activity_url", "text", "notified_at"})
+	collectedAt := e.CollectedAt.Format(time.RFC3339)
+	for _, n := range e.Notifications {
+		userID := ""
+		if n.UserID != 0 {
+			userID = strconv.FormatInt(n.UserID, 10)
+		}
+		w.Write([]string{collectedAt, n.ID, n.Type, userID, n.UserName, n.ActivityURL, n.Text, n.NotifiedAt})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,106 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// notify は NOTIFY_WEBHOOK_URL が設定されている場合に、メッセージをJSONでPOSTする。
+// SlackとDiscordのIncoming Webhookの両方で表示されるよう、text と content に同じ内容を入れる。
+func notify(ctx context.Context, level, message string) {
+	webhookURL := os.Getenv("NOTIFY_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+	text := fmt.Sprintf("[yamap-auto-domo %s] %s: %s", runID, level, message)
+	body, _ := json.Marshal(map[string]string{"text": text, "content": text, "run_id": runID})
+
+	// 実行中止の通知は呼び出し元のコンテキストがキャンセルされていても送信する
+	reqCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("通知の作成に失敗しました: %v"), err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("通知の送信に失敗しました: %v"), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		loggerFromContext(ctx).Printf(tr("通知の送信に失敗しました: ステータス %d"), resp.StatusCode)
+	}
+}
+
+// reactionEvent は REACTION_WEBHOOK_URL に送る投稿1件分の処理結果
+type reactionEvent struct {
+	// Event は "reacted" (成功)、"failed" (失敗)、"skipped" (閲覧できない投稿) のいずれか
+	Event      string `json:"event"`
+	Action     string `json:"action"`
+	URL        string `json:"url"`
+	AuthorID   int64  `json:"author_id,omitempty"`
+	AuthorName string `json:"author_name,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Emoji      string `json:"emoji,omitempty"`
+	Error      string `json:"error,omitempty"`
+	RunID      string `json:"run_id"`
+	At         string `json:"at"`
+}
+
+// postReactionEvent は REACTION_WEBHOOK_URL が設定されている場合に、投稿1件の処理結果をJSONでPOSTする。
+// 外部のシステムがほぼリアルタイムに結果を受け取れるよう投稿ごとに送信し、送信に失敗しても処理は継続する。
+func postReactionEvent(ctx context.Context, activity ActivityInfo, sent string, err error) {
+	webhookURL := os.Getenv("REACTION_WEBHOOK_URL")
+	if webhookURL == "" {
+		return
+	}
+	ev := reactionEvent{
+		Event:      "reacted",
+		Action:     status.report().Action,
+		URL:        activity.URL,
+		AuthorID:   activity.AuthorID,
+		AuthorName: activity.AuthorName,
+		Title:      activity.Title,
+		Emoji:      sent,
+		RunID:      runID,
+		At:         time.Now().Format(time.RFC3339),
+	}
+	var skipErr *skipError
+	switch {
+	case errors.As(err, &skipErr):
+		ev.Event = "skipped"
+		ev.Error = skipErr.reason
+	case err != nil:
+		ev.Event = "failed"
+		ev.Error = err.Error()
+	}
+	body, _ := json.Marshal(ev)
+
+	// 中断による失敗も通知できるよう、呼び出し元のコンテキストがキャンセルされていても送信する
+	reqCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	req, reqErr := http.NewRequestWithContext(reqCtx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if reqErr != nil {
+		loggerFromContext(ctx).Printf(tr("リアクションのWebhookの作成に失敗しました: %v"), reqErr)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, reqErr := http.DefaultClient.Do(req)
+	if reqErr != nil {
+		loggerFromContext(ctx).Printf(tr("リアクションのWebhookの送信に失敗しました: %v"), reqErr)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		loggerFromContext(ctx).Printf(tr("リアクションのWebhookの送信に失敗しました: ステータス %d"), resp.StatusCode)
+	}
+}
@@ -0,0 +1,425 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mathrand "math/rand/v2"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// pacer は観測したページの応答時間に応じて投稿間の待機時間を調整する。
+// サイトが速いときは待機を短く、遅いときは長くすることで、無駄な待ち時間とサーバーへの負荷を両立させる。
+type pacer struct {
+	mu       sync.Mutex
+	minDelay time.Duration
+	maxDelay time.Duration
+	factor   float64
+	// latency は応答時間の指数移動平均。まだ観測していない場合は0
+	latency time.Duration
+	// slots は -spread で割り当てた2件目以降の処理の予定時刻。next 番目が次の予定
+	slots []time.Time
+	next  int
+}
+
+// spreadWindow は -spread フラグで指定された、処理を分散させる時間幅。0 の場合は分散せずに続けて処理する
+var spreadWindow time.Duration
+
+// spreadOver は -spread が指定されている場合に、n 件の処理を spreadWindow の中のランダムな時刻に割り当てる。
+// 最初の1件はすぐに処理し、以降の wait は割り当てた時刻まで待つ
+func (p *pacer) spreadOver(ctx context.Context, n int) {
+	if spreadWindow <= 0 || n <= 1 {
+		return
+	}
+	start := time.Now()
+	offsets := make([]time.Duration, n-1)
+	for i := range offsets {
+		offsets[i] = mathrand.N(spreadWindow)
+	}
+	slices.Sort(offsets)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.slots = make([]time.Time, len(offsets))
+	for i, off := range offsets {
+		p.slots[i] = start.Add(off)
+	}
+	p.next = 0
+	loggerFromContext(ctx).Printf(tr("%d件の処理を %s にわたってランダムな間隔で分散させます (最後の予定: %s)。"), n, spreadWindow, p.slots[len(p.slots)-1].Local().Format("15:04:05"))
+}
+
+// pacerSmoothing は指数移動平均で新しい観測値に与える重み
+const pacerSmoothing = 0.3
+
+// pace はプロセス全体で共有する待機時間の調整器。.env の読み込み後に main で初期化する
+var pace *pacer
+
+// requestLimit はプロセス全体で共有するYAMAPへのリクエストの上限。REQUEST_RATE_LIMIT が未設定の場合は nil
+var requestLimit *rateLimiter
+
+// rateLimiter はトークンバケットでリクエストの頻度を制限する。トークンは毎秒 rate 個ずつ burst 個まで貯まり、
+// リクエストごとに1個使う
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// yamapRequestPatterns はChromeで上限の対象にするリクエスト。画像などの静的ファイルは含めず、
+// ページの読み込みとAPIの呼び出し (XHR・fetch) だけを対象にする
+var yamapRequestPatterns = func() []*fetch.RequestPattern {
+	var patterns []*fetch.RequestPattern
+	for _, url := range []string{"*://yamap.com/*", "*://*.yamap.com/*"} {
+		for _, typ := range []network.ResourceType{network.ResourceTypeDocument, network.ResourceTypeXHR, network.ResourceTypeFetch} {
+			patterns = append(patterns, &fetch.RequestPattern{URLPattern: url, ResourceType: typ})
+		}
+	}
+	return patterns
+}()
+
+// newRateLimiterFromEnv は REQUEST_RATE_LIMIT (1分あたりのリクエスト数) と REQUEST_RATE_BURST (連続して送れる数、既定値5) から
+// rateLimiter を作成する。REQUEST_RATE_LIMIT が未設定の場合は nil を返す
+func newRateLimiterFromEnv() (*rateLimiter, error) {
+	v := os.Getenv("REQUEST_RATE_LIMIT")
+	if v == "" {
+		return nil, nil
+	}
+	perMinute, err := strconv.ParseFloat(v, 64)
+	if err != nil || perMinute <= 0 {
+		return nil, fmt.Errorf(tr("REQUEST_RATE_LIMITの値が不正です: %s"), v)
+	}
+	burst := 5
+	if v := os.Getenv("REQUEST_RATE_BURST"); v != "" {
+		if burst, err = strconv.Atoi(v); err != nil || burst < 1 {
+			return nil, fmt.Errorf(tr("REQUEST_RATE_BURSTの値が不正です: %s"), v)
+		}
+	}
+	return &rateLimiter{rate: perMinute / 60, burst: float64(burst), tokens: float64(burst), last: time.Now()}, nil
+}
+
+// wait はトークンが貯まるまで待ってから1個使う。上限が設定されていない場合はすぐに戻る
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// interceptRequests はタブのリクエストを送信前に一時停止させ、handlePausedRequest で処理できるようにする。
+// REQUEST_RATE_LIMIT の上限の対象 (yamapRequestPatterns) と、設定ファイルの block_requests で遮断するリクエストが対象になる。
+// 収集中のスクロールで読み込まれるフィードなど、ページの中から送られるリクエストも対象になる
+func interceptRequests(ctx context.Context) {
+	var patterns []*fetch.RequestPattern
+	if requestLimit != nil {
+		patterns = append(patterns, yamapRequestPatterns...)
+	}
+	patterns = append(patterns, config.BlockRequests.patterns...)
+	if fixtureReplay != nil {
+		// -replay ではyamap.comをフィクスチャに差し替え、それ以外はオフラインとして失敗させるため、すべてのリクエストを止める
+		patterns = []*fetch.RequestPattern{{URLPattern: "*"}}
+	}
+	if len(patterns) == 0 {
+		return
+	}
+	if err := chromedp.Run(ctx, fetch.Enable().WithPatterns(patterns)); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: リクエストの上限と遮断を設定できません: %v"), err)
+	}
+}
+
+// handlePausedRequest は一時停止したリクエストを、block_requests に該当すれば遮断し、-replay ではフィクスチャに差し替え、
+// それ以外はYAMAPへのリクエストであればトークンが貯まってから再開する
+func handlePausedRequest(ctx context.Context, ev interface{}) {
+	paused, ok := ev.(*fetch.EventRequestPaused)
+	if !ok {
+		return
+	}
+	// イベントのリスナーの中ではCDPのコマンドの応答を待てないため、別のゴルーチンで処理する
+	go func() {
+		c := chromedp.FromContext(ctx)
+		if c == nil || c.Target == nil {
+			return
+		}
+		exec := cdp.WithExecutor(ctx, c.Target)
+		if config.BlockRequests.blocks(paused.Request.URL, paused.ResourceType) {
+			fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(exec)
+			return
+		}
+		if fixtureReplay != nil {
+			switch {
+			case strings.HasPrefix(paused.Request.URL, fixtureReplay.base):
+				fetch.ContinueRequest(paused.RequestID).Do(exec)
+			case fixturePath(fixtureReplay.dir, paused.Request.URL) != "":
+				fetch.ContinueRequest(paused.RequestID).WithURL(fixtureReplay.localURL(paused.Request.URL)).Do(exec)
+			default:
+				fetch.FailRequest(paused.RequestID, network.ErrorReasonInternetDisconnected).Do(exec)
+			}
+			return
+		}
+		if isYAMAPRequest(paused.Request.URL, paused.ResourceType) {
+			if err := requestLimit.wait(ctx); err != nil {
+				return
+			}
+		}
+		fetch.ContinueRequest(paused.RequestID).Do(exec)
+	}()
+}
+
+// isYAMAPRequest はリクエストが yamapRequestPatterns に該当するかを返す
+func isYAMAPRequest(rawURL string, typ network.ResourceType) bool {
+	switch typ {
+	case network.ResourceTypeDocument, network.ResourceTypeXHR, network.ResourceTypeFetch:
+	default:
+		return false
+	}
+	u, err := neturl.Parse(rawURL)
+	return err == nil && hostWithin(u.Hostname(), "yamap.com")
+}
+
+// hostWithin は host が domain そのものかそのサブドメインかを返す
+func hostWithin(host, domain string) bool {
+	host = strings.ToLower(host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// blockRequestsConfig はブラウザが送信する前に遮断するリクエスト。設定ファイルの block_requests に書く。
+// 解析・広告・地図のタイルなどの読み込みを止め、通信量とページの読み込み時間を減らす (Chromeのみ)
+type blockRequestsConfig struct {
+	// Presets は blockRequestPresets の名前 (analytics, ads, map_tiles, widgets)
+	Presets []string `json:"presets"`
+	// Domains は遮断するドメイン。サブドメインも対象になる
+	Domains []string `json:"domains"`
+	// URLs は遮断するURLのパターン。* は任意の文字列、? は任意の1文字に一致する
+	URLs []string `json:"urls"`
+	// ResourceTypes は遮断するリソースの種類 (Image, Media, Font など、CDPの Network.ResourceType)
+	ResourceTypes []string `json:"resource_types"`
+
+	domains  []string
+	urls     []*regexp.Regexp
+	types    map[network.ResourceType]bool
+	patterns []*fetch.RequestPattern
+}
+
+// blockRequestPresets は block_requests.presets で指定できる、よく遮断するドメインとURLのパターンのまとめ
+var blockRequestPresets = map[string]struct {
+	domains []string
+	urls    []string
+}{
+	"analytics": {domains: []string{"google-analytics.com", "analytics.google.com", "googletagmanager.com", "clarity.ms", "hotjar.com", "mixpanel.com", "segment.io", "sentry.io", "newrelic.com", "nr-data.net"}},
+	"ads":       {domains: []string{"doubleclick.net", "googlesyndication.com", "googleadservices.com", "adservice.google.com", "amazon-adsystem.com", "criteo.com", "criteo.net", "taboola.com", "outbrain.com", "adnxs.com"}},
+	"map_tiles": {domains: []string{"cyberjapandata.gsi.go.jp", "tile.openstreetmap.org", "api.mapbox.com", "tiles.mapbox.com"}, urls: []string{"*/tiles/*.png*", "*/tiles/*.pbf*", "*/tiles/*.webp*"}},
+	"widgets":   {domains: []string{"connect.facebook.net", "platform.twitter.com", "syndication.twitter.com", "platform.instagram.com", "d.line-scdn.net", "youtube.com", "ytimg.com"}},
+}
+
+// blockableResourceTypes は block_requests.resource_types に指定できるリソースの種類。
+// ページ本体 (Document) と、ページの動作に必要な Script・XHR・Fetch は遮断できない
+var blockableResourceTypes = []network.ResourceType{
+	network.ResourceTypeImage, network.ResourceTypeMedia, network.ResourceTypeFont, network.ResourceTypeStylesheet,
+	network.ResourceTypeTextTrack, network.ResourceTypeWebSocket, network.ResourceTypeManifest,
+	network.ResourceTypePing, network.ResourceTypeCSPViolationReport, network.ResourceTypeOther,
+}
+
+// validate は block_requests の値を検証し、遮断の判定に使うパターンを作る
+func (c *blockRequestsConfig) validate(add func(string, error)) {
+	var domains, urls []string
+	c.domains, c.urls, c.patterns = nil, nil, nil
+	for i, name := range c.Presets {
+		preset, ok := blockRequestPresets[name]
+		if !ok {
+			add(fmt.Sprintf("block_requests.presets[%d]", i), fmt.Errorf(tr("block_requests.presets に不明なプリセット '%s' が指定されました (analytics, ads, map_tiles, widgets)"), name))
+			continue
+		}
+		domains = append(domains, preset.domains...)
+		urls = append(urls, preset.urls...)
+	}
+	for i, d := range c.Domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "."))
+		key := fmt.Sprintf("block_requests.domains[%d]", i)
+		switch {
+		case d == "" || strings.ContainsAny(d, "/*?: "):
+			add(key, fmt.Errorf(tr("block_requests.domains にはドメイン名だけを指定してください: %q"), c.Domains[i]))
+		case hostWithin("yamap.com", d):
+			// サブドメイン (地図のタイルの配信元など) は遮断できるが、yamap.com 自体を遮断するとページを読み込めない
+			add(key, fmt.Errorf(tr("block_requests.domains に yamap.com 自体は指定できません: %s"), d))
+		default:
+			domains = append(domains, d)
+		}
+	}
+	for i, p := range c.URLs {
+		if strings.TrimSpace(p) == "" {
+			add(fmt.Sprintf("block_requests.urls[%d]", i), errors.New(tr("block_requests.urls に空のパターンが指定されています")))
+			continue
+		}
+		urls = append(urls, p)
+	}
+	c.types = map[network.ResourceType]bool{}
+	for i, t := range c.ResourceTypes {
+		idx := slices.IndexFunc(blockableResourceTypes, func(r network.ResourceType) bool { return strings.EqualFold(string(r), t) })
+		if idx < 0 {
+			add(fmt.Sprintf("block_requests.resource_types[%d]", i), fmt.Errorf(tr("block_requests.resource_types に遮断できないリソースの種類 '%s' が指定されました (%s)"), t, joinResourceTypes(blockableResourceTypes)))
+			continue
+		}
+		typ := blockableResourceTypes[idx]
+		c.types[typ] = true
+		c.patterns = append(c.patterns, &fetch.RequestPattern{URLPattern: "*", ResourceType: typ})
+	}
+	for _, d := range domains {
+		c.domains = append(c.domains, d)
+		c.patterns = append(c.patterns, &fetch.RequestPattern{URLPattern: "*://" + d + "/*"}, &fetch.RequestPattern{URLPattern: "*://*." + d + "/*"})
+	}
+	for _, p := range urls {
+		// Fetch.RequestPattern と同じく * は任意の文字列、? は任意の1文字に一致させる
+		expr := regexp.QuoteMeta(p)
+		expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+		c.urls = append(c.urls, regexp.MustCompile("^"+expr+"$"))
+		c.patterns = append(c.patterns, &fetch.RequestPattern{URLPattern: p})
+	}
+}
+
+// joinResourceTypes はリソースの種類をカンマ区切りで返す
+func joinResourceTypes(types []network.ResourceType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+// active は遮断するリクエストが設定されているかを返す
+func (c *blockRequestsConfig) active() bool {
+	return len(c.patterns) > 0
+}
+
+// blocks はリクエストを遮断するかを返す
+func (c *blockRequestsConfig) blocks(rawURL string, typ network.ResourceType) bool {
+	if c.types[typ] {
+		return true
+	}
+	for _, re := range c.urls {
+		if re.MatchString(rawURL) {
+			return true
+		}
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	for _, d := range c.domains {
+		if hostWithin(u.Hostname(), d) {
+			return true
+		}
+	}
+	return false
+}
+
+// newPacerFromEnv は PACING_MIN_DELAY, PACING_MAX_DELAY, PACING_FACTOR から pacer を作成する。
+// 既定値は最小2秒 (従来の固定待機時間)、最大20秒、係数1.0 (平均応答時間と同じだけ待つ)。
+func newPacerFromEnv(ctx context.Context) *pacer {
+	p := &pacer{minDelay: 2 * time.Second, maxDelay: 20 * time.Second, factor: 1.0}
+	if v := os.Getenv("PACING_MIN_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			p.minDelay = d
+		} else {
+			loggerFromContext(ctx).Printf(tr("警告: PACING_MIN_DELAYの値が不正です。既定値 %s を使用します: %v"), p.minDelay, err)
+		}
+	}
+	if v := os.Getenv("PACING_MAX_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			p.maxDelay = d
+		} else {
+			loggerFromContext(ctx).Printf(tr("警告: PACING_MAX_DELAYの値が不正です。既定値 %s を使用します: %v"), p.maxDelay, err)
+		}
+	}
+	if v := os.Getenv("PACING_FACTOR"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			p.factor = f
+		} else {
+			loggerFromContext(ctx).Printf(tr("警告: PACING_FACTORの値が不正です。既定値 %.1f を使用します"), p.factor)
+		}
+	}
+	if p.maxDelay < p.minDelay {
+		p.maxDelay = p.minDelay
+	}
+	return p
+}
+
+// observe はページの読み込みやUIの応答にかかった時間を記録する
+func (p *pacer) observe(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.latency == 0 {
+		p.latency = d
+		return
+	}
+	p.latency = time.Duration(pacerSmoothing*float64(d) + (1-pacerSmoothing)*float64(p.latency))
+}
+
+// delay は次の投稿までの待機時間を返す
+func (p *pacer) delay() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d := time.Duration(float64(p.latency) * p.factor)
+	if d < p.minDelay {
+		d = p.minDelay
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
+
+// wait は次の投稿までの待機を行う。コンテキストがキャンセルされた場合は即座に戻る。
+// spreadOver で予定時刻を割り当てている場合は、次の予定時刻まで待つ (最短でも PACING_MIN_DELAY)
+func (p *pacer) wait(ctx context.Context) {
+	p.waitScaled(ctx, 1)
+}
+
+// waitScaled は待機時間に scale を掛けて wait と同じ待機を行う。A/B 比較で戦略ごとに間隔を変えるために使う
+func (p *pacer) waitScaled(ctx context.Context, scale float64) {
+	d := time.Duration(float64(p.delay()) * scale)
+	p.mu.Lock()
+	latency := p.latency
+	if p.next < len(p.slots) {
+		at := p.slots[p.next]
+		p.next++
+		p.mu.Unlock()
+		if until := time.Until(at); until > d {
+			d = until
+		}
+		loggerFromContext(ctx).Printf(tr("次の投稿まで %s 待機します (-spread による予定: %s)"), d.Round(time.Second), at.Local().Format("15:04:05"))
+		sleepAction(d)(ctx)
+		return
+	}
+	p.mu.Unlock()
+	loggerFromContext(ctx).Printf(tr("次の投稿まで %s 待機します (平均応答時間: %s)"), d.Round(100*time.Millisecond), latency.Round(100*time.Millisecond))
+	sleepAction(d)(ctx)
+}
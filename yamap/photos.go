@@ -0,0 +1,216 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// photoExtensions は activity-photos で追加する画像ファイルの拡張子と、ページに渡すMIMEタイプ
+var photoExtensions = map[string]string{".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif", ".webp": "image/webp"}
+
+// photoFile は activity-photos で追加する画像ファイル
+type photoFile struct {
+	path    string
+	modTime time.Time
+}
+
+// photoCaptionData は photo_caption_template のテンプレートで参照できる値
+type photoCaptionData struct {
+	// FileName は拡張子を含むファイル名、Name は拡張子を除いた名前
+	FileName string
+	Name     string
+	// Index は追加する順の番号 (1から)、Total は追加する枚数
+	Index int
+	Total int
+	// ModTime はファイルの更新日時
+	ModTime time.Time
+}
+
+// activityPhotoCountScript は活動日記の編集ページに表示されている写真の枚数を返すスクリプト
+const activityPhotoCountScript = `document.querySelectorAll('main [class*="Photo"] img, main [class*="Image"] img').length`
+
+// activityPhotoInputSelector は活動日記の編集ページの写真のファイルの入力欄
+const activityPhotoInputSelector = `main input[type="file"][accept*="image"], main input[type="file"]`
+
+// lastCaptionScript は写真の説明の入力欄のうち最後のもの (直前に追加した写真の欄) に text を入力するスクリプト。見つからない場合は false
+func lastCaptionScript(text string) string {
+	return `(() => {` + planFormHelpers + `
+	const fields = document.querySelectorAll('main textarea[name*="caption"], main input[name*="caption"], main textarea[placeholder*="写真の説明"], main input[placeholder*="写真の説明"]');
+	if (fields.length === 0) return false;
+	__planSet(fields[fields.length - 1], ` + jsString(text) + `);
+	return true;
+})()`
+}
+
+// saveActivityScript は活動日記の編集フォームの保存ボタンをクリックするスクリプト
+const saveActivityScript = `(() => {
+	const buttons = Array.from(document.querySelectorAll('main button'));
+	const button = buttons.find(b => /保存|更新/.test(b.textContent) && !/下書き/.test(b.textContent)) || document.querySelector('main form button[type="submit"]');
+	if (!button || button.disabled) return false;
+	button.click();
+	return true;
+})()`
+
+// listPhotos は dir の画像ファイルを order (name はファイル名、mtime は更新日時の古い順) に並べて返す
+func listPhotos(dir, order string) ([]photoFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var photos []photoFile
+	for _, e := range entries {
+		if e.IsDir() || photoExtensions[strings.ToLower(filepath.Ext(e.Name()))] == "" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, photoFile{path: filepath.Join(dir, e.Name()), modTime: info.ModTime()})
+	}
+	switch order {
+	case "name":
+		// os.ReadDir はファイル名の順に返す
+	case "mtime":
+		sort.SliceStable(photos, func(i, j int) bool { return photos[i].modTime.Before(photos[j].modTime) })
+	default:
+		return nil, fmt.Errorf(tr("PHOTOS_ORDERの値が不正です: %s (name, mtime のいずれかを指定してください)"), order)
+	}
+	return photos, nil
+}
+
+// runActivityPhotos は PHOTOS_DIR の画像ファイルを、PHOTOS_ACTIVITY で指定した自分の活動日記に編集ページから1枚ずつ追加する。
+// 設定ファイルの photo_caption_template があれば写真ごとに説明を入力し、最後に保存する
+func runActivityPhotos() error {
+	log.Println(tr("--- プログラム開始 (activity-photos) ---"))
+	startTime := time.Now()
+	id := activityIDFromRef(os.Getenv("PHOTOS_ACTIVITY"))
+	if id == "" {
+		return errors.New(tr("activity-photos では PHOTOS_ACTIVITY に活動日記のIDかURLを指定してください"))
+	}
+	activityURL := "https://yamap.com/activities/" + id
+	dir := os.Getenv("PHOTOS_DIR")
+	if dir == "" {
+		return errors.New(tr("activity-photos では PHOTOS_DIR に写真のディレクトリを指定してください"))
+	}
+	order := os.Getenv("PHOTOS_ORDER")
+	if order == "" {
+		order = "name"
+	}
+	photos, err := listPhotos(dir, order)
+	if err != nil {
+		return fmt.Errorf(tr("写真の一覧の取得に失敗しました: %w"), err)
+	}
+	if len(photos) == 0 {
+		log.Printf(tr("%s に追加する写真がないため、何もせずに終了します。"), dir)
+		return nil
+	}
+	captions := make([]string, len(photos))
+	if config.photoCaptionTemplate != nil {
+		for i, p := range photos {
+			name := filepath.Base(p.path)
+			data := photoCaptionData{FileName: name, Name: strings.TrimSuffix(name, filepath.Ext(name)), Index: i + 1, Total: len(photos), ModTime: p.modTime}
+			var buf bytes.Buffer
+			if err := config.photoCaptionTemplate.Execute(&buf, data); err != nil {
+				return fmt.Errorf(tr("写真の説明のテンプレートの展開に失敗しました (%s): %w"), name, err)
+			}
+			captions[i] = strings.TrimSpace(buf.String())
+		}
+	}
+	log.Printf(tr("%d枚の写真を %s に追加します。"), len(photos), activityURL)
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	drv := driverFromContext(ctx)
+
+	editURL := activityURL + "/edit"
+	if err := runActions(ctx, drv.Navigate(editURL), drv.WaitVisible(`main form`), drv.WaitNetworkIdle()); err != nil {
+		saveDebugSnapshot(ctx, drv, "activity_photos")
+		return fmt.Errorf(tr("活動日記の編集ページの読み込みに失敗: %w"), err)
+	}
+	added := 0
+	for i, p := range photos {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		if err := addActivityPhoto(ctx, drv, p.path, captions[i]); err != nil {
+			saveDebugSnapshot(ctx, drv, "activity_photos")
+			return fmt.Errorf(tr("写真の追加に失敗しました (%s): %w"), p.path, err)
+		}
+		added++
+		status.markStep()
+		loggerFromContext(ctx).Printf(tr("写真を追加しました (%d/%d): %s"), i+1, len(photos), filepath.Base(p.path))
+	}
+	if added == 0 {
+		return nil
+	}
+
+	var saved bool
+	err = runActions(ctx, drv.Evaluate(saveActivityScript, &saved))
+	if err == nil && !saved {
+		err = errors.New(tr("活動日記の保存ボタンが見つかりません"))
+	}
+	if err == nil {
+		err = runActions(ctx, drv.WaitNavigatedAway("/activities/"+id+"/edit", 60*time.Second), drv.WaitNetworkIdle())
+	}
+	status.recordResult(err == nil, err)
+	if err != nil {
+		saveDebugSnapshot(ctx, drv, "activity_photos")
+		return fmt.Errorf(tr("活動日記の保存に失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d枚の写真を追加して保存しました: %s"), added, activityURL)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// addActivityPhoto は編集ページの写真の入力欄に画像ファイルを1枚設定し、ページに表示されるまで待ってから説明を入力する
+func addActivityPhoto(ctx context.Context, drv pageDriver, path, caption string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var before int
+	var ok bool
+	mimeType := photoExtensions[strings.ToLower(filepath.Ext(path))]
+	if err := runActions(ctx,
+		drv.Evaluate(activityPhotoCountScript, &before),
+		drv.Evaluate(setFileInputScript(activityPhotoInputSelector, filepath.Base(path), mimeType, data), &ok),
+	); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(tr("写真のファイルの入力欄が見つかりません"))
+	}
+	if err := runActions(ctx,
+		drv.Poll(fmt.Sprintf(`%s > %d`, activityPhotoCountScript, before), 60*time.Second),
+		drv.WaitNetworkIdle(),
+	); err != nil {
+		return fmt.Errorf(tr("写真のアップロードが終わりませんでした: %w"), err)
+	}
+	if caption == "" {
+		return nil
+	}
+	var found bool
+	if err := runActions(ctx, drv.Evaluate(lastCaptionScript(caption), &found)); err != nil {
+		return err
+	}
+	if !found {
+		loggerFromContext(ctx).Printf(tr("警告: 写真の説明の入力欄が見つからないため、説明を入力しません: %s"), filepath.Base(path))
+	}
+	return nil
+}
@@ -0,0 +1,639 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// climbingPlan は登山計画1件の情報
+type climbingPlan struct {
+	ID    int64  `json:"id"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	// StartDate と EndDate は入山日と下山日 (2006-01-02)。取得できない場合は空
+	StartDate string   `json:"start_date"`
+	EndDate   string   `json:"end_date"`
+	Route     []string `json:"route"`
+	Members   []string `json:"members"`
+}
+
+// planLinksScript は登山計画の一覧ページに表示されている登山計画のパスを重複なく取得するスクリプト
+const planLinksScript = `Array.from(new Set(Array.from(document.querySelectorAll('main a[href^="/plans/"]'))
+	.map(a => (a.getAttribute("href").match(/^\/plans\/\d+/) || [""])[0]).filter(Boolean)))`
+
+// planDetailScript は登山計画の詳細ページの NUXT データから日程・ルート・メンバーを取り出すスクリプト。
+// ストアの構成はページの実装により異なるため、計画らしいオブジェクトを候補の中から探し、見つからない項目は画面の表示から補う
+const planDetailScript = `(() => {
+	const nuxt = window.__NUXT__ || {};
+	const candidates = [];
+	if (nuxt.state && nuxt.state.plan) candidates.push(nuxt.state.plan.plan, nuxt.state.plan);
+	for (const d of (nuxt.data || [])) if (d) candidates.push(d.plan, d);
+	const p = candidates.find(c => c && typeof c === "object" && ("start_at" in c || "started_at" in c || "members" in c)) || {};
+	const date = v => {
+		if (!v) return "";
+		const d = typeof v === "number" ? new Date(v * 1000) : new Date(v);
+		if (isNaN(d)) return "";
+		return d.getFullYear() + "-" + String(d.getMonth() + 1).padStart(2, "0") + "-" + String(d.getDate()).padStart(2, "0");
+	};
+	const text = el => el.textContent.trim();
+	const points = p.route_points || p.checkpoints || p.landmarks || [];
+	let route = points.map(r => r && (r.name || (r.landmark && r.landmark.name))).filter(Boolean);
+	if (route.length === 0) route = Array.from(document.querySelectorAll('[class*="Route"] [class*="Name"]')).map(text).filter(Boolean);
+	let members = (p.members || []).map(m => m && (m.name || (m.user && m.user.name))).filter(Boolean);
+	if (members.length === 0) members = Array.from(document.querySelectorAll('[class*="Member"] a[href^="/users/"]')).map(text).filter(Boolean);
+	let start = date(p.start_at || p.started_at), end = date(p.end_at || p.finished_at);
+	if (!start) {
+		const times = Array.from(document.querySelectorAll("time[datetime]")).map(t => date(t.getAttribute("datetime"))).filter(Boolean);
+		start = times[0] || "";
+		end = times[times.length - 1] || "";
+	}
+	const heading = document.querySelector("h1");
+	return {
+		title: p.title || p.name || (heading ? text(heading) : document.title),
+		start_date: start,
+		end_date: end || start,
+		route: Array.from(new Set(route)),
+		members: Array.from(new Set(members)),
+	};
+})()`
+
+// runPlansExport は自分の登山計画の一覧から各計画の日程・ルート・メンバーを取得し、PLANS_FILE (既定値 plans.json) に書き出す。
+// 拡張子が .ics の場合はカレンダーに取り込めるiCalendar形式で書き出す
+func runPlansExport() error {
+	log.Println(tr("--- プログラム開始 (plans-export) ---"))
+	startTime := time.Now()
+	path := os.Getenv("PLANS_FILE")
+	if path == "" {
+		path = "plans.json"
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	if err := runActions(ctx,
+		drv.Navigate("https://yamap.com/plans"),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+	); err != nil {
+		return fmt.Errorf(tr("登山計画の一覧の取得に失敗: %w"), err)
+	}
+	var paths []string
+	seen := make(map[string]struct{})
+	for noNew := 0; noNew < 3; {
+		var found []string
+		if err := runActions(ctx, drv.Evaluate(planLinksScript, &found)); err != nil {
+			return fmt.Errorf(tr("登山計画の一覧の取得に失敗: %w"), err)
+		}
+		before := len(paths)
+		for _, p := range found {
+			if _, ok := seen[p]; !ok {
+				seen[p] = struct{}{}
+				paths = append(paths, p)
+			}
+		}
+		if len(paths) == before {
+			noNew++
+		} else {
+			noNew = 0
+		}
+		if err := runActions(ctx, scrollForMore(drv, "("+planLinksScript+").length")); err != nil {
+			return fmt.Errorf(tr("ページスクロールに失敗: %w"), err)
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("登山計画: %d件"), len(paths))
+
+	plans := make([]climbingPlan, 0, len(paths))
+	for _, p := range paths {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		url := "https://yamap.com" + p
+		plan := climbingPlan{URL: url}
+		plan.ID, _ = strconv.ParseInt(strings.TrimPrefix(p, "/plans/"), 10, 64)
+		err := runActions(ctx,
+			drv.Navigate(url),
+			drv.WaitVisible(`main`),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(planDetailScript, &plan),
+		)
+		status.recordResult(err == nil, err)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("登山計画の取得に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		plans = append(plans, plan)
+		status.markStep()
+	}
+	// 日程の順に並べる。日程のない計画は最後にする
+	sort.SliceStable(plans, func(i, j int) bool {
+		if (plans[i].StartDate == "") != (plans[j].StartDate == "") {
+			return plans[j].StartDate == ""
+		}
+		return plans[i].StartDate < plans[j].StartDate
+	})
+
+	var data []byte
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		data = plansICS(plans, time.Now())
+	} else if data, err = json.MarshalIndent(plans, "", "  "); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf(tr("登山計画の書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("登山計画 %d 件を %s に書き出しました。"), len(plans), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// plansICS は登山計画を終日の予定として iCalendar (RFC 5545) 形式で返す。日程のない計画は含めない
+func plansICS(plans []climbingPlan, now time.Time) []byte {
+	var b strings.Builder
+	line := func(s string) {
+		// 75オクテットを超える行は、UTF-8の文字の途中で切らないように折り返す
+		for len(s) > 75 {
+			n := 75
+			for !utf8.RuneStart(s[n]) {
+				n--
+			}
+			b.WriteString(s[:n] + "\r\n")
+			s = " " + s[n:]
+		}
+		b.WriteString(s + "\r\n")
+	}
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//yamap-auto-domo//plans-export//JA")
+	line("CALSCALE:GREGORIAN")
+	for _, p := range plans {
+		start, err := time.Parse("2006-01-02", p.StartDate)
+		if err != nil {
+			continue
+		}
+		end, err := time.Parse("2006-01-02", p.EndDate)
+		if err != nil || end.Before(start) {
+			end = start
+		}
+		var desc []string
+		if len(p.Route) > 0 {
+			desc = append(desc, "ルート: "+strings.Join(p.Route, " → "))
+		}
+		if len(p.Members) > 0 {
+			desc = append(desc, "メンバー: "+strings.Join(p.Members, "、"))
+		}
+		desc = append(desc, p.URL)
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:yamap-plan-%d@yamap.com", p.ID))
+		line("DTSTAMP:" + now.UTC().Format("20060102T150405Z"))
+		// DTEND は終わりの日を含まないため、下山日の翌日にする
+		line("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+		line("DTEND;VALUE=DATE:" + end.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:" + icsEscape(p.Title))
+		line("DESCRIPTION:" + icsEscape(strings.Join(desc, "\n")))
+		line("URL:" + p.URL)
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// icsEscape は iCalendar のテキストの値に含められない文字をエスケープする
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// planTemplatePath は plan-create で読み込むテンプレートのパス
+var planTemplatePath string
+
+// planTemplate は plan-create で登山計画のフォームに入力する内容
+type planTemplate struct {
+	Title    string
+	Mountain string
+	// Route は経由する地点の名前 (順番どおり)
+	Route []string
+	// Date は入山日。2006-01-02 の形式か曜日 (例: saturday、土) で、曜日の場合は翌日以降で最も近いその曜日
+	Date      string
+	Days      int
+	Members   []string
+	Equipment []string
+	Memo      string
+}
+
+// parsePlanTemplate はテンプレートを読み込む。YAMLのうち、トップレベルの「キー: 値」、
+// 文字列のリスト (「- 項目」の行か [a, b] の形式)、複数行の文字列 (| の後のインデントされた行) だけを扱う
+func parsePlanTemplate(data []byte) (planTemplate, error) {
+	t := planTemplate{Days: 1}
+	strs := map[string]*string{"title": &t.Title, "mountain": &t.Mountain, "date": &t.Date, "memo": &t.Memo}
+	lists := map[string]*[]string{"route": &t.Route, "members": &t.Members, "equipment": &t.Equipment}
+	var list *[]string
+	var block *string
+	blockIndent := -1
+	var blockLines []string
+	endBlock := func() {
+		if block != nil {
+			*block = strings.TrimRight(strings.Join(blockLines, "\n"), "\n")
+		}
+		block, blockIndent, blockLines = nil, -1, nil
+	}
+	for i, raw := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		n := i + 1
+		if block != nil {
+			indent := len(raw) - len(strings.TrimLeft(raw, " "))
+			if strings.TrimSpace(raw) == "" {
+				blockLines = append(blockLines, "")
+				continue
+			}
+			if blockIndent < 0 && indent > 0 {
+				blockIndent = indent
+			}
+			if blockIndent > 0 && indent >= blockIndent {
+				blockLines = append(blockLines, raw[blockIndent:])
+				continue
+			}
+			endBlock()
+		}
+		line := strings.TrimRight(yamlStripComment(raw), " \t")
+		if strings.TrimSpace(line) == "" || line == "---" {
+			continue
+		}
+		if strings.Contains(line, "\t") {
+			return t, fmt.Errorf(tr("%d行目: インデントにタブは使えません"), n)
+		}
+		if item := strings.TrimLeft(line, " "); item == "-" || strings.HasPrefix(item, "- ") {
+			if list == nil {
+				return t, fmt.Errorf(tr("%d行目: リストの項目の前にキーがありません"), n)
+			}
+			v, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(item, "-")))
+			if err != nil {
+				return t, fmt.Errorf(tr("%d行目: %w"), n, err)
+			}
+			*list = append(*list, v)
+			continue
+		}
+		if line[0] == ' ' {
+			return t, fmt.Errorf(tr("%d行目: 入れ子のキーは扱えません"), n)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return t, fmt.Errorf(tr("%d行目: 「キー: 値」の形式ではありません"), n)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		list = nil
+		switch {
+		case strs[key] != nil && (value == "|" || value == "|-"):
+			block = strs[key]
+		case strs[key] != nil:
+			v, err := yamlScalar(value)
+			if err != nil {
+				return t, fmt.Errorf(tr("%d行目: %w"), n, err)
+			}
+			*strs[key] = v
+		case key == "days":
+			d, err := strconv.Atoi(value)
+			if err != nil || d <= 0 {
+				return t, fmt.Errorf(tr("%d行目: days には1以上の整数を指定してください: %s"), n, value)
+			}
+			t.Days = d
+		case lists[key] != nil && value == "":
+			list = lists[key]
+		case lists[key] != nil && strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, f := range yamlSplitFlow(value[1 : len(value)-1]) {
+				v, err := yamlScalar(strings.TrimSpace(f))
+				if err != nil {
+					return t, fmt.Errorf(tr("%d行目: %w"), n, err)
+				}
+				if v != "" {
+					*lists[key] = append(*lists[key], v)
+				}
+			}
+		case lists[key] != nil:
+			return t, fmt.Errorf(tr("%d行目: %s にはリストを指定してください"), n, key)
+		default:
+			return t, fmt.Errorf(tr("%d行目: 不明なキーです: %s"), n, key)
+		}
+	}
+	endBlock()
+	if t.Mountain == "" {
+		return t, errors.New(tr("テンプレートに mountain を指定してください"))
+	}
+	if t.Date == "" {
+		return t, errors.New(tr("テンプレートに date を指定してください"))
+	}
+	return t, nil
+}
+
+// yamlStripComment は引用符の外にある # 以降 (行頭か空白の後のもの) を取り除く
+func yamlStripComment(s string) string {
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// yamlSplitFlow は [a, b] の中身を引用符の外のカンマで分ける
+func yamlSplitFlow(s string) []string {
+	var fields []string
+	var quote rune
+	start := 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			fields = append(fields, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, s[start:])
+}
+
+// yamlScalar は引用符で囲まれた値の引用符とエスケープを外す。囲まれていない値はそのまま返す
+func yamlScalar(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf(tr("引用符で囲まれた値が不正です: %s"), s)
+		}
+		return v, nil
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'"):
+		return "", fmt.Errorf(tr("引用符が閉じられていません: %s"), s)
+	}
+	return s, nil
+}
+
+// planWeekdays は planTemplate.Date に指定できる曜日の名前
+var planWeekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday,
+	"thursday": time.Thursday, "friday": time.Friday, "saturday": time.Saturday,
+	"日": time.Sunday, "月": time.Monday, "火": time.Tuesday, "水": time.Wednesday, "木": time.Thursday, "金": time.Friday, "土": time.Saturday,
+}
+
+// startDate は入山日を返す。曜日の場合は now の翌日以降で最も近いその曜日
+func (t planTemplate) startDate(now time.Time) (time.Time, error) {
+	if d, err := time.ParseInLocation("2006-01-02", t.Date, now.Location()); err == nil {
+		return d, nil
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(t.Date), "曜日"), "曜")
+	wd, ok := planWeekdays[name]
+	if !ok {
+		return time.Time{}, fmt.Errorf(tr("date には 2006-01-02 の形式の日付か曜日を指定してください: %s"), t.Date)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	days := (int(wd)-int(today.Weekday())+6)%7 + 1
+	return today.AddDate(0, 0, days), nil
+}
+
+// 登山計画の作成フォームの項目のラベル
+const (
+	planTitleLabel     = "タイトル"
+	planMountainLabel  = "山名"
+	planRouteLabel     = "経由地"
+	planStartLabel     = "入山日"
+	planEndLabel       = "下山日"
+	planMembersLabel   = "メンバー"
+	planMemoLabel      = "メモ"
+	planEquipmentLabel = "装備"
+)
+
+// planFormHelpers はフォームの項目をラベルの文字列から探し、Vueのコンポーネントにも反映されるように値を入力する関数
+const planFormHelpers = `
+const __planField = label => {
+	for (const l of document.querySelectorAll("main label, main dt, main legend, main h2, main h3, main [class*='Label']")) {
+		if (!l.textContent.includes(label)) continue;
+		if (l.control) return l.control;
+		const box = l.closest("dl, fieldset, section, div");
+		const f = box && box.querySelector("input:not([type=hidden]):not([type=checkbox]):not([type=radio]), textarea, select");
+		if (f) return f;
+	}
+	return null;
+};
+const __planSet = (el, value) => {
+	const proto = el.tagName === "TEXTAREA" ? HTMLTextAreaElement : el.tagName === "SELECT" ? HTMLSelectElement : HTMLInputElement;
+	Object.getOwnPropertyDescriptor(proto.prototype, "value").set.call(el, value);
+	el.dispatchEvent(new Event("input", {bubbles: true}));
+	el.dispatchEvent(new Event("change", {bubbles: true}));
+};`
+
+// planFieldScript はラベルが label の項目に value を入力するスクリプト。項目が見つからない場合は false
+func planFieldScript(label, value string) string {
+	l, _ := json.Marshal(label)
+	v, _ := json.Marshal(value)
+	return `(() => {` + planFormHelpers + `
+	const f = __planField(` + string(l) + `);
+	if (!f) return false;
+	f.focus();
+	__planSet(f, ` + string(v) + `);
+	return true;
+})()`
+}
+
+// planSuggestionScript は検索欄の入力で表示された候補のうち、query を含むもの (ない場合は最初のもの) をクリックするスクリプト
+func planSuggestionScript(query string) string {
+	q, _ := json.Marshal(query)
+	return `(() => {
+	const items = Array.from(document.querySelectorAll('[role="option"], [class*="Suggest"] li, [class*="Candidate"] li, [class*="SearchResult"] li'));
+	const item = items.find(i => i.textContent.includes(` + string(q) + `)) || items[0];
+	if (!item) return false;
+	(item.querySelector("button, a") || item).click();
+	return true;
+})()`
+}
+
+// planEquipmentScript は名前が一致する装備のチェックボックスにチェックを付け、見つからなかった装備の名前を返すスクリプト
+func planEquipmentScript(items []string) string {
+	data, _ := json.Marshal(items)
+	return `(() => {
+	const boxes = Array.from(document.querySelectorAll('main input[type="checkbox"]'));
+	const text = b => ((b.labels && b.labels[0]) || b.closest("label") || b.parentElement).textContent.trim();
+	return ` + string(data) + `.filter(name => {
+		const box = boxes.find(b => text(b) === name) || boxes.find(b => text(b).includes(name));
+		if (!box) return true;
+		if (!box.checked) box.click();
+		return false;
+	});
+})()`
+}
+
+// planSubmitScript は登山計画の作成フォームの送信ボタンをクリックするスクリプト
+const planSubmitScript = `(() => {
+	const buttons = Array.from(document.querySelectorAll('main button'));
+	const button = buttons.find(b => /作成|保存|登録|提出/.test(b.textContent)) || document.querySelector('main form button[type="submit"]');
+	if (!button || button.disabled) return false;
+	button.click();
+	return true;
+})()`
+
+// pickPlanSuggestion は検索欄に query を入力し、表示された候補を選ぶ
+func pickPlanSuggestion(ctx context.Context, drv pageDriver, label, query string) error {
+	var found, picked bool
+	if err := runActions(ctx,
+		drv.Evaluate(planFieldScript(label, query), &found),
+		sleepAction(2*time.Second),
+	); err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf(tr("フォームの項目が見つかりません: %s"), label)
+	}
+	if err := runActions(ctx, drv.Evaluate(planSuggestionScript(query), &picked), sleepAction(time.Second)); err != nil {
+		return err
+	}
+	if !picked {
+		return fmt.Errorf(tr("%sの候補が見つかりません: %s"), label, query)
+	}
+	return nil
+}
+
+// runPlanCreate は -template のテンプレートから登山計画の作成フォームに入力し、登山計画を作成する
+func runPlanCreate() error {
+	log.Println(tr("--- プログラム開始 (plan-create) ---"))
+	startTime := time.Now()
+	data, err := os.ReadFile(planTemplatePath)
+	if err != nil {
+		return fmt.Errorf(tr("テンプレートの読み込みに失敗しました: %w"), err)
+	}
+	tmpl, err := parsePlanTemplate(data)
+	if err != nil {
+		return fmt.Errorf(tr("%s の形式が不正です: %w"), planTemplatePath, err)
+	}
+	start, err := tmpl.startDate(time.Now())
+	if err != nil {
+		return err
+	}
+	end := start.AddDate(0, 0, tmpl.Days-1)
+	if tmpl.Title == "" {
+		tmpl.Title = tmpl.Mountain
+	}
+	log.Printf(tr("登山計画を作成します: %s (%s〜%s)"), tmpl.Title, start.Format("2006-01-02"), end.Format("2006-01-02"))
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	drv := driverFromContext(ctx)
+
+	err = fillPlanForm(ctx, drv, tmpl, start, end)
+	if err == nil {
+		var submitted bool
+		if err = runActions(ctx, drv.Evaluate(planSubmitScript, &submitted)); err == nil && !submitted {
+			err = errors.New(tr("登山計画の作成ボタンが見つかりません"))
+		}
+	}
+	var url string
+	if err == nil {
+		err = runActions(ctx,
+			drv.WaitNavigatedAway("/plans/new", 30*time.Second),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(`location.href`, &url),
+		)
+	}
+	status.recordResult(err == nil, err)
+	if err != nil {
+		saveDebugSnapshot(ctx, drv, "plan_create")
+		return fmt.Errorf(tr("登山計画の作成に失敗しました: %w"), err)
+	}
+	status.markStep()
+	loggerFromContext(ctx).Printf(tr("登山計画を作成しました: %s"), url)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// fillPlanForm は登山計画の作成ページを開き、テンプレートの内容を入力する。
+// 装備の一覧にない装備はメモに書き加える
+func fillPlanForm(ctx context.Context, drv pageDriver, tmpl planTemplate, start, end time.Time) error {
+	if err := runActions(ctx,
+		drv.Navigate("https://yamap.com/plans/new"),
+		drv.WaitVisible(`main form, main input`),
+		drv.WaitNetworkIdle(),
+	); err != nil {
+		return err
+	}
+	if err := pickPlanSuggestion(ctx, drv, planMountainLabel, tmpl.Mountain); err != nil {
+		return err
+	}
+	for _, point := range tmpl.Route {
+		if err := pickPlanSuggestion(ctx, drv, planRouteLabel, point); err != nil {
+			return err
+		}
+	}
+	fields := [][2]string{
+		{planTitleLabel, tmpl.Title},
+		{planStartLabel, start.Format("2006-01-02")},
+		{planEndLabel, end.Format("2006-01-02")},
+	}
+	for _, f := range fields {
+		var found bool
+		if err := runActions(ctx, drv.Evaluate(planFieldScript(f[0], f[1]), &found)); err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf(tr("フォームの項目が見つかりません: %s"), f[0])
+		}
+	}
+	for _, member := range tmpl.Members {
+		if err := pickPlanSuggestion(ctx, drv, planMembersLabel, member); err != nil {
+			return err
+		}
+	}
+	memo := tmpl.Memo
+	if len(tmpl.Equipment) > 0 {
+		var missing []string
+		if err := runActions(ctx, drv.Evaluate(planEquipmentScript(tmpl.Equipment), &missing)); err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			loggerFromContext(ctx).Printf(tr("装備の一覧にない装備をメモに書き加えます: %s"), strings.Join(missing, ", "))
+			memo = strings.TrimSpace(memo + "\n" + planEquipmentLabel + ": " + strings.Join(missing, "、"))
+		}
+	}
+	if memo != "" {
+		var found bool
+		if err := runActions(ctx, drv.Evaluate(planFieldScript(planMemoLabel, memo), &found)); err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf(tr("フォームの項目が見つかりません: %s"), planMemoLabel)
+		}
+	}
+	return runActions(ctx, sleepAction(time.Second))
+}
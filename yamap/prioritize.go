@@ -0,0 +1,134 @@
+package yamap
+
+import (
+	"context"
+	mathrand "math/rand/v2"
+	"os"
+	"slices"
+)
+
+// Prioritizer は収集した投稿をリアクションする順に並べ替える戦略。
+// 収集とリアクションのループの間で適用し、最大実行時間や上限で処理しきれない場合にどの投稿を優先するかを決める
+type Prioritizer interface {
+	// Prioritize は投稿を処理する順に並べ替えたスライスを返す。引数のスライスは変更しない
+	Prioritize(activities []ActivityInfo) []ActivityInfo
+}
+
+// prioritizers は QUEUE_ORDER・設定ファイルの queue_order に指定できる戦略
+var prioritizers = map[string]Prioritizer{
+	"collected":            collectedOrder{},
+	"newest-first":         newestFirst{},
+	"least-reacted-first":  leastReactedFirst{},
+	"zero-reactions-first": zeroReactionsFirst{},
+	"followed-users-first": followedUsersFirst{},
+	"random":               randomOrder{},
+}
+
+// prioritizerNames は指定できる戦略の名前の一覧 (エラーメッセージ用)
+const prioritizerNames = "collected, newest-first, least-reacted-first, zero-reactions-first, followed-users-first, random"
+
+// collectedOrder は収集した順 (タイムラインでは新しい順) のまま処理する
+type collectedOrder struct{}
+
+func (collectedOrder) Prioritize(activities []ActivityInfo) []ActivityInfo { return activities }
+
+// newestFirst は投稿日時の新しい順に並べる。投稿日時がわからない投稿は後に回す
+type newestFirst struct{}
+
+func (newestFirst) Prioritize(activities []ActivityInfo) []ActivityInfo {
+	ordered := slices.Clone(activities)
+	slices.SortStableFunc(ordered, func(a, b ActivityInfo) int {
+		if a.PostedAt.IsZero() || b.PostedAt.IsZero() {
+			return compareBool(!a.PostedAt.IsZero(), !b.PostedAt.IsZero())
+		}
+		return b.PostedAt.Compare(a.PostedAt)
+	})
+	return ordered
+}
+
+// leastReactedFirst はリアクションの種類数が少ない順に並べる。数がわからない投稿は後に回す
+type leastReactedFirst struct{}
+
+func (leastReactedFirst) Prioritize(activities []ActivityInfo) []ActivityInfo {
+	ordered := slices.Clone(activities)
+	slices.SortStableFunc(ordered, func(a, b ActivityInfo) int {
+		if !a.ReactionCountKnown || !b.ReactionCountKnown {
+			return compareBool(a.ReactionCountKnown, b.ReactionCountKnown)
+		}
+		return a.ReactionCount - b.ReactionCount
+	})
+	return ordered
+}
+
+// zeroReactionsFirst はまだリアクションが1件もない投稿を先にし、それ以外の順番は変えない
+type zeroReactionsFirst struct{}
+
+func (zeroReactionsFirst) Prioritize(activities []ActivityInfo) []ActivityInfo {
+	return partitionFirst(activities, func(a ActivityInfo) bool { return a.ReactionCountKnown && a.ReactionCount == 0 })
+}
+
+// followedUsersFirst は履歴にフォローした記録があるユーザーの投稿を先にし、それ以外の順番は変えない。履歴がない場合は並べ替えない
+type followedUsersFirst struct{}
+
+func (followedUsersFirst) Prioritize(activities []ActivityInfo) []ActivityInfo {
+	if history == nil {
+		return activities
+	}
+	return partitionFirst(activities, func(a ActivityInfo) bool { return a.AuthorID != 0 && history.hasFollowed(a.AuthorID) })
+}
+
+// randomOrder はランダムな順に並べる
+type randomOrder struct{}
+
+func (randomOrder) Prioritize(activities []ActivityInfo) []ActivityInfo {
+	ordered := slices.Clone(activities)
+	mathrand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	return ordered
+}
+
+// compareBool は true を先に並べるための比較関数
+func compareBool(a, b bool) int {
+	switch {
+	case a && !b:
+		return -1
+	case !a && b:
+		return 1
+	}
+	return 0
+}
+
+// partitionFirst は first に一致する投稿を先にし、それぞれの中の順番は変えずに並べる
+func partitionFirst(activities []ActivityInfo, first func(ActivityInfo) bool) []ActivityInfo {
+	ordered := slices.Clone(activities)
+	slices.SortStableFunc(ordered, func(a, b ActivityInfo) int { return compareBool(first(a), first(b)) })
+	return ordered
+}
+
+// queueOrder は使用する戦略の名前を返す。QUEUE_ORDER を設定ファイルの queue_order より優先する
+func queueOrder() string {
+	if v := os.Getenv("QUEUE_ORDER"); v != "" {
+		return v
+	}
+	if config.QueueOrder != "" {
+		return config.QueueOrder
+	}
+	return "collected"
+}
+
+// orderQueue は QUEUE_ORDER (または設定ファイルの queue_order) の戦略で、処理する順に投稿を並べ替える
+func orderQueue(ctx context.Context, activities []ActivityInfo) []ActivityInfo {
+	name := queueOrder()
+	p, ok := prioritizers[name]
+	if !ok {
+		loggerFromContext(ctx).Printf(tr("警告: QUEUE_ORDERの値が不正です。収集した順に処理します: %s"), name)
+		return activities
+	}
+	if name == "followed-users-first" && history == nil {
+		loggerFromContext(ctx).Print(tr("警告: followed-users-first を使うにはHISTORY_FILEの設定が必要です。収集した順に処理します"))
+		return activities
+	}
+	if name != "collected" {
+		loggerFromContext(ctx).Printf(tr("%d件の投稿を %s の順に並べ替えます。"), len(activities), name)
+	}
+	return p.Prioritize(activities)
+}
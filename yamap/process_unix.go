@@ -0,0 +1,22 @@
+//go:build !windows
+
+package yamap
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processAlive は同じホストのプロセスが生存しているかを返す。シグナル0を送って確かめる
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	return signalReachedProcess(syscall.Kill(pid, 0))
+}
+
+// signalReachedProcess はシグナル0の結果からプロセスが存在するかを返す。
+// 別のユーザーのプロセスで送る権限がない (EPERM) 場合も、プロセスは存在する
+func signalReachedProcess(err error) bool {
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package yamap
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestSignalReachedProcess(t *testing.T) {
+	if !signalReachedProcess(nil) {
+		t.Error("signal delivered: want alive")
+	}
+	if !signalReachedProcess(syscall.EPERM) {
+		t.Error("EPERM (another user's process): want alive")
+	}
+	if signalReachedProcess(syscall.ESRCH) {
+		t.Error("ESRCH: want not alive")
+	}
+}
+
+// TestProcessAliveOtherUser は別のユーザー (init) のプロセスを生存しているとみなすことを確かめる。
+// root で実行した場合はシグナルを送れるため EPERM にはならない
+func TestProcessAliveOtherUser(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Log("running as root: kill(1, 0) succeeds instead of EPERM")
+	}
+	if !processAlive(1) {
+		t.Error("processAlive(1) = false, want true")
+	}
+}
@@ -0,0 +1,32 @@
+//go:build windows
+
+package yamap
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processQueryLimitedInformation は OpenProcess に渡す PROCESS_QUERY_LIMITED_INFORMATION
+const processQueryLimitedInformation = 0x1000
+
+// stillActive は GetExitCodeProcess が実行中のプロセスに返す STILL_ACTIVE
+const stillActive = 259
+
+// processAlive は同じホストのプロセスが生存しているかを返す。Windowsではシグナル0を使えないため、
+// プロセスを開いて終了コードを確かめる。別のユーザーのプロセスのため開く権限がない場合は生存しているとみなす
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -0,0 +1,199 @@
+package yamap
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// profileConfig は profile-update で自分のプロフィールに設定する値。空の項目は変更しない
+type profileConfig struct {
+	// Bio は自己紹介のテンプレート (text/template)。.Month・.Season などを参照して季節ごとの文章に切り替えられる
+	Bio string `json:"bio"`
+	// Areas は活動エリア (都道府県などの表示名)。指定した場合は一覧にないエリアの選択を外す
+	Areas []string `json:"areas"`
+	// CoverPhoto はカバー写真の画像ファイルのパス
+	CoverPhoto string `json:"cover_photo"`
+
+	bio *template.Template
+}
+
+// profileData は自己紹介のテンプレートで参照できる値
+type profileData struct {
+	Now   time.Time
+	Year  int
+	Month int
+	Day   int
+	// Season は月から決めた季節 (春・夏・秋・冬)
+	Season string
+}
+
+// newProfileData は now の時点の profileData を作る
+func newProfileData(now time.Time) profileData {
+	seasons := [...]string{"冬", "冬", "春", "春", "春", "夏", "夏", "夏", "秋", "秋", "秋", "冬"}
+	return profileData{Now: now, Year: now.Year(), Month: int(now.Month()), Day: now.Day(), Season: seasons[now.Month()-1]}
+}
+
+// profileBioSelector・profileCoverInputSelector・profileSaveSelector はプロフィールの編集ページの自己紹介の入力欄・
+// カバー写真のファイルの入力欄・保存ボタン
+const (
+	profileBioSelector        = `textarea[name="description"], textarea[name="bio"], textarea[name*="introduction"]`
+	profileCoverInputSelector = `input[type="file"][name*="cover"], [class*="Cover"] input[type="file"]`
+	profileSaveSelector       = `form button[type="submit"]`
+)
+
+// setFieldValueScript は入力欄の値を value に置き換えるスクリプト。
+// フレームワークが値の変更を検知できるよう、ネイティブのsetterで値を設定してからinputイベントを発火する
+func setFieldValueScript(sel, value string) string {
+	return fmt.Sprintf(`(() => {
+	const el = document.querySelector(%s);
+	if (!el) return false;
+	const setter = Object.getOwnPropertyDescriptor(Object.getPrototypeOf(el), "value").set;
+	setter.call(el, %s);
+	el.dispatchEvent(new Event("input", {bubbles: true}));
+	el.dispatchEvent(new Event("change", {bubbles: true}));
+	return true;
+})()`, jsString(sel), jsString(value))
+}
+
+// setProfileAreasScript は活動エリアのチェックボックスを areas の表示名のものだけ選択された状態にし、
+// 見つからなかった表示名を返すスクリプト
+func setProfileAreasScript(areas []string) string {
+	names, _ := json.Marshal(areas)
+	return fmt.Sprintf(`(() => {
+	const wanted = %s;
+	const found = new Set();
+	for (const input of document.querySelectorAll('form input[type="checkbox"]')) {
+		const label = input.closest("label") || document.querySelector('label[for="' + input.id + '"]');
+		const name = label ? label.textContent.trim() : "";
+		if (!name) continue;
+		const on = wanted.includes(name);
+		if (on) found.add(name);
+		if (input.checked !== on) input.click();
+	}
+	return wanted.filter(name => !found.has(name));
+})()`, names)
+}
+
+// setFileInputScript は画像ファイルの内容 (Base64) からファイルを作ってファイルの入力欄に設定するスクリプト。
+// ブラウザごとのファイルのアップロードの仕組みを使わずに済むよう、DataTransfer で files を置き換えて change イベントを発火する
+func setFileInputScript(sel, name, mimeType string, data []byte) string {
+	return fmt.Sprintf(`(() => {
+	const input = document.querySelector(%s);
+	if (!input) return false;
+	const bytes = Uint8Array.from(atob(%s), c => c.charCodeAt(0));
+	const transfer = new DataTransfer();
+	transfer.items.add(new File([bytes], %s, {type: %s}));
+	input.files = transfer.files;
+	input.dispatchEvent(new Event("change", {bubbles: true}));
+	return true;
+})()`, jsString(sel), jsString(base64.StdEncoding.EncodeToString(data)), jsString(name), jsString(mimeType))
+}
+
+// runProfileUpdate は設定ファイルの profile の値で自分のプロフィール (自己紹介・活動エリア・カバー写真) を更新する。
+// 自己紹介はテンプレートを実行の日付で展開するため、定期的に実行すると季節ごとの文章に切り替わる
+func runProfileUpdate() error {
+	log.Println(tr("--- プログラム開始 (profile-update) ---"))
+	startTime := time.Now()
+	p := config.Profile
+	if p.bio == nil && len(p.Areas) == 0 && p.CoverPhoto == "" {
+		return errors.New(tr("profile-update では設定ファイルの profile に bio・areas・cover_photo のいずれかを指定してください"))
+	}
+	var bio string
+	if p.bio != nil {
+		var buf bytes.Buffer
+		if err := p.bio.Execute(&buf, newProfileData(time.Now())); err != nil {
+			return fmt.Errorf(tr("自己紹介のテンプレートの展開に失敗しました: %w"), err)
+		}
+		bio = strings.TrimSpace(buf.String())
+	}
+	var cover []byte
+	if p.CoverPhoto != "" {
+		data, err := os.ReadFile(p.CoverPhoto)
+		if err != nil {
+			return fmt.Errorf(tr("カバー写真を読み込めません: %w"), err)
+		}
+		cover = data
+	}
+	editURL := os.Getenv("PROFILE_EDIT_URL")
+	if editURL == "" {
+		editURL = "https://yamap.com/settings/profile"
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	drv := driverFromContext(ctx)
+	loggerFromContext(ctx).Printf(tr("プロフィールの編集ページを開きます: %s"), editURL)
+	if err := runActions(ctx, drv.Navigate(editURL), drv.WaitVisible(`form`), drv.WaitNetworkIdle()); err != nil {
+		return fmt.Errorf(tr("プロフィールの編集ページの読み込みに失敗: %w"), err)
+	}
+
+	if p.bio != nil {
+		var ok bool
+		if err := runActions(ctx, drv.Evaluate(setFieldValueScript(profileBioSelector, bio), &ok)); err != nil {
+			return fmt.Errorf(tr("自己紹介の設定に失敗: %w"), err)
+		}
+		if !ok {
+			return errors.New(tr("自己紹介の入力欄が見つかりません"))
+		}
+		loggerFromContext(ctx).Printf(tr("自己紹介を設定します: %s"), bio)
+	}
+	if len(p.Areas) > 0 {
+		var missing []string
+		if err := runActions(ctx, drv.Evaluate(setProfileAreasScript(p.Areas), &missing)); err != nil {
+			return fmt.Errorf(tr("活動エリアの設定に失敗: %w"), err)
+		}
+		if len(missing) > 0 {
+			loggerFromContext(ctx).Printf(tr("警告: 活動エリアの選択肢に見つからないものがあります: %s"), strings.Join(missing, ", "))
+		}
+		loggerFromContext(ctx).Printf(tr("活動エリアを設定します: %s"), strings.Join(p.Areas, ", "))
+	}
+	if cover != nil {
+		var ok bool
+		script := setFileInputScript(profileCoverInputSelector, filepath.Base(p.CoverPhoto), http.DetectContentType(cover), cover)
+		if err := runActions(ctx, drv.Evaluate(script, &ok), sleepAction(2*time.Second)); err != nil {
+			return fmt.Errorf(tr("カバー写真の設定に失敗: %w"), err)
+		}
+		if !ok {
+			return errors.New(tr("カバー写真の入力欄が見つかりません"))
+		}
+		loggerFromContext(ctx).Printf(tr("カバー写真を設定します: %s"), p.CoverPhoto)
+	}
+
+	if err := runActions(ctx, drv.Click(profileSaveSelector), sleepAction(2*time.Second), drv.WaitNetworkIdle()); err != nil {
+		return fmt.Errorf(tr("プロフィールの保存に失敗: %w"), err)
+	}
+	// 保存できたかは、編集ページを開き直して自己紹介が反映されているかで確かめる
+	if p.bio != nil {
+		var saved string
+		if err := runActions(ctx,
+			drv.Navigate(editURL),
+			drv.WaitVisible(profileBioSelector),
+			drv.Evaluate(fmt.Sprintf(`document.querySelector(%s).value`, jsString(profileBioSelector)), &saved),
+		); err != nil {
+			return fmt.Errorf(tr("保存したプロフィールの確認に失敗: %w"), err)
+		}
+		if strings.TrimSpace(saved) != bio {
+			return errors.New(tr("保存したプロフィールの自己紹介が設定した内容と異なります"))
+		}
+	}
+	loggerFromContext(ctx).Println(tr("プロフィールを更新しました。"))
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
@@ -0,0 +1,88 @@
+package yamap
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"sync"
+)
+
+// cpuProfilePath, memProfilePath は -cpuprofile, -memprofile フラグの値
+var cpuProfilePath, memProfilePath string
+
+// cpuProfileFile は書き込み中のCPUプロファイル
+var cpuProfileFile *os.File
+
+// stopProfilingOnce は終了の経路が複数あってもプロファイルを一度だけ書き出すために使う
+var stopProfilingOnce sync.Once
+
+// accountFilePath は複数アカウントの子プロセスでファイルが衝突しないよう、パスにアカウント名を加える (例: cpu.prof → cpu.main.prof)
+func accountFilePath(path string) string {
+	name := os.Getenv("YAMAP_ACCOUNT")
+	if name == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// startProfiling は -cpuprofile が指定されていればCPUプロファイルの記録を始める
+func startProfiling() error {
+	if cpuProfilePath == "" {
+		return nil
+	}
+	f, err := os.Create(accountFilePath(cpuProfilePath))
+	if err != nil {
+		return fmt.Errorf(tr("CPUプロファイルのファイルを作成できません: %w"), err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		return fmt.Errorf(tr("CPUプロファイルの記録を開始できません: %w"), err)
+	}
+	cpuProfileFile = f
+	return nil
+}
+
+// beforeExit は終了前に診断用のファイル (プロファイル・HAR・録画) を書き出し、履歴のロックを解放する。
+// os.Exit では defer が実行されないため、終了する各経路から呼び出す
+func beforeExit() {
+	stopProfiling()
+	harLog.save()
+	screenRec.finish()
+	artifacts.wait()
+	tracer.shutdown()
+	if heldHistoryLock != nil {
+		heldHistoryLock.release()
+	}
+}
+
+// stopProfiling はCPUプロファイルの記録を終え、-memprofile が指定されていればヒーププロファイルを書き出す
+func stopProfiling() {
+	stopProfilingOnce.Do(func() {
+		if cpuProfileFile != nil {
+			pprof.StopCPUProfile()
+			cpuProfileFile.Close()
+			log.Printf(tr("CPUプロファイルを %s に保存しました。"), cpuProfileFile.Name())
+		}
+		if memProfilePath == "" {
+			return
+		}
+		path := accountFilePath(memProfilePath)
+		f, err := os.Create(path)
+		if err != nil {
+			log.Printf(tr("メモリプロファイルのファイルを作成できません: %v"), err)
+			return
+		}
+		defer f.Close()
+		// 直近の割り当てまで反映させるため、書き出す前にGCを実行する
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Printf(tr("メモリプロファイルの書き出しに失敗しました: %v"), err)
+			return
+		}
+		log.Printf(tr("メモリプロファイルを %s に保存しました。"), path)
+	})
+}
@@ -0,0 +1,451 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// queuedActivity はリアクションのキュー (REACTION_QUEUE) に入れる投稿1件
+type queuedActivity struct {
+	URL        string    `json:"url"`
+	AuthorID   int64     `json:"author_id,omitempty"`
+	AuthorName string    `json:"author_name,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	PostedAt   time.Time `json:"posted_at,omitzero"`
+	// ReactionCount は収集した時点でついていた絵文字の種類の数。フィードから取得できなかった場合は nil
+	ReactionCount *int      `json:"reaction_count,omitempty"`
+	QueuedAt      time.Time `json:"queued_at"`
+}
+
+// queuedFromActivity は収集した投稿をキューに入れる形式にする
+func queuedFromActivity(a ActivityInfo, now time.Time) queuedActivity {
+	q := queuedActivity{URL: a.URL, AuthorID: a.AuthorID, AuthorName: a.AuthorName, Title: a.Title, PostedAt: a.PostedAt, QueuedAt: now}
+	if a.ReactionCountKnown {
+		count := a.ReactionCount
+		q.ReactionCount = &count
+	}
+	return q
+}
+
+// activity はキューの投稿をリアクションの対象の形式にする
+func (q queuedActivity) activity() ActivityInfo {
+	a := ActivityInfo{URL: q.URL, AuthorID: q.AuthorID, AuthorName: q.AuthorName, Title: q.Title, PostedAt: q.PostedAt}
+	if q.ReactionCount != nil {
+		a.ReactionCount, a.ReactionCountKnown = *q.ReactionCount, true
+	}
+	return a
+}
+
+// reactionQueue は collect が収集した投稿を react が後で処理するまで保存しておくキュー。
+// REACTION_QUEUE のJSONファイルか、redis:// のRedis
+type reactionQueue interface {
+	// push はキューにない投稿を末尾に加え、加えた件数を返す
+	push(items []queuedActivity) (int, error)
+	// peek はキューに入れた順に最大 n 件 (0 の場合はすべて) を返す。キューからは除かない
+	peek(n int) ([]queuedActivity, error)
+	// remove は処理を終えた投稿をキューから除く
+	remove(urls []string) error
+	// expire はキューに入れた日時が before より前の投稿を除き、除いた件数を返す
+	expire(before time.Time) (int, error)
+}
+
+// defaultReactionQueueMaxAge はキューの投稿を古すぎるとみなして除くまでの既定の時間
+const defaultReactionQueueMaxAge = 72 * time.Hour
+
+// reactionQueueFromEnv は REACTION_QUEUE (既定値 reaction-queue.json) からキューを選ぶ。
+// redis:// または rediss:// で始まる場合はRedis (キー <REDIS_KEY_PREFIX>:queue)、それ以外はJSONファイルのパスとみなす。
+// 複数アカウントで実行する場合は、アカウントごとにファイル名またはキーを分ける
+func reactionQueueFromEnv() (reactionQueue, string, error) {
+	target := os.Getenv("REACTION_QUEUE")
+	if target == "" {
+		target = "reaction-queue.json"
+	}
+	if !strings.HasPrefix(target, "redis://") && !strings.HasPrefix(target, "rediss://") {
+		path := accountFilePath(target)
+		return &fileReactionQueue{path: path}, path, nil
+	}
+	u, err := neturl.Parse(target)
+	if err != nil {
+		return nil, "", errors.New(tr("REACTION_QUEUEの値が不正です (redis://:パスワード@ホスト:6379/0 の形式で指定してください)"))
+	}
+	key := "yamap-auto-domo"
+	if v := os.Getenv("REDIS_KEY_PREFIX"); v != "" {
+		key = v
+	}
+	key += ":queue"
+	if name := os.Getenv("YAMAP_ACCOUNT"); name != "" {
+		key += ":" + name
+	}
+	client, err := newRedisClient(target)
+	if err != nil {
+		return nil, "", errors.New(tr("REACTION_QUEUEの値が不正です (redis://:パスワード@ホスト:6379/0 の形式で指定してください)"))
+	}
+	return &redisReactionQueue{client: client, key: key}, u.Redacted() + " (" + key + ")", nil
+}
+
+// reactionQueueMaxAge は REACTION_QUEUE_MAX_AGE (既定値 72h) を返す。0 の場合は古い投稿を除かない
+func reactionQueueMaxAge() (time.Duration, error) {
+	v := os.Getenv("REACTION_QUEUE_MAX_AGE")
+	if v == "" {
+		return defaultReactionQueueMaxAge, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf(tr("REACTION_QUEUE_MAX_AGEの値が不正です: %s"), v)
+	}
+	return d, nil
+}
+
+// fileReactionQueue はJSONファイルのキュー。collect と react が同時に書き換えても投稿を失わないよう、
+// 読み書きの間は <ファイル>.lock を排他的に作成してロックする
+type fileReactionQueue struct {
+	path string
+}
+
+// fileQueueLockTimeout はキューのファイルのロックを待つ最大の時間。読み書きは短時間で終わるため、これを超えたロックは残骸とみなす
+const fileQueueLockTimeout = 30 * time.Second
+
+// update はロックを取ってキューを読み込み、fn で書き換えた内容を保存する
+func (q *fileReactionQueue) update(fn func(items []queuedActivity) ([]queuedActivity, error)) error {
+	lockPath := q.path + ".lock"
+	deadline := time.Now().Add(fileQueueLockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > fileQueueLockTimeout {
+			log.Printf(tr("キューのロック %s が残っていたため取り除きます。"), lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf(tr("キューのファイルを別のプロセスが使用中です (%s)"), lockPath)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	defer os.Remove(lockPath)
+
+	var items []queuedActivity
+	data, err := os.ReadFile(q.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return fmt.Errorf(tr("キューのファイルの形式が不正です: %w"), err)
+		}
+	}
+	updated, err := fn(items)
+	if err != nil || updated == nil {
+		return err
+	}
+	data, err = json.MarshalIndent(updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(q.path, data, 0o600)
+}
+
+func (q *fileReactionQueue) push(items []queuedActivity) (int, error) {
+	added := 0
+	err := q.update(func(queued []queuedActivity) ([]queuedActivity, error) {
+		seen := make(map[string]bool, len(queued))
+		for _, item := range queued {
+			seen[item.URL] = true
+		}
+		for _, item := range items {
+			if !seen[item.URL] {
+				seen[item.URL] = true
+				queued = append(queued, item)
+				added++
+			}
+		}
+		return append([]queuedActivity{}, queued...), nil
+	})
+	return added, err
+}
+
+func (q *fileReactionQueue) peek(n int) ([]queuedActivity, error) {
+	var out []queuedActivity
+	err := q.update(func(queued []queuedActivity) ([]queuedActivity, error) {
+		if n > 0 && len(queued) > n {
+			queued = queued[:n]
+		}
+		out = queued
+		return nil, nil
+	})
+	return out, err
+}
+
+func (q *fileReactionQueue) remove(urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	return q.update(func(queued []queuedActivity) ([]queuedActivity, error) {
+		kept := []queuedActivity{}
+		for _, item := range queued {
+			if !slices.Contains(urls, item.URL) {
+				kept = append(kept, item)
+			}
+		}
+		return kept, nil
+	})
+}
+
+func (q *fileReactionQueue) expire(before time.Time) (int, error) {
+	removed := 0
+	err := q.update(func(queued []queuedActivity) ([]queuedActivity, error) {
+		kept := []queuedActivity{}
+		for _, item := range queued {
+			if item.QueuedAt.Before(before) {
+				removed++
+			} else {
+				kept = append(kept, item)
+			}
+		}
+		return kept, nil
+	})
+	return removed, err
+}
+
+// redisReactionQueue はRedisのキュー。複数のマシンで collect と react を分けて実行できる。
+// 投稿の内容は <キー> のハッシュに、キューに入れた順は <キー>:order のソート済みセット (スコアはキューに入れた日時) に保存する
+type redisReactionQueue struct {
+	client *redis.Client
+	key    string
+}
+
+func (q *redisReactionQueue) push(items []queuedActivity) (int, error) {
+	ctx := context.Background()
+	added := 0
+	for _, item := range items {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return added, err
+		}
+		isNew, err := q.client.HSetNX(ctx, q.key, item.URL, data).Result()
+		if err != nil {
+			return added, err
+		}
+		if isNew {
+			if err := q.client.ZAdd(ctx, q.key+":order", redis.Z{Score: float64(item.QueuedAt.UnixNano()), Member: item.URL}).Err(); err != nil {
+				return added, err
+			}
+			added++
+		}
+	}
+	return added, nil
+}
+
+func (q *redisReactionQueue) peek(n int) ([]queuedActivity, error) {
+	ctx := context.Background()
+	urls, err := q.client.ZRange(ctx, q.key+":order", 0, int64(n-1)).Result()
+	if err != nil || len(urls) == 0 {
+		return nil, err
+	}
+	values, err := q.client.HMGet(ctx, q.key, urls...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var items []queuedActivity
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var item queuedActivity
+		if err := json.Unmarshal([]byte(s), &item); err != nil {
+			return nil, fmt.Errorf(tr("キューの投稿の形式が不正です: %w"), err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (q *redisReactionQueue) remove(urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	if err := q.client.HDel(ctx, q.key, urls...).Err(); err != nil {
+		return err
+	}
+	members := make([]any, len(urls))
+	for i, u := range urls {
+		members[i] = u
+	}
+	return q.client.ZRem(ctx, q.key+":order", members...).Err()
+}
+
+func (q *redisReactionQueue) expire(before time.Time) (int, error) {
+	urls, err := q.client.ZRangeByScore(context.Background(), q.key+":order", &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(before.UnixNano(), 10),
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+	return len(urls), q.remove(urls)
+}
+
+// runCollect は投稿を収集してリアクションのキュー (REACTION_QUEUE) に加える。リアクションは送らない。
+// スクロールの多い収集と、リアクションの送信を別々のスケジュールやマシンで実行できるようにする
+func runCollect() error {
+	log.Println(tr("--- プログラム開始 (collect) ---"))
+	startTime := time.Now()
+
+	queue, where, err := reactionQueueFromEnv()
+	if err != nil {
+		return err
+	}
+	source := os.Getenv("COLLECT_SOURCE")
+	if source == "" {
+		source = "timeline"
+	}
+	countEnv := map[string]string{"timeline": "TIMELINE_POST_COUNT_TO_PROCESS", "activities": "ACTIVITIES_POST_COUNT_TO_PROCESS"}[source]
+	if countEnv == "" {
+		return fmt.Errorf(tr("COLLECT_SOURCEの値が不正です: %s (timeline, activities のいずれかを指定してください)"), source)
+	}
+	postCount, err := strconv.Atoi(os.Getenv(countEnv))
+	if err != nil {
+		return fmt.Errorf(tr("%sの値が不正です: %w"), countEnv, err)
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(source == "timeline")
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	var activities []ActivityInfo
+	if source == "timeline" {
+		activities, err = collectTimeline(ctx, postCount)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("タイムラインの収集中にエラーが発生しました: %v"), err)
+		}
+	} else {
+		activities = collectActivities(ctx, postCount)
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の投稿を収集しました。"), len(activities))
+
+	now := time.Now()
+	items := make([]queuedActivity, 0, len(activities))
+	for _, a := range activities {
+		items = append(items, queuedFromActivity(a, now))
+	}
+	added, err := queue.push(items)
+	if err != nil {
+		return fmt.Errorf(tr("キューへの追加に失敗しました: %w"), err)
+	}
+	feedMarkers.commit(ctx)
+	loggerFromContext(ctx).Printf(tr("%d件の投稿をキュー %s に追加しました (キューにあった %d 件を除く)。"), added, where, len(items)-added)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// runReact はリアクションのキュー (REACTION_QUEUE) の投稿にリアクションを送り、処理した投稿をキューから除く。
+// 停止の条件などで処理しなかった投稿はキューに残し、次の react で処理する
+func runReact() error {
+	log.Println(tr("--- プログラム開始 (react) ---"))
+	startTime := time.Now()
+
+	queue, where, err := reactionQueueFromEnv()
+	if err != nil {
+		return err
+	}
+	maxAge, err := reactionQueueMaxAge()
+	if err != nil {
+		return err
+	}
+	batch := 0
+	if v := os.Getenv("REACTION_QUEUE_BATCH"); v != "" {
+		if batch, err = strconv.Atoi(v); err != nil || batch < 0 {
+			return fmt.Errorf(tr("REACTION_QUEUE_BATCHの値が不正です: %s"), v)
+		}
+	}
+	if maxAge > 0 {
+		expired, err := queue.expire(time.Now().Add(-maxAge))
+		if err != nil {
+			return fmt.Errorf(tr("キューの読み込みに失敗しました: %w"), err)
+		}
+		if expired > 0 {
+			log.Printf(tr("キューに入れてから %s 以上経った %d 件の投稿を除きました。"), maxAge, expired)
+		}
+	}
+	items, err := queue.peek(batch)
+	if err != nil {
+		return fmt.Errorf(tr("キューの読み込みに失敗しました: %w"), err)
+	}
+	if len(items) == 0 {
+		log.Printf(tr("キュー %s に投稿がないため、何もせずに終了します。"), where)
+		return nil
+	}
+	activities := make([]ActivityInfo, 0, len(items))
+	for _, item := range items {
+		activities = append(activities, item.activity())
+	}
+	log.Printf(tr("キュー %s の %d 件の投稿にリアクションします。"), where, len(activities))
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	status.markStep()
+
+	// リアクションを終えた投稿 (成功・失敗・スキップ) は、途中で強制終了しても処理し直さないよう1件ごとにキューから除く。
+	// 失敗した投稿は再試行キュー (RETRY_QUEUE_MAX_ATTEMPTS) に移るため、このキューには残さない
+	done := 0
+	hooks := *hooksFromContext(ctx)
+	onReaction := hooks.OnReaction
+	hooks.OnReaction = func(ctx context.Context, activity ActivityInfo, sent string, err error) {
+		if ctx.Err() == nil {
+			if err := queue.remove([]string{activity.URL}); err != nil {
+				loggerFromContext(ctx).Printf(tr("警告: 処理した投稿をキューから除けませんでした (%s): %v"), activity.URL, err)
+			} else {
+				done++
+			}
+		}
+		if onReaction != nil {
+			onReaction(ctx, activity, sent, err)
+		}
+	}
+	reactedURLs := reactToActivities(withHooks(ctx, &hooks), activities)
+	loggerFromContext(ctx).Printf(tr("%d件の投稿をキューから除きました。"), done)
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
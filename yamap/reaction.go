@@ -0,0 +1,575 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp/device"
+)
+
+// abOutcome は A/B 比較の戦略ごとの今回の実行の結果
+type abOutcome struct {
+	processed, reacted, failed, skipped int
+}
+
+// assignVariants は設定ファイルに ab_test がある場合に、処理する順に投稿を2つの戦略へ交互に割り当てる。
+// 処理の順番や時間切れによる偏りが出ないよう交互にし、戦略の絵文字は投稿に絵文字の指定 (プランなど) がない場合だけ使う
+func assignVariants(ctx context.Context, activities []ActivityInfo) {
+	if len(config.ABTest) == 0 {
+		return
+	}
+	for i := range activities {
+		v := config.ABTest[i%len(config.ABTest)]
+		activities[i].Variant = v.Name
+		if activities[i].Emoji == "" {
+			activities[i].Emoji = v.Emoji
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("A/B比較: %d件の投稿を %s と %s に交互に割り当てます。"), len(activities), config.ABTest[0].Name, config.ABTest[1].Name)
+}
+
+// variantPaceFactor は戦略の投稿後の待機時間の倍率を返す。戦略がない場合は 1
+func variantPaceFactor(name string) float64 {
+	for _, v := range config.ABTest {
+		if v.Name == name && v.PaceFactor > 0 {
+			return v.PaceFactor
+		}
+	}
+	return 1
+}
+
+// recordABOutcome は投稿1件の処理結果を戦略ごとに数える。戦略が割り当てられていない場合は何もしない
+func recordABOutcome(outcomes map[string]*abOutcome, variant string, liked bool, err error) {
+	if variant == "" {
+		return
+	}
+	o, ok := outcomes[variant]
+	if !ok {
+		o = &abOutcome{}
+		outcomes[variant] = o
+	}
+	o.processed++
+	var skipErr *skipError
+	switch {
+	case liked:
+		o.reacted++
+	case errors.As(err, &skipErr):
+		o.skipped++
+	case err != nil:
+		o.failed++
+	}
+}
+
+// logABOutcomes は A/B 比較の戦略ごとの今回の実行の結果を出力する
+func logABOutcomes(ctx context.Context, outcomes map[string]*abOutcome) {
+	if len(outcomes) == 0 {
+		return
+	}
+	loggerFromContext(ctx).Println(tr("\n--- A/B比較の結果 (今回の実行) ---"))
+	for _, v := range config.ABTest {
+		o, ok := outcomes[v.Name]
+		if !ok {
+			continue
+		}
+		loggerFromContext(ctx).Printf(tr("%s: 処理 %d件 / 成功 %d件 / 失敗 %d件 / スキップ %d件 (失敗率 %s)"), v.Name, o.processed, o.reacted, o.failed, o.skipped, failureRate(o.failed, o.processed))
+	}
+	loggerFromContext(ctx).Println(tr("戦略ごとの返報 (リアクションした投稿者からのフォロー) は -action history で確認できます。"))
+}
+
+// reactToActivities は収集した投稿に順番にリアクションを送信し、リアクションした投稿のURLを返す。
+// 投稿の合間にキルスイッチと最大実行時間を確認し、閲覧できない投稿はスキップ理由を記録して次へ進む。
+func reactToActivities(ctx context.Context, activities []ActivityInfo) []string {
+	// 前回の実行が中断されていれば、収集した投稿の代わりにその処理待ちの投稿を続きから処理する
+	progress := runCheckpoint{Action: status.report().Action}
+	if resumed := runCheckpoints.resume(); resumed != nil {
+		activities = resumed.Queue
+		progress = *resumed
+	} else {
+		activities = withRetries(ctx, orderQueue(ctx, activities))
+		assignVariants(ctx, activities)
+	}
+	outcomes := make(map[string]*abOutcome)
+	breaker := newCircuitBreakerFromEnv(ctx)
+	reactedURLs := progress.Reacted
+	var skipped []string
+	queue := append([]string(nil), progress.Done...)
+	for _, activity := range activities {
+		queue = append(queue, activity.URL)
+	}
+	status.setQueue(queue)
+	status.restoreResults(progress.Succeeded, progress.Failed, progress.Skipped)
+	pace.spreadOver(ctx, len(activities))
+	progress.Queue = activities
+	runCheckpoints.save(ctx, progress, true)
+	stopConditions := reactionStopConditions()
+	for i, activity := range activities {
+		if stopConditionReached(ctx, stopConditions) {
+			break
+		}
+		if reason := dedupe.claim(ctx, activity.URL); reason != "" {
+			// 他のインスタンスが処理する投稿もリアクションを終えた投稿として扱い、react のキューなどから除けるようにする
+			skip := &skipError{reason: reason}
+			status.recordResult(false, skip)
+			recordPostOutcome(ctx, activity.URL, skip)
+			hooksFromContext(ctx).reacted(ctx, activity, "", skip)
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", activity.URL, skip.reason))
+			progress.record(activity.URL, false, skip, activities[i+1:])
+			runCheckpoints.save(ctx, progress, false)
+			continue
+		}
+		recycleTabIfNeeded(ctx)
+		loggerFromContext(ctx).Printf(tr("--- 投稿 %d/%d を処理中 ---"), i+1, len(activities))
+		liked, sent, err := sendReaction(ctx, activity.URL, activity.Emoji)
+		dedupe.finish(ctx, activity.URL, liked)
+		status.recordResult(liked, err)
+		postReactionEvent(ctx, activity, sent, err)
+		events.publishResult(activity.URL, sent, err)
+		recordPostOutcome(ctx, activity.URL, err)
+		recordABOutcome(outcomes, activity.Variant, liked, err)
+		updateRetryQueue(ctx, activity, err)
+		hooksFromContext(ctx).reacted(ctx, activity, sent, err)
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			loggerFromContext(ctx).Printf(tr("投稿をスキップしました (%s): %s"), activity.URL, skipErr.reason)
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", activity.URL, skipErr.reason))
+		} else if err != nil {
+			loggerFromContext(ctx).Printf(tr("リアクション処理でエラーが発生しました (%s): %v"), activity.URL, err)
+			hooksFromContext(ctx).failed(ctx, "reaction", err)
+		}
+		if liked {
+			reactedURLs = append(reactedURLs, activity.URL)
+			if err := postComment(ctx, driverFromContext(ctx), config.commentTemplates); err != nil {
+				loggerFromContext(ctx).Printf(tr("コメントの送信に失敗しました (%s): %v"), activity.URL, err)
+				hooksFromContext(ctx).failed(ctx, "comment", err)
+			}
+			recordReaction(ctx, activity, sent)
+			loggerFromContext(ctx).Printf(tr("いいね！しました。(現在 %d/%d 件)"), len(reactedURLs), len(activities))
+		}
+		progress.record(activity.URL, liked, err, activities[i+1:])
+		progress.Reacted = reactedURLs
+		runCheckpoints.save(ctx, progress, false)
+		if breaker.record(ctx, activity.URL, err) {
+			breaker.trip(ctx)
+		}
+		// メインのコンテキストがキャンセルされた場合は、ループを中断
+		if ctx.Err() != nil {
+			loggerFromContext(ctx).Println(tr("メインコンテキストがキャンセルされたため、リアクション処理を中断します。"))
+			break
+		}
+		pace.waitScaled(ctx, variantPaceFactor(activity.Variant)) // 連続アクセスを避けるための待機
+	}
+
+	loggerFromContext(ctx).Printf(tr("いいね！の送信が完了しました。最終的な成功件数: %d"), len(reactedURLs))
+	if ctx.Err() == nil {
+		runCheckpoints.clear(ctx)
+	}
+	logABOutcomes(ctx, outcomes)
+	if len(skipped) > 0 {
+		loggerFromContext(ctx).Printf(tr("\n--- スキップした投稿一覧 (%d件) ---"), len(skipped))
+		for _, line := range skipped {
+			loggerFromContext(ctx).Println(line)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+	return reactedURLs
+}
+
+// alreadyReactedReason は投稿ページで自分のリアクションが見つかった場合のスキップ理由
+const alreadyReactedReason = "投稿ページでリアクション済み"
+
+// skipError はリアクションせずに投稿をスキップしたことを表す。リトライの対象にはならない
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string {
+	return tr("投稿をスキップしました: ") + e.reason
+}
+
+// isAlreadyReacted は err が投稿ページでリアクション済みと判定したスキップかを返す
+func isAlreadyReacted(err error) bool {
+	var skipErr *skipError
+	return errors.As(err, &skipErr) && skipErr.reason == tr(alreadyReactedReason)
+}
+
+// activityUnavailablePhrases は投稿を閲覧できない場合に表示される文言と NUXT のエラーページのステータスコードを、スキップ理由ごとにまとめたもの
+var activityUnavailablePhrases = []struct {
+	Reason   string   `json:"reason"`
+	Phrases  []string `json:"phrases"`
+	Statuses []int    `json:"statuses"`
+}{
+	{"削除済みまたは存在しない投稿 (404)", []string{"ページが見つかりません", "お探しのページは見つかりません", "page not found"}, []int{404, 410}},
+	{"閲覧権限がない投稿 (403)", []string{"アクセス権限がありません", "閲覧する権限がありません", "forbidden"}, []int{403}},
+	{"非公開の投稿", []string{"非公開", "公開されていません", "this activity is private"}, nil},
+	{"ブロックされているユーザーの投稿", []string{"ブロックされています", "閲覧できません", "you have been blocked"}, nil},
+}
+
+// postPage は投稿ページの種類ごとの、リアクションのボタンの場所と閲覧できるかの判定
+type postPage struct {
+	// toolbar はリアクションのボタンが並ぶツールバーのセレクタ
+	toolbar string
+	// addButton はツールバーのリアクションボタンのセレクタ
+	addButton string
+	// availability は投稿ページを判定するスクリプト。
+	// リアクションのツールバーがあれば空文字、閲覧できない旨のページであればスキップ理由、まだ判別できなければ null を返す。
+	availability string
+	// reacted は自分がすでにリアクションしているかを返すスクリプト。NUXT のデータの viewer_has_reacted を優先し、
+	// データが見つからない場合はツールバーのボタンの選択状態で判定する
+	reacted string
+}
+
+// pageLayout はPC版・スマートフォン版のどちらのレイアウトで表示するかによって変わるセレクタ
+type pageLayout struct {
+	// footer はページの読み込みを待つフッター、feed はタイムラインのフィードのセレクタ
+	footer, feed string
+	// activity は活動日記 (/activities/{id})、moment はモーメント (/moments/{id}) のページ。
+	// モーメントのページは活動日記とは別のコンポーネントで、ツールバーのクラス名が異なる。
+	// また、下に他のモーメントが続けて表示されるため、リアクションボタンは開いたモーメントのツールバーの中に限る
+	activity, moment postPage
+}
+
+// desktopLayout はPC版、mobileLayout はスマートフォン版 (-mobile) のレイアウト。
+// スマートフォン版ではフッターの代わりに画面下部のタブバーが表示され、活動日記のツールバーは画面下部に固定されたものになる
+var (
+	desktopLayout = pageLayout{
+		footer:   ".FooterNav",
+		feed:     ".TimelineList__Feed",
+		activity: newPostPage(".ActivitiesId__ActivityToolBarContainer", "ActivitiesId__", false),
+		moment:   newPostPage(`[class*="MomentsId__"][class*="ToolBar"]`, "MomentsId__", true),
+	}
+	mobileLayout = pageLayout{
+		footer:   `.FooterNav, [class*="TabBar"]`,
+		feed:     `[class*="TimelineList__Feed"]`,
+		activity: newPostPage(`[class*="ActivitiesId__"][class*="ToolBar"]`, "ActivitiesId__", true),
+		moment:   newPostPage(`[class*="MomentsId__"][class*="ToolBar"]`, "MomentsId__", true),
+	}
+)
+
+// layout は使用中のレイアウト。-mobile の場合は mobileLayout になる
+var layout = desktopLayout
+
+// mobileDevice は -mobile でエミュレートする端末 (画面サイズ・UA・タッチ操作)
+var mobileDevice = device.IPhone13
+
+// postPageOf は投稿のURLからページの種類を返す
+func postPageOf(url string) postPage {
+	if strings.Contains(url, "yamap.com/moments/") {
+		return layout.moment
+	}
+	return layout.activity
+}
+
+// newPostPage は postPage を作る。classPrefix はページの要素のクラス名の接頭辞で、描画途中かの判定に使う。
+// scoped の場合はリアクションボタンを toolbar の中に限る
+func newPostPage(toolbar, classPrefix string, scoped bool) postPage {
+	page := postPage{toolbar: toolbar, addButton: emojiAddButtonSelector}
+	if scoped {
+		var sels []string
+		for _, sel := range strings.Split(emojiAddButtonSelector, ", ") {
+			sels = append(sels, toolbar+" "+sel)
+		}
+		page.addButton = strings.Join(sels, ", ")
+	}
+	encoded, _ := json.Marshal(activityUnavailablePhrases)
+	page.availability = fmt.Sprintf(`(() => {
+		if (document.querySelector(%s)) return "";
+		const entries = %s;
+		// NUXT のエラーページは描画を待たずにステータスコードで判定する
+		const nuxtError = window.__NUXT__ && window.__NUXT__.error;
+		if (nuxtError && nuxtError.statusCode) {
+			const entry = entries.find(e => (e.statuses || []).includes(Number(nuxtError.statusCode)));
+			if (entry) return entry.reason;
+		}
+		// 投稿ページの要素がある場合は描画途中とみなし、タイトルなどの文言では判定しない
+		if (document.querySelector(%s)) return null;
+		const texts = [document.title, ...Array.from(document.querySelectorAll("h1, h2, main p")).map(e => e.textContent)]
+			.map(t => (t || "").toLowerCase());
+		for (const entry of entries) {
+			if (entry.phrases.some(p => texts.some(t => t.includes(p.toLowerCase())))) return entry.reason;
+		}
+		return null;
+	})()`, jsString(toolbar), encoded, jsString(`[class*="`+classPrefix+`"]`))
+	page.reacted = `(() => {
+		const nuxt = window.__NUXT__ || {};
+		const candidates = [];
+		if (nuxt.state) for (const key of ["activity", "moment"]) if (nuxt.state[key]) candidates.push(nuxt.state[key][key], nuxt.state[key]);
+		for (const d of (nuxt.data || [])) if (d) candidates.push(d.activity, d.moment, d);
+		const post = candidates.find(c => c && typeof c === "object" && Array.isArray(c.emoji_reactions));
+		if (post) return post.emoji_reactions.some(r => r && r.viewer_has_reacted);
+		const toolbar = document.querySelector(` + jsString(toolbar) + `);
+		if (!toolbar) return false;
+		const add = ` + jsString(emojiAddButtonSelector) + `;
+		return Array.from(toolbar.querySelectorAll("button")).filter(b => !b.matches(add)).some(` + ownReactionFilter + `);
+	})()`
+	return page
+}
+
+// uiLabels はaria-labelなどに使われるUI文言をロケールごとに保持する。
+// 英語設定のアカウントでは日本語の文言が英語に置き換わるため、セレクタは両方の表記に対応させる。
+var uiLabels = map[string][]string{
+	"send-emoji": {"絵文字をおくる", "Send emoji"},
+	"close":      {"閉じる", "Close"},
+	"follow":     {"フォローする", "Follow"},
+	"following":  {"フォロー中", "Following"},
+}
+
+// labelSelector は uiLabels の全ての表記にマッチする属性セレクタを返す (例: button[aria-label="..."])
+func labelSelector(element, attr, key string) string {
+	var sels []string
+	for _, label := range uiLabels[key] {
+		sels = append(sels, fmt.Sprintf(`%s[%s=%s]`, element, attr, jsString(label)))
+	}
+	return strings.Join(sels, ", ")
+}
+
+// emojiAddButtonSelector はリアクションボタンのセレクタ。クラス名とaria-label (日本語/英語) のいずれかにマッチする
+var emojiAddButtonSelector = ".emoji-add-button, " + labelSelector("button", "aria-label", "send-emoji")
+
+// 投稿ごとのタイムアウトと、その中でリアクションを1回試行するごとのタイムアウトの既定値
+const (
+	defaultPostTimeout    = 90 * time.Second
+	defaultAttemptTimeout = 30 * time.Second
+)
+
+// reactionTimeouts は REACTION_POST_TIMEOUT, REACTION_ATTEMPT_TIMEOUT から投稿ごとと試行ごとのタイムアウトを返す。
+// REACTION_ATTEMPT_TIMEOUT が未設定の場合は設定ファイルの retry.reaction.timeout を使う。
+// 値が不正な場合は警告して既定値を使う。試行ごとのタイムアウトは投稿ごとのタイムアウトを超えない
+var reactionTimeouts = sync.OnceValues(func() (post, attempt time.Duration) {
+	parse := func(name string, def time.Duration) time.Duration {
+		v := os.Getenv(name)
+		if v == "" {
+			return def
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Printf(tr("警告: %sの値が不正です。既定値 %s を使用します: %s"), name, def, v)
+			return def
+		}
+		return d
+	}
+	post = parse("REACTION_POST_TIMEOUT", defaultPostTimeout)
+	attempt = min(parse("REACTION_ATTEMPT_TIMEOUT", config.Retry.Reaction.or(defaultReactionRetry).timeout), post)
+	return post, attempt
+})
+
+// sendReaction は投稿に絵文字リアクションを送る。emoji が空の場合は設定のルールに従って選ぶ。
+// 送信に成功した場合は、実際に送った絵文字 (ピッカーのボタンのラベルが取得できない場合は空) も返す。
+// 投稿全体は REACTION_POST_TIMEOUT、ピッカーを開いて絵文字を選ぶ1回の試行は REACTION_ATTEMPT_TIMEOUT で打ち切る。
+func sendReaction(parentCtx context.Context, url, emoji string) (liked bool, sent string, err error) {
+	parentCtx, span := startSpan(parentCtx, "reaction", "url.full", url)
+	defer func() {
+		span.setAttr("reaction.emoji", sent)
+		span.finish(err)
+	}()
+	postTimeout, attemptTimeout := reactionTimeouts()
+	reactionCtx, cancel := context.WithTimeout(parentCtx, postTimeout)
+	defer cancel()
+
+	drv := driverFromContext(parentCtx)
+	page := postPageOf(url)
+	l := loggerFromContext(parentCtx)
+	l.Printf(tr("投稿ページに移動してリアクションを送信します: %s"), url)
+	status.setCurrentURL(url)
+	events.publish("navigating", url, "", "")
+
+	loadStart := time.Now()
+	if err := openPost(reactionCtx, drv, url); err != nil {
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			return false, "", err
+		}
+		l.Println(tr("リアクションページの基本読み込みに失敗しました。"))
+		return false, "", fmt.Errorf(tr("投稿ページの基本読み込みに失敗: %w"), err)
+	}
+	pace.observe(time.Since(loadStart))
+
+	// ブロックされている・削除された・非公開の投稿では、リアクションボタンの待機でタイムアウトするまで時間を浪費するため、
+	// 投稿ページか閲覧できない旨のページかが判別できた時点ですぐに判定する
+	var unavailable string
+	if err := runActions(reactionCtx,
+		drv.Poll(`(`+page.availability+`) !== null`, 10*time.Second),
+		drv.Evaluate(page.availability, &unavailable),
+	); err == nil && unavailable != "" {
+		return false, "", &skipError{reason: tr(unavailable)}
+	}
+	// 履歴に記録がない投稿 (HISTORY_FILE を使わない場合など) にも重ねて送らないよう、送る前にページの状態で確かめる
+	if viewerHasReacted(reactionCtx, drv, page) {
+		return false, "", &skipError{reason: tr(alreadyReactedReason)}
+	}
+
+	if emoji == "" {
+		emoji = chooseEmoji(reactionCtx, drv)
+	}
+
+	l.Println(tr("リアクションボタンが表示されるまでスクロールします..."))
+	if err := runActions(reactionCtx,
+		// ツールバーが表示領域に入るまでスクロール
+		drv.ScrollIntoView(page.toolbar),
+		drv.WaitVisible(page.addButton),
+	); err != nil {
+		l.Println(tr("リアクションボタンの表示待機に失敗しました。"))
+		return false, "", fmt.Errorf(tr("リアクションボタンの表示待機に失敗: %w"), err)
+	}
+
+	policy := config.Retry.Reaction.or(defaultReactionRetry)
+	var sendErr error
+	for i := 0; i < policy.Attempts; i++ {
+		if errors.Is(sendErr, errRendererCrashed) {
+			// クラッシュしたページはリロードしても操作できないため、この投稿は失敗として次の投稿に進む
+			break
+		}
+		l.Printf(tr("リアクション試行 %d回目: %s"), i+1, url)
+
+		// 止まったページで投稿全体の時間を使い切らないよう、1回の試行は短く打ち切ってリロードからやり直す
+		attemptCtx, cancelAttempt := context.WithTimeout(reactionCtx, attemptTimeout)
+		attemptCtx, attemptSpan := startSpan(attemptCtx, "reaction attempt", "reaction.attempt", i+1)
+		var label string
+		label, sendErr = attemptReaction(attemptCtx, drv, page, emoji)
+		attemptSpan.finish(sendErr)
+		cancelAttempt()
+		if sendErr == nil {
+			l.Printf(tr("リアクションの送信に成功しました: %s"), url)
+			status.markStep()
+			return true, label, nil
+		}
+		if errors.Is(sendErr, context.DeadlineExceeded) && reactionCtx.Err() == nil {
+			sendErr = fmt.Errorf(tr("試行のタイムアウト (%s) を超えました: %w"), attemptTimeout, sendErr)
+		}
+
+		l.Printf(tr("試行 %d回目が失敗しました (%s): %v"), i+1, url, sendErr)
+
+		if errors.Is(sendErr, errRendererCrashed) {
+			break
+		}
+		if reactionCtx.Err() != nil {
+			l.Printf(tr("コンテキストエラーのためリアクション処理を中断します: %v"), reactionCtx.Err())
+			break
+		}
+
+		if i < policy.Attempts-1 {
+			if policy.OnRetry == retryNavigate {
+				l.Println(tr("投稿ページを開き直して再試行します..."))
+				if err := openPost(reactionCtx, drv, url); err != nil {
+					return false, "", fmt.Errorf(tr("投稿ページの基本読み込みに失敗: %w"), err)
+				}
+				if err := runActions(reactionCtx, drv.ScrollIntoView(page.toolbar), drv.WaitVisible(page.addButton)); err != nil {
+					return false, "", fmt.Errorf(tr("リアクションボタンの表示待機に失敗: %w"), err)
+				}
+			} else {
+				l.Println(tr("ページをリロードして再試行します..."))
+				if err := runActions(reactionCtx, drv.Reload(), drv.WaitVisible(page.addButton)); err != nil {
+					l.Printf(tr("リロードに失敗: %v"), err)
+					return false, "", fmt.Errorf(tr("リロード後のボタン待機に失敗: %w"), err)
+				}
+			}
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	if errors.Is(sendErr, errRendererCrashed) {
+		return false, "", sendErr
+	}
+	return false, "", fmt.Errorf(tr("リアクションの送信に失敗しました（%d回試行）: %w"), policy.Attempts, sendErr)
+}
+
+// viewerHasReacted は表示中の投稿ページで、自分がすでにリアクションしているかを返す。判定できない場合は false
+func viewerHasReacted(ctx context.Context, drv pageDriver, page postPage) bool {
+	var reacted bool
+	return runActions(ctx, drv.Evaluate(page.reacted, &reacted)) == nil && reacted
+}
+
+// openPost は投稿ページを開き、フッター (layout.footer) が表示されるまで待つ。設定ファイルの retry.navigation に従って再試行する。
+// 削除済み・非公開などの閲覧できない旨のページが表示された場合は、フッターを待たず、再試行もせずに *skipError を返す
+func openPost(ctx context.Context, drv pageDriver, url string) error {
+	policy := config.Retry.Navigation.or(defaultNavigationRetry)
+	postTimeout, _ := reactionTimeouts()
+	page := postPageOf(url)
+	loaded := `document.querySelector(` + jsString(layout.footer) + `) !== null || !!(` + page.availability + `)`
+	var err error
+	for attempt := 1; attempt <= policy.Attempts; attempt++ {
+		load := drv.Navigate(url)
+		if attempt > 1 {
+			loggerFromContext(ctx).Printf(tr("投稿ページの読み込みを再試行します (%d/%d回目): %v"), attempt, policy.Attempts, err)
+			if policy.OnRetry == retryReload {
+				load = drv.Reload()
+			}
+		}
+		attemptCtx, cancel := policy.withTimeout(ctx)
+		err = runActions(attemptCtx, load, drv.Poll(loaded, postTimeout))
+		cancel()
+		if err == nil {
+			var unavailable string
+			if err := runActions(ctx, drv.Evaluate(page.availability, &unavailable)); err == nil && unavailable != "" {
+				return &skipError{reason: tr(unavailable)}
+			}
+			return runActions(ctx, drv.WaitVisible(layout.footer))
+		}
+		if ctx.Err() != nil || errors.Is(err, errRendererCrashed) {
+			return err
+		}
+	}
+	return err
+}
+
+// attemptReaction は表示済みのリアクションボタンから絵文字ピッカーを開き、絵文字を1回選んで送る。
+// emoji がピッカーに見つからない場合は最初の絵文字を送る。送った絵文字 (ラベルが取得できない場合は空) を返す
+func attemptReaction(ctx context.Context, drv pageDriver, page postPage, emoji string) (string, error) {
+	pickerStart := time.Now()
+	if err := runActions(ctx,
+		drv.Click(page.addButton),
+		drv.WaitVisible(`.emojiPickerBody`),
+	); err != nil {
+		loggerFromContext(ctx).Printf(tr("絵文字ピッカーの表示に失敗: %v"), err)
+		return "", err
+	}
+	pace.observe(time.Since(pickerStart))
+	if err := runActions(ctx, sleepAction(2*time.Second)); err != nil {
+		return "", err
+	}
+
+	if emoji != "" {
+		var found bool
+		loggerFromContext(ctx).Printf(tr("絵文字ピッカーから絵文字 %q を選択してクリックします。"), emoji)
+		if err := runActions(ctx,
+			drv.Evaluate(clickEmojiScript(emoji), &found),
+			sleepAction(3*time.Second),
+		); err != nil {
+			return "", err
+		}
+		if found {
+			return emoji, nil
+		}
+		loggerFromContext(ctx).Printf(tr("絵文字 %q がピッカーに見つからないため、最初の絵文字を使用します。"), emoji)
+	}
+
+	// 以前はリアクション済みの絵文字をクリックしようとしていたが、
+	// 0件の場合はピッカーから選択する必要があるためロジックを修正。
+	// ピッカー内の最初の絵文字ボタンをクリックする。
+	loggerFromContext(ctx).Println(tr("絵文字ピッカーから最初の絵文字を選択してクリックします。"))
+	var firstLabel string
+	err := runActions(ctx,
+		drv.Evaluate(firstEmojiLabelScript, &firstLabel),
+		// ユーザーのフィードバックに基づき、リアクションの有無両方のパターンに対応
+		drv.Click(firstEmojiSelector),
+		sleepAction(3*time.Second), // Wait for the reaction to be sent
+	)
+	return firstLabel, err
+}
+
+// firstEmojiSelector は絵文字ピッカー内の最初の絵文字ボタンのセレクタ
+const firstEmojiSelector = `.emojiButton.emoji-button:first-child, .emoji-picker-button:first-child`
+
+// firstEmojiLabelScript は最初の絵文字ボタンのラベル (aria-label・title・画像の alt・表示文字の順) を返すスクリプト。見つからない場合は空文字
+const firstEmojiLabelScript = `(() => {
+	const b = document.querySelector(` + "`" + firstEmojiSelector + "`" + `);
+	if (!b) return "";
+	const img = b.querySelector("img[alt]");
+	return (b.getAttribute("aria-label") || b.getAttribute("title") || (img && img.getAttribute("alt")) || b.textContent || "").trim();
+})()`
@@ -0,0 +1,461 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runActivitiesReaction は活動一覧ページへのリアクション処理全体を実行する
+func runActivitiesReaction() error {
+	log.Println(tr("--- プログラム開始 (react-activities) ---"))
+	startTime := time.Now()
+
+	allocatorCtx, cancelAllocator := context.WithTimeout(context.Background(), runTimeout()+5*time.Minute)
+	defer cancelAllocator()
+
+	ctx, cancel, err := startBrowser(allocatorCtx)
+	if err != nil {
+		return fmt.Errorf(tr("ブラウザの起動に失敗しました: %w"), err)
+	}
+	defer cancel()
+
+	ctx, cancel = context.WithTimeout(ctx, runTimeout())
+	status.setCancel(cancel)
+	defer cancel()
+	loggerFromContext(ctx).Println(tr("ブラウザの初期化完了。"))
+	status.setBrowser(ctx)
+	status.setPhase("logging-in")
+
+	loggerFromContext(ctx).Println(tr("環境変数を読み込んでいます..."))
+	email := os.Getenv("YAMAP_EMAIL")
+	password, err := resolvePassword(email)
+	if err != nil {
+		return fmt.Errorf(tr("パスワードの取得に失敗しました: %w"), err)
+	}
+	postCountStr := os.Getenv("ACTIVITIES_POST_COUNT_TO_PROCESS")
+	if email == "" || password == "" || postCountStr == "" {
+		return errors.New(tr("環境変数 YAMAP_EMAIL, YAMAP_PASSWORD, ACTIVITIES_POST_COUNT_TO_PROCESS を設定してください"))
+	}
+	postCount, err := strconv.Atoi(postCountStr)
+	if err != nil {
+		return fmt.Errorf(tr("ACTIVITIES_POST_COUNT_TO_PROCESSの値が不正です: %w"), err)
+	}
+	loggerFromContext(ctx).Println(tr("環境変数の読み込み完了。"))
+
+	loggerFromContext(ctx).Println(tr("ログイン処理を開始します..."))
+	loginStartTime := time.Now()
+	// login関数はタイムラインへの遷移をハードコーディングしているので、ここではfalseを渡して遷移をスキップさせる
+	if err := login(ctx, email, password, false); err != nil {
+		return loginError(err)
+	}
+	loggerFromContext(ctx).Printf(tr("ログイン成功。処理時間: %s"), time.Since(loginStartTime))
+	ctx = withSession(ctx, discoverSession(ctx))
+	status.setPhase("collecting")
+	status.markStep()
+
+	loggerFromContext(ctx).Println(tr("活動一覧ページの処理を開始します..."))
+	activitiesStartTime := time.Now()
+	reactedURLs, err := processActivities(ctx, postCount)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("活動一覧ページの処理中にエラーが発生しました: %v"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("活動一覧ページの処理完了。処理時間: %s"), time.Since(activitiesStartTime))
+
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+
+	printDependencies()
+	return nil
+}
+
+// runThankFollowers は前回の実行以降に増えたフォロワーの最新の投稿にリアクションやお礼コメントを送る
+func runThankFollowers() error {
+	log.Println(tr("--- プログラム開始 (thank-followers) ---"))
+	startTime := time.Now()
+
+	if history == nil {
+		return errors.New(tr("thank-followers では新しいフォロワーを判別するために HISTORY_FILE を設定してください"))
+	}
+	maxThanks := 20
+	if v := os.Getenv("THANK_FOLLOWERS_MAX"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("THANK_FOLLOWERS_MAXの値が不正です: %s"), v)
+		}
+		maxThanks = n
+	}
+	react := os.Getenv("THANK_FOLLOWERS_REACT") != "false"
+	if !react && len(config.thankYouTemplates) == 0 {
+		return errors.New(tr("THANK_FOLLOWERS_REACT=false の場合は設定ファイルに thank_you_templates を指定してください"))
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、フォロワー一覧を確認できません"))
+	}
+	status.setPhase("collecting")
+	status.markStep()
+
+	followers, err := collectFollowers(ctx, sess.UserID)
+	if err != nil {
+		return fmt.Errorf(tr("フォロワー一覧の取得に失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d人のフォロワーを確認しました。"), len(followers))
+
+	known := history.knownFollowers()
+	if known == nil {
+		// 初回は既存のフォロワー全員にお礼を送らないよう、現在のフォロワーを基準として記録するだけにする
+		if err := history.addFollowers(followers); err != nil {
+			return fmt.Errorf(tr("フォロワー一覧の保存に失敗しました: %w"), err)
+		}
+		loggerFromContext(ctx).Println(tr("初回の実行のため、現在のフォロワーを記録しました。次回以降の実行で新しいフォロワーにお礼を送ります。"))
+		status.setPhase("done")
+		sdNotify("STOPPING=1")
+		return nil
+	}
+	var newFollowers []int64
+	for _, id := range followers {
+		if _, ok := known[id]; !ok {
+			newFollowers = append(newFollowers, id)
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("新しいフォロワー: %d人"), len(newFollowers))
+	if len(newFollowers) > maxThanks {
+		loggerFromContext(ctx).Printf(tr("上限 (%d人) を超えた分は次回以降に処理します。"), maxThanks)
+		newFollowers = newFollowers[:maxThanks]
+	}
+
+	status.setPhase("reacting")
+	pace.spreadOver(ctx, len(newFollowers))
+	var thanked []string
+	for i, id := range newFollowers {
+		if waitForKillSwitch(ctx) == killSwitchStop || maxRuntimeReached() || ctx.Err() != nil || !waitForOperatingHours(ctx) || (react && (!waitForHourlyQuota(ctx) || !withinDomoBudget(ctx))) {
+			loggerFromContext(ctx).Println(tr("停止の指示、時間切れ、稼働時間の外またはリアクションの上限のため、残りのフォロワーは次回以降に処理します。"))
+			break
+		}
+		recycleTabIfNeeded(ctx)
+		loggerFromContext(ctx).Printf(tr("--- フォロワー %d/%d (ID: %d) を処理中 ---"), i+1, len(newFollowers), id)
+		url, err := latestActivityURL(ctx, id)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("最新の投稿の取得に失敗しました。次回の実行で再試行します: %v"), err)
+			continue
+		}
+		if url == "" {
+			loggerFromContext(ctx).Println(tr("投稿がないため、お礼は送らずに確認済みとします。"))
+		} else if err := thankFollower(ctx, url, react); err != nil {
+			loggerFromContext(ctx).Printf(tr("お礼の送信に失敗しました。次回の実行で再試行します (%s): %v"), url, err)
+			continue
+		} else {
+			thanked = append(thanked, url)
+			if err := history.markThanked(id, time.Now()); err != nil {
+				loggerFromContext(ctx).Printf(tr("警告: お礼の記録に失敗しました: %v"), err)
+			}
+		}
+		if err := history.addFollowers([]int64{id}); err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: フォロワー一覧の保存に失敗しました: %v"), err)
+		}
+		pace.wait(ctx)
+	}
+
+	if len(thanked) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- お礼を送った投稿一覧 ---"))
+		for _, url := range thanked {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// planPath は -plan フラグで指定されたプランファイルのパス
+var planPath string
+
+// plan はリアクションする予定の投稿の一覧。plan で書き出し、確認・編集した後に apply で実行する
+type plan struct {
+	CreatedAt  time.Time   `json:"created_at"`
+	Source     string      `json:"source"`
+	Activities []planEntry `json:"activities"`
+}
+
+type planEntry struct {
+	URL        string    `json:"url"`
+	AuthorID   int64     `json:"author_id,omitempty"`
+	AuthorName string    `json:"author_name,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	PostedAt   time.Time `json:"posted_at,omitzero"`
+	// Emoji が空の場合は apply の実行時に絵文字のルールで選ぶ
+	Emoji string `json:"emoji,omitempty"`
+}
+
+// runPlan はリアクション対象の投稿を収集し、投稿者・タイトル・送る絵文字をプランファイルに書き出す。リアクションは送らない
+func runPlan() error {
+	log.Println(tr("--- プログラム開始 (plan) ---"))
+	startTime := time.Now()
+
+	source := os.Getenv("PLAN_SOURCE")
+	if source == "" {
+		source = "timeline"
+	}
+	countEnv := map[string]string{"timeline": "TIMELINE_POST_COUNT_TO_PROCESS", "activities": "ACTIVITIES_POST_COUNT_TO_PROCESS"}[source]
+	if countEnv == "" {
+		return fmt.Errorf(tr("PLAN_SOURCEの値が不正です: %s (timeline, activities のいずれかを指定してください)"), source)
+	}
+	postCount, err := strconv.Atoi(os.Getenv(countEnv))
+	if err != nil {
+		return fmt.Errorf(tr("%sの値が不正です: %w"), countEnv, err)
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(source == "timeline")
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	status.markStep()
+
+	var activities []ActivityInfo
+	if source == "timeline" {
+		activities, err = collectTimeline(ctx, postCount)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("タイムラインの収集中にエラーが発生しました: %v"), err)
+		}
+	} else {
+		activities = collectActivities(ctx, postCount)
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の投稿を収集しました。"), len(activities))
+	// apply ではリアクションの数がわからないため、プランの時点で処理する順に並べておく
+	activities = orderQueue(ctx, activities)
+
+	p := plan{CreatedAt: time.Now(), Source: source}
+	drv := driverFromContext(ctx)
+	for i, activity := range activities {
+		entry := planEntry{URL: activity.URL, AuthorID: activity.AuthorID, AuthorName: activity.AuthorName, Title: activity.Title, PostedAt: activity.PostedAt, Emoji: config.DefaultEmoji}
+		// 絵文字のルールの評価やタイトルの表示には投稿ページの情報が必要なため、必要な場合のみ投稿ページを開く
+		if (len(config.EmojiRules) > 0 || entry.Title == "") && ctx.Err() == nil && !maxRuntimeReached() {
+			loggerFromContext(ctx).Printf(tr("投稿の情報を取得しています (%d/%d): %s"), i+1, len(activities), activity.URL)
+			var meta activityMetadata
+			err := runActions(ctx, drv.Navigate(activity.URL), drv.WaitVisible(layout.footer), drv.WaitNetworkIdle())
+			if err == nil {
+				meta, err = fetchActivityMetadata(ctx, drv)
+			}
+			if err != nil {
+				loggerFromContext(ctx).Printf(tr("投稿の情報の取得に失敗しました: %v"), err)
+			} else {
+				entry.Emoji = emojiForMetadata(ctx, meta)
+				if entry.Title == "" {
+					entry.Title = meta.Title
+				}
+				if entry.AuthorName == "" {
+					entry.AuthorName = meta.Author
+				}
+				if entry.AuthorID == 0 {
+					entry.AuthorID = meta.AuthorID
+				}
+				if entry.PostedAt.IsZero() {
+					entry.PostedAt = meta.postedAt()
+				}
+			}
+			status.markStep()
+			pace.wait(ctx)
+		}
+		p.Activities = append(p.Activities, entry)
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf(tr("プランの作成に失敗しました: %w"), err)
+	}
+	if err := os.WriteFile(planPath, data, 0644); err != nil {
+		return fmt.Errorf(tr("プランファイルの書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の投稿を含むプランを %s に書き出しました。内容を確認・編集してから -action apply で実行してください。"), len(p.Activities), planPath)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// runApply はプランファイルに記載された投稿だけに、記載された絵文字でリアクションを送る
+func runApply() error {
+	log.Println(tr("--- プログラム開始 (apply) ---"))
+	startTime := time.Now()
+
+	data, err := os.ReadFile(planPath)
+	if err != nil {
+		return fmt.Errorf(tr("プランファイルの読み込みに失敗しました: %w"), err)
+	}
+	var p plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf(tr("プランファイルの形式が不正です: %w"), err)
+	}
+	activities := make([]ActivityInfo, 0, len(p.Activities))
+	for i, entry := range p.Activities {
+		if !strings.HasPrefix(entry.URL, "https://yamap.com/activities/") {
+			return fmt.Errorf(tr("プランの %d 件目のURLが活動日記のURLではありません: %s"), i+1, entry.URL)
+		}
+		activities = append(activities, ActivityInfo{URL: entry.URL, AuthorID: entry.AuthorID, AuthorName: entry.AuthorName, Title: entry.Title, PostedAt: entry.PostedAt, Emoji: entry.Emoji})
+	}
+	log.Printf(tr("%s に作成されたプラン (%d件) を実行します。"), p.CreatedAt.Local().Format("2006-01-02 15:04"), len(activities))
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	status.markStep()
+
+	reactedURLs := reactToActivities(ctx, activities)
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// unreactURLsPath は -urls フラグで指定された、unreact の対象の投稿URLの一覧ファイル
+var unreactURLsPath string
+
+// unreactTargets は unreact でリアクションを取り消す投稿を返す。-urls が指定されていればそのファイルから、
+// なければリアクション履歴から -since, -until, -author, -history-action に一致する投稿を選ぶ
+func unreactTargets() ([]historyEntry, error) {
+	if unreactURLsPath != "" {
+		data, err := os.ReadFile(unreactURLsPath)
+		if err != nil {
+			return nil, err
+		}
+		var targets []historyEntry
+		for i, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if !strings.HasPrefix(line, "https://yamap.com/activities/") {
+				return nil, fmt.Errorf(tr("%d 行目のURLが活動日記のURLではありません: %s"), i+1, line)
+			}
+			targets = append(targets, historyEntry{URL: line})
+		}
+		return targets, nil
+	}
+	if history == nil {
+		return nil, errors.New(tr("取り消すリアクションを選ぶには HISTORY_FILE を設定するか、-urls で投稿URLの一覧を指定してください"))
+	}
+	filter, err := historyFilterFromFlags()
+	if err != nil {
+		return nil, err
+	}
+	if filter.isZero() {
+		return nil, errors.New(tr("すべてのリアクションの取り消しを防ぐため、-since, -until, -author, -history-action のいずれかを指定してください"))
+	}
+	return history.matching(filter), nil
+}
+
+// runUnreact は誤った条件で実行してしまった場合などに、送ったリアクションを投稿ページで取り消す
+func runUnreact() error {
+	log.Println(tr("--- プログラム開始 (unreact) ---"))
+	startTime := time.Now()
+
+	targets, err := unreactTargets()
+	if err != nil {
+		return fmt.Errorf(tr("取り消すリアクションの選択に失敗しました: %w"), err)
+	}
+	if len(targets) == 0 {
+		log.Println(tr("取り消すリアクションはありません。"))
+		return nil
+	}
+	log.Printf(tr("%d件の投稿のリアクションを取り消します。"), len(targets))
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	status.markStep()
+
+	queue := make([]string, len(targets))
+	for i, t := range targets {
+		queue[i] = t.URL
+	}
+	status.setQueue(queue)
+	pace.spreadOver(ctx, len(queue))
+	removed := 0
+	for i, target := range targets {
+		if waitForKillSwitch(ctx) == killSwitchStop {
+			loggerFromContext(ctx).Println(tr("キルスイッチにより停止が指示されたため、取り消しを終了します。"))
+			break
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Printf(tr("最大実行時間 (%s) に達したため、新しい投稿の処理を終了します。"), maxRuntime)
+			break
+		}
+		if !waitForOperatingHours(ctx) {
+			break
+		}
+		recycleTabIfNeeded(ctx)
+		loggerFromContext(ctx).Printf(tr("--- 投稿 %d/%d を処理中 ---"), i+1, len(targets))
+		err := removeReaction(ctx, target.URL, target.Emoji)
+		status.recordResult(err == nil, err)
+		events.publishResult(target.URL, target.Emoji, err)
+		recordPostOutcome(ctx, target.URL, err)
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			loggerFromContext(ctx).Printf(tr("投稿をスキップしました (%s): %s"), target.URL, skipErr.reason)
+		} else if err != nil {
+			loggerFromContext(ctx).Printf(tr("リアクションの取り消しでエラーが発生しました (%s): %v"), target.URL, err)
+		} else {
+			removed++
+			loggerFromContext(ctx).Printf(tr("リアクションを取り消しました: %s (現在 %d/%d 件)"), target.URL, removed, len(targets))
+			if err := history.forget(target.URL); err != nil {
+				loggerFromContext(ctx).Printf(tr("警告: リアクション履歴の更新に失敗しました: %v"), err)
+			}
+		}
+		if ctx.Err() != nil {
+			loggerFromContext(ctx).Println(tr("メインコンテキストがキャンセルされたため、取り消しを中断します。"))
+			break
+		}
+		pace.wait(ctx)
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- リアクションの取り消しが完了しました (%d/%d 件) ---"), removed, len(targets))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
@@ -0,0 +1,39 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestSendReactionLogsToContextLogger は sendReaction のログが withLogger で紐づけた出力先に書かれ、
+// 標準の log パッケージには書かれないことを確かめる
+func TestSendReactionLogsToContextLogger(t *testing.T) {
+	const url = "https://yamap.com/activities/1"
+	var global bytes.Buffer
+	orig := log.Writer()
+	log.SetOutput(&global)
+	t.Cleanup(func() { log.SetOutput(orig) })
+
+	// 投稿ページを開いた時点でレンダラーがクラッシュした記録を読み戻し、再試行せずに失敗させる
+	drv := replayDriver{mu: &sync.Mutex{}, next: new(int), exchanges: []sessionExchange{
+		{Method: "Navigate", Args: []string{url}, Error: "renderer crashed", Sentinel: "renderer-crashed"},
+	}}
+	var captured bytes.Buffer
+	ctx := withLogger(context.WithValue(context.Background(), driverContextKey{}, pageDriver(drv)), log.New(&captured, "", 0))
+
+	if liked, _, err := sendReaction(ctx, url, ""); liked || err == nil {
+		t.Fatalf("sendReaction = %v, %v; want failure", liked, err)
+	}
+	for _, want := range []string{url, tr("リアクションページの基本読み込みに失敗しました。")} {
+		if !strings.Contains(captured.String(), want) {
+			t.Errorf("context logger output %q does not contain %q", captured.String(), want)
+		}
+	}
+	if global.Len() != 0 {
+		t.Errorf("standard logger got %q; want nothing", global.String())
+	}
+}
@@ -0,0 +1,137 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dedupeClaimTTL は投稿を処理中として確保しておく時間。確保したインスタンスが異常終了しても、この時間が過ぎれば他のインスタンスが処理できる
+const dedupeClaimTTL = 10 * time.Minute
+
+// dedupe は REDIS_URL が設定されている場合の、複数のインスタンスで同じ投稿を重ねて処理しないための共有の記録。未設定の場合は nil
+var dedupe *redisDedupe
+
+// redisDedupe はRedisのキー (<REDIS_KEY_PREFIX>:<ユーザーID>:<投稿ID>) で投稿の処理状況をインスタンス間で共有する。
+// 処理を始める前に SET NX で投稿を確保し、リアクションに成功したら REDIS_DEDUPE_TTL の間リアクション済みとして残す。
+// 成功しなかった場合はキーを消し、他のインスタンスや次の実行が改めて処理できるようにする
+type redisDedupe struct {
+	client *redis.Client
+	prefix string
+	ttl    time.Duration
+	// owner は確保したキーに書き込むインスタンスの識別子 (ホスト:PID)
+	owner string
+}
+
+// newRedisDedupeFromEnv は REDIS_URL, REDIS_KEY_PREFIX (既定値 yamap-auto-domo), REDIS_DEDUPE_TTL (既定値 720h) から共有の記録を作る。
+// REDIS_URL が未設定の場合は nil を返す。接続は最初に使うときに開く
+func newRedisDedupeFromEnv() (*redisDedupe, error) {
+	raw := os.Getenv("REDIS_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	client, err := newRedisClient(raw)
+	if err != nil {
+		return nil, errors.New(tr("REDIS_URLの値が不正です (redis://:パスワード@ホスト:6379/0 の形式で指定してください)"))
+	}
+	d := &redisDedupe{client: client, prefix: "yamap-auto-domo", ttl: 30 * 24 * time.Hour}
+	if v := os.Getenv("REDIS_KEY_PREFIX"); v != "" {
+		d.prefix = v
+	}
+	if v := os.Getenv("REDIS_DEDUPE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf(tr("REDIS_DEDUPE_TTLの値が不正です: %s"), v)
+		}
+		d.ttl = ttl
+	}
+	host, _ := os.Hostname()
+	d.owner = fmt.Sprintf("%s:%d", host, os.Getpid())
+	return d, nil
+}
+
+// key は投稿の処理状況を記録するキーを返す。同じ投稿でもアカウントが違えば別々に処理できるよう、ユーザーIDで分ける。
+// ユーザーIDを取得できなかった場合は YAMAP_ACCOUNT のアカウント名で分け (<REDIS_KEY_PREFIX>:account:<アカウント名>:<投稿ID>)、
+// どちらもない場合は別のアカウントの記録と区別できないため false を返す
+func (d *redisDedupe) key(ctx context.Context, activityURL string) (string, bool) {
+	id := activityURL
+	if u, err := neturl.Parse(activityURL); err == nil {
+		id = filepath.Base(strings.TrimSuffix(u.Path, "/"))
+	}
+	if userID := sessionFromContext(ctx).UserID; userID != 0 {
+		return fmt.Sprintf("%s:%d:%s", d.prefix, userID, id), true
+	}
+	if name := os.Getenv("YAMAP_ACCOUNT"); name != "" {
+		return fmt.Sprintf("%s:account:%s:%s", d.prefix, name, id), true
+	}
+	return "", false
+}
+
+// claim は投稿を処理中として確保し、処理しない場合はその理由を返す。他のインスタンスが処理中、またはリアクション済みの場合や、
+// アカウントを区別できずに確保できない場合は理由を返す。Redisに接続できない場合は、リアクションを止めないよう警告を出して処理を続ける
+func (d *redisDedupe) claim(ctx context.Context, activityURL string) string {
+	if d == nil {
+		return ""
+	}
+	key, ok := d.key(ctx, activityURL)
+	if !ok {
+		loggerFromContext(ctx).Printf(tr("ログイン中のユーザーIDを取得できず YAMAP_ACCOUNT も未設定のため、Redisで重複を確認できません。投稿をスキップします: %s"), activityURL)
+		return tr("アカウントを区別できないため重複を確認できない")
+	}
+	claimed, err := d.client.SetNX(ctx, key, "processing "+d.owner, dedupeClaimTTL).Result()
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: Redisで投稿を確保できませんでした。重複の確認をせずに処理します: %v"), err)
+		return ""
+	}
+	if claimed {
+		return ""
+	}
+	holder, _ := d.client.Get(ctx, key).Result()
+	if strings.HasPrefix(holder, "processing ") {
+		loggerFromContext(ctx).Printf(tr("他のインスタンス (%s) が処理中のため、投稿をスキップします: %s"), strings.TrimPrefix(holder, "processing "), activityURL)
+	} else {
+		loggerFromContext(ctx).Printf(tr("他のインスタンスがリアクション済みのため、投稿をスキップします: %s"), activityURL)
+	}
+	return tr("他のインスタンスで処理済み")
+}
+
+// finish は確保した投稿の処理結果を記録する。リアクションした場合は REDIS_DEDUPE_TTL の間リアクション済みとして残し、
+// それ以外は確保を解いて他のインスタンスが処理できるようにする
+func (d *redisDedupe) finish(ctx context.Context, activityURL string, reacted bool) {
+	if d == nil {
+		return
+	}
+	key, ok := d.key(ctx, activityURL)
+	if !ok {
+		return
+	}
+	var err error
+	if reacted {
+		err = d.client.Set(ctx, key, "reacted "+time.Now().Format(time.RFC3339), d.ttl).Err()
+	} else {
+		err = d.client.Del(ctx, key).Err()
+	}
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: Redisに投稿の処理結果を記録できませんでした (%s): %v"), activityURL, err)
+	}
+}
+
+// newRedisClient は redis://ユーザー:パスワード@ホスト:ポート/DB番号 の接続先のクライアントを作る。rediss:// の場合はTLSで接続する。
+// 接続は最初のコマンドを送るときに開き、切れた場合は次のコマンドで繋ぎ直す
+func newRedisClient(raw string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	opts.DialTimeout = 10 * time.Second
+	opts.ReadTimeout = 10 * time.Second
+	opts.WriteTimeout = 10 * time.Second
+	return redis.NewClient(opts), nil
+}
@@ -0,0 +1,167 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRedisDedupe は REDIS_TEST_URL のRedisに、テストごとの接頭辞で共有の記録を作る。未設定の場合はテストをスキップする
+func newTestRedisDedupe(t *testing.T) *redisDedupe {
+	t.Helper()
+	raw := os.Getenv("REDIS_TEST_URL")
+	if raw == "" {
+		t.Skip("REDIS_TEST_URL が未設定のため、Redisのテストをスキップします")
+	}
+	t.Setenv("REDIS_URL", raw)
+	t.Setenv("REDIS_KEY_PREFIX", fmt.Sprintf("test.%s.%d", t.Name(), time.Now().UnixNano()))
+	t.Setenv("REDIS_DEDUPE_TTL", "1h")
+	d, err := newRedisDedupeFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx := context.Background()
+		if keys, err := d.client.Keys(ctx, d.prefix+":*").Result(); err == nil && len(keys) > 0 {
+			d.client.Del(ctx, keys...)
+		}
+		d.client.Close()
+	})
+	return d
+}
+
+func TestRedisDedupeClaimFinish(t *testing.T) {
+	d := newTestRedisDedupe(t)
+	ctx := withSession(context.Background(), &session{UserID: 42})
+	const url = "https://yamap.com/activities/100"
+
+	if reason := d.claim(ctx, url); reason != "" {
+		t.Fatalf("first claim = %q, want claimed", reason)
+	}
+	key := d.prefix + ":42:100"
+	if got, err := d.client.Get(ctx, key).Result(); err != nil || got != "processing "+d.owner {
+		t.Errorf("claimed value = %q (%v), want processing %s", got, err, d.owner)
+	}
+	if ttl := d.client.TTL(ctx, key).Val(); ttl <= 0 || ttl > dedupeClaimTTL {
+		t.Errorf("claim TTL = %v, want up to %v", ttl, dedupeClaimTTL)
+	}
+	// 他のインスタンスは処理中の投稿を確保できない
+	if reason := d.claim(ctx, url); reason != tr("他のインスタンスで処理済み") {
+		t.Errorf("claim while processing = %q, want skipped", reason)
+	}
+	// 別のアカウントは同じ投稿を確保できる
+	if reason := d.claim(withSession(context.Background(), &session{UserID: 43}), url); reason != "" {
+		t.Errorf("claim by another account = %q, want claimed", reason)
+	}
+
+	// リアクションしなかった場合は確保を解き、再び確保できる
+	d.finish(ctx, url, false)
+	if n := d.client.Exists(ctx, key).Val(); n != 0 {
+		t.Errorf("key exists after an unsuccessful finish")
+	}
+	if reason := d.claim(ctx, url); reason != "" {
+		t.Fatalf("claim after release = %q, want claimed", reason)
+	}
+
+	// リアクションした場合は REDIS_DEDUPE_TTL の間残り、確保できない
+	d.finish(ctx, url, true)
+	if got := d.client.Get(ctx, key).Val(); !strings.HasPrefix(got, "reacted ") {
+		t.Errorf("value after reaction = %q, want reacted", got)
+	}
+	if ttl := d.client.TTL(ctx, key).Val(); ttl <= dedupeClaimTTL || ttl > time.Hour {
+		t.Errorf("reacted TTL = %v, want REDIS_DEDUPE_TTL (1h)", ttl)
+	}
+	if reason := d.claim(ctx, url); reason != tr("他のインスタンスで処理済み") {
+		t.Errorf("claim after reaction = %q, want skipped", reason)
+	}
+}
+
+// TestRedisDedupeKeyWithoutUserID はユーザーIDを取得できなかった場合に、アカウント名でキーを分け、
+// アカウント名もなければRedisを使わずに投稿をスキップすることを確かめる
+func TestRedisDedupeKeyWithoutUserID(t *testing.T) {
+	client, err := newRedisClient("redis://127.0.0.1:1/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	d := &redisDedupe{client: client, prefix: "yamap-auto-domo"}
+	ctx := context.Background()
+	const url = "https://yamap.com/activities/100"
+
+	t.Setenv("YAMAP_ACCOUNT", "")
+	if key, ok := d.key(withSession(ctx, &session{UserID: 42}), url); !ok || key != "yamap-auto-domo:42:100" {
+		t.Errorf("key with user ID = %q, %v", key, ok)
+	}
+	if _, ok := d.key(ctx, url); ok {
+		t.Error("key without user ID or account succeeded")
+	}
+	if reason := d.claim(ctx, url); reason != tr("アカウントを区別できないため重複を確認できない") {
+		t.Errorf("claim without user ID or account = %q, want skipped", reason)
+	}
+
+	t.Setenv("YAMAP_ACCOUNT", "sub")
+	if key, ok := d.key(ctx, url); !ok || key != "yamap-auto-domo:account:sub:100" {
+		t.Errorf("key with account = %q, %v", key, ok)
+	}
+}
+
+func TestRedisReactionQueue(t *testing.T) {
+	d := newTestRedisDedupe(t)
+	t.Setenv("REACTION_QUEUE", os.Getenv("REDIS_TEST_URL"))
+	t.Setenv("YAMAP_ACCOUNT", "")
+	q, _, err := reactionQueueFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq := q.(*redisReactionQueue)
+	defer rq.client.Close()
+	if rq.key != d.prefix+":queue" {
+		t.Fatalf("queue key = %q, want %s:queue", rq.key, d.prefix)
+	}
+
+	base := time.Now().Add(-time.Hour)
+	items := []queuedActivity{
+		{URL: "https://yamap.com/activities/3", QueuedAt: base.Add(3 * time.Minute)},
+		{URL: "https://yamap.com/activities/1", QueuedAt: base.Add(1 * time.Minute)},
+		{URL: "https://yamap.com/activities/2", QueuedAt: base.Add(2 * time.Minute), Title: "二つ目"},
+	}
+	if n, err := q.push(items); err != nil || n != 3 {
+		t.Fatalf("push = %d, %v; want 3", n, err)
+	}
+	if n, err := q.push(items[:1]); err != nil || n != 0 {
+		t.Errorf("push of a queued activity = %d, %v; want 0", n, err)
+	}
+
+	peeked, err := q.peek(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, item := range peeked {
+		urls = append(urls, item.URL)
+	}
+	if want := []string{"https://yamap.com/activities/1", "https://yamap.com/activities/2"}; !slices.Equal(urls, want) {
+		t.Errorf("peek(2) = %q, want %q in queued order", urls, want)
+	}
+	if peeked[1].Title != "二つ目" {
+		t.Errorf("peeked title = %q, want the stored item", peeked[1].Title)
+	}
+
+	if err := q.remove([]string{"https://yamap.com/activities/1"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := q.expire(base.Add(150 * time.Second)); err != nil || n != 1 {
+		t.Errorf("expire = %d, %v; want 1 (activities/2)", n, err)
+	}
+	rest, err := q.peek(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0].URL != "https://yamap.com/activities/3" {
+		t.Errorf("remaining = %+v, want only activities/3", rest)
+	}
+}
@@ -0,0 +1,236 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// reportPath は -report で指定された、実行の結果を人が読む形式でまとめたレポートのパス。拡張子が .html の場合はHTML、それ以外はMarkdown
+var reportPath string
+
+// reportMaxScreenshots はレポートに載せる失敗時のスクリーンショットの上限
+const reportMaxScreenshots = 20
+
+// postOutcome はレポートに載せる、リアクションなどができなかった投稿1件の結果
+type postOutcome struct {
+	URL     string
+	Skipped bool
+	Reason  string
+	// Screenshot は失敗したときの表示領域のスクリーンショット (PNG)。上限を超えた場合やスキップの場合は nil
+	Screenshot []byte
+}
+
+// addOutcome はレポートに載せる投稿の結果を記録する
+func (s *runStatus) addOutcome(o postOutcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outcomes = append(s.outcomes, o)
+}
+
+// postOutcomes はレポートに載せる投稿の結果を返す
+func (s *runStatus) postOutcomes() []postOutcome {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]postOutcome(nil), s.outcomes...)
+}
+
+// recordPostOutcome は -report が指定されている場合に、スキップまたは失敗した投稿をレポート用に記録する。
+// 失敗の場合は reportMaxScreenshots 件まで、その時点の表示領域のスクリーンショットを撮る
+func recordPostOutcome(ctx context.Context, url string, err error) {
+	if reportPath == "" || err == nil {
+		return
+	}
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		status.addOutcome(postOutcome{URL: url, Skipped: true, Reason: skipErr.reason})
+		return
+	}
+	o := postOutcome{URL: url, Reason: err.Error()}
+	shots := 0
+	for _, prev := range status.postOutcomes() {
+		if prev.Screenshot != nil {
+			shots++
+		}
+	}
+	if shots < reportMaxScreenshots {
+		shotCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+		var buf []byte
+		if err := runActions(shotCtx, driverFromContext(ctx).ViewportScreenshot(&buf)); err == nil {
+			o.Screenshot = buf
+		}
+	}
+	status.addOutcome(o)
+}
+
+// runReportData はレポートのテンプレートに渡す値
+type runReportData struct {
+	Run       runRecord
+	Duration  string
+	Error     string
+	Reactions []historyEntry
+	Skipped   []postOutcome
+	Failed    []postOutcome
+	// Images はMarkdownのレポートで、Failed の順にスクリーンショットを保存したファイル名 (保存しなかった場合は空)
+	Images []string
+}
+
+// writeRunReport は実行の結果・リアクションした投稿・スキップの理由・失敗時のスクリーンショットをまとめたレポートを書き出す。
+// HTMLではスクリーンショットを埋め込んだ1つのファイルに、Markdownではレポートの隣に <名前>_failure_<番号>.png として保存する
+func writeRunReport(path string, run runRecord, reactions []historyEntry, outcomes []postOutcome, runErr error) error {
+	data := runReportData{Run: run, Duration: run.FinishedAt.Sub(run.StartedAt).Round(time.Second).String(), Reactions: reactions}
+	if runErr != nil {
+		data.Error = runErr.Error()
+	}
+	for _, o := range outcomes {
+		if o.Skipped {
+			data.Skipped = append(data.Skipped, o)
+		} else {
+			data.Failed = append(data.Failed, o)
+		}
+	}
+	var buf bytes.Buffer
+	if strings.EqualFold(filepath.Ext(path), ".html") {
+		if err := runReportHTML.Execute(&buf, data); err != nil {
+			return err
+		}
+		return writeArtifact(path, buf.Bytes())
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	for i, o := range data.Failed {
+		name := ""
+		if o.Screenshot != nil {
+			name = fmt.Sprintf("%s_failure_%d.png", base, i+1)
+			if err := writeArtifact(name, o.Screenshot); err != nil {
+				return err
+			}
+			name = filepath.Base(name)
+		}
+		data.Images = append(data.Images, name)
+	}
+	if err := runReportMarkdown.Execute(&buf, data); err != nil {
+		return err
+	}
+	return writeArtifact(path, buf.Bytes())
+}
+
+// reportFuncs はレポートのテンプレートで使う関数。見出しや項目名は tr で -lang の言語にする
+var reportFuncs = map[string]interface{}{
+	"tr":       tr,
+	"lang":     func() string { return logLang },
+	"datetime": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+	// cell はMarkdownの表のセルを壊さないよう、改行と | を置き換える
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(s)
+	},
+	"dataURI": func(png []byte) htmltemplate.URL {
+		return htmltemplate.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	},
+}
+
+// runReportMarkdown はMarkdownのレポート
+var runReportMarkdown = template.Must(template.New("report.md").Funcs(reportFuncs).Parse(`# {{printf (tr "yamap-auto-domo 実行レポート (%s)") .Run.Action}}
+
+| {{tr "項目"}} | {{tr "値"}} |
+| :--- | :--- |
+| {{tr "実行ID"}} | {{.Run.RunID}} |
+| {{tr "開始"}} | {{datetime .Run.StartedAt}} |
+| {{tr "終了"}} | {{datetime .Run.FinishedAt}} ({{.Duration}}) |
+| {{tr "処理"}} | {{printf (tr "%d件 (成功 %d / 失敗 %d / スキップ %d)") .Run.Processed .Run.Succeeded .Run.Failed .Run.Skipped}} |
+{{- if .Run.StoppedBy}}
+| {{tr "停止の条件"}} | {{cell .Run.StoppedBy}} |
+{{- end}}
+{{- if .Run.Aborted}}
+| {{tr "中止の理由"}} | {{cell .Run.Aborted}} |
+{{- end}}
+{{- if .Error}}
+| {{tr "エラー"}} | {{cell .Error}} |
+{{- end}}
+{{- with .Run.Build}}
+| {{tr "バージョン"}} | {{.Version}}{{with .Revision}} {{.}}{{end}}{{with .Browser}} ({{.}}){{end}} |
+{{- end}}
+
+## {{printf (tr "リアクションした投稿 (%d件)") (len .Reactions)}}
+{{if .Reactions}}
+| {{tr "日時"}} | {{tr "タイトル"}} | {{tr "投稿者"}} | {{tr "絵文字"}} |
+| :--- | :--- | :--- | :--- |
+{{range .Reactions}}| {{datetime .ReactedAt}} | [{{cell (or .Title .URL)}}]({{.URL}}) | {{cell .AuthorName}} | {{.Emoji}} |
+{{end}}{{else}}
+{{tr "なし"}}
+{{end}}
+## {{printf (tr "スキップした投稿 (%d件)") (len .Skipped)}}
+{{if .Skipped}}
+| {{tr "投稿"}} | {{tr "理由"}} |
+| :--- | :--- |
+{{range .Skipped}}| {{.URL}} | {{cell .Reason}} |
+{{end}}{{else}}
+{{tr "なし"}}
+{{end}}
+## {{printf (tr "失敗した投稿 (%d件)") (len .Failed)}}
+{{if .Failed}}{{range $i, $o := .Failed}}
+### {{$o.URL}}
+
+{{$o.Reason}}
+{{with index $.Images $i}}
+![{{$o.URL}}]({{.}})
+{{end}}{{end}}{{else}}
+{{tr "なし"}}
+{{end}}`))
+
+// runReportHTML はスクリーンショットを埋め込んだHTMLのレポート
+var runReportHTML = htmltemplate.Must(htmltemplate.New("report.html").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{printf (tr "yamap-auto-domo 実行レポート (%s)") .Run.Action}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 10px; text-align: left; }
+.error { color: #c62828; }
+img.shot { max-width: 640px; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>{{printf (tr "yamap-auto-domo 実行レポート (%s)") .Run.Action}}</h1>
+<table>
+<tr><th>{{tr "実行ID"}}</th><td>{{.Run.RunID}}</td></tr>
+<tr><th>{{tr "開始"}}</th><td>{{datetime .Run.StartedAt}}</td></tr>
+<tr><th>{{tr "終了"}}</th><td>{{datetime .Run.FinishedAt}} ({{.Duration}})</td></tr>
+<tr><th>{{tr "処理"}}</th><td>{{printf (tr "%d件 (成功 %d / 失敗 %d / スキップ %d)") .Run.Processed .Run.Succeeded .Run.Failed .Run.Skipped}}</td></tr>
+{{if .Run.StoppedBy}}<tr><th>{{tr "停止の条件"}}</th><td>{{.Run.StoppedBy}}</td></tr>
+{{end}}{{if .Run.Aborted}}<tr><th>{{tr "中止の理由"}}</th><td class="error">{{.Run.Aborted}}</td></tr>
+{{end}}{{if .Error}}<tr><th>{{tr "エラー"}}</th><td class="error">{{.Error}}</td></tr>
+{{end}}{{with .Run.Build}}<tr><th>{{tr "バージョン"}}</th><td>{{.Version}}{{with .Revision}} {{.}}{{end}}{{with .Browser}} ({{.}}){{end}}</td></tr>
+{{end}}</table>
+
+<h2>{{printf (tr "リアクションした投稿 (%d件)") (len .Reactions)}}</h2>
+{{if .Reactions}}<table>
+<tr><th>{{tr "日時"}}</th><th>{{tr "タイトル"}}</th><th>{{tr "投稿者"}}</th><th>{{tr "絵文字"}}</th></tr>
+{{range .Reactions}}<tr><td>{{datetime .ReactedAt}}</td><td><a href="{{.URL}}">{{or .Title .URL}}</a></td><td>{{.AuthorName}}</td><td>{{.Emoji}}</td></tr>
+{{end}}</table>
+{{else}}<p>{{tr "なし"}}</p>
+{{end}}
+<h2>{{printf (tr "スキップした投稿 (%d件)") (len .Skipped)}}</h2>
+{{if .Skipped}}<table>
+<tr><th>{{tr "投稿"}}</th><th>{{tr "理由"}}</th></tr>
+{{range .Skipped}}<tr><td><a href="{{.URL}}">{{.URL}}</a></td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{else}}<p>{{tr "なし"}}</p>
+{{end}}
+<h2>{{printf (tr "失敗した投稿 (%d件)") (len .Failed)}}</h2>
+{{range .Failed}}<h3><a href="{{.URL}}">{{.URL}}</a></h3>
+<p class="error">{{.Reason}}</p>
+{{if .Screenshot}}<img class="shot" src="{{dataURI .Screenshot}}" alt="{{.URL}}">
+{{end}}{{else}}<p>{{tr "なし"}}</p>
+{{end}}</body>
+</html>
+`))
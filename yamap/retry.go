@@ -0,0 +1,138 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// retryEntry はリアクションに失敗し、次回以降の実行で再試行する投稿。Attempts は失敗した実行の回数
+type retryEntry struct {
+	URL        string    `json:"url"`
+	AuthorID   int64     `json:"author_id,omitempty"`
+	AuthorName string    `json:"author_name,omitempty"`
+	Title      string    `json:"title,omitempty"`
+	Emoji      string    `json:"emoji,omitempty"`
+	Reason     string    `json:"reason"`
+	Attempts   int       `json:"attempts"`
+	FailedAt   time.Time `json:"failed_at"`
+}
+
+// retryQueueMaxAttempts は RETRY_QUEUE_MAX_ATTEMPTS から、再試行をあきらめるまでに失敗してよい実行の回数を返す (既定値 3)。
+// 0 の場合は再試行キューを使わない
+func retryQueueMaxAttempts(ctx context.Context) int {
+	v := os.Getenv("RETRY_QUEUE_MAX_ATTEMPTS")
+	if v == "" {
+		return 3
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		loggerFromContext(ctx).Print(tr("警告: RETRY_QUEUE_MAX_ATTEMPTSの値が不正です。既定値 3 を使用します"))
+		return 3
+	}
+	return n
+}
+
+// withRetries は再試行キューの投稿を収集した投稿より先に処理するよう、キューの先頭に加える。
+// プランのとおりに実行する apply では加えない
+func withRetries(ctx context.Context, activities []ActivityInfo) []ActivityInfo {
+	if history == nil || retryQueueMaxAttempts(ctx) == 0 || status.report().Action == "apply" {
+		return activities
+	}
+	retries := history.retryActivities()
+	if len(retries) == 0 {
+		return activities
+	}
+	loggerFromContext(ctx).Printf(tr("前回までに失敗した投稿 %d 件を先に再試行します。"), len(retries))
+	queued := make(map[string]struct{}, len(retries))
+	for _, a := range retries {
+		queued[a.URL] = struct{}{}
+	}
+	for _, a := range activities {
+		if _, ok := queued[a.URL]; !ok {
+			retries = append(retries, a)
+		}
+	}
+	return retries
+}
+
+// updateRetryQueue はリアクションの結果に応じて再試行キューを更新する。失敗した投稿はキューに加え、
+// 成功・スキップした投稿はキューから除く。中断による失敗は投稿の問題ではないため数えない
+func updateRetryQueue(ctx context.Context, activity ActivityInfo, err error) {
+	maxAttempts := retryQueueMaxAttempts(ctx)
+	if history == nil || maxAttempts == 0 || ctx.Err() != nil {
+		return
+	}
+	var skipErr *skipError
+	if err == nil || errors.As(err, &skipErr) {
+		if err := history.clearRetry(activity.URL); err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: 再試行キューの保存に失敗しました: %v"), err)
+		}
+		return
+	}
+	attempts, saveErr := history.recordRetry(activity, err.Error(), maxAttempts, time.Now())
+	if saveErr != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 再試行キューの保存に失敗しました: %v"), saveErr)
+	}
+	if attempts >= maxAttempts {
+		loggerFromContext(ctx).Printf(tr("%d回の実行で失敗したため、この投稿の再試行をあきらめます: %s"), attempts, activity.URL)
+	} else {
+		loggerFromContext(ctx).Printf(tr("次回以降の実行で再試行します (失敗 %d/%d 回): %s"), attempts, maxAttempts, activity.URL)
+	}
+}
+
+// retryActivities は再試行キューの投稿を返す。リアクション済みとして履歴にある投稿はキューから除く
+func (h *historyStore) retryActivities() []ActivityInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	reacted := make(map[string]struct{}, len(h.Entries))
+	for _, e := range h.Entries {
+		reacted[e.URL] = struct{}{}
+	}
+	var activities []ActivityInfo
+	kept := h.RetryQueue[:0]
+	for _, e := range h.RetryQueue {
+		if _, ok := reacted[e.URL]; ok {
+			continue
+		}
+		kept = append(kept, e)
+		activities = append(activities, ActivityInfo{URL: e.URL, AuthorID: e.AuthorID, AuthorName: e.AuthorName, Title: e.Title, Emoji: e.Emoji})
+	}
+	h.RetryQueue = kept
+	return activities
+}
+
+// recordRetry は投稿のリアクションの失敗を再試行キューに記録し、これまでに失敗した実行の回数を返す。
+// 回数が maxAttempts に達した場合はキューから除く
+func (h *historyStore) recordRetry(activity ActivityInfo, reason string, maxAttempts int, at time.Time) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := slices.IndexFunc(h.RetryQueue, func(e retryEntry) bool { return e.URL == activity.URL })
+	if i < 0 {
+		h.RetryQueue = append(h.RetryQueue, retryEntry{URL: activity.URL, AuthorID: activity.AuthorID, AuthorName: activity.AuthorName, Title: activity.Title, Emoji: activity.Emoji})
+		i = len(h.RetryQueue) - 1
+	}
+	h.RetryQueue[i].Reason = reason
+	h.RetryQueue[i].Attempts++
+	h.RetryQueue[i].FailedAt = at
+	attempts := h.RetryQueue[i].Attempts
+	if attempts >= maxAttempts {
+		h.RetryQueue = slices.Delete(h.RetryQueue, i, i+1)
+	}
+	return attempts, h.save()
+}
+
+// clearRetry は投稿を再試行キューから除く。キューにない場合は何もしない
+func (h *historyStore) clearRetry(url string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := slices.IndexFunc(h.RetryQueue, func(e retryEntry) bool { return e.URL == url })
+	if i < 0 {
+		return nil
+	}
+	h.RetryQueue = slices.Delete(h.RetryQueue, i, i+1)
+	return h.save()
+}
@@ -0,0 +1,142 @@
+package yamap
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// screenRec は -record で指定されたディレクトリへの画面の録画。-record が未指定の場合は nil
+var screenRec *screenRecorder
+
+// screenFrame は録画したフレームのファイル名と表示された時刻
+type screenFrame struct {
+	name string
+	at   time.Time
+}
+
+// screenRecorder はCDPのスクリーンキャストで受け取ったフレームを連番のPNGとして保存する。
+// 終了時にフレームの表示時間を記したffmpegの連結リストを書き出し、ffmpegがあれば動画にまとめる
+type screenRecorder struct {
+	mu     sync.Mutex
+	dir    string
+	frames []screenFrame
+	done   bool
+}
+
+// newScreenRecorder は録画の保存先のディレクトリを作成する。複数アカウントで実行した場合はアカウントごとのディレクトリに分ける
+func newScreenRecorder(dir string) (*screenRecorder, error) {
+	if name := os.Getenv("YAMAP_ACCOUNT"); name != "" {
+		dir = filepath.Join(dir, name)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf(tr("録画のディレクトリを作成できません: %w"), err)
+	}
+	return &screenRecorder{dir: dir}, nil
+}
+
+// start はタブのスクリーンキャストを開始する。フレームは画面に変化があったときだけ送られる
+func (r *screenRecorder) start(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	if err := chromedp.Run(ctx, page.StartScreencast().WithFormat(page.ScreencastFormatPng).WithMaxWidth(1280)); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 録画を開始できません: %v"), err)
+	}
+}
+
+// handle はスクリーンキャストのフレームを保存し、次のフレームを受け取るために受信を通知する
+func (r *screenRecorder) handle(ctx context.Context, ev interface{}) {
+	frame, ok := ev.(*page.EventScreencastFrame)
+	if r == nil || !ok {
+		return
+	}
+	// イベントのリスナーの中ではCDPのコマンドの応答を待てないため、別のゴルーチンで処理する
+	go func() {
+		if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+			ackCtx, cancel := context.WithTimeout(cdp.WithExecutor(ctx, c.Target), 5*time.Second)
+			defer cancel()
+			page.ScreencastFrameAck(frame.SessionID).Do(ackCtx)
+		}
+		at := time.Now()
+		if frame.Metadata != nil && frame.Metadata.Timestamp != nil {
+			at = frame.Metadata.Timestamp.Time()
+		}
+		data, err := base64.StdEncoding.DecodeString(frame.Data)
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.done {
+			return
+		}
+		name := fmt.Sprintf("frame_%06d.png", len(r.frames)+1)
+		if err := os.WriteFile(filepath.Join(r.dir, name), data, 0o644); err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: 録画のフレームを保存できません: %v"), err)
+			return
+		}
+		r.frames = append(r.frames, screenFrame{name: name, at: at})
+	}()
+}
+
+// finish は録画を終え、フレームの連結リスト (frames.txt) を書き出す。ffmpegがあれば recording.mp4 にまとめる
+func (r *screenRecorder) finish() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.done {
+		return
+	}
+	r.done = true
+	if len(r.frames) == 0 {
+		log.Print(tr("録画するフレームがありませんでした。"))
+		return
+	}
+	// フレームは受信順に保存されるが、時刻はブラウザ側のものを使うため並べ替えてから表示時間を求める
+	sort.SliceStable(r.frames, func(i, j int) bool { return r.frames[i].at.Before(r.frames[j].at) })
+	var list strings.Builder
+	for i, f := range r.frames {
+		// 最後のフレームは1秒間表示する
+		duration := time.Second
+		if i+1 < len(r.frames) {
+			duration = r.frames[i+1].at.Sub(f.at)
+		}
+		fmt.Fprintf(&list, "file '%s'\nduration %.3f\n", f.name, duration.Seconds())
+	}
+	// ffmpegの連結リストは最後のフレームの表示時間を反映させるため、最後のファイルをもう一度記載する
+	fmt.Fprintf(&list, "file '%s'\n", r.frames[len(r.frames)-1].name)
+	listPath := filepath.Join(r.dir, "frames.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		log.Printf(tr("警告: 録画のフレームの一覧を保存できません: %v"), err)
+		return
+	}
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		log.Printf(tr("%d枚のフレームを %s に録画しました。ffmpegが見つからないため、動画にはまとめません。"), len(r.frames), r.dir)
+		return
+	}
+	video := filepath.Join(r.dir, "recording.mp4")
+	// 画面の大きさは奇数になることがあり、H.264は偶数の幅と高さしか扱えないため切り詰める
+	cmd := exec.Command(ffmpeg, "-y", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", listPath,
+		"-vf", "crop=trunc(iw/2)*2:trunc(ih/2)*2,format=yuv420p", "-fps_mode", "vfr", video)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf(tr("警告: 録画を動画にまとめられません: %v: %s"), err, strings.TrimSpace(string(out)))
+		return
+	}
+	log.Printf(tr("%d枚のフレームを録画し、%s にまとめました。"), len(r.frames), video)
+}
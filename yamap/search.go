@@ -0,0 +1,287 @@
+package yamap
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchResult は search-export で書き出す活動日記の情報
+type searchResult struct {
+	URL      string `json:"url"`
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	AuthorID int64  `json:"author_id"`
+	// PostedAt は投稿日時 (RFC 3339)。取得できない場合は空
+	PostedAt   string   `json:"posted_at"`
+	DistanceKm float64  `json:"distance_km"`
+	ElevationM float64  `json:"elevation_m"`
+	Reactions  int      `json:"reactions"`
+	Mountains  []string `json:"mountains"`
+}
+
+// activityReactionCountScript は活動日記詳細ページの NUXT データから絵文字のリアクションの合計数を取り出すスクリプト。
+// 候補の探し方は activityMetadataScript と同じ
+const activityReactionCountScript = `(() => {
+	const nuxt = window.__NUXT__ || {};
+	const candidates = [];
+	if (nuxt.state && nuxt.state.activity) candidates.push(nuxt.state.activity.activity, nuxt.state.activity);
+	for (const d of (nuxt.data || [])) if (d) candidates.push(d.activity, d);
+	const a = candidates.find(c => c && typeof c === "object" && ("distance" in c || "cumulative_up" in c || "title" in c)) || {};
+	if (Array.isArray(a.emoji_reactions)) return a.emoji_reactions.reduce((n, r) => n + (Number(r && r.count) || 1), 0);
+	return Number(a.emoji_reactions_count || a.reactions_count) || 0;
+})()`
+
+// runSearchExport は react-activities と同じ検索の条件 (ACTIVITIES_SEARCH_PARAMS と設定ファイルの activity_search) で
+// 活動日記を検索し、リアクションせずに各活動日記の情報を SEARCH_EXPORT_FILE (既定値 search-results.csv) に書き出す。
+// 件数は SEARCH_EXPORT_COUNT (既定値 50)。拡張子が .csv の場合はCSV、それ以外はJSONで書き出す
+func runSearchExport() error {
+	log.Println(tr("--- プログラム開始 (search-export) ---"))
+	startTime := time.Now()
+	count := 50
+	if v := os.Getenv("SEARCH_EXPORT_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("SEARCH_EXPORT_COUNTの値が不正です: %s"), v)
+		}
+		count = n
+	}
+	path := os.Getenv("SEARCH_EXPORT_FILE")
+	if path == "" {
+		path = "search-results.csv"
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("collecting")
+	urls, err := searchResultURLs(ctx, count)
+	if err != nil {
+		return err
+	}
+	loggerFromContext(ctx).Printf(tr("検索結果から%d件の活動日記を取得します。"), len(urls))
+
+	drv := driverFromContext(ctx)
+	results := make([]searchResult, 0, len(urls))
+	for i, url := range urls {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		var meta activityMetadata
+		var reactions int
+		err := runActions(ctx,
+			drv.Navigate(url),
+			drv.WaitVisible(layout.footer),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(activityMetadataScript, &meta),
+			drv.Evaluate(activityReactionCountScript, &reactions),
+		)
+		status.recordResult(err == nil, err)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("活動日記の情報の取得に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		id, _ := strconv.ParseInt(activityIDFromRef(url), 10, 64)
+		results = append(results, searchResult{
+			URL: url, ID: id, Title: meta.Title, Author: meta.Author, AuthorID: meta.AuthorID, PostedAt: meta.PostedAt,
+			DistanceKm: meta.Distance / 1000, ElevationM: meta.CumulativeUp, Reactions: reactions, Mountains: meta.MountainNames,
+		})
+		loggerFromContext(ctx).Printf("[%d/%d] %s (%s)", i+1, len(urls), meta.Title, url)
+		status.markStep()
+		pace.wait(ctx)
+	}
+
+	if err := writeSearchResults(path, results); err != nil {
+		return fmt.Errorf(tr("検索結果の書き出しに失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("活動日記 %d 件を %s に書き出しました。"), len(results), path)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// searchResultURLs は活動日記の検索結果のページを順に開き、最大 count 件の活動日記のURLを返す。
+// react-activities と異なり、投稿者ごとの上限や除外は適用しない
+func searchResultURLs(ctx context.Context, count int) ([]string, error) {
+	drv := driverFromContext(ctx)
+	var urls []string
+	seen := make(map[string]struct{})
+	for page := 1; len(urls) < count; page++ {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		var entries []struct {
+			Href string `json:"href"`
+		}
+		pageURL := activitySearchURL(page)
+		if err := runActions(ctx,
+			drv.Navigate(pageURL),
+			drv.WaitVisible(`footer[data-global-footer="true"]`),
+			drv.Evaluate(activityEntriesScript, &entries),
+		); err != nil {
+			if len(urls) > 0 {
+				loggerFromContext(ctx).Printf(tr("%dページ目の取得に失敗したため、取得できた分を書き出します: %v"), page, err)
+				break
+			}
+			return nil, fmt.Errorf(tr("活動日記の検索結果の取得に失敗: %w"), err)
+		}
+		before := len(urls)
+		for _, e := range entries {
+			url := "https://yamap.com" + e.Href
+			if _, ok := seen[url]; ok || len(urls) >= count {
+				continue
+			}
+			seen[url] = struct{}{}
+			urls = append(urls, url)
+		}
+		if len(urls) == before {
+			// 活動日記のないページか、前のページと同じ結果のページは検索結果の終わりとみなす
+			break
+		}
+		status.markStep()
+		pace.wait(ctx)
+	}
+	return urls, nil
+}
+
+// writeSearchResults は search-export の結果を、拡張子が .csv の場合はCSV、それ以外はJSONで path に書き出す
+func writeSearchResults(path string, results []searchResult) error {
+	if !strings.EqualFold(filepath.Ext(path), ".csv") {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0644)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"url", "id", "title", "author", "author_id", "posted_at", "distance_km", "elevation_m", "reactions", "mountains"})
+	for _, r := range results {
+		w.Write([]string{r.URL, strconv.FormatInt(r.ID, 10), r.Title, r.Author, strconv.FormatInt(r.AuthorID, 10), r.PostedAt,
+			strconv.FormatFloat(r.DistanceKm, 'f', 2, 64), strconv.FormatFloat(r.ElevationM, 'f', 0, 64), strconv.Itoa(r.Reactions), strings.Join(r.Mountains, " / ")})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// activitySearchURL は活動日記の検索結果の page ページ目のURLを返す。
+// ACTIVITIES_SEARCH_PARAMS (例: "keyword=丹沢&prefecture_id=14") を検索条件のクエリとしてそのまま付け加え、
+// 設定ファイルの activity_search の条件で上書きする
+func activitySearchURL(page int) string {
+	query, err := neturl.ParseQuery(os.Getenv("ACTIVITIES_SEARCH_PARAMS"))
+	if err != nil {
+		log.Printf(tr("警告: ACTIVITIES_SEARCH_PARAMSの値が不正です。検索条件は指定しません: %v"), err)
+		query = neturl.Values{}
+	}
+	config.ActivitySearch.apply(query, time.Now())
+	query.Set("page", strconv.Itoa(page))
+	return "https://yamap.com/search/activities?" + query.Encode()
+}
+
+// activitySearch は活動日記の検索の条件。設定ファイルの activity_search に書き、検索ページ (/search/activities) のクエリに変換する
+type activitySearch struct {
+	Keyword string `json:"keyword"`
+	// PrefectureID は地域の都道府県コード (1: 北海道 〜 47: 沖縄県)
+	PrefectureID int `json:"prefecture_id"`
+	// MountainID は山のID
+	MountainID int64 `json:"mountain_id"`
+	// Since と Until は活動日の範囲。日付 (2006-01-02) か、今日からさかのぼる日数 (7d) で指定する
+	Since string `json:"since"`
+	Until string `json:"until"`
+	// Sort は並び順 (searchSorts のキー)
+	Sort string `json:"sort"`
+	// ActivityType は活動の種類 (searchActivityTypes のキー)
+	ActivityType string `json:"activity_type"`
+}
+
+// searchSorts は activity_search.sort に指定できる並び順と、検索ページのクエリの order の値
+var searchSorts = map[string]string{"newest": "latest", "popular": "popular"}
+
+// searchSortNames は指定できる並び順の一覧 (エラーメッセージ用)
+const searchSortNames = "newest, popular"
+
+// searchActivityTypes は activity_search.activity_type に指定できる活動の種類と、検索ページのクエリの activity_type_id の値
+var searchActivityTypes = map[string]string{
+	"hiking": "1", "trail-running": "2", "climbing": "3", "backcountry-ski": "4", "walking": "5", "cycling": "6",
+}
+
+// searchActivityTypeNames は指定できる活動の種類の一覧 (エラーメッセージ用)
+const searchActivityTypeNames = "hiking, trail-running, climbing, backcountry-ski, walking, cycling"
+
+// searchDate は since・until の値を検索ページの日付 (2006-01-02) に変換する
+func searchDate(v string, now time.Time) (string, error) {
+	t, err := parseSince(v, now)
+	if err != nil {
+		return "", fmt.Errorf(tr("日付 (2006-01-02) か日数 (7d) を指定してください: %s"), v)
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+// validate は検索の条件を検証し、問題を add に渡す
+func (s activitySearch) validate(add func(key string, err error)) {
+	if s.PrefectureID != 0 && (s.PrefectureID < 1 || s.PrefectureID > 47) {
+		add("activity_search.prefecture_id", fmt.Errorf(tr("activity_search.prefecture_id には1から47までの都道府県コードを指定してください: %d"), s.PrefectureID))
+	}
+	if s.MountainID < 0 {
+		add("activity_search.mountain_id", fmt.Errorf(tr("activity_search.mountain_id の山のIDが不正です: %d"), s.MountainID))
+	}
+	for _, f := range []struct{ key, value string }{{"activity_search.since", s.Since}, {"activity_search.until", s.Until}} {
+		if f.value == "" {
+			continue
+		}
+		if _, err := searchDate(f.value, time.Now()); err != nil {
+			add(f.key, fmt.Errorf("%s: %w", f.key, err))
+		}
+	}
+	if _, ok := searchSorts[s.Sort]; s.Sort != "" && !ok {
+		add("activity_search.sort", fmt.Errorf(tr("activity_search.sort には %s のいずれかを指定してください: %s"), searchSortNames, s.Sort))
+	}
+	if _, ok := searchActivityTypes[s.ActivityType]; s.ActivityType != "" && !ok {
+		add("activity_search.activity_type", fmt.Errorf(tr("activity_search.activity_type には %s のいずれかを指定してください: %s"), searchActivityTypeNames, s.ActivityType))
+	}
+}
+
+// apply は検索の条件を query に設定する。指定されていない条件は query の値をそのまま残す
+func (s activitySearch) apply(query neturl.Values, now time.Time) {
+	if s.Keyword != "" {
+		query.Set("keyword", s.Keyword)
+	}
+	if s.PrefectureID != 0 {
+		query.Set("prefecture_id", strconv.Itoa(s.PrefectureID))
+	}
+	if s.MountainID != 0 {
+		query.Set("mountain_id", strconv.FormatInt(s.MountainID, 10))
+	}
+	if d, err := searchDate(s.Since, now); s.Since != "" && err == nil {
+		query.Set("start_date", d)
+	}
+	if d, err := searchDate(s.Until, now); s.Until != "" && err == nil {
+		query.Set("end_date", d)
+	}
+	if v, ok := searchSorts[s.Sort]; ok {
+		query.Set("order", v)
+	}
+	if v, ok := searchActivityTypes[s.ActivityType]; ok {
+		query.Set("activity_type_id", v)
+	}
+}
@@ -0,0 +1,234 @@
+package yamap
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+// seenFilterFalsePositiveRate は確認済みのIDのブルームフィルタの偽陽性率。未確認の投稿を確認済みと誤る割合
+const seenFilterFalsePositiveRate = 0.001
+
+// bloomFilter はIDの集合を一定のメモリで表すブルームフィルタ。追加していないIDを含むと判定することがある (偽陽性) が、
+// 追加したIDを含まないと判定することはない
+type bloomFilter struct {
+	k         uint32
+	bits      []uint64
+	count     uint64
+	createdAt time.Time
+}
+
+// newBloomFilter は capacity 件のIDを偽陽性率 seenFilterFalsePositiveRate で表せる大きさのブルームフィルタを作成する
+func newBloomFilter(capacity int) *bloomFilter {
+	m := math.Ceil(-float64(capacity) * math.Log(seenFilterFalsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := max(1, uint32(math.Round(m/float64(capacity)*math.Ln2)))
+	return &bloomFilter{k: k, bits: make([]uint64, (uint64(m)+63)/64), createdAt: time.Now()}
+}
+
+// mix64 はIDを一様に分布するハッシュ値にする (splitmix64 の最終段)
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// each はIDに対応する k 個のビットの位置を順に fn に渡す。fn が false を返した時点で止める
+func (f *bloomFilter) each(id int64, fn func(word int, mask uint64) bool) {
+	m := uint64(len(f.bits)) * 64
+	h1 := mix64(uint64(id))
+	h2 := mix64(h1^0x9e3779b97f4a7c15) | 1
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % m
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+func (f *bloomFilter) add(id int64) {
+	f.each(id, func(word int, mask uint64) bool {
+		f.bits[word] |= mask
+		return true
+	})
+	f.count++
+}
+
+func (f *bloomFilter) has(id int64) bool {
+	found := true
+	f.each(id, func(word int, mask uint64) bool {
+		found = f.bits[word]&mask != 0
+		return found
+	})
+	return found
+}
+
+// bloomFilterMagic はブルームフィルタのファイルの先頭に置く識別子と形式のバージョン
+const bloomFilterMagic = "YSBF1"
+
+// bloomFilterHeader はブルームフィルタのファイルの識別子に続く、ビット列の前の部分
+type bloomFilterHeader struct {
+	K         uint32
+	Words     uint64
+	Count     uint64
+	CreatedAt int64
+}
+
+// MarshalBinary はブルームフィルタをファイルに保存する形式 (識別子・k・ビット数・追加した件数・作成日時・ビット列、リトルエンディアン) にする
+func (f *bloomFilter) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBufferString(bloomFilterMagic)
+	binary.Write(buf, binary.LittleEndian, bloomFilterHeader{K: f.k, Words: uint64(len(f.bits)), Count: f.count, CreatedAt: f.createdAt.Unix()})
+	binary.Write(buf, binary.LittleEndian, f.bits)
+	return buf.Bytes(), nil
+}
+
+func (f *bloomFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	magic := make([]byte, len(bloomFilterMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != bloomFilterMagic {
+		return errors.New(tr("ブルームフィルタのファイルではありません"))
+	}
+	var header bloomFilterHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if header.K == 0 || header.Words == 0 || header.Words*8 != uint64(r.Len()) {
+		return errors.New(tr("ブルームフィルタのファイルが壊れています"))
+	}
+	f.k, f.count, f.createdAt = header.K, header.Count, time.Unix(header.CreatedAt, 0)
+	f.bits = make([]uint64, header.Words)
+	return binary.Read(r, binary.LittleEndian, f.bits)
+}
+
+// seenSet は確認済みの投稿などのIDの集合。直近の maxRecent 件はLRUで正確に保持し、あふれた古いIDはブルームフィルタに移すため、
+// 確認した件数によらずメモリの使用量が一定に収まる。SEEN_FILTER_FILE を設定した場合は、remember で記録したIDを
+// ファイルのブルームフィルタに保存し、実行をまたいで確認済みとして扱う
+type seenSet struct {
+	maxRecent int
+	capacity  int
+	recent    map[int64]*list.Element
+	order     *list.List
+	// spilled は今回の実行でLRUからあふれたID、persisted は前回までの実行と今回の実行で remember したID。使わない場合は nil
+	spilled   *bloomFilter
+	persisted *bloomFilter
+	path      string
+}
+
+// newSeenSet は SEEN_RECENT_MAX (既定値 10000) 件をLRUで保持する、今回の実行だけの seenSet を作成する
+func newSeenSet(ctx context.Context) *seenSet {
+	s := &seenSet{maxRecent: 10000, capacity: 1000000, recent: make(map[int64]*list.Element), order: list.New()}
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{{"SEEN_RECENT_MAX", &s.maxRecent}, {"SEEN_FILTER_CAPACITY", &s.capacity}} {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			loggerFromContext(ctx).Printf(tr("警告: %sの値が不正です。既定値 %d を使用します"), v.name, *v.dst)
+			continue
+		}
+		*v.dst = n
+	}
+	return s
+}
+
+// loadSeenSet は newSeenSet に加えて、SEEN_FILTER_FILE が設定されていれば前回までに remember したIDのブルームフィルタを読み込む。
+// 記録した件数が SEEN_FILTER_CAPACITY に達したフィルタは偽陽性が増えるため、破棄して作り直す
+func loadSeenSet(ctx context.Context) *seenSet {
+	s := newSeenSet(ctx)
+	s.path = os.Getenv("SEEN_FILTER_FILE")
+	if s.path == "" {
+		return s
+	}
+	s.persisted = newBloomFilter(s.capacity)
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			loggerFromContext(ctx).Printf(tr("警告: 確認済みの投稿のフィルタの読み込みに失敗しました: %v"), err)
+		}
+		return s
+	}
+	var saved bloomFilter
+	switch err := saved.UnmarshalBinary(data); {
+	case err != nil:
+		loggerFromContext(ctx).Printf(tr("警告: 確認済みの投稿のフィルタを読み込めないため作り直します: %v"), err)
+	case saved.count >= uint64(s.capacity):
+		loggerFromContext(ctx).Printf(tr("確認済みの投稿のフィルタが %d 件 (%s から) に達したため作り直します。"), saved.count, saved.createdAt.Local().Format("2006-01-02"))
+	case len(saved.bits) != len(s.persisted.bits):
+		loggerFromContext(ctx).Println(tr("SEEN_FILTER_CAPACITY が変わったため、確認済みの投稿のフィルタを作り直します。"))
+	default:
+		s.persisted = &saved
+	}
+	return s
+}
+
+// seen は今回の実行で add したIDかを返す。LRUからあふれたIDはブルームフィルタで判定する
+func (s *seenSet) seen(id int64) bool {
+	if e, ok := s.recent[id]; ok {
+		s.order.MoveToFront(e)
+		return true
+	}
+	return s.spilled != nil && s.spilled.has(id)
+}
+
+// add はIDを今回の実行で確認済みとする。LRUがあふれた場合は最も古いIDをブルームフィルタに移す
+func (s *seenSet) add(id int64) {
+	if s.seen(id) {
+		return
+	}
+	s.recent[id] = s.order.PushFront(id)
+	if s.order.Len() <= s.maxRecent {
+		return
+	}
+	oldest := s.order.Back()
+	s.order.Remove(oldest)
+	delete(s.recent, oldest.Value.(int64))
+	if s.spilled == nil {
+		s.spilled = newBloomFilter(s.capacity)
+	}
+	s.spilled.add(oldest.Value.(int64))
+}
+
+// remember はIDを次回以降の実行でも確認済みとして扱うよう記録する。SEEN_FILTER_FILE が未設定の場合は何もしない
+func (s *seenSet) remember(id int64) {
+	if s.persisted != nil && !s.persisted.has(id) {
+		s.persisted.add(id)
+	}
+}
+
+// remembered は前回までの実行 (または今回の実行) で remember したIDかを返す
+func (s *seenSet) remembered(id int64) bool {
+	return s.persisted != nil && s.persisted.has(id)
+}
+
+// recentIDs はLRUに保持している直近のIDを返す
+func (s *seenSet) recentIDs() []int64 {
+	ids := make([]int64, 0, s.order.Len())
+	for e := s.order.Front(); e != nil; e = e.Next() {
+		ids = append(ids, e.Value.(int64))
+	}
+	return ids
+}
+
+// save は remember したIDのブルームフィルタを SEEN_FILTER_FILE に書き出す
+func (s *seenSet) save(ctx context.Context) {
+	if s.persisted == nil {
+		return
+	}
+	data, _ := s.persisted.MarshalBinary()
+	if err := writeFileAtomic(s.path, data, 0o600); err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: 確認済みの投稿のフィルタの保存に失敗しました: %v"), err)
+	}
+}
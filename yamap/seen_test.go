@@ -0,0 +1,175 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestBloomFilter は追加したIDを必ず含むと判定し、追加していないIDの偽陽性が想定の割合に収まること、
+// ファイルの形式で保存して読み込んでも同じ判定になることを確かめる
+func TestBloomFilter(t *testing.T) {
+	const n = 10000
+	f := newBloomFilter(n)
+	for id := int64(1); id <= n; id++ {
+		f.add(id)
+	}
+	for id := int64(1); id <= n; id++ {
+		if !f.has(id) {
+			t.Fatalf("has(%d) = false after add", id)
+		}
+	}
+	falsePositives := 0
+	for id := int64(n + 1); id <= 11*n; id++ {
+		if f.has(id) {
+			falsePositives++
+		}
+	}
+	// 想定の偽陽性率 0.1% に対して、余裕を見て3倍までを許す
+	if rate := float64(falsePositives) / (10 * n); rate > 3*seenFilterFalsePositiveRate {
+		t.Errorf("false positive rate = %.4f, want about %.4f", rate, seenFilterFalsePositiveRate)
+	}
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded bloomFilter
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.k != f.k || loaded.count != n || !slices.Equal(loaded.bits, f.bits) || loaded.createdAt.Unix() != f.createdAt.Unix() {
+		t.Errorf("loaded k=%d count=%d createdAt=%v, want k=%d count=%d createdAt=%v and the same bits",
+			loaded.k, loaded.count, loaded.createdAt, f.k, n, f.createdAt)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":       nil,
+		"wrong magic": append([]byte("XXXXX"), data[len(bloomFilterMagic):]...),
+		"truncated":   data[:len(data)-1],
+		"header only": data[:len(bloomFilterMagic)+8],
+	} {
+		if err := new(bloomFilter).UnmarshalBinary(data); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded", name)
+		}
+	}
+}
+
+// TestSeenSetEviction は SEEN_RECENT_MAX を超えると最も長く参照されていないIDがLRUから除かれ、
+// 除かれたIDもブルームフィルタで確認済みと判定されることを確かめる
+func TestSeenSetEviction(t *testing.T) {
+	t.Setenv("SEEN_RECENT_MAX", "3")
+	t.Setenv("SEEN_FILTER_CAPACITY", "100")
+	s := newSeenSet(context.Background())
+	for _, id := range []int64{1, 2, 3} {
+		s.add(id)
+	}
+	if got, want := s.recentIDs(), []int64{3, 2, 1}; !slices.Equal(got, want) {
+		t.Fatalf("recentIDs = %v, want %v", got, want)
+	}
+	if s.spilled != nil {
+		t.Error("bloom filter created before the LRU overflowed")
+	}
+
+	// 1 を参照すると最も新しくなり、次に追加したときは 2 が除かれる
+	if !s.seen(1) {
+		t.Fatal("seen(1) = false")
+	}
+	s.add(2) // 追加済みのIDは重複せず、参照として扱う
+	s.add(4)
+	if got, want := s.recentIDs(), []int64{4, 2, 1}; !slices.Equal(got, want) {
+		t.Errorf("recentIDs = %v, want %v (3 evicted)", got, want)
+	}
+	s.add(5)
+	if got, want := s.recentIDs(), []int64{5, 4, 2}; !slices.Equal(got, want) {
+		t.Errorf("recentIDs = %v, want %v (1 evicted)", got, want)
+	}
+	for _, id := range []int64{1, 2, 3, 4, 5} {
+		if !s.seen(id) {
+			t.Errorf("seen(%d) = false, want true", id)
+		}
+	}
+	if s.seen(6) {
+		t.Error("seen(6) = true for an id never added")
+	}
+	if s.remembered(1) {
+		t.Error("remembered(1) = true without SEEN_FILTER_FILE")
+	}
+
+	t.Setenv("SEEN_RECENT_MAX", "0")
+	var logs bytes.Buffer
+	if s := newSeenSet(withLogger(context.Background(), log.New(&logs, "", 0))); s.maxRecent != 10000 {
+		t.Errorf("maxRecent = %d for SEEN_RECENT_MAX=0, want the default 10000", s.maxRecent)
+	}
+	if !strings.Contains(logs.String(), "SEEN_RECENT_MAX") {
+		t.Errorf("log = %q, want a warning about SEEN_RECENT_MAX", logs.String())
+	}
+}
+
+// TestSeenSetSaveLoad は remember したIDだけが SEEN_FILTER_FILE に保存されて次の実行で読み込まれること、
+// 容量に達したフィルタ・容量の変わったフィルタ・壊れたファイルは作り直すことを確かめる
+func TestSeenSetSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.bin")
+	t.Setenv("SEEN_FILTER_FILE", path)
+	t.Setenv("SEEN_FILTER_CAPACITY", "100")
+	var logs bytes.Buffer
+	ctx := withLogger(context.Background(), log.New(&logs, "", 0))
+
+	s := loadSeenSet(ctx)
+	if s.remembered(1) {
+		t.Fatal("remembered(1) = true before remember")
+	}
+	s.remember(1)
+	s.remember(2)
+	s.remember(2)
+	s.add(3) // add は今回の実行だけで、保存しない
+	if !s.remembered(1) || !s.remembered(2) {
+		t.Error("remembered = false right after remember")
+	}
+	s.save(ctx)
+
+	s = loadSeenSet(ctx)
+	if !s.remembered(1) || !s.remembered(2) {
+		t.Error("remembered IDs were not loaded from SEEN_FILTER_FILE")
+	}
+	if s.remembered(3) || s.seen(3) {
+		t.Error("an ID only added in the previous run was loaded")
+	}
+	if s.persisted.count != 2 {
+		t.Errorf("count = %d, want 2 (duplicate remember not counted)", s.persisted.count)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("log = %q, want nothing", logs.String())
+	}
+
+	// 容量が変わったフィルタは作り直す
+	t.Setenv("SEEN_FILTER_CAPACITY", "1000")
+	if s := loadSeenSet(ctx); s.remembered(1) || !strings.Contains(logs.String(), "SEEN_FILTER_CAPACITY") {
+		t.Errorf("filter with another capacity was used; log = %q", logs.String())
+	}
+
+	// 記録した件数が容量に達したフィルタは作り直す
+	t.Setenv("SEEN_FILTER_CAPACITY", "2")
+	s = loadSeenSet(ctx)
+	s.remember(1)
+	s.remember(2)
+	s.save(ctx)
+	logs.Reset()
+	if s := loadSeenSet(ctx); s.remembered(1) || s.persisted.count != 0 || logs.Len() == 0 {
+		t.Errorf("full filter was used (count %d); log = %q", s.persisted.count, logs.String())
+	}
+
+	// 壊れたファイルは警告して作り直す
+	if err := os.WriteFile(path, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logs.Reset()
+	if s := loadSeenSet(ctx); s.persisted == nil || s.remembered(1) || logs.Len() == 0 {
+		t.Errorf("corrupted filter was not rebuilt; log = %q", logs.String())
+	}
+}
@@ -0,0 +1,114 @@
+package yamap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultSelfTestURLs は selftest で開く、ブラウザの自動操作の痕跡を判定する公開のテストページ
+var defaultSelfTestURLs = []string{"https://bot.sannysoft.com/", "https://arh.antoinevastel.com/bots/areyouheadless"}
+
+// automationSignal は selftest で調べるヘッドレスブラウザ・WebDriverの痕跡
+type automationSignal struct {
+	Name    string `json:"name"`
+	Exposed bool   `json:"exposed"`
+	Detail  string `json:"detail"`
+}
+
+// automationSignalsScript はページから見えるヘッドレスブラウザ・WebDriverの痕跡を調べるスクリプト
+const automationSignalsScript = `(() => {
+	const ua = navigator.userAgent;
+	let renderer = "";
+	try {
+		const gl = document.createElement("canvas").getContext("webgl");
+		const ext = gl && gl.getExtension("WEBGL_debug_renderer_info");
+		renderer = ext ? gl.getParameter(ext.UNMASKED_RENDERER_WEBGL) : "";
+	} catch (e) {}
+	return [
+		{name: "navigator.webdriver", exposed: navigator.webdriver === true, detail: String(navigator.webdriver)},
+		{name: "userAgent", exposed: /Headless/i.test(ua), detail: ua},
+		{name: "navigator.plugins", exposed: navigator.plugins.length === 0, detail: String(navigator.plugins.length)},
+		{name: "navigator.languages", exposed: !navigator.languages || navigator.languages.length === 0, detail: (navigator.languages || []).join(",")},
+		{name: "window.chrome", exposed: /Chrome/.test(ua) && typeof window.chrome === "undefined", detail: typeof window.chrome},
+		{name: "window.outerSize", exposed: window.outerWidth === 0 || window.outerHeight === 0, detail: window.outerWidth + "x" + window.outerHeight},
+		{name: "WebGL renderer", exposed: /SwiftShader|llvmpipe/i.test(renderer), detail: renderer},
+	];
+})()`
+
+// runSelfTest はログインせずに、現在のブラウザの起動設定 (-browser, UI_LOCALE, CHROME_EXTRA_FLAGS など) でYAMAPのトップページと
+// 判定用のテストページ (SELFTEST_URLS、カンマ区切り) を開き、ページから見えるヘッドレスブラウザ・WebDriverの痕跡を表示する。
+// テストページ自体の判定結果は、各ページのスクリーンショットとHTMLをデバッグ情報として保存して確認する
+func runSelfTest() error {
+	log.Println(tr("--- プログラム開始 (selftest) ---"))
+	startTime := time.Now()
+	urls := append([]string{"https://yamap.com/"}, defaultSelfTestURLs...)
+	if v := os.Getenv("SELFTEST_URLS"); v != "" {
+		urls = []string{"https://yamap.com/"}
+		for _, u := range strings.Split(v, ",") {
+			if u = strings.TrimSpace(u); u != "" {
+				urls = append(urls, u)
+			}
+		}
+	}
+
+	allocatorCtx, cancelAllocator := context.WithTimeout(context.Background(), runTimeout()+5*time.Minute)
+	defer cancelAllocator()
+	browserCtx, cancelBrowser, err := startBrowser(allocatorCtx)
+	if err != nil {
+		return fmt.Errorf(tr("ブラウザの起動に失敗しました: %w"), err)
+	}
+	defer cancelBrowser()
+	ctx, cancel := context.WithTimeout(browserCtx, runTimeout())
+	defer cancel()
+	status.setCancel(cancel)
+	status.setBrowser(ctx)
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	exposed := make(map[string]bool)
+	for i, u := range urls {
+		var signals []automationSignal
+		if err := runActions(ctx,
+			drv.Navigate(u),
+			drv.WaitNetworkIdle(),
+			drv.Evaluate(automationSignalsScript, &signals),
+		); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			loggerFromContext(ctx).Printf(tr("警告: %s を確認できませんでした: %v"), u, err)
+			continue
+		}
+		loggerFromContext(ctx).Printf("--- %s ---", u)
+		for _, s := range signals {
+			mark := "OK  "
+			if s.Exposed {
+				mark = "NG  "
+				exposed[s.Name] = true
+			}
+			loggerFromContext(ctx).Printf("%s%-20s %s", mark, s.Name, s.Detail)
+		}
+		saveDebugSnapshot(ctx, drv, fmt.Sprintf("selftest_%d", i+1))
+		status.markStep()
+	}
+
+	loggerFromContext(ctx).Println("---------------------------------")
+	if len(exposed) == 0 {
+		loggerFromContext(ctx).Println(tr("ヘッドレスブラウザ・WebDriverの痕跡は見つかりませんでした。"))
+	} else {
+		var names []string
+		for name := range exposed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		loggerFromContext(ctx).Printf(tr("警告: ページから次の痕跡が見えます: %s"), strings.Join(names, ", "))
+	}
+	status.setPhase("done")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
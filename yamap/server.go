@@ -0,0 +1,84 @@
+package yamap
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"time"
+)
+
+// startHealthServer は /healthz と /readyz、進捗イベントの /events を提供するHTTPサーバーをバックグラウンドで起動する。
+// healthz はプロセスが停滞していないか (最後の処理から HEALTH_STALE_AFTER 以内か) を、
+// readyz はブラウザが起動済みでログインを終えているかを返す。
+func startHealthServer(addr string) {
+	staleAfter := 10 * time.Minute
+	if v := os.Getenv("HEALTH_STALE_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Printf(tr("警告: HEALTH_STALE_AFTERの値が不正です。既定値 %s を使用します: %v"), staleAfter, err)
+		} else {
+			staleAfter = d
+		}
+	}
+
+	writeReport := func(w http.ResponseWriter, ok bool, r healthReport) {
+		w.Header().Set("Content-Type", "application/json")
+		if ok {
+			r.Status = "ok"
+			w.WriteHeader(http.StatusOK)
+		} else {
+			r.Status = "unhealthy"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(r)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		r := status.report()
+		ok := true
+		switch r.Phase {
+		case "initializing", "done":
+			// ブラウザ起動前と処理完了後はブラウザの状態を問わない
+		default:
+			status.mu.Lock()
+			last := status.lastStepAt
+			status.mu.Unlock()
+			ok = r.BrowserAlive && time.Since(last) < staleAfter
+		}
+		writeReport(w, ok, r)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		r := status.report()
+		ok := r.BrowserAlive && (r.Phase == "collecting" || r.Phase == "reacting")
+		writeReport(w, ok, r)
+	})
+	mux.HandleFunc("/events", serveEvents)
+
+	go func() {
+		log.Printf(tr("ヘルスチェックサーバーを %s で起動します (/healthz, /readyz, /events)"), addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf(tr("ヘルスチェックサーバーが停止しました: %v"), err)
+		}
+	}()
+}
+
+// startPprofServer は net/http/pprof のハンドラを提供するHTTPサーバーをバックグラウンドで起動する。
+// 実行中のプロファイルを取得できるが認証はないため、外部に公開しないアドレス (127.0.0.1 など) で待ち受けること
+func startPprofServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", httppprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+
+	go func() {
+		log.Printf(tr("pprofサーバーを %s で起動します (/debug/pprof/)"), addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf(tr("pprofサーバーが停止しました: %v"), err)
+		}
+	}()
+}
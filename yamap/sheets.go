@@ -0,0 +1,174 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serviceAccountKey はGoogle Cloudのサービスアカウントの鍵ファイル (JSON) のうち、アクセストークンの取得に使う項目
+type serviceAccountKey struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// loadServiceAccountKey は GOOGLE_SERVICE_ACCOUNT_FILE (未設定の場合は GOOGLE_APPLICATION_CREDENTIALS) のサービスアカウントの鍵を読み込む
+func loadServiceAccountKey() (serviceAccountKey, error) {
+	var key serviceAccountKey
+	keyPath := os.Getenv("GOOGLE_SERVICE_ACCOUNT_FILE")
+	if keyPath == "" {
+		keyPath = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if keyPath == "" {
+		return key, errors.New(tr("GOOGLE_SERVICE_ACCOUNT_FILE にサービスアカウントの鍵ファイルを指定してください"))
+	}
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return key, err
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return key, fmt.Errorf(tr("サービスアカウントの鍵ファイルの形式が不正です: %w"), err)
+	}
+	return key, nil
+}
+
+// googleSheetsScope はスプレッドシートへの書き込みに必要なOAuthスコープ
+const googleSheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+
+// googleAccessToken はサービスアカウントの鍵で署名したJWTをアクセストークンと交換する (OAuth 2.0 JWT Bearer, RFC 7523)
+func googleAccessToken(ctx context.Context, key serviceAccountKey, scope string) (string, error) {
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", errors.New(tr("サービスアカウントの秘密鍵 (private_key) を読み込めません"))
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf(tr("サービスアカウントの秘密鍵の形式が不正です: %w"), err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New(tr("サービスアカウントの秘密鍵がRSA鍵ではありません"))
+	}
+	tokenURI := key.TokenURI
+	if tokenURI == "" {
+		tokenURI = "https://oauth2.googleapis.com/token"
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": scope,
+		"aud":   tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf(tr("JWTの署名に失敗: %w"), err)
+	}
+	form := neturl.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf(tr("アクセストークンの取得に失敗: %w"), err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf(tr("アクセストークンの取得に失敗: ステータス %d: %s"), resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", fmt.Errorf(tr("アクセストークンの応答の形式が不正です: %s"), strings.TrimSpace(string(body)))
+	}
+	return token.AccessToken, nil
+}
+
+// exportReactionsToSheet は GOOGLE_SHEETS_ID が設定されている場合に、今回の実行で送ったリアクションを
+// 日時・URL・投稿者名・投稿者ID・絵文字・アクションの行としてGoogleスプレッドシートに追記する。
+// 認証には GOOGLE_SERVICE_ACCOUNT_FILE (未設定の場合は GOOGLE_APPLICATION_CREDENTIALS) のサービスアカウントの鍵を使う。
+func exportReactionsToSheet(reactions []historyEntry) error {
+	sheetID := os.Getenv("GOOGLE_SHEETS_ID")
+	if sheetID == "" || len(reactions) == 0 {
+		return nil
+	}
+	sheetRange := os.Getenv("GOOGLE_SHEETS_RANGE")
+	if sheetRange == "" {
+		sheetRange = "Sheet1!A:F"
+	}
+	key, err := loadServiceAccountKey()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	token, err := googleAccessToken(ctx, key, googleSheetsScope)
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]string, 0, len(reactions))
+	for _, e := range reactions {
+		authorID := ""
+		if e.AuthorID != 0 {
+			authorID = strconv.FormatInt(e.AuthorID, 10)
+		}
+		postedAt := ""
+		if !e.PostedAt.IsZero() {
+			postedAt = e.PostedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		rows = append(rows, []string{e.ReactedAt.Local().Format("2006-01-02 15:04:05"), e.URL, e.AuthorName, authorID, e.Emoji, e.Action, e.Title, postedAt})
+	}
+	body, _ := json.Marshal(map[string]interface{}{"values": rows})
+	// 投稿者名などがスプレッドシートの数式として解釈されないよう、RAW で書き込む
+	endpoint := fmt.Sprintf("https://sheets.googleapis.com/v4/spreadsheets/%s/values/%s:append?valueInputOption=RAW&insertDataOption=INSERT_ROWS",
+		neturl.PathEscape(sheetID), neturl.PathEscape(sheetRange))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf(tr("ステータス %d: %s"), resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	loggerFromContext(ctx).Printf(tr("%d件のリアクションをGoogleスプレッドシートに追記しました。"), len(rows))
+	return nil
+}
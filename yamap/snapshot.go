@@ -0,0 +1,247 @@
+package yamap
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountSnapshot は snapshot で記録する、ある時点の自分のフォロワー数・フォロー数・DOMOの残高。
+// ページから読み取れなかった項目は nil
+type accountSnapshot struct {
+	At          time.Time `json:"at"`
+	Followers   *int64    `json:"followers"`
+	Following   *int64    `json:"following"`
+	DomoBalance *int64    `json:"domo_balance"`
+	// FollowerIDs は diff-followers で記録したフォロワーのID。snapshot では記録しない
+	FollowerIDs []int64 `json:"follower_ids,omitempty"`
+}
+
+// profileCountsScript はプロフィールページからフォロワー数とフォロー数を取り出すスクリプト。
+// NUXTのデータ内の件数の項目と、「フォロワー 123」のような表示の順に探し、見つからない項目は -1 を返す
+const profileCountsScript = `(() => {
+	const seen = new Set();
+	const find = (v, pattern, depth) => {
+		if (!v || typeof v !== "object" || depth > 6 || seen.has(v)) return null;
+		seen.add(v);
+		for (const [k, x] of Object.entries(v)) {
+			if (pattern.test(k) && typeof x === "number") return x;
+		}
+		for (const x of Object.values(v)) {
+			const found = find(x, pattern, depth + 1);
+			if (found !== null) return found;
+		}
+		return null;
+	};
+	const text = document.body.innerText || "";
+	const fromText = (label) => {
+		const m = text.match(new RegExp(label + "\\s*([\\d,]+)")) || text.match(new RegExp("([\\d,]+)\\s*" + label));
+		return m ? Number(m[1].replace(/,/g, "")) : -1;
+	};
+	const count = (pattern, label) => {
+		seen.clear();
+		const found = find(window.__NUXT__, pattern, 0);
+		return found !== null ? found : fromText(label);
+	};
+	return {
+		followers: count(/^(followers?_count|followers_size)$/i, "フォロワー"),
+		following: count(/^(follows?_count|followings?_count|follows_size)$/i, "フォロー(?!ワー)(?:中)?"),
+	};
+})()`
+
+// runSnapshot は自分のフォロワー数・フォロー数とDOMOの残高を読み取り、履歴に記録する。
+// 定期的に実行することで、history や dashboard で推移を確認できる
+func runSnapshot() error {
+	log.Println(tr("--- プログラム開始 (snapshot) ---"))
+	startTime := time.Now()
+	if history == nil {
+		return errors.New(tr("snapshot では記録先として HISTORY_FILE を設定してください"))
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、フォロワー数を確認できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+	snap := accountSnapshot{At: time.Now()}
+
+	var counts struct {
+		Followers int64 `json:"followers"`
+		Following int64 `json:"following"`
+	}
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", sess.UserID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(profileCountsScript, &counts),
+	); err != nil {
+		return fmt.Errorf(tr("プロフィールページの読み込みに失敗しました: %w"), err)
+	}
+	if counts.Followers >= 0 {
+		snap.Followers = &counts.Followers
+	}
+	if counts.Following >= 0 {
+		snap.Following = &counts.Following
+	}
+	status.markStep()
+
+	if balanceURL := os.Getenv("DOMO_BALANCE_URL"); balanceURL != "" {
+		if err := runActions(ctx, drv.Navigate(balanceURL), drv.WaitVisible(`main`), drv.WaitNetworkIdle()); err != nil {
+			loggerFromContext(ctx).Printf(tr("DOMOの残高のページの読み込みに失敗しました: %v"), err)
+		}
+	}
+	var balance int64
+	if err := runActions(ctx, drv.Evaluate(domoBalanceScript, &balance)); err == nil && balance >= 0 {
+		snap.DomoBalance = &balance
+	}
+	if snap.Followers == nil && snap.Following == nil && snap.DomoBalance == nil {
+		saveDebugSnapshot(ctx, drv, "snapshot")
+		return errors.New(tr("フォロワー数・フォロー数・DOMOの残高をページから読み取れませんでした"))
+	}
+
+	prev, hasPrev := history.latestSnapshot()
+	if err := history.addSnapshot(snap); err != nil {
+		return fmt.Errorf(tr("スナップショットの保存に失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("フォロワー: %s / フォロー: %s / DOMOの残高: %s"),
+		snapshotValue(snap.Followers, prev.Followers, hasPrev), snapshotValue(snap.Following, prev.Following, hasPrev), snapshotValue(snap.DomoBalance, prev.DomoBalance, hasPrev))
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// snapshotValue はスナップショットの値を、前回の値があれば増減とともに表示用の文字列にする
+func snapshotValue(v, prev *int64, hasPrev bool) string {
+	if v == nil {
+		return tr("不明")
+	}
+	if !hasPrev || prev == nil {
+		return strconv.FormatInt(*v, 10)
+	}
+	return fmt.Sprintf("%d (%+d)", *v, *v-*prev)
+}
+
+// runDiffFollowers は現在のフォロワー一覧を前回 diff-followers で記録した一覧と比べ、新しくフォローしたユーザーと
+// フォローを外したユーザーを表示する。DIFF_FOLLOWERS_NOTIFY=true の場合は変化を NOTIFY_WEBHOOK_URL にも送る
+func runDiffFollowers() error {
+	log.Println(tr("--- プログラム開始 (diff-followers) ---"))
+	startTime := time.Now()
+	if history == nil {
+		return errors.New(tr("diff-followers では前回のフォロワー一覧の記録先として HISTORY_FILE を設定してください"))
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、フォロワー一覧を確認できません"))
+	}
+	status.setPhase("collecting")
+
+	current, err := collectFollowers(ctx, sess.UserID)
+	if err != nil {
+		return fmt.Errorf(tr("フォロワー一覧の取得に失敗しました: %w"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("%d人のフォロワーを確認しました。"), len(current))
+	// 一覧のスクロールが途中で止まった場合に、表示されなかったフォロワーをフォローを外したと誤って判定しないよう、
+	// プロフィールに表示されたフォロワー数と比べる
+	var counts struct {
+		Followers int64 `json:"followers"`
+	}
+	complete := true
+	if err := runActions(ctx, driverFromContext(ctx).Evaluate(profileCountsScript, &counts)); err == nil && counts.Followers > int64(len(current)) {
+		loggerFromContext(ctx).Printf(tr("警告: フォロワー一覧を %d / %d 人しか取得できませんでした。フォローを外したユーザーの判定を省略し、今回の一覧は記録しません。"), len(current), counts.Followers)
+		complete = false
+	}
+
+	prev, prevAt, ok := history.latestFollowerList()
+	if ok {
+		followed, unfollowed := diffFollowerIDs(prev, current)
+		if !complete {
+			unfollowed = nil
+		}
+		fmt.Printf(tr("前回の記録 (%s) からの変化\n"), prevAt.Local().Format("2006-01-02 15:04"))
+		fmt.Printf(tr("\n--- 新しくフォローしたユーザー (%d人) ---\n"), len(followed))
+		for _, id := range followed {
+			fmt.Printf("https://yamap.com/users/%d\n", id)
+		}
+		if complete {
+			fmt.Printf(tr("\n--- フォローを外したユーザー (%d人) ---\n"), len(unfollowed))
+			for _, id := range unfollowed {
+				fmt.Printf("https://yamap.com/users/%d\n", id)
+			}
+		}
+		if os.Getenv("DIFF_FOLLOWERS_NOTIFY") == "true" && len(followed)+len(unfollowed) > 0 {
+			notify(ctx, "INFO", followerDiffMessage(followed, unfollowed))
+		}
+	} else {
+		loggerFromContext(ctx).Println(tr("前回のフォロワー一覧の記録がないため、現在のフォロワーを記録しました。次回以降の実行で変化を表示します。"))
+	}
+
+	if complete {
+		n := int64(len(current))
+		if err := history.addSnapshot(accountSnapshot{At: time.Now(), Followers: &n, FollowerIDs: current}); err != nil {
+			return fmt.Errorf(tr("フォロワー一覧の保存に失敗しました: %w"), err)
+		}
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// diffFollowerIDs は前回と今回のフォロワー一覧から、新しくフォローしたユーザーとフォローを外したユーザーを返す
+func diffFollowerIDs(prev, current []int64) (followed, unfollowed []int64) {
+	before := make(map[int64]struct{}, len(prev))
+	for _, id := range prev {
+		before[id] = struct{}{}
+	}
+	now := make(map[int64]struct{}, len(current))
+	for _, id := range current {
+		now[id] = struct{}{}
+		if _, ok := before[id]; !ok {
+			followed = append(followed, id)
+		}
+	}
+	for _, id := range prev {
+		if _, ok := now[id]; !ok {
+			unfollowed = append(unfollowed, id)
+		}
+	}
+	return followed, unfollowed
+}
+
+// followerDiffMessage はフォロワーの変化を通知する文面を作る
+func followerDiffMessage(followed, unfollowed []int64) string {
+	urls := func(ids []int64) string {
+		var s []string
+		for _, id := range ids {
+			s = append(s, fmt.Sprintf("https://yamap.com/users/%d", id))
+		}
+		return strings.Join(s, " ")
+	}
+	msg := fmt.Sprintf(tr("フォロワーの変化: +%d人 / -%d人"), len(followed), len(unfollowed))
+	if len(followed) > 0 {
+		msg += "\n" + tr("新しくフォロー: ") + urls(followed)
+	}
+	if len(unfollowed) > 0 {
+		msg += "\n" + tr("フォローを解除: ") + urls(unfollowed)
+	}
+	return msg
+}
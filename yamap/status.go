@@ -0,0 +1,317 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// runStatus はヘルスチェック用に現在の実行状態を保持する
+type runStatus struct {
+	mu         sync.Mutex
+	action     string
+	phase      string
+	currentURL string
+	lastStepAt time.Time
+	browserCtx context.Context
+	startedAt  time.Time
+	cancel     context.CancelFunc
+	abortErr   error
+	// stoppedBy は新しい投稿の処理を終えた条件 (stopCondition の名前)。条件によらずに終えた場合は空
+	stoppedBy string
+	// queue はリアクション処理の対象の投稿URL。processed 件目までが処理済み
+	queue     []string
+	processed int
+	succeeded int
+	failed    int
+	skipped   int
+	// reactions は今回の実行で成功したリアクション
+	reactions []historyEntry
+	// feed はタイムラインの収集で読み込んだフィードの内訳。タイムラインを収集していない場合は nil
+	feed *feedStats
+	// rateLimited はアクセス過多の表示を検出して一時停止した記録。検出していない場合は nil
+	rateLimited *rateLimitStats
+	// browserProduct は起動したブラウザの製品名とバージョン
+	browserProduct string
+	// outcomes は -report のレポートに載せる、スキップまたは失敗した投稿
+	outcomes []postOutcome
+	// loginDuration はログインにかかった時間
+	loginDuration time.Duration
+}
+
+// status はプロセス全体で共有される実行状態
+var status = &runStatus{phase: "initializing", startedAt: time.Now()}
+
+// setBrowserVersion は起動したブラウザの製品名とバージョンを記録する
+func (s *runStatus) setBrowserVersion(v string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.browserProduct = v
+}
+
+// setLoginDuration はログインにかかった時間を記録する
+func (s *runStatus) setLoginDuration(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loginDuration = d
+}
+
+// browserVersion は起動したブラウザの製品名とバージョンを返す。起動していない場合は空
+func (s *runStatus) browserVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.browserProduct
+}
+
+func (s *runStatus) setAction(action string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.action = action
+}
+
+func (s *runStatus) setPhase(phase string) {
+	s.mu.Lock()
+	s.phase = phase
+	s.mu.Unlock()
+	sdNotify("STATUS=" + phase)
+	if phase == "done" {
+		events.publishDone()
+	}
+}
+
+func (s *runStatus) setBrowser(ctx context.Context) {
+	s.mu.Lock()
+	s.browserCtx = ctx
+	s.lastStepAt = time.Now()
+	s.mu.Unlock()
+	// ブラウザの起動完了をもってsystemdへ準備完了を通知する
+	sdNotify("READY=1")
+}
+
+// setCancel は実行中のアクションのメインコンテキストをキャンセルする関数を登録する
+func (s *runStatus) setCancel(cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel = cancel
+}
+
+// setStoppedBy は新しい投稿の処理を終えた条件を記録する
+func (s *runStatus) setStoppedBy(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stoppedBy = name
+}
+
+// abort はサイトの状態などにより実行全体を中止する。最初の理由を記録し、メインコンテキストをキャンセルする
+func (s *runStatus) abort(err error) {
+	s.mu.Lock()
+	if s.abortErr == nil {
+		s.abortErr = err
+	}
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// abortError は abort で記録された中止理由を返す
+func (s *runStatus) abortError() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.abortErr
+}
+
+func (s *runStatus) setCurrentURL(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentURL = url
+}
+
+// markStep は処理が前進したことを記録する。長時間更新がない場合、ヘルスチェックは異常を返す
+func (s *runStatus) markStep() {
+	s.mu.Lock()
+	s.lastStepAt = time.Now()
+	s.mu.Unlock()
+	sdWatchdog()
+}
+
+// browserAlive はブラウザのプロセスが生存しているかを確認する。プロセスの確認は processAlive を使うため、Windowsでも動く
+func (s *runStatus) browserAlive() bool {
+	s.mu.Lock()
+	ctx := s.browserCtx
+	s.mu.Unlock()
+	if ctx == nil || ctx.Err() != nil {
+		return false
+	}
+	proc := driverFromContext(ctx).Process()
+	if proc == nil {
+		return false
+	}
+	return processAlive(proc.Pid)
+}
+
+// healthReport は /healthz, /readyz のレスポンスボディ
+type healthReport struct {
+	Status        string `json:"status"`
+	Action        string `json:"action"`
+	Phase         string `json:"phase"`
+	CurrentURL    string `json:"current_url,omitempty"`
+	BrowserAlive  bool   `json:"browser_alive"`
+	LastStepAt    string `json:"last_step_at,omitempty"`
+	SinceLastStep string `json:"since_last_step,omitempty"`
+	Uptime        string `json:"uptime"`
+	Queued        int    `json:"queued"`
+	Processed     int    `json:"processed"`
+	Succeeded     int    `json:"succeeded"`
+	Failed        int    `json:"failed"`
+	Skipped       int    `json:"skipped"`
+	// Feed はタイムラインの収集で読み込んだフィードの内訳
+	Feed *feedStats `json:"feed,omitempty"`
+	// RateLimited はアクセス過多の表示を検出して一時停止した記録
+	RateLimited *rateLimitStats `json:"rate_limited,omitempty"`
+}
+
+// recordRateLimit はアクセス過多の表示を検出し、paused の間一時停止したことを記録する
+func (s *runStatus) recordRateLimit(url string, paused time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rateLimited == nil {
+		s.rateLimited = &rateLimitStats{}
+	}
+	r := s.rateLimited
+	r.Detections++
+	r.paused += paused
+	r.Paused = r.paused.Round(time.Second).String()
+	r.URLs = append(r.URLs, url)
+	r.LastAt = time.Now()
+}
+
+// rateLimitReport は記録のコピーを返す。s.mu を持った状態で呼ぶ
+func (s *runStatus) rateLimitReport() *rateLimitStats {
+	if s.rateLimited == nil {
+		return nil
+	}
+	r := *s.rateLimited
+	r.URLs = slices.Clone(r.URLs)
+	return &r
+}
+
+// setFeedStats はタイムラインの収集で読み込んだフィードの内訳を記録する
+func (s *runStatus) setFeedStats(f *feedStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.feed = f
+}
+
+// setQueue はリアクション処理の対象を設定し、処理結果の集計をやり直す
+func (s *runStatus) setQueue(urls []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = urls
+	s.processed, s.succeeded, s.failed, s.skipped = 0, 0, 0, 0
+}
+
+// restoreResults は前回の実行から引き継いだ処理結果の件数を集計に加える。setQueue の後に呼ぶ
+func (s *runStatus) restoreResults(succeeded, failed, skipped int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.succeeded += succeeded
+	s.failed += failed
+	s.skipped += skipped
+	s.processed += succeeded + failed + skipped
+}
+
+// recordResult は投稿1件の処理結果を集計する
+func (s *runStatus) recordResult(liked bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed++
+	var skipErr *skipError
+	switch {
+	case errors.As(err, &skipErr):
+		s.skipped++
+	case liked:
+		s.succeeded++
+	default:
+		s.failed++
+	}
+}
+
+// addReaction は今回の実行で成功したリアクションを記録する
+func (s *runStatus) addReaction(e historyEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reactions = append(s.reactions, e)
+}
+
+// runReactions は今回の実行で成功したリアクションを返す
+func (s *runStatus) runReactions() []historyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]historyEntry(nil), s.reactions...)
+}
+
+// pendingQueue は未処理の投稿URLを返す
+func (s *runStatus) pendingQueue() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.processed >= len(s.queue) {
+		return nil
+	}
+	return append([]string(nil), s.queue[s.processed:]...)
+}
+
+func (s *runStatus) report() healthReport {
+	alive := s.browserAlive()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := healthReport{
+		Action:       s.action,
+		Phase:        s.phase,
+		CurrentURL:   s.currentURL,
+		BrowserAlive: alive,
+		Uptime:       time.Since(s.startedAt).Round(time.Second).String(),
+		Queued:       len(s.queue),
+		Processed:    s.processed,
+		Succeeded:    s.succeeded,
+		Failed:       s.failed,
+		Skipped:      s.skipped,
+		Feed:         s.feed,
+		RateLimited:  s.rateLimitReport(),
+	}
+	if !s.lastStepAt.IsZero() {
+		r.LastStepAt = s.lastStepAt.Format(time.RFC3339)
+		r.SinceLastStep = time.Since(s.lastStepAt).Round(time.Second).String()
+	}
+	return r
+}
+
+// result は現在までの実行の結果を履歴に保存する形式で返す
+func (s *runStatus) result() runRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	build := currentBuildInfo()
+	build.Browser = s.browserProduct
+	r := runRecord{
+		Build:        &build,
+		RunID:        runID,
+		Action:       s.action,
+		StartedAt:    s.startedAt,
+		FinishedAt:   time.Now(),
+		Processed:    s.processed,
+		Succeeded:    s.succeeded,
+		Failed:       s.failed,
+		Skipped:      s.skipped,
+		Feed:         s.feed,
+		RateLimited:  s.rateLimitReport(),
+		LoginSeconds: s.loginDuration.Seconds(),
+		StoppedBy:    s.stoppedBy,
+	}
+	if s.abortErr != nil {
+		r.Aborted = s.abortErr.Error()
+	}
+	return r
+}
@@ -0,0 +1,45 @@
+package yamap
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// processDriver は Process だけを返すテスト用の pageDriver
+type processDriver struct {
+	pageDriver
+	proc *os.Process
+}
+
+func (d processDriver) Process() *os.Process { return d.proc }
+
+func TestBrowserAlive(t *testing.T) {
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	exited := &os.Process{Pid: exitedPID(t)}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"running process", context.WithValue(context.Background(), driverContextKey{}, pageDriver(processDriver{proc: self})), true},
+		{"exited process", context.WithValue(context.Background(), driverContextKey{}, pageDriver(processDriver{proc: exited})), false},
+		{"no process (replay)", context.WithValue(context.Background(), driverContextKey{}, pageDriver(processDriver{})), false},
+		{"browser context ended", context.WithValue(canceled, driverContextKey{}, pageDriver(processDriver{proc: self})), false},
+		{"no browser", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &runStatus{browserCtx: tt.ctx}
+			if got := s.browserAlive(); got != tt.want {
+				t.Errorf("browserAlive() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
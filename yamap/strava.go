@@ -0,0 +1,322 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stravaClient はStravaのAPIのクライアント。アクセストークンはリフレッシュトークンから取得する
+type stravaClient struct {
+	clientID, clientSecret string
+	refreshToken           string
+	// tokenFile は更新されたリフレッシュトークンを保存するファイル
+	tokenFile   string
+	accessToken string
+}
+
+// stravaTokenFile は STRAVA_TOKEN_FILE の内容
+type stravaTokenFile struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newStravaClient は STRAVA_CLIENT_ID・STRAVA_CLIENT_SECRET と、STRAVA_TOKEN_FILE (既定値 strava-token.json) に保存した
+// リフレッシュトークン (ない場合は STRAVA_REFRESH_TOKEN) からクライアントを作る
+func newStravaClient() (*stravaClient, error) {
+	c := &stravaClient{
+		clientID:     os.Getenv("STRAVA_CLIENT_ID"),
+		clientSecret: os.Getenv("STRAVA_CLIENT_SECRET"),
+		refreshToken: os.Getenv("STRAVA_REFRESH_TOKEN"),
+		tokenFile:    os.Getenv("STRAVA_TOKEN_FILE"),
+	}
+	if c.tokenFile == "" {
+		c.tokenFile = "strava-token.json"
+	}
+	if data, err := os.ReadFile(c.tokenFile); err == nil {
+		var saved stravaTokenFile
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf(tr("%s の形式が不正です: %w"), c.tokenFile, err)
+		}
+		if saved.RefreshToken != "" {
+			c.refreshToken = saved.RefreshToken
+		}
+	}
+	if c.clientID == "" || c.clientSecret == "" || c.refreshToken == "" {
+		return nil, errors.New(tr("STRAVA_CLIENT_ID・STRAVA_CLIENT_SECRET・STRAVA_REFRESH_TOKEN を設定してください"))
+	}
+	return c, nil
+}
+
+// authorize はリフレッシュトークンからアクセストークンを取得する。
+// Stravaはリフレッシュトークンを更新することがあるため、変わった場合は STRAVA_TOKEN_FILE に保存する
+func (c *stravaClient) authorize(ctx context.Context) error {
+	form := neturl.Values{
+		"client_id":     {c.clientID},
+		"client_secret": {c.clientSecret},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {c.refreshToken},
+	}
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://www.strava.com/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := c.do(req, &token); err != nil {
+		return fmt.Errorf(tr("Stravaのアクセストークンの取得に失敗しました: %w"), err)
+	}
+	c.accessToken = token.AccessToken
+	redaction.add(token.AccessToken)
+	if token.RefreshToken != "" && token.RefreshToken != c.refreshToken {
+		c.refreshToken = token.RefreshToken
+		redaction.add(token.RefreshToken)
+		data, _ := json.Marshal(stravaTokenFile{RefreshToken: token.RefreshToken})
+		if err := os.WriteFile(c.tokenFile, data, 0o600); err != nil {
+			return fmt.Errorf(tr("更新されたStravaのリフレッシュトークンを %s に保存できません: %w"), c.tokenFile, err)
+		}
+	}
+	return nil
+}
+
+// stravaUpload はアップロードの処理状況 (GET /uploads/{id} の応答)
+type stravaUpload struct {
+	ID         int64  `json:"id"`
+	ActivityID int64  `json:"activity_id"`
+	Status     string `json:"status"`
+	Error      string `json:"error"`
+}
+
+// stravaDuplicatePattern はアップロードが既存のアクティビティと重複した場合のエラーから、既存のアクティビティのIDを取り出す
+var stravaDuplicatePattern = regexp.MustCompile(`duplicate of .*?(\d+)`)
+
+// upload はGPXファイルをアクティビティとしてアップロードし、処理が終わるまで待ってアクティビティのIDを返す。
+// externalID が同じアップロードや同じ記録のアクティビティが既にある場合は、既存のアクティビティのIDを返す
+func (c *stravaClient) upload(ctx context.Context, gpx []byte, name, description, activityType, externalID string) (int64, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	fields := map[string]string{"data_type": "gpx", "name": name, "description": description, "external_id": externalID}
+	if activityType != "" {
+		fields["activity_type"] = activityType
+	}
+	for k, v := range fields {
+		w.WriteField(k, v)
+	}
+	part, err := w.CreateFormFile("file", externalID+".gpx")
+	if err != nil {
+		return 0, err
+	}
+	part.Write(gpx)
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://www.strava.com/api/v3/uploads", &body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var up stravaUpload
+	if err := c.do(req, &up); err != nil {
+		return 0, err
+	}
+	// アップロードは非同期に処理されるため、アクティビティが作られるかエラーになるまで待つ
+	for attempt := 0; up.ActivityID == 0 && up.Error == "" && attempt < 30; attempt++ {
+		if err := sleepAction(2 * time.Second)(ctx); err != nil {
+			return 0, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("https://www.strava.com/api/v3/uploads/%d", up.ID), nil)
+		if err != nil {
+			return 0, err
+		}
+		if err := c.do(req, &up); err != nil {
+			return 0, err
+		}
+	}
+	switch {
+	case up.ActivityID != 0:
+		return up.ActivityID, nil
+	case up.Error != "":
+		if m := stravaDuplicatePattern.FindStringSubmatch(up.Error); m != nil {
+			id, _ := strconv.ParseInt(m[1], 10, 64)
+			return id, nil
+		}
+		return 0, errors.New(up.Error)
+	default:
+		return 0, fmt.Errorf(tr("アップロードの処理が終わりませんでした (状態: %s)"), up.Status)
+	}
+}
+
+// do はリクエストを送り、2xx の応答のJSONを out にデコードする。アクセストークンの取得後は Authorization を付ける
+func (c *stravaClient) do(req *http.Request, out interface{}) error {
+	if c.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf(tr("ステータス %d: %s"), resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, out)
+}
+
+// runSyncStrava は自分の最近の活動日記 STRAVA_SYNC_ACTIVITIES 件 (既定値 5) のうち、まだ同期していないもののGPXファイルを
+// ダウンロードし、タイトルと本文を付けてStravaにアップロードする。同期した活動日記は履歴に記録し、二度アップロードしない
+func runSyncStrava() error {
+	log.Println(tr("--- プログラム開始 (sync-strava) ---"))
+	startTime := time.Now()
+	if history == nil {
+		return errors.New(tr("sync-strava では同期済みの活動日記を記録するために HISTORY_FILE を設定してください"))
+	}
+	activityCount := 5
+	if v := os.Getenv("STRAVA_SYNC_ACTIVITIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf(tr("STRAVA_SYNC_ACTIVITIESの値が不正です: %s"), v)
+		}
+		activityCount = n
+	}
+	activityType := os.Getenv("STRAVA_ACTIVITY_TYPE")
+	if activityType == "" {
+		activityType = "hike"
+	}
+	strava, err := newStravaClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+	if sess.UserID == 0 {
+		return errors.New(tr("自分のユーザーIDを取得できなかったため、自分の活動日記を確認できません"))
+	}
+	status.setPhase("collecting")
+	drv := driverFromContext(ctx)
+
+	var paths []string
+	if err := runActions(ctx,
+		drv.Navigate(fmt.Sprintf("https://yamap.com/users/%d", sess.UserID)),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(myActivityLinksScript, &paths),
+	); err != nil {
+		return fmt.Errorf(tr("自分の活動日記の一覧の取得に失敗: %w"), err)
+	}
+	if len(paths) > activityCount {
+		paths = paths[:activityCount]
+	}
+	var ids []int64
+	for _, p := range paths {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(p, "/activities/"), 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	if !history.hasStravaSyncedAny() {
+		// 初回はStravaに記録済みかもしれない過去の活動日記をアップロードしないよう、現在の活動日記を同期済みとして記録するだけにする
+		if err := history.markStravaSynced(ids, 0); err != nil {
+			return fmt.Errorf(tr("同期済みの活動日記の保存に失敗しました: %w"), err)
+		}
+		loggerFromContext(ctx).Println(tr("初回の実行のため、現在の活動日記を同期済みとして記録しました。次回以降の実行で新しい活動日記をアップロードします。"))
+		status.setPhase("done")
+		sdNotify("STOPPING=1")
+		return nil
+	}
+
+	var pending []int64
+	for i := len(ids) - 1; i >= 0; i-- {
+		if !history.isStravaSynced(ids[i]) {
+			pending = append(pending, ids[i])
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("Stravaに同期していない活動日記: %d件"), len(pending))
+	if len(pending) == 0 {
+		status.setPhase("done")
+		sdNotify("STOPPING=1")
+		return nil
+	}
+	if err := strava.authorize(ctx); err != nil {
+		return err
+	}
+
+	status.setPhase("reacting")
+	synced := 0
+	for _, id := range pending {
+		if ctx.Err() != nil || maxRuntimeReached() {
+			break
+		}
+		url := fmt.Sprintf("https://yamap.com/activities/%d", id)
+		stravaID, err := syncActivityToStrava(ctx, drv, strava, url, id, activityType)
+		status.recordResult(err == nil, err)
+		var skipErr *skipError
+		if errors.As(err, &skipErr) {
+			loggerFromContext(ctx).Printf(tr("Stravaへの同期をスキップしました (%s): %s"), url, skipErr.reason)
+			continue
+		} else if err != nil {
+			loggerFromContext(ctx).Printf(tr("Stravaへの同期に失敗しました (%s): %v"), url, err)
+			continue
+		}
+		if err := history.markStravaSynced([]int64{id}, stravaID); err != nil {
+			return fmt.Errorf(tr("同期済みの活動日記の保存に失敗しました: %w"), err)
+		}
+		loggerFromContext(ctx).Printf(tr("Stravaに同期しました: %s → https://www.strava.com/activities/%d"), url, stravaID)
+		synced++
+		status.markStep()
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("--- %d件の活動日記をStravaに同期しました ---"), synced)
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// syncActivityToStrava は活動日記1件のGPXファイルをダウンロードし、タイトルと本文を付けてStravaにアップロードする
+func syncActivityToStrava(ctx context.Context, drv pageDriver, strava *stravaClient, url string, id int64, activityType string) (int64, error) {
+	var gpxURL string
+	if err := runActions(ctx,
+		drv.Navigate(url),
+		drv.WaitVisible(layout.footer),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(gpxLinkScript, &gpxURL),
+	); err != nil {
+		return 0, err
+	}
+	if gpxURL == "" {
+		return 0, &skipError{reason: tr("GPXファイルのダウンロードのリンクがありません")}
+	}
+	meta, err := fetchActivityMetadata(ctx, drv)
+	if err != nil {
+		return 0, fmt.Errorf(tr("活動の情報の取得に失敗: %w"), err)
+	}
+	gpx, err := fetchInPage(ctx, drv, gpxURL)
+	if err != nil {
+		return 0, fmt.Errorf(tr("GPXファイルのダウンロードに失敗しました: %w"), err)
+	}
+	description := strings.TrimSpace(meta.Description + "\n\n" + url)
+	return strava.upload(ctx, gpx, meta.Title, description, activityType, fmt.Sprintf("yamap-%d", id))
+}
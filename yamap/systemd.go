@@ -0,0 +1,42 @@
+package yamap
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sdNotify はsystemdの Type=notify サービスとして実行されている場合に、NOTIFY_SOCKET へ状態を送信する。
+// NOTIFY_SOCKET が未設定の場合は何もしない。
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// 先頭が "@" のソケットは抽象名前空間を表す
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf(tr("systemdへの通知に失敗しました (%s): %v"), state, err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf(tr("systemdへの通知に失敗しました (%s): %v"), state, err)
+	}
+}
+
+// sdWatchdog はsystemdのウォッチドッグが有効な場合 (WATCHDOG_USEC が設定されている場合) に生存通知を送信する
+func sdWatchdog() {
+	if os.Getenv("WATCHDOG_USEC") == "" {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	sdNotify("WATCHDOG=1")
+}
@@ -0,0 +1,756 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runTimelineReaction はタイムラインへのリアクション処理全体を実行する
+func runTimelineReaction() error {
+	log.Println(tr("--- プログラム開始 ---"))
+	startTime := time.Now()
+	if saveFeedPath != "" {
+		savedFeed = newFeedRecorder()
+	}
+
+	// 多数の投稿を処理する際にブラウザセッションがタイムアウトしないよう、アロケータのタイムアウトはメインより5分長くする
+	allocatorCtx, cancelAllocator := context.WithTimeout(context.Background(), runTimeout()+5*time.Minute)
+	defer cancelAllocator()
+
+	ctx, cancel, err := startBrowser(allocatorCtx)
+	if err != nil {
+		return fmt.Errorf(tr("ブラウザの起動に失敗しました: %w"), err)
+	}
+	defer cancel()
+
+	// メインのコンテキストタイムアウトは넉넉하게設定
+	ctx, cancel = context.WithTimeout(ctx, runTimeout())
+	status.setCancel(cancel)
+	defer cancel()
+	loggerFromContext(ctx).Println(tr("ブラウザの初期化完了。"))
+	status.setBrowser(ctx)
+	status.setPhase("logging-in")
+
+	loggerFromContext(ctx).Println(tr("環境変数を読み込んでいます..."))
+	email := os.Getenv("YAMAP_EMAIL")
+	password, err := resolvePassword(email)
+	if err != nil {
+		return fmt.Errorf(tr("パスワードの取得に失敗しました: %w"), err)
+	}
+	postCountStr := os.Getenv("TIMELINE_POST_COUNT_TO_PROCESS")
+	if email == "" || password == "" || postCountStr == "" {
+		return errors.New(tr("環境変数 YAMAP_EMAIL, YAMAP_PASSWORD, TIMELINE_POST_COUNT_TO_PROCESS を設定してください"))
+	}
+	postCount, err := strconv.Atoi(postCountStr)
+	if err != nil {
+		return fmt.Errorf(tr("TIMELINE_POST_COUNT_TO_PROCESSの値が不正です: %w"), err)
+	}
+	loggerFromContext(ctx).Println(tr("環境変数の読み込み完了。"))
+
+	loggerFromContext(ctx).Println(tr("ログイン処理を開始します..."))
+	loginStartTime := time.Now()
+	if err := login(ctx, email, password, true); err != nil {
+		return loginError(err)
+	}
+	loggerFromContext(ctx).Printf(tr("ログイン成功。処理時間: %s"), time.Since(loginStartTime))
+	ctx = withSession(ctx, discoverSession(ctx))
+	status.setPhase("collecting")
+	status.markStep()
+
+	loggerFromContext(ctx).Println(tr("タイムラインの処理を開始します..."))
+	timelineStartTime := time.Now()
+	reactedURLs, err := processTimeline(ctx, postCount)
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("タイムライン処理中にエラーが発生しました: %v"), err)
+	}
+	loggerFromContext(ctx).Printf(tr("タイムライン処理完了。処理時間: %s"), time.Since(timelineStartTime))
+	if savedFeed != nil {
+		if err := savedFeed.write(saveFeedPath); err != nil {
+			loggerFromContext(ctx).Printf(tr("フィードの保存に失敗しました: %v"), err)
+		} else {
+			loggerFromContext(ctx).Printf(tr("読み込んだフィード %d 件を %s に保存しました。"), len(savedFeed.items), saveFeedPath)
+		}
+	}
+
+	if len(reactedURLs) > 0 {
+		loggerFromContext(ctx).Println(tr("\n--- 「いいね！」した投稿一覧 ---"))
+		for _, url := range reactedURLs {
+			loggerFromContext(ctx).Println(url)
+		}
+		loggerFromContext(ctx).Println("---------------------------------")
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+
+	printDependencies()
+	return nil
+}
+
+func processTimeline(ctx context.Context, postCountToProcess int) ([]string, error) {
+	if runCheckpoints.resuming() {
+		status.setPhase("reacting")
+		return reactToActivities(ctx, nil), nil
+	}
+	activitiesToProcess, err := collectTimeline(ctx, postCountToProcess)
+	if err != nil {
+		return nil, err
+	}
+	loggerFromContext(ctx).Printf(tr("%d件の未リアクション投稿を収集しました。リアクション処理を開始します。"), len(activitiesToProcess))
+	status.setPhase("reacting")
+
+	reacted := reactToActivities(ctx, activitiesToProcess)
+	// 収集した投稿を最後まで処理した場合 (中断・stop_when などで終えていない場合) だけ、次回の収集を今回確認した投稿で打ち切るようにする
+	if ctx.Err() == nil && status.result().StoppedBy == "" {
+		feedMarkers.commit(ctx)
+	}
+	return reacted, nil
+}
+
+// collectTimeline はタイムラインをスクロールし、未リアクションの投稿を収集する
+func collectTimeline(ctx context.Context, postCountToProcess int) (_ []ActivityInfo, err error) {
+	ctx, span := startSpan(ctx, "collect timeline", "collect.limit", postCountToProcess)
+	defer func() { span.finish(err) }()
+	drv := driverFromContext(ctx)
+	loggerFromContext(ctx).Println(tr("タイムライン上の未リアクションの投稿URLを収集します..."))
+
+	var activitiesToProcess []ActivityInfo
+	seenActivities := loadSeenSet(ctx)
+	defer seenActivities.save(ctx)
+	authors := newAuthorLimiter(ctx)
+	feedEnd := newFeedEndDetector()
+	recoveries := 0
+	stats := newFeedStats()
+	seenFeeds := newSeenSet(ctx)
+	seenMoments := newSeenSet(ctx)
+	cutoff := time.Now().Add(-maxAge)
+	// marker は前回までに最後まで確認した最も新しい活動日記のID (FEED_MARKERS_FILE)。
+	// complete は今回の収集がフィードの終端・-max-age・marker まで確認できたかで、その場合だけ newestID を次回の目印にする
+	marker := feedMarkers.newest(ctx, "timeline")
+	var newestID int64
+	complete := false
+
+	checkpoint := loadTimelineCheckpoint(ctx)
+	if checkpoint.ScrollY > 0 || len(checkpoint.SeenIDs) > 0 {
+		loggerFromContext(ctx).Printf(tr("前回中断した収集を再開します (確認済み %d 件、収集済み %d 件)。"), len(checkpoint.SeenIDs), len(checkpoint.Collected))
+		for _, id := range checkpoint.SeenIDs {
+			seenActivities.add(id)
+		}
+		activitiesToProcess = append(activitiesToProcess, checkpoint.Collected...)
+		restoreScrollPosition(ctx, drv, checkpoint.ScrollY)
+	}
+
+	for len(activitiesToProcess) < postCountToProcess {
+		select {
+		case <-ctx.Done():
+			loggerFromContext(ctx).Println(tr("URL収集中にタイムアウトしました。"))
+			return nil, ctx.Err()
+		default:
+		}
+		if maxRuntimeReached() {
+			loggerFromContext(ctx).Println(tr("最大実行時間に達したため、URLの収集を終了します。"))
+			break
+		}
+
+		if err := runActions(ctx,
+			drv.WaitVisible(layout.feed),
+			drv.Poll(`window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds`, 20*time.Second),
+		); err != nil {
+			if errors.Is(err, errRendererCrashed) && recoveries < maxCollectionRecoveries {
+				// タブは作り直されているため、タイムラインを開き直して中断した位置までスクロールする
+				recoveries++
+				loggerFromContext(ctx).Printf(tr("タブのクラッシュから復旧し、タイムラインの収集を再開します (%d/%d)。"), recoveries, maxCollectionRecoveries)
+				if err := runActions(ctx, drv.Navigate("https://yamap.com/timeline"), drv.WaitVisible(layout.feed)); err != nil {
+					loggerFromContext(ctx).Printf(tr("タイムラインを開き直せませんでした: %v"), err)
+					break
+				}
+				restoreScrollPosition(ctx, drv, checkpoint.ScrollY)
+				feedEnd.height = 0
+				continue
+			}
+			loggerFromContext(ctx).Printf(tr("タイムラインデータの準備待機中にエラーが発生しました: %v"), err)
+			break // ループを抜けて収集したURLの処理に移る
+		}
+
+		feedItems, err := parseNuxtData(ctx)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("NUXTデータのパースに失敗: %v"), err)
+			break
+		}
+		status.markStep()
+		savedFeed.add(feedItems)
+
+		initialCount := len(activitiesToProcess)
+		// newInBatch, oldInBatch はこの読み込みで初めて見た活動日記と、そのうち -max-age より古いものの件数
+		newInBatch, oldInBatch := 0, 0
+		markerReached := false
+		for _, item := range feedItems {
+			if !seenFeeds.seen(item.ID) {
+				seenFeeds.add(item.ID)
+				stats.ByType[item.FeedableType]++
+			}
+			if item.Moment != nil {
+				// モーメントのIDは活動日記とは別の番号のため、確認済みのIDも分けて持つ
+				if !includeMoments || item.Moment.ID == 0 || seenMoments.seen(item.Moment.ID) {
+					continue
+				}
+				seenMoments.add(item.Moment.ID)
+				info, skip := momentActivity(item, cutoff)
+				if skip != "" {
+					if skip != "reacted" {
+						stats.Skipped[skip]++
+					}
+					continue
+				}
+				var author User
+				if item.Moment.User != nil {
+					author = *item.Moment.User
+				}
+				if reason := authors.allow(author); reason != "" {
+					loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) の投稿をスキップします (%s): %s"), info.AuthorID, reason, info.URL)
+					continue
+				}
+				if !hooksFromContext(ctx).collected(ctx, info) {
+					continue
+				}
+				activitiesToProcess = append(activitiesToProcess, info)
+				stats.Moments++
+				loggerFromContext(ctx).Printf(tr("未リアクションのモーメントを発見: %s (現在 %d 件)"), info.URL, len(activitiesToProcess))
+				events.publish("collected", info.URL, "", "")
+				status.markStep()
+				if len(activitiesToProcess) >= postCountToProcess {
+					goto collected
+				}
+				continue
+			}
+			if item.Activity == nil || item.Activity.ID == 0 {
+				continue
+			}
+			if !seenActivities.seen(item.Activity.ID) {
+				seenActivities.add(item.Activity.ID)
+				if !item.isPromoted() {
+					newestID = max(newestID, item.Activity.ID)
+					if marker > 0 && item.Activity.ID <= marker {
+						markerReached = true
+					}
+				}
+				if seenActivities.remembered(item.Activity.ID) {
+					stats.SeenBefore++
+					continue
+				}
+				if item.isPromoted() {
+					seenActivities.remember(item.Activity.ID)
+					loggerFromContext(ctx).Printf(tr("広告・キャンペーンの投稿をスキップします (feedable_type: %s): https://yamap.com/activities/%d"), item.FeedableType, item.Activity.ID)
+					stats.Skipped["promoted"]++
+					continue
+				}
+				postedAt := item.Activity.CreatedAt.time()
+				if postedAt.IsZero() {
+					postedAt = item.CreatedAt.time()
+				}
+				newInBatch++
+				tooOld := maxAge > 0 && !postedAt.IsZero() && postedAt.Before(cutoff)
+				if tooOld {
+					oldInBatch++
+				}
+				hasReacted := false
+				for _, reaction := range item.Activity.EmojiReactions {
+					if reaction.ViewerHasReacted {
+						hasReacted = true
+						break
+					}
+				}
+				if hasReacted {
+					stats.Reacted++
+					seenActivities.remember(item.Activity.ID)
+				} else {
+					stats.Unreacted++
+				}
+				if !hasReacted && tooOld {
+					stats.Skipped["too_old"]++
+				} else if !hasReacted {
+					url := fmt.Sprintf("https://yamap.com/activities/%d", item.Activity.ID)
+					var author User
+					if item.Activity.User != nil {
+						author = *item.Activity.User
+					}
+					authorID, authorName := author.ID, author.Name
+					if reason := authors.allow(author); reason != "" {
+						loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) の投稿をスキップします (%s): %s"), authorID, reason, url)
+						continue
+					}
+					info := ActivityInfo{
+						URL: url, AuthorID: authorID, AuthorName: authorName, Title: item.Activity.Title, PostedAt: postedAt,
+						ReactionCount: len(item.Activity.EmojiReactions), ReactionCountKnown: true,
+					}
+					if !hooksFromContext(ctx).collected(ctx, info) {
+						continue
+					}
+					activitiesToProcess = append(activitiesToProcess, info)
+					loggerFromContext(ctx).Printf(tr("未リアクションの投稿を発見: %s (現在 %d 件)"), url, len(activitiesToProcess))
+					events.publish("collected", url, "", "")
+					status.markStep()
+					if len(activitiesToProcess) >= postCountToProcess {
+						goto collected
+					}
+				}
+			}
+		}
+
+		// タイムラインは新しい順のため、読み込んだ投稿がすべて古ければ、これ以上スクロールしても新しい投稿は出てこない
+		if newInBatch > 0 && oldInBatch == newInBatch {
+			loggerFromContext(ctx).Printf(tr("読み込んだ投稿がすべて -max-age (%s) より古くなったため、スクロールを終了します。"), maxAge)
+			complete = true
+			break
+		}
+		if markerReached {
+			loggerFromContext(ctx).Printf(tr("前回までに確認した投稿 (ID: %d) に達したため、スクロールを終了します。"), marker)
+			complete = true
+			break
+		}
+		reason, err := feedEnd.observe(ctx, drv, len(activitiesToProcess) > initialCount)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("ページの高さの取得に失敗: %v"), err)
+			break
+		}
+		if reason != "" {
+			loggerFromContext(ctx).Printf(tr("%s。タイムラインの終端と判断します。"), reason)
+			complete = true
+			break
+		}
+
+		loggerFromContext(ctx).Println(tr("ページを下にスクロールします..."))
+		if err := runActions(ctx, scrollForMore(drv, feedCountScript)); err != nil {
+			loggerFromContext(ctx).Printf(tr("ページスクロールに失敗: %v"), err)
+			break
+		}
+		checkpoint.ScrollY = feedEnd.height
+		checkpoint.SeenIDs = seenActivities.recentIDs()
+		checkpoint.Collected = activitiesToProcess
+		checkpoint.save(ctx)
+	}
+
+collected:
+	authors.logSummary(ctx)
+	stats.Collected = len(activitiesToProcess)
+	stats.addAuthorSkips(authors)
+	stats.log(ctx)
+	status.setFeedStats(stats)
+	span.setAttr("collect.collected", stats.Collected)
+	span.setAttr("collect.reacted", stats.Reacted)
+	span.setAttr("collect.unreacted", stats.Unreacted)
+	checkpoint.clear(ctx)
+	if complete {
+		feedMarkers.stage(ctx, "timeline", newestID)
+	}
+	return activitiesToProcess, nil
+}
+
+// momentActivity はタイムラインのモーメントをリアクションの対象として返す。
+// 対象にしない場合は、その理由 (feedSkipLabels のキー、またはリアクション済みの "reacted") を返す
+func momentActivity(item FeedItem, cutoff time.Time) (ActivityInfo, string) {
+	m := item.Moment
+	if item.isPromoted() {
+		return ActivityInfo{}, "promoted"
+	}
+	for _, reaction := range m.EmojiReactions {
+		if reaction.ViewerHasReacted {
+			return ActivityInfo{}, "reacted"
+		}
+	}
+	postedAt := m.CreatedAt.time()
+	if postedAt.IsZero() {
+		postedAt = item.CreatedAt.time()
+	}
+	if maxAge > 0 && !postedAt.IsZero() && postedAt.Before(cutoff) {
+		return ActivityInfo{}, "too_old"
+	}
+	// モーメントにはタイトルがないため、本文の書き出しをタイトルの代わりにする
+	title := []rune(strings.Join(strings.Fields(m.Text), " "))
+	if len(title) > 40 {
+		title = append(title[:40], '…')
+	}
+	info := ActivityInfo{
+		URL: fmt.Sprintf("https://yamap.com/moments/%d", m.ID), Title: string(title), PostedAt: postedAt,
+		ReactionCount: len(m.EmojiReactions), ReactionCountKnown: true,
+	}
+	if m.User != nil {
+		info.AuthorID, info.AuthorName = m.User.ID, m.User.Name
+	}
+	return info, ""
+}
+
+// feedStats はタイムラインの収集中に読み込んだフィードの内訳。収集した件数が指定より少ない理由を把握するために使う
+type feedStats struct {
+	// ByType は feedable_type ごとの件数
+	ByType map[string]int `json:"by_type"`
+	// Reacted, Unreacted は活動日記のうちリアクション済み・未リアクションの件数 (広告などを除く)
+	Reacted   int `json:"reacted"`
+	Unreacted int `json:"unreacted"`
+	// SeenBefore は前回までの実行でリアクション済みか広告と確認したため、内訳に数えずに除いた活動日記の件数 (SEEN_FILTER_FILE)
+	SeenBefore int `json:"seen_before,omitempty"`
+	// Collected はリアクションの対象として収集した件数、Moments はそのうちモーメントの件数 (-moments)
+	Collected int `json:"collected"`
+	Moments   int `json:"moments,omitempty"`
+	// Skipped は未リアクションでも対象から除いた理由 (feedSkipLabels のキー) ごとの件数
+	Skipped map[string]int `json:"skipped,omitempty"`
+}
+
+// feedSkipLabels は feedStats.Skipped のキーと表示名
+var feedSkipLabels = []struct{ key, label string }{
+	{"promoted", "広告・キャンペーン"},
+	{"too_old", "-max-age より古い投稿"},
+	{"excluded_author", "除外する投稿者 (exclude_authors)"},
+	{"author_limit", "同じ投稿者への上限 (MAX_REACTIONS_PER_AUTHOR)"},
+	{"author_cooldown", "同じ投稿者への間隔 (AUTHOR_COOLDOWN_DAYS)"},
+}
+
+func newFeedStats() *feedStats {
+	return &feedStats{ByType: make(map[string]int), Skipped: make(map[string]int)}
+}
+
+// addAuthorSkips は投稿者ごとのルールでスキップした件数を内訳に加える
+func (f *feedStats) addAuthorSkips(l *authorLimiter) {
+	for key, n := range map[string]int{"excluded_author": l.excluded, "author_limit": l.skipped, "author_cooldown": l.cooling} {
+		if n > 0 {
+			f.Skipped[key] += n
+		}
+	}
+}
+
+// log はフィードの内訳をログに出力する
+func (f *feedStats) log(ctx context.Context) {
+	loggerFromContext(ctx).Println(tr("--- 読み込んだフィードの内訳 ---"))
+	for _, row := range sortedCounts(f.ByType, 1) {
+		loggerFromContext(ctx).Printf(tr("%s: %d 件"), row.Key, row.Count)
+	}
+	loggerFromContext(ctx).Printf(tr("活動日記: リアクション済み %d 件 / 未リアクション %d 件"), f.Reacted, f.Unreacted)
+	if f.SeenBefore > 0 {
+		loggerFromContext(ctx).Printf(tr("前回までの実行で確認済みの活動日記: %d 件"), f.SeenBefore)
+	}
+	for _, s := range feedSkipLabels {
+		if n := f.Skipped[s.key]; n > 0 {
+			loggerFromContext(ctx).Printf(tr("スキップ (%s): %d 件"), tr(s.label), n)
+		}
+	}
+	loggerFromContext(ctx).Printf(tr("収集した投稿: %d 件"), f.Collected)
+	if f.Moments > 0 {
+		loggerFromContext(ctx).Printf(tr("うちモーメント: %d 件"), f.Moments)
+	}
+	loggerFromContext(ctx).Println("---------------------------------")
+}
+
+// scrollStrategy は SCROLL_STRATEGY (既定値 bottom) から、遅延読み込みを発生させるためのスクロール方法を返す
+func scrollStrategy(ctx context.Context) string {
+	switch v := os.Getenv("SCROLL_STRATEGY"); v {
+	case "":
+		return "bottom"
+	case "bottom", "step", "keys":
+		return v
+	default:
+		loggerFromContext(ctx).Print(tr("警告: SCROLL_STRATEGYの値が不正です。既定値 bottom を使用します"))
+		return "bottom"
+	}
+}
+
+// maxScrollSteps は step・keys で1回のスクロールに使う最大の段階数
+const maxScrollSteps = 20
+
+// atBottomScript はページの最下部までスクロールしたかを返すスクリプト
+const atBottomScript = `window.innerHeight + window.scrollY >= document.body.scrollHeight - 2`
+
+// scrollDown は SCROLL_STRATEGY に従ってページの最下部までスクロールする。
+// bottom は最下部へ一度に移動し、step は表示領域の高さずつ、keys はPageDownキーの入力で段階的に移動してから最後にEndキーを送る
+func scrollDown(drv pageDriver) browserAction {
+	return func(ctx context.Context) error {
+		strategy := scrollStrategy(ctx)
+		if strategy == "bottom" {
+			return drv.Evaluate(`window.scrollTo(0, document.body.scrollHeight)`, nil)(ctx)
+		}
+		for i := 0; i < maxScrollSteps; i++ {
+			var step browserAction
+			if strategy == "keys" {
+				step = drv.PressKey("PageDown")
+			} else {
+				step = drv.Evaluate(`window.scrollBy(0, Math.floor(window.innerHeight * 0.9))`, nil)
+			}
+			var atBottom bool
+			if err := runActions(ctx, step, sleepAction(300*time.Millisecond), drv.Evaluate(atBottomScript, &atBottom)); err != nil {
+				return err
+			}
+			if atBottom {
+				break
+			}
+		}
+		if strategy == "keys" {
+			return drv.PressKey("End")(ctx)
+		}
+		return nil
+	}
+}
+
+// feedEndConfig はタイムラインのスクロールで終端に達したとみなす条件。設定ファイルの feed_end に書く
+type feedEndConfig struct {
+	// IdleRounds は新しい投稿が読み込まれないスクロールが何回続いたら終端とみなすか。0 の場合は 5
+	IdleRounds int `json:"idle_rounds"`
+	// IgnoreHeight が true の場合は、スクロールの前後でページの高さが変わらないことを新しい投稿が読み込まれない回数に数えない
+	IgnoreHeight bool `json:"ignore_height"`
+	// Selectors と Texts はタイムラインの終端に表示される要素のセレクタと文言。見つかれば回数によらず終端とみなす。
+	// 未指定の場合は defaultFeedEndSelectors・defaultFeedEndTexts を使う
+	Selectors []string `json:"selectors"`
+	Texts     []string `json:"texts"`
+}
+
+// defaultFeedEndSelectors と defaultFeedEndTexts は feed_end.selectors・feed_end.texts が未設定の場合に使う、タイムラインの終端の表示
+var (
+	defaultFeedEndSelectors = []string{`.TimelineList__End`, `[class*="TimelineList__NoMore"]`, `[data-testid="timeline-end"]`}
+	defaultFeedEndTexts     = []string{"これ以上投稿はありません", "すべての投稿を表示しました", "No more posts", "You're all caught up"}
+)
+
+// validate は終端の判定の条件を検証し、問題を add に渡す
+func (c feedEndConfig) validate(add func(key string, err error)) {
+	if c.IdleRounds < 0 {
+		add("feed_end.idle_rounds", fmt.Errorf(tr("feed_end.idle_rounds には1以上の回数を指定してください: %d"), c.IdleRounds))
+	}
+	for _, list := range []struct {
+		key    string
+		values []string
+	}{{"feed_end.selectors", c.Selectors}, {"feed_end.texts", c.Texts}} {
+		for i, v := range list.values {
+			if strings.TrimSpace(v) == "" {
+				add(fmt.Sprintf("%s[%d]", list.key, i), fmt.Errorf(tr("%s[%d] が空です"), list.key, i))
+			}
+		}
+	}
+}
+
+// feedEndDetector はタイムラインの収集のスクロールごとに、終端に達したかを判定する。
+// 終端の表示が見つかった場合と、新しい投稿が読み込まれないスクロールが続いた場合に終端とみなす
+type feedEndDetector struct {
+	idleRounds    int
+	compareHeight bool
+	markerScript  string
+	// idle は新しい投稿が読み込まれなかった (またはページの高さが変わらなかった) 回数、height は前回のページの高さ
+	idle   int
+	height int64
+}
+
+// newFeedEndDetector は設定ファイルの feed_end から feedEndDetector を作成する
+func newFeedEndDetector() *feedEndDetector {
+	c := config.FeedEnd
+	d := &feedEndDetector{idleRounds: c.IdleRounds, compareHeight: !c.IgnoreHeight}
+	if d.idleRounds == 0 {
+		d.idleRounds = 5
+	}
+	selectors, texts := c.Selectors, c.Texts
+	if selectors == nil {
+		selectors = defaultFeedEndSelectors
+	}
+	if texts == nil {
+		texts = defaultFeedEndTexts
+	}
+	encodedSelectors, _ := json.Marshal(selectors)
+	encodedTexts, _ := json.Marshal(texts)
+	// 投稿の本文に同じ文言が含まれていても誤判定しないよう、文言はフィードの外の要素だけから探す
+	d.markerScript = fmt.Sprintf(`(() => {
+		for (const sel of %s) {
+			let el;
+			try { el = document.querySelector(sel); } catch (e) { continue; }
+			if (el && el.getClientRects().length > 0) return sel;
+		}
+		const texts = %s;
+		if (texts.length === 0) return "";
+		for (const el of document.querySelectorAll("main *")) {
+			if (el.children.length > 0 || el.closest(%s)) continue;
+			const text = (el.textContent || "").trim();
+			const found = texts.find(t => text.includes(t));
+			if (found) return found;
+		}
+		return "";
+	})()`, encodedSelectors, encodedTexts, jsString(layout.feed))
+	return d
+}
+
+// observe はスクロール1回分の読み込みの結果を記録し、終端に達した場合はその理由を返す。
+// progressed は今回の読み込みで新しい投稿があったかどうか。ページの高さを取得できない場合はエラーを返す
+func (d *feedEndDetector) observe(ctx context.Context, drv pageDriver, progressed bool) (string, error) {
+	if progressed {
+		d.idle = 0
+	} else {
+		d.idle++
+	}
+	var marker string
+	if err := runActions(ctx, drv.Evaluate(d.markerScript, &marker)); err == nil && marker != "" {
+		return fmt.Sprintf(tr("タイムラインの終端の表示 (%s) が見つかりました"), marker), nil
+	}
+	if d.idle >= d.idleRounds {
+		return fmt.Sprintf(tr("%d回連続で新しい投稿が読み込まれませんでした"), d.idleRounds), nil
+	}
+	var height int64
+	if err := runActions(ctx, drv.Evaluate(`document.body.scrollHeight`, &height)); err != nil {
+		return "", err
+	}
+	if d.compareHeight && height == d.height {
+		loggerFromContext(ctx).Println(tr("ページの高さが変わりませんでした。タイムラインの終端に到達した可能性があります。"))
+		d.idle++
+	}
+	d.height = height
+	return "", nil
+}
+
+// feedCountScript はタイムラインに読み込まれているフィードの件数を返すスクリプト
+const feedCountScript = `(window.__NUXT__ && window.__NUXT__.state && window.__NUXT__.state.timeline && window.__NUXT__.state.timeline.feeds || []).length`
+
+// loadingIndicatorScript は読み込み中の表示 (スピナーなど) が見えているかを返すスクリプト
+const loadingIndicatorScript = `Array.from(document.querySelectorAll('main [class*="Loading"], main [class*="loading"], main [class*="Spinner"], main [class*="spinner"], main [role="progressbar"], main [aria-busy="true"]')).some(el => el.offsetParent !== null)`
+
+// scrollLoadTimeout はスクロール後に続きが読み込まれるのを待つ最大の時間。過ぎた場合は終端に達したものとして戻る
+const scrollLoadTimeout = 10 * time.Second
+
+// scrollForMore はスクロールして、続きが読み込まれるまで待つ。countExpr で数えた件数が増えるか、
+// 読み込み中の表示が現れてから消えた時点で戻るため、固定時間の待機より速く、遅い回線でも待ちすぎない。
+// 戻る前に Hooks の OnScroll (失敗した場合は OnError) を呼ぶ
+func scrollForMore(drv pageDriver, countExpr string) browserAction {
+	return func(ctx context.Context) error {
+		ctx, span := startSpan(ctx, "scroll")
+		loaded, err := waitForMore(ctx, drv, countExpr)
+		span.setAttr("scroll.loaded", loaded)
+		span.finish(err)
+		if err == nil {
+			err = hooksFromContext(ctx).scrolled(ctx, loaded)
+		} else {
+			hooksFromContext(ctx).failed(ctx, "scroll", err)
+		}
+		return err
+	}
+}
+
+// waitForMore は scrollForMore の本体。スクロールして続きが読み込まれるまで待ち、読み込まれている件数を返す
+func waitForMore(ctx context.Context, drv pageDriver, countExpr string) (int, error) {
+	var before int
+	if err := runActions(ctx, drv.Evaluate(countExpr, &before), scrollDown(drv)); err != nil {
+		return 0, err
+	}
+	loaded := before
+	deadline := time.Now().Add(scrollLoadTimeout)
+	sawLoading := false
+	for time.Now().Before(deadline) {
+		var state struct {
+			Count   int  `json:"count"`
+			Loading bool `json:"loading"`
+		}
+		if err := drv.Evaluate(`({count: `+countExpr+`, loading: `+loadingIndicatorScript+`})`, &state)(ctx); err != nil {
+			return loaded, err
+		}
+		loaded = state.Count
+		if state.Count > before {
+			return loaded, nil
+		}
+		if state.Loading {
+			sawLoading = true
+		} else if sawLoading {
+			return loaded, nil
+		}
+		if err := sleepAction(200 * time.Millisecond)(ctx); err != nil {
+			return loaded, err
+		}
+	}
+	return loaded, nil
+}
+
+// maxCollectionRecoveries はタイムラインの収集中にタブのクラッシュから復旧する最大回数
+const maxCollectionRecoveries = 3
+
+// feedMarker はフィードで前回までに確認した最も新しい活動日記のID
+type feedMarker struct {
+	NewestID  int64     `json:"newest_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// feedMarkerStore はフィードごと (timeline, community:<ID>) の feedMarker を FEED_MARKERS_FILE に保存する。
+// 収集でフィードを最後まで確認できた場合だけ stage で新しい目印を控え、リアクションなどの後続の処理を終えてから commit で保存する。
+// そのため、収集した投稿を処理する前に中断した場合は、次の実行でも同じ範囲を確認し直す
+type feedMarkerStore struct {
+	once    sync.Once
+	mu      sync.Mutex
+	path    string
+	markers map[string]feedMarker
+	staged  map[string]int64
+}
+
+// feedMarkers は実行中に共有するフィードの目印。FEED_MARKERS_FILE が未設定の場合は何も記録しない
+var feedMarkers = &feedMarkerStore{}
+
+// load は初めて使うときに FEED_MARKERS_FILE を読み込む。複数アカウントの子プロセスではアカウントごとのファイルを使う
+func (s *feedMarkerStore) load(ctx context.Context) {
+	s.once.Do(func() {
+		s.markers = make(map[string]feedMarker)
+		s.staged = make(map[string]int64)
+		path := os.Getenv("FEED_MARKERS_FILE")
+		if path == "" {
+			return
+		}
+		s.path = accountFilePath(path)
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				loggerFromContext(ctx).Printf(tr("警告: フィードの目印の読み込みに失敗しました: %v"), err)
+			}
+			return
+		}
+		if err := json.Unmarshal(data, &s.markers); err != nil {
+			loggerFromContext(ctx).Printf(tr("警告: フィードの目印を解析できないため、最初から確認します: %v"), err)
+			s.markers = make(map[string]feedMarker)
+		}
+	})
+}
+
+// newest は feed で前回までに確認した最も新しい活動日記のIDを返す。記録がない場合は 0
+func (s *feedMarkerStore) newest(ctx context.Context, feed string) int64 {
+	s.load(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.markers[feed].NewestID
+}
+
+// stage は feed を最後まで確認したときの最も新しい活動日記のIDを、commit まで控えておく
+func (s *feedMarkerStore) stage(ctx context.Context, feed string, id int64) {
+	s.load(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" || id <= s.markers[feed].NewestID {
+		return
+	}
+	s.staged[feed] = id
+}
+
+// commit は控えておいた目印をファイルに書き出す
+func (s *feedMarkerStore) commit(ctx context.Context) {
+	s.load(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" || len(s.staged) == 0 {
+		return
+	}
+	for feed, id := range s.staged {
+		s.markers[feed] = feedMarker{NewestID: id, UpdatedAt: time.Now()}
+	}
+	clear(s.staged)
+	data, err := json.MarshalIndent(s.markers, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data, 0o600)
+	}
+	if err != nil {
+		loggerFromContext(ctx).Printf(tr("警告: フィードの目印の保存に失敗しました: %v"), err)
+	}
+}
@@ -0,0 +1,317 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceSpan はOpenTelemetryのスパン1件。OTEL_EXPORTER_OTLP_ENDPOINT が設定されていない場合は nil で、
+// nil のスパンのメソッドは何もしない
+type traceSpan struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	mu       sync.Mutex
+	attrs    map[string]any
+	err      string
+}
+
+type traceSpanContextKey struct{}
+
+// tracer は終了したスパンを OTLP/HTTP (JSON) で送るエクスポーター。OTEL_EXPORTER_OTLP_ENDPOINT が設定されていない場合は nil
+var tracer *spanExporter
+
+// rootSpan は実行全体のスパン。コンテキストにスパンが紐づいていない場合の親にする
+var rootSpan *traceSpan
+
+// spanExporter は終了したスパンを溜めておき、traceExportInterval ごとと終了時にまとめて送る
+type spanExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	mu       sync.Mutex
+	pending  []*traceSpan
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// traceExportInterval は溜めたスパンを送る間隔
+const traceExportInterval = 5 * time.Second
+
+// newSpanExporterFromEnv はOpenTelemetryの標準の環境変数からエクスポーターを作成する。
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (送信先のURL) または OTEL_EXPORTER_OTLP_ENDPOINT (末尾に /v1/traces を付ける) が
+// どちらも設定されていない場合は nil を返す
+func newSpanExporterFromEnv() (*spanExporter, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+	}
+	if _, err := neturl.ParseRequestURI(endpoint); err != nil {
+		return nil, fmt.Errorf(tr("OTLPの送信先のURLが不正です: %s"), endpoint)
+	}
+	if p := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); p != "" && p != "http/json" {
+		return nil, fmt.Errorf(tr("OTEL_EXPORTER_OTLP_PROTOCOLは http/json のみに対応しています: %s"), p)
+	}
+	headers := make(map[string]string)
+	for _, kv := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if v, err := neturl.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = v
+		}
+		headers[strings.TrimSpace(key)] = value
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "yamap-auto-domo"
+	}
+	e := &spanExporter{endpoint: endpoint, headers: headers, service: service, stop: make(chan struct{}), done: make(chan struct{})}
+	go e.loop()
+	log.Printf(tr("トレースを %s に送信します。"), endpoint)
+	return e, nil
+}
+
+// loop は traceExportInterval ごとに溜めたスパンを送る。長い実行でも途中経過をトレースのバックエンドで確認できるようにする
+func (e *spanExporter) loop() {
+	defer close(e.done)
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			e.flush()
+			return
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+// shutdown は残りのスパンを送って送信を終える。終了前に一度だけ呼ぶ
+func (e *spanExporter) shutdown() {
+	if e == nil {
+		return
+	}
+	close(e.stop)
+	<-e.done
+}
+
+// add は終了したスパンを送信待ちに加える
+func (e *spanExporter) add(s *traceSpan) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending = append(e.pending, s)
+}
+
+// flush は送信待ちのスパンを OTLP/HTTP のJSON形式で送る。失敗しても実行は続け、警告だけを出す
+func (e *spanExporter) flush() {
+	e.mu.Lock()
+	spans := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	encoded := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.otlp())
+	}
+	body, _ := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(map[string]any{
+				"service.name":    e.service,
+				"service.version": currentBuildInfo().Version,
+			})},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "yamap-auto-domo"},
+				"spans": encoded,
+			}},
+		}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Printf(tr("警告: トレースの送信に失敗しました: %v"), err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf(tr("警告: トレースの送信に失敗しました: %v"), err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf(tr("警告: トレースの送信に失敗しました: ステータス %d"), resp.StatusCode)
+	}
+}
+
+// startSpan は ctx に紐づくスパン (なければ実行全体のスパン) を親とするスパンを始め、それを紐づけたコンテキストを返す。
+// attrs はキーと値の組を交互に並べる。トレースを送らない場合はスパンを作らず ctx をそのまま返す
+func startSpan(ctx context.Context, name string, attrs ...any) (context.Context, *traceSpan) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	parent := rootSpan
+	if ctx != nil {
+		if s, ok := ctx.Value(traceSpanContextKey{}).(*traceSpan); ok {
+			parent = s
+		}
+	}
+	s := newTraceSpan(name, parent)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.setAttr(fmt.Sprint(attrs[i]), attrs[i+1])
+	}
+	if ctx == nil {
+		return ctx, s
+	}
+	return context.WithValue(ctx, traceSpanContextKey{}, s), s
+}
+
+// newTraceSpan は parent (nil の場合は新しいトレース) の子のスパンを作る
+func newTraceSpan(name string, parent *traceSpan) *traceSpan {
+	s := &traceSpan{name: name, start: time.Now(), attrs: make(map[string]any)}
+	rand.Read(s.spanID[:])
+	if parent != nil {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	return s
+}
+
+// setAttr はスパンに属性を加える
+func (s *traceSpan) setAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// finish はスパンを終え、送信待ちに加える。err が nil でない場合はスパンを失敗として記録する。
+// スキップは投稿側の理由のため失敗にはせず、理由を属性に残す
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+	var skipErr *skipError
+	if errors.As(err, &skipErr) {
+		s.setAttr("skip.reason", skipErr.reason)
+	} else if err != nil {
+		s.err = err.Error()
+	}
+	s.end = time.Now()
+	tracer.add(s)
+}
+
+// otlp はスパンを OTLP のJSON形式で表す
+func (s *traceSpan) otlp() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := map[string]any{
+		"traceId":           hex.EncodeToString(s.traceID[:]),
+		"spanId":            hex.EncodeToString(s.spanID[:]),
+		"name":              s.name,
+		"kind":              1, // SPAN_KIND_INTERNAL
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.attrs),
+	}
+	if s.parentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	if s.err != "" {
+		span["status"] = map[string]any{"code": 2, "message": s.err} // STATUS_CODE_ERROR
+	}
+	return span
+}
+
+// otlpAttributes は属性を OTLP の KeyValue の形式で、キーの順に並べる
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	out := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		var value map[string]any
+		switch v := attrs[key].(type) {
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": key, "value": value})
+	}
+	return out
+}
+
+// tracingDriver はページの移動をスパンとして記録する pageDriver のラッパー。
+// 移動は操作の中で最も時間がかかりやすく、遅い手順を特定する手がかりになるため
+type tracingDriver struct {
+	pageDriver
+}
+
+// withTracing はトレースを送る場合に、drv をページの移動を記録するドライバでラップする
+func withTracing(drv pageDriver) pageDriver {
+	if tracer == nil {
+		return drv
+	}
+	return tracingDriver{pageDriver: drv}
+}
+
+func (d tracingDriver) Navigate(url string) browserAction {
+	action := d.pageDriver.Navigate(url)
+	return func(ctx context.Context) error {
+		ctx, span := startSpan(ctx, "navigate", "url.full", url)
+		err := action(ctx)
+		span.finish(err)
+		return err
+	}
+}
+
+func (d tracingDriver) Reload() browserAction {
+	action := d.pageDriver.Reload()
+	return func(ctx context.Context) error {
+		ctx, span := startSpan(ctx, "reload")
+		err := action(ctx)
+		span.finish(err)
+		return err
+	}
+}
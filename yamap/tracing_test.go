@@ -0,0 +1,192 @@
+package yamap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// otlpExport は OTLP/HTTP (JSON) の ExportTraceServiceRequest のうち、テストで確かめる部分
+type otlpExport struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []otlpKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []otlpSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes"`
+	Status            *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// otlpAttr は属性 key の値 (stringValue・intValue などを1つ持つオブジェクト) を返す
+func otlpAttr(attrs []otlpKeyValue, key string) map[string]any {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return nil
+}
+
+// TestSpanExporterOTLP はエクスポーターが送った本文をデコードし、IDの16進数の表現・開始と終了の時刻の単位 (ナノ秒の10進数の文字列)・
+// スパンの親子関係・属性と失敗の記録が OTLP/JSON の形式になっていることを確かめる
+func TestSpanExporterOTLP(t *testing.T) {
+	var bodies [][]byte
+	var authHeader string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request = %s %s (%s)", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		authHeader = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+	}))
+	defer srv.Close()
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", srv.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20secret")
+	t.Setenv("OTEL_SERVICE_NAME", "yamap-test")
+
+	origTracer, origRoot := tracer, rootSpan
+	t.Cleanup(func() { tracer, rootSpan = origTracer, origRoot })
+	exporter, err := newSpanExporterFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer = exporter
+
+	before := time.Now()
+	rootSpan = newTraceSpan("run", nil)
+	ctx, collect := startSpan(context.Background(), "collect timeline", "collect.limit", 20)
+	_, navigate := startSpan(ctx, "navigate", "url.full", "https://yamap.com/timeline")
+	time.Sleep(2 * time.Millisecond)
+	navigate.finish(errors.New("net::ERR_TIMED_OUT"))
+	_, react := startSpan(ctx, "react")
+	react.finish(&skipError{reason: "リアクション済み"})
+	collect.setAttr("collect.collected", int64(3))
+	collect.finish(nil)
+	rootSpan.finish(nil)
+	exporter.shutdown()
+	after := time.Now()
+
+	if len(bodies) != 1 {
+		t.Fatalf("received %d exports, want 1", len(bodies))
+	}
+	if authHeader != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the decoded OTEL_EXPORTER_OTLP_HEADERS value", authHeader)
+	}
+	var export otlpExport
+	if err := json.Unmarshal(bodies[0], &export); err != nil {
+		t.Fatalf("decode %s: %v", bodies[0], err)
+	}
+	if len(export.ResourceSpans) != 1 || len(export.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("export = %s", bodies[0])
+	}
+	if v := otlpAttr(export.ResourceSpans[0].Resource.Attributes, "service.name"); v["stringValue"] != "yamap-test" {
+		t.Errorf("service.name = %v", v)
+	}
+	spans := make(map[string]otlpSpan)
+	for _, s := range export.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	if len(spans) != 4 {
+		t.Fatalf("spans = %s, want run, collect timeline, navigate and react", bodies[0])
+	}
+
+	traceIDPattern := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	spanIDPattern := regexp.MustCompile(`^[0-9a-f]{16}$`)
+	run := spans["run"]
+	for name, s := range spans {
+		// IDはbase64ではなく小文字の16進数で、すべて同じトレースに属する
+		if !traceIDPattern.MatchString(s.TraceID) || s.TraceID == "00000000000000000000000000000000" || s.TraceID != run.TraceID {
+			t.Errorf("%s traceId = %q, want the run's 32 hex digits %q", name, s.TraceID, run.TraceID)
+		}
+		if !spanIDPattern.MatchString(s.SpanID) {
+			t.Errorf("%s spanId = %q, want 16 hex digits", name, s.SpanID)
+		}
+		if s.Kind != 1 {
+			t.Errorf("%s kind = %d, want SPAN_KIND_INTERNAL", name, s.Kind)
+		}
+		// 時刻はUnixエポックからのナノ秒を10進数の文字列で表す
+		start, err1 := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+		end, err2 := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+		if err1 != nil || err2 != nil {
+			t.Errorf("%s times = %q, %q; want decimal strings", name, s.StartTimeUnixNano, s.EndTimeUnixNano)
+			continue
+		}
+		if start < before.UnixNano() || end > after.UnixNano() || end < start {
+			t.Errorf("%s times = [%d, %d], want within [%d, %d] in nanoseconds", name, start, end, before.UnixNano(), after.UnixNano())
+		}
+	}
+
+	// 親子関係: run ← collect timeline ← navigate, react
+	if run.ParentSpanID != "" {
+		t.Errorf("run parentSpanId = %q, want none", run.ParentSpanID)
+	}
+	collectSpan := spans["collect timeline"]
+	if collectSpan.ParentSpanID != run.SpanID {
+		t.Errorf("collect timeline parentSpanId = %q, want run %q", collectSpan.ParentSpanID, run.SpanID)
+	}
+	for _, name := range []string{"navigate", "react"} {
+		if spans[name].ParentSpanID != collectSpan.SpanID {
+			t.Errorf("%s parentSpanId = %q, want collect timeline %q", name, spans[name].ParentSpanID, collectSpan.SpanID)
+		}
+	}
+	nav := spans["navigate"]
+	navStart, _ := strconv.ParseInt(nav.StartTimeUnixNano, 10, 64)
+	navEnd, _ := strconv.ParseInt(nav.EndTimeUnixNano, 10, 64)
+	if time.Duration(navEnd-navStart) < 2*time.Millisecond {
+		t.Errorf("navigate lasted %v, want at least the 2ms it slept", time.Duration(navEnd-navStart))
+	}
+
+	// 属性と失敗の記録
+	if v := otlpAttr(collectSpan.Attributes, "collect.limit"); v["intValue"] != "20" {
+		t.Errorf("collect.limit = %v, want intValue \"20\"", v)
+	}
+	if v := otlpAttr(collectSpan.Attributes, "collect.collected"); v["intValue"] != "3" {
+		t.Errorf("collect.collected = %v, want intValue \"3\"", v)
+	}
+	if v := otlpAttr(nav.Attributes, "url.full"); v["stringValue"] != "https://yamap.com/timeline" {
+		t.Errorf("url.full = %v", v)
+	}
+	if nav.Status == nil || nav.Status.Code != 2 || nav.Status.Message != "net::ERR_TIMED_OUT" {
+		t.Errorf("navigate status = %+v, want STATUS_CODE_ERROR with the message", nav.Status)
+	}
+	if s := spans["react"]; s.Status != nil || otlpAttr(s.Attributes, "skip.reason")["stringValue"] != "リアクション済み" {
+		t.Errorf("react = %+v, want a skip reason without an error status", s)
+	}
+	if collectSpan.Status != nil {
+		t.Errorf("collect timeline status = %+v, want none", collectSpan.Status)
+	}
+}
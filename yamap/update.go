@@ -0,0 +1,215 @@
+package yamap
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// defaultUpdateRepository は update でリリースを確認するGitHubのリポジトリ
+const defaultUpdateRepository = "pyororin/yamap-puppeteer-script"
+
+// updatePublicKey はリリースの checksums.txt の署名を検証するEd25519の公開鍵 (Base64)。
+// リリースのワークフロー (.github/workflows/release.yml) が -ldflags "-X yamap-auto-domo/yamap.updatePublicKey=..." で埋め込む。
+// 埋め込まれていないバイナリ (手元でのビルド) では、UPDATE_PUBLIC_KEY を設定しない限り update は更新しない
+var updatePublicKey string
+
+// githubRelease はGitHubのリリースのAPIの応答のうち update で使う項目
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// updateAssetName は実行中のOS・アーキテクチャ向けのバイナリのリリースでのファイル名
+func updateAssetName() string {
+	name := fmt.Sprintf("yamap-auto-domo_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// runUpdate は UPDATE_REPOSITORY (既定値 pyororin/yamap-puppeteer-script) の最新のリリースを確認し、
+// 実行中のバイナリより新しいバージョン (セマンティックバージョニングで比較) であれば、このOS・アーキテクチャ向けのバイナリを
+// ダウンロードして置き換える。古いバージョンへは戻さない。リリースの checksums.txt.sig の署名を埋め込んだ公開鍵
+// (UPDATE_PUBLIC_KEY で置き換え可) で必ず検証してから、checksums.txt のSHA-256でバイナリを検証する
+func runUpdate() error {
+	repo := os.Getenv("UPDATE_REPOSITORY")
+	if repo == "" {
+		repo = defaultUpdateRepository
+	}
+	encodedKey := updatePublicKey
+	if v := os.Getenv("UPDATE_PUBLIC_KEY"); v != "" {
+		encodedKey = v
+	}
+	if encodedKey == "" {
+		return errors.New(tr("このバイナリには署名を検証する公開鍵が埋め込まれていないため、更新できません。リリースのバイナリを使うか、UPDATE_PUBLIC_KEY を設定してください"))
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New(tr("UPDATE_PUBLIC_KEYの値が不正です (Base64でエンコードしたEd25519の公開鍵を指定してください)"))
+	}
+	publicKey := ed25519.PublicKey(key)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	var release githubRelease
+	data, err := fetchUpdateFile(ctx, "https://api.github.com/repos/"+repo+"/releases/latest")
+	if err != nil {
+		return fmt.Errorf(tr("リリースの確認に失敗しました: %w"), err)
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return fmt.Errorf(tr("リリースの確認に失敗しました: %w"), err)
+	}
+	if !semver.IsValid(release.TagName) {
+		return fmt.Errorf(tr("リリースのタグ %s がバージョン (v1.2.3 の形式) ではないため、更新できません"), release.TagName)
+	}
+	// 開発中のビルドは、直前のタグより新しい擬似バージョン (v1.2.4-0.20261016000000-abcdef123456 など) として比較する
+	current := currentBuildInfo().Version
+	if !semver.IsValid(current) {
+		return fmt.Errorf(tr("実行中のバイナリのバージョン %s が分からないため、更新しません。リリースのバイナリを使ってください"), current)
+	}
+	switch c := semver.Compare(release.TagName, current); {
+	case c == 0:
+		loggerFromContext(ctx).Printf(tr("最新のバージョン %s を実行しています。"), current)
+		return nil
+	case c < 0:
+		loggerFromContext(ctx).Printf(tr("実行中のバージョン %s は最新のリリース %s より新しいため、更新しません。"), current, release.TagName)
+		return nil
+	}
+	loggerFromContext(ctx).Printf(tr("新しいリリース %s があります (実行中: %s)。"), release.TagName, current)
+
+	assets := make(map[string]string)
+	for _, a := range release.Assets {
+		assets[a.Name] = a.URL
+	}
+	name := updateAssetName()
+	if assets[name] == "" {
+		return fmt.Errorf(tr("リリース %s にこのOS・アーキテクチャ向けのバイナリ %s がありません"), release.TagName, name)
+	}
+	if assets["checksums.txt"] == "" {
+		return fmt.Errorf(tr("リリース %s に checksums.txt がないため、検証できません"), release.TagName)
+	}
+	checksums, err := fetchUpdateFile(ctx, assets["checksums.txt"])
+	if err != nil {
+		return err
+	}
+	if assets["checksums.txt.sig"] == "" {
+		return fmt.Errorf(tr("リリース %s に checksums.txt.sig がないため、署名を検証できません"), release.TagName)
+	}
+	sig, err := fetchUpdateFile(ctx, assets["checksums.txt.sig"])
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+	if !ed25519.Verify(publicKey, checksums, sig) {
+		return errors.New(tr("checksums.txt の署名が一致しません"))
+	}
+	loggerFromContext(ctx).Println(tr("checksums.txt の署名を検証しました。"))
+	var want string
+	for _, line := range strings.Split(string(checksums), "\n") {
+		// sha256sum の形式 ("<16進数>  <ファイル名>"、バイナリモードではファイル名の前に "*")
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			want = strings.ToLower(fields[0])
+		}
+	}
+	if want == "" {
+		return fmt.Errorf(tr("checksums.txt に %s のチェックサムがありません"), name)
+	}
+
+	loggerFromContext(ctx).Printf(tr("%s をダウンロードしています..."), name)
+	binary, err := fetchUpdateFile(ctx, assets[name])
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf(tr("%s のチェックサムが一致しません (期待値 %s, 実際 %s)"), name, want, got)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		return fmt.Errorf(tr("%s の置き換えに失敗しました: %w"), exe, err)
+	}
+	loggerFromContext(ctx).Printf(tr("%s を %s に更新しました。"), exe, release.TagName)
+	return nil
+}
+
+// fetchUpdateFile はリリースの情報やファイルをダウンロードする
+func fetchUpdateFile(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "yamap-auto-domo")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf(tr("%s の取得に失敗しました: ステータス %d"), url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// replaceExecutable は exe と同じディレクトリに書き出した新しいバイナリで exe を置き換える。
+// 実行中のファイルを上書きできないWindowsでも置き換えられるよう、元のファイルは .old に移してから入れ替える
+func replaceExecutable(exe string, binary []byte) error {
+	info, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".yamap-auto-domo-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0o111); err != nil {
+		return err
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	// Windowsでは実行中のファイルを削除できないため、.old は次回の更新で削除する
+	os.Remove(old)
+	return nil
+}
@@ -0,0 +1,222 @@
+package yamap
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gpxPath と uploadTitle は activity-upload でアップロードするGPXファイルのパスと活動日記のタイトル
+var (
+	gpxPath     string
+	uploadTitle string
+)
+
+// gpxFile はGPXファイルのうち、activity-upload で説明文に使うトラックの名前と記録点
+type gpxFile struct {
+	Metadata struct {
+		Name string `xml:"name"`
+	} `xml:"metadata"`
+	Tracks []struct {
+		Name     string `xml:"name"`
+		Segments []struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// gpxPoint はトラックの記録点
+type gpxPoint struct {
+	Lat  float64   `xml:"lat,attr"`
+	Lon  float64   `xml:"lon,attr"`
+	Ele  *float64  `xml:"ele"`
+	Time time.Time `xml:"time"`
+}
+
+// uploadData は activity_upload_description のテンプレートで参照できる値。GPXファイルの記録から求める
+type uploadData struct {
+	Title string
+	// Date は最初の記録点の日付 (2006-01-02)。時刻のないGPXファイルでは空
+	Date string
+	// Distance は移動距離 (km)、Elevation は累積標高 (上り, m)
+	Distance  float64
+	Elevation float64
+	// Duration は最初から最後の記録点までの時間
+	Duration time.Duration
+}
+
+// summarizeGPX はGPXファイルを解析し、テンプレートで参照できる値を求める。タイトルはトラックの名前 (なければメタデータの名前)
+func summarizeGPX(data []byte) (uploadData, error) {
+	var f gpxFile
+	if err := xml.Unmarshal(data, &f); err != nil {
+		return uploadData{}, err
+	}
+	var points []gpxPoint
+	var d uploadData
+	for _, trk := range f.Tracks {
+		if d.Title == "" {
+			d.Title = trk.Name
+		}
+		for _, seg := range trk.Segments {
+			points = append(points, seg.Points...)
+		}
+	}
+	if d.Title == "" {
+		d.Title = f.Metadata.Name
+	}
+	if len(points) == 0 {
+		return uploadData{}, errors.New(tr("GPXファイルにトラックの記録点がありません"))
+	}
+	for i := 1; i < len(points); i++ {
+		prev, p := points[i-1], points[i]
+		d.Distance += greatCircleKm(prev.Lat, prev.Lon, p.Lat, p.Lon)
+		if prev.Ele != nil && p.Ele != nil && *p.Ele > *prev.Ele {
+			d.Elevation += *p.Ele - *prev.Ele
+		}
+	}
+	first, last := points[0].Time, points[len(points)-1].Time
+	if !first.IsZero() {
+		d.Date = first.Local().Format("2006-01-02")
+		if last.After(first) {
+			d.Duration = last.Sub(first)
+		}
+	}
+	return d, nil
+}
+
+// greatCircleKm は2点間の大円距離 (km) を求める
+func greatCircleKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371.0
+	rad := math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// 活動日記の作成フォームの項目のラベル
+const (
+	uploadTitleLabel       = "タイトル"
+	uploadDescriptionLabel = "本文"
+)
+
+// uploadGPXInputSelector は活動日記の作成ページのGPXファイルの入力欄
+const uploadGPXInputSelector = `input[type="file"][accept*="gpx"], main input[type="file"]`
+
+// saveDraftScript は活動日記の作成フォームの下書き保存のボタンをクリックするスクリプト。
+// 誤って公開しないよう、下書きのボタンが見つからない場合は他のボタンを押さずに false を返す
+const saveDraftScript = `(() => {
+	const button = Array.from(document.querySelectorAll('main button')).find(b => /下書き/.test(b.textContent));
+	if (!button || button.disabled) return false;
+	button.click();
+	return true;
+})()`
+
+// runActivityUpload は -gpx のGPXファイルで活動日記の作成の手順を進め、タイトルと本文を入力して下書きとして保存する。
+// 公開はしないため、内容を確認してから手動で公開する
+func runActivityUpload() error {
+	log.Println(tr("--- プログラム開始 (activity-upload) ---"))
+	startTime := time.Now()
+	if gpxPath == "" {
+		return errors.New(tr("activity-upload では -gpx にGPXファイルのパスを指定してください"))
+	}
+	data, err := os.ReadFile(gpxPath)
+	if err != nil {
+		return fmt.Errorf(tr("GPXファイルの読み込みに失敗しました: %w"), err)
+	}
+	summary, err := summarizeGPX(data)
+	if err != nil {
+		return fmt.Errorf(tr("%s の形式が不正です: %w"), gpxPath, err)
+	}
+	if uploadTitle != "" {
+		summary.Title = uploadTitle
+	}
+	if summary.Title == "" {
+		summary.Title = strings.TrimSuffix(filepath.Base(gpxPath), filepath.Ext(gpxPath))
+	}
+	var description string
+	if config.activityUploadDescription != nil {
+		var buf bytes.Buffer
+		if err := config.activityUploadDescription.Execute(&buf, summary); err != nil {
+			return fmt.Errorf(tr("本文のテンプレートの展開に失敗しました: %w"), err)
+		}
+		description = strings.TrimSpace(buf.String())
+	}
+	uploadURL := os.Getenv("ACTIVITY_UPLOAD_URL")
+	if uploadURL == "" {
+		uploadURL = "https://yamap.com/activities/new"
+	}
+	log.Printf(tr("活動日記の下書きを作成します: %s (%.1fkm, 累積標高 %.0fm)"), summary.Title, summary.Distance, summary.Elevation)
+
+	ctx, closeBrowser, err := openLoggedInBrowser(false)
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	status.setPhase("reacting")
+	drv := driverFromContext(ctx)
+
+	err = fillUploadForm(ctx, drv, uploadURL, filepath.Base(gpxPath), data, summary.Title, description)
+	if err == nil {
+		var saved bool
+		if err = runActions(ctx, drv.Evaluate(saveDraftScript, &saved)); err == nil && !saved {
+			err = errors.New(tr("下書き保存のボタンが見つかりません"))
+		}
+	}
+	var url string
+	if err == nil {
+		err = runActions(ctx, sleepAction(3*time.Second), drv.WaitNetworkIdle(), drv.Evaluate(`location.href`, &url))
+	}
+	status.recordResult(err == nil, err)
+	if err != nil {
+		saveDebugSnapshot(ctx, drv, "activity_upload")
+		return fmt.Errorf(tr("活動日記の下書きの作成に失敗しました: %w"), err)
+	}
+	status.markStep()
+	loggerFromContext(ctx).Printf(tr("活動日記を下書きとして保存しました。内容を確認してから公開してください: %s"), url)
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// fillUploadForm は活動日記の作成ページを開いてGPXファイルを設定し、読み込まれた後に表示されるフォームにタイトルと本文を入力する
+func fillUploadForm(ctx context.Context, drv pageDriver, uploadURL, name string, gpx []byte, title, description string) error {
+	var ok bool
+	if err := runActions(ctx,
+		drv.Navigate(uploadURL),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(setFileInputScript(uploadGPXInputSelector, name, "application/gpx+xml", gpx), &ok),
+	); err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(tr("GPXファイルの入力欄が見つかりません"))
+	}
+	// GPXファイルの読み込みが終わるとタイトルの入力欄が表示されるため、入力できるまで繰り返す
+	if err := runActions(ctx,
+		drv.Poll(planFieldScript(uploadTitleLabel, title), 60*time.Second),
+		drv.WaitNetworkIdle(),
+	); err != nil {
+		return fmt.Errorf(tr("GPXファイルの読み込みが終わりませんでした: %w"), err)
+	}
+	if description != "" {
+		var found bool
+		if err := runActions(ctx, drv.Evaluate(planFieldScript(uploadDescriptionLabel, description), &found)); err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf(tr("フォームの項目が見つかりません: %s"), uploadDescriptionLabel)
+		}
+	}
+	return runActions(ctx, sleepAction(time.Second))
+}
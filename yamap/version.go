@@ -0,0 +1,129 @@
+package yamap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// buildDate はビルド日時。-ldflags "-X yamap-auto-domo/yamap.buildDate=2026-10-16T09:00:00Z" で埋め込む。空の場合はVCSのコミット日時を使う
+var buildDate string
+
+// buildInfo はバイナリに埋め込まれたビルド情報と、起動したブラウザのバージョン。
+// version で表示するほか、現場で起きた問題を調べられるよう実行の記録とクラッシュレポートの先頭に含める
+type buildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Browser は今回の実行で起動したブラウザの製品名とバージョン (例: HeadlessChrome/139.0.7258.66)。起動していない場合は空
+	Browser string `json:"browser,omitempty"`
+}
+
+// currentBuildInfo は runtime/debug から読み取ったビルド情報を返す
+func currentBuildInfo() buildInfo {
+	b := buildInfo{Version: "(devel)", BuildDate: buildDate, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if info.Main.Version != "" {
+		b.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			b.Revision = s.Value
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		case "vcs.time":
+			if b.BuildDate == "" {
+				b.BuildDate = s.Value
+			}
+		}
+	}
+	return b
+}
+
+// text はビルド情報を "項目: 値" の行で返す
+func (b buildInfo) text() string {
+	revision := b.Revision
+	if revision == "" {
+		revision = "unknown"
+	} else if b.Modified {
+		revision += " (modified)"
+	}
+	browser := b.Browser
+	if browser == "" {
+		browser = "unknown"
+	}
+	buildDate := b.BuildDate
+	if buildDate == "" {
+		buildDate = "unknown"
+	}
+	return fmt.Sprintf("version: %s\nrevision: %s\nbuild_date: %s\ngo: %s\nbrowser: %s\n", b.Version, revision, buildDate, b.GoVersion, browser)
+}
+
+// runVersion はビルド情報と、-browser のブラウザを起動して検出したバージョンを標準出力に表示する。
+// ブラウザを起動できない場合もビルド情報は表示し、ブラウザの欄にはエラーを表示する
+func runVersion() error {
+	b := currentBuildInfo()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, cancelBrowser, err := startBrowser(ctx)
+	if err != nil {
+		b.Browser = fmt.Sprintf(tr("検出できません (%v)"), err)
+	} else {
+		cancelBrowser()
+		b.Browser = status.browserVersion()
+	}
+	fmt.Print(b.text())
+	return nil
+}
+
+// printDependencies は go.mod ファイルを解析し、直接の依存関係を標準出力に表示します。
+func printDependencies() {
+	file, err := os.Open("go.mod")
+	if err != nil {
+		log.Printf(tr("go.modファイルの読み込みに失敗しました: %v"), err)
+		return
+	}
+	defer file.Close()
+
+	log.Println(tr("\n--- このプログラムの実行に必要だったライブラリ一覧 ---"))
+	scanner := bufio.NewScanner(file)
+	inRequireBlock := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "require (" {
+			inRequireBlock = true
+			continue
+		}
+		if line == ")" {
+			inRequireBlock = false
+			continue
+		}
+		// requireブロック内にあり、コメントではない、かつ空行でもない行を処理
+		if inRequireBlock && !strings.HasPrefix(line, "//") && line != "" {
+			parts := strings.Fields(line)
+			if len(parts) >= 2 {
+				// 間接的な依存関係 "// indirect" を含まないもののみ出力
+				if !strings.HasSuffix(line, "// indirect") {
+					log.Printf("- %s %s", parts[0], parts[1])
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf(tr("go.modファイルのスキャン中にエラーが発生しました: %v"), err)
+	}
+	log.Println("----------------------------------------------------")
+}
@@ -0,0 +1,240 @@
+package yamap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// watchConfig は watch で新しい活動日記を確認する山・ランドマークと、対象にする活動日記の条件
+type watchConfig struct {
+	// Mountains と Landmarks は活動日記の一覧を確認する山とランドマークのID
+	Mountains []int64 `json:"mountains"`
+	Landmarks []int64 `json:"landmarks"`
+	// Interval は一覧を確認する間隔 (例: 30m)。空の場合は 30分
+	Interval string `json:"interval"`
+	// Keywords・MinDistanceKm・MinElevationM は emoji_rules と同じ条件で、すべてを満たす活動日記だけを対象にする
+	Keywords      []string `json:"keywords"`
+	MinDistanceKm float64  `json:"min_distance_km"`
+	MinElevationM float64  `json:"min_elevation_m"`
+	// React が true の場合は対象の活動日記にリアクションする。false の場合は通知だけを送る
+	React bool `json:"react"`
+
+	interval time.Duration
+}
+
+// watchSource は watch で活動日記の一覧を確認するページ
+type watchSource struct {
+	// key は履歴に記録する識別子 (例: mountains/123)
+	key string
+	url string
+}
+
+// sources は確認する山・ランドマークの活動日記の一覧のページを返す
+func (c watchConfig) sources() []watchSource {
+	var sources []watchSource
+	for _, list := range []struct {
+		kind string
+		ids  []int64
+	}{{"mountains", c.Mountains}, {"landmarks", c.Landmarks}} {
+		for _, id := range list.ids {
+			key := fmt.Sprintf("%s/%d", list.kind, id)
+			sources = append(sources, watchSource{key: key, url: "https://yamap.com/" + key + "/activities"})
+		}
+	}
+	return sources
+}
+
+// matches は活動日記が watch の条件を満たすかを返す
+func (c watchConfig) matches(meta activityMetadata) bool {
+	return emojiRule{Keywords: c.Keywords, MinDistanceKm: c.MinDistanceKm, MinElevationM: c.MinElevationM}.matches(meta)
+}
+
+// runWatch は設定ファイルの watch の山・ランドマークの活動日記の一覧を watch.interval ごとに確認し、
+// 条件に合う新しい活動日記にリアクションするか通知を送る。停止の指示か -max-runtime の経過まで繰り返す
+func runWatch() error {
+	log.Println(tr("--- プログラム開始 (watch) ---"))
+	startTime := time.Now()
+	if history == nil {
+		return errors.New(tr("watch では確認済みの活動日記を記録するために HISTORY_FILE を設定してください"))
+	}
+	cfg := config.Watch
+	sources := cfg.sources()
+	if len(sources) == 0 {
+		return errors.New(tr("watch では設定ファイルの watch に mountains か landmarks を指定してください"))
+	}
+	interval := cfg.interval
+	if interval == 0 {
+		interval = 30 * time.Minute
+	}
+	if noLogin && cfg.React {
+		return errors.New(tr("watch.react でリアクションする場合は -no-login を使えません"))
+	}
+
+	ctx, closeBrowser, err := openPublicBrowser()
+	if err != nil {
+		return err
+	}
+	defer closeBrowser()
+	sess := sessionFromContext(ctx)
+
+	for round := 1; ; round++ {
+		status.setPhase("collecting")
+		loggerFromContext(ctx).Printf(tr("--- %d回目の確認 (%d件の山・ランドマーク) ---"), round, len(sources))
+		// 確認の間だけ履歴をロックし、待機中は同じ履歴を使う他のアクションが実行できるようにする
+		err := withHistoryLock("watch", func() error {
+			recycleTabIfNeeded(ctx)
+			var matched []ActivityInfo
+			for _, src := range sources {
+				if ctx.Err() != nil {
+					break
+				}
+				found, err := watchSourceActivities(ctx, src, sess.UserID, cfg)
+				if err != nil {
+					loggerFromContext(ctx).Printf(tr("活動日記の一覧の確認に失敗しました (%s): %v"), src.url, err)
+					continue
+				}
+				matched = append(matched, found...)
+			}
+			loggerFromContext(ctx).Printf(tr("条件に合う新しい活動日記: %d件"), len(matched))
+
+			if len(matched) > 0 {
+				status.setPhase("reacting")
+				if cfg.React {
+					// reactToActivities は投稿の一覧ごとに集計をやり直すため、前回までの確認の件数を加え直す
+					before := status.report()
+					reactToActivities(ctx, matched)
+					status.restoreResults(before.Succeeded, before.Failed, before.Skipped)
+				} else {
+					for _, a := range matched {
+						loggerFromContext(ctx).Printf(tr("新しい活動日記: %s (%s) %s"), a.Title, a.AuthorName, a.URL)
+						notify(ctx, "INFO", fmt.Sprintf(tr("新しい活動日記: %s (%s) %s"), a.Title, a.AuthorName, a.URL))
+						status.recordResult(true, nil)
+					}
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, errHistoryLocked) {
+			loggerFromContext(ctx).Printf(tr("履歴を別のプロセスが使用中のため、今回の確認を見送ります: %v"), err)
+		} else if err != nil {
+			return err
+		}
+
+		if waitForKillSwitch(ctx) == killSwitchStop || maxRuntimeReached() || ctx.Err() != nil {
+			break
+		}
+		status.setPhase("waiting")
+		loggerFromContext(ctx).Printf(tr("次の確認まで %s 待機します。"), interval)
+		if !watchWait(ctx, interval) {
+			break
+		}
+	}
+
+	status.setPhase("done")
+	sdNotify("STOPPING=1")
+	loggerFromContext(ctx).Print(tr("--- 全ての処理が正常に完了しました ---"))
+	loggerFromContext(ctx).Printf(tr("総処理時間: %s"), time.Since(startTime))
+	return nil
+}
+
+// watchSourceActivities は活動日記の一覧のページを開き、まだ確認していない活動日記のうち条件に合うものを古い順に返す。
+// 初めて確認するページの場合は、過去の活動日記にまとめて反応しないよう、表示されている活動日記を確認済みとして記録するだけにする
+func watchSourceActivities(ctx context.Context, src watchSource, myID int64, cfg watchConfig) ([]ActivityInfo, error) {
+	drv := driverFromContext(ctx)
+	var entries []struct {
+		Href     string `json:"href"`
+		UserHref string `json:"user"`
+	}
+	if err := runActions(ctx,
+		drv.Navigate(src.url),
+		drv.WaitVisible(`main`),
+		drv.WaitNetworkIdle(),
+		drv.Evaluate(communityEntriesScript, &entries),
+	); err != nil {
+		return nil, err
+	}
+	status.markStep()
+	var ids []int64
+	for _, e := range entries {
+		if id, err := strconv.ParseInt(strings.TrimPrefix(e.Href, "/activities/"), 10, 64); err == nil && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if !history.isWatchSource(src.key) {
+		if err := history.markWatchSeen(src.key, ids, time.Now()); err != nil {
+			return nil, fmt.Errorf(tr("確認済みの活動日記の保存に失敗しました: %w"), err)
+		}
+		loggerFromContext(ctx).Printf(tr("初めて確認するため、現在の活動日記 %d件を確認済みとして記録しました: %s"), len(ids), src.url)
+		return nil, nil
+	}
+
+	authors := newAuthorLimiter(ctx)
+	var found []ActivityInfo
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		if history.isWatchSeen(id) || ctx.Err() != nil {
+			continue
+		}
+		url := fmt.Sprintf("https://yamap.com/activities/%d", id)
+		if err := runActions(ctx, drv.Navigate(url), drv.WaitVisible(layout.footer), drv.WaitNetworkIdle()); err != nil {
+			loggerFromContext(ctx).Printf(tr("活動日記の確認に失敗しました。次回の確認で再試行します (%s): %v"), url, err)
+			continue
+		}
+		meta, err := fetchActivityMetadata(ctx, drv)
+		if err != nil {
+			loggerFromContext(ctx).Printf(tr("活動日記の確認に失敗しました。次回の確認で再試行します (%s): %v"), url, err)
+			continue
+		}
+		if err := history.markWatchSeen(src.key, []int64{id}, time.Now()); err != nil {
+			return found, fmt.Errorf(tr("確認済みの活動日記の保存に失敗しました: %w"), err)
+		}
+		status.markStep()
+		switch {
+		case meta.AuthorID != 0 && meta.AuthorID == myID:
+			continue
+		case !cfg.matches(meta):
+			loggerFromContext(ctx).Printf(tr("条件に合わないためスキップします: %s"), url)
+			continue
+		case cfg.React && history.hasReacted(url):
+			loggerFromContext(ctx).Printf(tr("履歴でリアクション済みのためスキップします: %s"), url)
+			continue
+		}
+		if reason := authors.allow(User{ID: meta.AuthorID, Name: meta.Author}); reason != "" {
+			loggerFromContext(ctx).Printf(tr("ユーザー (ID: %d) の投稿をスキップします (%s): %s"), meta.AuthorID, reason, url)
+			continue
+		}
+		found = append(found, ActivityInfo{URL: url, AuthorID: meta.AuthorID, AuthorName: meta.Author, Title: meta.Title, PostedAt: meta.postedAt()})
+	}
+	return found, nil
+}
+
+// watchWait は次の確認まで待つ。-max-runtime の経過が先の場合はそこまで待つ。停止の指示があった場合は false を返す
+func watchWait(ctx context.Context, d time.Duration) bool {
+	if maxRuntime > 0 {
+		d = min(d, maxRuntime-time.Since(status.startedAt))
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	// 待機中もヘルスチェックが停滞とみなさないよう、1分ごとに前進を記録する
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			status.markStep()
+			if waitForKillSwitch(ctx) == killSwitchStop {
+				return false
+			}
+		case <-timer.C:
+			return true
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package yamap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// webDashboardDays はWebダッシュボードで日別のリアクション数を表示する日数
+const webDashboardDays = 30
+
+// webDashboardMaxRows はWebダッシュボードの実行一覧・リアクション一覧に表示する最大件数
+const webDashboardMaxRows = 100
+
+// webDashboardView はWebダッシュボードのテンプレートに渡す集計結果
+type webDashboardView struct {
+	GeneratedAt    string
+	HistoryPath    string
+	TotalReactions int
+	UniqueAuthors  int
+	TotalRuns      int
+	FailureRate    string
+	Days           []webDashboardDay
+	Runs           []webDashboardRun
+	Reactions      []historyEntry
+	// Screenshots は AUDIT_SCREENSHOT_DIR が設定され、監査用のスクリーンショットを表示できるか
+	Screenshots bool
+}
+
+type webDashboardDay struct {
+	Date  string
+	Count int
+	// Percent は表示期間内で最も多い日を100とした割合 (棒グラフの幅)
+	Percent int
+}
+
+type webDashboardRun struct {
+	runRecord
+	Duration    string
+	FailureRate string
+}
+
+// failureRate は失敗の割合を表示用の文字列にする。処理した投稿がない場合は "-"
+func failureRate(failed, processed int) string {
+	if processed == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(failed)*100/float64(processed))
+}
+
+// buildWebDashboardView は履歴から過去の実行・日別のリアクション数・失敗率・リアクションした投稿の一覧を集計する
+func buildWebDashboardView(h *historyStore, now time.Time) webDashboardView {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	v := webDashboardView{
+		GeneratedAt:    now.Local().Format("2006-01-02 15:04:05"),
+		HistoryPath:    h.backend.String(),
+		TotalReactions: len(h.Entries),
+		TotalRuns:      len(h.Runs),
+		Screenshots:    os.Getenv("AUDIT_SCREENSHOT_DIR") != "",
+	}
+
+	authors := make(map[int64]struct{})
+	perDay := make(map[string]int)
+	for _, e := range h.Entries {
+		if e.AuthorID != 0 {
+			authors[e.AuthorID] = struct{}{}
+		}
+		perDay[e.ReactedAt.Local().Format("2006-01-02")]++
+	}
+	v.UniqueAuthors = len(authors)
+
+	maxCount := 0
+	for i := 0; i < webDashboardDays; i++ {
+		date := now.Local().AddDate(0, 0, -i).Format("2006-01-02")
+		v.Days = append(v.Days, webDashboardDay{Date: date, Count: perDay[date]})
+		maxCount = max(maxCount, perDay[date])
+	}
+	for i := range v.Days {
+		if maxCount > 0 {
+			v.Days[i].Percent = v.Days[i].Count * 100 / maxCount
+		}
+	}
+
+	var processed, failed int
+	for i := len(h.Runs) - 1; i >= 0; i-- {
+		r := h.Runs[i]
+		processed += r.Processed
+		failed += r.Failed
+		if len(v.Runs) < webDashboardMaxRows {
+			v.Runs = append(v.Runs, webDashboardRun{
+				runRecord:   r,
+				Duration:    r.FinishedAt.Sub(r.StartedAt).Round(time.Second).String(),
+				FailureRate: failureRate(r.Failed, r.Processed),
+			})
+		}
+	}
+	v.FailureRate = failureRate(failed, processed)
+
+	for i := len(h.Entries) - 1; i >= 0 && len(v.Reactions) < webDashboardMaxRows; i-- {
+		v.Reactions = append(v.Reactions, h.Entries[i])
+	}
+	return v
+}
+
+// webDashboardTemplate はWebダッシュボードのHTML
+var webDashboardTemplate = htmltemplate.Must(htmltemplate.New("dashboard").Funcs(htmltemplate.FuncMap{
+	"datetime": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	"base":     filepath.Base,
+}).Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>yamap-auto-domo ダッシュボード</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border-bottom: 1px solid #ddd; padding: 4px 10px; text-align: left; }
+td.num { text-align: right; }
+.bar { background: #4caf50; height: 10px; }
+.summary span { display: inline-block; margin-right: 2em; font-size: 1.2em; }
+img.shot { width: 240px; border: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>yamap-auto-domo ダッシュボード</h1>
+<p>{{.HistoryPath}} ({{.GeneratedAt}} 時点)</p>
+<p class="summary">
+<span>リアクション: {{.TotalReactions}}件</span>
+<span>投稿者: {{.UniqueAuthors}}人</span>
+<span>実行: {{.TotalRuns}}回</span>
+<span>失敗率: {{.FailureRate}}</span>
+</p>
+
+<h2>日別のリアクション数 (直近{{len .Days}}日)</h2>
+<table>
+{{range .Days}}<tr><td>{{.Date}}</td><td class="num">{{.Count}}</td><td style="width: 300px"><div class="bar" style="width: {{.Percent}}%"></div></td></tr>
+{{end}}</table>
+
+<h2>過去の実行</h2>
+<table>
+<tr><th>開始</th><th>アクション</th><th>所要時間</th><th>処理</th><th>成功</th><th>失敗</th><th>スキップ</th><th>失敗率</th><th>中止理由</th></tr>
+{{range .Runs}}<tr><td>{{datetime .StartedAt}}</td><td>{{.Action}}</td><td>{{.Duration}}</td><td class="num">{{.Processed}}</td><td class="num">{{.Succeeded}}</td><td class="num">{{.Failed}}</td><td class="num">{{.Skipped}}</td><td class="num">{{.FailureRate}}</td><td>{{.Aborted}}</td></tr>
+{{else}}<tr><td colspan="9">まだ実行の記録がありません</td></tr>
+{{end}}</table>
+
+<h2>リアクションした投稿</h2>
+<table>
+<tr><th>日時</th><th>投稿</th><th>投稿者</th>{{if .Screenshots}}<th>スクリーンショット</th>{{end}}</tr>
+{{range .Reactions}}<tr><td>{{datetime .ReactedAt}}</td><td><a href="{{.URL}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></td><td>{{if .AuthorID}}<a href="https://yamap.com/users/{{.AuthorID}}">{{if .AuthorName}}{{.AuthorName}}{{else}}{{.AuthorID}}{{end}}</a>{{end}}</td>{{if $.Screenshots}}<td>{{if .Screenshot}}<a href="/screenshots/{{base .Screenshot}}"><img class="shot" src="/screenshots/{{base .Screenshot}}" loading="lazy" alt=""></a>{{end}}</td>{{end}}</tr>
+{{else}}<tr><td colspan="4">まだリアクションの記録がありません</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// runWebDashboard は HISTORY_FILE (または HISTORY_DATABASE_URL) の履歴を表示する読み取り専用のWebダッシュボードを DASHBOARD_ADDR (既定値 127.0.0.1:8090) で提供する。
+// 別のプロセスが実行中に書き込んだ内容も反映されるよう、リクエストのたびに履歴を読み込み直す。
+func runWebDashboard() error {
+	backend, err := historyBackendFromEnv()
+	if err != nil {
+		return err
+	}
+	if backend == nil {
+		return errors.New(tr("dashboard では表示する履歴として HISTORY_FILE か HISTORY_DATABASE_URL を設定してください"))
+	}
+	addr := os.Getenv("DASHBOARD_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:8090"
+	}
+
+	mux := http.NewServeMux()
+	if dir := os.Getenv("AUDIT_SCREENSHOT_DIR"); dir != "" {
+		mux.Handle("/screenshots/", http.StripPrefix("/screenshots/", http.FileServer(http.Dir(dir))))
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		h, err := loadHistory(backend)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(tr("履歴の読み込みに失敗しました: %v"), err), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		if err := webDashboardTemplate.Execute(&buf, buildWebDashboardView(h, time.Now())); err != nil {
+			http.Error(w, fmt.Sprintf(tr("ダッシュボードの作成に失敗しました: %v"), err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(buf.Bytes())
+	})
+
+	log.Printf(tr("ダッシュボードを http://%s/ で公開します (終了するには Ctrl+C)"), addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		return fmt.Errorf(tr("ダッシュボードのサーバーが停止しました: %w"), err)
+	}
+	return nil
+}